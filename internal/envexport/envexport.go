@@ -0,0 +1,273 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package envexport writes resolved environment variables in the formats
+// understood by common CI systems and deployment tools, and reads .env files.
+package envexport
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Format is an output format.
+type Format string
+
+const (
+	// FormatDotenv writes NAME=VALUE lines, quoting values as needed.
+	FormatDotenv Format = "dotenv"
+
+	// FormatGitHubActions writes ::add-mask:: workflow commands and
+	// $GITHUB_ENV entries.
+	FormatGitHubActions Format = "gha"
+
+	// FormatKubernetes writes a Kubernetes Secret manifest.
+	FormatKubernetes Format = "k8s"
+)
+
+// Formats is the list of supported formats.
+var Formats = []Format{FormatDotenv, FormatGitHubActions, FormatKubernetes}
+
+// nameRe matches valid environment variable names.
+var nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Var is a single environment variable.
+type Var struct {
+	Name  string
+	Value string
+}
+
+// Parse parses "NAME=VALUE" pairs. It returns an error if a pair has no "=", a
+// name is not a valid environment variable name, or a name is repeated.
+func Parse(pairs []string) ([]*Var, error) {
+	seen := make(map[string]struct{}, len(pairs))
+	vars := make([]*Var, 0, len(pairs))
+	for _, p := range pairs {
+		name, value, ok := strings.Cut(p, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid pair %q: expected NAME=VALUE", p)
+		}
+		if !nameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid name %q: must contain only letters, "+
+				"digits, and underscores, and not start with a digit", name)
+		}
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("duplicate name %q", name)
+		}
+		seen[name] = struct{}{}
+		vars = append(vars, &Var{Name: name, Value: value})
+	}
+	return vars, nil
+}
+
+// Sort sorts the variables by name, so the output of the writers does not
+// depend on the order the variables were given in. Names are compared
+// byte-wise, so uppercase names sort before lowercase ones.
+func Sort(vars []*Var) {
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].Name < vars[j].Name
+	})
+}
+
+// dotenvSafeRe matches values that do not need to be quoted.
+var dotenvSafeRe = regexp.MustCompile(`^[A-Za-z0-9_./:@+,=-]*$`)
+
+// dotenvReplacer escapes values inside double quotes.
+var dotenvReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+// WriteDotenv writes the variables as NAME=VALUE lines. Values with characters
+// other than letters, digits, and common punctuation are double-quoted, with
+// backslashes, double quotes, and newlines escaped.
+func WriteDotenv(w io.Writer, vars []*Var) error {
+	for _, v := range vars {
+		value := v.Value
+		if !dotenvSafeRe.MatchString(value) {
+			value = `"` + dotenvReplacer.Replace(value) + `"`
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", v.Name, value); err != nil {
+			return fmt.Errorf("failed to write %s: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// dotenvUnescaper reverses dotenvReplacer.
+var dotenvUnescaper = strings.NewReplacer(
+	`\\`, `\`,
+	`\"`, `"`,
+	`\n`, "\n",
+	`\r`, "\r",
+)
+
+// ReadDotenv reads NAME=VALUE lines in the format written by WriteDotenv.
+// Blank lines and lines starting with "#" are skipped, and an "export " prefix
+// is ignored. Values may be double-quoted, with the escapes WriteDotenv uses,
+// or single-quoted, which are taken literally. Unquoted values end at " #",
+// which starts a comment. Names are validated as with Parse.
+func ReadDotenv(r io.Reader) ([]*Var, error) {
+	var pairs []string
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected NAME=VALUE", n)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+
+		switch {
+		case len(value) >= 2 && value[0] == '"':
+			end := closingQuote(value)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated double quote", n)
+			}
+			value = dotenvUnescaper.Replace(value[1:end])
+		case len(value) >= 1 && value[0] == '\'':
+			end := strings.IndexByte(value[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single quote", n)
+			}
+			value = value[1 : end+1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+
+		pairs = append(pairs, name+"="+value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read: %w", err)
+	}
+	return Parse(pairs)
+}
+
+// closingQuote returns the index of the double quote that closes the string
+// starting at s[0], skipping escaped quotes, or -1 if there is none.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// commandReplacer escapes data in GitHub Actions workflow commands.
+var commandReplacer = strings.NewReplacer(
+	"%", "%25",
+	"\r", "%0D",
+	"\n", "%0A",
+)
+
+// WriteGitHubActions writes an ::add-mask:: workflow command for each value to
+// masks, and the variables in $GITHUB_ENV syntax to env. Each line of a
+// multi-line value is masked separately, since GitHub masks line by line. Every
+// variable is written with a random heredoc delimiter so values may contain
+// newlines.
+func WriteGitHubActions(masks, env io.Writer, vars []*Var) error {
+	for _, v := range vars {
+		for _, line := range strings.Split(v.Value, "\n") {
+			line = strings.TrimSuffix(line, "\r")
+			if line == "" {
+				continue
+			}
+			if _, err := fmt.Fprintf(masks, "::add-mask::%s\n", commandReplacer.Replace(line)); err != nil {
+				return fmt.Errorf("failed to write mask for %s: %w", v.Name, err)
+			}
+		}
+	}
+
+	for _, v := range vars {
+		delim, err := delimiter(v.Value)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(env, "%s<<%s\n%s\n%s\n", v.Name, delim, v.Value, delim); err != nil {
+			return fmt.Errorf("failed to write %s: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// delimiter returns a random heredoc delimiter that does not appear in value.
+func delimiter(value string) (string, error) {
+	for {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("failed to generate delimiter: %w", err)
+		}
+		delim := "ghadelimiter_" + hex.EncodeToString(b)
+		if !strings.Contains(value, delim) {
+			return delim, nil
+		}
+	}
+}
+
+// WriteKubernetesSecret writes the variables as the data of an Opaque
+// Kubernetes Secret manifest in YAML, with each value base64-encoded. The
+// namespace is omitted if empty.
+func WriteKubernetesSecret(w io.Writer, name, namespace string, vars []*Var) error {
+	var b strings.Builder
+	b.WriteString("apiVersion: v1\n")
+	b.WriteString("kind: Secret\n")
+	b.WriteString("metadata:\n")
+	fmt.Fprintf(&b, "  name: %s\n", yamlString(name))
+	if namespace != "" {
+		fmt.Fprintf(&b, "  namespace: %s\n", yamlString(namespace))
+	}
+	b.WriteString("type: Opaque\n")
+	if len(vars) == 0 {
+		b.WriteString("data: {}\n")
+	} else {
+		b.WriteString("data:\n")
+		for _, v := range vars {
+			fmt.Fprintf(&b, "  %s: %s\n", v.Name,
+				yamlString(base64.StdEncoding.EncodeToString([]byte(v.Value))))
+		}
+	}
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// yamlString quotes s as a YAML scalar. JSON strings are valid YAML.
+func yamlString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -0,0 +1,245 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envexport
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		pairs []string
+		exp   []*Var
+		err   string
+	}{
+		{
+			name:  "valid",
+			pairs: []string{"API_KEY=sm://p/api-key", "_X=a=b", "EMPTY="},
+			exp: []*Var{
+				{Name: "API_KEY", Value: "sm://p/api-key"},
+				{Name: "_X", Value: "a=b"},
+				{Name: "EMPTY", Value: ""},
+			},
+		},
+		{
+			name:  "missing_equals",
+			pairs: []string{"API_KEY"},
+			err:   "expected NAME=VALUE",
+		},
+		{
+			name:  "invalid_name",
+			pairs: []string{"1KEY=x"},
+			err:   "invalid name",
+		},
+		{
+			name:  "duplicate",
+			pairs: []string{"A=x", "A=y"},
+			err:   "duplicate name",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vars, err := Parse(tc.pairs)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected %v to contain %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(vars) != len(tc.exp) {
+				t.Fatalf("expected %d vars, got %d", len(tc.exp), len(vars))
+			}
+			for i, v := range vars {
+				if *v != *tc.exp[i] {
+					t.Errorf("expected %#v to be %#v", v, tc.exp[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSort(t *testing.T) {
+	t.Parallel()
+
+	vars := []*Var{
+		{Name: "b", Value: "1"},
+		{Name: "A", Value: "2"},
+		{Name: "_C", Value: "3"},
+		{Name: "B", Value: "4"},
+	}
+	Sort(vars)
+
+	var act []string
+	for _, v := range vars {
+		act = append(act, v.Name)
+	}
+	if exp := []string{"A", "B", "_C", "b"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
+
+func TestWriteDotenv(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	if err := WriteDotenv(&b, []*Var{
+		{Name: "PLAIN", Value: "abc-123"},
+		{Name: "SPACES", Value: "a b"},
+		{Name: "ESCAPED", Value: "say \"hi\"\\\nbye"},
+		{Name: "EMPTY", Value: ""},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `PLAIN=abc-123
+SPACES="a b"
+ESCAPED="say \"hi\"\\\nbye"
+EMPTY=
+`
+	if act := b.String(); act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
+
+func TestReadDotenv(t *testing.T) {
+	t.Parallel()
+
+	t.Run("round_trip", func(t *testing.T) {
+		t.Parallel()
+
+		exp := []*Var{
+			{Name: "PLAIN", Value: "abc-123"},
+			{Name: "SPACES", Value: "a b"},
+			{Name: "ESCAPED", Value: "say \"hi\"\\\nbye"},
+			{Name: "EMPTY", Value: ""},
+		}
+
+		var b bytes.Buffer
+		if err := WriteDotenv(&b, exp); err != nil {
+			t.Fatal(err)
+		}
+
+		act, err := ReadDotenv(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(act, exp) {
+			t.Errorf("expected %#v to be %#v", act, exp)
+		}
+	})
+
+	t.Run("syntax", func(t *testing.T) {
+		t.Parallel()
+
+		in := strings.Join([]string{
+			"# comment",
+			"",
+			"export API_KEY=sm://p/api-key#3 # pinned",
+			"SINGLE='a \\n #b'",
+			`DOUBLE="x" # trailing`,
+		}, "\n")
+
+		act, err := ReadDotenv(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := []*Var{
+			{Name: "API_KEY", Value: "sm://p/api-key#3"},
+			{Name: "SINGLE", Value: `a \n #b`},
+			{Name: "DOUBLE", Value: "x"},
+		}
+		if !reflect.DeepEqual(act, exp) {
+			t.Errorf("expected %#v to be %#v", act, exp)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		t.Parallel()
+
+		for _, in := range []string{"NOVALUE", `A="open`, "A='open", "1A=x", "A=x\nA=y"} {
+			if _, err := ReadDotenv(strings.NewReader(in)); err == nil {
+				t.Errorf("expected error for %q", in)
+			}
+		}
+	})
+}
+
+func TestWriteGitHubActions(t *testing.T) {
+	t.Parallel()
+
+	var masks, env bytes.Buffer
+	if err := WriteGitHubActions(&masks, &env, []*Var{
+		{Name: "TOKEN", Value: "abc%"},
+		{Name: "CERT", Value: "line1\nline2\n"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expMasks := "::add-mask::abc%25\n::add-mask::line1\n::add-mask::line2\n"
+	if act := masks.String(); act != expMasks {
+		t.Errorf("expected %q to be %q", act, expMasks)
+	}
+
+	re := regexp.MustCompile(`^TOKEN<<(ghadelimiter_[0-9a-f]+)\nabc%\n(ghadelimiter_[0-9a-f]+)\n` +
+		`CERT<<(ghadelimiter_[0-9a-f]+)\nline1\nline2\n\n(ghadelimiter_[0-9a-f]+)\n$`)
+	m := re.FindStringSubmatch(env.String())
+	if m == nil {
+		t.Fatalf("unexpected env output %q", env.String())
+	}
+	if m[1] != m[2] || m[3] != m[4] {
+		t.Errorf("expected matching delimiters in %q", env.String())
+	}
+}
+
+func TestWriteKubernetesSecret(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	if err := WriteKubernetesSecret(&b, "app-secrets", "prod", []*Var{
+		{Name: "API_KEY", Value: "abc"},
+		{Name: "EMPTY", Value: ""},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `apiVersion: v1
+kind: Secret
+metadata:
+  name: "app-secrets"
+  namespace: "prod"
+type: Opaque
+data:
+  API_KEY: "YWJj"
+  EMPTY: ""
+`
+	if act := b.String(); act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
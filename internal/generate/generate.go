@@ -0,0 +1,245 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package generate creates key material, such as RSA keys, SSH keypairs, and
+// self-signed certificates, to be stored as secrets.
+package generate
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// TypeRSA generates a PKCS #8 RSA private key and its PKIX public key. The
+	// size may be given as "rsa:BITS" and defaults to DefaultRSABits.
+	TypeRSA = "rsa"
+
+	// TypeSSHEd25519 generates an OpenSSH Ed25519 private key and its public
+	// key in authorized_keys format.
+	TypeSSHEd25519 = "ssh-ed25519"
+
+	// TypeSelfSignedCert generates a PKCS #8 ECDSA P-256 private key and a
+	// self-signed certificate for it.
+	TypeSelfSignedCert = "self-signed-cert"
+)
+
+const (
+	// DefaultRSABits is the size of RSA keys when none is given.
+	DefaultRSABits = 4096
+
+	// MinRSABits is the smallest RSA key size that may be generated.
+	MinRSABits = 2048
+
+	// DefaultCertTTL is how long self-signed certificates are valid for when no
+	// TTL is given.
+	DefaultCertTTL = 90 * 24 * time.Hour
+)
+
+// Types returns the names of the supported types, for help text and errors.
+func Types() []string {
+	return []string{TypeRSA + ":BITS", TypeSSHEd25519, TypeSelfSignedCert}
+}
+
+// Options are settings for generating material. Not every type uses every
+// option.
+type Options struct {
+	// CommonName is the subject common name of a self-signed certificate. It is
+	// also added as a DNS name. Required for TypeSelfSignedCert.
+	CommonName string
+
+	// TTL is how long a self-signed certificate is valid for. It defaults to
+	// DefaultCertTTL.
+	TTL time.Duration
+
+	// Comment is the comment on an SSH key.
+	Comment string
+
+	// Rand is the source of randomness. It defaults to crypto/rand.Reader.
+	Rand io.Reader
+
+	// Now is the time a certificate is valid from. It defaults to the current
+	// time.
+	Now time.Time
+}
+
+// Material is generated key material.
+type Material struct {
+	// Private is the private half, PEM-encoded, to be stored as a secret.
+	Private []byte
+
+	// Public is the public half: a PEM public key or certificate, or an SSH
+	// authorized_keys line. It is not secret.
+	Public []byte
+}
+
+// Generate creates material of the given type, such as "rsa:4096",
+// "ssh-ed25519", or "self-signed-cert".
+func Generate(typ string, opts *Options) (*Material, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	r := opts.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+
+	name, arg, _ := strings.Cut(typ, ":")
+	switch name {
+	case TypeRSA:
+		bits := DefaultRSABits
+		if arg != "" {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < MinRSABits {
+				return nil, fmt.Errorf("invalid RSA key size %q: must be at least %d bits", arg, MinRSABits)
+			}
+			bits = n
+		}
+		return generateRSA(r, bits)
+	case TypeSSHEd25519:
+		if arg != "" {
+			return nil, fmt.Errorf("type %s does not take a parameter", name)
+		}
+		return generateSSHEd25519(r, opts.Comment)
+	case TypeSelfSignedCert:
+		if arg != "" {
+			return nil, fmt.Errorf("type %s does not take a parameter", name)
+		}
+		return generateSelfSignedCert(r, opts)
+	default:
+		return nil, fmt.Errorf("unknown type %q: must be one of %s", typ, strings.Join(Types(), ", "))
+	}
+}
+
+func generateRSA(r io.Reader, bits int) (*Material, error) {
+	key, err := rsa.GenerateKey(r, bits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+	}
+
+	private, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	return &Material{
+		Private: private,
+		Public:  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+	}, nil
+}
+
+func generateSSHEd25519(r io.Reader, comment string) (*Material, error) {
+	pub, key, err := ed25519.GenerateKey(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, comment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	public := ssh.MarshalAuthorizedKey(sshPub)
+	if comment != "" {
+		public = append(public[:len(public)-1], []byte(" "+comment+"\n")...)
+	}
+
+	return &Material{
+		Private: pem.EncodeToMemory(block),
+		Public:  public,
+	}, nil
+}
+
+func generateSelfSignedCert(r io.Reader, opts *Options) (*Material, error) {
+	if opts.CommonName == "" {
+		return nil, fmt.Errorf("type %s requires a common name", TypeSelfSignedCert)
+	}
+	ttl := opts.TTL
+	if ttl == 0 {
+		ttl = DefaultCertTTL
+	}
+	if ttl < 0 {
+		return nil, fmt.Errorf("invalid TTL %s: must be positive", ttl)
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ECDSA key: %w", err)
+	}
+
+	serial, err := rand.Int(r, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: opts.CommonName},
+		DNSNames:              []string{opts.CommonName},
+		NotBefore:             now.Add(-5 * time.Minute).UTC(),
+		NotAfter:              now.Add(ttl).UTC(),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(r, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	private, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Material{
+		Private: private,
+		Public:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}, nil
+}
+
+// encodePrivateKey returns the key as a PKCS #8 PEM block.
+func encodePrivateKey(key any) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
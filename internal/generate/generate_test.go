@@ -0,0 +1,178 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generate
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerate_Errors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		typ  string
+		opts *Options
+	}{
+		{"unknown", "dsa", nil},
+		{"rsa_small", "rsa:1024", nil},
+		{"rsa_invalid", "rsa:big", nil},
+		{"ssh_param", "ssh-ed25519:256", nil},
+		{"cert_no_cn", "self-signed-cert", nil},
+		{"cert_negative_ttl", "self-signed-cert", &Options{CommonName: "foo", TTL: -time.Hour}},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := Generate(tc.typ, tc.opts); err == nil {
+				t.Errorf("expected error for %q", tc.typ)
+			}
+		})
+	}
+}
+
+func TestGenerate_RSA(t *testing.T) {
+	t.Parallel()
+
+	m, err := Generate("rsa:2048", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(decodePEM(t, m.Private, "PRIVATE KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("expected %T to be *rsa.PrivateKey", key)
+	}
+	if got, want := rsaKey.N.BitLen(), 2048; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(decodePEM(t, m.Public, "PUBLIC KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsaKey.PublicKey.Equal(pub) {
+		t.Errorf("expected public key to match private key")
+	}
+}
+
+func TestGenerate_SSHEd25519(t *testing.T) {
+	t.Parallel()
+
+	m, err := Generate(TypeSSHEd25519, &Options{Comment: "deploy-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.ParseRawPrivateKey(m.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, ok := key.(*ed25519.PrivateKey)
+	if !ok {
+		t.Fatalf("expected %T to be *ed25519.PrivateKey", key)
+	}
+
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey(m.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := comment, "deploy-key"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	sshPub, err := ssh.NewPublicKey(edKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pub.Marshal(), sshPub.Marshal()) {
+		t.Errorf("expected public key to match private key")
+	}
+}
+
+func TestGenerate_SelfSignedCert(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	m, err := Generate(TypeSelfSignedCert, &Options{
+		CommonName: "internal.example.com",
+		TTL:        30 * 24 * time.Hour,
+		Now:        now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(decodePEM(t, m.Private, "PRIVATE KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		t.Fatalf("expected %T to be *ecdsa.PrivateKey", key)
+	}
+
+	cert, err := x509.ParseCertificate(decodePEM(t, m.Public, "CERTIFICATE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cert.Subject.CommonName, "internal.example.com"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if err := cert.VerifyHostname("internal.example.com"); err != nil {
+		t.Error(err)
+	}
+	if got, want := cert.NotAfter, now.Add(30*24*time.Hour); !got.Equal(want) {
+		t.Errorf("expected %s to be %s", got, want)
+	}
+	if !ecKey.PublicKey.Equal(cert.PublicKey) {
+		t.Errorf("expected certificate to be for the private key")
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Errorf("expected certificate to be self-signed: %v", err)
+	}
+}
+
+func decodePEM(tb testing.TB, b []byte, typ string) []byte {
+	tb.Helper()
+
+	block, rest := pem.Decode(b)
+	if block == nil {
+		tb.Fatalf("expected PEM block in %q", b)
+	}
+	if block.Type != typ {
+		tb.Fatalf("expected %q to be %q", block.Type, typ)
+	}
+	if len(rest) > 0 {
+		tb.Fatalf("expected no trailing data, got %q", rest)
+	}
+	return block.Bytes
+}
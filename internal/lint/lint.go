@@ -0,0 +1,105 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lint finds and validates berglas references in configuration files,
+// such as Kubernetes manifests and .env files.
+package lint
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas"
+)
+
+// referenceRegexp matches anything that looks like a berglas or Secret Manager
+// reference, including fallback chains. Quotes and whitespace end a reference.
+var referenceRegexp = regexp.MustCompile("(?:" +
+	regexp.QuoteMeta(berglas.ReferencePrefixSecretManager) + "|" +
+	regexp.QuoteMeta(berglas.ReferencePrefixStorage) + ")[^\\s\"'`]*")
+
+// Finding is a reference found in a file.
+type Finding struct {
+	// File is the name of the file the reference was found in.
+	File string
+
+	// Line and Column are the 1-indexed position of the reference.
+	Line, Column int
+
+	// Text is the reference as it appears in the file.
+	Text string
+
+	// References are the parsed references, in fallback order. It is nil if the
+	// reference is invalid.
+	References []*berglas.Reference
+
+	// Err is the reason the reference is invalid, if any.
+	Err error
+}
+
+// String returns the finding in the format "FILE:LINE:COLUMN: TEXT", followed
+// by the error if there is one.
+func (f *Finding) String() string {
+	s := fmt.Sprintf("%s:%d:%d: %s", f.File, f.Line, f.Column, f.Text)
+	if f.Err != nil {
+		s += ": " + f.Err.Error()
+	}
+	return s
+}
+
+// Find returns every reference in r, which is read as text. The name is used
+// as the File of each finding. Lines that are comments in YAML, shell, or .env
+// syntax are skipped.
+func Find(r io.Reader, name string) ([]*Finding, error) {
+	var findings []*Finding
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(text), "#") {
+			continue
+		}
+
+		for _, loc := range referenceRegexp.FindAllStringIndex(text, -1) {
+			s := strings.TrimRight(text[loc[0]:loc[1]], ",;)]}")
+
+			f := &Finding{
+				File:   name,
+				Line:   line,
+				Column: loc[0] + 1,
+				Text:   s,
+			}
+
+			refs, err := berglas.ParseReferences(s)
+			if err != nil {
+				f.Err = err
+			} else {
+				f.References = refs
+			}
+			findings = append(findings, f)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return findings, nil
+}
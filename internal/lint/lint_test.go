@@ -0,0 +1,93 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		input string
+		exp   []string
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "yaml",
+			input: "env:\n- name: API_KEY\n  value: \"sm://my-project/api-key\"\n",
+			exp:   []string{"f:3:11: sm://my-project/api-key"},
+		},
+		{
+			name:  "env",
+			input: "A=berglas://my-bucket/a#12\nB=sm://my-project/b?destination=tempfile\n",
+			exp: []string{
+				"f:1:3: berglas://my-bucket/a#12",
+				"f:2:3: sm://my-project/b?destination=tempfile",
+			},
+		},
+		{
+			name:  "comment",
+			input: "# A=sm://my-project/a\n",
+		},
+		{
+			name:  "fallback",
+			input: "A='sm://p/a|berglas://b/a'\n",
+			exp:   []string{"f:1:4: sm://p/a|berglas://b/a"},
+		},
+		{
+			name:  "trailing_punctuation",
+			input: `{"a": ["sm://p/a"], "b": sm://p/b}`,
+			exp: []string{
+				"f:1:9: sm://p/a",
+				"f:1:26: sm://p/b",
+			},
+		},
+		{
+			name:  "invalid",
+			input: "A=sm://my-project\n",
+			exp:   []string{"f:1:3: sm://my-project: invalid secret format \"my-project\""},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			findings, err := Find(strings.NewReader(tc.input), "f")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var act []string
+			for _, f := range findings {
+				act = append(act, f.String())
+			}
+
+			if !reflect.DeepEqual(act, tc.exp) {
+				t.Errorf("expected %q to be %q", act, tc.exp)
+			}
+		})
+	}
+}
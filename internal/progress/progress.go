@@ -0,0 +1,148 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package progress renders progress bars for long-running CLI operations.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas"
+	"golang.org/x/term"
+)
+
+// renderInterval is the minimum time between renders, to avoid flooding the
+// terminal on fast operations.
+const renderInterval = 100 * time.Millisecond
+
+// barWidth is the number of characters in the bar itself.
+const barWidth = 30
+
+// Bar is a terminal progress bar. It satisfies berglas.Progress and is safe for
+// concurrent use.
+type Bar struct {
+	w     io.Writer
+	label string
+	now   func() time.Time
+
+	mu         sync.Mutex
+	total      int
+	current    int
+	started    time.Time
+	lastRender time.Time
+}
+
+// New creates a new progress bar that renders to w, prefixed with label.
+func New(w io.Writer, label string) *Bar {
+	return &Bar{
+		w:     w,
+		label: label,
+		now:   time.Now,
+	}
+}
+
+// NewTerminal creates a new progress bar like New if w is a terminal. Otherwise
+// it returns berglas.DiscardProgress, so redirected output is not filled with
+// partial lines.
+func NewTerminal(w io.Writer, label string) berglas.Progress {
+	f, ok := w.(interface{ Fd() uintptr })
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return berglas.DiscardProgress
+	}
+	return New(w, label)
+}
+
+// Start resets the bar with the given total. A total of -1 means the total is
+// unknown, in which case only the count and rate are rendered.
+func (b *Bar) Start(total int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.total = total
+	b.current = 0
+	b.started = b.now()
+	b.lastRender = time.Time{}
+	b.render(false)
+}
+
+// Increment advances the bar by n items.
+func (b *Bar) Increment(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.current += n
+	b.render(false)
+}
+
+// Finish renders the final state and moves to a new line.
+func (b *Bar) Finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.render(true)
+	fmt.Fprintf(b.w, "\n")
+}
+
+// render writes the bar, unless it was rendered too recently. Callers must
+// hold the lock.
+func (b *Bar) render(force bool) {
+	now := b.now()
+	if !force && !b.lastRender.IsZero() && now.Sub(b.lastRender) < renderInterval {
+		return
+	}
+	b.lastRender = now
+
+	fmt.Fprintf(b.w, "\r%s", b.line(now))
+}
+
+// line builds the bar text at the given time. Callers must hold the lock.
+func (b *Bar) line(now time.Time) string {
+	elapsed := now.Sub(b.started)
+
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(b.current) / elapsed.Seconds()
+	}
+
+	if b.total < 0 {
+		return fmt.Sprintf("%s %d items %.1f/s", b.label, b.current, rate)
+	}
+
+	pct := 100.0
+	if b.total > 0 {
+		pct = 100 * float64(b.current) / float64(b.total)
+	}
+
+	filled := barWidth
+	if b.total > 0 {
+		filled = barWidth * b.current / b.total
+	}
+	if filled > barWidth {
+		filled = barWidth
+	}
+
+	eta := "--"
+	if remaining := b.total - b.current; rate > 0 && remaining >= 0 {
+		eta = time.Duration(float64(remaining) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("%s [%s%s] %3.0f%% %d/%d %.1f/s ETA %s",
+		b.label,
+		strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+		pct, b.current, b.total, rate, eta)
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package progress
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas"
+)
+
+// testBar returns a bar that renders to b with a clock advanced by advance.
+func testBar(b *bytes.Buffer, label string) (*Bar, func(time.Duration)) {
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	bar := New(b, label)
+	bar.now = func() time.Time { return now }
+	return bar, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestBar(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	bar, advance := testBar(&b, "Deleting")
+
+	bar.Start(4)
+	if act, exp := b.String(), "\rDeleting [                              ]   0% 0/4 0.0/s ETA --"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	b.Reset()
+	advance(time.Second)
+	bar.Increment(2)
+	if act, exp := b.String(), "\rDeleting [===============               ]  50% 2/4 2.0/s ETA 1s"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	// Updates within the render interval are not rendered.
+	b.Reset()
+	advance(time.Millisecond)
+	bar.Increment(1)
+	if act := b.String(); act != "" {
+		t.Errorf("expected no render, got %q", act)
+	}
+
+	// Finish always renders and ends the line.
+	b.Reset()
+	bar.Increment(1)
+	bar.Finish()
+	if act := b.String(); !strings.HasPrefix(act, "\rDeleting [==============================] 100% 4/4") ||
+		!strings.HasSuffix(act, "\n") {
+		t.Errorf("expected final render, got %q", act)
+	}
+}
+
+func TestBar_unknownTotal(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	bar, advance := testBar(&b, "Exporting")
+
+	bar.Start(-1)
+	b.Reset()
+	advance(2 * time.Second)
+	bar.Increment(5)
+	if act, exp := b.String(), "\rExporting 5 items 2.5/s"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
+
+func TestNewTerminal(t *testing.T) {
+	t.Parallel()
+
+	if p := NewTerminal(&bytes.Buffer{}, "label"); p != berglas.DiscardProgress {
+		t.Errorf("expected buffer to discard progress, got %T", p)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p := NewTerminal(f, "label")
+	if p != berglas.DiscardProgress {
+		t.Errorf("expected file to discard progress, got %T", p)
+	}
+	p.Start(1)
+	p.Increment(1)
+	p.Finish()
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("expected nothing to be written, got %d bytes", info.Size())
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package report builds an inventory of secrets with an estimated monthly
+// cost.
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bytesPerGiB is the number of bytes in the unit Cloud Storage bills by.
+const bytesPerGiB = 1 << 30
+
+// Type is the storage backend of the secrets in a report.
+type Type string
+
+const (
+	TypeSecretManager Type = "secret_manager"
+	TypeStorage       Type = "storage"
+)
+
+// Pricing are the unit prices, in USD, used to estimate monthly cost. Prices
+// vary by region and change over time, so they can be loaded from a file.
+type Pricing struct {
+	// SecretManagerVersion is the monthly price of each active (enabled or
+	// disabled) Secret Manager secret version, per replica location.
+	SecretManagerVersion float64 `json:"secret_manager_version"`
+
+	// StorageGiB is the monthly price of each GiB stored in Cloud Storage,
+	// including noncurrent generations.
+	StorageGiB float64 `json:"storage_gib"`
+
+	// KMSKeyVersion is the monthly price of each Cloud KMS key version. Each key
+	// used by the secrets is counted as a single active version.
+	KMSKeyVersion float64 `json:"kms_key_version"`
+}
+
+// DefaultPricing returns the list prices for Secret Manager, Cloud Storage
+// Standard in a single region, and Cloud KMS software keys.
+func DefaultPricing() Pricing {
+	return Pricing{
+		SecretManagerVersion: 0.06,
+		StorageGiB:           0.02,
+		KMSKeyVersion:        0.06,
+	}
+}
+
+// LoadPricing reads pricing as JSON. Fields that are not set keep their
+// default prices.
+func LoadPricing(r io.Reader) (Pricing, error) {
+	pricing := DefaultPricing()
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&pricing); err != nil {
+		return Pricing{}, fmt.Errorf("failed to parse pricing: %w", err)
+	}
+
+	if pricing.SecretManagerVersion < 0 || pricing.StorageGiB < 0 || pricing.KMSKeyVersion < 0 {
+		return Pricing{}, fmt.Errorf("prices must not be negative")
+	}
+	return pricing, nil
+}
+
+// Secret is the inventory of a single secret.
+type Secret struct {
+	Name string `json:"name"`
+
+	// Versions is the number of Secret Manager versions or Cloud Storage
+	// generations, and ActiveVersions is the number that are billed (those that
+	// are not destroyed).
+	Versions       int `json:"versions"`
+	ActiveVersions int `json:"active_versions"`
+
+	// SizeBytes is the total size of all versions, if known.
+	SizeBytes int64 `json:"size_bytes"`
+
+	// Locations are the replica locations of a Secret Manager secret, or empty
+	// if it is automatically replicated.
+	Locations []string `json:"locations,omitempty"`
+
+	// KMSKey is the Cloud KMS key of a Cloud Storage secret.
+	KMSKey string `json:"kms_key,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// MonthlyCost is the estimated monthly cost of the secret, excluding its
+	// KMS key, which may be shared.
+	MonthlyCost float64 `json:"estimated_monthly_cost"`
+}
+
+// Report is an inventory of secrets with totals.
+type Report struct {
+	Type    Type      `json:"type"`
+	Source  string    `json:"source"`
+	Pricing Pricing   `json:"pricing"`
+	Secrets []*Secret `json:"secrets"`
+
+	Count          int      `json:"count"`
+	Versions       int      `json:"versions"`
+	ActiveVersions int      `json:"active_versions"`
+	SizeBytes      int64    `json:"size_bytes"`
+	KMSKeys        []string `json:"kms_keys,omitempty"`
+
+	// MonthlyCost is the estimated monthly cost of all secrets and the KMS keys
+	// they use.
+	MonthlyCost float64 `json:"estimated_monthly_cost"`
+}
+
+// New builds a report of the given secrets, sorted by name, estimating the
+// cost of each secret and the totals with the given pricing.
+func New(typ Type, source string, secrets []*Secret, pricing Pricing) *Report {
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+
+	r := &Report{
+		Type:    typ,
+		Source:  source,
+		Pricing: pricing,
+		Secrets: secrets,
+		Count:   len(secrets),
+	}
+
+	for _, s := range secrets {
+		switch typ {
+		case TypeSecretManager:
+			replicas := max(len(s.Locations), 1)
+			s.MonthlyCost = float64(s.ActiveVersions*replicas) * pricing.SecretManagerVersion
+		case TypeStorage:
+			s.MonthlyCost = float64(s.SizeBytes) / bytesPerGiB * pricing.StorageGiB
+		}
+
+		r.Versions += s.Versions
+		r.ActiveVersions += s.ActiveVersions
+		r.SizeBytes += s.SizeBytes
+		r.MonthlyCost += s.MonthlyCost
+		if s.KMSKey != "" && !slices.Contains(r.KMSKeys, s.KMSKey) {
+			r.KMSKeys = append(r.KMSKeys, s.KMSKey)
+		}
+	}
+	sort.Strings(r.KMSKeys)
+	r.MonthlyCost += float64(len(r.KMSKeys)) * pricing.KMSKeyVersion
+
+	return r
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// WriteCSV writes one row per secret, followed by a row named "TOTAL" with
+// the totals. Multiple locations are separated by spaces.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	rows := [][]string{{
+		"name", "versions", "active_versions", "size_bytes", "locations",
+		"kms_key", "updated_at", "estimated_monthly_cost",
+	}}
+	for _, s := range r.Secrets {
+		rows = append(rows, []string{
+			s.Name,
+			strconv.Itoa(s.Versions),
+			strconv.Itoa(s.ActiveVersions),
+			strconv.FormatInt(s.SizeBytes, 10),
+			strings.Join(s.Locations, " "),
+			s.KMSKey,
+			s.UpdatedAt.UTC().Format(time.RFC3339),
+			formatCost(s.MonthlyCost),
+		})
+	}
+	rows = append(rows, []string{
+		"TOTAL",
+		strconv.Itoa(r.Versions),
+		strconv.Itoa(r.ActiveVersions),
+		strconv.FormatInt(r.SizeBytes, 10),
+		"",
+		strings.Join(r.KMSKeys, " "),
+		"",
+		formatCost(r.MonthlyCost),
+	})
+
+	if err := cw.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// formatCost formats a cost in USD with enough precision for small amounts.
+func formatCost(cost float64) string {
+	return strconv.FormatFloat(cost, 'f', 4, 64)
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	pricing := Pricing{
+		SecretManagerVersion: 0.06,
+		StorageGiB:           0.02,
+		KMSKeyVersion:        1,
+	}
+
+	cases := []struct {
+		name    string
+		typ     Type
+		secrets []*Secret
+		costs   []float64
+		total   float64
+	}{
+		{
+			name: "secret_manager",
+			typ:  TypeSecretManager,
+			secrets: []*Secret{
+				{Name: "b", Versions: 3, ActiveVersions: 2},
+				{Name: "a", Versions: 1, ActiveVersions: 1, Locations: []string{"us-east1", "us-west1"}},
+			},
+			costs: []float64{0.12, 0.12},
+			total: 0.24,
+		},
+		{
+			name: "storage",
+			typ:  TypeStorage,
+			secrets: []*Secret{
+				{Name: "a", Versions: 2, ActiveVersions: 2, SizeBytes: 1 << 30, KMSKey: "k1"},
+				{Name: "b", Versions: 1, ActiveVersions: 1, SizeBytes: 1 << 29, KMSKey: "k1"},
+				{Name: "c", Versions: 1, ActiveVersions: 1, KMSKey: "k2"},
+			},
+			costs: []float64{0.02, 0.01, 0},
+			total: 2.03,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := New(tc.typ, "src", tc.secrets, pricing)
+			if r.Count != len(tc.secrets) {
+				t.Errorf("expected %d to be %d", r.Count, len(tc.secrets))
+			}
+			for i, s := range r.Secrets {
+				if i > 0 && r.Secrets[i-1].Name > s.Name {
+					t.Errorf("expected secrets to be sorted by name")
+				}
+				if !costEqual(s.MonthlyCost, tc.costs[i]) {
+					t.Errorf("%s: expected %v to be %v", s.Name, s.MonthlyCost, tc.costs[i])
+				}
+			}
+			if !costEqual(r.MonthlyCost, tc.total) {
+				t.Errorf("expected %v to be %v", r.MonthlyCost, tc.total)
+			}
+		})
+	}
+}
+
+func TestLoadPricing(t *testing.T) {
+	t.Parallel()
+
+	pricing, err := LoadPricing(strings.NewReader(`{"storage_gib": 0.026}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := DefaultPricing()
+	exp.StorageGiB = 0.026
+	if pricing != exp {
+		t.Errorf("expected %#v to be %#v", pricing, exp)
+	}
+
+	for _, s := range []string{`{"storage": 1}`, `{"storage_gib": -1}`, `nope`} {
+		if _, err := LoadPricing(strings.NewReader(s)); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestReport_Write(t *testing.T) {
+	t.Parallel()
+
+	updated := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	r := New(TypeStorage, "my-secrets", []*Secret{
+		{Name: "foo", Versions: 2, ActiveVersions: 2, SizeBytes: 10, KMSKey: "k", UpdatedAt: updated},
+	}, Pricing{KMSKeyVersion: 0.06})
+
+	var b bytes.Buffer
+	if err := r.WriteCSV(&b); err != nil {
+		t.Fatal(err)
+	}
+	exp := "name,versions,active_versions,size_bytes,locations,kms_key,updated_at,estimated_monthly_cost\n" +
+		"foo,2,2,10,,k,2024-01-02T03:04:05Z,0.0000\n" +
+		"TOTAL,2,2,10,,k,,0.0600\n"
+	if act := b.String(); act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	b.Reset()
+	if err := r.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Count != 1 || decoded.Secrets[0].Name != "foo" {
+		t.Errorf("unexpected report %#v", decoded)
+	}
+}
+
+func costEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
@@ -0,0 +1,24 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package supervise runs a command as a child process that stays attached to
+// the caller, for when the caller must remain running, such as when it is PID
+// 1 in a container.
+package supervise
+
+// SignalExitCode returns the exit code a shell reports for a process killed
+// by the given signal number.
+func SignalExitCode(sig int) int {
+	return 128 + sig
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package supervise
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// forwardedSignals are the signals sent to the child's process group when the
+// supervisor receives them.
+var forwardedSignals = []os.Signal{
+	syscall.SIGALRM,
+	syscall.SIGCONT,
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGWINCH,
+}
+
+// Run starts the program at path with the given arguments (including the
+// program name) and environment, and waits for it to exit. It returns the
+// child's exit code, or 128 plus the signal number if the child was killed by
+// a signal.
+//
+// The child is started in its own process group, which becomes the foreground
+// process group if stdin is a terminal, so the terminal delivers keyboard
+// signals and window size changes to it directly. Signals sent to the
+// supervisor are forwarded to the child's process group. If the supervisor is
+// PID 1, it also reaps orphaned descendants so they do not become zombies.
+func Run(path string, args, env []string) (int, error) {
+	// Register before starting the child so no exit or signal is missed.
+	sigCh := make(chan os.Signal, 32)
+	signal.Notify(sigCh, forwardedSignals...)
+	defer signal.Stop(sigCh)
+
+	childCh := make(chan os.Signal, 1)
+	signal.Notify(childCh, syscall.SIGCHLD)
+	defer signal.Stop(childCh)
+
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if _, err := unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP); err == nil {
+		attr.Foreground = true
+		attr.Ctty = int(os.Stdin.Fd())
+
+		// The supervisor is in the background while the child runs, and must
+		// not be stopped for touching the terminal.
+		signal.Ignore(syscall.SIGTTIN, syscall.SIGTTOU)
+		defer signal.Reset(syscall.SIGTTIN, syscall.SIGTTOU)
+	}
+
+	proc, err := os.StartProcess(path, args, &os.ProcAttr{
+		Env:   env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		Sys:   attr,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to start %q: %w", path, err)
+	}
+	pid := proc.Pid
+
+	// The child is waited for directly below, which os.Process does not know
+	// about.
+	defer proc.Release()
+
+	reapAll := os.Getpid() == 1
+
+	for {
+		select {
+		case sig := <-sigCh:
+			// The process group may already be gone if the child just exited.
+			if err := syscall.Kill(-pid, sig.(syscall.Signal)); err != nil && !errors.Is(err, syscall.ESRCH) {
+				return 0, fmt.Errorf("failed to forward %s: %w", sig, err)
+			}
+		case <-childCh:
+			status, exited, err := reap(pid, reapAll)
+			if err != nil {
+				return 0, err
+			}
+			if exited {
+				return exitCode(status), nil
+			}
+		}
+	}
+}
+
+// reap waits for exited children without blocking, returning the status of
+// the child with the given pid if it exited. SIGCHLD signals are coalesced, so
+// every exited child is collected. If all is true, any child is reaped,
+// including orphans reparented to this process.
+func reap(pid int, all bool) (syscall.WaitStatus, bool, error) {
+	wpid := pid
+	if all {
+		wpid = -1
+	}
+
+	var result syscall.WaitStatus
+	var exited bool
+	for {
+		var status syscall.WaitStatus
+		got, err := syscall.Wait4(wpid, &status, syscall.WNOHANG, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if errors.Is(err, syscall.ECHILD) || got <= 0 {
+			return result, exited, nil
+		}
+		if err != nil {
+			return result, exited, fmt.Errorf("failed to wait for child: %w", err)
+		}
+
+		if got == pid {
+			result, exited = status, true
+		}
+	}
+}
+
+// exitCode returns the shell exit code for the given status.
+func exitCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return SignalExitCode(int(status.Signal()))
+	}
+	return status.ExitStatus()
+}
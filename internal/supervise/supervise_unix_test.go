@@ -0,0 +1,82 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package supervise
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		script string
+		exp    int
+	}{
+		{"success", "exit 0", 0},
+		{"exit_code", "exit 3", 3},
+		{"signaled", "kill -TERM $$", SignalExitCode(int(syscall.SIGTERM))},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			code, err := Run("/bin/sh", []string{"sh", "-c", tc.script}, os.Environ())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tc.exp {
+				t.Errorf("expected %d to be %d", code, tc.exp)
+			}
+		})
+	}
+}
+
+func TestRun_forwardsSignals(t *testing.T) {
+	// Not parallel, since the signal is sent to the whole test process.
+
+	ready := filepath.Join(t.TempDir(), "ready")
+	script := `trap 'exit 7' USR1; touch "$READY"; while :; do sleep 0.1; done`
+
+	go func() {
+		for {
+			if _, err := os.Stat(ready); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	code, err := Run("/bin/sh", []string{"sh", "-c", script}, append(os.Environ(), "READY="+ready))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 7 {
+		t.Errorf("expected %d to be %d", code, 7)
+	}
+}
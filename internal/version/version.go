@@ -36,6 +36,23 @@ var (
 		return "HEAD"
 	})
 
+	// Date is the build date, or the commit date if not set at build time.
+	date string
+	Date = valueOrFallback(date, func() string {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.time" {
+					return setting.Value
+				}
+			}
+		}
+
+		return "unknown"
+	})
+
+	// GoVersion is the version of Go used to build the binary.
+	GoVersion = runtime.Version()
+
 	// OSArch is the operating system and architecture combination.
 	OSArch = runtime.GOOS + "/" + runtime.GOARCH
 
@@ -0,0 +1,126 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wait blocks until dependencies, such as files or TCP listeners, are
+// ready.
+package wait
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Condition is a dependency that can be checked for readiness.
+type Condition interface {
+	// Ready returns nil if the dependency is ready, or an error describing why
+	// it is not.
+	Ready(ctx context.Context) error
+
+	// String returns the condition as it was specified.
+	String() string
+}
+
+// Parse parses a condition. Supported formats are:
+//
+//	tcp://HOST:PORT   - a TCP connection to HOST:PORT succeeds
+//	file:///PATH      - the file at PATH exists
+//	PATH              - same as file://PATH
+func Parse(s string) (Condition, error) {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		scheme, rest = "file", s
+	}
+
+	switch scheme {
+	case "tcp":
+		if _, _, err := net.SplitHostPort(rest); err != nil {
+			return nil, fmt.Errorf("invalid tcp address %q: %w", rest, err)
+		}
+		return &tcpCondition{spec: s, addr: rest}, nil
+	case "file":
+		if rest == "" {
+			return nil, fmt.Errorf("missing file path in %q", s)
+		}
+		return &fileCondition{spec: s, path: rest}, nil
+	default:
+		return nil, fmt.Errorf("unsupported wait condition %q (must be tcp:// or a file path)", s)
+	}
+}
+
+// For checks each condition in order every interval until it is ready. It
+// returns an error if the context is done before all conditions are ready.
+func For(ctx context.Context, interval time.Duration, conds ...Condition) error {
+	for _, c := range conds {
+		if err := forOne(ctx, interval, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forOne(ctx context.Context, interval time.Duration, c Condition) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := c.Ready(ctx)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for %s: %w (last error: %s)", c, ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// tcpCondition is ready when a TCP connection can be established.
+type tcpCondition struct {
+	spec string
+	addr string
+}
+
+func (c *tcpCondition) Ready(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (c *tcpCondition) String() string {
+	return c.spec
+}
+
+// fileCondition is ready when the file exists.
+type fileCondition struct {
+	spec string
+	path string
+}
+
+func (c *fileCondition) Ready(ctx context.Context) error {
+	_, err := os.Stat(c.path)
+	return err
+}
+
+func (c *fileCondition) String() string {
+	return c.spec
+}
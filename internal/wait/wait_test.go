@@ -0,0 +1,123 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wait
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		s    string
+		err  bool
+	}{
+		{"tcp", "tcp://localhost:5432", false},
+		{"tcp_missing_port", "tcp://localhost", true},
+		{"file_scheme", "file:///tmp/ready", false},
+		{"file_path", "/tmp/ready", false},
+		{"file_empty", "file://", true},
+		{"unsupported", "http://localhost", true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c, err := Parse(tc.s)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t: %v", tc.err, err)
+			}
+			if err == nil && c.String() != tc.s {
+				t.Errorf("expected %q to be %q", c.String(), tc.s)
+			}
+		})
+	}
+}
+
+func TestFor(t *testing.T) {
+	t.Parallel()
+
+	t.Run("file", func(t *testing.T) {
+		t.Parallel()
+
+		pth := filepath.Join(t.TempDir(), "ready")
+		c, err := Parse(pth)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			if err := os.WriteFile(pth, nil, 0o600); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := For(ctx, 10*time.Millisecond, c); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		t.Parallel()
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+
+		c, err := Parse("tcp://" + ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := For(ctx, 10*time.Millisecond, c); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := Parse(filepath.Join(t.TempDir(), "never"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		if err := For(ctx, 10*time.Millisecond, c); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
@@ -17,21 +17,44 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"os/exec"
 	"os/signal"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"text/tabwriter"
-
+	"time"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/internal/envexport"
+	"github.com/GoogleCloudPlatform/berglas/v2/internal/generate"
+	"github.com/GoogleCloudPlatform/berglas/v2/internal/lint"
+	"github.com/GoogleCloudPlatform/berglas/v2/internal/progress"
+	"github.com/GoogleCloudPlatform/berglas/v2/internal/report"
+	"github.com/GoogleCloudPlatform/berglas/v2/internal/supervise"
 	"github.com/GoogleCloudPlatform/berglas/v2/internal/version"
+	"github.com/GoogleCloudPlatform/berglas/v2/internal/wait"
 	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/awskms"
 	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logredact"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/s3store"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"google.golang.org/api/option"
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -43,6 +66,57 @@ const (
 	MisuseExitCode = 61
 )
 
+// dataPrefixEnv prefixes a DATA argument that names an environment variable to
+// read the secret value from, such as "env:TF_VAR_secret".
+const dataPrefixEnv = "env:"
+
+// execWaitInterval is how often exec checks --wait-for dependencies.
+const execWaitInterval = 500 * time.Millisecond
+
+const (
+	// envUseClientCertificate and envUseMTLSEndpoint are the environment
+	// variables the Google API client libraries use to enable client
+	// certificates and select the mTLS endpoints.
+	envUseClientCertificate = "GOOGLE_API_USE_CLIENT_CERTIFICATE"
+	envUseMTLSEndpoint      = "GOOGLE_API_USE_MTLS_ENDPOINT"
+
+	// envReadOnly is the environment variable that enables read-only mode when
+	// set to "true".
+	envReadOnly = "BERGLAS_READ_ONLY"
+
+	// envJustification is the environment variable that sets the default
+	// request justification.
+	envJustification = "BERGLAS_JUSTIFICATION"
+
+	// envAADContext is the environment variable that sets the default context
+	// bound into the additional authenticated data of Cloud Storage secrets.
+	envAADContext = "BERGLAS_AAD_CONTEXT"
+
+	// envLogHashKey is the environment variable that sets the key used to hash
+	// secret names in logs.
+	envLogHashKey = "BERGLAS_LOG_HASH_KEY"
+
+	// envCacheKey is the environment variable that sets the key used to encrypt
+	// the on-disk secret cache, enabling the cache.
+	envCacheKey = "BERGLAS_CACHE_KEY"
+
+	// envAliases is the environment variable that sets the path to the alias
+	// file.
+	envAliases = "BERGLAS_ALIASES"
+
+	// envPreferRegion is the environment variable that sets the region the
+	// caller runs in.
+	envPreferRegion = "BERGLAS_PREFER_REGION"
+
+	// envNamingPolicy is the environment variable that sets the path to the
+	// naming policy file.
+	envNamingPolicy = "BERGLAS_NAMING_POLICY"
+
+	// envStorageEncryptionKey is the environment variable that sets the base64
+	// customer-supplied encryption key for Cloud Storage objects.
+	envStorageEncryptionKey = "BERGLAS_STORAGE_ENCRYPTION_KEY"
+)
+
 var (
 	stdout = os.Stdout
 	stderr = os.Stderr
@@ -52,18 +126,140 @@ var (
 	logLevel  string
 	logDebug  bool
 
+	logHashKey         string
+	logDebugSampleRate float64
+
+	cacheKey          string
+	cacheTTL          time.Duration
+	cacheDir          string
+	cacheMaxStaleness time.Duration
+
+	circuitBreaker int
+
+	aliasesFile string
+
+	namingPolicyFile string
+
+	storageEncryptionKeyFile string
+
+	preferRegion string
+
+	hedgeDelay time.Duration
+
+	showProgress bool
+	showStats    bool
+
+	// cliStats records the API calls made by the command for --stats.
+	cliStats berglas.Stats
+
+	timeout time.Duration
+
+	storageEndpoint       string
+	s3Endpoint            string
+	secretManagerEndpoint string
+	kmsEndpoint           string
+	useMTLS               bool
+	readOnly              bool
+	justification         string
+	bindAAD               bool
+	aadContext            string
+
 	accessGeneration int64
+	accessCiphertext bool
+	accessMetadata   bool
+
+	copyRebind bool
+
+	decryptOfflineDEKFile string
+
+	escrowWrappingKey string
+	escrowPrivateKey  string
+	escrowTo          string
+
+	listGenerations  bool
+	listPrefix       string
+	listWithMetadata bool
+	listStates       []string
+	listQuiet        bool
+	listFormat       string
+
+	key           string
+	execLocal     bool
+	execWait      []string
+	execSupervise bool
+	execDeep      bool
+	execStrict    bool
+
+	editor             string
+	editFromFile       string
+	editFormat         string
+	createIfMissing    bool
+	forceLarge         bool
+	waitForConsistency bool
+
+	envelopeAlgorithm string
+
+	requireProtectionLevel string
+	expiration             string
+	secretPolicy           string
+	secretContentType      string
+	ignorePolicy           bool
+	secretManagerPolicy    bool
+	rollbackProtection     bool
+
+	pruneDryRun bool
+	pruneKeep   int
+
+	reportFormat    string
+	reportPricing   string
+	reportWithSizes bool
+
+	deleteFile        string
+	deleteParallelism int
+	deleteDestroyTTL  string
+
+	envExportFile      string
+	envExportFormat    string
+	envExportName      string
+	envExportNamespace string
+	envExportSort      bool
+
+	assumeYes bool
+
+	lintFiles       []string
+	lintCheckAccess bool
 
-	listGenerations bool
-	listPrefix      string
+	driftEnvFiles    []string
+	driftFailOnDrift bool
 
-	key       string
-	execLocal bool
+	updateManyFile string
 
-	editor          string
-	createIfMissing bool
+	members          []string
+	membersNoExpand  bool
+	membersDryRun    bool
+	membersScope     string
+	allowACLFallback bool
 
-	members []string
+	grantReason  string
+	grantExpires string
+
+	migrateWithIAM      bool
+	migrateVerify       bool
+	migrateDeleteSource bool
+	migrateReportPath   string
+
+	watchExec     string
+	watchInterval time.Duration
+
+	generateType         string
+	generateCommonName   string
+	generateTTL          string
+	generatePublicSecret string
+
+	versionFormat string
+
+	parseRefFormat string
+	parseRefSchema bool
 
 	projectID      string
 	bucket         string
@@ -72,8 +268,46 @@ var (
 	kmsKeyRing     string
 	kmsCryptoKey   string
 	smLocations    []string
+
+	bootstrapExpirationLifecycle bool
+	bootstrapKMSProtectionLevel  string
+	bootstrapKMSRotationPeriod   time.Duration
+	bootstrapKMSLabels           map[string]string
 )
 
+// commandTimeouts are the default deadlines for each command when --timeout is
+// not given. Commands that are not listed, such as edit, have no deadline.
+var commandTimeouts = map[string]time.Duration{
+	"access":            30 * time.Second,
+	"bootstrap":         5 * time.Minute,
+	"can-i":             30 * time.Second,
+	"copy":              time.Minute,
+	"create":            time.Minute,
+	"delete":            10 * time.Minute,
+	"drift":             2 * time.Minute,
+	"escrow export":     30 * time.Second,
+	"escrow restore":    time.Minute,
+	"exec":              time.Minute,
+	"generate":          2 * time.Minute,
+	"grant":             2 * time.Minute,
+	"iam show":          30 * time.Second,
+	"kv delete":         time.Minute,
+	"kv get":            30 * time.Second,
+	"kv list":           30 * time.Second,
+	"kv set":            time.Minute,
+	"lint":              2 * time.Minute,
+	"list":              2 * time.Minute,
+	"migrate":           10 * time.Minute,
+	"prune":             10 * time.Minute,
+	"prune-generations": 5 * time.Minute,
+	"report":            10 * time.Minute,
+	"restore":           30 * time.Second,
+	"revoke":            2 * time.Minute,
+	"stat":              30 * time.Second,
+	"update":            5 * time.Minute,
+	"update-many":       10 * time.Minute,
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "berglas",
 	Short: "Interact with encrypted secrets",
@@ -107,16 +341,42 @@ Cloud Storage and decrypting it with Google Cloud KMS.
 
 The result will be the raw value without any additional formatting or newline
 characters.
+
+With --ciphertext, the stored envelope (the KMS-wrapped data encryption key and
+the encrypted data) is printed exactly as it appears in Cloud Storage, without
+calling Cloud KMS. This is only supported for Cloud Storage secrets. See
+"berglas decrypt-offline" for decrypting the result.
+
+With --include-metadata, the result is instead a JSON object with the plaintext
+(base64-encoded), the version (the Secret Manager version or Cloud Storage
+generation), the update time, and the CRC32C checksum of the plaintext. This
+returns the value and its version in a single call.
+
+If SECRET is "-", newline-delimited references are read from stdin and one JSON
+object is printed per line (NDJSON) with the reference and either the
+--include-metadata result or an error. The command exits non-zero if any
+reference failed.
 `, "\n"),
 	Example: strings.Trim(`
   # Read a secret named "api-key" from the bucket "my-secrets"
   berglas access my-secrets/api-key
 
+  # Read a secret along with its version and update time
+  berglas access sm://my-project/api-key --include-metadata
+
+  # Save the stored ciphertext of a secret for offline recovery
+  berglas access my-secrets/api-key --ciphertext > api-key.enc
+
   # Read generation 1563925940580201 of a secret named "api-key" from the bucket "my-secrets"
   berglas access my-secrets/api-key#1563925940580201
+
+  # Read every Secret Manager secret referenced in a set of manifests
+  grep -ho 'sm://[^"[:space:]]*' manifests/* | berglas access -
 `, "\n"),
 	Args: cobra.ExactArgs(1),
 	RunE: accessRun,
+
+	ValidArgsFunction: completeSecrets,
 }
 
 var bootstrapCmd = &cobra.Command{
@@ -133,22 +393,105 @@ bucket already exists, an error is returned.
 
 This command will also create a Cloud KMS key ring and crypto key in the
 specified project. If the key ring or crypto key already exist, no errors are
-returned.
+returned and the existing crypto key is not modified. By default, the crypto
+key uses SOFTWARE protection and rotates every 30 days.
 `, "\n"),
 	Example: strings.Trim(`
   # Bootstrap a berglas environment
   berglas bootstrap --project my-project --bucket my-bucket
+
+  # Bootstrap with an HSM-protected key rotated every 90 days
+  berglas bootstrap --project my-project --bucket my-bucket \
+    --kms-protection-level HSM --kms-rotation-period 2160h
 `, "\n"),
 	Args: cobra.ExactArgs(0),
 	RunE: bootstrapRun,
 }
 
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the on-disk secret cache",
+	Long: strings.Trim(`
+Manages the on-disk cache of accessed secrets. The cache is enabled with the
+global --cache-key flag (or BERGLAS_CACHE_KEY), which is the Cloud KMS key or
+key URI (such as local-key:///path/to/key) used to encrypt cache entries.
+Cached secrets are served for --cache-ttl without contacting Secret Manager or
+Cloud Storage, even if they change, which reduces requests from commands that
+run often, such as cron jobs. Entries are stored in --cache-dir.
+
+With --cache-max-staleness, expired entries are kept and served for up to that
+long past their expiry when Secret Manager or Cloud Storage is unavailable, with
+a warning that berglas is running in degraded mode. Secrets that were deleted or
+whose access was revoked are never served from the cache.
+`, "\n"),
+}
+
+var cachePurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Remove all cached secrets",
+	Long: strings.Trim(`
+Removes every entry from the on-disk secret cache in --cache-dir.
+`, "\n"),
+	Example: strings.Trim(`
+  # Remove all cached secrets
+  berglas cache purge
+`, "\n"),
+	Args: cobra.NoArgs,
+	RunE: cachePurgeRun,
+}
+
+var canICmd = &cobra.Command{
+	Use:   "can-i ACTION SECRET",
+	Short: "Check permissions for an action on a secret",
+	Long: strings.Trim(`
+Checks whether the caller has the IAM permissions required to perform an
+action on a secret, without performing it. ACTION is one of "access", "create",
+or "grant" (which also covers revoke).
+
+Each required permission is printed with the resource it was tested on, such
+as the Cloud Storage object, its Cloud KMS key, or the Secret Manager secret.
+Checking "create" for a Cloud Storage secret requires --key.
+
+The command exits non-zero if any permission is missing, or if the secret does
+not exist (or already exists, for "create").
+
+If SECRET is "-", newline-delimited references are read from stdin and one JSON
+object is printed per line (NDJSON) with the reference, the permissions, and an
+error if the action is not allowed.
+`, "\n"),
+	Example: strings.Trim(`
+  # Check if the caller can read the secret "foo" from Secret Manager
+  berglas can-i access sm://my-project/foo
+
+  # Check if the caller can create the secret "foo" in the bucket "my-secrets"
+  berglas can-i create my-secrets/foo \
+    --key projects/my-p/locations/global/keyRings/my-kr/cryptoKeys/my-k
+
+  # Check if the caller can read each secret listed in a file
+  berglas can-i access - < secrets.txt
+`, "\n"),
+	Args: cobra.ExactArgs(2),
+	RunE: canIRun,
+
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) == 0 {
+			return []string{
+				string(berglas.CanIAccess),
+				string(berglas.CanICreate),
+				string(berglas.CanIGrant),
+			}, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completeSecrets(cmd, args[1:], toComplete)
+	},
+}
+
 var completionCmd = &cobra.Command{
-	Use:   "completion SHELL",
-	Args:  cobra.ExactArgs(1),
-	Short: "Outputs shell completion for the given shell (bash or zsh)",
+	Use:       "completion SHELL",
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+	Short:     "Outputs shell completion for the given shell (bash, zsh, fish, or powershell)",
 	Long: strings.Trim(
-		`Outputs shell completion for the given shell (bash or zsh)
+		`Outputs shell completion for the given shell (bash, zsh, fish, or powershell)
 
 This depends on the bash-completion package. To install it:
 
@@ -160,6 +503,11 @@ This depends on the bash-completion package. To install it:
 
 Zsh users may also put the file somewhere on their $fpath, like
 /usr/local/share/zsh/site-functions
+
+Fish users may also put the file in ~/.config/fish/completions/berglas.fish
+
+Completion includes secret names: type a bucket name followed by a slash, or
+"sm://PROJECT/", and press tab to list matching secrets.
 `, "\n"),
 	Example: strings.Trim(`
   # Enable completion for bash users
@@ -167,10 +515,51 @@ Zsh users may also put the file somewhere on their $fpath, like
 
   # Enable completion for zsh users
   source <(berglas completion zsh)
+
+  # Enable completion for fish users
+  berglas completion fish | source
+
+  # Enable completion for PowerShell users
+  berglas completion powershell | Out-String | Invoke-Expression
 `, "\n"),
 	RunE: completionRun,
 }
 
+var copyCmd = &cobra.Command{
+	Use:   "copy SOURCE DESTINATION",
+	Short: "Copy a Cloud Storage secret",
+	Long: strings.Trim(`
+Copies a Cloud Storage secret to another bucket or object. If the destination
+already exists, an error is returned.
+
+The data encryption key of a secret is encrypted with Cloud KMS additional
+authenticated data that includes the object name, and the bucket name for
+secrets created with --bind-aad. A raw copy of the object, such as one made with
+"gsutil cp", cannot be decrypted at its new location for this reason.
+
+Without --rebind, the stored ciphertext is copied unchanged, which is refused if
+the additional authenticated data would change. With --rebind, the secret is
+decrypted with the additional authenticated data of the source and re-encrypted
+with the same Cloud KMS key, bound to the destination.
+
+To repair a raw copy, delete it and copy the original with --rebind.
+`, "\n"),
+	Example: strings.Trim(`
+  # Copy the secret "api-key" to a secret of the same name in another bucket
+  berglas copy my-secrets/api-key my-backup-secrets/api-key
+
+  # Copy the secret "api-key" to a new name, re-encrypting it for that name
+  berglas copy my-secrets/api-key my-secrets/api-key-v2 --rebind
+
+  # Restore generation 1563925940580201 of a secret to a new name
+  berglas copy my-secrets/api-key#1563925940580201 my-secrets/api-key-old --rebind
+`, "\n"),
+	Args: cobra.ExactArgs(2),
+	RunE: copyRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
 var createCmd = &cobra.Command{
 	Use:   "create SECRET DATA",
 	Short: "Create a secret",
@@ -178,6 +567,10 @@ var createCmd = &cobra.Command{
 Creates a new secret with the given name and contents, encrypted with the
 provided Cloud KMS key. If the secret already exists, an error is returned.
 
+DATA is the secret value. It may also be "-" to read from stdin, "@FILE" to read
+from a file, or "env:NAME" to read from an environment variable. Prefix a
+literal value that starts with one of these with "\".
+
 Use the "edit" or "update" commands to update an existing secret.
 `, "\n"),
 	Example: strings.Trim(`
@@ -190,26 +583,123 @@ Use the "edit" or "update" commands to update an existing secret.
 
   # Read a secret from a local file
   berglas create my-secrets/api-key @/path/to/file --key...
+
+  # Read a secret from an environment variable, keeping it out of argv and
+  # shell history
+  berglas create my-secrets/api-key env:TF_VAR_secret --key...
+
+  # Require the key to be backed by an external key manager (EKM)
+  berglas create my-secrets/api-key abcd1234 --key... \
+    --require-protection-level EXTERNAL
+
+  # Only allow the secret to be resolved to a file, never an env var
+  berglas create my-secrets/tls-key @key.pem --key... --policy file-only
 `, "\n"),
 	Args: cobra.ExactArgs(2),
 	RunE: createRun,
 }
 
+var decryptOfflineCmd = &cobra.Command{
+	Use:   "decrypt-offline [FILE]",
+	Short: "Decrypt a stored ciphertext without Cloud KMS",
+	Long: strings.Trim(`
+Decrypts a ciphertext previously saved with "berglas access --ciphertext" using
+an escrowed data encryption key (DEK), without making any network calls. This
+is intended for break-glass recovery when Cloud KMS is unavailable.
+
+The ciphertext is read from FILE, or from stdin if FILE is omitted or "-". The
+DEK file may contain the 32-byte key as raw bytes, hex, or base64. The DEK must
+already be unwrapped; a DEK that is still wrapped by Cloud KMS must first be
+decrypted by whatever holds the wrapping key, such as an HSM under escrow.
+
+The result will be the raw value without any additional formatting or newline
+characters.
+`, "\n"),
+	Example: strings.Trim(`
+  # Decrypt a saved ciphertext with an escrowed DEK
+  berglas decrypt-offline api-key.enc --dek-file api-key.dek
+
+  # Decrypt a ciphertext from stdin
+  cat api-key.enc | berglas decrypt-offline --dek-file api-key.dek
+`, "\n"),
+	Args: cobra.MaximumNArgs(1),
+	RunE: decryptOfflineRun,
+}
+
 var deleteCmd = &cobra.Command{
-	Use:   "delete SECRET",
+	Use:   "delete SECRET...",
 	Short: "Remove a secret",
 	Long: strings.Trim(`
 Deletes a secret from a Google Cloud Storage bucket by deleting the underlying
 GCS object. If the secret does not exist, this operation is a no-op.
 
 This command will exit successfully even if the secret does not exist.
+
+Multiple secrets may be given as arguments or with --file, which reads one
+secret per line from a file or stdin ("-"). Blank lines and lines starting
+with "#" are ignored. The secrets are deleted concurrently and a result is
+printed for each one. The command exits non-zero if any deletion fails.
+
+A Secret Manager reference with a version, such as sm://my-project/api-key#3,
+destroys only that version. With --destroy-ttl, the secret's version destroy
+TTL is set first, so the version is disabled and only destroyed once the TTL
+passes. Until then, it can be recovered with "berglas restore". The TTL stays
+set on the secret and applies to later destroys of its versions.
+
+Deletion cannot be undone. When run from a terminal, the command asks you to
+type the secret name (or the number of secrets when deleting several) before
+deleting anything. Use --yes to skip the prompt. There is no prompt when stdin
+is not a terminal.
 `, "\n"),
 	Example: strings.Trim(`
   # Delete a secret named "api-key"
   berglas delete my-secrets/api-key
+
+  # Destroy version 3 of a Secret Manager secret, recoverable for 7 days
+  berglas delete sm://my-project/api-key#3 --destroy-ttl 7d
+
+  # Delete every secret listed in secrets.txt
+  berglas delete --file secrets.txt
+
+  # Delete a secret without the confirmation prompt
+  berglas delete my-secrets/api-key --yes
 `, "\n"),
-	Args: cobra.ExactArgs(1),
+	Args: func(cmd *cobra.Command, args []string) error {
+		if deleteFile == "" {
+			return cobra.MinimumNArgs(1)(cmd, args)
+		}
+		return nil
+	},
 	RunE: deleteRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Report pinned references behind the latest version",
+	Long: strings.Trim(`
+Compares each reference in the given .env files that is pinned to a Secret
+Manager version or Cloud Storage generation, such as sm://my-project/foo#3,
+with the latest enabled version or live generation, and reports which
+variables are behind. Secret values are never read.
+
+References that are not pinned to a number, such as "latest" or a version
+alias, are reported as unpinned. Fallback chains are checked reference by
+reference.
+
+With --fail-on-drift, the command exits non-zero if any variable is behind,
+for use in CI.
+`, "\n"),
+	Example: strings.Trim(`
+  # Report drift for the references in .env
+  berglas drift --env-file .env
+
+  # Fail a CI job if any pinned reference is behind
+  berglas drift --env-file prod.env --env-file staging.env --fail-on-drift
+`, "\n"),
+	Args: cobra.NoArgs,
+	RunE: driftRun,
 }
 
 var editCmd = &cobra.Command{
@@ -222,7 +712,14 @@ using an editor, encrypting the updated content using Google Cloud KMS, writing
 it back into Google Cloud Storage.
 
 The file must be saved with changes and editor must exit with exit code 0 for
-the secret to be updated.
+the secret to be updated. With --from-file, the new value is read from a file
+(or stdin with "-") instead and no editor is opened.
+
+With --format json, a JSON object describing the outcome is printed to stdout,
+whether or not the edit succeeds. Its status is one of "updated", "unchanged"
+(the value was saved without changes), "empty", "editor_failed" (the editor
+exited non-zero, see editor_exit_code), or "error". The previous and new
+version or generation are included when known.
 `, "\n"),
 	Example: strings.Trim(`
   # Edit a secret named "api-key" from the bucket "my-secrets"
@@ -230,9 +727,132 @@ the secret to be updated.
 
   # Edit a secret named "api-key" from the bucket "my-secrets" using emacs
   berglas edit my-secrets/api-key --editor emacs
+
+  # Replace a secret from a rendered template, reporting the result as JSON
+  render-template | berglas edit sm://my-project/api-key --from-file - --format json
 `, "\n"),
 	Args: cobra.ExactArgs(1),
 	RunE: editRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var envCmd = &cobra.Command{
+	Use:   "env [NAME=REFERENCE...]",
+	Short: "Export resolved secrets for CI systems",
+	Long: strings.Trim(`
+Resolves a list of NAME=REFERENCE pairs and prints them in a format understood
+by a CI system or deployment tool, so each one does not need its own wrapper
+script. Pairs may be given as arguments or with --file, which reads one pair per
+line from a file or stdin ("-"). Blank lines and lines starting with "#" are
+ignored. Values that are not berglas references are passed through unchanged.
+
+The --format flag selects the output:
+
+- dotenv: NAME=VALUE lines. Values are double-quoted, with backslashes, quotes,
+  and newlines escaped, unless they contain only letters, digits, and common
+  punctuation.
+
+- gha: GitHub Actions. An "::add-mask::" command is printed for each value so it
+  is redacted from the logs, and the variables are appended to the file named
+  by $GITHUB_ENV so later steps can read them. If $GITHUB_ENV is not set, the
+  variables are printed instead.
+
+- k8s: a Kubernetes Secret manifest with the base64-encoded values in its data.
+  The Secret's name is set with --name and its namespace with --namespace.
+
+Variables are written in the order they are given, or sorted by name with
+--sort. With --sort, dotenv and k8s output depends only on the set of variables
+and their values, so exports can be compared between runs. The gha format uses a
+random delimiter for each variable and is never byte-for-byte stable.
+
+The output contains plaintext secrets. Take care where it is written.
+`, "\n"),
+	Example: strings.Trim(`
+  # Write a .env file
+  berglas env API_KEY=sm://my-project/api-key TLS_KEY=my-secrets/tls-key > .env
+
+  # Export secrets to later steps of a GitHub Actions job
+  berglas env --file secrets.env --format gha
+
+  # Create a Kubernetes Secret
+  berglas env --file secrets.env --format k8s --name app-secrets | kubectl apply -f -
+`, "\n"),
+	Args: func(cmd *cobra.Command, args []string) error {
+		if envExportFile == "" {
+			return cobra.MinimumNArgs(1)(cmd, args)
+		}
+		return nil
+	},
+	RunE: envRun,
+}
+
+var escrowCmd = &cobra.Command{
+	Use:   "escrow",
+	Short: "Export and restore break-glass escrow bundles",
+	Long: strings.Trim(`
+Exports Cloud Storage secrets to sealed escrow bundles and restores them, as a
+documented recovery path that does not depend on the secret's primary Cloud KMS
+key.
+
+An escrow bundle holds the stored ciphertext and the secret's data encryption
+key re-wrapped under an escrow RSA key with RSA-OAEP (SHA-256). Bundles are as
+sensitive as the escrow private key and should be kept in sealed-envelope
+storage.
+`, "\n"),
+}
+
+var escrowExportCmd = &cobra.Command{
+	Use:   "export SECRET",
+	Short: "Export a secret to an escrow bundle",
+	Long: strings.Trim(`
+Exports a Cloud Storage secret to an escrow bundle, printed as JSON. The data
+encryption key is decrypted with the secret's Cloud KMS key and re-wrapped
+under the --wrapping-key, which is either a Cloud KMS asymmetric decryption key
+version (RSA-OAEP SHA-256) or the path to a PEM-encoded RSA public key. The
+secret's plaintext is never decrypted.
+
+Every export must give a --justification, which is attached to the Cloud KMS
+request so it appears in the Cloud KMS audit log, and is reported on stderr.
+`, "\n"),
+	Example: strings.Trim(`
+  # Escrow a secret under a public key held by the security team
+  berglas escrow export my-secrets/api-key \
+    --wrapping-key escrow.pub \
+    --justification CHG-1234 > api-key.escrow.json
+
+  # Escrow a secret under a Cloud KMS key in a separate project
+  berglas escrow export my-secrets/api-key \
+    --wrapping-key projects/p/locations/global/keyRings/escrow/cryptoKeys/escrow/cryptoKeyVersions/1 \
+    --justification CHG-1234 > api-key.escrow.json
+`, "\n"),
+	Args: cobra.ExactArgs(1),
+	RunE: escrowExportRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var escrowRestoreCmd = &cobra.Command{
+	Use:   "restore [FILE]",
+	Short: "Restore a secret from an escrow bundle",
+	Long: strings.Trim(`
+Restores a secret from an escrow bundle read from FILE, or from stdin if FILE is
+omitted or "-". The data encryption key is unwrapped with --private-key, or with
+the bundle's Cloud KMS wrapping key if no private key is given. The secret is
+then written back to Cloud Storage under a new data encryption key.
+
+By default the secret is restored to its original location and Cloud KMS key.
+Use --to and --key to restore elsewhere, for example when the original key is
+unavailable.
+`, "\n"),
+	Example: strings.Trim(`
+  # Restore a secret with the escrow private key under a new Cloud KMS key
+  berglas escrow restore api-key.escrow.json \
+    --private-key escrow.pem \
+    --key projects/p/locations/global/keyRings/recovery/cryptoKeys/recovery
+`, "\n"),
+	Args: cobra.MaximumNArgs(1),
+	RunE: escrowRestoreRun,
 }
 
 var execCmd = &cobra.Command{
@@ -243,8 +863,33 @@ Parse berglas references and spawn the given command with the secrets in the
 childprocess environment similar to exec(1). This is very useful in Docker
 containers or languages that do not support auto-import.
 
-Berglas will remain the parent process, but stdin, stdout, stderr, and any
-signals are proxied to the child process.
+By default, berglas replaces itself with the command, which inherits its
+process ID, stdin, stdout, and stderr.
+
+Run with --supervise to keep berglas running as the parent process instead.
+The command is started in its own process group, which is given the terminal
+if there is one. Signals sent to berglas (including SIGWINCH, SIGUSR1, and
+SIGUSR2) are forwarded to the process group, and berglas exits with the
+command's exit code, or 128 plus the signal number if it was killed by a
+signal. When running as PID 1, such as in a container, berglas also reaps
+orphaned processes so they do not become zombies.
+
+Run with --wait-for to block until dependencies are ready before resolving
+secrets and starting the command. Each value is either tcp://HOST:PORT, which
+waits until a TCP connection succeeds, or a file path (optionally prefixed
+with file://), which waits until the file exists. The wait counts toward
+--timeout.
+
+Run with --deep to also resolve references nested in environment variables
+whose values are JSON or YAML documents, such as a serialized config. Each
+string value that is entirely a reference is replaced with its secret, encoded
+as a JSON string or double-quoted YAML scalar, and the rest of the document is
+left as-is.
+
+After resolution, berglas warns about any environment variables that still
+contain a reference, such as a reference nested in a value without --deep, or
+a secret whose value is itself a reference. Only the variable names are
+printed. Run with --strict to fail instead of starting the command.
 
 WARNING: Using berglas exec exposes secrets in plaintext in environment
 variables. You should have a strong understanding of your software supply
@@ -254,11 +899,58 @@ resolved secrets will be in plaintext and available to the entire process.
 	Example: strings.Trim(`
   # Spawn a subshell with secrets populated
   berglas exec -- ${SHELL}
+
+  # Run as PID 1 in a container, reaping orphaned processes
+  berglas exec --supervise -- myapp
+
+  # Wait for the database and a config file before starting the app
+  berglas exec --wait-for tcp://db:5432 --wait-for /config/ready --timeout 5m -- myapp
+
+  # Resolve references inside a JSON config
+  APP_CONFIG='{"db": {"password": "sm://my-project/db-password"}}' \
+    berglas exec --deep -- myapp
 `, "\n"),
 	Args: cobra.MinimumNArgs(1),
 	RunE: execRun,
 }
 
+var generateCmd = &cobra.Command{
+	Use:   "generate SECRET --type TYPE",
+	Short: "Generate key material and store it as a secret",
+	Long: strings.Trim(`
+Generates new key material locally and stores the private half as a secret,
+the same as "berglas create". The private key never leaves this process
+unencrypted.
+
+TYPE is one of:
+
+  rsa:BITS          A PKCS #8 RSA private key (BITS defaults to 4096) and its
+                    PKIX public key
+  ssh-ed25519       An OpenSSH Ed25519 private key and its authorized_keys line
+  self-signed-cert  A PKCS #8 ECDSA P-256 private key and a self-signed
+                    certificate for --cn, valid for --ttl (default 90d)
+
+The public half is not secret. It is written to stdout unless --public-secret
+is given, in which case it is stored as a second secret and the success
+messages are printed instead. All flags of "berglas create" that apply to the
+secret, such as --key and --policy, apply to both secrets.
+`, "\n"),
+	Example: strings.Trim(`
+  # Generate an RSA key and save the public key locally
+  berglas generate my-secrets/signing-key --type rsa:4096 --key... > signing.pub
+
+  # Generate an SSH deploy key, storing both halves
+  berglas generate my-secrets/deploy-key --type ssh-ed25519 --key... \
+    --public-secret my-secrets/deploy-key-pub
+
+  # Generate a self-signed certificate valid for 30 days
+  berglas generate sm://my-project/tls-key --type self-signed-cert \
+    --cn internal.example.com --ttl 30d --public-secret sm://my-project/tls-cert
+`, "\n"),
+	Args: cobra.ExactArgs(1),
+	RunE: generateRun,
+}
+
 var grantCmd = &cobra.Command{
 	Use:   "grant SECRET",
 	Short: "Grant access to a secret",
@@ -277,11 +969,31 @@ Members must be specified with their type, for example:
   - group:group@mydomain.com
   - serviceAccount:xyz@gserviceaccount.com
   - user:user@mydomain.com
+
+Shorthand members are expanded unless --no-expand is given:
+
+  - jane@mydomain.com becomes user:jane@mydomain.com
+  - xyz@project.iam.gserviceaccount.com becomes serviceAccount:...
+  - sa:xyz@project becomes serviceAccount:xyz@project.iam.gserviceaccount.com
+
+With --scope project, the argument is a Secret Manager project (sm://PROJECT)
+and roles/secretmanager.secretAccessor is changed on the project's IAM policy,
+which applies to every secret in the project.
+
+For Secret Manager secrets, --expires grants access under an IAM condition that
+ends it at the given time, and --reason (which requires --expires) records why
+access was granted, such as a ticket number, in the condition's title and
+description. Use "berglas iam show" to review them. Revoking a member removes
+both their unconditional access and access granted with --reason or --expires.
 `, "\n"),
 	Example: strings.Trim(`
   # Grant access to a user
   berglas grant my-secrets/api-key --member user:user@mydomain.com
 
+  # Grant temporary access, recording the ticket that approved it
+  berglas grant sm://my-project/api-key --member user:user@mydomain.com \
+    --reason JIRA-123 --expires 2025-01-01
+
   # Grant access to service account
   berglas grant my-secrets/api-key \
     --member serviceAccount:sa@project.iam.gserviceaccount.com
@@ -290,9 +1002,157 @@ Members must be specified with their type, for example:
   berglas grant my-secrets/api-key \
     --member user:user@mydomain.com \
     --member serviceAccount:sa@project.iam.gserviceaccount.com
+
+  # Show which members would be added without changing any policies
+  berglas grant my-secrets/api-key --member user:user@mydomain.com --dry-run
+
+  # Grant access to every Secret Manager secret in a project
+  berglas grant sm://my-project --scope project \
+    --member serviceAccount:sa@project.iam.gserviceaccount.com
 `, "\n"),
 	Args: cobra.ExactArgs(1),
 	RunE: grantRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var iamCmd = &cobra.Command{
+	Use:   "iam",
+	Short: "Review access to secrets",
+}
+
+var iamShowCmd = &cobra.Command{
+	Use:   "show SECRET",
+	Short: "Show who has access to a secret",
+	Long: strings.Trim(`
+Shows the members with access to a secret, one per line. For Secret Manager
+secrets, these are the bindings of roles/secretmanager.secretAccessor on the
+secret's IAM policy, or with --scope project, on the project's. Access granted
+with "berglas grant --reason --expires" shows its reason and expiry, and access
+under other IAM conditions shows the condition's title and any expiry. Access
+that has expired is marked "(expired)".
+
+For Cloud Storage secrets, the members with read access to the object are
+shown.
+`, "\n"),
+	Example: strings.Trim(`
+  # Review access to a secret
+  berglas iam show sm://my-project/api-key
+
+  # Review access to every secret in a project
+  berglas iam show sm://my-project --scope project
+`, "\n"),
+	Args: cobra.ExactArgs(1),
+	RunE: iamShowRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var kvCmd = &cobra.Command{
+	Use:   "kv",
+	Short: "Get and set keys in a structured secret",
+	Long: strings.Trim(`
+Gets and sets individual keys of a structured secret, which holds several named
+values in one secret so they are always read and written together. Structured
+secrets are JSON objects of strings, or NAME=VALUE lines in dotenv format. The
+format of an existing secret is preserved, and new keys are added to an empty
+secret as JSON.
+
+Every set or delete writes a new version of the whole secret. Cloud Storage
+and S3 writes fail if the secret was changed since it was read. Secret Manager
+writes are last writer wins, so concurrent changes to the same secret may be
+lost.
+
+Single keys of JSON structured secrets can be resolved with the "jsonkey"
+reference parameter. Keys of dotenv structured secrets can only be read with
+"berglas kv get".
+`, "\n"),
+}
+
+var kvGetCmd = &cobra.Command{
+	Use:   "get SECRET KEY",
+	Short: "Print the value of a key",
+	Example: strings.Trim(`
+  # Print the database password
+  berglas kv get sm://my-project/database password
+`, "\n"),
+	Args: cobra.ExactArgs(2),
+	RunE: kvGetRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var kvSetCmd = &cobra.Command{
+	Use:   "set SECRET KEY VALUE",
+	Short: "Set the value of a key",
+	Long: strings.Trim(`
+Sets the value of KEY in the structured secret, adding the key if it does not
+exist. VALUE is read in the same way as by create: "-" reads from stdin,
+"@path" reads from a file, and "env:NAME" reads from an environment variable.
+`, "\n"),
+	Example: strings.Trim(`
+  # Set the database user
+  berglas kv set sm://my-project/database user admin
+
+  # Set the database password from stdin
+  berglas kv set sm://my-project/database password - < password.txt
+`, "\n"),
+	Args: cobra.ExactArgs(3),
+	RunE: kvSetRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var kvDeleteCmd = &cobra.Command{
+	Use:   "delete SECRET KEY",
+	Short: "Remove a key",
+	Example: strings.Trim(`
+  # Remove the old password
+  berglas kv delete sm://my-project/database old_password
+`, "\n"),
+	Args: cobra.ExactArgs(2),
+	RunE: kvDeleteRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var kvListCmd = &cobra.Command{
+	Use:   "list SECRET",
+	Short: "List the keys",
+	Example: strings.Trim(`
+  # List the keys of the database secret
+  berglas kv list sm://my-project/database
+`, "\n"),
+	Args: cobra.ExactArgs(1),
+	RunE: kvListRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint FILE...",
+	Short: "Validate secret references in files",
+	Long: strings.Trim(`
+Finds all berglas:// and sm:// references in the given files, such as
+Kubernetes manifests or .env files, and validates their syntax. With
+--check-access, it also checks that each secret exists and that the caller can
+access it. Every reference is printed, and the command exits non-zero if any
+reference has a problem.
+
+Files may be given as arguments or with --file. Use - to read from stdin.
+`, "\n"),
+	Example: strings.Trim(`
+  # Validate references in a manifest and an env file
+  berglas lint k8s.yaml .env
+
+  # Also check that each secret exists and is accessible
+  berglas lint --file k8s.yaml --check-access
+
+  # Read from stdin
+  cat k8s.yaml | berglas lint -
+`, "\n"),
+	Args: cobra.ArbitraryArgs,
+	RunE: lintRun,
 }
 
 var listCmd = &cobra.Command{
@@ -302,6 +1162,19 @@ var listCmd = &cobra.Command{
 Lists secrets by name in the given Google Cloud Storage bucket. It does not
 read their values, only their key names. To retrieve the value of a secret, use
 the "access" command instead.
+
+With --all-generations, the STATE column shows whether each Cloud Storage
+generation is the LIVE generation or ARCHIVED (noncurrent), and whether each
+Secret Manager version is ENABLED, DISABLED, or DESTROYED.
+
+If there are no secrets, "No secrets found" is printed instead of a table,
+unless --quiet is given.
+
+Secrets are always listed in the same order: by name in reverse lexical order,
+then newest generation or version first. With --format json, the secrets are
+printed as a JSON array with a fixed set of fields in a fixed order and
+timestamps in UTC, so the output of two runs over unchanged secrets is
+byte-for-byte identical. An empty list is printed as [].
 `, "\n"),
 	Example: strings.Trim(`
   # List all secrets in the bucket "my-secrets"
@@ -312,6 +1185,18 @@ the "access" command instead.
 
   # List all generations of all secrets in the bucket "my-secrets"
   berglas list my-secrets --all-generations
+
+  # Include the size, checksum, and KMS key of each generation
+  berglas list my-secrets --all-generations --with-metadata
+
+  # List disabled versions of all secrets in the project "my-project"
+  berglas list sm://my-project --all-generations --state DISABLED
+
+  # Print nothing, rather than "No secrets found", for an empty bucket
+  berglas list my-secrets --quiet
+
+  # Print the secrets and their metadata as JSON, for diffing between runs
+  berglas list sm://my-project --with-metadata --format json
 `, "\n"),
 	Args: cobra.ExactArgs(1),
 	RunE: listRun,
@@ -333,6 +1218,21 @@ regular workflow. Secrets will be migrated as-is with the following caveats:
   are random integers. Versions in Secret Manager are auto-incrementing. While
   relative ordering will be preserved, the versions will differ.
 
+- IAM bindings are not copied by default. Run with --with-iam to grant each
+  member with roles/storage.legacyObjectReader on the Cloud Storage object the
+  roles/secretmanager.secretAccessor role on the migrated secret. A report mapping
+  each secret to the granted members is printed at the end. Note that
+  bucket-level and project-level bindings are not copied.
+
+Failures do not stop the migration. Each failure is reported and the command
+exits with an error after all secrets are processed. Run with --verify to access
+each migrated secret and compare it byte-for-byte against the source, and with
+--delete-source-after to delete sources once all of their generations migrated
+(and verified) successfully. Use --report to write a JSON summary of migrated,
+skipped, and failed secrets. With --delete-source-after, the command asks you to
+type the bucket name before starting when run from a terminal; use --yes to
+skip the prompt.
+
 This command is intentionally a slow and non-parallelized operation to both
 avoid quota limits and to discourage recurrent use.
 `, "\n"),
@@ -340,34 +1240,176 @@ avoid quota limits and to discourage recurrent use.
   # Migrate all secrets in the "my-secrets" bucket to Secret Manager in the
   # project "my-project"
   berglas migrate my-secrets --project my-project
+
+  # Migrate all secrets and copy their object-level IAM members
+  berglas migrate my-secrets --project my-project --with-iam
+
+  # Migrate, verify, and delete the sources, saving a report for auditing
+  berglas migrate my-secrets --project my-project \
+    --verify --delete-source-after --report report.json
 `, "\n"),
 	Args: cobra.ExactArgs(1),
 	RunE: migrateRun,
 }
 
-var revokeCmd = &cobra.Command{
-	Use:   "revoke SECRET",
-	Short: "Revoke access to a secret",
+var parseRefCmd = &cobra.Command{
+	Use:   "parse-ref REF",
+	Short: "Print the parsed parts of a reference",
 	Long: strings.Trim(`
-Revoke IAM access to an existing secret for a given list of members. The secret
-must exist for access to be revoked.
+Parses a reference exactly as the other commands do, including aliases, and
+prints its parts: the type, project and name or bucket and object, version or
+generation, destination, and query parameters. External tools can use this
+instead of re-implementing the reference syntax. No API calls are made.
 
-When executed, this command revokes the following IAM permissions for each
-member:
+The JSON output is described by a JSON Schema, which --schema prints.
+`, "\n"),
+	Example: strings.Trim(`
+  # Print the parts of a reference as JSON
+  berglas parse-ref "sm://my-project/api-key?destination=tempfile#3"
 
-  - roles/storage.legacyObjectReader on the Cloud Storage object
-  - roles/cloudkms.cryptoKeyDecrypter on the Cloud KMS crypto key
+  # Print the JSON Schema for the output
+  berglas parse-ref --schema
+`, "\n"),
+	Args: cobra.MaximumNArgs(1),
+	RunE: parseRefRun,
+}
 
-If the member is not granted the IAM permissions, no action is taken.
-Specifically, this does not return an error if the member did not originally
-have permission to access the secret.
+var pruneCmd = &cobra.Command{
+	Use:   "prune BUCKET",
+	Short: "Delete expired secrets in a bucket",
+	Long: strings.Trim(`
+Deletes all secrets in the given Google Cloud Storage bucket whose expiration
+time has passed, including all of their generations. Secrets are given an
+expiration with the --expiration flag on "create" or "update". Secrets without
+an expiration are never deleted.
 
-Members must be specified with their type, for example:
+When run from a terminal without --dry-run, the command asks you to type the
+bucket name before deleting anything. Use --yes to skip the prompt.
+`, "\n"),
+	Example: strings.Trim(`
+  # Delete expired secrets in the bucket "my-secrets"
+  berglas prune my-secrets
+
+  # Show which secrets would be deleted, without deleting them
+  berglas prune my-secrets --dry-run
+`, "\n"),
+	Args: cobra.ExactArgs(1),
+	RunE: pruneRun,
+}
+
+var pruneGenerationsCmd = &cobra.Command{
+	Use:   "prune-generations SECRET",
+	Short: "Delete old generations of a secret",
+	Long: strings.Trim(`
+Deletes the noncurrent generations of a Cloud Storage secret beyond the --keep
+most recent, including the live generation. The live generation is never
+deleted. This complements a bucket-wide lifecycle rule for secrets that need
+stricter history trimming.
+
+When run from a terminal without --dry-run, the command asks you to type the
+secret name before deleting anything. Use --yes to skip the prompt.
+`, "\n"),
+	Example: strings.Trim(`
+  # Keep only the 5 most recent generations of "api-key"
+  berglas prune-generations my-secrets/api-key --keep 5
+
+  # Show which generations would be deleted, without deleting them
+  berglas prune-generations my-secrets/api-key --keep 5 --dry-run
+`, "\n"),
+	Args: cobra.ExactArgs(1),
+	RunE: pruneGenerationsRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report sm://PROJECT | BUCKET",
+	Short: "Report an inventory of secrets with an estimated cost",
+	Long: strings.Trim(`
+Reports an inventory of the secrets in a Secret Manager project or Cloud
+Storage bucket, without reading their values unless --with-sizes is given. For
+each secret, this includes the number of versions, the total size, the
+replication locations (Secret Manager) or KMS key (Cloud Storage), when it was
+last updated, and an estimated monthly cost. Totals are included at the end.
+Last access times are not available from the APIs and are not reported.
+
+The cost estimate is derived from unit prices:
+
+  - Secret Manager: each active version, per replica location
+  - Cloud Storage: each GiB stored, including noncurrent generations
+  - Cloud KMS: each key used by the secrets, as one key version
+
+The defaults are list prices for Cloud Storage Standard in a single region and
+Cloud KMS software keys. They can be overridden with --pricing, a JSON file
+with any of "secret_manager_version", "storage_gib", and "kms_key_version" in
+USD per month. Access operations are not included.
+`, "\n"),
+	Example: strings.Trim(`
+  # Report on the secrets in the project "my-project" as JSON
+  berglas report sm://my-project
+
+  # Report on the secrets in the bucket "my-secrets" as CSV with custom prices
+  berglas report my-secrets --format csv --pricing pricing.json
+`, "\n"),
+	Args: cobra.ExactArgs(1),
+	RunE: reportRun,
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore SECRET",
+	Short: "Recover a destroyed Secret Manager version",
+	Long: strings.Trim(`
+Recovers a Secret Manager version that was destroyed with "berglas delete
+--destroy-ttl", or while the secret had a version destroy TTL, and that is
+still awaiting destruction. The version is enabled again, which cancels its
+destruction.
+
+SECRET must be a Secret Manager reference with a version. Versions that were
+destroyed without a TTL, or whose TTL has passed, cannot be recovered.
+`, "\n"),
+	Example: strings.Trim(`
+  # Recover version 3 of the secret "api-key"
+  berglas restore sm://my-project/api-key#3
+`, "\n"),
+	Args: cobra.ExactArgs(1),
+	RunE: restoreRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke SECRET",
+	Short: "Revoke access to a secret",
+	Long: strings.Trim(`
+Revoke IAM access to an existing secret for a given list of members. The secret
+must exist for access to be revoked.
+
+When executed, this command revokes the following IAM permissions for each
+member:
+
+  - roles/storage.legacyObjectReader on the Cloud Storage object
+  - roles/cloudkms.cryptoKeyDecrypter on the Cloud KMS crypto key
+
+If the member is not granted the IAM permissions, no action is taken.
+Specifically, this does not return an error if the member did not originally
+have permission to access the secret.
+
+Members must be specified with their type, for example:
 
   - domain:mydomain.com
   - group:group@mydomain.com
   - serviceAccount:xyz@gserviceaccount.com
   - user:user@mydomain.com
+
+Shorthand members are expanded unless --no-expand is given:
+
+  - jane@mydomain.com becomes user:jane@mydomain.com
+  - xyz@project.iam.gserviceaccount.com becomes serviceAccount:...
+  - sa:xyz@project becomes serviceAccount:xyz@project.iam.gserviceaccount.com
+
+With --scope project, the argument is a Secret Manager project (sm://PROJECT)
+and roles/secretmanager.secretAccessor is changed on the project's IAM policy,
+which applies to every secret in the project.
 `, "\n"),
 	Example: strings.Trim(`
   # Revoke access from a user
@@ -381,9 +1423,48 @@ Members must be specified with their type, for example:
   berglas revoke my-secrets/api-key \
     --member user:user@mydomain.com \
     --member serviceAccount:sa@project.iam.gserviceaccount.com
+
+  # Show which members would be removed without changing any policies
+  berglas revoke my-secrets/api-key --member user:user@mydomain.com --dry-run
+
+  # Revoke project-wide access to Secret Manager secrets
+  berglas revoke sm://my-project --scope project \
+    --member serviceAccount:sa@project.iam.gserviceaccount.com
 `, "\n"),
 	Args: cobra.ExactArgs(1),
 	RunE: revokeRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var statCmd = &cobra.Command{
+	Use:   "stat SECRET",
+	Short: "Describe a secret",
+	Long: strings.Trim(`
+Describes a secret without reading its value. This prints whether the secret
+exists, its storage backend, latest version or generation, the number of
+versions or generations, when it was last updated, its KMS key (Cloud Storage)
+or replication policy (Secret Manager), and the permissions the caller has on
+it.
+
+If SECRET is "-", newline-delimited references are read from stdin and one JSON
+object is printed per line (NDJSON) with the reference and either its
+description or an error.
+`, "\n"),
+	Example: strings.Trim(`
+  # Describe the secret "foo" from Secret Manager
+  berglas stat sm://my-project/foo
+
+  # Describe the secret "foo" from the bucket "my-secrets"
+  berglas stat my-secrets/foo
+
+  # Describe each secret listed in a file
+  berglas stat - < secrets.txt
+`, "\n"),
+	Args: cobra.ExactArgs(1),
+	RunE: statRun,
+
+	ValidArgsFunction: completeSecrets,
 }
 
 var updateCmd = &cobra.Command{
@@ -394,20 +1475,116 @@ Update an existing secret. If the secret does not exist, an error is returned.
 
 Run with --create-if-missing to force creation of the secret if it does not
 already exist.
+
+For Secret Manager secrets, run with --locations to change the replication
+locations. Secret Manager does not support changing replication in place, so
+the secret is re-created and all enabled versions and IAM bindings are copied
+to the new secret. Version numbers are not preserved.
 `, "\n"),
 	Example: strings.Trim(`
   # Update the secret named "api-key" with the contents "new-contents"
   berglas update my-secrets/api-key new-contents
 
+  # Update the secret named "api-key" from an environment variable
+  berglas update my-secrets/api-key env:API_KEY
+
   # Update the secret named "api-key" with a new KMS encryption key, keeping
   # the original secret value
   berglas update my-secrets/api-key --key=...
 
   # Update the secret named "api-key", creating it if it does not already exist
   berglas update my-secrets/api-key abcd1234 --create-if-missing --key...
+
+  # Move the Secret Manager secret "api-key" to user-managed replication,
+  # keeping the original secret value
+  berglas update sm://my-project/api-key --locations us-east1,us-west1
 `, "\n"),
 	Args: cobra.RangeArgs(1, 2),
 	RunE: updateRun,
+
+	ValidArgsFunction: completeSecrets,
+}
+
+var updateManyCmd = &cobra.Command{
+	Use:   "update-many",
+	Short: "Add a new version to many Secret Manager secrets",
+	Long: strings.Trim(`
+Adds a new version to many Secret Manager secrets at once, such as when
+rotating a CA bundle that is shared by hundreds of secrets. The updates are read
+from a YAML file given with --file (or stdin with "-"):
+
+  # The payload for every update, in the same format as the DATA argument of
+  # "berglas update" (a literal value, @FILE, or env:NAME).
+  data: "@ca-bundle.pem"
+  updates:
+    - secret: sm://my-project/ca-bundle
+    - secret: sm://other-project/ca-bundle
+      # Overrides the payload for this secret.
+      data: env:OTHER_CA_BUNDLE
+
+Updates run concurrently. A failed update does not stop the others, and the
+outcome of each one is printed when all have finished.
+`, "\n"),
+	Example: strings.Trim(`
+  # Rotate a shared CA bundle
+  berglas update-many --file updates.yaml
+
+  # Create any secret that does not exist yet
+  berglas update-many --file updates.yaml --create-if-missing
+`, "\n"),
+	Args: cobra.NoArgs,
+	RunE: updateManyRun,
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print version and build information",
+	Long: strings.Trim(`
+Prints the berglas version, git commit, build date, Go version, and platform.
+Run with --format json for machine-readable output.
+`, "\n"),
+	Example: strings.Trim(`
+  # Print build information as JSON
+  berglas version --format json
+`, "\n"),
+	Args: cobra.NoArgs,
+	RunE: versionRun,
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch SECRET",
+	Short: "Watch a secret for new versions",
+	Long: strings.Trim(`
+Watches a secret and reports each new version until interrupted.
+
+For Secret Manager secrets with a configured Pub/Sub topic, and for Cloud
+Storage secrets in a bucket with a Pub/Sub notification covering the object, a
+temporary subscription is created on the topic so new versions are reported as
+soon as they are added. Otherwise the latest version is polled every
+--interval.
+
+Run with --exec to run a shell command for each new version. The command
+receives the secret's plaintext on stdin and its version in the
+BERGLAS_SECRET_VERSION environment variable. A failing command is reported but
+does not stop the watch.
+`, "\n"),
+	Example: strings.Trim(`
+  # Print a line each time a new version of "api-key" is added
+  berglas watch sm://my-project/api-key
+
+  # Reload a service each time "api-key" is rotated
+  berglas watch sm://my-project/api-key --exec 'systemctl reload my-service'
+
+  # Watch a Cloud Storage secret
+  berglas watch my-secrets/api-key --exec 'systemctl reload my-service'
+
+  # Write each new version to a file, polling every 10 seconds
+  berglas watch sm://my-project/api-key --interval 10s --exec 'cat > /etc/api-key'
+`, "\n"),
+	Args: cobra.ExactArgs(1),
+	RunE: watchRun,
+
+	ValidArgsFunction: completeSecrets,
 }
 
 func main() {
@@ -419,6 +1596,80 @@ func main() {
 		"Level at which to log")
 	rootCmd.PersistentFlags().BoolVar(&logDebug, "log-debug", false,
 		"Enable verbose source debug logging")
+	rootCmd.PersistentFlags().StringVar(&logHashKey, "log-hash-key",
+		os.Getenv(envLogHashKey),
+		"Replace secret, bucket, project, and key names in logs with an HMAC "+
+			"under this key")
+	rootCmd.PersistentFlags().Float64Var(&logDebugSampleRate, "log-debug-sample-rate", 1,
+		"Fraction of debug log entries to write, between 0 and 1")
+	rootCmd.PersistentFlags().BoolVar(&showProgress, "progress", false,
+		"Show progress for long-running operations when stderr is a terminal")
+	rootCmd.PersistentFlags().BoolVar(&showStats, "stats", false,
+		"Print a summary of the API calls made and the time taken on stderr "+
+			"after the command")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0,
+		"Maximum time to wait for the command to complete (e.g. 30s, 5m). "+
+			"If unspecified, a per-command default is used. A negative value "+
+			"disables the deadline.")
+	rootCmd.PersistentFlags().StringVar(&storageEndpoint, "storage-endpoint", "",
+		"Custom Cloud Storage API endpoint (e.g. a Private Service Connect endpoint)")
+	rootCmd.PersistentFlags().StringVar(&s3Endpoint, "s3-endpoint", "",
+		"Custom endpoint for s3:// secrets (e.g. a MinIO server)")
+	rootCmd.PersistentFlags().StringVar(&secretManagerEndpoint, "secret-manager-endpoint", "",
+		"Custom Secret Manager API endpoint")
+	rootCmd.PersistentFlags().StringVar(&kmsEndpoint, "kms-endpoint", "",
+		"Custom Cloud KMS API endpoint")
+	rootCmd.PersistentFlags().BoolVar(&useMTLS, "use-mtls",
+		strings.EqualFold(os.Getenv(envUseClientCertificate), "true"),
+		"Authenticate to Google APIs with the device client certificate over "+
+			"mTLS, failing if no certificate is available")
+	rootCmd.PersistentFlags().BoolVar(&readOnly, "read-only",
+		strings.EqualFold(os.Getenv(envReadOnly), "true"),
+		"Refuse to run commands that modify secrets or permissions")
+	rootCmd.PersistentFlags().BoolVar(&ignorePolicy, "ignore-policy", false,
+		"Resolve secrets even if their file-only or env-only policy forbids it")
+	rootCmd.PersistentFlags().BoolVar(&secretManagerPolicy, "secret-manager-policy", false,
+		"Enforce the policy label of Secret Manager secrets, which requires "+
+			"secretmanager.secrets.get")
+	rootCmd.PersistentFlags().BoolVar(&rollbackProtection, "rollback-protection", false,
+		"Stamp Storage secrets with a write counter and fail reads of a restored older generation")
+	rootCmd.PersistentFlags().StringVar(&justification, "justification",
+		os.Getenv(envJustification),
+		"Reason for the request, such as a change ticket ID, sent with Secret "+
+			"Manager and Cloud KMS requests")
+	rootCmd.PersistentFlags().BoolVar(&bindAAD, "bind-aad", false,
+		"Bind the KMS additional authenticated data of new Storage secrets to "+
+			"the bucket and object name")
+	rootCmd.PersistentFlags().StringVar(&aadContext, "aad-context",
+		os.Getenv(envAADContext),
+		"Context string bound into the additional authenticated data of Storage "+
+			"secrets (implies --bind-aad)")
+
+	rootCmd.PersistentFlags().StringVar(&cacheKey, "cache-key", os.Getenv(envCacheKey),
+		"Cache accessed secrets on disk, encrypted with this Cloud KMS key or key URI")
+	rootCmd.PersistentFlags().DurationVar(&cacheTTL, "cache-ttl", berglas.DefaultDiskCacheTTL,
+		"How long to serve secrets from the on-disk cache")
+	rootCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", "",
+		"Directory of the on-disk cache (defaults to the user cache directory)")
+	rootCmd.PersistentFlags().DurationVar(&cacheMaxStaleness, "cache-max-staleness", 0,
+		"Serve expired cached secrets up to this long past expiry when the backend is unavailable (0 disables)")
+	rootCmd.PersistentFlags().IntVar(&circuitBreaker, "circuit-breaker", 0,
+		"Stop accessing a backend after this many consecutive unavailable errors (0 disables)")
+
+	rootCmd.PersistentFlags().StringVar(&aliasesFile, "aliases", os.Getenv(envAliases),
+		"Path to a file of NAME=REFERENCE lines defining secret aliases")
+
+	rootCmd.PersistentFlags().StringVar(&namingPolicyFile, "naming-policy", os.Getenv(envNamingPolicy),
+		"Path to a naming policy file that new and updated secret names must match")
+
+	rootCmd.PersistentFlags().StringVar(&storageEncryptionKeyFile, "storage-encryption-key-file", "",
+		"Path to a base64 customer-supplied encryption key for Storage objects (or set "+
+			envStorageEncryptionKey+")")
+
+	rootCmd.PersistentFlags().StringVar(&preferRegion, "prefer-region", os.Getenv(envPreferRegion),
+		"Region the caller runs in, used to flag Secret Manager secrets with no replica there")
+	rootCmd.PersistentFlags().DurationVar(&hedgeDelay, "hedge-delay", 0,
+		"Send a second request when accessing a secret takes longer than this (0 disables)")
 
 	rootCmd.AddCommand(accessCmd)
 	accessCmd.Flags().Int64Var(&accessGeneration, "generation", 0,
@@ -427,6 +1678,10 @@ func main() {
 		"please use hash notation instead (e.g. my-secrets/api-key#12345)"); err != nil {
 		panic(err)
 	}
+	accessCmd.Flags().BoolVar(&accessCiphertext, "ciphertext", false,
+		"Print the stored ciphertext without decrypting it")
+	accessCmd.Flags().BoolVar(&accessMetadata, "include-metadata", false,
+		"Print a JSON object with the plaintext, version, update time, and checksum")
 
 	rootCmd.AddCommand(bootstrapCmd)
 	bootstrapCmd.Flags().StringVar(&projectID, "project", "",
@@ -447,41 +1702,202 @@ func main() {
 		"Name of the KMS key ring to create")
 	bootstrapCmd.Flags().StringVar(&kmsCryptoKey, "kms-key", "berglas-key",
 		"Name of the KMS key to create")
+	bootstrapCmd.Flags().BoolVar(&bootstrapExpirationLifecycle, "expiration-lifecycle", false,
+		"Add a bucket lifecycle rule that deletes secrets a day after they expire")
+	bootstrapCmd.Flags().StringVar(&bootstrapKMSProtectionLevel, "kms-protection-level", "SOFTWARE",
+		"Protection level of the KMS key to create (SOFTWARE or HSM)")
+	bootstrapCmd.Flags().DurationVar(&bootstrapKMSRotationPeriod, "kms-rotation-period", 30*24*time.Hour,
+		"Rotation period of the KMS key to create (at least 24h, negative to disable rotation)")
+	bootstrapCmd.Flags().StringToStringVar(&bootstrapKMSLabels, "kms-labels", nil,
+		"Labels to set on the KMS key to create (e.g. team=security,env=prod)")
+
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cachePurgeCmd)
+
+	rootCmd.AddCommand(canICmd)
+	canICmd.Flags().StringVar(&key, "key", "",
+		"KMS key to check for encryption (required to create Storage secrets)")
 
 	rootCmd.AddCommand(completionCmd)
 
+	rootCmd.AddCommand(copyCmd)
+	copyCmd.Flags().BoolVar(&copyRebind, "rebind", false,
+		"Re-encrypt the secret bound to the destination instead of copying the ciphertext")
+
 	rootCmd.AddCommand(createCmd)
 	createCmd.Flags().StringVar(&key, "key", "",
-		"KMS key to use for encryption")
+		"KMS key to use for encryption (Cloud KMS key name, aws-kms://ARN, or local-key://PATH)")
 	createCmd.Flags().StringSliceVar(&smLocations, "locations", nil,
 		"Comma-separated canonical IDs in which to replicate secrets (e.g. 'us-east1,us-west-1')")
+	createCmd.Flags().BoolVar(&forceLarge, "force-large", false,
+		"Allow Cloud Storage secrets larger than the default size limit")
+	createCmd.Flags().StringVar(&envelopeAlgorithm, "envelope-algorithm", "",
+		"Encrypt Storage secrets with this algorithm in the versioned envelope format (aes-256-gcm or xchacha20-poly1305)")
+	createCmd.Flags().StringVar(&requireProtectionLevel, "require-protection-level", "",
+		"Fail unless the KMS key has this protection level (e.g. HSM, EXTERNAL)")
+	createCmd.Flags().StringVar(&expiration, "expiration", "",
+		"Expire the Storage secret after a duration (e.g. 24h) or at an RFC 3339 time")
+	createCmd.Flags().BoolVar(&waitForConsistency, "wait-for-consistency", false,
+		"Wait until the new Secret Manager secret can be accessed before returning")
+	createCmd.Flags().StringVar(&secretPolicy, "policy", "",
+		"Restrict how the secret may be resolved (file-only or env-only)")
+	createCmd.Flags().StringVar(&secretContentType, "content-type", "",
+		"Media type of the secret value, recorded for consumers (e.g. application/json)")
+
+	rootCmd.AddCommand(decryptOfflineCmd)
+	decryptOfflineCmd.Flags().StringVar(&decryptOfflineDEKFile, "dek-file", "",
+		"Path to the unwrapped data encryption key")
+	if err := decryptOfflineCmd.MarkFlagRequired("dek-file"); err != nil {
+		panic(err)
+	}
 
 	rootCmd.AddCommand(deleteCmd)
+	deleteCmd.Flags().StringVar(&deleteFile, "file", "",
+		"File with one secret per line to delete (use - for stdin)")
+	deleteCmd.Flags().IntVar(&deleteParallelism, "parallelism", 8,
+		"Number of secrets to delete concurrently")
+	deleteCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false,
+		"Skip the confirmation prompt")
+	deleteCmd.Flags().StringVar(&deleteDestroyTTL, "destroy-ttl", "",
+		"Keep destroyed Secret Manager versions recoverable for this long (e.g. 7d)")
+
+	rootCmd.AddCommand(driftCmd)
+	driftCmd.Flags().StringArrayVar(&driftEnvFiles, "env-file", []string{".env"},
+		"Path to a .env file to check (may be given multiple times)")
+	driftCmd.Flags().BoolVar(&driftFailOnDrift, "fail-on-drift", false,
+		"Exit non-zero if any variable is behind the latest version")
 
 	rootCmd.AddCommand(editCmd)
 	editCmd.Flags().StringVar(&editor, "editor", "",
 		"Editor program to use. If unspecified, this defaults to $VISUAL or "+
 			"$EDITOR in that order.")
+	editCmd.Flags().StringVar(&editFromFile, "from-file", "",
+		"Read the new value from a file instead of opening an editor (use - for stdin)")
+	editCmd.Flags().StringVar(&editFormat, "format", "text",
+		"Output format (text or json). JSON describes the outcome, including failures")
 	editCmd.Flags().BoolVar(&createIfMissing, "create-if-missing", false,
 		"Create the secret if it doesn't exist")
 	editCmd.Flags().StringVar(&key, "key", "",
 		"KMS key to use for encryption (only used when secret doesn't exist)")
+	editCmd.Flags().BoolVar(&forceLarge, "force-large", false,
+		"Allow Cloud Storage secrets larger than the default size limit")
+	editCmd.Flags().StringVar(&envelopeAlgorithm, "envelope-algorithm", "",
+		"Encrypt Storage secrets with this algorithm in the versioned envelope format (aes-256-gcm or xchacha20-poly1305)")
+
+	rootCmd.AddCommand(envCmd)
+	envCmd.Flags().StringVar(&envExportFile, "file", "",
+		"File with one NAME=REFERENCE pair per line (use - for stdin)")
+	envCmd.Flags().StringVar(&envExportFormat, "format", string(envexport.FormatDotenv),
+		"Output format (dotenv, gha, or k8s)")
+	envCmd.Flags().StringVar(&envExportName, "name", "",
+		"Name of the Kubernetes Secret (required with --format k8s)")
+	envCmd.Flags().StringVar(&envExportNamespace, "namespace", "",
+		"Namespace of the Kubernetes Secret")
+	envCmd.Flags().BoolVar(&envExportSort, "sort", false,
+		"Sort the variables by name")
+
+	rootCmd.AddCommand(escrowCmd)
+	escrowCmd.AddCommand(escrowExportCmd)
+	escrowExportCmd.Flags().StringVar(&escrowWrappingKey, "wrapping-key", "",
+		"Cloud KMS crypto key version or path to a PEM-encoded RSA public key")
+	if err := escrowExportCmd.MarkFlagRequired("wrapping-key"); err != nil {
+		panic(err)
+	}
+	escrowCmd.AddCommand(escrowRestoreCmd)
+	escrowRestoreCmd.Flags().StringVar(&escrowPrivateKey, "private-key", "",
+		"Path to the PEM-encoded RSA private key for a local wrapping key")
+	escrowRestoreCmd.Flags().StringVar(&escrowTo, "to", "",
+		"Secret to restore to, defaults to the original secret")
+	escrowRestoreCmd.Flags().StringVar(&key, "key", "",
+		"KMS key to encrypt the restored secret, defaults to the original key")
 
 	rootCmd.AddCommand(execCmd)
 	execCmd.Flags().BoolVar(&execLocal, "local", false, "")
 	if err := execCmd.Flags().MarkDeprecated("local", "there is no replacement"); err != nil {
 		panic(err)
 	}
+	execCmd.Flags().StringArrayVar(&execWait, "wait-for", nil,
+		"Dependency to wait for before starting (tcp://HOST:PORT or a file path)")
+	execCmd.Flags().BoolVar(&execSupervise, "supervise", false,
+		"Keep berglas running as the parent, forwarding signals and reaping zombies")
+	execCmd.Flags().BoolVar(&execDeep, "deep", false,
+		"Also resolve references nested in JSON or YAML values")
+	execCmd.Flags().BoolVar(&execStrict, "strict", false,
+		"Fail instead of warning when references remain in the environment after resolution")
+
+	rootCmd.AddCommand(generateCmd)
+	generateCmd.Flags().StringVar(&generateType, "type", "",
+		"Type of material to generate (rsa:BITS, ssh-ed25519, or self-signed-cert)")
+	if err := generateCmd.MarkFlagRequired("type"); err != nil {
+		panic(err)
+	}
+	generateCmd.Flags().StringVar(&generateCommonName, "cn", "",
+		"Common name of the self-signed certificate")
+	generateCmd.Flags().StringVar(&generateTTL, "ttl", "",
+		"How long the self-signed certificate is valid for (e.g. 90d or 2160h)")
+	generateCmd.Flags().StringVar(&generatePublicSecret, "public-secret", "",
+		"Store the public half as this secret instead of writing it to stdout")
+	generateCmd.Flags().StringVar(&key, "key", "",
+		"KMS key to use for encryption (Cloud KMS key name, aws-kms://ARN, or local-key://PATH)")
+	generateCmd.Flags().StringSliceVar(&smLocations, "locations", nil,
+		"Comma-separated canonical IDs in which to replicate secrets (e.g. 'us-east1,us-west-1')")
+	generateCmd.Flags().StringVar(&envelopeAlgorithm, "envelope-algorithm", "",
+		"Encrypt Storage secrets with this algorithm in the versioned envelope format (aes-256-gcm or xchacha20-poly1305)")
+	generateCmd.Flags().StringVar(&requireProtectionLevel, "require-protection-level", "",
+		"Fail unless the KMS key has this protection level (e.g. HSM, EXTERNAL)")
+	generateCmd.Flags().StringVar(&expiration, "expiration", "",
+		"Expire the Storage secrets after a duration (e.g. 24h) or at an RFC 3339 time")
+	generateCmd.Flags().BoolVar(&waitForConsistency, "wait-for-consistency", false,
+		"Wait until new Secret Manager secrets can be accessed before returning")
+	generateCmd.Flags().StringVar(&secretPolicy, "policy", "",
+		"Restrict how the secrets may be resolved (file-only or env-only)")
 
 	rootCmd.AddCommand(grantCmd)
 	grantCmd.Flags().StringSliceVar(&members, "member", nil,
 		"Member to add")
+	grantCmd.Flags().BoolVar(&membersNoExpand, "no-expand", false,
+		"Do not expand shorthand members like bare emails or sa:NAME@PROJECT")
+	grantCmd.Flags().BoolVar(&membersDryRun, "dry-run", false,
+		"Show the changes that would be made without updating IAM policies")
+	grantCmd.Flags().StringVar(&membersScope, "scope", string(berglas.SecretManagerScopeSecret),
+		"Secret Manager scope: secret, or project for every secret in sm://PROJECT")
+	grantCmd.Flags().BoolVar(&allowACLFallback, "allow-acl-fallback", false,
+		"Use object ACLs if the bucket does not support object IAM policies")
+	grantCmd.Flags().StringVar(&grantReason, "reason", "",
+		"Reason for the grant, such as a ticket number, recorded in the IAM condition (requires --expires)")
+	grantCmd.Flags().StringVar(&grantExpires, "expires", "",
+		"End access after a duration (e.g. 720h), on a date, or at an RFC 3339 time (Secret Manager only)")
+
+	rootCmd.AddCommand(iamCmd)
+	iamCmd.AddCommand(iamShowCmd)
+	iamShowCmd.Flags().StringVar(&membersScope, "scope", string(berglas.SecretManagerScopeSecret),
+		"Secret Manager scope: secret, or project for every secret in sm://PROJECT")
+
+	rootCmd.AddCommand(kvCmd)
+	kvCmd.AddCommand(kvGetCmd)
+	kvCmd.AddCommand(kvSetCmd)
+	kvCmd.AddCommand(kvDeleteCmd)
+	kvCmd.AddCommand(kvListCmd)
+
+	rootCmd.AddCommand(lintCmd)
+	lintCmd.Flags().StringArrayVar(&lintFiles, "file", nil,
+		"File to lint (use - for stdin), may be given multiple times")
+	lintCmd.Flags().BoolVar(&lintCheckAccess, "check-access", false,
+		"Check that each secret exists and is accessible by the caller")
 
 	rootCmd.AddCommand(listCmd)
 	listCmd.Flags().BoolVar(&listGenerations, "all-generations", false,
 		"List all versions of secrets")
 	listCmd.Flags().StringVar(&listPrefix, "prefix", "",
 		"List secrets that match prefix")
+	listCmd.Flags().StringSliceVar(&listStates, "state", nil,
+		"Only list Secret Manager versions in the given states (ENABLED, DISABLED, DESTROYED)")
+	listCmd.Flags().BoolVar(&listWithMetadata, "with-metadata", false,
+		"Include size and checksum (Secret Manager reads each payload) and KMS key (Cloud Storage)")
+	listCmd.Flags().BoolVarP(&listQuiet, "quiet", "q", false,
+		"Print nothing when there are no secrets")
+	listCmd.Flags().StringVar(&listFormat, "format", "table",
+		"Output format (table or json)")
 
 	rootCmd.AddCommand(migrateCmd)
 	migrateCmd.Flags().StringVar(&projectID, "project", "",
@@ -489,27 +1905,138 @@ func main() {
 	if err := migrateCmd.MarkFlagRequired("project"); err != nil {
 		panic(err)
 	}
+	migrateCmd.Flags().BoolVar(&migrateWithIAM, "with-iam", false,
+		"Grant members with access to the source object access to the migrated secret")
+	migrateCmd.Flags().BoolVar(&migrateVerify, "verify", false,
+		"Access each migrated secret and compare it to the source")
+	migrateCmd.Flags().BoolVar(&migrateDeleteSource, "delete-source-after", false,
+		"Delete each source secret after all of its generations migrated successfully")
+	migrateCmd.Flags().StringVar(&migrateReportPath, "report", "",
+		"Write a JSON summary of the migration to the given file (use - for "+
+			"stdout, which moves progress output to stderr)")
+	migrateCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false,
+		"Skip the confirmation prompt")
+
+	rootCmd.AddCommand(parseRefCmd)
+	parseRefCmd.Flags().StringVar(&parseRefFormat, "format", "json",
+		"Output format (json or text)")
+	parseRefCmd.Flags().BoolVar(&parseRefSchema, "schema", false,
+		"Print the JSON Schema for the output instead of parsing a reference")
+
+	rootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().StringVar(&listPrefix, "prefix", "",
+		"Only prune secrets that match prefix")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false,
+		"Print expired secrets without deleting them")
+	pruneCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false,
+		"Skip the confirmation prompt")
+
+	rootCmd.AddCommand(pruneGenerationsCmd)
+	pruneGenerationsCmd.Flags().IntVar(&pruneKeep, "keep", 0,
+		"Number of most recent generations to keep, including the live generation")
+	if err := pruneGenerationsCmd.MarkFlagRequired("keep"); err != nil {
+		panic(err)
+	}
+	pruneGenerationsCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false,
+		"Print old generations without deleting them")
+	pruneGenerationsCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false,
+		"Skip the confirmation prompt")
+
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.Flags().StringVar(&reportFormat, "format", "json",
+		"Output format (json or csv)")
+	reportCmd.Flags().StringVar(&reportPricing, "pricing", "",
+		"JSON file with unit prices to use for the cost estimate")
+	reportCmd.Flags().BoolVar(&reportWithSizes, "with-sizes", false,
+		"Include Secret Manager version sizes, which requires accessing their values")
+
+	rootCmd.AddCommand(restoreCmd)
 
 	rootCmd.AddCommand(revokeCmd)
 	revokeCmd.Flags().StringSliceVar(&members, "member", nil,
 		"Member to remove")
+	revokeCmd.Flags().BoolVar(&membersNoExpand, "no-expand", false,
+		"Do not expand shorthand members like bare emails or sa:NAME@PROJECT")
+	revokeCmd.Flags().BoolVar(&membersDryRun, "dry-run", false,
+		"Show the changes that would be made without updating IAM policies")
+	revokeCmd.Flags().StringVar(&membersScope, "scope", string(berglas.SecretManagerScopeSecret),
+		"Secret Manager scope: secret, or project for every secret in sm://PROJECT")
+	revokeCmd.Flags().BoolVar(&allowACLFallback, "allow-acl-fallback", false,
+		"Use object ACLs if the bucket does not support object IAM policies")
+
+	rootCmd.AddCommand(statCmd)
 
 	rootCmd.AddCommand(updateCmd)
 	updateCmd.Flags().BoolVar(&createIfMissing, "create-if-missing", false,
 		"Create the secret if it does not already exist")
 	updateCmd.Flags().StringVar(&key, "key", "",
-		"KMS key to use for re-encryption")
+		"KMS key to use for re-encryption (Cloud KMS key name, aws-kms://ARN, or local-key://PATH)")
+	updateCmd.Flags().StringSliceVar(&smLocations, "locations", nil,
+		"Comma-separated canonical IDs in which to replicate secrets (e.g. 'us-east1,us-west-1')")
+	updateCmd.Flags().StringVar(&expiration, "expiration", "",
+		"Expire the Storage secret after a duration (e.g. 24h) or at an RFC 3339 time")
+	updateCmd.Flags().BoolVar(&forceLarge, "force-large", false,
+		"Allow Cloud Storage secrets larger than the default size limit")
+	updateCmd.Flags().StringVar(&envelopeAlgorithm, "envelope-algorithm", "",
+		"Encrypt Storage secrets with this algorithm in the versioned envelope format (aes-256-gcm or xchacha20-poly1305)")
+
+	rootCmd.AddCommand(updateManyCmd)
+	updateManyCmd.Flags().StringVar(&updateManyFile, "file", "",
+		"YAML file with the updates to apply (use - for stdin)")
+	if err := updateManyCmd.MarkFlagRequired("file"); err != nil {
+		panic(err)
+	}
+	updateManyCmd.Flags().BoolVar(&createIfMissing, "create-if-missing", false,
+		"Create secrets that do not already exist")
+
+	rootCmd.AddCommand(versionCmd)
+	versionCmd.Flags().StringVar(&versionFormat, "format", "text",
+		"Output format (text or json)")
+
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().StringVar(&watchExec, "exec", "",
+		"Shell command to run for each new version")
+	watchCmd.Flags().DurationVar(&watchInterval, "interval", berglas.DefaultWatchPollInterval,
+		"Interval at which to poll for new versions when Pub/Sub is unavailable")
 
 	ctx, cancel := signal.NotifyContext(context.Background(),
 		syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	if err := rootCmd.ExecuteContext(ctx); err != nil {
+	// The per-command deadline is only known once flags are parsed.
+	timeoutCtx, cancelTimeout := ctx, context.CancelFunc(func() {})
+	defer func() { cancelTimeout() }()
+
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		if d := commandTimeout(cmd); d > 0 {
+			timeoutCtx, cancelTimeout = context.WithTimeout(cmd.Context(), d)
+			cmd.SetContext(timeoutCtx)
+		}
+	}
+
+	start := time.Now()
+	err := rootCmd.ExecuteContext(ctx)
+	if showStats {
+		printStats(stderr, time.Since(start), cliStats.Services())
+	}
+
+	if err != nil {
+		timedOut := errors.Is(timeoutCtx.Err(), context.DeadlineExceeded)
+		cancelTimeout()
 		cancel()
 
 		code := 1
 		if terr, ok := err.(*exitError); ok {
 			code = terr.code
+
+			// A nil error means the failure was already reported.
+			if terr.err == nil {
+				os.Exit(code)
+			}
+		}
+
+		if timedOut {
+			err = fmt.Errorf("%w (command timed out, use --timeout to change the deadline)", err)
 		}
 
 		fmt.Fprintf(stderr, "%s\n", err)
@@ -518,9 +2045,25 @@ func main() {
 }
 
 func accessRun(cmd *cobra.Command, args []string) error {
-	ctx, client, err := clientWithContext(cmd.Context())
-	if err != nil {
-		return misuseError(err)
+	if args[0] == "-" {
+		if accessCiphertext || accessGeneration != 0 {
+			return misuseError(fmt.Errorf("--ciphertext and --generation cannot " +
+				"be used when reading references from stdin"))
+		}
+
+		ctx, client, err := clientWithContext(cmd.Context())
+		if err != nil {
+			return misuseError(err)
+		}
+		defer client.Close()
+
+		return runBatch(func(ref *berglas.Reference) (any, error) {
+			secret, err := readReference(ctx, client, ref)
+			if err != nil {
+				return nil, err
+			}
+			return newAccessResult(secret), nil
+		})
 	}
 
 	// Deprecated - update to new syntax
@@ -532,6 +2075,47 @@ func accessRun(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return misuseError(err)
 	}
+	if accessCiphertext && ref.Type() != berglas.ReferenceTypeStorage {
+		return misuseError(fmt.Errorf("--ciphertext is only supported for " +
+			"Cloud Storage secrets"))
+	}
+	if accessCiphertext && accessMetadata {
+		return misuseError(fmt.Errorf("--ciphertext and --include-metadata " +
+			"cannot be used together"))
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	if accessMetadata {
+		secret, err := readReference(ctx, client, ref)
+		if err != nil {
+			return apiError(err)
+		}
+
+		b, err := json.MarshalIndent(newAccessResult(secret), "", "  ")
+		if err != nil {
+			return apiError(fmt.Errorf("failed to marshal result: %w", err))
+		}
+		fmt.Fprintf(stdout, "%s\n", b)
+		return nil
+	}
+
+	if accessCiphertext {
+		ct, err := client.ReadCiphertext(ctx, &berglas.StorageReadRequest{
+			Bucket:     ref.Bucket(),
+			Object:     ref.Object(),
+			Generation: ref.Generation(),
+		})
+		if err != nil {
+			return apiError(err)
+		}
+		fmt.Fprintf(stdout, "%s", ct.Blob)
+		return nil
+	}
 
 	switch t := ref.Type(); t {
 	case berglas.ReferenceTypeSecretManager:
@@ -554,6 +2138,15 @@ func accessRun(cmd *cobra.Command, args []string) error {
 			return apiError(err)
 		}
 		fmt.Fprintf(stdout, "%s", plaintext)
+	case berglas.ReferenceTypeS3:
+		plaintext, err := client.Access(ctx, &berglas.StorageS3AccessRequest{
+			Bucket: ref.Bucket(),
+			Object: ref.Object(),
+		})
+		if err != nil {
+			return apiError(err)
+		}
+		fmt.Fprintf(stdout, "%s", plaintext)
 	default:
 		return misuseError(fmt.Errorf("unknown type %T", t))
 	}
@@ -561,11 +2154,64 @@ func accessRun(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// accessResult is the result of an access, printed as JSON with
+// --include-metadata.
+type accessResult struct {
+	// Plaintext is the secret value, base64-encoded in JSON.
+	Plaintext []byte `json:"plaintext"`
+
+	// Version is the Secret Manager version or Cloud Storage generation. It is
+	// empty for S3 secrets, which are not versioned.
+	Version string `json:"version,omitempty"`
+
+	// UpdateTime is when the version was created.
+	UpdateTime time.Time `json:"update_time"`
+
+	// CRC32C is the CRC32C checksum (Castagnoli polynomial) of the plaintext.
+	CRC32C uint32 `json:"crc32c"`
+}
+
+// newAccessResult builds the access result for the given secret.
+func newAccessResult(secret *berglas.Secret) *accessResult {
+	return &accessResult{
+		Plaintext:  secret.Plaintext,
+		Version:    secretVersion(secret),
+		UpdateTime: secret.UpdatedAt,
+		CRC32C:     crc32.Checksum(secret.Plaintext, crc32.MakeTable(crc32.Castagnoli)),
+	}
+}
+
+// readReference reads the secret and its metadata for the given reference.
+func readReference(ctx context.Context, client *berglas.Client, ref *berglas.Reference) (*berglas.Secret, error) {
+	switch t := ref.Type(); t {
+	case berglas.ReferenceTypeSecretManager:
+		return client.Read(ctx, &berglas.SecretManagerReadRequest{
+			Project: ref.Project(),
+			Name:    ref.Name(),
+			Version: ref.Version(),
+		})
+	case berglas.ReferenceTypeStorage:
+		return client.Read(ctx, &berglas.StorageReadRequest{
+			Bucket:     ref.Bucket(),
+			Object:     ref.Object(),
+			Generation: ref.Generation(),
+		})
+	case berglas.ReferenceTypeS3:
+		return client.Read(ctx, &berglas.StorageS3ReadRequest{
+			Bucket: ref.Bucket(),
+			Object: ref.Object(),
+		})
+	default:
+		return nil, fmt.Errorf("unknown type %T", t)
+	}
+}
+
 func bootstrapRun(cmd *cobra.Command, args []string) error {
 	ctx, client, err := clientWithContext(cmd.Context())
 	if err != nil {
 		return misuseError(err)
 	}
+	defer client.Close()
 
 	if err := client.Bootstrap(ctx, &berglas.BootstrapRequest{
 		ProjectID:      projectID,
@@ -574,6 +2220,12 @@ func bootstrapRun(cmd *cobra.Command, args []string) error {
 		KMSLocation:    kmsLocation,
 		KMSKeyRing:     kmsKeyRing,
 		KMSCryptoKey:   kmsCryptoKey,
+
+		KMSProtectionLevel: strings.ToUpper(bootstrapKMSProtectionLevel),
+		KMSRotationPeriod:  bootstrapKMSRotationPeriod,
+		KMSLabels:          bootstrapKMSLabels,
+
+		ExpirationLifecycle: bootstrapExpirationLifecycle,
 	}); err != nil {
 		return apiError(err)
 	}
@@ -600,118 +2252,334 @@ func bootstrapRun(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func completionRun(cmd *cobra.Command, args []string) error {
-	switch shell := args[0]; shell {
-	case "bash":
-		if err := rootCmd.GenBashCompletion(stdout); err != nil {
-			err = fmt.Errorf("failed to generate bash completion: %w", err)
-			return apiError(err)
-		}
-	case "zsh":
-		if err := rootCmd.GenZshCompletion(stdout); err != nil {
-			err = fmt.Errorf("failed to generate zsh completion: %w", err)
-			return apiError(err)
-		}
-
-		// enable the `source <(berglas completion SHELL)` pattern for zsh
-		if _, err := io.WriteString(stdout, "compdef _berglas berglas\n"); err != nil {
-			err = fmt.Errorf("failed to run compdef: %w", err)
-			return apiError(err)
-		}
-	default:
-		err := fmt.Errorf("unknown completion %q", shell)
+func cachePurgeRun(cmd *cobra.Command, args []string) error {
+	dir, err := diskCacheDir()
+	if err != nil {
 		return misuseError(err)
 	}
 
+	n, err := berglas.PurgeDiskCache(dir)
+	if err != nil {
+		return apiError(err)
+	}
+	fmt.Fprintf(stdout, "Removed %d cached secret(s) from %s\n", n, dir)
 	return nil
 }
 
-func createRun(cmd *cobra.Command, args []string) error {
+// diskCacheDir returns the directory of the on-disk cache.
+func diskCacheDir() (string, error) {
+	if cacheDir != "" {
+		return cacheDir, nil
+	}
+	return berglas.DefaultDiskCacheDir()
+}
+
+func canIRun(cmd *cobra.Command, args []string) error {
+	action := berglas.CanIAction(args[0])
+	if !berglas.IsCanIAction(args[0]) {
+		return misuseError(fmt.Errorf("invalid action %q: must be one of access, create, grant", args[0]))
+	}
+
 	ctx, client, err := clientWithContext(cmd.Context())
 	if err != nil {
 		return misuseError(err)
 	}
+	defer client.Close()
+
+	if args[1] == "-" {
+		return runBatch(func(ref *berglas.Reference) (any, error) {
+			resp, err := canIReference(ctx, client, action, ref)
+			if err != nil {
+				return nil, err
+			}
+			return newCanIResult(resp), canIVerdict(action, resp, ref.String())
+		})
+	}
 
-	ref, err := parseRef(args[0])
+	ref, err := parseRef(args[1])
 	if err != nil {
 		return misuseError(err)
 	}
 
-	data := strings.TrimSpace(args[1])
-	plaintext, err := readData(data)
+	resp, err := canIReference(ctx, client, action, ref)
 	if err != nil {
+		if berglas.IsValidationErr(err) {
+			return misuseError(err)
+		}
+		return apiError(err)
+	}
+
+	tw := new(tabwriter.Writer)
+	tw.Init(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "PERMISSION\tRESOURCE\tGRANTED\n")
+	for _, p := range resp.Permissions {
+		fmt.Fprintf(tw, "%s\t%s\t%t\n", p.Permission, p.Resource, p.Granted)
+	}
+	tw.Flush()
+
+	if err := canIVerdict(action, resp, args[1]); err != nil {
 		return misuseError(err)
 	}
 
+	fmt.Fprintf(stdout, "yes\n")
+	return nil
+}
+
+// canIReference checks the permissions for the action on the given reference.
+func canIReference(ctx context.Context, client *berglas.Client, action berglas.CanIAction, ref *berglas.Reference) (*berglas.CanIResponse, error) {
 	switch t := ref.Type(); t {
 	case berglas.ReferenceTypeSecretManager:
-		secret, err := client.Create(ctx, &berglas.SecretManagerCreateRequest{
-			Project:   ref.Project(),
-			Name:      ref.Name(),
-			Locations: smLocations,
-			Plaintext: plaintext,
+		return client.CanI(ctx, &berglas.SecretManagerCanIRequest{
+			Project: ref.Project(),
+			Name:    ref.Name(),
+			Action:  action,
 		})
-		if err != nil {
-			return apiError(err)
-		}
-		fmt.Fprintf(stdout, "Successfully created secret [%s] with version [%s]\n",
-			secret.Name, secret.Version)
 	case berglas.ReferenceTypeStorage:
-		// Check if no unsupported options have been given
-		if len(smLocations) > 0 {
-			return misuseError(fmt.Errorf("locations on a per-secret basis unsupported for Storage keys"))
-		}
+		return client.CanI(ctx, &berglas.StorageCanIRequest{
+			Bucket: ref.Bucket(),
+			Object: ref.Object(),
+			Key:    key,
+			Action: action,
+		})
+	default:
+		return nil, fmt.Errorf("unknown type %T", t)
+	}
+}
 
-		// Create the requested secret
-		secret, err := client.Create(ctx, &berglas.StorageCreateRequest{
-			Bucket:    ref.Bucket(),
-			Object:    ref.Object(),
-			Key:       key,
-			Plaintext: plaintext,
+// canIVerdict returns an error explaining why the action is not allowed on the
+// named secret, or nil if it is.
+func canIVerdict(action berglas.CanIAction, resp *berglas.CanIResponse, name string) error {
+	switch {
+	case action == berglas.CanICreate && resp.Exists:
+		return fmt.Errorf("no: secret %s already exists", name)
+	case action != berglas.CanICreate && !resp.Exists:
+		return fmt.Errorf("no: secret %s does not exist", name)
+	case !resp.Allowed:
+		var missing []string
+		for _, p := range resp.Missing() {
+			missing = append(missing, p.Permission)
+		}
+		if len(missing) == 0 {
+			return fmt.Errorf("no: could not determine the kms key of %s, "+
+				"pass --key to check it", name)
+		}
+		return fmt.Errorf("no: missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// canIResult is the result of a permission check, printed as JSON when
+// references are read from stdin.
+type canIResult struct {
+	Exists      bool                    `json:"exists"`
+	Allowed     bool                    `json:"allowed"`
+	Permissions []*canIPermissionResult `json:"permissions"`
+}
+
+// canIPermissionResult is a single permission of a canIResult.
+type canIPermissionResult struct {
+	Permission string `json:"permission"`
+	Resource   string `json:"resource"`
+	Granted    bool   `json:"granted"`
+}
+
+// newCanIResult builds the permission check result for the given response.
+func newCanIResult(resp *berglas.CanIResponse) *canIResult {
+	permissions := make([]*canIPermissionResult, 0, len(resp.Permissions))
+	for _, p := range resp.Permissions {
+		permissions = append(permissions, &canIPermissionResult{
+			Permission: p.Permission,
+			Resource:   p.Resource,
+			Granted:    p.Granted,
 		})
-		if err != nil {
+	}
+	return &canIResult{
+		Exists:      resp.Exists,
+		Allowed:     resp.Allowed,
+		Permissions: permissions,
+	}
+}
+
+func completionRun(cmd *cobra.Command, args []string) error {
+	switch shell := args[0]; shell {
+	case "bash":
+		if err := rootCmd.GenBashCompletion(stdout); err != nil {
+			err = fmt.Errorf("failed to generate bash completion: %w", err)
+			return apiError(err)
+		}
+	case "zsh":
+		if err := rootCmd.GenZshCompletion(stdout); err != nil {
+			err = fmt.Errorf("failed to generate zsh completion: %w", err)
 			return apiError(err)
 		}
 
-		fmt.Fprintf(stdout, "Successfully created secret [%s] with generation [%d]\n",
-			secret.Name, secret.Generation)
+		// enable the `source <(berglas completion SHELL)` pattern for zsh
+		if _, err := io.WriteString(stdout, "compdef _berglas berglas\n"); err != nil {
+			err = fmt.Errorf("failed to run compdef: %w", err)
+			return apiError(err)
+		}
+	case "fish":
+		if err := rootCmd.GenFishCompletion(stdout, true); err != nil {
+			err = fmt.Errorf("failed to generate fish completion: %w", err)
+			return apiError(err)
+		}
+	case "powershell":
+		if err := rootCmd.GenPowerShellCompletionWithDesc(stdout); err != nil {
+			err = fmt.Errorf("failed to generate powershell completion: %w", err)
+			return apiError(err)
+		}
 	default:
-		return misuseError(fmt.Errorf("unknown type %T", t))
+		err := fmt.Errorf("unknown completion %q", shell)
+		return misuseError(err)
 	}
 
 	return nil
 }
 
-func deleteRun(cmd *cobra.Command, args []string) error {
+func copyRun(cmd *cobra.Command, args []string) error {
+	src, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
+	dst, err := parseRef(args[1])
+	if err != nil {
+		return misuseError(err)
+	}
+	if src.Type() != berglas.ReferenceTypeStorage || dst.Type() != berglas.ReferenceTypeStorage {
+		return misuseError(fmt.Errorf("copy is only supported for Cloud Storage secrets"))
+	}
+	if dst.Generation() != 0 {
+		return misuseError(fmt.Errorf("destination cannot specify a generation"))
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	secret, err := client.Copy(ctx, &berglas.CopyRequest{
+		SourceBucket:     src.Bucket(),
+		SourceObject:     src.Object(),
+		SourceGeneration: src.Generation(),
+		Bucket:           dst.Bucket(),
+		Object:           dst.Object(),
+		Rebind:           copyRebind,
+	})
+	if err != nil {
+		if errors.Is(err, berglas.ErrCopyRequiresRebind) {
+			return misuseError(fmt.Errorf("%w (the secret is bound to its object "+
+				"name, pass --rebind to re-encrypt it for the destination)", err))
+		}
+		if berglas.IsValidationErr(err) {
+			return misuseError(err)
+		}
+		return apiError(err)
+	}
+
+	fmt.Fprintf(stdout, "Successfully copied secret [%s] to [%s/%s] with generation [%d]\n",
+		args[0], secret.Parent, secret.Name, secret.Generation)
+	return nil
+}
+
+func createRun(cmd *cobra.Command, args []string) error {
 	ctx, client, err := clientWithContext(cmd.Context())
 	if err != nil {
 		return misuseError(err)
 	}
+	defer client.Close()
 
 	ref, err := parseRef(args[0])
 	if err != nil {
 		return misuseError(err)
 	}
 
+	data := strings.TrimSpace(args[1])
+	plaintext, err := readData(data)
+	if err != nil {
+		return misuseError(err)
+	}
+
+	return createSecret(ctx, client, ref, plaintext, stdout)
+}
+
+// createSecret creates the secret at ref from the create flags and prints the
+// result to w. It is shared by the create and generate commands.
+func createSecret(ctx context.Context, client *berglas.Client, ref *berglas.Reference, plaintext []byte, w io.Writer) error {
+	policy := berglas.Policy(secretPolicy)
+	if !berglas.IsPolicy(secretPolicy) {
+		return misuseError(fmt.Errorf("invalid policy %q: must be one of %s, %s",
+			secretPolicy, berglas.PolicyFileOnly, berglas.PolicyEnvOnly))
+	}
+
 	switch t := ref.Type(); t {
 	case berglas.ReferenceTypeSecretManager:
-		if err := client.Delete(ctx, &berglas.SecretManagerDeleteRequest{
-			Project: ref.Project(),
-			Name:    ref.Name(),
-		}); err != nil {
+		if requireProtectionLevel != "" {
+			return misuseError(fmt.Errorf("protection level is unsupported for Secret Manager secrets"))
+		}
+		if expiration != "" {
+			return misuseError(fmt.Errorf("expiration is unsupported for Secret Manager secrets"))
+		}
+
+		secret, err := client.Create(ctx, &berglas.SecretManagerCreateRequest{
+			Project:            ref.Project(),
+			Name:               ref.Name(),
+			Locations:          smLocations,
+			Plaintext:          plaintext,
+			WaitForConsistency: waitForConsistency,
+			Policy:             policy,
+			ContentType:        secretContentType,
+		})
+		if err != nil {
 			return apiError(err)
 		}
-		fmt.Fprintf(stdout, "Successfully deleted secret [%s] if it existed\n",
-			ref.Name())
+		fmt.Fprintf(w, "Successfully created secret [%s] with version [%s]\n",
+			secret.Name, secret.Version)
 	case berglas.ReferenceTypeStorage:
-		if err := client.Delete(ctx, &berglas.StorageDeleteRequest{
-			Bucket: ref.Bucket(),
-			Object: ref.Object(),
-		}); err != nil {
+		// Check if no unsupported options have been given
+		if len(smLocations) > 0 {
+			return misuseError(fmt.Errorf("locations on a per-secret basis unsupported for Storage keys"))
+		}
+
+		expiresAt, err := parseExpiration(expiration, time.Now())
+		if err != nil {
+			return misuseError(err)
+		}
+
+		// Create the requested secret
+		secret, err := client.Create(ctx, &berglas.StorageCreateRequest{
+			Bucket:                 ref.Bucket(),
+			Object:                 ref.Object(),
+			Key:                    key,
+			Plaintext:              plaintext,
+			RequireProtectionLevel: strings.ToUpper(requireProtectionLevel),
+			ExpiresAt:              expiresAt,
+			Policy:                 policy,
+			ContentType:            secretContentType,
+		})
+		if err != nil {
+			return apiError(err)
+		}
+
+		fmt.Fprintf(w, "Successfully created secret [%s] with generation [%d]\n",
+			secret.Name, secret.Generation)
+	case berglas.ReferenceTypeS3:
+		if len(smLocations) > 0 || requireProtectionLevel != "" || expiration != "" || secretPolicy != "" ||
+			secretContentType != "" {
+			return misuseError(fmt.Errorf("locations, protection level, expiration, policy, and content type " +
+				"are unsupported for S3 secrets"))
+		}
+
+		secret, err := client.Create(ctx, &berglas.StorageS3CreateRequest{
+			Bucket:    ref.Bucket(),
+			Object:    ref.Object(),
+			Key:       key,
+			Plaintext: plaintext,
+		})
+		if err != nil {
 			return apiError(err)
 		}
-		fmt.Fprintf(stdout, "Successfully deleted secret [%s] if it existed\n",
-			ref.Object())
+		fmt.Fprintf(w, "Successfully created secret [%s]\n", secret.Name)
 	default:
 		return misuseError(fmt.Errorf("unknown type %T", t))
 	}
@@ -719,391 +2587,2383 @@ func deleteRun(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func editRun(cmd *cobra.Command, args []string) error {
-	ctx, client, err := clientWithContext(cmd.Context())
+func decryptOfflineRun(cmd *cobra.Command, args []string) error {
+	r := io.Reader(stdin)
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return misuseError(fmt.Errorf("failed to open file: %w", err))
+		}
+		defer f.Close()
+		r = f
+	}
+
+	blob, err := io.ReadAll(r)
+	if err != nil {
+		return misuseError(fmt.Errorf("failed to read ciphertext: %w", err))
+	}
+
+	rawDEK, err := os.ReadFile(decryptOfflineDEKFile)
+	if err != nil {
+		return misuseError(fmt.Errorf("failed to read dek file: %w", err))
+	}
+
+	plaintext, err := berglas.DecryptOffline(bytes.TrimSpace(blob), parseDEK(rawDEK))
+	if err != nil {
+		return misuseError(err)
+	}
+	fmt.Fprintf(stdout, "%s", plaintext)
+	return nil
+}
+
+// parseDEK decodes a data encryption key stored as raw bytes, hex, or base64.
+// Contents that decode in neither encoding are returned as-is so the library can
+// explain what is wrong with them.
+func parseDEK(b []byte) []byte {
+	if len(b) == 32 {
+		return b
+	}
+
+	s := strings.TrimSpace(string(b))
+	if k, err := hex.DecodeString(s); err == nil {
+		return k
+	}
+	if k, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return k
+	}
+	return b
+}
+
+func deleteRun(cmd *cobra.Command, args []string) error {
+	if deleteParallelism < 1 {
+		return misuseError(fmt.Errorf("--parallelism must be at least 1"))
+	}
+	destroyTTL, err := parseTTL(deleteDestroyTTL)
 	if err != nil {
 		return misuseError(err)
 	}
 
-	// Find the editor
-	var editor string
-	for _, e := range []string{"VISUAL", "EDITOR"} {
-		if v := os.Getenv(e); v != "" {
-			editor = v
-			break
+	names := args
+	if deleteFile != "" {
+		fileNames, err := readSecretList(deleteFile)
+		if err != nil {
+			return misuseError(err)
+		}
+		names = append(slices.Clone(args), fileNames...)
+		if len(names) == 0 {
+			return misuseError(fmt.Errorf("no secrets to delete in %s", deleteFile))
 		}
 	}
-	if editor == "" {
-		err := fmt.Errorf("no editor is set - set VISUAL or EDITOR")
+
+	if len(names) > 1 || deleteFile != "" {
+		count := strconv.Itoa(len(names))
+		if err := confirmDestructive(
+			fmt.Sprintf("This will permanently delete %s secrets.", count),
+			"the number of secrets", count); err != nil {
+			return misuseError(err)
+		}
+
+		ctx, client, err := clientWithContext(cmd.Context())
+		if err != nil {
+			return misuseError(err)
+		}
+		defer client.Close()
+
+		return deleteBatch(ctx, client, names, destroyTTL)
+	}
+
+	ref, err := parseRef(names[0])
+	if err != nil {
+		return misuseError(err)
+	}
+
+	if ref.Type() == berglas.ReferenceTypeSecretManager && ref.Version() != "" {
+		return destroyVersion(cmd.Context(), ref, destroyTTL)
+	}
+	if destroyTTL != 0 {
+		return misuseError(fmt.Errorf("--destroy-ttl requires a Secret Manager reference with a version"))
+	}
+
+	name := ref.Name()
+	if ref.Type() == berglas.ReferenceTypeStorage {
+		name = ref.Object()
+	}
+	if err := confirmDestructive(
+		fmt.Sprintf("This will permanently delete secret [%s] and all of its versions.", name),
+		"the secret name", name); err != nil {
+		return misuseError(err)
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	if ref.Type() == berglas.ReferenceTypeStorage {
+		ctx = withProgress(ctx, "Deleting generations")
+	}
+	if err := deleteReference(ctx, client, ref, 0); err != nil {
 		return apiError(err)
 	}
 
-	ref, err := parseRef(args[0])
+	fmt.Fprintf(stdout, "Successfully deleted secret [%s] if it existed\n", name)
+	return nil
+}
+
+// destroyVersion destroys a single Secret Manager version, keeping it
+// recoverable for ttl if it is not zero.
+func destroyVersion(ctx context.Context, ref *berglas.Reference, ttl time.Duration) error {
+	msg := fmt.Sprintf("This will permanently destroy version [%s] of secret [%s].",
+		ref.Version(), ref.Name())
+	if ttl != 0 {
+		msg = fmt.Sprintf("This will destroy version [%s] of secret [%s] after %s.",
+			ref.Version(), ref.Name(), ttl)
+	}
+	if err := confirmDestructive(msg, "the secret name", ref.Name()); err != nil {
+		return misuseError(err)
+	}
+
+	ctx, client, err := clientWithContext(ctx)
 	if err != nil {
 		return misuseError(err)
 	}
+	defer client.Close()
 
-	var originalSecret *berglas.Secret
+	if err := deleteReference(ctx, client, ref, ttl); err != nil {
+		return apiError(err)
+	}
 
-	// Get the existing secret
+	if ttl != 0 {
+		fmt.Fprintf(stdout, "Successfully scheduled version [%s] of secret [%s] for destruction "+
+			"in %s, restore it with \"berglas restore %s\"\n", ref.Version(), ref.Name(), ttl, ref)
+		return nil
+	}
+	fmt.Fprintf(stdout, "Successfully destroyed version [%s] of secret [%s]\n",
+		ref.Version(), ref.Name())
+	return nil
+}
+
+// deleteBatch deletes the named secrets concurrently and prints a result for
+// each one, in the order given.
+func deleteBatch(ctx context.Context, client *berglas.Client, names []string, destroyTTL time.Duration) error {
+	errs := make([]error, len(names))
+
+	sem := make(chan struct{}, deleteParallelism)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		ref, err := parseRef(name)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, ref *berglas.Reference) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			errs[i] = deleteReference(ctx, client, ref, destroyTTL)
+		}(i, ref)
+	}
+	wg.Wait()
+
+	var failed int
+	tw := new(tabwriter.Writer)
+	tw.Init(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "SECRET\tRESULT\n")
+	for i, name := range names {
+		result := "deleted"
+		if err := errs[i]; err != nil {
+			failed++
+			result = "error: " + err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", name, result)
+	}
+	tw.Flush()
+
+	if failed > 0 {
+		return apiError(fmt.Errorf("failed to delete %d of %d secrets", failed, len(names)))
+	}
+	return nil
+}
+
+// deleteReference deletes the secret for the given reference. It is not an
+// error if the secret does not exist.
+func deleteReference(ctx context.Context, client *berglas.Client, ref *berglas.Reference, destroyTTL time.Duration) error {
 	switch t := ref.Type(); t {
 	case berglas.ReferenceTypeSecretManager:
-		originalSecret, err = client.Read(ctx, &berglas.SecretManagerReadRequest{
-			Project: ref.Project(),
-			Name:    ref.Name(),
-			Version: ref.Version(),
+		return client.Delete(ctx, &berglas.SecretManagerDeleteRequest{
+			Project:    ref.Project(),
+			Name:       ref.Name(),
+			Version:    ref.Version(),
+			DestroyTTL: destroyTTL,
 		})
 	case berglas.ReferenceTypeStorage:
-		originalSecret, err = client.Read(ctx, &berglas.StorageReadRequest{
-			Bucket:     ref.Bucket(),
-			Object:     ref.Object(),
-			Generation: ref.Generation(),
+		if destroyTTL != 0 {
+			return fmt.Errorf("destroy TTL is unsupported for Storage secrets")
+		}
+		return client.Delete(ctx, &berglas.StorageDeleteRequest{
+			Bucket: ref.Bucket(),
+			Object: ref.Object(),
 		})
 	default:
-		return misuseError(fmt.Errorf("unknown type %T", t))
+		return fmt.Errorf("unknown type %T", t)
+	}
+}
+
+// readSecretList reads one secret per line from the named file, or stdin if
+// the name is "-". Blank lines and lines starting with "#" are skipped.
+func readSecretList(name string) ([]string, error) {
+	r := io.Reader(stdin)
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var names []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return names, nil
+}
+
+// batchResult is one line of the NDJSON output of a command run on references
+// read from stdin.
+type batchResult struct {
+	Reference string `json:"reference"`
+	Result    any    `json:"result,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// runBatch reads newline-delimited references from stdin, skipping blank lines
+// and lines starting with "#", and calls fn with each one. A batchResult is
+// printed for every reference as it completes. A failed reference does not stop
+// the batch; an error counting the failures is returned at the end.
+func runBatch(fn func(ref *berglas.Reference) (any, error)) error {
+	refs, err := readSecretList("-")
+	if err != nil {
+		return misuseError(err)
+	}
+
+	enc := json.NewEncoder(stdout)
+	var failed int
+	for _, s := range refs {
+		result := &batchResult{Reference: s}
+
+		ref, err := parseRef(s)
+		if err == nil {
+			result.Result, err = fn(ref)
+		}
+		if err != nil {
+			failed++
+			result.Error = err.Error()
+		}
+
+		if err := enc.Encode(result); err != nil {
+			return apiError(fmt.Errorf("failed to write result: %w", err))
+		}
+	}
+
+	if failed > 0 {
+		return apiError(fmt.Errorf("%d of %d references failed", failed, len(refs)))
+	}
+	return nil
+}
+
+// confirmDestructive describes an irreversible action and asks the user to type
+// the expected value (such as the secret or bucket name) to proceed. It does not
+// prompt when --yes is given or stdin is not a terminal, so scripts and
+// pipelines are unaffected.
+func confirmDestructive(action, what, expected string) error {
+	if assumeYes || !term.IsTerminal(int(stdin.Fd())) {
+		return nil
+	}
+
+	fmt.Fprintf(stderr, "%s This cannot be undone.\n", action)
+	fmt.Fprintf(stderr, "Type %s [%s] to confirm: ", what, expected)
+
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	if strings.TrimSpace(line) != expected {
+		return fmt.Errorf("confirmation did not match %q, aborting", expected)
+	}
+	return nil
+}
+
+// editResult is the result of an edit, printed as JSON with --format json.
+type editResult struct {
+	// Status is one of "updated", "unchanged", "empty", "editor_failed", or
+	// "error".
+	Status string `json:"status"`
+
+	// Secret is the reference to the edited secret.
+	Secret string `json:"secret"`
+
+	// PreviousVersion and Version are the Secret Manager version or Cloud
+	// Storage generation before and after the edit.
+	PreviousVersion string `json:"previous_version,omitempty"`
+	Version         string `json:"version,omitempty"`
+
+	// EditorExitCode is the exit code of the editor, if it failed.
+	EditorExitCode *int `json:"editor_exit_code,omitempty"`
+
+	// Error describes the failure, if any.
+	Error string `json:"error,omitempty"`
+}
+
+func driftRun(cmd *cobra.Command, args []string) error {
+	type entry struct {
+		name string
+		ref  *berglas.Reference
+	}
+
+	var entries []*entry
+	for _, pth := range driftEnvFiles {
+		vars, err := readEnvFile(pth)
+		if err != nil {
+			return misuseError(err)
+		}
+
+		for _, v := range vars {
+			if !berglas.IsReference(v.Value) {
+				continue
+			}
+
+			name := v.Name
+			if len(driftEnvFiles) > 1 {
+				name = pth + ":" + name
+			}
+
+			refs, err := berglas.ParseReferences(v.Value)
+			if err != nil {
+				return misuseError(fmt.Errorf("%s: %w", name, err))
+			}
+			for _, ref := range refs {
+				entries = append(entries, &entry{name: name, ref: ref})
+			}
+		}
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	var behind, failed int
+
+	tw := new(tabwriter.Writer)
+	tw.Init(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tREFERENCE\tPINNED\tLATEST\tSTATUS\n")
+	for _, e := range entries {
+		result, err := client.Drift(ctx, e.ref)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(tw, "%s\t%s\t\t\terror: %s\n", e.name, e.ref, err)
+		case result.Pinned == "":
+			fmt.Fprintf(tw, "%s\t%s\t\t\tunpinned\n", e.name, e.ref)
+		case result.Behind:
+			behind++
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\tbehind\n", e.name, e.ref, result.Pinned, result.Latest)
+		default:
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\tcurrent\n", e.name, e.ref, result.Pinned, result.Latest)
+		}
+	}
+	tw.Flush()
+
+	if failed > 0 {
+		return apiError(fmt.Errorf("failed to check %d of %d references", failed, len(entries)))
+	}
+	if driftFailOnDrift && behind > 0 {
+		return misuseError(fmt.Errorf("%d of %d references are behind the latest version",
+			behind, len(entries)))
+	}
+	return nil
+}
+
+// readEnvFile reads the variables in the named .env file.
+func readEnvFile(pth string) ([]*envexport.Var, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open env file: %w", err)
+	}
+	defer f.Close()
+
+	vars, err := envexport.ReadDotenv(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", pth, err)
+	}
+	return vars, nil
+}
+
+func editRun(cmd *cobra.Command, args []string) error {
+	// Fail before opening the editor rather than discarding the user's changes.
+	if readOnly {
+		return misuseError(fmt.Errorf("cannot edit secrets in read-only mode"))
+	}
+	if editFormat != "text" && editFormat != "json" {
+		return misuseError(fmt.Errorf("unknown format %q (must be text or json)", editFormat))
+	}
+
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
+
+	result := &editResult{Secret: ref.String()}
+	if err := editSecretWithClient(cmd.Context(), ref, result); err != nil {
+		if result.Status == "" {
+			result.Status = "error"
+		}
+		result.Error = err.Error()
+		if editFormat == "json" {
+			printEditResult(result)
+		}
+		return err
+	}
+
+	switch {
+	case editFormat == "json":
+		printEditResult(result)
+	case ref.Type() == berglas.ReferenceTypeSecretManager:
+		fmt.Fprintf(stdout, "Successfully updated secret [%s] to version [%s]\n",
+			ref.Name(), result.Version)
+	default:
+		fmt.Fprintf(stdout, "Successfully updated secret [%s] with generation [%s]\n",
+			ref.Object(), result.Version)
+	}
+	return nil
+}
+
+// printEditResult prints the edit result as JSON.
+func printEditResult(result *editResult) {
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		fmt.Fprintf(stderr, "failed to encode edit result: %s\n", err)
+	}
+}
+
+// editSecretWithClient creates a client and edits the secret.
+func editSecretWithClient(ctx context.Context, ref *berglas.Reference, result *editResult) *exitError {
+	ctx, client, err := clientWithContext(ctx)
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	return editSecret(ctx, client, ref, result)
+}
+
+// editSecret reads the secret, gets the new plaintext from --from-file or an
+// editor, and updates the secret, recording the outcome in result.
+func editSecret(ctx context.Context, client *berglas.Client, ref *berglas.Reference, result *editResult) *exitError {
+	var originalSecret *berglas.Secret
+	var err error
+
+	// Get the existing secret
+	switch t := ref.Type(); t {
+	case berglas.ReferenceTypeSecretManager:
+		originalSecret, err = client.Read(ctx, &berglas.SecretManagerReadRequest{
+			Project: ref.Project(),
+			Name:    ref.Name(),
+			Version: ref.Version(),
+		})
+	case berglas.ReferenceTypeStorage:
+		originalSecret, err = client.Read(ctx, &berglas.StorageReadRequest{
+			Bucket:     ref.Bucket(),
+			Object:     ref.Object(),
+			Generation: ref.Generation(),
+		})
+	default:
+		return misuseError(fmt.Errorf("unknown type %T", t))
+	}
+
+	switch {
+	case berglas.IsSecretDoesNotExistErr(err) && createIfMissing:
+		originalSecret = &berglas.Secret{KMSKey: key}
+	case err != nil:
+		return apiError(err)
+	default:
+		result.PreviousVersion = secretVersion(originalSecret)
+	}
+
+	// Get the new secret value
+	var newPlaintext []byte
+	if editFromFile != "" {
+		newPlaintext, err = readEditFile(editFromFile)
+		if err != nil {
+			return misuseError(fmt.Errorf("failed to read %s: %w", editFromFile, err))
+		}
+	} else {
+		var exitErr *exitError
+		newPlaintext, exitErr = editInEditor(ctx, originalSecret.Plaintext, result)
+		if exitErr != nil {
+			return exitErr
+		}
+	}
+
+	// Error if the secret is empty
+	if len(newPlaintext) == 0 {
+		result.Status = "empty"
+		return misuseError(fmt.Errorf("secret is empty"))
+	}
+
+	if bytes.Equal(newPlaintext, originalSecret.Plaintext) {
+		result.Status = "unchanged"
+		result.Version = result.PreviousVersion
+		return misuseError(fmt.Errorf("secret unchanged - not going to update"))
+	}
+
+	// Update the secret
+	var updatedSecret *berglas.Secret
+	switch ref.Type() {
+	case berglas.ReferenceTypeSecretManager:
+		updatedSecret, err = client.Update(ctx, &berglas.SecretManagerUpdateRequest{
+			Project:         ref.Project(),
+			Name:            ref.Name(),
+			Plaintext:       newPlaintext,
+			CreateIfMissing: createIfMissing,
+		})
+	case berglas.ReferenceTypeStorage:
+		updatedSecret, err = client.Update(ctx, &berglas.StorageUpdateRequest{
+			Bucket:          ref.Bucket(),
+			Object:          ref.Object(),
+			Generation:      originalSecret.Generation,
+			Key:             originalSecret.KMSKey,
+			Metageneration:  originalSecret.Metageneration,
+			Plaintext:       newPlaintext,
+			CreateIfMissing: createIfMissing,
+		})
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to update secret: %w", err)
+		return misuseError(err)
+	}
+
+	result.Status = "updated"
+	result.Version = secretVersion(updatedSecret)
+	return nil
+}
+
+// secretVersion returns the Secret Manager version or Cloud Storage generation
+// of the secret.
+func secretVersion(s *berglas.Secret) string {
+	if s.Version != "" {
+		return s.Version
+	}
+	return strconv.FormatInt(s.Generation, 10)
+}
+
+// readEditFile reads the new secret value for a non-interactive edit, where
+// "-" reads all of stdin.
+func readEditFile(pth string) ([]byte, error) {
+	if pth == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(pth)
+}
+
+// editInEditor writes the plaintext to a tempfile, opens it in the editor, and
+// returns the saved contents. If the editor exits non-zero, the returned error
+// carries its exit code and it is recorded in result.
+func editInEditor(ctx context.Context, plaintext []byte, result *editResult) ([]byte, *exitError) {
+	// Find the editor
+	editorProg := editor
+	for _, e := range []string{"VISUAL", "EDITOR"} {
+		if editorProg != "" {
+			break
+		}
+		editorProg = os.Getenv(e)
+	}
+	if editorProg == "" {
+		err := fmt.Errorf("no editor is set - set VISUAL or EDITOR")
+		return nil, apiError(err)
+	}
+
+	// Create the tempfile
+	f, err := os.CreateTemp("", "berglas-")
+	if err != nil {
+		err = fmt.Errorf("failed to create tempfile for secret: %w", err)
+		return nil, apiError(err)
+	}
+
+	defer func() {
+		if err := os.Remove(f.Name()); err != nil {
+			fmt.Fprintf(stderr, "failed to cleanup tempfile %s: %s\n", f.Name(), err)
+		}
+	}()
+
+	// Write contents to the original file
+	if _, err := f.Write(plaintext); err != nil {
+		err = fmt.Errorf("failed to write tempfile for secret: %w", err)
+		return nil, apiError(err)
+	}
+
+	if err := f.Sync(); err != nil {
+		err = fmt.Errorf("failed to sync tempfile for secret: %w", err)
+		return nil, apiError(err)
+	}
+
+	if err := f.Close(); err != nil {
+		err = fmt.Errorf("failed to close tempfile for secret: %w", err)
+		return nil, apiError(err)
+	}
+
+	// Spawn editor. With JSON output, the editor writes to stderr so stdout
+	// only contains the result.
+	editorSplit := strings.Split(editorProg, " ")
+	editorCmd, editorArgs := editorSplit[0], editorSplit[1:]
+	editorArgs = append(editorArgs, f.Name())
+	externalCmd := exec.CommandContext(ctx, editorCmd, editorArgs...)
+	externalCmd.Stdin = stdin
+	externalCmd.Stdout = stdout
+	if editFormat == "json" {
+		externalCmd.Stdout = stderr
+	}
+	externalCmd.Stderr = stderr
+	if err := externalCmd.Start(); err != nil {
+		err = fmt.Errorf("failed to start editor: %w", err)
+		return nil, misuseError(err)
+	}
+	if err := externalCmd.Wait(); err != nil {
+		if terr, ok := err.(*exec.ExitError); ok && terr.ProcessState != nil {
+			code := terr.ProcessState.ExitCode()
+			result.Status = "editor_failed"
+			result.EditorExitCode = &code
+			return nil, exitWithCode(code, fmt.Errorf("editor did not exit 0: %w", err))
+		}
+		err = fmt.Errorf("unknown failure in running editor: %w", err)
+		return nil, misuseError(err)
+	}
+
+	// Read the new secret value
+	newPlaintext, err := os.ReadFile(f.Name())
+	if err != nil {
+		err = fmt.Errorf("failed to read secret tempfile: %w", err)
+		return nil, misuseError(err)
+	}
+	return newPlaintext, nil
+}
+
+func envRun(cmd *cobra.Command, args []string) error {
+	format := envexport.Format(envExportFormat)
+	if !slices.Contains(envexport.Formats, format) {
+		return misuseError(fmt.Errorf("invalid format %q: must be dotenv, gha, or k8s",
+			envExportFormat))
+	}
+	if format == envexport.FormatKubernetes && envExportName == "" {
+		return misuseError(fmt.Errorf("--name is required with --format k8s"))
+	}
+
+	pairs := args
+	if envExportFile != "" {
+		filePairs, err := readSecretList(envExportFile)
+		if err != nil {
+			return misuseError(err)
+		}
+		pairs = append(slices.Clone(args), filePairs...)
+		if len(pairs) == 0 {
+			return misuseError(fmt.Errorf("no variables in %s", envExportFile))
+		}
+	}
+
+	// Validate the names before resolving anything.
+	if _, err := envexport.Parse(pairs); err != nil {
+		return misuseError(err)
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+
+	env, err := client.ResolveEnv(ctx, &berglas.ResolveEnvRequest{
+		Env: pairs,
+	})
+	client.Close()
+	if err != nil {
+		return apiError(err)
+	}
+
+	vars, err := envexport.Parse(env)
+	if err != nil {
+		return apiError(err)
+	}
+	if envExportSort {
+		envexport.Sort(vars)
+	}
+
+	switch format {
+	case envexport.FormatGitHubActions:
+		envOut := io.Writer(stdout)
+		if pth := os.Getenv("GITHUB_ENV"); pth != "" {
+			f, err := os.OpenFile(pth, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+			if err != nil {
+				return fmt.Errorf("failed to open $GITHUB_ENV: %w", err)
+			}
+			defer f.Close()
+			envOut = f
+		}
+		return envexport.WriteGitHubActions(stdout, envOut, vars)
+	case envexport.FormatKubernetes:
+		return envexport.WriteKubernetesSecret(stdout, envExportName, envExportNamespace, vars)
+	default:
+		return envexport.WriteDotenv(stdout, vars)
+	}
+}
+
+func escrowExportRun(cmd *cobra.Command, args []string) error {
+	if justification == "" {
+		return misuseError(fmt.Errorf("escrow export requires --justification"))
+	}
+
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
+	if ref.Type() != berglas.ReferenceTypeStorage {
+		return misuseError(fmt.Errorf("escrow is only supported for Cloud Storage secrets"))
+	}
+
+	req := &berglas.EscrowExportRequest{
+		Bucket:     ref.Bucket(),
+		Object:     ref.Object(),
+		Generation: ref.Generation(),
+	}
+	if strings.HasPrefix(escrowWrappingKey, "projects/") {
+		req.WrappingKey = escrowWrappingKey
+	} else {
+		b, err := os.ReadFile(escrowWrappingKey)
+		if err != nil {
+			return misuseError(fmt.Errorf("failed to read wrapping key: %w", err))
+		}
+		req.PublicKey = b
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	bundle, err := client.EscrowExport(ctx, req)
+	if err != nil {
+		return apiError(err)
+	}
+
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(bundle); err != nil {
+		return misuseError(fmt.Errorf("failed to write bundle: %w", err))
+	}
+
+	fmt.Fprintf(stderr, "Exported secret [%s] with generation [%d] to escrow "+
+		"under [%s] (justification: %s)\n",
+		ref.Object(), bundle.Generation, bundle.WrappingKey, justification)
+	return nil
+}
+
+func escrowRestoreRun(cmd *cobra.Command, args []string) error {
+	r := io.Reader(stdin)
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return misuseError(fmt.Errorf("failed to open file: %w", err))
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var bundle berglas.EscrowBundle
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return misuseError(fmt.Errorf("failed to parse bundle: %w", err))
+	}
+
+	req := &berglas.EscrowRestoreRequest{
+		Bundle: &bundle,
+		Key:    key,
+	}
+	if escrowPrivateKey != "" {
+		b, err := os.ReadFile(escrowPrivateKey)
+		if err != nil {
+			return misuseError(fmt.Errorf("failed to read private key: %w", err))
+		}
+		req.PrivateKey = b
+	}
+	if escrowTo != "" {
+		ref, err := parseRef(escrowTo)
+		if err != nil {
+			return misuseError(err)
+		}
+		if ref.Type() != berglas.ReferenceTypeStorage {
+			return misuseError(fmt.Errorf("escrow is only supported for Cloud Storage secrets"))
+		}
+		req.Bucket = ref.Bucket()
+		req.Object = ref.Object()
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	secret, err := client.EscrowRestore(ctx, req)
+	if err != nil {
+		return apiError(err)
+	}
+
+	fmt.Fprintf(stdout, "Successfully restored secret [%s] with generation [%d]\n",
+		secret.Name, secret.Generation)
+	return nil
+}
+
+func execRun(cmd *cobra.Command, args []string) error {
+	// Wait before creating the client, since dependencies may include mounted
+	// credentials.
+	if len(execWait) > 0 {
+		conds := make([]wait.Condition, 0, len(execWait))
+		for _, w := range execWait {
+			c, err := wait.Parse(w)
+			if err != nil {
+				return misuseError(err)
+			}
+			conds = append(conds, c)
+		}
+
+		if err := wait.For(cmd.Context(), execWaitInterval, conds...); err != nil {
+			return err
+		}
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+
+	execCmd := args[0]
+	execArgs := args[1:]
+
+	// Resolve references in the local env. The client is not needed after, so
+	// it is closed rather than held open for the life of the command.
+	env, err := client.ResolveEnv(ctx, &berglas.ResolveEnvRequest{
+		Env:  os.Environ(),
+		Deep: execDeep,
+	})
+	client.Close()
+	if err != nil {
+		return apiError(err)
+	}
+
+	// References left in the environment would otherwise surface as
+	// authentication failures in the command. Only names are printed, since
+	// the values may be resolved secrets.
+	if names := berglas.UnresolvedReferences(env); len(names) > 0 {
+		msg := fmt.Sprintf("unresolved references remain in %s", strings.Join(names, ", "))
+		if !execDeep {
+			msg += " (use --deep to resolve references nested in JSON or YAML values)"
+		}
+		if execStrict {
+			return misuseError(errors.New(msg))
+		}
+		fmt.Fprintf(stderr, "Warning: %s\n", msg)
+	}
+
+	execCmdFull, err := exec.LookPath(execCmd)
+	if err != nil {
+		return fmt.Errorf("failed to lookup path for %q: %w", execCmd, err)
+	}
+
+	// Unlike os/exec, execv(3) expects the arguments to include the command.
+	execArgs = append([]string{execCmdFull}, execArgs...)
+
+	if execSupervise {
+		code, err := supervise.Run(execCmdFull, execArgs, env)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			// The command reports its own errors.
+			return exitWithCode(code, nil)
+		}
+		return nil
+	}
+
+	if err := syscall.Exec(execCmdFull, execArgs, env); err != nil {
+		return fmt.Errorf("failed to execute %q: %w", execCmd, err)
+	}
+	return nil
+}
+
+func generateRun(cmd *cobra.Command, args []string) error {
+	ttl, err := parseTTL(generateTTL)
+	if err != nil {
+		return misuseError(err)
+	}
+
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
+
+	var publicRef *berglas.Reference
+	if generatePublicSecret != "" {
+		publicRef, err = parseRef(generatePublicSecret)
+		if err != nil {
+			return misuseError(err)
+		}
+	}
+
+	material, err := generate.Generate(generateType, &generate.Options{
+		CommonName: generateCommonName,
+		TTL:        ttl,
+		Comment:    ref.Name(),
+	})
+	if err != nil {
+		return misuseError(err)
+	}
+	defer clear(material.Private)
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	// When the public half goes to stdout, keep it clean for redirection.
+	w := stdout
+	if publicRef == nil {
+		w = stderr
+	}
+	if err := createSecret(ctx, client, ref, material.Private, w); err != nil {
+		return err
+	}
+
+	if publicRef == nil {
+		if _, err := stdout.Write(material.Public); err != nil {
+			return fmt.Errorf("failed to write public key: %w", err)
+		}
+		return nil
+	}
+	return createSecret(ctx, client, publicRef, material.Public, stdout)
+}
+
+func grantRun(cmd *cobra.Command, args []string) error {
+	scope := berglas.SecretManagerScope(membersScope)
+	if !berglas.IsSecretManagerScope(membersScope) {
+		return misuseError(fmt.Errorf("invalid scope %q: must be secret or project", membersScope))
+	}
+
+	var project string
+	if scope == berglas.SecretManagerScopeProject {
+		p, err := parseProjectRef(args[0])
+		if err != nil {
+			return misuseError(err)
+		}
+		project = p
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	members, err := cliMembers(members)
+	if err != nil {
+		return misuseError(err)
+	}
+
+	sort.Strings(members)
+
+	expires, err := parseExpiration(grantExpires, time.Now())
+	if err != nil {
+		return misuseError(err)
+	}
+
+	if scope == berglas.SecretManagerScopeProject {
+		result, err := client.GrantWithResult(ctx, &berglas.SecretManagerGrantRequest{
+			Project: project,
+			Members: members,
+			DryRun:  membersDryRun,
+			Scope:   scope,
+			Reason:  grantReason,
+			Expires: expires,
+		})
+		if err != nil {
+			return apiError(err)
+		}
+		printIAMResult("projects/"+project, result)
+		return nil
+	}
+
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
+
+	switch t := ref.Type(); t {
+	case berglas.ReferenceTypeSecretManager:
+		result, err := client.GrantWithResult(ctx, &berglas.SecretManagerGrantRequest{
+			Project: ref.Project(),
+			Name:    ref.Name(),
+			Members: members,
+			DryRun:  membersDryRun,
+			Reason:  grantReason,
+			Expires: expires,
+		})
+		if err != nil {
+			return apiError(err)
+		}
+		printIAMResult(ref.Name(), result)
+	case berglas.ReferenceTypeStorage:
+		if grantReason != "" || !expires.IsZero() {
+			return misuseError(fmt.Errorf("--reason and --expires are only supported " +
+				"for Secret Manager secrets"))
+		}
+
+		result, err := client.GrantWithResult(ctx, &berglas.StorageGrantRequest{
+			Bucket:           ref.Bucket(),
+			Object:           ref.Object(),
+			Members:          members,
+			DryRun:           membersDryRun,
+			AllowACLFallback: allowACLFallback,
+		})
+		if berglas.IsObjectIAMUnavailableErr(err) {
+			return apiError(fmt.Errorf("%w (use --allow-acl-fallback to use object ACLs instead)", err))
+		}
+		if err != nil {
+			return apiError(err)
+		}
+		printIAMResult(ref.Object(), result)
+	default:
+		return misuseError(fmt.Errorf("unknown type %T", t))
+	}
+
+	return nil
+}
+
+func iamShowRun(cmd *cobra.Command, args []string) error {
+	scope := berglas.SecretManagerScope(membersScope)
+	if !berglas.IsSecretManagerScope(membersScope) {
+		return misuseError(fmt.Errorf("invalid scope %q: must be secret or project", membersScope))
+	}
+
+	req := &berglas.SecretManagerBindingsRequest{Scope: scope}
+	var ref *berglas.Reference
+	if scope == berglas.SecretManagerScopeProject {
+		p, err := parseProjectRef(args[0])
+		if err != nil {
+			return misuseError(err)
+		}
+		req.Project = p
+	} else {
+		r, err := parseRef(args[0])
+		if err != nil {
+			return misuseError(err)
+		}
+		ref = r
+		req.Project, req.Name = ref.Project(), ref.Name()
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	var bindings []*berglas.IAMBinding
+	switch {
+	case ref == nil || ref.Type() == berglas.ReferenceTypeSecretManager:
+		bindings, err = client.Bindings(ctx, req)
+		if err != nil {
+			return apiError(err)
+		}
+	case ref.Type() == berglas.ReferenceTypeStorage:
+		members, err := client.Members(ctx, &berglas.StorageMembersRequest{
+			Bucket: ref.Bucket(),
+			Object: ref.Object(),
+		})
+		if err != nil {
+			return apiError(err)
+		}
+		if len(members) > 0 {
+			bindings = append(bindings, &berglas.IAMBinding{Members: members})
+		}
+	default:
+		return misuseError(fmt.Errorf("iam show is not supported for %s", ref))
+	}
+
+	if len(bindings) == 0 {
+		fmt.Fprintln(stdout, "No members have access")
+		return nil
+	}
+
+	now := time.Now()
+	tw := new(tabwriter.Writer)
+	tw.Init(stdout, 0, 4, 4, ' ', 0)
+	fmt.Fprintf(tw, "MEMBER\tREASON\tEXPIRES\tCONDITION\n")
+	for _, b := range bindings {
+		reason, expires, condition := "-", "-", "-"
+		if c := b.Condition; c != nil {
+			condition = c.Title
+			if c.Reason != "" {
+				reason = c.Reason
+			}
+			if !c.Expires.IsZero() {
+				expires = c.Expires.UTC().Format(time.RFC3339)
+				if c.Expired(now) {
+					expires += " (expired)"
+				}
+			}
+		}
+		for _, m := range b.Members {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", m, reason, expires, condition)
+		}
+	}
+	tw.Flush()
+	return nil
+}
+
+func kvGetRun(cmd *cobra.Command, args []string) error {
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	v, err := client.GetKey(ctx, ref, args[1])
+	if err != nil {
+		return apiError(err)
+	}
+	fmt.Fprintf(stdout, "%s", v)
+	return nil
+}
+
+func kvSetRun(cmd *cobra.Command, args []string) error {
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
+
+	value, err := readData(args[2])
+	if err != nil {
+		return misuseError(err)
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	if _, err := client.SetKey(ctx, ref, args[1], value); err != nil {
+		return apiError(err)
+	}
+
+	fmt.Fprintf(stdout, "Successfully set key [%s] in secret [%s]\n", args[1], args[0])
+	return nil
+}
+
+func kvDeleteRun(cmd *cobra.Command, args []string) error {
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	if _, err := client.DeleteKey(ctx, ref, args[1]); err != nil {
+		return apiError(err)
+	}
+
+	fmt.Fprintf(stdout, "Successfully deleted key [%s] from secret [%s]\n", args[1], args[0])
+	return nil
+}
+
+func kvListRun(cmd *cobra.Command, args []string) error {
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	s, err := client.ReadStructured(ctx, ref)
+	if err != nil {
+		return apiError(err)
+	}
+	for _, k := range s.Keys() {
+		fmt.Fprintln(stdout, k)
+	}
+	return nil
+}
+
+func lintRun(cmd *cobra.Command, args []string) error {
+	files := append(slices.Clone(lintFiles), args...)
+	if len(files) == 0 {
+		return misuseError(fmt.Errorf("missing files to lint"))
+	}
+
+	var findings []*lint.Finding
+	for _, name := range files {
+		fileFindings, err := lintFile(name)
+		if err != nil {
+			return misuseError(err)
+		}
+		findings = append(findings, fileFindings...)
+	}
+
+	if lintCheckAccess {
+		ctx, client, err := clientWithContext(cmd.Context())
+		if err != nil {
+			return misuseError(err)
+		}
+		defer client.Close()
+
+		cache := make(map[string]error)
+		for _, f := range findings {
+			if f.Err != nil {
+				continue
+			}
+			if err := lintCheckReferences(ctx, client, f.References, cache); err != nil {
+				f.Err = err
+			}
+		}
+	}
+
+	var problems int
+	for _, f := range findings {
+		if f.Err != nil {
+			problems++
+			fmt.Fprintf(stdout, "%s\n", f)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: ok\n", f)
+	}
+
+	if problems > 0 {
+		return misuseError(fmt.Errorf("found %d invalid references out of %d",
+			problems, len(findings)))
+	}
+	return nil
+}
+
+// lintFile finds references in the named file, or stdin if the name is "-".
+func lintFile(name string) ([]*lint.Finding, error) {
+	if name == "-" {
+		return lint.Find(stdin, "<stdin>")
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	return lint.Find(f, name)
+}
+
+// lintCheckReferences returns nil if any of the given references exists and
+// is accessible by the caller, as a fallback chain would be resolved. Results
+// are cached by reference, since the same secret is often referenced many
+// times.
+func lintCheckReferences(ctx context.Context, client *berglas.Client, refs []*berglas.Reference, cache map[string]error) error {
+	var errs []error
+	for _, ref := range refs {
+		key := ref.String()
+		err, ok := cache[key]
+		if !ok {
+			err = lintCheckReference(ctx, client, ref)
+			cache[key] = err
+		}
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// lintCheckReference returns an error if the secret does not exist or the
+// caller lacks the permissions needed to access it.
+func lintCheckReference(ctx context.Context, client *berglas.Client, ref *berglas.Reference) error {
+	var resp *berglas.CanIResponse
+	var err error
+	switch ref.Type() {
+	case berglas.ReferenceTypeSecretManager:
+		resp, err = client.CanI(ctx, &berglas.SecretManagerCanIRequest{
+			Project: ref.Project(),
+			Name:    ref.Name(),
+			Action:  berglas.CanIAccess,
+		})
+	case berglas.ReferenceTypeStorage:
+		resp, err = client.CanI(ctx, &berglas.StorageCanIRequest{
+			Bucket: ref.Bucket(),
+			Object: ref.Object(),
+			Action: berglas.CanIAccess,
+		})
+	default:
+		return fmt.Errorf("unknown type %T", ref.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", ref, err)
+	}
+	if !resp.Exists {
+		return fmt.Errorf("%s: secret does not exist", ref)
+	}
+
+	if missing := resp.Missing(); len(missing) > 0 {
+		return fmt.Errorf("%s: missing permission %s on %s", ref,
+			missing[0].Permission, missing[0].Resource)
+	}
+	if !resp.Allowed {
+		return fmt.Errorf("%s: cannot determine the kms key", ref)
+	}
+	return nil
+}
+
+func listRun(cmd *cobra.Command, args []string) error {
+	if listFormat != "table" && listFormat != "json" {
+		return misuseError(fmt.Errorf("unknown format %q (must be table or json)", listFormat))
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	var list *berglas.ListResponse
+
+	switch {
+	case strings.HasPrefix(args[0], "sm://"):
+		if len(listStates) > 0 && !listGenerations {
+			return misuseError(fmt.Errorf("--state requires --all-generations"))
+		}
+
+		states := make([]string, len(listStates))
+		for i, state := range listStates {
+			states[i] = strings.ToUpper(state)
+		}
+
+		project := strings.Trim(strings.TrimPrefix(args[0], "sm://"), "/")
+		list, err = client.List(ctx, &berglas.SecretManagerListRequest{
+			Project:      project,
+			Prefix:       listPrefix,
+			Versions:     listGenerations,
+			States:       states,
+			WithMetadata: listWithMetadata,
+		})
+		if err != nil {
+			return apiError(err)
+		}
+
+		if listFormat == "json" {
+			return printListJSON(list.Secrets)
+		}
+
+		if len(list.Secrets) == 0 {
+			printNoSecrets()
+			return nil
+		}
+
+		tw := new(tabwriter.Writer)
+		tw.Init(stdout, 0, 4, 4, ' ', 0)
+		switch {
+		case listGenerations && listWithMetadata:
+			fmt.Fprintf(tw, "NAME\tVERSION\tSTATE\tUPDATED\tSIZE\tCRC32C\n")
+			for _, s := range list.Secrets {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%08x\n", s.Name, s.Version, s.State,
+					s.UpdatedAt.Local(), s.Size, s.CRC32C)
+			}
+		case listGenerations:
+			fmt.Fprintf(tw, "NAME\tVERSION\tSTATE\tUPDATED\n")
+			for _, s := range list.Secrets {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Name, s.Version, s.State, s.UpdatedAt.Local())
+			}
+		case listWithMetadata:
+			fmt.Fprintf(tw, "NAME\tVERSION\tUPDATED\tSIZE\tCRC32C\n")
+			for _, s := range list.Secrets {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%08x\n", s.Name, s.Version, s.UpdatedAt.Local(),
+					s.Size, s.CRC32C)
+			}
+		default:
+			fmt.Fprintf(tw, "NAME\tVERSION\tUPDATED\n")
+			for _, s := range list.Secrets {
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name, s.Version, s.UpdatedAt.Local())
+			}
+		}
+		tw.Flush()
+	default:
+		if len(listStates) > 0 {
+			return misuseError(fmt.Errorf("--state is only supported for Secret Manager secrets"))
+		}
+
+		bucket := strings.Trim(strings.TrimPrefix(args[0], "gs://"), "/")
+		list, err = client.List(ctx, &berglas.ListRequest{
+			Bucket:      bucket,
+			Prefix:      listPrefix,
+			Generations: listGenerations,
+		})
+		if err != nil {
+			return apiError(err)
+		}
+
+		if listFormat == "json" {
+			return printListJSON(list.Secrets)
+		}
+
+		if len(list.Secrets) == 0 {
+			printNoSecrets()
+			return nil
+		}
+
+		tw := new(tabwriter.Writer)
+		tw.Init(stdout, 0, 4, 4, ' ', 0)
+		switch {
+		case listGenerations && listWithMetadata:
+			fmt.Fprintf(tw, "NAME\tGENERATION\tSTATE\tUPDATED\tSIZE\tCRC32C\tKMS KEY\n")
+			for _, s := range list.Secrets {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%d\t%08x\t%s\n", s.Name, s.Generation, s.State,
+					s.UpdatedAt.Local(), s.Size, s.CRC32C, s.KMSKey)
+			}
+		case listGenerations:
+			fmt.Fprintf(tw, "NAME\tGENERATION\tSTATE\tUPDATED\n")
+			for _, s := range list.Secrets {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", s.Name, s.Generation, s.State, s.UpdatedAt.Local())
+			}
+		case listWithMetadata:
+			fmt.Fprintf(tw, "NAME\tGENERATION\tUPDATED\tSIZE\tCRC32C\tKMS KEY\n")
+			for _, s := range list.Secrets {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%08x\t%s\n", s.Name, s.Generation, s.UpdatedAt.Local(),
+					s.Size, s.CRC32C, s.KMSKey)
+			}
+		default:
+			fmt.Fprintf(tw, "NAME\tGENERATION\tUPDATED\n")
+			for _, s := range list.Secrets {
+				fmt.Fprintf(tw, "%s\t%d\t%s\n", s.Name, s.Generation, s.UpdatedAt.Local())
+			}
+		}
+		tw.Flush()
+	}
+
+	return nil
+}
+
+// listResult is a listed secret, printed as JSON with --format json. The
+// fields are always written in this order, and the metadata fields are present
+// exactly when --with-metadata is given, regardless of their values.
+type listResult struct {
+	// Name is the name of the secret.
+	Name string `json:"name"`
+
+	// Version is the Secret Manager version or Cloud Storage generation.
+	Version string `json:"version"`
+
+	// State is the state of the version. It is only set with
+	// --all-generations.
+	State string `json:"state,omitempty"`
+
+	// UpdateTime is when the version was created, in UTC.
+	UpdateTime time.Time `json:"update_time"`
+
+	// Size is the size of the stored data in bytes.
+	Size *int64 `json:"size,omitempty"`
+
+	// CRC32C is the CRC32C checksum (Castagnoli polynomial) of the stored data.
+	CRC32C *uint32 `json:"crc32c,omitempty"`
+
+	// KMSKey is the key used to encrypt the secret key. Cloud Storage only.
+	KMSKey string `json:"kms_key,omitempty"`
+}
+
+// printListJSON prints the secrets as a JSON array of listResult, in the order
+// returned by the client.
+func printListJSON(secrets []*berglas.Secret) error {
+	results := make([]*listResult, 0, len(secrets))
+	for _, s := range secrets {
+		r := &listResult{
+			Name:       s.Name,
+			Version:    secretVersion(s),
+			State:      s.State,
+			UpdateTime: s.UpdatedAt.UTC(),
+		}
+		if listWithMetadata {
+			size, crc := s.Size, s.CRC32C
+			r.Size, r.CRC32C, r.KMSKey = &size, &crc, s.KMSKey
+		}
+		results = append(results, r)
+	}
+
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return apiError(fmt.Errorf("failed to marshal result: %w", err))
+	}
+	fmt.Fprintf(stdout, "%s\n", b)
+	return nil
+}
+
+// printNoSecrets reports that list found no secrets, unless --quiet was given.
+func printNoSecrets() {
+	if listQuiet {
+		return
+	}
+	fmt.Fprintln(stdout, "No secrets found")
+}
+
+func parseRefRun(cmd *cobra.Command, args []string) error {
+	if parseRefSchema {
+		if len(args) > 0 {
+			return misuseError(fmt.Errorf("--schema does not take a reference"))
+		}
+		fmt.Fprint(stdout, berglas.ReferenceComponentsSchema)
+		return nil
+	}
+	if len(args) != 1 {
+		return misuseError(fmt.Errorf("expected a reference"))
+	}
+	if parseRefFormat != "text" && parseRefFormat != "json" {
+		return misuseError(fmt.Errorf("unknown format %q (must be text or json)", parseRefFormat))
+	}
+
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
+
+	// Parsing a tempfile destination creates the file, which is not needed
+	// since nothing is resolved.
+	switch ref.Destination() {
+	case "tempfile", "tmpfile":
+		os.Remove(ref.Filepath())
+	}
+
+	c := ref.Components()
+	if parseRefFormat == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(c); err != nil {
+			return fmt.Errorf("failed to encode reference: %w", err)
+		}
+		return nil
+	}
+
+	tw := new(tabwriter.Writer)
+	tw.Init(stdout, 0, 4, 4, ' ', 0)
+	fmt.Fprintf(tw, "Type:\t%s\n", c.Type)
+	fmt.Fprintf(tw, "Reference:\t%s\n", c.Reference)
+	for _, f := range []struct{ name, value string }{
+		{"Project", c.Project},
+		{"Name", c.Name},
+		{"Version", c.Version},
+		{"Bucket", c.Bucket},
+		{"Object", c.Object},
+		{"Destination", c.Destination},
+		{"Quota project", c.QuotaProject},
+		{"Encoding", c.Encoding},
+		{"JSON key", c.JSONKey},
+	} {
+		if f.value != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", f.name, f.value)
+		}
+	}
+	if c.Generation != 0 {
+		fmt.Fprintf(tw, "Generation:\t%d\n", c.Generation)
+	}
+	if c.Directory {
+		fmt.Fprintf(tw, "Directory:\ttrue\n")
+	}
+	if c.Trim {
+		fmt.Fprintf(tw, "Trim:\ttrue\n")
+	}
+	tw.Flush()
+	return nil
+}
+
+func pruneRun(cmd *cobra.Command, args []string) error {
+	bucket := strings.Trim(strings.TrimPrefix(args[0], "gs://"), "/")
+
+	if !pruneDryRun {
+		if err := confirmDestructive(
+			fmt.Sprintf("This will permanently delete all expired secrets in bucket [%s].", bucket),
+			"the bucket name", bucket); err != nil {
+			return misuseError(err)
+		}
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	resp, err := client.Prune(ctx, &berglas.StoragePruneRequest{
+		Bucket: bucket,
+		Prefix: listPrefix,
+		DryRun: pruneDryRun,
+	})
+	if resp != nil {
+		verb := "Deleted"
+		if pruneDryRun {
+			verb = "Would delete"
+		}
+		for _, s := range resp.Secrets {
+			fmt.Fprintf(stdout, "%s expired secret [%s] (expired %s)\n",
+				verb, s.Name, s.ExpiresAt.Local())
+		}
+	}
+	if err != nil {
+		return apiError(err)
+	}
+	return nil
+}
+
+func pruneGenerationsRun(cmd *cobra.Command, args []string) error {
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
+	if ref.Type() != berglas.ReferenceTypeStorage {
+		return misuseError(fmt.Errorf("prune-generations is only supported for " +
+			"Cloud Storage secrets"))
+	}
+
+	if !pruneDryRun {
+		if err := confirmDestructive(
+			fmt.Sprintf("This will permanently delete all but the %d most recent "+
+				"generations of secret [%s].", pruneKeep, ref.Object()),
+			"the secret name", ref.Object()); err != nil {
+			return misuseError(err)
+		}
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	resp, err := client.PruneGenerations(ctx, &berglas.StoragePruneGenerationsRequest{
+		Bucket: ref.Bucket(),
+		Object: ref.Object(),
+		Keep:   pruneKeep,
+		DryRun: pruneDryRun,
+	})
+	if resp != nil {
+		verb := "Deleted"
+		if pruneDryRun {
+			verb = "Would delete"
+		}
+		for _, s := range resp.Secrets {
+			fmt.Fprintf(stdout, "%s generation [%d] of secret [%s] (updated %s)\n",
+				verb, s.Generation, s.Name, s.UpdatedAt.Local())
+		}
+	}
+	if err != nil {
+		return apiError(err)
+	}
+	return nil
+}
+
+func migrateRun(cmd *cobra.Command, args []string) error {
+	bucket := strings.Trim(strings.TrimPrefix(args[0], "gs://"), "/")
+
+	if migrateDeleteSource {
+		if err := confirmDestructive(
+			fmt.Sprintf("This will permanently delete each migrated secret from bucket [%s].", bucket),
+			"the bucket name", bucket); err != nil {
+			return misuseError(err)
+		}
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	return migrateBucket(ctx, client, bucket, stdout, stderr)
+}
+
+// migrateClient is the client migrate copies secrets and their members with.
+type migrateClient interface {
+	berglas.SecretClient
+
+	Members(ctx context.Context, i berglas.MembersRequester) ([]string, error)
+}
+
+// migrateBucket migrates every generation of each secret in the bucket to
+// Secret Manager, printing progress to stdout. When the report is written to
+// stdout, progress is printed to stderr instead so stdout is only the report.
+func migrateBucket(ctx context.Context, client migrateClient, bucket string, stdout, stderr io.Writer) error {
+	out := stdout
+	if migrateReportPath == "-" {
+		out = stderr
+	}
+
+	storageList, err := client.List(ctx, &berglas.StorageListRequest{
+		Bucket:      bucket,
+		Generations: true,
+	})
+	if err != nil {
+		return apiError(err)
+	}
+
+	// Listing returns the newest generation first. Migrate oldest first so the
+	// latest Secret Manager version holds the live generation.
+	sort.SliceStable(storageList.Secrets, func(i, j int) bool {
+		a, b := storageList.Secrets[i], storageList.Secrets[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Generation < b.Generation
+	})
+
+	report := new(migrateReport)
+
+	bar := newProgress("Migrating")
+	bar.Start(len(storageList.Secrets))
+	defer bar.Finish()
+
+	// Listing returns all generations, but IAM only needs to be copied (and the
+	// source deleted) once per secret. Track per-secret state by source name.
+	grantedMembers := make(map[string][]string)
+	failedSources := make(map[string]bool)
+	var sources []*berglas.Secret
+
+	for _, s := range storageList.Secrets {
+		bar.Increment(1)
+
+		name := strings.Replace(s.Name, "/", "_", -1)
+		fmt.Fprintf(out, "Migrating %s to projects/%s/secrets/%s... ",
+			s.Name, projectID, name)
+
+		result := &migrateResult{
+			Source:      fmt.Sprintf("%s/%s", s.Parent, s.Name),
+			Generation:  s.Generation,
+			Destination: fmt.Sprintf("projects/%s/secrets/%s", projectID, name),
+		}
+		report.Secrets = append(report.Secrets, result)
+
+		if len(sources) == 0 || sources[len(sources)-1].Name != s.Name {
+			sources = append(sources, s)
+		}
+
+		fail := func(err error) {
+			fmt.Fprintf(out, "failed: %s\n", err)
+			result.Status = migrateStatusFailed
+			result.Error = err.Error()
+			failedSources[s.Name] = true
+			report.Failed++
+		}
+
+		skipped, err := migrateGeneration(ctx, client, s, projectID, name, migrateVerify, result)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		if skipped {
+			fmt.Fprintf(out, "skip (empty plaintext)\n")
+			result.Status = migrateStatusSkipped
+			report.Skipped++
+			continue
+		}
+
+		if members, ok := grantedMembers[s.Name]; ok {
+			result.Members = members
+		} else if migrateWithIAM {
+			members, err := client.Members(ctx, &berglas.StorageMembersRequest{
+				Bucket: s.Parent,
+				Object: s.Name,
+			})
+			if err != nil {
+				fail(err)
+				continue
+			}
+
+			if err := client.Grant(ctx, &berglas.SecretManagerGrantRequest{
+				Project: projectID,
+				Name:    name,
+				Members: members,
+			}); err != nil {
+				fail(err)
+				continue
+			}
+
+			grantedMembers[s.Name] = members
+			result.Members = members
+		}
+
+		fmt.Fprintf(out, "done!\n")
+		result.Status = migrateStatusMigrated
+		report.Migrated++
 	}
 
-	if err != nil {
-		return apiError(err)
+	if migrateWithIAM && len(grantedMembers) > 0 {
+		fmt.Fprintf(out, "\n")
+
+		tw := new(tabwriter.Writer)
+		tw.Init(out, 0, 4, 4, ' ', 0)
+		fmt.Fprintf(tw, "SECRET\tMEMBER\n")
+		for _, s := range sources {
+			members, ok := grantedMembers[s.Name]
+			if !ok {
+				continue
+			}
+
+			name := strings.Replace(s.Name, "/", "_", -1)
+			if len(members) == 0 {
+				fmt.Fprintf(tw, "%s\t%s\n", name, "(none)")
+				continue
+			}
+			for _, m := range members {
+				fmt.Fprintf(tw, "%s\t%s\n", name, m)
+			}
+		}
+		tw.Flush()
 	}
 
-	// Create the tempfile
-	f, err := os.CreateTemp("", "berglas-")
-	if err != nil {
-		err = fmt.Errorf("failed to create tempfile for secret: %w", err)
-		return apiError(err)
+	// Only delete sources where every generation migrated successfully (and
+	// verified, if requested).
+	if migrateDeleteSource {
+		fmt.Fprintf(out, "\n")
+
+		for _, s := range sources {
+			if failedSources[s.Name] {
+				fmt.Fprintf(out, "Not deleting %s/%s (migration failed)\n", s.Parent, s.Name)
+				continue
+			}
+
+			if err := client.Delete(ctx, &berglas.StorageDeleteRequest{
+				Bucket: s.Parent,
+				Object: s.Name,
+			}); err != nil {
+				return apiError(fmt.Errorf("failed to delete source %s/%s: %w",
+					s.Parent, s.Name, err))
+			}
+			fmt.Fprintf(out, "Deleted source %s/%s\n", s.Parent, s.Name)
+
+			for _, r := range report.Secrets {
+				if r.Source == fmt.Sprintf("%s/%s", s.Parent, s.Name) {
+					r.SourceDeleted = true
+				}
+			}
+		}
 	}
 
-	defer func() {
-		if err := os.Remove(f.Name()); err != nil {
-			fmt.Fprintf(stderr, "failed to cleanup tempfile %s: %s\n", f.Name(), err)
+	fmt.Fprintf(out, "\nMigrated: %d, skipped: %d, failed: %d\n",
+		report.Migrated, report.Skipped, report.Failed)
+
+	if migrateReportPath != "" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return apiError(fmt.Errorf("failed to marshal report: %w", err))
 		}
-	}()
+		b = append(b, '\n')
+
+		if migrateReportPath == "-" {
+			if _, err := stdout.Write(b); err != nil {
+				return apiError(fmt.Errorf("failed to write report: %w", err))
+			}
+		} else if err := os.WriteFile(migrateReportPath, b, 0o600); err != nil {
+			return apiError(fmt.Errorf("failed to write report: %w", err))
+		}
+	}
 
-	// Write contents to the original file
-	if _, err := f.Write(originalSecret.Plaintext); err != nil {
-		err = fmt.Errorf("failed to write tempfile for secret: %w", err)
-		return apiError(err)
+	if report.Failed > 0 {
+		return apiError(fmt.Errorf("failed to migrate %d secret(s)", report.Failed))
 	}
+	return nil
+}
 
-	if err := f.Sync(); err != nil {
-		err = fmt.Errorf("failed to sync tempfile for secret: %w", err)
-		return apiError(err)
+// migrateGeneration copies the given Cloud Storage generation to a new version
+// of the named Secret Manager secret, recording the version (and whether it
+// was verified) on result. It returns true if the generation was skipped
+// because its plaintext is empty.
+func migrateGeneration(ctx context.Context, client berglas.Interface, s *berglas.Secret,
+	project, name string, verify bool, result *migrateResult,
+) (bool, error) {
+	secret, err := client.Read(ctx, &berglas.StorageReadRequest{
+		Bucket:     s.Parent,
+		Object:     s.Name,
+		Generation: s.Generation,
+	})
+	if err != nil {
+		return false, err
 	}
 
-	if err := f.Close(); err != nil {
-		err = fmt.Errorf("failed to close tempfile for secret: %w", err)
-		return apiError(err)
+	if len(secret.Plaintext) == 0 {
+		return true, nil
 	}
 
-	// Spawn editor
-	editorSplit := strings.Split(editor, " ")
-	editorCmd, editorArgs := editorSplit[0], editorSplit[1:]
-	editorArgs = append(editorArgs, f.Name())
-	externalCmd := exec.CommandContext(ctx, editorCmd, editorArgs...)
-	externalCmd.Stdin = stdin
-	externalCmd.Stdout = stdout
-	externalCmd.Stderr = stderr
-	if err := externalCmd.Start(); err != nil {
-		err = fmt.Errorf("failed to start editor: %w", err)
-		return misuseError(err)
+	updated, err := client.Update(ctx, &berglas.SecretManagerUpdateRequest{
+		Project:         project,
+		Name:            name,
+		Plaintext:       secret.Plaintext,
+		CreateIfMissing: true,
+	})
+	if err != nil {
+		return false, err
 	}
-	if err := externalCmd.Wait(); err != nil {
-		if terr, ok := err.(*exec.ExitError); ok && terr.ProcessState != nil {
-			code := terr.ProcessState.ExitCode()
-			return exitWithCode(code, fmt.Errorf("editor did not exit 0: %w", err))
+	result.Version = updated.Version
+
+	if verify {
+		plaintext, err := client.Access(ctx, &berglas.SecretManagerAccessRequest{
+			Project: project,
+			Name:    name,
+			Version: updated.Version,
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to verify: %w", err)
 		}
-		err = fmt.Errorf("unknown failure in running editor: %w", err)
-		return misuseError(err)
+		if !bytes.Equal(plaintext, secret.Plaintext) {
+			return false, fmt.Errorf("failed to verify: migrated value does not match source")
+		}
+		result.Verified = true
 	}
+	return false, nil
+}
 
-	// Read the new secret value
-	newPlaintext, err := os.ReadFile(f.Name())
-	if err != nil {
-		err = fmt.Errorf("failed to read secret tempfile: %w", err)
-		return misuseError(err)
+const (
+	migrateStatusMigrated = "migrated"
+	migrateStatusSkipped  = "skipped"
+	migrateStatusFailed   = "failed"
+)
+
+// migrateReport is the machine-readable summary of a migration.
+type migrateReport struct {
+	Migrated int              `json:"migrated"`
+	Skipped  int              `json:"skipped"`
+	Failed   int              `json:"failed"`
+	Secrets  []*migrateResult `json:"secrets"`
+}
+
+// migrateResult is the result of migrating a single source generation.
+type migrateResult struct {
+	Source        string   `json:"source"`
+	Generation    int64    `json:"generation"`
+	Destination   string   `json:"destination"`
+	Version       string   `json:"version,omitempty"`
+	Status        string   `json:"status"`
+	Verified      bool     `json:"verified"`
+	SourceDeleted bool     `json:"source_deleted"`
+	Members       []string `json:"members,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+func reportRun(cmd *cobra.Command, args []string) error {
+	if reportFormat != "json" && reportFormat != "csv" {
+		return misuseError(fmt.Errorf("invalid format %q: must be json or csv", reportFormat))
 	}
 
-	// Error if the secret is empty
-	if len(newPlaintext) == 0 {
-		err := fmt.Errorf("secret is empty")
-		return misuseError(err)
+	pricing := report.DefaultPricing()
+	if reportPricing != "" {
+		f, err := os.Open(reportPricing)
+		if err != nil {
+			return misuseError(fmt.Errorf("failed to open pricing: %w", err))
+		}
+		pricing, err = report.LoadPricing(f)
+		f.Close()
+		if err != nil {
+			return misuseError(err)
+		}
 	}
 
-	if bytes.Equal(newPlaintext, originalSecret.Plaintext) {
-		err := fmt.Errorf("secret unchanged - not going to update")
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
 		return misuseError(err)
 	}
+	defer client.Close()
 
-	// Update the secret
-	switch t := ref.Type(); t {
-	case berglas.ReferenceTypeSecretManager:
-		updatedSecret, err := client.Update(ctx, &berglas.SecretManagerUpdateRequest{
-			Project:   ref.Project(),
-			Name:      ref.Name(),
-			Plaintext: newPlaintext,
-		})
+	var r *report.Report
+	if strings.HasPrefix(args[0], "sm://") {
+		project := strings.Trim(strings.TrimPrefix(args[0], "sm://"), "/")
+		secrets, err := reportSecretManager(ctx, client, project)
 		if err != nil {
-			err = fmt.Errorf("failed to update secret: %w", err)
-			return misuseError(err)
+			return apiError(err)
+		}
+		r = report.New(report.TypeSecretManager, args[0], secrets, pricing)
+	} else {
+		if reportWithSizes {
+			return misuseError(fmt.Errorf("--with-sizes is only supported for Secret Manager secrets"))
 		}
 
-		fmt.Fprintf(stdout, "Successfully updated secret [%s] to version [%s]\n",
-			updatedSecret.Name, updatedSecret.Version)
-	case berglas.ReferenceTypeStorage:
-		updatedSecret, err := client.Update(ctx, &berglas.StorageUpdateRequest{
-			Bucket:         ref.Bucket(),
-			Object:         ref.Object(),
-			Generation:     originalSecret.Generation,
-			Key:            originalSecret.KMSKey,
-			Metageneration: originalSecret.Metageneration,
-			Plaintext:      newPlaintext,
-		})
+		bucket := strings.Trim(strings.TrimPrefix(args[0], "gs://"), "/")
+		secrets, err := reportStorage(ctx, client, bucket)
 		if err != nil {
-			err = fmt.Errorf("failed to update secret: %w", err)
-			return misuseError(err)
+			return apiError(err)
 		}
-
-		fmt.Fprintf(stdout, "Successfully updated secret [%s] with generation [%d]\n",
-			updatedSecret.Name, updatedSecret.Generation)
-	default:
-		return misuseError(fmt.Errorf("unknown type %T", t))
+		r = report.New(report.TypeStorage, args[0], secrets, pricing)
 	}
 
-	return nil
+	if reportFormat == "csv" {
+		return r.WriteCSV(stdout)
+	}
+	return r.WriteJSON(stdout)
 }
 
-func execRun(cmd *cobra.Command, args []string) error {
-	ctx, client, err := clientWithContext(cmd.Context())
+// reportSecretManager returns the inventory of the secrets in the project.
+// Secrets without any versions are included.
+func reportSecretManager(ctx context.Context, client *berglas.Client, project string) ([]*report.Secret, error) {
+	list, err := client.List(ctx, &berglas.SecretManagerListRequest{
+		Project: project,
+	})
 	if err != nil {
-		return misuseError(err)
+		return nil, err
 	}
 
-	execCmd := args[0]
-	execArgs := args[1:]
+	byName := make(map[string]*report.Secret, len(list.Secrets))
+	secrets := make([]*report.Secret, 0, len(list.Secrets))
+	for _, s := range list.Secrets {
+		rs := &report.Secret{
+			Name:      s.Name,
+			Locations: s.Locations,
+			UpdatedAt: s.UpdatedAt,
+		}
+		byName[s.Name] = rs
+		secrets = append(secrets, rs)
+	}
 
-	// Parse local env
-	env := os.Environ()
+	versions, err := client.List(ctx, &berglas.SecretManagerListRequest{
+		Project:      project,
+		Versions:     true,
+		WithMetadata: reportWithSizes,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	for i, e := range env {
-		p := strings.SplitN(e, "=", 2)
-		if len(p) < 2 {
+	for _, v := range versions.Secrets {
+		rs, ok := byName[v.Name]
+		if !ok {
+			// Created after the first list.
 			continue
 		}
-
-		k, v := p[0], p[1]
-		if !berglas.IsReference(v) {
-			continue
+		rs.Versions++
+		if v.State != "DESTROYED" {
+			rs.ActiveVersions++
 		}
-
-		s, err := client.Resolve(ctx, v)
-		if err != nil {
-			return apiError(err)
+		rs.SizeBytes += v.Size
+		if v.UpdatedAt.After(rs.UpdatedAt) {
+			rs.UpdatedAt = v.UpdatedAt
 		}
-		env[i] = fmt.Sprintf("%s=%s", k, s)
 	}
+	return secrets, nil
+}
 
-	execCmdFull, err := exec.LookPath(execCmd)
+// reportStorage returns the inventory of the secrets in the bucket.
+func reportStorage(ctx context.Context, client *berglas.Client, bucket string) ([]*report.Secret, error) {
+	list, err := client.List(ctx, &berglas.StorageListRequest{
+		Bucket:      bucket,
+		Generations: true,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to lookup path for %q: %w", execCmd, err)
+		return nil, err
 	}
 
-	// Unlike os/exec, execv(3) expects the arguments to include the command.
-	execArgs = append([]string{execCmdFull}, execArgs...)
+	byName := make(map[string]*report.Secret)
+	latest := make(map[string]int64)
+	var secrets []*report.Secret
+	for _, g := range list.Secrets {
+		rs, ok := byName[g.Name]
+		if !ok {
+			rs = &report.Secret{Name: g.Name}
+			byName[g.Name] = rs
+			secrets = append(secrets, rs)
+		}
 
-	if err := syscall.Exec(execCmdFull, execArgs, env); err != nil {
-		return fmt.Errorf("failed to execute %q: %w", execCmd, err)
+		// Every stored generation is billed.
+		rs.Versions++
+		rs.ActiveVersions++
+		rs.SizeBytes += g.Size
+
+		// The key of the latest generation is the one in use.
+		if g.Generation > latest[g.Name] {
+			latest[g.Name] = g.Generation
+			rs.KMSKey = g.KMSKey
+		}
+		if g.UpdatedAt.After(rs.UpdatedAt) {
+			rs.UpdatedAt = g.UpdatedAt
+		}
 	}
-	return nil
+	return secrets, nil
 }
 
-func grantRun(cmd *cobra.Command, args []string) error {
-	ctx, client, err := clientWithContext(cmd.Context())
+func restoreRun(cmd *cobra.Command, args []string) error {
+	ref, err := parseRef(args[0])
 	if err != nil {
 		return misuseError(err)
 	}
+	if ref.Type() != berglas.ReferenceTypeSecretManager || ref.Version() == "" {
+		return misuseError(fmt.Errorf("restore requires a Secret Manager reference with a version, " +
+			"such as sm://my-project/api-key#3"))
+	}
 
-	ref, err := parseRef(args[0])
+	ctx, client, err := clientWithContext(cmd.Context())
 	if err != nil {
 		return misuseError(err)
 	}
+	defer client.Close()
 
-	sort.Strings(members)
-
-	switch t := ref.Type(); t {
-	case berglas.ReferenceTypeSecretManager:
-		if err := client.Grant(ctx, &berglas.SecretManagerGrantRequest{
-			Project: ref.Project(),
-			Name:    ref.Name(),
-			Members: members,
-		}); err != nil {
-			return apiError(err)
-		}
-		fmt.Fprintf(stdout, "Successfully granted permission on [%s] to: \n- %s\n",
-			ref.Name(), strings.Join(members, "\n- "))
-	case berglas.ReferenceTypeStorage:
-		if err := client.Grant(ctx, &berglas.StorageGrantRequest{
-			Bucket:  ref.Bucket(),
-			Object:  ref.Object(),
-			Members: members,
-		}); err != nil {
-			return apiError(err)
-		}
-		fmt.Fprintf(stdout, "Successfully granted permission on [%s] to: \n- %s\n",
-			ref.Object(), strings.Join(members, "\n- "))
-	default:
-		return misuseError(fmt.Errorf("unknown type %T", t))
+	if _, err := client.Restore(ctx, &berglas.SecretManagerRestoreRequest{
+		Project: ref.Project(),
+		Name:    ref.Name(),
+		Version: ref.Version(),
+	}); err != nil {
+		return apiError(err)
 	}
 
+	fmt.Fprintf(stdout, "Successfully restored version [%s] of secret [%s]\n",
+		ref.Version(), ref.Name())
 	return nil
 }
 
-func listRun(cmd *cobra.Command, args []string) error {
+func revokeRun(cmd *cobra.Command, args []string) error {
+	scope := berglas.SecretManagerScope(membersScope)
+	if !berglas.IsSecretManagerScope(membersScope) {
+		return misuseError(fmt.Errorf("invalid scope %q: must be secret or project", membersScope))
+	}
+
+	var project string
+	if scope == berglas.SecretManagerScopeProject {
+		p, err := parseProjectRef(args[0])
+		if err != nil {
+			return misuseError(err)
+		}
+		project = p
+	}
+
 	ctx, client, err := clientWithContext(cmd.Context())
 	if err != nil {
 		return misuseError(err)
 	}
+	defer client.Close()
 
-	var list *berglas.ListResponse
+	members, err := cliMembers(members)
+	if err != nil {
+		return misuseError(err)
+	}
 
-	switch {
-	case strings.HasPrefix(args[0], "sm://"):
-		project := strings.Trim(strings.TrimPrefix(args[0], "sm://"), "/")
-		list, err = client.List(ctx, &berglas.SecretManagerListRequest{
-			Project:  project,
-			Prefix:   listPrefix,
-			Versions: listGenerations,
+	sort.Strings(members)
+
+	if scope == berglas.SecretManagerScopeProject {
+		result, err := client.RevokeWithResult(ctx, &berglas.SecretManagerRevokeRequest{
+			Project: project,
+			Members: members,
+			DryRun:  membersDryRun,
+			Scope:   scope,
 		})
 		if err != nil {
 			return apiError(err)
 		}
+		printIAMResult("projects/"+project, result)
+		return nil
+	}
 
-		if len(list.Secrets) == 0 {
-			return nil
-		}
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
 
-		tw := new(tabwriter.Writer)
-		tw.Init(stdout, 0, 4, 4, ' ', 0)
-		fmt.Fprintf(tw, "NAME\tVERSION\tUPDATED\n")
-		for _, s := range list.Secrets {
-			fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name, s.Version, s.UpdatedAt.Local())
-		}
-		tw.Flush()
-	default:
-		bucket := strings.Trim(strings.TrimPrefix(args[0], "gs://"), "/")
-		list, err = client.List(ctx, &berglas.ListRequest{
-			Bucket:      bucket,
-			Prefix:      listPrefix,
-			Generations: listGenerations,
+	switch t := ref.Type(); t {
+	case berglas.ReferenceTypeSecretManager:
+		result, err := client.RevokeWithResult(ctx, &berglas.SecretManagerRevokeRequest{
+			Project: ref.Project(),
+			Name:    ref.Name(),
+			Members: members,
+			DryRun:  membersDryRun,
 		})
 		if err != nil {
 			return apiError(err)
 		}
-
-		if len(list.Secrets) == 0 {
-			return nil
+		printIAMResult(ref.Name(), result)
+	case berglas.ReferenceTypeStorage:
+		result, err := client.RevokeWithResult(ctx, &berglas.StorageRevokeRequest{
+			Bucket:           ref.Bucket(),
+			Object:           ref.Object(),
+			Members:          members,
+			DryRun:           membersDryRun,
+			AllowACLFallback: allowACLFallback,
+		})
+		if berglas.IsObjectIAMUnavailableErr(err) {
+			return apiError(fmt.Errorf("%w (use --allow-acl-fallback to use object ACLs instead)", err))
 		}
-
-		tw := new(tabwriter.Writer)
-		tw.Init(stdout, 0, 4, 4, ' ', 0)
-		fmt.Fprintf(tw, "NAME\tGENERATION\tUPDATED\n")
-		for _, s := range list.Secrets {
-			fmt.Fprintf(tw, "%s\t%d\t%s\n", s.Name, s.Generation, s.UpdatedAt.Local())
+		if err != nil {
+			return apiError(err)
 		}
-		tw.Flush()
+		printIAMResult(ref.Object(), result)
+	default:
+		return misuseError(fmt.Errorf("unknown type %T", t))
 	}
 
 	return nil
 }
 
-func migrateRun(cmd *cobra.Command, args []string) error {
+func statRun(cmd *cobra.Command, args []string) error {
 	ctx, client, err := clientWithContext(cmd.Context())
 	if err != nil {
 		return misuseError(err)
 	}
+	defer client.Close()
+
+	if args[0] == "-" {
+		return runBatch(func(ref *berglas.Reference) (any, error) {
+			backend, resp, err := statReference(ctx, client, ref)
+			if err != nil {
+				return nil, err
+			}
+			return newStatResult(backend, resp), nil
+		})
+	}
 
-	bucket := strings.Trim(strings.TrimPrefix(args[0], "gs://"), "/")
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
 
-	storageList, err := client.List(ctx, &berglas.StorageListRequest{
-		Bucket:      bucket,
-		Generations: true,
-	})
+	backend, resp, err := statReference(ctx, client, ref)
 	if err != nil {
 		return apiError(err)
 	}
 
-	for _, s := range storageList.Secrets {
-		name := strings.Replace(s.Name, "/", "_", -1)
-		fmt.Fprintf(stdout, "Migrating %s to projects/%s/secrets/%s... ",
-			s.Name, projectID, name)
-
-		secret, err := client.Read(ctx, &berglas.StorageReadRequest{
-			Bucket: s.Parent,
-			Object: s.Name,
-		})
-		if err != nil {
-			return apiError(err)
+	tw := new(tabwriter.Writer)
+	tw.Init(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Secret:\t%s\n", args[0])
+	fmt.Fprintf(tw, "Backend:\t%s\n", backend)
+	fmt.Fprintf(tw, "Exists:\t%t\n", resp.Exists)
+
+	if resp.Exists {
+		s := resp.Secret
+		switch ref.Type() {
+		case berglas.ReferenceTypeSecretManager:
+			replication := "automatic"
+			if len(s.Locations) > 0 {
+				replication = strings.Join(s.Locations, ", ")
+			}
+			fmt.Fprintf(tw, "Version:\t%s\n", s.Version)
+			fmt.Fprintf(tw, "Versions:\t%d\n", resp.Versions)
+			fmt.Fprintf(tw, "Updated:\t%s\n", s.UpdatedAt.Local())
+			fmt.Fprintf(tw, "Replication:\t%s\n", replication)
+			if s.ContentType != "" {
+				fmt.Fprintf(tw, "Content type:\t%s\n", s.ContentType)
+			}
+		case berglas.ReferenceTypeStorage:
+			fmt.Fprintf(tw, "Generation:\t%d\n", s.Generation)
+			fmt.Fprintf(tw, "Generations:\t%d\n", resp.Versions)
+			fmt.Fprintf(tw, "Updated:\t%s\n", s.UpdatedAt.Local())
+			fmt.Fprintf(tw, "Size:\t%d\n", s.Size)
+			fmt.Fprintf(tw, "KMS key:\t%s\n", s.KMSKey)
+			if s.ProtectionLevel != "" {
+				fmt.Fprintf(tw, "Protection level:\t%s\n", s.ProtectionLevel)
+			}
+			if !s.ExpiresAt.IsZero() {
+				fmt.Fprintf(tw, "Expires:\t%s\n", s.ExpiresAt.Local())
+			}
+			if s.ContentType != "" {
+				fmt.Fprintf(tw, "Content type:\t%s\n", s.ContentType)
+			}
 		}
 
-		if len(secret.Plaintext) == 0 {
-			fmt.Fprintf(stdout, "skip (empty plaintext)\n")
-			continue
-		}
+	}
+	tw.Flush()
 
-		if _, err := client.Update(ctx, &berglas.SecretManagerUpdateRequest{
-			Project:         projectID,
-			Name:            name,
-			Plaintext:       secret.Plaintext,
-			CreateIfMissing: true,
-		}); err != nil {
-			return apiError(err)
+	if resp.Exists {
+		fmt.Fprintf(stdout, "Permissions:\n")
+		for _, p := range resp.Permissions {
+			fmt.Fprintf(stdout, "  %s\n", p)
 		}
-
-		fmt.Fprintf(stdout, "done!\n")
 	}
 
 	return nil
 }
 
-func revokeRun(cmd *cobra.Command, args []string) error {
-	ctx, client, err := clientWithContext(cmd.Context())
-	if err != nil {
-		return misuseError(err)
-	}
-
-	ref, err := parseRef(args[0])
-	if err != nil {
-		return misuseError(err)
-	}
-
-	sort.Strings(members)
-
+// statReference describes the secret at the given reference, returning the name
+// of its storage backend.
+func statReference(ctx context.Context, client *berglas.Client, ref *berglas.Reference) (string, *berglas.StatResponse, error) {
 	switch t := ref.Type(); t {
 	case berglas.ReferenceTypeSecretManager:
-		if err := client.Revoke(ctx, &berglas.SecretManagerRevokeRequest{
+		resp, err := client.Stat(ctx, &berglas.SecretManagerStatRequest{
 			Project: ref.Project(),
 			Name:    ref.Name(),
-			Members: members,
-		}); err != nil {
-			return apiError(err)
-		}
-		fmt.Fprintf(stdout, "Successfully revoked permission on [%s] from: \n- %s\n",
-			ref.Name(), strings.Join(members, "\n- "))
+		})
+		return "Secret Manager", resp, err
 	case berglas.ReferenceTypeStorage:
-		if err := client.Revoke(ctx, &berglas.StorageRevokeRequest{
-			Bucket:  ref.Bucket(),
-			Object:  ref.Object(),
-			Members: members,
-		}); err != nil {
-			return apiError(err)
-		}
-		fmt.Fprintf(stdout, "Successfully revoked permission on [%s] from: \n- %s\n",
-			ref.Object(), strings.Join(members, "\n- "))
+		resp, err := client.Stat(ctx, &berglas.StorageStatRequest{
+			Bucket: ref.Bucket(),
+			Object: ref.Object(),
+		})
+		return "Cloud Storage", resp, err
 	default:
-		return misuseError(fmt.Errorf("unknown type %T", t))
+		return "", nil, fmt.Errorf("unknown type %T", t)
 	}
+}
 
-	return nil
+// statResult is the description of a secret, printed as JSON when references
+// are read from stdin.
+type statResult struct {
+	Exists      bool       `json:"exists"`
+	Backend     string     `json:"backend"`
+	Version     string     `json:"version,omitempty"`
+	Versions    int        `json:"versions,omitempty"`
+	UpdateTime  *time.Time `json:"update_time,omitempty"`
+	Size        int64      `json:"size,omitempty"`
+	KMSKey      string     `json:"kms_key,omitempty"`
+	Locations   []string   `json:"locations,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	Permissions []string   `json:"permissions,omitempty"`
+}
+
+// newStatResult builds the description of a secret for the given response.
+func newStatResult(backend string, resp *berglas.StatResponse) *statResult {
+	result := &statResult{
+		Exists:  resp.Exists,
+		Backend: backend,
+	}
+	if s := resp.Secret; resp.Exists && s != nil {
+		result.Version = secretVersion(s)
+		result.Versions = resp.Versions
+		result.UpdateTime = &s.UpdatedAt
+		result.Size = s.Size
+		result.KMSKey = s.KMSKey
+		result.Locations = s.Locations
+		result.ContentType = s.ContentType
+		result.Permissions = resp.Permissions
+	}
+	return result
 }
 
 func updateRun(cmd *cobra.Command, args []string) error {
@@ -1111,6 +4971,7 @@ func updateRun(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return misuseError(err)
 	}
+	defer client.Close()
 
 	ref, err := parseRef(args[0])
 	if err != nil {
@@ -1127,10 +4988,15 @@ func updateRun(cmd *cobra.Command, args []string) error {
 
 	switch t := ref.Type(); t {
 	case berglas.ReferenceTypeSecretManager:
+		if expiration != "" {
+			return misuseError(fmt.Errorf("expiration is unsupported for Secret Manager secrets"))
+		}
+
 		secret, err := client.Update(ctx, &berglas.SecretManagerUpdateRequest{
 			Project:         ref.Project(),
 			Name:            ref.Name(),
 			Plaintext:       plaintext,
+			Locations:       smLocations,
 			CreateIfMissing: createIfMissing,
 		})
 		if err != nil {
@@ -1139,18 +5005,44 @@ func updateRun(cmd *cobra.Command, args []string) error {
 		fmt.Fprintf(stdout, "Successfully updated secret [%s] to version [%s]\n",
 			secret.Name, secret.Version)
 	case berglas.ReferenceTypeStorage:
+		if len(smLocations) > 0 {
+			return misuseError(fmt.Errorf("locations on a per-secret basis unsupported for Storage keys"))
+		}
+
+		expiresAt, err := parseExpiration(expiration, time.Now())
+		if err != nil {
+			return misuseError(err)
+		}
+
 		secret, err := client.Update(ctx, &berglas.StorageUpdateRequest{
 			Bucket:          ref.Bucket(),
 			Object:          ref.Object(),
 			Key:             key,
 			Plaintext:       plaintext,
 			CreateIfMissing: createIfMissing,
+			ExpiresAt:       expiresAt,
 		})
 		if err != nil {
 			return apiError(err)
 		}
 		fmt.Fprintf(stdout, "Successfully updated secret [%s] to generation [%d]\n",
 			secret.Name, secret.Generation)
+	case berglas.ReferenceTypeS3:
+		if len(smLocations) > 0 || expiration != "" {
+			return misuseError(fmt.Errorf("locations and expiration are unsupported for S3 secrets"))
+		}
+
+		secret, err := client.Update(ctx, &berglas.StorageS3UpdateRequest{
+			Bucket:          ref.Bucket(),
+			Object:          ref.Object(),
+			Key:             key,
+			Plaintext:       plaintext,
+			CreateIfMissing: createIfMissing,
+		})
+		if err != nil {
+			return apiError(err)
+		}
+		fmt.Fprintf(stdout, "Successfully updated secret [%s]\n", secret.Name)
 	default:
 		return misuseError(fmt.Errorf("unknown type %T", t))
 	}
@@ -1158,6 +5050,171 @@ func updateRun(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// updateManySpec is the format of the file read by update-many.
+type updateManySpec struct {
+	Data    string `yaml:"data"`
+	Updates []struct {
+		Secret string `yaml:"secret"`
+		Data   string `yaml:"data"`
+	} `yaml:"updates"`
+}
+
+func updateManyRun(cmd *cobra.Command, args []string) error {
+	r := io.Reader(stdin)
+	if updateManyFile != "-" {
+		f, err := os.Open(updateManyFile)
+		if err != nil {
+			return misuseError(fmt.Errorf("failed to open file: %w", err))
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var spec updateManySpec
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil {
+		return misuseError(fmt.Errorf("failed to parse %s: %w", updateManyFile, err))
+	}
+	if len(spec.Updates) == 0 {
+		return misuseError(fmt.Errorf("%s contains no updates", updateManyFile))
+	}
+
+	// Read each distinct payload once, so a file or variable shared by every
+	// update is not read hundreds of times.
+	payloads := make(map[string][]byte)
+	reqs := make([]*berglas.SecretManagerUpdateRequest, 0, len(spec.Updates))
+	for idx, u := range spec.Updates {
+		ref, err := parseRef(u.Secret)
+		if err != nil {
+			return misuseError(fmt.Errorf("update %d: %w", idx, err))
+		}
+		if ref.Type() != berglas.ReferenceTypeSecretManager {
+			return misuseError(fmt.Errorf("update %d: %s is not a Secret Manager secret", idx, u.Secret))
+		}
+
+		data := cmp.Or(u.Data, spec.Data)
+		if data == "" {
+			return misuseError(fmt.Errorf("update %d: missing data", idx))
+		}
+		if strings.HasPrefix(data, "-") && updateManyFile == "-" {
+			return misuseError(fmt.Errorf("update %d: cannot read data from stdin "+
+				"when the file is read from stdin", idx))
+		}
+
+		plaintext, ok := payloads[data]
+		if !ok {
+			plaintext, err = readData(strings.TrimSpace(data))
+			if err != nil {
+				return misuseError(fmt.Errorf("update %d: %w", idx, err))
+			}
+			payloads[data] = plaintext
+		}
+
+		reqs = append(reqs, &berglas.SecretManagerUpdateRequest{
+			Project:         ref.Project(),
+			Name:            ref.Name(),
+			Plaintext:       plaintext,
+			CreateIfMissing: createIfMissing,
+		})
+	}
+
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	results, err := client.UpdateMany(withProgress(ctx, "Updating"), reqs)
+	if results == nil {
+		return apiError(err)
+	}
+
+	var failed int
+	tw := new(tabwriter.Writer)
+	tw.Init(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "SECRET\tVERSION\tSTATUS\n")
+	for idx, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Fprintf(tw, "%s\t\terror: %s\n", spec.Updates[idx].Secret, result.Err)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\tupdated\n", spec.Updates[idx].Secret, result.Secret.Version)
+	}
+	tw.Flush()
+
+	if failed > 0 {
+		return apiError(fmt.Errorf("failed to update %d of %d secrets", failed, len(results)))
+	}
+	return nil
+}
+
+func versionRun(cmd *cobra.Command, args []string) error {
+	info := berglas.BuildInfo()
+
+	switch versionFormat {
+	case "text":
+		tw := new(tabwriter.Writer)
+		tw.Init(stdout, 0, 4, 4, ' ', 0)
+		fmt.Fprintf(tw, "Name:\t%s\n", info.Name)
+		fmt.Fprintf(tw, "Version:\t%s\n", info.Version)
+		fmt.Fprintf(tw, "Commit:\t%s\n", info.Commit)
+		fmt.Fprintf(tw, "Date:\t%s\n", info.Date)
+		fmt.Fprintf(tw, "Go version:\t%s\n", info.GoVersion)
+		fmt.Fprintf(tw, "OS/Arch:\t%s\n", info.OSArch)
+		tw.Flush()
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(info); err != nil {
+			return fmt.Errorf("failed to encode version: %w", err)
+		}
+	default:
+		return misuseError(fmt.Errorf("unknown format %q (must be text or json)", versionFormat))
+	}
+	return nil
+}
+
+func watchRun(cmd *cobra.Command, args []string) error {
+	ctx, client, err := clientWithContext(cmd.Context())
+	if err != nil {
+		return misuseError(err)
+	}
+	defer client.Close()
+
+	ref, err := parseRef(args[0])
+	if err != nil {
+		return misuseError(err)
+	}
+
+	ctx = berglas.WithWatchPollInterval(ctx, watchInterval)
+
+	if err := client.Watch(ctx, ref.String(), func(s *berglas.Secret) {
+		version := s.Version
+		if version == "" {
+			version = strconv.FormatInt(s.Generation, 10)
+		}
+
+		if watchExec == "" {
+			fmt.Fprintf(stdout, "New version %s of %s\n", version, ref.String())
+			return
+		}
+
+		c := exec.CommandContext(ctx, "/bin/sh", "-c", watchExec)
+		c.Stdin = bytes.NewReader(s.Plaintext)
+		c.Stdout = stdout
+		c.Stderr = stderr
+		c.Env = append(os.Environ(), "BERGLAS_SECRET_VERSION="+version)
+		if err := c.Run(); err != nil {
+			fmt.Fprintf(stderr, "command failed for version %s: %s\n", version, err)
+		}
+	}); err != nil && !errors.Is(err, context.Canceled) {
+		return apiError(err)
+	}
+	return nil
+}
+
 // exitError is a typed error to return.
 type exitError struct {
 	err  error
@@ -1180,8 +5237,15 @@ func exitWithCode(code int, err error) *exitError {
 	}
 }
 
-// apiError returns the given error with an API error exit code.
+// apiError returns the given error with an API error exit code. Request
+// validation errors are returned with a userland exit code instead, since they
+// occur before any API calls are made.
 func apiError(err error) *exitError {
+	if berglas.IsValidationErr(err) || berglas.IsReadOnlyErr(err) ||
+		berglas.IsSecretTooLargeErr(err) || errors.Is(err, berglas.ErrNamingPolicyViolation) ||
+		errors.Is(err, berglas.ErrKeyDoesNotExist) {
+		return misuseError(err)
+	}
 	return exitWithCode(APIExitCode, err)
 }
 
@@ -1190,28 +5254,242 @@ func misuseError(err error) *exitError {
 	return exitWithCode(MisuseExitCode, err)
 }
 
+// commandTimeout returns the deadline for the given command. An explicit
+// --timeout takes precedence over the command's default.
+func commandTimeout(cmd *cobra.Command) time.Duration {
+	if timeout != 0 {
+		return timeout
+	}
+	name := strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+	return commandTimeouts[name]
+}
+
 // clientWithContext returns an instantiated berglas client and context with a
 // closer.
 func clientWithContext(ctx context.Context) (context.Context, *berglas.Client, error) {
-	logger, err := logging.New(stderr, logLevel, logFormat, logDebug)
+	// Mask resolved secret values if they ever end up in a log record, such as
+	// in an error message.
+	logOpts := []logging.Option{logging.WithRedactor(logredact.Default())}
+	if logHashKey != "" {
+		logOpts = append(logOpts, logging.WithHashedAttrs([]byte(logHashKey)))
+	}
+	if logDebugSampleRate != 1 {
+		logOpts = append(logOpts, logging.WithDebugSampleRate(logDebugSampleRate))
+	}
+
+	logger, err := logging.New(stderr, logLevel, logFormat, logDebug, logOpts...)
 	if err != nil {
 		return ctx, nil, err
 	}
 	ctx = logging.WithLogger(ctx, logger)
+	ctx = berglas.WithJustification(ctx, justification)
+
+	if useMTLS {
+		if err := enableMTLS(); err != nil {
+			return ctx, nil, err
+		}
+	}
+
+	opts := []option.ClientOption{
+		berglas.WithLogRedaction(logredact.Default()),
+		berglas.WithKeyWrapper(awskms.Scheme, awskms.New()),
+		berglas.WithS3Store(s3store.New(s3Endpoint)),
+	}
+	if readOnly {
+		opts = append(opts, berglas.WithReadOnly())
+	}
+	if ignorePolicy {
+		opts = append(opts, berglas.WithPolicyOverride())
+	}
+	if secretManagerPolicy {
+		opts = append(opts, berglas.WithSecretManagerPolicy())
+	}
+	if rollbackProtection {
+		opts = append(opts, berglas.WithRollbackProtection())
+	}
+	if !forceLarge {
+		opts = append(opts, berglas.WithMaxStoragePlaintextSize(berglas.DefaultStorageMaxPlaintextSize))
+	}
+	if bindAAD || aadContext != "" {
+		opts = append(opts, berglas.WithBoundAAD(aadContext))
+	}
+	if envelopeAlgorithm != "" {
+		if !berglas.IsEnvelopeAlgorithm(envelopeAlgorithm) {
+			return ctx, nil, fmt.Errorf("unsupported envelope algorithm %q", envelopeAlgorithm)
+		}
+		opts = append(opts, berglas.WithEnvelopeAlgorithm(berglas.EnvelopeAlgorithm(envelopeAlgorithm)))
+	}
+	if storageEndpoint != "" {
+		opts = append(opts, berglas.WithStorageEndpoint(storageEndpoint))
+	}
+	if secretManagerEndpoint != "" {
+		opts = append(opts, berglas.WithSecretManagerEndpoint(secretManagerEndpoint))
+	}
+	if kmsEndpoint != "" {
+		opts = append(opts, berglas.WithKMSEndpoint(kmsEndpoint))
+	}
+	storageKey, err := loadStorageEncryptionKey()
+	if err != nil {
+		return ctx, nil, err
+	}
+	if storageKey != nil {
+		opts = append(opts, berglas.WithStorageEncryptionKey(storageKey))
+	}
+	if cacheKey != "" {
+		dir, err := diskCacheDir()
+		if err != nil {
+			return ctx, nil, err
+		}
+		opts = append(opts, berglas.WithDiskCache(dir, cacheKey, cacheTTL))
+	}
+	if cacheMaxStaleness > 0 {
+		if cacheKey == "" {
+			return ctx, nil, fmt.Errorf("--cache-max-staleness requires --cache-key")
+		}
+		opts = append(opts, berglas.WithStaleCacheFallback(cacheMaxStaleness))
+	}
+	if circuitBreaker > 0 {
+		opts = append(opts, berglas.WithCircuitBreaker(circuitBreaker, 0))
+	}
+
+	if preferRegion != "" {
+		opts = append(opts, berglas.WithPreferredRegion(preferRegion))
+	}
+	if hedgeDelay > 0 {
+		opts = append(opts, berglas.WithHedging(hedgeDelay))
+	}
+
+	if showStats {
+		opts = append(opts, berglas.WithStats(&cliStats))
+	}
+
+	aliases, err := loadAliases()
+	if err != nil {
+		return ctx, nil, err
+	}
+	if len(aliases) > 0 {
+		opts = append(opts, berglas.WithAliases(aliases))
+	}
+
+	namingPolicy, err := loadNamingPolicy()
+	if err != nil {
+		return ctx, nil, err
+	}
+	if namingPolicy != nil {
+		opts = append(opts, berglas.WithNamingPolicy(namingPolicy))
+	}
 
-	client, err := berglas.New(ctx)
+	client, err := berglas.New(ctx, opts...)
 	if err != nil {
 		return ctx, nil, fmt.Errorf("failed to create berglas client: %w", err)
 	}
 	return ctx, client, nil
 }
 
+// enableMTLS configures the Google API client libraries to present the device
+// client certificate. Unless the user chose an mTLS endpoint mode, the mTLS
+// endpoints are always used so requests fail rather than silently falling back
+// to plain TLS when no certificate is available.
+func enableMTLS() error {
+	if err := os.Setenv(envUseClientCertificate, "true"); err != nil {
+		return fmt.Errorf("failed to enable client certificates: %w", err)
+	}
+	if os.Getenv(envUseMTLSEndpoint) == "" {
+		if err := os.Setenv(envUseMTLSEndpoint, "always"); err != nil {
+			return fmt.Errorf("failed to enable mTLS endpoints: %w", err)
+		}
+	}
+	return nil
+}
+
+// printIAMResult prints the per-member result of a grant or revoke.
+func printIAMResult(name string, result *berglas.IAMResult) {
+	for _, w := range result.Warnings {
+		fmt.Fprintf(stderr, "Warning: %s: %s\n", w.Resource, w.Message)
+	}
+
+	if result.DryRun {
+		fmt.Fprintf(stdout, "Dry run for [%s], no changes were made:\n", name)
+	} else {
+		fmt.Fprintf(stdout, "Successfully updated permissions on [%s]:\n", name)
+	}
+
+	if len(result.Changes) == 0 {
+		return
+	}
+
+	fmt.Fprintf(stdout, "\n")
+
+	tw := new(tabwriter.Writer)
+	tw.Init(stdout, 0, 4, 4, ' ', 0)
+	fmt.Fprintf(tw, "MEMBER\tROLE\tRESOURCE\tRESULT\n")
+	for _, c := range result.Changes {
+		action := string(c.Action)
+		if result.DryRun && c.Action != berglas.IAMActionUnchanged {
+			action = "would be " + action
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Member, c.Role, c.Resource, action)
+	}
+	tw.Flush()
+}
+
+// printStats prints the time the command took and the API calls it made.
+func printStats(w io.Writer, elapsed time.Duration, services []*berglas.ServiceStats) {
+	fmt.Fprintf(w, "\nFinished in %s\n", elapsed.Round(time.Millisecond))
+	if len(services) == 0 {
+		fmt.Fprintf(w, "No API calls were made\n")
+		return
+	}
+
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "SERVICE\tCALLS\tERRORS\tRETRIES\tLATENCY\tBYTES SENT\tBYTES RECEIVED\n")
+	for _, s := range services {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%d\t%d\n", s.Service, s.Calls, s.Errors, s.Retries,
+			s.Latency.Round(time.Millisecond), s.BytesSent, s.BytesReceived)
+	}
+	tw.Flush()
+}
+
+// newProgress returns a terminal progress bar with the given label if
+// --progress was given and stderr is a terminal, or a progress that discards
+// all updates otherwise.
+func newProgress(label string) berglas.Progress {
+	if !showProgress {
+		return berglas.DiscardProgress
+	}
+	return progress.NewTerminal(stderr, label)
+}
+
+// withProgress attaches a progress bar with the given label to the context.
+func withProgress(ctx context.Context, label string) context.Context {
+	return berglas.WithProgress(ctx, newProgress(label))
+}
+
+// cliMembers expands and validates the members given on the command line,
+// unless --no-expand was given.
+func cliMembers(members []string) ([]string, error) {
+	if membersNoExpand {
+		return members, nil
+	}
+	return berglas.ExpandMembers(members)
+}
+
 // readData reads the given string. If the string starts with an "@", it is
 // assumed to be a filepath. If the string starts with a "-", data is read from
-// stdin. If the data starts with a "\", it is assumed to be an escape character
-// only when specified as the first character.
+// stdin. If the string starts with "env:", data is read from the named
+// environment variable, which keeps it out of argv and shell history. If the
+// data starts with a "\", it is assumed to be an escape character only when
+// specified as the first character.
 func readData(s string) ([]byte, error) {
 	switch {
+	case strings.HasPrefix(s, dataPrefixEnv):
+		name := strings.TrimPrefix(s, dataPrefixEnv)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %q is not set", name)
+		}
+		return []byte(v), nil
 	case strings.HasPrefix(s, "@"):
 		return os.ReadFile(s[1:])
 	case strings.HasPrefix(s, "-"):
@@ -1232,12 +5510,199 @@ func readData(s string) ([]byte, error) {
 }
 
 // parseRef parses a secret ref and returns any errors.
+// completeSecretsTimeout is the maximum amount of time to spend listing
+// secrets for shell completion.
+const completeSecretsTimeout = 5 * time.Second
+
+// completeSecrets is a cobra.ValidArgsFunction that completes the first
+// argument with the names of existing secrets. Secret Manager secrets are
+// completed once the project is given ("sm://PROJECT/"), and Cloud Storage
+// secrets once the bucket is given ("BUCKET/" or "gs://BUCKET/").
+func completeSecrets(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+
+	scheme, rest := "", toComplete
+	if i := strings.Index(toComplete, "://"); i >= 0 {
+		scheme, rest = toComplete[:i+3], toComplete[i+3:]
+	}
+
+	parent, prefix, ok := strings.Cut(rest, "/")
+	if !ok || parent == "" {
+		return nil, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), completeSecretsTimeout)
+	defer cancel()
+
+	ctx, client, err := clientWithContext(ctx)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	defer client.Close()
+
+	var list *berglas.ListResponse
+	switch scheme {
+	case "sm://":
+		list, err = client.List(ctx, &berglas.SecretManagerListRequest{
+			Project: parent,
+			Prefix:  prefix,
+		})
+	case "", "gs://", "berglas://":
+		list, err = client.List(ctx, &berglas.StorageListRequest{
+			Bucket: parent,
+			Prefix: prefix,
+		})
+	default:
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	names := make([]string, 0, len(list.Secrets))
+	for _, s := range list.Secrets {
+		names = append(names, scheme+parent+"/"+s.Name)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// parseExpiration parses an expiration given either as a duration relative to
+// now (e.g. "24h"), as an RFC 3339 timestamp, or as a date (e.g. "2025-01-01"),
+// which is midnight UTC. An empty string returns the zero time.
+func parseExpiration(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("expiration %q must be positive", s)
+		}
+		return now.Add(d), nil
+	}
+
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiration %q: must be a duration "+
+			"(e.g. 24h), a date, or an RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+// parseTTL parses a validity period as a Go duration or a whole number of days
+// with a "d" suffix, such as "90d".
+func parseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid TTL %q: must be a duration (e.g. 2160h) or days (e.g. 90d)", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid TTL %q: must be a duration (e.g. 2160h) or days (e.g. 90d)", s)
+		}
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("TTL %q must be positive", s)
+	}
+	return d, nil
+}
+
+// loadAliases reads the alias file given by --aliases, if any. The file is only
+// read once.
+var loadAliases = sync.OnceValues(func() (map[string]string, error) {
+	if aliasesFile == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(aliasesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open aliases file: %w", err)
+	}
+	defer f.Close()
+
+	aliases, err := berglas.ParseAliases(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse aliases file %s: %w", aliasesFile, err)
+	}
+	return aliases, nil
+})
+
+// loadNamingPolicy reads the naming policy file given by --naming-policy, if
+// any. The file is only read once.
+var loadNamingPolicy = sync.OnceValues(func() (*berglas.NamingPolicy, error) {
+	if namingPolicyFile == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(namingPolicyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open naming policy file: %w", err)
+	}
+	defer f.Close()
+
+	policy, err := berglas.ParseNamingPolicy(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse naming policy file %s: %w", namingPolicyFile, err)
+	}
+	return policy, nil
+})
+
+// loadStorageEncryptionKey decodes the customer-supplied encryption key from
+// --storage-encryption-key-file or BERGLAS_STORAGE_ENCRYPTION_KEY, if either is
+// set. The encoded key is registered for log redaction.
+func loadStorageEncryptionKey() ([]byte, error) {
+	encoded := os.Getenv(envStorageEncryptionKey)
+	if storageEncryptionKeyFile != "" {
+		b, err := os.ReadFile(storageEncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read storage encryption key file: %w", err)
+		}
+		encoded = string(b)
+	}
+
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+	logredact.Default().Register(encoded)
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage encryption key: must be base64")
+	}
+	if len(key) != berglas.StorageEncryptionKeySize {
+		return nil, fmt.Errorf("invalid storage encryption key: must be %d bytes, got %d",
+			berglas.StorageEncryptionKeySize, len(key))
+	}
+	return key, nil
+}
+
 func parseRef(r string) (*berglas.Reference, error) {
 	s := r
 
-	// Replace gs:// with berglas://
-	if strings.HasPrefix(s, "gs://") {
-		s = "berglas://" + s[5:]
+	// Expand aliases before anything else, since alias names look like
+	// references without a protocol.
+	aliases, err := loadAliases()
+	if err != nil {
+		return nil, err
+	}
+	if ref, ok := aliases[s]; ok {
+		s = ref
 	}
 
 	// If there's no protocol, assume berglas:// (backwards compat)
@@ -1251,3 +5716,14 @@ func parseRef(r string) (*berglas.Reference, error) {
 	}
 	return ref, nil
 }
+
+// parseProjectRef parses a Secret Manager project reference of the form
+// sm://PROJECT.
+func parseProjectRef(s string) (string, error) {
+	project, ok := strings.CutPrefix(s, berglas.ReferencePrefixSecretManager)
+	project = strings.Trim(project, "/")
+	if !ok || project == "" || strings.Contains(project, "/") {
+		return "", fmt.Errorf("invalid project %q: must be of the format sm://PROJECT", s)
+	}
+	return project, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglastest"
+)
+
+func TestMigrateGeneration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := berglastest.New()
+
+	first := client.MustSet(t, "berglas://b/o", "first")
+	second := client.MustSet(t, "berglas://b/o", "second")
+
+	for _, tc := range []struct {
+		source *berglas.Secret
+		exp    string
+	}{
+		{first, "first"},
+		{second, "second"},
+	} {
+		result := new(migrateResult)
+		skipped, err := migrateGeneration(ctx, client, tc.source, "p", "o", true, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skipped {
+			t.Fatalf("expected generation %d not to be skipped", tc.source.Generation)
+		}
+		if !result.Verified {
+			t.Errorf("expected generation %d to be verified", tc.source.Generation)
+		}
+
+		plaintext, err := client.Access(ctx, &berglas.SecretManagerAccessRequest{
+			Project: "p",
+			Name:    "o",
+			Version: result.Version,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act, exp := string(plaintext), tc.exp; act != exp {
+			t.Errorf("expected generation %d to migrate %q, got %q",
+				tc.source.Generation, exp, act)
+		}
+	}
+}
+
+// migrateTestClient adds Members to the fake client so it can be migrated with.
+type migrateTestClient struct {
+	*berglastest.Client
+}
+
+func (c *migrateTestClient) Members(ctx context.Context, i berglas.MembersRequester) ([]string, error) {
+	return nil, nil
+}
+
+func TestMigrateBucket_reportStdout(t *testing.T) {
+	// Not parallel: migrate reads its flags from package variables.
+	oldProjectID, oldReportPath := projectID, migrateReportPath
+	t.Cleanup(func() {
+		projectID, migrateReportPath = oldProjectID, oldReportPath
+	})
+	projectID, migrateReportPath = "p", "-"
+
+	ctx := context.Background()
+	client := berglastest.New()
+	client.MustSet(t, "berglas://b/o", "first")
+	client.MustSet(t, "berglas://b/o", "second")
+
+	var stdout, stderr bytes.Buffer
+	if err := migrateBucket(ctx, &migrateTestClient{client}, "b", &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	var report migrateReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("expected stdout to be the JSON report: %s\n%s", err, stdout.String())
+	}
+	if act, exp := report.Migrated, 2; act != exp {
+		t.Errorf("expected %d migrated, got %d", exp, act)
+	}
+
+	if act, exp := stderr.String(), "Migrated: 2, skipped: 0, failed: 0"; !strings.Contains(act, exp) {
+		t.Errorf("expected stderr %q to contain %q", act, exp)
+	}
+}
@@ -52,7 +52,7 @@ func init() {
 	}
 	ctx = logging.WithLogger(ctx, logger)
 
-	client, err := berglas.New(ctx)
+	client, err := berglas.DefaultClient(ctx)
 	if err != nil {
 		handleError(fmt.Errorf("failed to initialize berglas client: %w", err))
 		return
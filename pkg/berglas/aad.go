@@ -0,0 +1,66 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"strconv"
+
+	"google.golang.org/api/option"
+)
+
+// aadVersionBound is the value of MetadataAADKey for secrets whose DEK was
+// encrypted with additional authenticated data bound to the bucket, object,
+// and caller context.
+const aadVersionBound = "v2"
+
+// WithBoundAAD returns a client option that binds the KMS additional
+// authenticated data for new Cloud Storage secrets to both the bucket and
+// object name, plus the given context string, which may be empty. A secret
+// written this way cannot be decrypted after being copied to another bucket or
+// object, or by a client configured with a different context.
+//
+// Reads always use the scheme recorded on the secret, so secrets written
+// without this option remain readable. The context must match the one used
+// when the secret was written.
+func WithBoundAAD(context string) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.boundAAD = true
+		c.aadContext = context
+	}}
+}
+
+// storageAAD returns the additional authenticated data used to encrypt the DEK
+// of the secret at the given bucket and object. If bound is false, this is the
+// object name alone, which is what older versions of berglas used. A non-zero
+// write counter is prepended on its own line.
+func storageAAD(bound bool, bucket, object, context string, counter int64) []byte {
+	aad := object
+	if bound {
+		// Bucket and object names cannot contain newlines, so the fields cannot
+		// be confused. The context is last so it may contain anything.
+		aad = "berglas:" + aadVersionBound + "\n" + bucket + "\n" + object + "\n" + context
+	}
+
+	if counter > 0 {
+		aad = "berglas:counter:" + strconv.FormatInt(counter, 10) + "\n" + aad
+	}
+	return []byte(aad)
+}
+
+// storageAADBound reports whether the secret with the given object metadata
+// was written with bound additional authenticated data.
+func storageAADBound(metadata map[string]string) bool {
+	return metadata[MetadataAADKey] == aadVersionBound
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/option"
+)
+
+func TestStorageAAD(t *testing.T) {
+	t.Parallel()
+
+	if got, want := storageAAD(false, "bucket", "object", "ctx", 0), []byte("object"); !bytes.Equal(got, want) {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	bound := storageAAD(true, "bucket", "object", "", 0)
+	for _, other := range [][]byte{
+		storageAAD(false, "bucket", "object", "", 0),
+		storageAAD(true, "other", "object", "", 0),
+		storageAAD(true, "bucket", "other", "", 0),
+		storageAAD(true, "bucket", "object", "ctx", 0),
+		storageAAD(true, "bucket", "object\nctx", "", 0),
+		storageAAD(true, "bucket", "object", "", 1),
+	} {
+		if bytes.Equal(bound, other) {
+			t.Errorf("expected %q to differ from %q", bound, other)
+		}
+	}
+}
+
+func TestStorageAAD_writeCounter(t *testing.T) {
+	t.Parallel()
+
+	for _, bound := range []bool{false, true} {
+		seen := make(map[string]int64)
+		for _, counter := range []int64{0, 1, 2, 10} {
+			aad := string(storageAAD(bound, "bucket", "object", "", counter))
+			if other, ok := seen[aad]; ok {
+				t.Errorf("expected counters %d and %d to differ (bound=%t)", other, counter, bound)
+			}
+			seen[aad] = counter
+		}
+	}
+}
+
+func TestStorageAADBound(t *testing.T) {
+	t.Parallel()
+
+	if storageAADBound(nil) {
+		t.Errorf("expected nil metadata to not be bound")
+	}
+	if !storageAADBound(map[string]string{MetadataAADKey: aadVersionBound}) {
+		t.Errorf("expected metadata to be bound")
+	}
+}
+
+func TestClient_BoundAAD_storage(t *testing.T) {
+	testAcc(t)
+
+	ctx := logging.WithLogger(context.Background(), logging.TestLogger(t))
+
+	newClient := func(opts ...option.ClientOption) *Client {
+		client, err := New(ctx, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := client.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+		return client
+	}
+
+	bucket, object, key := testBucket(t), testName(t), testKey(t)
+	defer testStorageCleanup(t, bucket, object)
+
+	bound := newClient(WithBoundAAD("staging"))
+	if _, err := bound.Create(ctx, &StorageCreateRequest{
+		Bucket:    bucket,
+		Object:    object,
+		Key:       key,
+		Plaintext: []byte("my secret value"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bound.Access(ctx, &StorageAccessRequest{
+		Bucket: bucket,
+		Object: object,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A different context must not decrypt the secret.
+	if _, err := newClient(WithBoundAAD("production")).Access(ctx, &StorageAccessRequest{
+		Bucket: bucket,
+		Object: object,
+	}); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -17,6 +17,7 @@ package berglas
 import (
 	"context"
 	"fmt"
+	"time"
 
 	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
@@ -26,8 +27,21 @@ import (
 
 type accessRequest interface {
 	isAccessRequest()
+	Validate() error
 }
 
+// accessResult is a secret accessed through the client's access pipeline.
+type accessResult struct {
+	// plaintext is the value of the secret.
+	plaintext []byte
+
+	// policy is the Policy of the secret, which Resolve enforces.
+	policy Policy
+}
+
+// accessFunc accesses a secret; each stage of the access pipeline is one.
+type accessFunc func(ctx context.Context, i accessRequest) (*accessResult, error)
+
 // StorageAccessRequest is used as input to access a secret from Cloud Storage
 // encrypted with Cloud KMS.
 type StorageAccessRequest struct {
@@ -43,6 +57,15 @@ type StorageAccessRequest struct {
 
 func (r *StorageAccessRequest) isAccessRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageAccessRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	return v.err()
+}
+
 // AccessRequest is an alias for StorageAccessRequest for
 // backwards-compatibility. New clients should use StorageAccessRequest.
 type AccessRequest = StorageAccessRequest
@@ -56,16 +79,29 @@ type SecretManagerAccessRequest struct {
 	// Name is the name of the secret to access.
 	Name string
 
-	// Version is the version of the secret to access.
+	// Version is the version of the secret to access. Besides a version number
+	// or alias, this may be a constraint resolved against the enabled versions:
+	// "latest-N" for the Nth version before latest, or ">=N", ">N", "<=N", or
+	// "<N" for the newest version matching the comparison.
 	Version string
 }
 
 func (r *SecretManagerAccessRequest) isAccessRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerAccessRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	v.require("Name", r.Name, "missing secret name")
+	v.versionConstraint("Version", r.Version)
+	return v.err()
+}
+
 // Access is a top-level package function for accessing a secret. For large
 // volumes of secrets, please create a client instead.
 func Access(ctx context.Context, i accessRequest) ([]byte, error) {
-	client, err := New(ctx)
+	client, err := DefaultClient(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -74,33 +110,65 @@ func Access(ctx context.Context, i accessRequest) ([]byte, error) {
 
 // Access accesses a secret. When given a SecretManagerAccessRequest, this
 // accesses a secret from Secret Manager. When given a StorageAccessRequest,
-// this accesses a secret stored in Cloud Storage encrypted with Cloud KMS.
+// this accesses a secret stored in Cloud Storage encrypted with Cloud KMS. When
+// given a StorageS3AccessRequest, this accesses a secret stored in the client's
+// S3-compatible store.
 func (c *Client) Access(ctx context.Context, i accessRequest) ([]byte, error) {
+	result, err := c.accessPipeline(ctx, i)
+	if err != nil {
+		return nil, err
+	}
+	return result.plaintext, nil
+}
+
+// accessPipeline accesses a secret through the disk cache, circuit breaker,
+// singleflight, and hedging the client was created with, returning its value
+// and Policy.
+func (c *Client) accessPipeline(ctx context.Context, i accessRequest) (*accessResult, error) {
 	if i == nil {
 		return nil, fmt.Errorf("missing request")
 	}
 
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkAllowedRequest(i); err != nil {
+		return nil, err
+	}
+
+	access := accessFunc(c.accessUpstream)
+	if c.accessGroup != nil {
+		access = c.accessShared
+	}
+	if c.breaker != nil {
+		next := access
+		access = func(ctx context.Context, i accessRequest) (*accessResult, error) {
+			return c.accessBreaker(ctx, i, next)
+		}
+	}
+	if c.diskCache != nil {
+		return c.accessCached(ctx, i, access)
+	}
+	return access(ctx, i)
+}
+
+func (c *Client) access(ctx context.Context, i accessRequest) (*accessResult, error) {
 	switch t := i.(type) {
 	case *SecretManagerAccessRequest:
 		return c.secretManagerAccess(ctx, t)
 	case *StorageAccessRequest:
 		return c.storageAccess(ctx, t)
+	case *StorageS3AccessRequest:
+		return c.s3Access(ctx, t)
 	default:
 		return nil, fmt.Errorf("unknown access type %T", t)
 	}
 }
 
-func (c *Client) secretManagerAccess(ctx context.Context, i *SecretManagerAccessRequest) ([]byte, error) {
+func (c *Client) secretManagerAccess(ctx context.Context, i *SecretManagerAccessRequest) (*accessResult, error) {
 	project := i.Project
-	if project == "" {
-		return nil, fmt.Errorf("missing project")
-	}
-
 	name := i.Name
-	if name == "" {
-		return nil, fmt.Errorf("missing secret name")
-	}
-
 	version := i.Version
 	if version == "" {
 		version = "latest"
@@ -115,31 +183,46 @@ func (c *Client) secretManagerAccess(ctx context.Context, i *SecretManagerAccess
 	logger.DebugContext(ctx, "access.start")
 	defer logger.DebugContext(ctx, "access.finish")
 
+	version, err := c.secretManagerResolveVersion(ctx, project, name, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access secret: %w", err)
+	}
+
+	start := time.Now()
 	resp, err := c.secretManagerClient.AccessSecretVersion(ctx, &secretspb.AccessSecretVersionRequest{
 		Name: fmt.Sprintf("projects/%s/secrets/%s/versions/%s", project, name, version),
 	})
 	if err != nil {
 		terr, ok := grpcstatus.FromError(err)
 		if ok && terr.Code() == grpccodes.NotFound {
-			return nil, errSecretDoesNotExist
+			return nil, secretManagerError(ErrSecretDoesNotExist, project, name)
+		}
+		if isReplicationErr(err) {
+			if rerr := c.secretManagerReplicationError(ctx, project, name, err); rerr != nil {
+				return nil, fmt.Errorf("failed to access secret: %w", rerr)
+			}
 		}
 		return nil, fmt.Errorf("failed to access secret: %w", err)
 	}
 
-	return resp.Payload.Data, nil
-}
-
-func (c *Client) storageAccess(ctx context.Context, i *StorageAccessRequest) ([]byte, error) {
-	bucket := i.Bucket
-	if bucket == "" {
-		return nil, fmt.Errorf("missing bucket name")
+	if latency := time.Since(start); latency > SlowAccessThreshold {
+		c.secretManagerWarnSlowAccess(ctx, project, name, latency)
 	}
 
-	object := i.Object
-	if object == "" {
-		return nil, fmt.Errorf("missing object name")
+	result := &accessResult{plaintext: resp.Payload.Data}
+	if c.secretManagerPolicies && !c.policyOverride {
+		result.policy, err = c.secretManagerPolicy(ctx, project, name)
+		if err != nil {
+			c.wipe(result.plaintext)
+			return nil, fmt.Errorf("failed to read secret policy: %w", err)
+		}
 	}
+	return result, nil
+}
 
+func (c *Client) storageAccess(ctx context.Context, i *StorageAccessRequest) (*accessResult, error) {
+	bucket := i.Bucket
+	object := i.Object
 	generation := i.Generation
 	if generation == 0 {
 		generation = -1
@@ -162,5 +245,5 @@ func (c *Client) storageAccess(ctx context.Context, i *StorageAccessRequest) ([]
 	if err != nil {
 		return nil, fmt.Errorf("failed to access secret: %w", err)
 	}
-	return secret.Plaintext, nil
+	return &accessResult{plaintext: secret.Plaintext, policy: secret.Policy}, nil
 }
@@ -33,7 +33,7 @@ func TestClient_Access_secretManager(t *testing.T) {
 			Name:    name,
 		})
 		if !IsSecretDoesNotExistErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretDoesNotExist)
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
 		}
 	})
 
@@ -81,7 +81,7 @@ func TestClient_Access_storage(t *testing.T) {
 			Object: object,
 		})
 		if !IsSecretDoesNotExistErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretDoesNotExist)
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
 		}
 	})
 
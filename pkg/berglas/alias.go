@@ -0,0 +1,86 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/option"
+)
+
+// aliasNameRe matches valid alias names. Names cannot contain a slash, so they
+// are never mistaken for a Cloud Storage reference without a scheme.
+var aliasNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ParseAliases parses an alias file, which maps short names to references so
+// they do not need to be typed out in full. Each line is of the form
+// "NAME=REFERENCE", such as "db-pass=sm://prod-project/db-password#latest".
+// Whitespace around the name and reference is ignored, as are blank lines and
+// lines starting with "#". Names may contain letters, digits, ".", "_", and
+// "-". References may be fallback chains.
+func ParseAliases(r io.Reader) (map[string]string, error) {
+	aliases := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, ref, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected NAME=REFERENCE", n)
+		}
+		name, ref = strings.TrimSpace(name), strings.TrimSpace(ref)
+
+		if !aliasNameRe.MatchString(name) {
+			return nil, fmt.Errorf("line %d: invalid alias name %q", n, name)
+		}
+		if _, ok := aliases[name]; ok {
+			return nil, fmt.Errorf("line %d: duplicate alias %q", n, name)
+		}
+		if _, err := ParseReferences(ref); err != nil {
+			return nil, fmt.Errorf("line %d: invalid reference for alias %q: %w", n, name, err)
+		}
+		aliases[name] = ref
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read aliases: %w", err)
+	}
+	return aliases, nil
+}
+
+// WithAliases makes Resolve accept the names of the given aliases, as returned
+// by ParseAliases, in place of the references they map to.
+func WithAliases(aliases map[string]string) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.aliases = maps.Clone(aliases)
+	}}
+}
+
+// expandAlias returns the reference for s if it is the name of an alias, and
+// s otherwise.
+func (c *Client) expandAlias(s string) string {
+	if ref, ok := c.aliases[s]; ok {
+		return ref
+	}
+	return s
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAliases(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		in   string
+		exp  map[string]string
+		err  string
+	}{
+		{
+			name: "empty",
+			in:   "",
+			exp:  map[string]string{},
+		},
+		{
+			name: "valid",
+			in: strings.Join([]string{
+				"# Production secrets",
+				"",
+				"db-pass = sm://prod-project/db-password#latest",
+				"api_key=berglas://my-bucket/api-key",
+				"chain=sm://p/a|sm://p/b",
+			}, "\n"),
+			exp: map[string]string{
+				"db-pass": "sm://prod-project/db-password#latest",
+				"api_key": "berglas://my-bucket/api-key",
+				"chain":   "sm://p/a|sm://p/b",
+			},
+		},
+		{
+			name: "missing_separator",
+			in:   "db-pass",
+			err:  "line 1: expected NAME=REFERENCE",
+		},
+		{
+			name: "invalid_name",
+			in:   "my-bucket/api-key=sm://p/s",
+			err:  "invalid alias name",
+		},
+		{
+			name: "duplicate",
+			in:   "a=sm://p/s\na=sm://p/t",
+			err:  "line 2: duplicate alias",
+		},
+		{
+			name: "invalid_reference",
+			in:   "a=not-a-reference",
+			err:  "invalid reference for alias",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			aliases, err := ParseAliases(strings.NewReader(tc.in))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if act, exp := len(aliases), len(tc.exp); act != exp {
+				t.Fatalf("expected %d aliases to be %d", act, exp)
+			}
+			for k, exp := range tc.exp {
+				if act := aliases[k]; act != exp {
+					t.Errorf("expected %q to be %q", act, exp)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_expandAlias(t *testing.T) {
+	t.Parallel()
+
+	aliases := map[string]string{"db-pass": "sm://p/db-password#latest"}
+	c := new(Client)
+	WithAliases(aliases).(*clientOption).apply(c)
+
+	// The client keeps its own copy.
+	aliases["db-pass"] = "sm://p/other"
+
+	if act, exp := c.expandAlias("db-pass"), "sm://p/db-password#latest"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+	if act, exp := c.expandAlias("sm://p/s"), "sm://p/s"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package awskms provides a berglas.KeyWrapper backed by AWS KMS. Register it
+// on a client with:
+//
+//	berglas.WithKeyWrapper(awskms.Scheme, awskms.New())
+//
+// Keys are given as "aws-kms://" followed by the ARN of a symmetric AWS KMS
+// key, such as "aws-kms://arn:aws:kms:us-east-1:111122223333:key/1234abcd".
+// Credentials are loaded from the default AWS configuration chain.
+package awskms
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// Scheme is the key URI scheme handled by this wrapper.
+const Scheme = "aws-kms"
+
+// encryptionContextKey is the encryption context key under which the
+// additional authenticated data is bound.
+const encryptionContextKey = "berglas-aad"
+
+// KeyWrapper wraps data encryption keys with AWS KMS.
+type KeyWrapper struct {
+	// loadConfig loads the AWS configuration. It is config.LoadDefaultConfig
+	// if nil.
+	loadConfig func(ctx context.Context, optFns ...func(*config.LoadOptions) error) (aws.Config, error)
+
+	lock sync.Mutex
+	cfg  *aws.Config
+}
+
+// New creates a new AWS KMS key wrapper. The AWS configuration is loaded on
+// first use, and loading is retried on the next use if it fails.
+func New() *KeyWrapper {
+	return &KeyWrapper{}
+}
+
+// Wrap encrypts the data encryption key with the AWS KMS key.
+func (w *KeyWrapper) Wrap(ctx context.Context, key string, dek, aad []byte) ([]byte, error) {
+	client, arn, err := w.client(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(arn),
+		Plaintext:         dek,
+		EncryptionContext: encryptionContext(aad),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt with aws kms: %w", err)
+	}
+	return resp.CiphertextBlob, nil
+}
+
+// Unwrap decrypts the data encryption key with the AWS KMS key.
+func (w *KeyWrapper) Unwrap(ctx context.Context, key string, wrapped, aad []byte) ([]byte, error) {
+	client, arn, err := w.client(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(arn),
+		CiphertextBlob:    wrapped,
+		EncryptionContext: encryptionContext(aad),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt with aws kms: %w", err)
+	}
+	return resp.Plaintext, nil
+}
+
+// client returns an AWS KMS client for the region of the given key.
+func (w *KeyWrapper) client(ctx context.Context, key string) (*kms.Client, string, error) {
+	arn, region, err := parseKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	cfg, err := w.config(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return kms.NewFromConfig(cfg, func(o *kms.Options) {
+		o.Region = region
+	}), arn, nil
+}
+
+// config returns the AWS configuration, loading it if it has not been loaded
+// successfully yet. The configuration outlives the request that loads it, so
+// it is not loaded with the request's cancellation.
+func (w *KeyWrapper) config(ctx context.Context) (aws.Config, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.cfg != nil {
+		return *w.cfg, nil
+	}
+
+	load := w.loadConfig
+	if load == nil {
+		load = config.LoadDefaultConfig
+	}
+
+	cfg, err := load(context.WithoutCancel(ctx))
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load aws config: %w", err)
+	}
+	w.cfg = &cfg
+	return cfg, nil
+}
+
+// parseKey parses an AWS KMS key URI into the key ARN and its region.
+func parseKey(key string) (string, string, error) {
+	arn, ok := strings.CutPrefix(key, Scheme+"://")
+	if !ok {
+		return "", "", fmt.Errorf("invalid aws kms key %q: missing %s:// prefix", key, Scheme)
+	}
+
+	// arn:<partition>:kms:<region>:<account>:key/<id>
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "kms" || parts[3] == "" ||
+		!(strings.HasPrefix(parts[5], "key/") || strings.HasPrefix(parts[5], "alias/")) {
+		return "", "", fmt.Errorf("invalid aws kms key %q: must be of the format "+
+			"%s://arn:aws:kms:<region>:<account>:key/<id>", key, Scheme)
+	}
+	return arn, parts[3], nil
+}
+
+// encryptionContext binds the additional authenticated data to the wrapped
+// key. AWS KMS encryption context values are strings, so the data is encoded.
+func encryptionContext(aad []byte) map[string]string {
+	if len(aad) == 0 {
+		return nil
+	}
+	return map[string]string{encryptionContextKey: base64.StdEncoding.EncodeToString(aad)}
+}
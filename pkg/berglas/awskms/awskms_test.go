@@ -0,0 +1,130 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awskms
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+func TestParseKey(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		key    string
+		arn    string
+		region string
+		err    bool
+	}{
+		{
+			name:   "key",
+			key:    "aws-kms://arn:aws:kms:us-east-1:111122223333:key/1234abcd",
+			arn:    "arn:aws:kms:us-east-1:111122223333:key/1234abcd",
+			region: "us-east-1",
+		},
+		{
+			name:   "alias",
+			key:    "aws-kms://arn:aws:kms:eu-west-2:111122223333:alias/berglas",
+			arn:    "arn:aws:kms:eu-west-2:111122223333:alias/berglas",
+			region: "eu-west-2",
+		},
+		{
+			name: "missing_scheme",
+			key:  "arn:aws:kms:us-east-1:111122223333:key/1234abcd",
+			err:  true,
+		},
+		{
+			name: "missing_region",
+			key:  "aws-kms://arn:aws:kms::111122223333:key/1234abcd",
+			err:  true,
+		},
+		{
+			name: "not_kms",
+			key:  "aws-kms://arn:aws:s3:us-east-1:111122223333:key/1234abcd",
+			err:  true,
+		},
+		{
+			name: "key_id",
+			key:  "aws-kms://1234abcd",
+			err:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			arn, region, err := parseKey(tc.key)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t, got %v", tc.err, err)
+			}
+			if act, exp := arn, tc.arn; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+			if act, exp := region, tc.region; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
+
+func TestKeyWrapper_config(t *testing.T) {
+	t.Parallel()
+
+	var calls int
+	w := &KeyWrapper{
+		loadConfig: func(ctx context.Context, _ ...func(*config.LoadOptions) error) (aws.Config, error) {
+			calls++
+			if err := ctx.Err(); err != nil {
+				return aws.Config{}, err
+			}
+			if calls == 1 {
+				return aws.Config{}, fmt.Errorf("transient failure")
+			}
+			return aws.Config{Region: "us-east-1"}, nil
+		},
+	}
+
+	if _, err := w.config(context.Background()); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// The failure is not cached, and a canceled request does not stop loading.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cfg, err := w.config(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := cfg.Region, "us-east-1"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	// Successful loads are cached.
+	if _, err := w.config(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := calls, 2; act != exp {
+		t.Errorf("expected %d calls, got %d", exp, act)
+	}
+}
@@ -17,11 +17,8 @@ package berglas
 
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
+	"errors"
 	"fmt"
-	"io"
 	"strings"
 	"time"
 
@@ -29,6 +26,8 @@ import (
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/berglas/v2/internal/version"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logredact"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/api/option"
 	storagev1 "google.golang.org/api/storage/v1"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -51,6 +50,34 @@ const (
 	// MetadataKMSKey is the key in the metadata where the name of the KMS key is
 	// stored.
 	MetadataKMSKey = "berglas-kms-key"
+
+	// MetadataKMSProtectionLevelKey is the key in the metadata where the
+	// protection level of the KMS key (e.g. "HSM" or "EXTERNAL") is stored, if
+	// it was known when the secret was written.
+	MetadataKMSProtectionLevelKey = "berglas-kms-protection-level"
+
+	// MetadataExpiresAtKey is the key in the metadata where the expiration time
+	// of the secret is stored, in RFC 3339 format. Expired secrets are deleted by
+	// Prune.
+	MetadataExpiresAtKey = "berglas-expires-at"
+
+	// MetadataAADKey is the key in the metadata where the version of the KMS
+	// additional authenticated data scheme is stored. It is absent for secrets
+	// whose additional authenticated data is only the object name.
+	MetadataAADKey = "berglas-aad"
+
+	// MetadataPolicyKey is the key in the metadata, or the label for Secret
+	// Manager secrets, where the Policy restricting how the secret may be
+	// resolved is stored.
+	MetadataPolicyKey = "berglas-policy"
+
+	// MetadataContentTypeKey is the key in the metadata, or the annotation for
+	// Secret Manager secrets, where the media type of the plaintext is stored.
+	MetadataContentTypeKey = "berglas-content-type"
+
+	// MetadataWriteCounterKey is the key in the metadata where the write
+	// counter used to detect rollback is stored. See WithRollbackProtection.
+	MetadataWriteCounterKey = "berglas-write-counter"
 )
 
 // Client is a berglas client
@@ -59,34 +86,161 @@ type Client struct {
 	secretManagerClient *secretmanager.Client
 	storageClient       *storage.Client
 	storageIAMClient    *storagev1.Service
+
+	// opts are the options the client was created with, used to create
+	// additional clients on demand (such as Pub/Sub for watching secrets).
+	opts []option.ClientOption
+
+	// readOnly causes all mutating methods to return ErrReadOnly.
+	readOnly bool
+
+	// storageMaxPlaintextSize is the maximum size of Cloud Storage secrets. Zero
+	// or less means there is no limit.
+	storageMaxPlaintextSize int64
+
+	// zeroize causes intermediate buffers to be wiped after use.
+	zeroize bool
+
+	// envelopeAlgorithm is the algorithm for new Cloud Storage secrets. Empty
+	// means the unversioned AES-256-GCM format.
+	envelopeAlgorithm EnvelopeAlgorithm
+
+	// boundAAD binds the KMS additional authenticated data for new secrets to
+	// the bucket, object, and aadContext.
+	boundAAD   bool
+	aadContext string
+
+	// userAgentSuffix is appended to the user agent sent to all services.
+	userAgentSuffix string
+
+	// keyWrappers are the key wrappers for key URIs, by scheme. Cloud KMS keys
+	// do not have a scheme and are always supported.
+	keyWrappers map[string]KeyWrapper
+
+	// s3Store stores S3 secrets, if configured.
+	s3Store S3Store
+
+	// accessGroup coalesces identical in-flight Access calls, if configured.
+	accessGroup *singleflight.Group
+
+	// diskCache caches accessed secrets on disk, if configured.
+	diskCache *diskCache
+
+	// aliases maps alias names accepted by Resolve to references.
+	aliases map[string]string
+
+	// preferredRegion is the region the caller runs in, used to diagnose slow
+	// or failed Secret Manager accesses.
+	preferredRegion string
+
+	// redactor, if set, receives every value returned by Resolve.
+	redactor *logredact.Redactor
+
+	// stats, if set, records the API calls made by the client.
+	stats *Stats
+
+	// policyOverride causes Resolve to ignore each secret's Policy.
+	policyOverride bool
+
+	// secretManagerPolicies causes Secret Manager accesses to also read the
+	// secret's Policy label.
+	secretManagerPolicies bool
+
+	// allowedPrefixes, if set, are the only reference prefixes Access and
+	// Resolve will read secrets from.
+	allowedPrefixes []string
+
+	// rollbackProtection stamps and verifies the write counter of Cloud Storage
+	// secrets. writeCounters holds the highest counter read for each object.
+	rollbackProtection bool
+	writeCounters      writeCounters
+
+	// namingPolicy, if set, restricts the names of written secrets.
+	namingPolicy *NamingPolicy
+
+	// hedgeDelay, if positive, is how long an access may take before a second
+	// request is sent.
+	hedgeDelay time.Duration
+
+	// breaker, if set, stops sending accesses to unavailable backends.
+	breaker *circuitBreaker
+
+	// maxStaleness, if positive, is how long past expiry a disk cache entry
+	// may be served when the backend is unavailable.
+	maxStaleness time.Duration
+
+	// storageKey, if set, is the customer-supplied encryption key for Cloud
+	// Storage objects.
+	storageKey []byte
+
+	// storageReadRetries and storageReadRetryBase configure how Cloud Storage
+	// downloads are retried.
+	storageReadRetries   int
+	storageReadRetryBase time.Duration
 }
 
 // New creates a new berglas client.
+//
+// Options apply to all underlying clients, except WithReadOnly,
+// WithMaxStoragePlaintextSize, WithZeroize, and those returned by
+// WithStorageEndpoint, WithSecretManagerEndpoint, and WithKMSEndpoint, which
+// only apply to their respective service. All clients honor the standard
+// HTTPS_PROXY, HTTP_PROXY, and NO_PROXY environment variables.
 func New(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
-	opts = append(opts, option.WithUserAgent(version.UserAgent))
-
 	var c Client
+	c.storageReadRetries = StorageReadMaxRetries
+	for _, opt := range opts {
+		if co, ok := opt.(*clientOption); ok {
+			co.apply(&c)
+		}
+	}
+
+	if strings.ContainsAny(c.userAgentSuffix, "\r\n") {
+		return nil, fmt.Errorf("invalid user agent suffix %q", c.userAgentSuffix)
+	}
+	if err := c.checkStorageEncryptionKey(); err != nil {
+		return nil, err
+	}
+	opts = append(opts, option.WithUserAgent(userAgent(c.userAgentSuffix)))
+	c.opts = opts
+
+	kmsOpts := serviceOptions(opts, serviceKMS)
+	secretManagerOpts := serviceOptions(opts, serviceSecretManager)
+	storageOpts := serviceOptions(opts, serviceStorage)
+	if c.stats != nil {
+		kmsOpts = append(kmsOpts, c.stats.grpcOption(string(serviceKMS)))
+		secretManagerOpts = append(secretManagerOpts, c.stats.grpcOption(string(serviceSecretManager)))
+
+		storageOpt, err := c.stats.storageOption(ctx, storageOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create storage http client: %w", err)
+		}
+		storageOpts = append(storageOpts, storageOpt)
+	}
 
-	kmsClient, err := kms.NewKeyManagementClient(ctx, opts...)
+	kmsClient, err := kms.NewKeyManagementClient(ctx, kmsOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create kms client: %w", err)
 	}
 	c.kmsClient = kmsClient
 
-	secretManagerClient, err := secretmanager.NewClient(ctx, opts...)
+	secretManagerClient, err := secretmanager.NewClient(ctx, secretManagerOpts...)
 	if err != nil {
+		c.Close()
 		return nil, fmt.Errorf("failed to create secretManager client: %w", err)
 	}
 	c.secretManagerClient = secretManagerClient
 
-	storageClient, err := storage.NewClient(ctx, opts...)
+	storageClient, err := storage.NewClient(ctx, storageOpts...)
 	if err != nil {
+		c.Close()
 		return nil, fmt.Errorf("failed to create storage client: %w", err)
 	}
 	c.storageClient = storageClient
 
-	storageIAMClient, err := storagev1.NewService(ctx, opts...)
+	storageIAMClient, err := storagev1.NewService(ctx, storageOpts...)
 	if err != nil {
+		c.Close()
 		return nil, fmt.Errorf("failed to create storagev1 client: %w", err)
 	}
 	c.storageIAMClient = storageIAMClient
@@ -94,6 +248,66 @@ func New(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
 	return &c, nil
 }
 
+// Close closes the connections held by the client. The client must not be
+// used after it is closed. Clients are safe to share, so long-running
+// applications should create one client and close it on shutdown instead of
+// creating a client per request.
+func (c *Client) Close() error {
+	var errs []error
+	if c.kmsClient != nil {
+		if err := c.kmsClient.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close kms client: %w", err))
+		}
+	}
+	if c.secretManagerClient != nil {
+		if err := c.secretManagerClient.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close secretManager client: %w", err))
+		}
+	}
+	if c.storageClient != nil {
+		if err := c.storageClient.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close storage client: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// clientOption is a client option that configures the berglas client itself
+// rather than the underlying API clients. It must only be passed to New, which
+// never forwards it.
+type clientOption struct {
+	option.ClientOption
+	apply func(*Client)
+}
+
+// WithReadOnly returns a client option that prevents the client from mutating
+// secrets or permissions. Create, Update, Delete, Grant, Revoke, and Bootstrap
+// return an error for which IsReadOnlyErr is true, without making any API
+// calls.
+func WithReadOnly() option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.readOnly = true
+	}}
+}
+
+// WithUserAgentSuffix returns a client option that appends the given value,
+// such as "my-service/1.2", to the user agent sent with every request, so the
+// traffic of applications embedding berglas can be told apart in API logs.
+func WithUserAgentSuffix(suffix string) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.userAgentSuffix = suffix
+	}}
+}
+
+// userAgent returns the berglas user agent with the given suffix, if any.
+func userAgent(suffix string) string {
+	suffix = strings.TrimSpace(suffix)
+	if suffix == "" {
+		return version.UserAgent
+	}
+	return version.UserAgent + " " + suffix
+}
+
 // Secret represents a secret.
 type Secret struct {
 	// Parent is the resource container. For Cloud Storage secrets, this is the
@@ -116,26 +330,74 @@ type Secret struct {
 	// only.
 	Generation, Metageneration int64
 
+	// ETag is the entity tag of the object, if the store reports one. It is not
+	// set after a write. S3 only.
+	ETag string
+
 	// KMSKey is the key used to encrypt the secret key. Cloud Storage only.
 	KMSKey string
 
+	// ProtectionLevel is the protection level of KMS key used to encrypt the
+	// secret key, if it was known when the secret was written. Cloud Storage
+	// only.
+	ProtectionLevel string
+
+	// State is the state of the version. For Secret Manager, this is "ENABLED",
+	// "DISABLED", or "DESTROYED", and is only set when reading or listing
+	// versions. For Cloud Storage, this is StorageStateLive or
+	// StorageStateArchived, and is only set when listing.
+	State string
+
+	// Size is the size of the stored data in bytes. For Cloud Storage, this is
+	// the size of the encrypted object. For Secret Manager, this is the size of
+	// the payload and is only set when listing with metadata.
+	Size int64
+
+	// CRC32C is the CRC32C checksum (Castagnoli polynomial) of the stored data,
+	// as described for Size.
+	CRC32C uint32
+
+	// ExpiresAt is the time after which the secret may be pruned. It is the
+	// zero value if the secret does not expire. Cloud Storage only.
+	ExpiresAt time.Time
+
 	// Locations is the list of custom locations the secret is replicated to.
 	// This is set to nil if the secret is automatically replicated instead.
 	// Secret Manager only.
 	Locations []string
+
+	// Policy restricts how Resolve may place the secret. It is not set when
+	// reading Secret Manager secrets.
+	Policy Policy
+
+	// ContentType is the media type of the plaintext given when the secret was
+	// created, such as "application/json" or "application/x-pem-file", if any.
+	// It is a hint for consumers and is not checked against the plaintext. For
+	// Secret Manager secrets, it is only set by Create and Stat.
+	ContentType string
 }
 
 // secretFromAttrs constructs a secret from the given object attributes and
 // plaintext.
 func secretFromAttrs(bucket string, attrs *storage.ObjectAttrs, plaintext []byte) *Secret {
+	// Unparseable expirations are treated as no expiration, so a malformed
+	// value never causes a secret to be pruned.
+	expiresAt, _ := time.Parse(time.RFC3339, attrs.Metadata[MetadataExpiresAtKey])
+
 	return &Secret{
-		Parent:         bucket,
-		Name:           attrs.Name,
-		Generation:     attrs.Generation,
-		Metageneration: attrs.Metageneration,
-		UpdatedAt:      attrs.Updated,
-		KMSKey:         attrs.Metadata[MetadataKMSKey],
-		Plaintext:      plaintext,
+		Parent:          bucket,
+		Name:            attrs.Name,
+		Generation:      attrs.Generation,
+		Metageneration:  attrs.Metageneration,
+		UpdatedAt:       attrs.Updated,
+		KMSKey:          attrs.Metadata[MetadataKMSKey],
+		ProtectionLevel: attrs.Metadata[MetadataKMSProtectionLevelKey],
+		ExpiresAt:       expiresAt,
+		Policy:          Policy(attrs.Metadata[MetadataPolicyKey]),
+		ContentType:     attrs.Metadata[MetadataContentTypeKey],
+		Size:            attrs.Size,
+		CRC32C:          attrs.CRC32C,
+		Plaintext:       plaintext,
 	}
 }
 
@@ -147,9 +409,9 @@ func timestampToTime(ts *timestamppb.Timestamp) time.Time {
 }
 
 // kmsKeyIncludesVersion returns true if the given KMS key reference includes
-// a version.
+// a version. Key URIs for other key wrappers never include a version.
 func kmsKeyIncludesVersion(s string) bool {
-	return strings.Count(s, "/") > 7
+	return isCloudKMSKey(s) && strings.Count(s, "/") > 7
 }
 
 // kmsKeyTrimVersion trims the version from a KMS key reference if it exists.
@@ -161,59 +423,3 @@ func kmsKeyTrimVersion(s string) string {
 	parts := strings.SplitN(s, "/", 9)
 	return strings.Join(parts[0:8], "/")
 }
-
-// envelopeDecrypt decrypts the data with the dek, returning the plaintext and
-// any errors that occur.
-func envelopeDecrypt(dek, data []byte) ([]byte, error) {
-	block, err := aes.NewCipher(dek)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher from dek: %w", err)
-	}
-
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create gcm from dek: %w", err)
-	}
-
-	size := aesgcm.NonceSize()
-	if len(data) < size {
-		return nil, fmt.Errorf("malformed ciphertext")
-	}
-	nonce, ciphertext := data[:size], data[size:]
-
-	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt ciphertext with dek: %w", err)
-	}
-	return plaintext, nil
-}
-
-// envelopeEncrypt generates a unique DEK and encrypts the plaintext with the
-// given key. The encryption key and resulting ciphertext are returned.
-func envelopeEncrypt(plaintext []byte) ([]byte, []byte, error) {
-	key := make([]byte, 32)
-	if _, err := io.ReadFull(rand.Reader, key); err != nil {
-		return nil, nil, fmt.Errorf("failed to generate random key bytes: %w", err)
-	}
-
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create cipher from key: %w", err)
-	}
-
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create gcm cipher: %w", err)
-	}
-
-	// Generate nonce
-	nonce := make([]byte, aesgcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, nil, fmt.Errorf("failed to generate random nonce bytes: %w", err)
-	}
-
-	// Encrypt the ciphertext with the DEK
-	ciphertext := aesgcm.Seal(nonce, nonce, plaintext, nil)
-
-	return key, ciphertext, nil
-}
@@ -38,6 +38,7 @@ var (
 
 func ExampleNew() {
 	client, err = berglas.New(ctx)
+	defer client.Close()
 }
 
 func ExampleClient_Access_secretManager() {
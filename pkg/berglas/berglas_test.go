@@ -24,6 +24,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/GoogleCloudPlatform/berglas/v2/internal/version"
 	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
 )
 
@@ -65,6 +66,93 @@ func TestKMSKeyTrimVersion(t *testing.T) {
 	}
 }
 
+func TestUserAgent(t *testing.T) {
+	t.Parallel()
+
+	if act, exp := userAgent(""), version.UserAgent; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+	if act, exp := userAgent(" my-service/1.2 "), version.UserAgent+" my-service/1.2"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	if _, err := New(context.Background(), WithUserAgentSuffix("a\r\nb")); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestClient_readOnly(t *testing.T) {
+	t.Parallel()
+
+	ctx := logging.WithLogger(context.Background(), logging.TestLogger(t))
+
+	var client Client
+	WithReadOnly().(*clientOption).apply(&client)
+
+	cases := []struct {
+		name string
+		f    func() error
+	}{
+		{
+			name: "bootstrap",
+			f: func() error {
+				return client.Bootstrap(ctx, &SecretManagerBootstrapRequest{})
+			},
+		},
+		{
+			name: "create",
+			f: func() error {
+				_, err := client.Create(ctx, &SecretManagerCreateRequest{})
+				return err
+			},
+		},
+		{
+			name: "update",
+			f: func() error {
+				_, err := client.Update(ctx, &StorageUpdateRequest{})
+				return err
+			},
+		},
+		{
+			name: "delete",
+			f: func() error {
+				return client.Delete(ctx, &StorageDeleteRequest{})
+			},
+		},
+		{
+			name: "prune",
+			f: func() error {
+				_, err := client.Prune(ctx, &StoragePruneRequest{})
+				return err
+			},
+		},
+		{
+			name: "grant",
+			f: func() error {
+				return client.Grant(ctx, &SecretManagerGrantRequest{})
+			},
+		},
+		{
+			name: "revoke",
+			f: func() error {
+				return client.Revoke(ctx, &StorageRevokeRequest{})
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tc.f(); !IsReadOnlyErr(err) {
+				t.Errorf("expected %q to be %q", err, ErrReadOnly)
+			}
+		})
+	}
+}
+
 func testClient(tb testing.TB) (context.Context, *Client) {
 	tb.Helper()
 
@@ -74,6 +162,11 @@ func testClient(tb testing.TB) (context.Context, *Client) {
 	if err != nil {
 		tb.Fatal(err)
 	}
+	tb.Cleanup(func() {
+		if err := client.Close(); err != nil {
+			tb.Error(err)
+		}
+	})
 	return ctx, client
 }
 
@@ -32,6 +32,7 @@ import (
 
 type bootstrapRequest interface {
 	isBootstrapRequest()
+	Validate() error
 }
 
 // StorageBootstrapRequest is used as input to bootstrap Cloud Storage and Cloud
@@ -54,10 +55,46 @@ type StorageBootstrapRequest struct {
 
 	// KMSCryptoKey is the name of the KMS crypto key.
 	KMSCryptoKey string
+
+	// KMSProtectionLevel is the protection level of the KMS crypto key, either
+	// "SOFTWARE" or "HSM". The default is "SOFTWARE".
+	KMSProtectionLevel string
+
+	// KMSRotationPeriod is how often the KMS crypto key is rotated. It must be
+	// at least one day. The default is 30 days, and a negative value disables
+	// automatic rotation.
+	KMSRotationPeriod time.Duration
+
+	// KMSLabels are labels to set on the KMS crypto key.
+	KMSLabels map[string]string
+
+	// ExpirationLifecycle adds a bucket lifecycle rule that deletes secrets one
+	// day after their expiration time, in case Prune is not run. It only
+	// applies when the bucket is created.
+	ExpirationLifecycle bool
 }
 
 func (r *StorageBootstrapRequest) isBootstrapRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageBootstrapRequest) Validate() error {
+	var v validator
+	v.require("ProjectID", r.ProjectID, "missing project ID")
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	switch r.KMSProtectionLevel {
+	case "", kmspb.ProtectionLevel_SOFTWARE.String(), kmspb.ProtectionLevel_HSM.String():
+	default:
+		v.addf("KMSProtectionLevel", "invalid protection level %q: must be SOFTWARE or HSM",
+			r.KMSProtectionLevel)
+	}
+	if r.KMSRotationPeriod > 0 && r.KMSRotationPeriod < 24*time.Hour {
+		v.addf("KMSRotationPeriod", "invalid rotation period %s: must be at least 24h",
+			r.KMSRotationPeriod)
+	}
+	return v.err()
+}
+
 // BootstrapRequest is an alias for StorageBootstrapRequest for
 // backwards-compatibility. New clients should use StorageBootstrapRequest.
 type BootstrapRequest = StorageBootstrapRequest
@@ -68,10 +105,16 @@ type SecretManagerBootstrapRequest struct{}
 
 func (r *SecretManagerBootstrapRequest) isBootstrapRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerBootstrapRequest) Validate() error {
+	return nil
+}
+
 // Bootstrap is a top-level package that creates a Cloud Storage bucket and
 // Cloud KMS key with the proper IAM permissions.
 func Bootstrap(ctx context.Context, i bootstrapRequest) error {
-	client, err := New(ctx)
+	client, err := DefaultClient(ctx)
 	if err != nil {
 		return err
 	}
@@ -85,6 +128,14 @@ func (c *Client) Bootstrap(ctx context.Context, i bootstrapRequest) error {
 		return fmt.Errorf("missing request")
 	}
 
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := i.Validate(); err != nil {
+		return err
+	}
+
 	switch t := i.(type) {
 	case *SecretManagerBootstrapRequest:
 		return c.secretManagerBootstrap(ctx, t)
@@ -101,15 +152,7 @@ func (c *Client) secretManagerBootstrap(ctx context.Context, i *SecretManagerBoo
 
 func (c *Client) storageBootstrap(ctx context.Context, i *StorageBootstrapRequest) error {
 	projectID := i.ProjectID
-	if projectID == "" {
-		return fmt.Errorf("missing project ID")
-	}
-
 	bucket := i.Bucket
-	if bucket == "" {
-		return fmt.Errorf("missing bucket name")
-	}
-
 	bucketLocation := strings.ToUpper(i.BucketLocation)
 	if bucketLocation == "" {
 		bucketLocation = "US"
@@ -130,6 +173,16 @@ func (c *Client) storageBootstrap(ctx context.Context, i *StorageBootstrapReques
 		kmsCryptoKey = "berglas-key"
 	}
 
+	kmsProtectionLevel := kmspb.ProtectionLevel_SOFTWARE
+	if i.KMSProtectionLevel != "" {
+		kmsProtectionLevel = kmspb.ProtectionLevel(kmspb.ProtectionLevel_value[i.KMSProtectionLevel])
+	}
+
+	kmsRotationPeriod := i.KMSRotationPeriod
+	if kmsRotationPeriod == 0 {
+		kmsRotationPeriod = 30 * 24 * time.Hour
+	}
+
 	logger := logging.FromContext(ctx).With(
 		"project_id", projectID,
 		"bucket", bucket,
@@ -137,6 +190,8 @@ func (c *Client) storageBootstrap(ctx context.Context, i *StorageBootstrapReques
 		"kms_location", kmsLocation,
 		"kms_key_ring", kmsKeyRing,
 		"kms_crypto_key", kmsCryptoKey,
+		"kms_protection_level", kmsProtectionLevel.String(),
+		"kms_rotation_period", kmsRotationPeriod,
 	)
 
 	logger.DebugContext(ctx, "bootstrap.start")
@@ -161,26 +216,30 @@ func (c *Client) storageBootstrap(ctx context.Context, i *StorageBootstrapReques
 	// Create the KMS crypto key
 	logger.DebugContext(ctx, "creating KMS crypto key")
 
-	rotationPeriod := 30 * 24 * time.Hour
+	cryptoKey := &kmspb.CryptoKey{
+		Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT,
+		VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+			Algorithm:       kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION,
+			ProtectionLevel: kmsProtectionLevel,
+		},
+		Labels: i.KMSLabels,
+	}
+	if kmsRotationPeriod > 0 {
+		cryptoKey.RotationSchedule = &kmspb.CryptoKey_RotationPeriod{
+			RotationPeriod: &durationpb.Duration{
+				Seconds: int64(kmsRotationPeriod.Seconds()),
+			},
+		}
+		cryptoKey.NextRotationTime = &timestamppb.Timestamp{
+			Seconds: time.Now().Add(kmsRotationPeriod).Unix(),
+		}
+	}
+
 	if _, err := c.kmsClient.CreateCryptoKey(ctx, &kmspb.CreateCryptoKeyRequest{
 		Parent: fmt.Sprintf("projects/%s/locations/%s/keyRings/%s",
 			projectID, kmsLocation, kmsKeyRing),
 		CryptoKeyId: kmsCryptoKey,
-		CryptoKey: &kmspb.CryptoKey{
-			Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT,
-			RotationSchedule: &kmspb.CryptoKey_RotationPeriod{
-				RotationPeriod: &durationpb.Duration{
-					Seconds: int64(rotationPeriod.Seconds()),
-				},
-			},
-			NextRotationTime: &timestamppb.Timestamp{
-				Seconds: time.Now().Add(time.Duration(rotationPeriod)).Unix(),
-			},
-			VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
-				Algorithm:       kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION,
-				ProtectionLevel: kmspb.ProtectionLevel_SOFTWARE,
-			},
-		},
+		CryptoKey:   cryptoKey,
 	}); err != nil {
 		logger.ErrorContext(ctx, "failed to create KMS crypto key", "error", err)
 
@@ -193,22 +252,35 @@ func (c *Client) storageBootstrap(ctx context.Context, i *StorageBootstrapReques
 	// Create the storage bucket
 	logger.DebugContext(ctx, "creating bucket")
 
+	lifecycleRules := []storage.LifecycleRule{
+		{
+			Action: storage.LifecycleAction{
+				Type: "Delete",
+			},
+			Condition: storage.LifecycleCondition{
+				NumNewerVersions: 10,
+			},
+		},
+	}
+	if i.ExpirationLifecycle {
+		// Secrets with an expiration also have their Custom-Time set to it.
+		lifecycleRules = append(lifecycleRules, storage.LifecycleRule{
+			Action: storage.LifecycleAction{
+				Type: "Delete",
+			},
+			Condition: storage.LifecycleCondition{
+				DaysSinceCustomTime: 1,
+			},
+		})
+	}
+
 	if err := c.storageClient.Bucket(bucket).Create(ctx, projectID, &storage.BucketAttrs{
 		PredefinedACL:              "private",
 		PredefinedDefaultObjectACL: "private",
 		Location:                   bucketLocation,
 		VersioningEnabled:          true,
 		Lifecycle: storage.Lifecycle{
-			Rules: []storage.LifecycleRule{
-				{
-					Action: storage.LifecycleAction{
-						Type: "Delete",
-					},
-					Condition: storage.LifecycleCondition{
-						NumNewerVersions: 10,
-					},
-				},
-			},
+			Rules: lifecycleRules,
 		},
 		Labels: map[string]string{
 			"purpose": "berglas",
@@ -0,0 +1,209 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultCircuitBreakerThreshold is the default number of consecutive
+	// unavailable errors that open the circuit breaker.
+	DefaultCircuitBreakerThreshold = 5
+
+	// DefaultCircuitBreakerCooldown is the default time the circuit breaker
+	// stays open before letting a request through again.
+	DefaultCircuitBreakerCooldown = 30 * time.Second
+
+	// staleCacheTimeout bounds reading a stale cache entry, which may need to
+	// unwrap its key with Cloud KMS after the access itself timed out.
+	staleCacheTimeout = 10 * time.Second
+)
+
+// WithCircuitBreaker returns a client option that stops sending Access calls,
+// including those made by Resolve and ResolveEnv, to a backend after threshold
+// consecutive requests to it failed because it was unavailable. While the
+// breaker is open, accesses fail immediately with ErrCircuitOpen, or are served
+// from the disk cache with WithStaleCacheFallback. After cooldown, one request
+// is let through; if it succeeds the breaker closes, and otherwise it stays
+// open for another cooldown. Secret Manager, Cloud Storage, and S3 each have
+// their own breaker. A threshold or cooldown of zero uses the default.
+//
+// Only errors that mean the backend could not be reached or was overloaded,
+// such as connection failures, timeouts, and 5xx or UNAVAILABLE responses,
+// count towards the threshold. Errors such as a missing secret or a denied
+// permission do not.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) option.ClientOption {
+	if threshold <= 0 {
+		threshold = DefaultCircuitBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultCircuitBreakerCooldown
+	}
+	return &clientOption{apply: func(c *Client) {
+		c.breaker = &circuitBreaker{
+			threshold: threshold,
+			cooldown:  cooldown,
+			now:       time.Now,
+		}
+	}}
+}
+
+// WithStaleCacheFallback returns a client option that serves an expired entry
+// from the disk cache, up to maxStaleness after it expired, when an access
+// fails because the backend is unavailable or the circuit breaker is open. A
+// warning is logged each time a stale value is served, and Resolve and
+// ResolveEnv enforce the Policy cached with the entry. This keeps processes
+// that restart during an outage running on the last known values, at the cost
+// of possibly using a secret that has since been rotated or revoked.
+//
+// It has no effect without WithDiskCache, and entries are kept on disk until
+// they are maxStaleness past their expiry. If the cache key is a Cloud KMS key,
+// reading an entry needs Cloud KMS to be available; use a local key to survive
+// outages that include Cloud KMS.
+func WithStaleCacheFallback(maxStaleness time.Duration) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.maxStaleness = maxStaleness
+	}}
+}
+
+// circuitBreaker tracks consecutive unavailable errors for each backend.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	lock     sync.Mutex
+	backends map[string]*breakerState
+}
+
+// breakerState is the state of the breaker for one backend.
+type breakerState struct {
+	failures  int
+	openUntil time.Time
+}
+
+// allow reports whether a request may be sent to the backend.
+func (b *circuitBreaker) allow(backend string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	st, ok := b.backends[backend]
+	if !ok || st.failures < b.threshold {
+		return true
+	}
+	if b.now().Before(st.openUntil) {
+		return false
+	}
+
+	// Let one request through and keep the others out until it completes.
+	st.openUntil = b.now().Add(b.cooldown)
+	return true
+}
+
+// record records the result of a request to the backend.
+func (b *circuitBreaker) record(backend string, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.backends == nil {
+		b.backends = make(map[string]*breakerState)
+	}
+	st, ok := b.backends[backend]
+	if !ok {
+		st = &breakerState{}
+		b.backends[backend] = st
+	}
+
+	if !isUpstreamUnavailable(err) {
+		st.failures = 0
+		return
+	}
+	st.failures++
+	if st.failures >= b.threshold {
+		st.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// accessBreaker sends the access upstream unless the circuit breaker for its
+// backend is open.
+func (c *Client) accessBreaker(ctx context.Context, i accessRequest, access accessFunc) (*accessResult, error) {
+	backend := fmt.Sprintf("%T", i)
+	if !c.breaker.allow(backend) {
+		logging.FromContext(ctx).DebugContext(ctx, "circuit breaker open", "backend", backend)
+		return nil, ErrCircuitOpen
+	}
+
+	result, err := access(ctx, i)
+	if !errors.Is(err, context.Canceled) {
+		c.breaker.record(backend, err)
+	}
+	return result, err
+}
+
+// accessStale returns an expired entry from the disk cache within the client's
+// maximum staleness, logging a warning that it is serving in degraded mode.
+func (c *Client) accessStale(ctx context.Context, pth, reqKey string, cause error) (*accessResult, bool) {
+	logger := logging.FromContext(ctx)
+
+	readCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), staleCacheTimeout)
+	defer cancel()
+
+	result, expiresAt, err := c.diskCacheGet(readCtx, pth, reqKey, c.maxStaleness)
+	if err != nil {
+		logger.DebugContext(ctx, "no stale disk cache entry", "error", err)
+		return nil, false
+	}
+
+	logger.WarnContext(ctx, "backend unavailable, serving stale cached secret (degraded mode)",
+		"error", cause,
+		"stale_for", time.Since(expiresAt).Round(time.Second).String())
+	return result, true
+}
+
+// isUpstreamUnavailable reports whether err means the backend could not be
+// reached or could not serve the request, rather than that the request itself
+// was rejected.
+func isUpstreamUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	switch grpcstatus.Code(err) {
+	case grpccodes.Unavailable, grpccodes.DeadlineExceeded, grpccodes.ResourceExhausted,
+		grpccodes.Internal:
+		return true
+	}
+
+	var terr *googleapi.Error
+	if errors.As(err, &terr) {
+		return terr.Code == http.StatusTooManyRequests || terr.Code >= http.StatusInternalServerError
+	}
+	return isStorageReadRetryable(err)
+}
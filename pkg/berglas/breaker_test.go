@@ -0,0 +1,285 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// flakyS3Store is an S3Store whose reads fail with err while it is set.
+type flakyS3Store struct {
+	memS3Store
+	reads atomic.Int32
+	err   atomic.Value
+}
+
+func (s *flakyS3Store) GetObject(ctx context.Context, bucket, object string) (*S3Object, error) {
+	s.reads.Add(1)
+	if err, ok := s.err.Load().(error); ok && err != nil {
+		return nil, err
+	}
+	return s.memS3Store.GetObject(ctx, bucket, object)
+}
+
+func TestIsUpstreamUnavailable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		err  error
+		exp  bool
+	}{
+		{"nil", nil, false},
+		{"canceled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, true},
+		{"grpc_unavailable", secretManagerError(grpcstatus.Error(grpccodes.Unavailable, "down"), "p", "n"), true},
+		{"grpc_not_found", secretManagerError(grpcstatus.Error(grpccodes.NotFound, "gone"), "p", "n"), false},
+		{"grpc_permission", grpcstatus.Error(grpccodes.PermissionDenied, "no"), false},
+		{"http_503", fmt.Errorf("read: %w", &googleapi.Error{Code: 503}), true},
+		{"http_403", &googleapi.Error{Code: 403}, false},
+		{"conn_refused", fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{"does_not_exist", ErrSecretDoesNotExist, false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if act, exp := isUpstreamUnavailable(tc.err), tc.exp; act != exp {
+				t.Errorf("expected %t to be %t", act, exp)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(0, 0)
+	b := WithCircuitBreaker(2, time.Minute).(*clientOption)
+	c := &Client{}
+	b.apply(c)
+	c.breaker.now = func() time.Time { return now }
+
+	unavailable := grpcstatus.Error(grpccodes.Unavailable, "down")
+
+	c.breaker.record("sm", unavailable)
+	if !c.breaker.allow("sm") {
+		t.Fatal("expected breaker to be closed after one failure")
+	}
+
+	// A different error resets the count
+	c.breaker.record("sm", ErrSecretDoesNotExist)
+	c.breaker.record("sm", unavailable)
+	if !c.breaker.allow("sm") {
+		t.Fatal("expected breaker to be closed after a reset")
+	}
+
+	c.breaker.record("sm", unavailable)
+	if c.breaker.allow("sm") {
+		t.Fatal("expected breaker to be open")
+	}
+	if !c.breaker.allow("gcs") {
+		t.Error("expected other backends to be unaffected")
+	}
+
+	// After the cooldown one request is let through
+	now = now.Add(time.Minute)
+	if !c.breaker.allow("sm") {
+		t.Fatal("expected breaker to let one request through")
+	}
+	if c.breaker.allow("sm") {
+		t.Fatal("expected breaker to hold other requests")
+	}
+
+	c.breaker.record("sm", nil)
+	if !c.breaker.allow("sm") {
+		t.Error("expected breaker to close after a success")
+	}
+}
+
+func TestClient_Access_staleCacheFallback(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	keyPath := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := KeySchemeLocal + "://" + keyPath
+
+	store := &flakyS3Store{}
+	c := &Client{}
+	WithS3Store(store).(*clientOption).apply(c)
+	WithDiskCache(t.TempDir(), key, time.Nanosecond).(*clientOption).apply(c)
+	WithStaleCacheFallback(time.Hour).(*clientOption).apply(c)
+	WithCircuitBreaker(1, time.Hour).(*clientOption).apply(c)
+
+	if _, err := c.Create(ctx, &StorageS3CreateRequest{
+		Bucket:    "bucket",
+		Object:    "a",
+		Key:       key,
+		Plaintext: []byte("value"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &StorageS3AccessRequest{Bucket: "bucket", Object: "a"}
+	if _, err := c.Access(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry has expired, but is served while the store is unavailable
+	store.err.Store(fmt.Errorf("dial: %w", syscall.ECONNREFUSED))
+	store.reads.Store(0)
+	for i := 0; i < 2; i++ {
+		plaintext, err := c.Access(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act, exp := string(plaintext), "value"; act != exp {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+	}
+
+	// The breaker opened after the first failure
+	if act, exp := store.reads.Load(), int32(1); act != exp {
+		t.Errorf("expected %d reads, got %d", exp, act)
+	}
+
+	// Secrets without a cache entry fail with the breaker open
+	if _, err := c.Access(ctx, &StorageS3AccessRequest{Bucket: "bucket", Object: "b"}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected %v to be %v", err, ErrCircuitOpen)
+	}
+}
+
+func TestClient_Resolve_staleCacheFallback(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	keyPath := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := KeySchemeLocal + "://" + keyPath
+
+	store := &flakyS3Store{}
+	c := &Client{}
+	WithS3Store(store).(*clientOption).apply(c)
+	WithDiskCache(t.TempDir(), key, time.Nanosecond).(*clientOption).apply(c)
+	WithStaleCacheFallback(time.Hour).(*clientOption).apply(c)
+	WithCircuitBreaker(1, time.Hour).(*clientOption).apply(c)
+
+	for _, name := range []string{"a", "file"} {
+		if _, err := c.Create(ctx, &StorageS3CreateRequest{
+			Bucket:    "bucket",
+			Object:    name,
+			Key:       key,
+			Plaintext: []byte("value"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.objects["bucket/file"].Metadata[MetadataPolicyKey] = string(PolicyFileOnly)
+
+	fileRef := "s3://bucket/file?destination=" + filepath.Join(dir, "file")
+	for _, ref := range []string{"s3://bucket/a", fileRef} {
+		if _, err := c.Resolve(ctx, ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The entries have expired, but are served while the store is unavailable
+	store.err.Store(fmt.Errorf("dial: %w", syscall.ECONNREFUSED))
+	store.reads.Store(0)
+
+	plaintext, err := c.Resolve(ctx, "s3://bucket/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := string(plaintext), "value"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	if _, err := c.Resolve(ctx, fileRef); err != nil {
+		t.Fatal(err)
+	}
+
+	// The policy of the stale entry is still enforced
+	if _, err := c.Resolve(ctx, "s3://bucket/file"); !errors.Is(err, ErrPolicyViolation) {
+		t.Errorf("expected policy violation, got %v", err)
+	}
+
+	// The breaker opened after the first failure
+	if act, exp := store.reads.Load(), int32(1); act != exp {
+		t.Errorf("expected %d reads, got %d", exp, act)
+	}
+}
+
+func TestClient_Access_staleCacheFallbackRejected(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	keyPath := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := KeySchemeLocal + "://" + keyPath
+
+	store := &flakyS3Store{}
+	c := &Client{}
+	WithS3Store(store).(*clientOption).apply(c)
+	WithDiskCache(t.TempDir(), key, time.Nanosecond).(*clientOption).apply(c)
+	WithStaleCacheFallback(time.Hour).(*clientOption).apply(c)
+
+	if _, err := c.Create(ctx, &StorageS3CreateRequest{
+		Bucket:    "bucket",
+		Object:    "a",
+		Key:       key,
+		Plaintext: []byte("value"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &StorageS3AccessRequest{Bucket: "bucket", Object: "a"}
+	if _, err := c.Access(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	// A revoked secret is never served from the cache
+	store.err.Store(grpcstatus.Error(grpccodes.PermissionDenied, "denied"))
+	if _, err := c.Access(ctx, req); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"github.com/GoogleCloudPlatform/berglas/v2/internal/version"
+)
+
+// VersionInfo describes the berglas build.
+type VersionInfo struct {
+	// Name is the name of the binary.
+	Name string `json:"name"`
+
+	// Version is the release version, or "source" for local builds.
+	Version string `json:"version"`
+
+	// Commit is the git commit the build is from.
+	Commit string `json:"commit"`
+
+	// Date is the date of the build's commit.
+	Date string `json:"date"`
+
+	// GoVersion is the version of Go used for the build.
+	GoVersion string `json:"go_version"`
+
+	// OSArch is the operating system and architecture of the build.
+	OSArch string `json:"os_arch"`
+}
+
+// BuildInfo returns information about the berglas build. Release builds set
+// these values at link time; other builds fall back to the module and VCS
+// information embedded by the Go toolchain.
+func BuildInfo() *VersionInfo {
+	return &VersionInfo{
+		Name:      version.Name,
+		Version:   version.Version,
+		Commit:    version.Commit,
+		Date:      version.Date,
+		GoVersion: version.GoVersion,
+		OSArch:    version.OSArch,
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/option"
+)
+
+const (
+	// DefaultDiskCacheTTL is the default time a secret is served from the disk
+	// cache.
+	DefaultDiskCacheTTL = 5 * time.Minute
+
+	// diskCacheExt is the extension of disk cache entries.
+	diskCacheExt = ".cache"
+)
+
+// diskCache is an on-disk cache of accessed secrets.
+type diskCache struct {
+	dir string
+	key string
+	ttl time.Duration
+}
+
+// diskCacheEntry is the contents of a disk cache entry.
+type diskCacheEntry struct {
+	// ExpiresAt is when the entry expires. It is bound into the additional
+	// authenticated data, so it cannot be extended.
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Key is the key that wrapped the data encryption key.
+	Key string `json:"key"`
+
+	// Policy is the Policy of the secret. Like ExpiresAt, it is bound into the
+	// additional authenticated data.
+	Policy Policy `json:"policy,omitempty"`
+
+	// Data is the envelope, as stored in Cloud Storage.
+	Data string `json:"data"`
+}
+
+// DefaultDiskCacheDir returns the default directory for the disk cache, in the
+// user's cache directory.
+func DefaultDiskCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "berglas"), nil
+}
+
+// WithDiskCache caches the plaintext returned by Access, including for Resolve
+// and ResolveEnv, in files in dir for the given TTL (or DefaultDiskCacheTTL if
+// zero). This reduces repeated requests from short-lived processes that run
+// often, such as cron jobs.
+//
+// Entries are encrypted with a new data encryption key, which is wrapped with
+// key in the same way as Cloud Storage secrets. The key may be a Cloud KMS key
+// or a URI for a registered KeyWrapper, such as "local-key:///path/to/key".
+// Entries are bound to the request and expiry, and their file names are hashes,
+// so they do not reveal secret names.
+//
+// Cached values are served until they expire, even if the secret changes. Use
+// PurgeDiskCache to remove all entries.
+func WithDiskCache(dir, key string, ttl time.Duration) option.ClientOption {
+	if ttl == 0 {
+		ttl = DefaultDiskCacheTTL
+	}
+	return &clientOption{apply: func(c *Client) {
+		c.diskCache = &diskCache{dir: dir, key: key, ttl: ttl}
+	}}
+}
+
+// PurgeDiskCache removes all entries from the disk cache in dir, returning the
+// number removed. It is not an error if dir does not exist.
+func PurgeDiskCache(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	var n int
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != diskCacheExt {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return n, fmt.Errorf("failed to remove cache entry: %w", err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// accessCached returns the secret from the disk cache if it has a live entry,
+// and otherwise accesses it and adds it to the cache. Failures to read or write
+// the cache are logged and do not fail the access. If the access fails because
+// the backend is unavailable and the client was created with
+// WithStaleCacheFallback, an expired entry is served instead.
+func (c *Client) accessCached(ctx context.Context, i accessRequest, access accessFunc) (*accessResult, error) {
+	logger := logging.FromContext(ctx)
+
+	reqKey := accessRequestKey(ctx, i)
+	pth := filepath.Join(c.diskCache.dir, diskCacheFilename(reqKey))
+
+	result, _, err := c.diskCacheGet(ctx, pth, reqKey, 0)
+	if err != nil {
+		logger.DebugContext(ctx, "disk cache miss", "error", err)
+	} else {
+		logger.DebugContext(ctx, "disk cache hit")
+		return result, nil
+	}
+
+	result, err = access(ctx, i)
+	if err != nil {
+		if c.maxStaleness > 0 && isUpstreamUnavailable(err) {
+			if stale, ok := c.accessStale(ctx, pth, reqKey, err); ok {
+				return stale, nil
+			}
+		}
+		return nil, err
+	}
+
+	if err := c.diskCachePut(ctx, pth, reqKey, result); err != nil {
+		logger.WarnContext(ctx, "failed to write disk cache entry", "error", err)
+	}
+	return result, nil
+}
+
+// diskCacheGet reads and decrypts a cache entry, also returning when it
+// expires. Entries up to staleness past their expiry are returned. Entries are
+// removed once they are past the client's maximum staleness, so they are kept
+// for WithStaleCacheFallback.
+func (c *Client) diskCacheGet(ctx context.Context, pth, reqKey string, staleness time.Duration) (*accessResult, time.Time, error) {
+	b, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var entry diskCacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid cache entry: %w", err)
+	}
+	now := time.Now()
+	if !now.Before(entry.ExpiresAt.Add(c.maxStaleness)) {
+		_ = os.Remove(pth)
+		return nil, time.Time{}, fmt.Errorf("cache entry expired")
+	}
+	if !now.Before(entry.ExpiresAt.Add(staleness)) {
+		return nil, time.Time{}, fmt.Errorf("cache entry expired")
+	}
+	if entry.Key != c.diskCache.key {
+		return nil, time.Time{}, fmt.Errorf("cache entry encrypted with a different key")
+	}
+
+	alg, encDEK, ciphertext, err := envelopeDecode(entry.Data)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	dek, err := c.unwrapDEK(ctx, entry.Key, encDEK, diskCacheAAD(reqKey, entry.ExpiresAt, entry.Policy))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to decrypt dek: %w", err)
+	}
+	defer c.wipe(dek)
+
+	plaintext, err := envelopeDecrypt(alg, dek, ciphertext)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return &accessResult{plaintext: plaintext, policy: entry.Policy}, entry.ExpiresAt, nil
+}
+
+// diskCachePut encrypts and writes a cache entry.
+func (c *Client) diskCachePut(ctx context.Context, pth, reqKey string, result *accessResult) error {
+	expiresAt := time.Now().Add(c.diskCache.ttl).UTC()
+
+	dek, ciphertext, err := envelopeEncrypt(c.envelopeAlgorithm, result.plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to perform envelope encryption: %w", err)
+	}
+	defer c.wipe(dek)
+
+	encDEK, err := c.wrapDEK(ctx, c.diskCache.key, dek, diskCacheAAD(reqKey, expiresAt, result.policy))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt dek: %w", err)
+	}
+
+	b, err := json.Marshal(&diskCacheEntry{
+		ExpiresAt: expiresAt,
+		Key:       c.diskCache.key,
+		Policy:    result.policy,
+		Data:      envelopeEncode(c.envelopeAlgorithm, encDEK, ciphertext),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %w", err)
+	}
+
+	if err := os.MkdirAll(c.diskCache.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	// Write to a temporary file and rename it, so concurrent readers never see
+	// a partial entry.
+	f, err := os.CreateTemp(c.diskCache.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache entry: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close cache entry: %w", err)
+	}
+	if err := os.Rename(f.Name(), pth); err != nil {
+		return fmt.Errorf("failed to save cache entry: %w", err)
+	}
+	return nil
+}
+
+// accessRequestKey identifies the secret and version an access request reads.
+// Requests billed to different quota projects have different keys.
+func accessRequestKey(ctx context.Context, i accessRequest) string {
+	return fmt.Sprintf("%T%+v?quotaProject=%s", i, i, quotaProjectFromContext(ctx))
+}
+
+// diskCacheFilename is the file name of the cache entry for the request key.
+func diskCacheFilename(reqKey string) string {
+	sum := sha256.Sum256([]byte(reqKey))
+	return hex.EncodeToString(sum[:]) + diskCacheExt
+}
+
+// diskCacheAAD binds a cache entry to its request, expiry, and policy.
+func diskCacheAAD(reqKey string, expiresAt time.Time, policy Policy) []byte {
+	return []byte(strings.Join([]string{
+		"berglas-cache", reqKey, expiresAt.Format(time.RFC3339Nano), string(policy),
+	}, "\x00"))
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClient_Access_diskCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	keyPath := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := KeySchemeLocal + "://" + keyPath
+	dir := filepath.Join(t.TempDir(), "cache")
+
+	store := &slowS3Store{}
+	c := &Client{}
+	WithS3Store(store).(*clientOption).apply(c)
+	WithDiskCache(dir, key, time.Hour).(*clientOption).apply(c)
+
+	for _, name := range []string{"a", "b"} {
+		if _, err := c.Create(ctx, &StorageS3CreateRequest{
+			Bucket:    "bucket",
+			Object:    name,
+			Key:       key,
+			Plaintext: []byte("value-" + name),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.reads.Store(0)
+
+	access := func(name string) string {
+		t.Helper()
+
+		plaintext, err := c.Access(ctx, &StorageS3AccessRequest{Bucket: "bucket", Object: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(plaintext)
+	}
+
+	// The second access is served from the cache
+	for i := 0; i < 2; i++ {
+		if act, exp := access("a"), "value-a"; act != exp {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+	}
+	if act, exp := store.reads.Load(), int32(1); act != exp {
+		t.Errorf("expected %d reads, got %d", exp, act)
+	}
+
+	// Entries do not reveal names and are bound to their request
+	if act, exp := access("b"), "value-b"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+	pthA := filepath.Join(dir, diskCacheFilename(accessRequestKey(ctx, &StorageS3AccessRequest{Bucket: "bucket", Object: "a"})))
+	pthB := filepath.Join(dir, diskCacheFilename(accessRequestKey(ctx, &StorageS3AccessRequest{Bucket: "bucket", Object: "b"})))
+	entry, err := os.ReadFile(pthA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(entry, []byte("value-a")) || bytes.Contains(entry, []byte("bucket")) {
+		t.Errorf("expected %q to not contain the secret or its name", entry)
+	}
+	if err := os.WriteFile(pthB, entry, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store.reads.Store(0)
+	if act, exp := access("b"), "value-b"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+	if act, exp := store.reads.Load(), int32(1); act != exp {
+		t.Errorf("expected %d reads, got %d", exp, act)
+	}
+
+	// Purging removes every entry
+	n, err := PurgeDiskCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := n, 2; act != exp {
+		t.Errorf("expected %d to be %d", act, exp)
+	}
+	store.reads.Store(0)
+	access("a")
+	if act, exp := store.reads.Load(), int32(1); act != exp {
+		t.Errorf("expected %d reads, got %d", exp, act)
+	}
+}
+
+func TestClient_Access_diskCacheExpired(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	keyPath := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := KeySchemeLocal + "://" + keyPath
+
+	store := &slowS3Store{}
+	c := &Client{}
+	WithS3Store(store).(*clientOption).apply(c)
+	WithDiskCache(t.TempDir(), key, time.Nanosecond).(*clientOption).apply(c)
+
+	if _, err := c.Create(ctx, &StorageS3CreateRequest{
+		Bucket:    "bucket",
+		Object:    "a",
+		Key:       key,
+		Plaintext: []byte("value"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	store.reads.Store(0)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Access(ctx, &StorageS3AccessRequest{Bucket: "bucket", Object: "a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if act, exp := store.reads.Load(), int32(2); act != exp {
+		t.Errorf("expected %d reads, got %d", exp, act)
+	}
+}
+
+func TestPurgeDiskCache_missing(t *testing.T) {
+	t.Parallel()
+
+	n, err := PurgeDiskCache(filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("expected 0 entries, got %d", n)
+	}
+}
@@ -0,0 +1,431 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/googleapi"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// CanIAction is an operation whose permissions can be checked with CanI.
+type CanIAction string
+
+const (
+	// CanIAccess checks the permissions needed to read a secret.
+	CanIAccess CanIAction = "access"
+
+	// CanICreate checks the permissions needed to create a secret.
+	CanICreate CanIAction = "create"
+
+	// CanIGrant checks the permissions needed to grant or revoke access to a
+	// secret.
+	CanIGrant CanIAction = "grant"
+)
+
+// IsCanIAction returns true if s is a supported CanIAction.
+func IsCanIAction(s string) bool {
+	switch CanIAction(s) {
+	case CanIAccess, CanICreate, CanIGrant:
+		return true
+	default:
+		return false
+	}
+}
+
+type canIRequest interface {
+	isCanIRequest()
+	Validate() error
+}
+
+// StorageCanIRequest is used as input to check permissions on a secret in
+// Cloud Storage.
+type StorageCanIRequest struct {
+	// Bucket is the name of the bucket where the secret lives.
+	Bucket string
+
+	// Object is the name of the object in Cloud Storage.
+	Object string
+
+	// Key is the fully qualified KMS key id. It is required for CanICreate. For
+	// other actions, the key recorded on the secret is used instead, unless the
+	// caller cannot read the secret's metadata.
+	Key string
+
+	// Action is the operation to check.
+	Action CanIAction
+}
+
+func (r *StorageCanIRequest) isCanIRequest() {}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageCanIRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	v.canIAction("Action", r.Action)
+	if r.Action == CanICreate {
+		v.require("Key", r.Key, "missing key name")
+	}
+	v.kmsKey("Key", r.Key)
+	return v.err()
+}
+
+// SecretManagerCanIRequest is used as input to check permissions on a secret
+// in Secret Manager.
+type SecretManagerCanIRequest struct {
+	// Project is the ID or number of the project where the secret lives.
+	Project string
+
+	// Name is the name of the secret.
+	Name string
+
+	// Action is the operation to check.
+	Action CanIAction
+}
+
+func (r *SecretManagerCanIRequest) isCanIRequest() {}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerCanIRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	v.require("Name", r.Name, "missing secret name")
+	v.canIAction("Action", r.Action)
+	return v.err()
+}
+
+// CanIPermission is a single permission checked by CanI.
+type CanIPermission struct {
+	// Resource is the full name of the resource the permission was tested on.
+	Resource string
+
+	// Permission is the IAM permission, such as "storage.objects.get".
+	Permission string
+
+	// Granted is true if the caller has the permission.
+	Granted bool
+}
+
+// CanIResponse is the response from a CanI call.
+type CanIResponse struct {
+	// Exists indicates whether the secret exists. Access and grant are never
+	// allowed on a secret that does not exist, and create is never allowed on
+	// one that does.
+	Exists bool
+
+	// Allowed is true if the secret is in the expected state for the action and
+	// the caller has every required permission.
+	Allowed bool
+
+	// Permissions are the permissions required for the action, in the order
+	// they are needed.
+	Permissions []*CanIPermission
+}
+
+// Missing returns the required permissions the caller does not have.
+func (r *CanIResponse) Missing() []*CanIPermission {
+	var missing []*CanIPermission
+	for _, p := range r.Permissions {
+		if !p.Granted {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// CanI is a top-level package function for checking permissions on a secret.
+// For large volumes of secrets, please create a client instead.
+func CanI(ctx context.Context, i canIRequest) (*CanIResponse, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.CanI(ctx, i)
+}
+
+// CanI checks whether the caller has the permissions required to perform an
+// action on a secret, using IAM permission tests instead of attempting the
+// action. Missing permissions are reported in the response, not as an error.
+func (c *Client) CanI(ctx context.Context, i canIRequest) (*CanIResponse, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch t := i.(type) {
+	case *SecretManagerCanIRequest:
+		return c.secretManagerCanI(ctx, t)
+	case *StorageCanIRequest:
+		return c.storageCanI(ctx, t)
+	default:
+		return nil, fmt.Errorf("unknown can-i type %T", t)
+	}
+}
+
+func (c *Client) secretManagerCanI(ctx context.Context, i *SecretManagerCanIRequest) (*CanIResponse, error) {
+	project := i.Project
+	name := i.Name
+	action := i.Action
+
+	logger := logging.FromContext(ctx).With(
+		"project", project,
+		"name", name,
+		"action", action,
+	)
+
+	logger.DebugContext(ctx, "canI.start")
+	defer logger.DebugContext(ctx, "canI.finish")
+
+	resource := fmt.Sprintf("projects/%s/secrets/%s", project, name)
+
+	var exists bool
+	if _, err := c.secretManagerClient.GetSecret(ctx, &secretspb.GetSecretRequest{
+		Name: resource,
+	}); err != nil {
+		terr, ok := grpcstatus.FromError(err)
+		if !ok || (terr.Code() != grpccodes.NotFound && terr.Code() != grpccodes.PermissionDenied) {
+			return nil, fmt.Errorf("failed to read secret: %w", err)
+		}
+		// Without secretmanager.secrets.get, the secret may exist, but testing
+		// its permissions will tell.
+		exists = terr.Code() == grpccodes.PermissionDenied
+	} else {
+		exists = true
+	}
+
+	resp := &CanIResponse{Exists: exists}
+
+	// Creating a secret is checked against the project, since the secret does
+	// not exist yet.
+	if action == CanICreate {
+		parent := "projects/" + project
+		perms, err := c.projectTestPermissions(ctx, parent, []string{
+			"secretmanager.secrets.create",
+			"secretmanager.versions.add",
+		})
+		if err != nil {
+			return nil, err
+		}
+		resp.Permissions = perms
+		resp.Allowed = !exists && len(resp.Missing()) == 0
+		return resp, nil
+	}
+
+	if !exists {
+		return resp, nil
+	}
+
+	var required []string
+	switch action {
+	case CanIAccess:
+		required = []string{"secretmanager.versions.access"}
+	case CanIGrant:
+		required = []string{
+			"secretmanager.secrets.getIamPolicy",
+			"secretmanager.secrets.setIamPolicy",
+		}
+	}
+
+	granted, err := c.secretManagerIAM(project, name).TestPermissions(ctx, required)
+	if err != nil {
+		terr, ok := grpcstatus.FromError(err)
+		if ok && terr.Code() == grpccodes.NotFound {
+			resp.Exists = false
+			return resp, nil
+		}
+		return nil, fmt.Errorf("failed to test permissions: %w", err)
+	}
+	resp.Permissions = canIPermissions(resource, required, granted)
+	resp.Allowed = len(resp.Missing()) == 0
+	return resp, nil
+}
+
+func (c *Client) storageCanI(ctx context.Context, i *StorageCanIRequest) (*CanIResponse, error) {
+	bucket := i.Bucket
+	object := i.Object
+	action := i.Action
+
+	logger := logging.FromContext(ctx).With(
+		"bucket", bucket,
+		"object", object,
+		"action", action,
+	)
+
+	logger.DebugContext(ctx, "canI.start")
+	defer logger.DebugContext(ctx, "canI.finish")
+
+	bucketResource := "projects/_/buckets/" + bucket
+	objectResource := bucketResource + "/objects/" + object
+
+	var key string
+	var exists bool
+	attrs, err := c.storageClient.
+		Bucket(bucket).
+		Object(object).
+		Attrs(ctx)
+	var terr *googleapi.Error
+	switch {
+	case err == storage.ErrObjectNotExist:
+	case errors.As(err, &terr) && terr.Code == http.StatusForbidden:
+		// Without storage.objects.get, the secret may exist, but testing its
+		// permissions will tell. Fall back to the given key.
+		exists, key = true, i.Key
+	case err != nil:
+		return nil, fmt.Errorf("failed to read secret metadata: %w", err)
+	case attrs.Metadata[MetadataIDKey] != "1":
+		// Objects that are not berglas secrets are treated as missing.
+	default:
+		exists, key = true, attrs.Metadata[MetadataKMSKey]
+	}
+
+	resp := &CanIResponse{Exists: exists}
+	if action != CanICreate && !exists {
+		return resp, nil
+	}
+
+	var perms []*CanIPermission
+	var keyRequired []string
+	switch action {
+	case CanIAccess:
+		perms, err = c.storageTestPermissions(ctx, bucket, object, objectResource, []string{
+			"storage.objects.get",
+		})
+		keyRequired = []string{"cloudkms.cryptoKeyVersions.useToDecrypt"}
+	case CanICreate:
+		// Object permissions cannot be tested before the object exists, so
+		// check the bucket instead.
+		required := []string{"storage.objects.create"}
+		var granted []string
+		granted, err = c.storageClient.Bucket(bucket).IAM().TestPermissions(ctx, required)
+		if err != nil {
+			err = fmt.Errorf("failed to test permissions: %w", err)
+		}
+		perms = canIPermissions(bucketResource, required, granted)
+		key = i.Key
+		keyRequired = []string{"cloudkms.cryptoKeyVersions.useToEncrypt"}
+	case CanIGrant:
+		perms, err = c.storageTestPermissions(ctx, bucket, object, objectResource, []string{
+			"storage.objects.getIamPolicy",
+			"storage.objects.setIamPolicy",
+		})
+		keyRequired = []string{
+			"cloudkms.cryptoKeys.getIamPolicy",
+			"cloudkms.cryptoKeys.setIamPolicy",
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The key is unknown if the secret's metadata could not be read and no key
+	// was given, in which case the missing object permission is reported.
+	if key != "" && isCloudKMSKey(key) {
+		key = kmsKeyTrimVersion(key)
+		granted, err := c.kmsClient.ResourceIAM(key).TestPermissions(ctx, keyRequired)
+		if err != nil {
+			return nil, fmt.Errorf("failed to test kms key permissions: %w", err)
+		}
+		perms = append(perms, canIPermissions(key, keyRequired, granted)...)
+	}
+
+	resp.Permissions = perms
+	resp.Allowed = (exists != (action == CanICreate)) && key != "" && len(resp.Missing()) == 0
+	return resp, nil
+}
+
+// storageTestPermissions tests the given object permissions. Object-level IAM
+// is not available on buckets with uniform bucket-level access, so the bucket
+// is tested instead, with IAM policy permissions mapped to their bucket
+// equivalents.
+func (c *Client) storageTestPermissions(ctx context.Context, bucket, object, resource string, required []string) ([]*CanIPermission, error) {
+	granted, err := c.storageIAM(bucket, object).TestPermissions(ctx, required)
+	if err == nil {
+		return canIPermissions(resource, required, granted), nil
+	}
+	if !c.isStorageUniformAccessErr(ctx, bucket, err) {
+		return nil, fmt.Errorf("failed to test permissions: %w", err)
+	}
+
+	bucketRequired := make([]string, len(required))
+	for i, perm := range required {
+		switch perm {
+		case "storage.objects.getIamPolicy":
+			perm = "storage.buckets.getIamPolicy"
+		case "storage.objects.setIamPolicy":
+			perm = "storage.buckets.setIamPolicy"
+		}
+		bucketRequired[i] = perm
+	}
+
+	granted, err = c.storageClient.Bucket(bucket).IAM().TestPermissions(ctx, bucketRequired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to test permissions: %w", err)
+	}
+	return canIPermissions("projects/_/buckets/"+bucket, bucketRequired, granted), nil
+}
+
+// projectTestPermissions tests the given permissions on a project.
+func (c *Client) projectTestPermissions(ctx context.Context, resource string, required []string) ([]*CanIPermission, error) {
+	svc, err := c.resourceManagerService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := svc.Projects.
+		TestIamPermissions(resource, &cloudresourcemanager.TestIamPermissionsRequest{
+			Permissions: required,
+		}).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to test project permissions: %w", err)
+	}
+	return canIPermissions(resource, required, resp.Permissions), nil
+}
+
+// canIPermissions returns the required permissions on the resource, marking
+// those in granted.
+func canIPermissions(resource string, required, granted []string) []*CanIPermission {
+	result := make([]*CanIPermission, 0, len(required))
+	for _, perm := range required {
+		p := &CanIPermission{
+			Resource:   resource,
+			Permission: perm,
+		}
+		for _, g := range granted {
+			if g == perm {
+				p.Granted = true
+				break
+			}
+		}
+		result = append(result, p)
+	}
+	return result
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"testing"
+)
+
+func TestCanIResponse_Missing(t *testing.T) {
+	t.Parallel()
+
+	resp := &CanIResponse{
+		Permissions: canIPermissions("r", []string{"a", "b", "c"}, []string{"b", "d"}),
+	}
+
+	missing := resp.Missing()
+	if len(missing) != 2 {
+		t.Fatalf("expected %d to be %d", len(missing), 2)
+	}
+	for i, exp := range []string{"a", "c"} {
+		if act := missing[i].Permission; act != exp {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+		if act := missing[i].Resource; act != "r" {
+			t.Errorf("expected %q to be %q", act, "r")
+		}
+	}
+}
+
+func TestClient_CanI_secretManager(t *testing.T) {
+	testAcc(t)
+
+	ctx, client := testClient(t)
+	project, name := testProject(t), testName(t)
+
+	resp, err := client.CanI(ctx, &SecretManagerCanIRequest{
+		Project: project,
+		Name:    name,
+		Action:  CanIAccess,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Exists || resp.Allowed {
+		t.Errorf("expected missing secret to not be allowed")
+	}
+
+	if _, err := client.Create(ctx, &SecretManagerCreateRequest{
+		Project:   project,
+		Name:      name,
+		Plaintext: []byte("test"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer testSecretManagerCleanup(t, project, name)
+
+	for _, action := range []CanIAction{CanIAccess, CanIGrant} {
+		resp, err := client.CanI(ctx, &SecretManagerCanIRequest{
+			Project: project,
+			Name:    name,
+			Action:  action,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.Allowed {
+			t.Errorf("expected %s to be allowed, missing %v", action, resp.Missing())
+		}
+	}
+}
+
+func TestClient_CanI_storage(t *testing.T) {
+	testAcc(t)
+
+	ctx, client := testClient(t)
+	bucket, object, key := testBucket(t), testName(t), testKey(t)
+
+	resp, err := client.CanI(ctx, &StorageCanIRequest{
+		Bucket: bucket,
+		Object: object,
+		Key:    key,
+		Action: CanICreate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Allowed {
+		t.Errorf("expected create to be allowed, missing %v", resp.Missing())
+	}
+
+	if _, err := client.Create(ctx, &StorageCreateRequest{
+		Bucket:    bucket,
+		Object:    object,
+		Key:       key,
+		Plaintext: []byte("test"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer testStorageCleanup(t, bucket, object)
+
+	for _, action := range []CanIAction{CanIAccess, CanIGrant} {
+		resp, err := client.CanI(ctx, &StorageCanIRequest{
+			Bucket: bucket,
+			Object: object,
+			Action: action,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.Allowed {
+			t.Errorf("expected %s to be allowed, missing %v", action, resp.Missing())
+		}
+	}
+}
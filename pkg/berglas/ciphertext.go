@@ -0,0 +1,116 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// Ciphertext is the raw, still-encrypted form of a Cloud Storage secret as it
+// is stored in the bucket.
+type Ciphertext struct {
+	// Secret is the secret's metadata. Plaintext is always empty.
+	Secret *Secret
+
+	// Blob is the stored envelope exactly as it appears in Cloud Storage. It
+	// can be passed to DecryptOffline.
+	Blob []byte
+
+	// Algorithm is the local envelope encryption algorithm.
+	Algorithm EnvelopeAlgorithm
+
+	// EncryptedDEK is the data encryption key, wrapped by Secret.KMSKey.
+	EncryptedDEK []byte
+
+	// AdditionalAuthenticatedData is the additional authenticated data that
+	// must be supplied when unwrapping EncryptedDEK.
+	AdditionalAuthenticatedData []byte
+}
+
+// ReadCiphertext is a top-level package function for reading the raw
+// ciphertext of a secret. For large volumes of secrets, please create a client
+// instead.
+func ReadCiphertext(ctx context.Context, i *StorageReadRequest) (*Ciphertext, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.ReadCiphertext(ctx, i)
+}
+
+// ReadCiphertext reads the stored envelope of a Cloud Storage secret without
+// calling Cloud KMS. Only read access to the object is required. Secret
+// Manager secrets are encrypted server-side and have no ciphertext to return.
+func (c *Client) ReadCiphertext(ctx context.Context, i *StorageReadRequest) (*Ciphertext, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	generation := i.Generation
+	if generation == 0 {
+		generation = -1
+	}
+
+	attrs, data, err := c.storageReadBlob(ctx, i.Bucket, i.Object, generation)
+	if err != nil {
+		return nil, err
+	}
+
+	alg, encDEK, _, err := envelopeDecode(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	aad := storageAAD(storageAADBound(attrs.Metadata), i.Bucket, i.Object, c.aadContext,
+		storageWriteCounter(attrs.Metadata))
+
+	return &Ciphertext{
+		Secret:                      secretFromAttrs(i.Bucket, attrs, nil),
+		Blob:                        data,
+		Algorithm:                   alg,
+		EncryptedDEK:                encDEK,
+		AdditionalAuthenticatedData: aad,
+	}, nil
+}
+
+// DecryptOffline decrypts a stored envelope (see Ciphertext.Blob) with an
+// already-unwrapped data encryption key. It makes no network calls and is
+// intended for break-glass recovery when Cloud KMS is unavailable but the
+// plaintext DEK has been escrowed. A wrapped DEK cannot be used here; it must
+// first be unwrapped by whatever holds the wrapping key.
+func DecryptOffline(blob, dek []byte) ([]byte, error) {
+	alg, encDEK, ciphertext, err := envelopeDecode(string(blob))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dek) != 32 {
+		if bytes.Equal(dek, encDEK) {
+			return nil, fmt.Errorf("dek is still wrapped by kms and must be unwrapped first")
+		}
+		return nil, fmt.Errorf("dek must be 32 bytes, got %d", len(dek))
+	}
+
+	plaintext, err := envelopeDecrypt(alg, dek, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt envelope: %w", err)
+	}
+	return plaintext, nil
+}
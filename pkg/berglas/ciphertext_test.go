@@ -0,0 +1,130 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestDecryptOffline(t *testing.T) {
+	t.Parallel()
+
+	plaintext := []byte("my secret value")
+
+	dek, ciphertext, err := envelopeEncrypt(EnvelopeAlgorithmXChaCha20Poly1305, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped := []byte("not-really-a-kms-ciphertext")
+	blob := []byte(envelopeEncode(EnvelopeAlgorithmXChaCha20Poly1305, wrapped, ciphertext))
+
+	cases := []struct {
+		name string
+		blob []byte
+		dek  []byte
+		err  bool
+	}{
+		{
+			name: "unwrapped_dek",
+			blob: blob,
+			dek:  dek,
+		},
+		{
+			name: "wrapped_dek",
+			blob: blob,
+			dek:  wrapped,
+			err:  true,
+		},
+		{
+			name: "wrong_dek",
+			blob: blob,
+			dek:  make([]byte, 32),
+			err:  true,
+		},
+		{
+			name: "malformed_blob",
+			blob: []byte("nope"),
+			dek:  dek,
+			err:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			act, err := DecryptOffline(tc.blob, tc.dek)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %t, got %v", tc.err, err)
+			}
+			if !tc.err && !bytes.Equal(act, plaintext) {
+				t.Errorf("expected %q to be %q", act, plaintext)
+			}
+		})
+	}
+}
+
+func TestClient_ReadCiphertext(t *testing.T) {
+	testAcc(t)
+
+	ctx, client := testClient(t)
+
+	bucket, object, key := testBucket(t), testName(t), testKey(t)
+	defer testStorageCleanup(t, bucket, object)
+
+	plaintext := []byte("my secret value")
+	if _, err := client.Create(ctx, &StorageCreateRequest{
+		Bucket:    bucket,
+		Object:    object,
+		Key:       key,
+		Plaintext: plaintext,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ct, err := client.ReadCiphertext(ctx, &StorageReadRequest{
+		Bucket: bucket,
+		Object: object,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct.Secret.Plaintext != nil {
+		t.Errorf("expected plaintext to be empty")
+	}
+
+	// Simulate an escrowed DEK by unwrapping it out of band.
+	resp, err := client.kmsClient.Decrypt(ctx, &kmspb.DecryptRequest{
+		Name:                        key,
+		Ciphertext:                  ct.EncryptedDEK,
+		AdditionalAuthenticatedData: ct.AdditionalAuthenticatedData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	act, err := DecryptOffline(ct.Blob, resp.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(act, plaintext) {
+		t.Errorf("expected %q to be %q", act, plaintext)
+	}
+}
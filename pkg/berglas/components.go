@@ -0,0 +1,167 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"encoding/json"
+)
+
+// Names of reference types in ReferenceComponents.
+const (
+	ReferenceTypeNameSecretManager = "secretmanager"
+	ReferenceTypeNameStorage       = "storage"
+	ReferenceTypeNameS3            = "s3"
+)
+
+// ReferenceComponents are the parsed parts of a reference in a stable,
+// machine-readable form, so external tools can validate and display references
+// without re-implementing the parser. Fields that do not apply to the type of
+// reference are empty. ReferenceComponentsSchema describes the JSON encoding.
+type ReferenceComponents struct {
+	// Type is the type of reference: "secretmanager", "storage", or "s3".
+	Type string `json:"type"`
+
+	// Reference is the canonical form of the reference, without query
+	// parameters.
+	Reference string `json:"reference"`
+
+	// Project, Name, and Version are set on Secret Manager references.
+	Project string `json:"project,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Bucket and Object are set on Cloud Storage and S3 references. Generation
+	// and Directory are only set on Cloud Storage references.
+	Bucket     string `json:"bucket,omitempty"`
+	Object     string `json:"object,omitempty"`
+	Generation int64  `json:"generation,omitempty"`
+	Directory  bool   `json:"directory,omitempty"`
+
+	// Destination is the "destination" query parameter as given.
+	Destination string `json:"destination,omitempty"`
+
+	// QuotaProject is the project charged for quota and billing.
+	QuotaProject string `json:"quota_project,omitempty"`
+
+	// Encoding, JSONKey, and Trim are the transforms applied by Resolve.
+	Encoding string `json:"encoding,omitempty"`
+	JSONKey  string `json:"json_key,omitempty"`
+	Trim     bool   `json:"trim,omitempty"`
+}
+
+// Components returns the parsed parts of the reference.
+func (r *Reference) Components() *ReferenceComponents {
+	c := &ReferenceComponents{
+		Reference:    r.String(),
+		Project:      r.project,
+		Name:         r.name,
+		Version:      r.version,
+		Bucket:       r.bucket,
+		Object:       r.object,
+		Generation:   r.generation,
+		Directory:    r.directory,
+		Destination:  r.destination,
+		QuotaProject: r.quotaProject,
+		Encoding:     r.encoding,
+		JSONKey:      r.jsonKey,
+		Trim:         r.trim,
+	}
+
+	switch r.typ {
+	case ReferenceTypeSecretManager:
+		c.Type = ReferenceTypeNameSecretManager
+	case ReferenceTypeStorage:
+		c.Type = ReferenceTypeNameStorage
+	case ReferenceTypeS3:
+		c.Type = ReferenceTypeNameS3
+	}
+	return c
+}
+
+// MarshalJSON encodes the reference as its ReferenceComponents.
+func (r *Reference) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Components())
+}
+
+// ReferenceComponentsSchema is the JSON Schema for the JSON encoding of
+// ReferenceComponents.
+const ReferenceComponentsSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$id": "https://github.com/GoogleCloudPlatform/berglas/reference.schema.json",
+  "title": "Berglas reference",
+  "description": "The parsed parts of a berglas reference, as printed by berglas parse-ref.",
+  "type": "object",
+  "required": ["type", "reference"],
+  "additionalProperties": false,
+  "properties": {
+    "type": {
+      "description": "The type of reference.",
+      "enum": ["secretmanager", "storage", "s3"]
+    },
+    "reference": {
+      "description": "The canonical form of the reference, without query parameters.",
+      "type": "string"
+    },
+    "project": {
+      "description": "The Google Cloud project of a Secret Manager secret.",
+      "type": "string"
+    },
+    "name": {
+      "description": "The name of a Secret Manager secret.",
+      "type": "string"
+    },
+    "version": {
+      "description": "The version of a Secret Manager secret, such as \"3\" or \"latest\".",
+      "type": "string"
+    },
+    "bucket": {
+      "description": "The bucket of a Cloud Storage or S3 secret.",
+      "type": "string"
+    },
+    "object": {
+      "description": "The object of a Cloud Storage or S3 secret, or the prefix of a directory reference.",
+      "type": "string"
+    },
+    "generation": {
+      "description": "The generation of a Cloud Storage secret.",
+      "type": "integer"
+    },
+    "directory": {
+      "description": "Whether the reference refers to every Cloud Storage secret under the object prefix.",
+      "type": "boolean"
+    },
+    "destination": {
+      "description": "The destination query parameter: a file path, or \"tempfile\".",
+      "type": "string"
+    },
+    "quota_project": {
+      "description": "The project charged for quota and billing.",
+      "type": "string"
+    },
+    "encoding": {
+      "description": "The encoding the secret is decoded from when resolved.",
+      "enum": ["base64", "base64url", "hex"]
+    },
+    "json_key": {
+      "description": "The top-level key extracted from a JSON secret when resolved.",
+      "type": "string"
+    },
+    "trim": {
+      "description": "Whether whitespace is trimmed from the secret when resolved.",
+      "type": "boolean"
+    }
+  }
+}
+`
@@ -0,0 +1,113 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestReference_Components(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		s    string
+		exp  string
+	}{
+		{
+			"sm",
+			"sm://my-project/api-key?destination=/var/key&trim=true#3",
+			`{"type":"secretmanager","reference":"sm://my-project/api-key#3","project":"my-project","name":"api-key","version":"3","destination":"/var/key","trim":true}`,
+		},
+		{
+			"storage",
+			"berglas://my-bucket/api-key?quotaProject=billing&jsonkey=password&encoding=base64#1563925173373377",
+			`{"type":"storage","reference":"berglas://my-bucket/api-key#1563925173373377","bucket":"my-bucket","object":"api-key","generation":1563925173373377,"quota_project":"billing","encoding":"base64","json_key":"password"}`,
+		},
+		{
+			"storage_directory",
+			"gs://my-bucket/certs/*?destination=/etc/certs",
+			`{"type":"storage","reference":"berglas://my-bucket/certs/*","bucket":"my-bucket","object":"certs/","directory":true,"destination":"/etc/certs"}`,
+		},
+		{
+			"s3",
+			"s3://my-bucket/api-key",
+			`{"type":"s3","reference":"s3://my-bucket/api-key","bucket":"my-bucket","object":"api-key"}`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ref, err := ParseReference(tc.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := json.Marshal(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if act := string(b); act != tc.exp {
+				t.Errorf("expected %s to be %s", act, tc.exp)
+			}
+		})
+	}
+}
+
+func TestReferenceComponentsSchema(t *testing.T) {
+	t.Parallel()
+
+	var schema struct {
+		Required   []string                   `json:"required"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal([]byte(ReferenceComponentsSchema), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	var exp, required []string
+	typ := reflect.TypeOf(ReferenceComponents{})
+	for i := 0; i < typ.NumField(); i++ {
+		name, opts, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		exp = append(exp, name)
+		if opts != "omitempty" {
+			required = append(required, name)
+		}
+	}
+
+	act := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		act = append(act, name)
+	}
+	sort.Strings(act)
+	sort.Strings(exp)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("expected schema properties %q to be %q", act, exp)
+	}
+
+	sort.Strings(required)
+	sort.Strings(schema.Required)
+	if !reflect.DeepEqual(schema.Required, required) {
+		t.Errorf("expected schema required %q to be %q", schema.Required, required)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/googleapi"
+)
+
+// CopyRequest is used as input to copy a Cloud Storage secret to another
+// bucket or object.
+//
+// The data encryption key of a secret is encrypted with additional
+// authenticated data that includes the object name (and, for secrets written
+// WithBoundAAD, the bucket name). A raw copy of the object, such as one made
+// with gsutil, therefore cannot be decrypted. Copy refuses to make such a copy
+// unless Rebind is set.
+type CopyRequest struct {
+	// SourceBucket and SourceObject identify the secret to copy.
+	SourceBucket string
+	SourceObject string
+
+	// SourceGeneration of the object to copy. The latest generation is used if
+	// unset.
+	SourceGeneration int64
+
+	// Bucket and Object are where to copy the secret. The copy fails if a secret
+	// already exists there.
+	Bucket string
+	Object string
+
+	// Rebind decrypts the secret with the additional authenticated data of the
+	// source and re-encrypts it, under a new data encryption key and the same
+	// Cloud KMS key, bound to the destination. Without it, the stored ciphertext
+	// is copied unchanged, which is only possible when the additional
+	// authenticated data does not change (for example, a secret written without
+	// WithBoundAAD copied to the same object name in another bucket).
+	Rebind bool
+}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *CopyRequest) Validate() error {
+	var v validator
+	v.require("SourceBucket", r.SourceBucket, "missing source bucket name")
+	v.require("SourceObject", r.SourceObject, "missing source object name")
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	if r.Bucket == r.SourceBucket && r.Object == r.SourceObject {
+		v.addf("Object", "source and destination must differ")
+	}
+	return v.err()
+}
+
+// Copy is a top-level package function for copying a secret. For large volumes
+// of secrets, please create a client instead.
+func Copy(ctx context.Context, i *CopyRequest) (*Secret, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Copy(ctx, i)
+}
+
+// Copy copies a Cloud Storage secret to another bucket or object. The returned
+// secret does not include the plaintext.
+func (c *Client) Copy(ctx context.Context, i *CopyRequest) (*Secret, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkNaming(i.Object); err != nil {
+		return nil, storageError(err, i.Bucket, i.Object)
+	}
+
+	generation := i.SourceGeneration
+	if generation == 0 {
+		generation = -1
+	}
+
+	logger := logging.FromContext(ctx).With(
+		"source_bucket", i.SourceBucket,
+		"source_object", i.SourceObject,
+		"source_generation", generation,
+		"bucket", i.Bucket,
+		"object", i.Object,
+		"rebind", i.Rebind,
+	)
+
+	logger.DebugContext(ctx, "copy.start")
+	defer logger.DebugContext(ctx, "copy.finish")
+
+	attrs, data, err := c.storageReadBlob(ctx, i.SourceBucket, i.SourceObject, generation)
+	if err != nil {
+		return nil, err
+	}
+
+	bound := storageAADBound(attrs.Metadata)
+	counter := storageWriteCounter(attrs.Metadata)
+	sourceAAD := storageAAD(bound, i.SourceBucket, i.SourceObject, c.aadContext, counter)
+
+	if !i.Rebind {
+		if !bytes.Equal(sourceAAD, storageAAD(bound, i.Bucket, i.Object, c.aadContext, counter)) {
+			return nil, storageError(ErrCopyRequiresRebind, i.Bucket, i.Object)
+		}
+		return c.storageCopyRaw(ctx, i, attrs)
+	}
+
+	key := attrs.Metadata[MetadataKMSKey]
+
+	alg, encDEK, ciphertext, err := envelopeDecode(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	logger.DebugContext(ctx, "decrypting dek using kms", "key", key)
+
+	dek, err := c.unwrapDEK(ctx, key, encDEK, sourceAAD)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt dek: %w", err)
+	}
+	defer c.wipe(dek)
+
+	plaintext, err := envelopeDecrypt(alg, dek, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt envelope: %w", err)
+	}
+	defer c.wipe(plaintext)
+
+	next, err := c.storageNextWriteCounter(ctx, i.Bucket, i.Object, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy secret: %w", err)
+	}
+
+	source := secretFromAttrs(i.SourceBucket, attrs, nil)
+	secret, err := c.encryptAndWrite(ctx, i.Bucket, i.Object, key, writeOptions{
+		protectionLevel: source.ProtectionLevel,
+		expiresAt:       source.ExpiresAt,
+		policy:          source.Policy,
+		contentType:     source.ContentType,
+		writeCounter:    next,
+		boundAAD:        bound || c.boundAAD,
+	}, plaintext, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy secret: %w", err)
+	}
+	secret.Plaintext = nil
+	return secret, nil
+}
+
+// storageCopyRaw copies the stored object, including its metadata, without
+// decrypting it.
+func (c *Client) storageCopyRaw(ctx context.Context, i *CopyRequest, source *storage.ObjectAttrs) (*Secret, error) {
+	logging.FromContext(ctx).DebugContext(ctx, "copying object without rebinding")
+
+	src, err := c.storageObjectKey(c.storageBucket(ctx, i.SourceBucket).
+		Object(i.SourceObject).
+		Generation(source.Generation), source)
+	if err != nil {
+		return nil, storageError(err, i.SourceBucket, i.SourceObject)
+	}
+
+	dst := c.storageBucket(ctx, i.Bucket).Object(i.Object).
+		If(storage.Conditions{DoesNotExist: true})
+	if c.storageKey != nil {
+		dst = dst.Key(c.storageKey)
+	}
+
+	attrs, err := dst.CopierFrom(src).Run(ctx)
+	if err != nil {
+		var terr *googleapi.Error
+		if errors.As(err, &terr) && terr.Code == http.StatusPreconditionFailed {
+			return nil, storageError(ErrSecretAlreadyExists, i.Bucket, i.Object)
+		}
+		return nil, fmt.Errorf("failed to copy secret: %w", err)
+	}
+	return secretFromAttrs(i.Bucket, attrs, nil), nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClient_Copy_storage(t *testing.T) {
+	testAcc(t)
+
+	ctx, client := testClient(t)
+
+	bucket, object, key := testBucket(t), testName(t), testKey(t)
+	defer testStorageCleanup(t, bucket, object)
+
+	plaintext := []byte("my secret value")
+	if _, err := client.Create(ctx, &StorageCreateRequest{
+		Bucket:    bucket,
+		Object:    object,
+		Key:       key,
+		Plaintext: plaintext,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	copied := testName(t)
+	defer testStorageCleanup(t, bucket, copied)
+
+	// The object name is part of the additional authenticated data, so a raw
+	// copy to another name must be refused.
+	if _, err := client.Copy(ctx, &CopyRequest{
+		SourceBucket: bucket,
+		SourceObject: object,
+		Bucket:       bucket,
+		Object:       copied,
+	}); !errors.Is(err, ErrCopyRequiresRebind) {
+		t.Fatalf("expected %v to be %v", err, ErrCopyRequiresRebind)
+	}
+
+	if _, err := client.Copy(ctx, &CopyRequest{
+		SourceBucket: bucket,
+		SourceObject: object,
+		Bucket:       bucket,
+		Object:       copied,
+		Rebind:       true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	act, err := client.Access(ctx, &StorageAccessRequest{
+		Bucket: bucket,
+		Object: copied,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(act) != string(plaintext) {
+		t.Errorf("expected %q to be %q", act, plaintext)
+	}
+
+	// Copying over an existing secret must fail.
+	if _, err := client.Copy(ctx, &CopyRequest{
+		SourceBucket: bucket,
+		SourceObject: object,
+		Bucket:       bucket,
+		Object:       copied,
+		Rebind:       true,
+	}); !errors.Is(err, ErrSecretAlreadyExists) {
+		t.Errorf("expected %v to be %v", err, ErrSecretAlreadyExists)
+	}
+}
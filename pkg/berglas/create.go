@@ -19,15 +19,19 @@ import (
 	"fmt"
 	"path"
 	"sort"
+	"strings"
+	"time"
 
 	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"github.com/sethvargo/go-retry"
 	grpccodes "google.golang.org/grpc/codes"
 	grpcstatus "google.golang.org/grpc/status"
 )
 
 type createRequest interface {
 	isCreateRequest()
+	Validate() error
 }
 
 // StorageCreateRequest is used as input to create a secret using Cloud Storage
@@ -44,10 +48,45 @@ type StorageCreateRequest struct {
 
 	// Plaintext is the plaintext secret to encrypt and store.
 	Plaintext []byte
+
+	// RequireProtectionLevel, if set, is the Cloud KMS protection level (e.g.
+	// "HSM" or "EXTERNAL") the key must have. The secret is not created if the
+	// key has a different protection level or cannot be inspected.
+	RequireProtectionLevel string
+
+	// ExpiresAt, if set, is the time after which the secret is considered
+	// expired and may be deleted by Prune.
+	ExpiresAt time.Time
+
+	// Policy, if set, restricts how Resolve may place the secret.
+	Policy Policy
+
+	// ContentType, if set, is the media type of the plaintext, such as
+	// "application/json". It is stored as the MetadataContentTypeKey metadata.
+	ContentType string
 }
 
 func (r *StorageCreateRequest) isCreateRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageCreateRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	v.require("Key", r.Key, "missing key name")
+	v.kmsKey("Key", r.Key)
+	v.requireBytes("Plaintext", r.Plaintext, "missing plaintext")
+	if r.RequireProtectionLevel != "" && !isProtectionLevel(r.RequireProtectionLevel) {
+		v.addf("RequireProtectionLevel", "invalid protection level %q: must be one of %s",
+			r.RequireProtectionLevel, strings.Join(protectionLevelNames(), ", "))
+	}
+	v.expiresAt("ExpiresAt", r.ExpiresAt)
+	v.policy("Policy", r.Policy)
+	v.contentType("ContentType", r.ContentType)
+	return v.err()
+}
+
 // CreateRequest is an alias for StorageCreateRequest for
 // backwards-compatibility. New clients should use StorageCreateRequest.
 type CreateRequest = StorageCreateRequest
@@ -68,14 +107,45 @@ type SecretManagerCreateRequest struct {
 	// the locations to the replicate data at. This defaults to the automatic
 	// replication policy when not specified. An empty array is not allowed.
 	Locations []string
+
+	// WaitForConsistency, if set, waits until the new version can be accessed
+	// as "latest" before returning, retrying with exponential backoff for up to
+	// ConsistencyTimeout. Secret Manager is eventually consistent, so an
+	// immediate access after creation may otherwise return not found.
+	WaitForConsistency bool
+
+	// Policy, if set, restricts how Resolve may place the secret. It is stored
+	// as the MetadataPolicyKey label of the secret.
+	Policy Policy
+
+	// ContentType, if set, is the media type of the plaintext, such as
+	// "application/json". It is stored as the MetadataContentTypeKey annotation
+	// of the secret.
+	ContentType string
 }
 
+// ConsistencyTimeout is the maximum time to wait for a new Secret Manager
+// version to become accessible when WaitForConsistency is set.
+const ConsistencyTimeout = 30 * time.Second
+
 func (r *SecretManagerCreateRequest) isCreateRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerCreateRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	v.require("Name", r.Name, "missing secret name")
+	v.requireBytes("Plaintext", r.Plaintext, "missing plaintext")
+	v.policy("Policy", r.Policy)
+	v.contentType("ContentType", r.ContentType)
+	return v.err()
+}
+
 // Create is a top-level package function for creating a secret. For large
 // volumes of secrets, please create a client instead.
 func Create(ctx context.Context, i createRequest) (*Secret, error) {
-	client, err := New(ctx)
+	client, err := DefaultClient(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -84,7 +154,9 @@ func Create(ctx context.Context, i createRequest) (*Secret, error) {
 
 // Create creates a secret. When given a SecretManagerCreateRequest, this
 // creates a secret using Secret Manager. When given a StorageCreateRequest,
-// this creates a secret stored in Cloud Storage encrypted with Cloud KMS.
+// this creates a secret stored in Cloud Storage encrypted with Cloud KMS. When
+// given a StorageS3CreateRequest, this creates a secret stored in the client's
+// S3-compatible store.
 //
 // If the secret already exists, an error is returned. Use Update to update an
 // existing secret.
@@ -93,11 +165,39 @@ func (c *Client) Create(ctx context.Context, i createRequest) (*Secret, error) {
 		return nil, fmt.Errorf("missing request")
 	}
 
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
 	switch t := i.(type) {
 	case *SecretManagerCreateRequest:
+		if err := c.checkNaming(t.Name); err != nil {
+			return nil, secretManagerError(err, t.Project, t.Name)
+		}
+		if err := checkPlaintextSize(ctx, t.Plaintext, SecretManagerMaxPlaintextSize); err != nil {
+			return nil, err
+		}
 		return c.secretManagerCreate(ctx, t)
 	case *StorageCreateRequest:
+		if err := c.checkNaming(t.Object); err != nil {
+			return nil, storageError(err, t.Bucket, t.Object)
+		}
+		if err := checkPlaintextSize(ctx, t.Plaintext, c.storageMaxPlaintextSize); err != nil {
+			return nil, err
+		}
 		return c.storageCreate(ctx, t)
+	case *StorageS3CreateRequest:
+		if err := c.checkNaming(t.Object); err != nil {
+			return nil, s3Error(err, t.Bucket, t.Object)
+		}
+		if err := checkPlaintextSize(ctx, t.Plaintext, c.storageMaxPlaintextSize); err != nil {
+			return nil, err
+		}
+		return c.s3Create(ctx, t)
 	default:
 		return nil, fmt.Errorf("unknown create type %T", t)
 	}
@@ -105,43 +205,11 @@ func (c *Client) Create(ctx context.Context, i createRequest) (*Secret, error) {
 
 func (c *Client) secretManagerCreate(ctx context.Context, i *SecretManagerCreateRequest) (*Secret, error) {
 	project := i.Project
-	if project == "" {
-		return nil, fmt.Errorf("missing project")
-	}
-
 	name := i.Name
-	if name == "" {
-		return nil, fmt.Errorf("missing secret name")
-	}
-
 	plaintext := i.Plaintext
-	if plaintext == nil {
-		return nil, fmt.Errorf("missing plaintext")
-	}
-
-	var replication *secretspb.Replication
-	if len(i.Locations) == 0 {
-		replication = &secretspb.Replication{
-			Replication: &secretspb.Replication_Automatic_{
-				Automatic: &secretspb.Replication_Automatic{},
-			},
-		}
-	} else {
-		sort.Strings(i.Locations)
-		replicas := make([]*secretspb.Replication_UserManaged_Replica, len(i.Locations))
 
-		for n, loc := range i.Locations {
-			replicas[n] = &secretspb.Replication_UserManaged_Replica{Location: loc}
-		}
-
-		replication = &secretspb.Replication{
-			Replication: &secretspb.Replication_UserManaged_{
-				UserManaged: &secretspb.Replication_UserManaged{
-					Replicas: replicas,
-				},
-			},
-		}
-	}
+	sort.Strings(i.Locations)
+	replication := secretManagerReplication(i.Locations)
 
 	logger := logging.FromContext(ctx).With(
 		"project", project,
@@ -153,16 +221,29 @@ func (c *Client) secretManagerCreate(ctx context.Context, i *SecretManagerCreate
 
 	logger.DebugContext(ctx, "creating secret")
 
+	var labels map[string]string
+	if i.Policy != PolicyNone {
+		labels = map[string]string{MetadataPolicyKey: string(i.Policy)}
+	}
+	var annotations map[string]string
+	if i.ContentType != "" {
+		annotations = map[string]string{MetadataContentTypeKey: i.ContentType}
+	}
+
 	secretResp, err := c.secretManagerClient.CreateSecret(ctx, &secretspb.CreateSecretRequest{
 		Parent:   fmt.Sprintf("projects/%s", project),
 		SecretId: name,
-		Secret:   &secretspb.Secret{Replication: replication},
+		Secret: &secretspb.Secret{
+			Replication: replication,
+			Labels:      labels,
+			Annotations: annotations,
+		},
 	})
 
 	if err != nil {
 		terr, ok := grpcstatus.FromError(err)
 		if ok && terr.Code() == grpccodes.AlreadyExists {
-			return nil, errSecretAlreadyExists
+			return nil, secretManagerError(ErrSecretAlreadyExists, project, name)
 		}
 		return nil, fmt.Errorf("failed to create secret: %w", err)
 	}
@@ -179,36 +260,29 @@ func (c *Client) secretManagerCreate(ctx context.Context, i *SecretManagerCreate
 		return nil, fmt.Errorf("failed to create secret version: %w", err)
 	}
 
+	if i.WaitForConsistency {
+		if err := c.secretManagerWaitForVersion(ctx, secretResp.Name, versionResp.Name); err != nil {
+			return nil, err
+		}
+	}
+
 	return &Secret{
-		Parent:    project,
-		Name:      name,
-		Version:   path.Base(versionResp.Name),
-		Plaintext: plaintext,
-		UpdatedAt: timestampToTime(versionResp.CreateTime),
-		Locations: i.Locations,
+		Parent:      project,
+		Name:        name,
+		Version:     path.Base(versionResp.Name),
+		Plaintext:   plaintext,
+		UpdatedAt:   timestampToTime(versionResp.CreateTime),
+		Locations:   i.Locations,
+		Policy:      i.Policy,
+		ContentType: i.ContentType,
 	}, nil
 }
 
 func (c *Client) storageCreate(ctx context.Context, i *StorageCreateRequest) (*Secret, error) {
 	bucket := i.Bucket
-	if bucket == "" {
-		return nil, fmt.Errorf("missing bucket name")
-	}
-
 	object := i.Object
-	if object == "" {
-		return nil, fmt.Errorf("missing object name")
-	}
-
 	key := i.Key
-	if key == "" {
-		return nil, fmt.Errorf("missing key name")
-	}
-
 	plaintext := i.Plaintext
-	if plaintext == nil {
-		return nil, fmt.Errorf("missing plaintext")
-	}
 
 	logger := logging.FromContext(ctx).With(
 		"bucket", bucket,
@@ -219,9 +293,109 @@ func (c *Client) storageCreate(ctx context.Context, i *StorageCreateRequest) (*S
 	logger.DebugContext(ctx, "create.start")
 	defer logger.DebugContext(ctx, "create.finish")
 
-	secret, err := c.encryptAndWrite(ctx, bucket, object, key, plaintext, 0, 0)
+	level, err := c.kmsKeyProtectionLevel(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check kms key: %w", err)
+	}
+	if err := checkProtectionLevel(key, level, i.RequireProtectionLevel); err != nil {
+		return nil, err
+	}
+
+	counter, err := c.storageNextWriteCounter(ctx, bucket, object, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret: %w", err)
+	}
+
+	secret, err := c.encryptAndWrite(ctx, bucket, object, key, writeOptions{
+		protectionLevel: level,
+		expiresAt:       i.ExpiresAt,
+		policy:          i.Policy,
+		contentType:     i.ContentType,
+		writeCounter:    counter,
+		boundAAD:        c.boundAAD,
+	}, plaintext, 0, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create secret: %w", err)
 	}
 	return secret, nil
 }
+
+// secretManagerWaitForVersion waits until accessing the latest version of the
+// secret returns the given version.
+func (c *Client) secretManagerWaitForVersion(ctx context.Context, secret, version string) error {
+	logger := logging.FromContext(ctx).With(
+		"secret", secret,
+		"version", version,
+	)
+
+	logger.DebugContext(ctx, "waiting for version to be accessible")
+
+	b := retry.WithMaxDuration(ConsistencyTimeout,
+		retry.WithCappedDuration(2*time.Second, retry.NewExponential(100*time.Millisecond)))
+
+	if err := retry.Do(ctx, b, func(ctx context.Context) error {
+		resp, err := c.secretManagerClient.AccessSecretVersion(ctx, &secretspb.AccessSecretVersionRequest{
+			Name: secret + "/versions/latest",
+		})
+		if err != nil {
+			if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.NotFound {
+				logger.DebugContext(ctx, "version not yet accessible", "error", err)
+				return retry.RetryableError(err)
+			}
+			return err
+		}
+		c.wipe(resp.GetPayload().GetData())
+
+		if path.Base(resp.Name) != path.Base(version) {
+			logger.DebugContext(ctx, "latest is not yet the new version", "latest", resp.Name)
+			return retry.RetryableError(fmt.Errorf("latest is version %s", path.Base(resp.Name)))
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to wait for secret version to be accessible: %w", err)
+	}
+	return nil
+}
+
+// secretManagerReplication builds the replication policy for the given
+// locations. If no locations are given, the automatic replication policy is
+// returned.
+func secretManagerReplication(locations []string) *secretspb.Replication {
+	if len(locations) == 0 {
+		return &secretspb.Replication{
+			Replication: &secretspb.Replication_Automatic_{
+				Automatic: &secretspb.Replication_Automatic{},
+			},
+		}
+	}
+
+	replicas := make([]*secretspb.Replication_UserManaged_Replica, len(locations))
+	for n, loc := range locations {
+		replicas[n] = &secretspb.Replication_UserManaged_Replica{Location: loc}
+	}
+
+	return &secretspb.Replication{
+		Replication: &secretspb.Replication_UserManaged_{
+			UserManaged: &secretspb.Replication_UserManaged{
+				Replicas: replicas,
+			},
+		},
+	}
+}
+
+// secretManagerLocations returns the sorted list of user-managed replica
+// locations for the given replication policy, or nil if the policy is
+// automatic.
+func secretManagerLocations(replication *secretspb.Replication) []string {
+	userManaged := replication.GetUserManaged()
+	if userManaged == nil {
+		return nil
+	}
+
+	locations := make([]string, len(userManaged.Replicas))
+	for i, r := range userManaged.Replicas {
+		locations[i] = r.Location
+	}
+	sort.Strings(locations)
+	return locations
+}
@@ -55,6 +55,35 @@ func TestClient_Create_secretManager(t *testing.T) {
 		}
 	})
 
+	t.Run("wait-for-consistency", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name := testProject(t), testName(t)
+		plaintext := []byte("my secret value")
+
+		if _, err := client.Create(ctx, &SecretManagerCreateRequest{
+			Project:            project,
+			Name:               name,
+			Plaintext:          plaintext,
+			WaitForConsistency: true,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer testSecretManagerCleanup(t, project, name)
+
+		accessResp, err := client.Access(ctx, &SecretManagerAccessRequest{
+			Project: project,
+			Name:    name,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act, exp := string(accessResp), string(plaintext); act != exp {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+	})
+
 	t.Run("custom-locations", func(t *testing.T) {
 		t.Parallel()
 
@@ -110,7 +139,7 @@ func TestClient_Create_secretManager(t *testing.T) {
 			Name:      name,
 			Plaintext: plaintext,
 		}); !IsSecretAlreadyExistsErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretAlreadyExists)
+			t.Errorf("expected %q to be %q", err, ErrSecretAlreadyExists)
 		}
 	})
 }
@@ -173,7 +202,7 @@ func TestClient_Create_storage(t *testing.T) {
 			Key:       key,
 			Plaintext: plaintext,
 		}); !IsSecretAlreadyExistsErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretAlreadyExists)
+			t.Errorf("expected %q to be %q", err, ErrSecretAlreadyExists)
 		}
 	})
 }
@@ -0,0 +1,75 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// StorageEncryptionKeySize is the size in bytes of a customer-supplied
+// encryption key for Cloud Storage objects (AES-256).
+const StorageEncryptionKeySize = 32
+
+// WithStorageEncryptionKey returns a client option that encrypts new Cloud
+// Storage secret objects with the given customer-supplied encryption key
+// (CSEK), in addition to the berglas envelope encryption. Cloud Storage then
+// refuses to serve the object's contents without the key. The key must be
+// StorageEncryptionKeySize bytes and is never logged.
+//
+// Secrets written with a customer-supplied key can only be read, updated, or
+// copied by clients with the same key. Secrets written without one remain
+// readable.
+func WithStorageEncryptionKey(key []byte) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.storageKey = bytes.Clone(key)
+	}}
+}
+
+// checkStorageEncryptionKey returns an error if the client's customer-supplied
+// encryption key, if any, is not the right size.
+func (c *Client) checkStorageEncryptionKey() error {
+	if c.storageKey != nil && len(c.storageKey) != StorageEncryptionKeySize {
+		return fmt.Errorf("invalid storage encryption key: must be %d bytes, got %d",
+			StorageEncryptionKeySize, len(c.storageKey))
+	}
+	return nil
+}
+
+// storageObjectKey returns the handle with the client's customer-supplied
+// encryption key if the object with the given attributes was encrypted with
+// one. It returns an error if the client has no key or a different one.
+func (c *Client) storageObjectKey(h *storage.ObjectHandle, attrs *storage.ObjectAttrs) (*storage.ObjectHandle, error) {
+	if attrs.CustomerKeySHA256 == "" {
+		return h, nil
+	}
+
+	if c.storageKey == nil {
+		return nil, fmt.Errorf("secret object is encrypted with a customer-supplied " +
+			"encryption key, but none was configured")
+	}
+
+	sum := sha256.Sum256(c.storageKey)
+	if base64.StdEncoding.EncodeToString(sum[:]) != attrs.CustomerKeySHA256 {
+		return nil, fmt.Errorf("secret object is encrypted with a different " +
+			"customer-supplied encryption key")
+	}
+	return h.Key(c.storageKey), nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/option"
+)
+
+func TestClient_checkStorageEncryptionKey(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		key  []byte
+		err  bool
+	}{
+		{
+			name: "none",
+			key:  nil,
+		},
+		{
+			name: "valid",
+			key:  bytes.Repeat([]byte{1}, StorageEncryptionKeySize),
+		},
+		{
+			name: "short",
+			key:  bytes.Repeat([]byte{1}, 16),
+			err:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := &Client{}
+			WithStorageEncryptionKey(tc.key).(*clientOption).apply(c)
+			if err := c.checkStorageEncryptionKey(); (err != nil) != tc.err {
+				t.Errorf("expected error to be %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestClient_storageObjectKey(t *testing.T) {
+	t.Parallel()
+
+	key := bytes.Repeat([]byte{1}, StorageEncryptionKeySize)
+	sum := sha256.Sum256(key)
+	keySHA := base64.StdEncoding.EncodeToString(sum[:])
+
+	withKey := &Client{}
+	WithStorageEncryptionKey(key).(*clientOption).apply(withKey)
+	otherKey := &Client{}
+	WithStorageEncryptionKey(bytes.Repeat([]byte{2}, StorageEncryptionKeySize)).(*clientOption).apply(otherKey)
+
+	cases := []struct {
+		name   string
+		client *Client
+		attrs  *storage.ObjectAttrs
+		err    string
+	}{
+		{
+			name:   "unencrypted_no_key",
+			client: &Client{},
+			attrs:  &storage.ObjectAttrs{},
+		},
+		{
+			name:   "unencrypted_with_key",
+			client: withKey,
+			attrs:  &storage.ObjectAttrs{},
+		},
+		{
+			name:   "encrypted_with_key",
+			client: withKey,
+			attrs:  &storage.ObjectAttrs{CustomerKeySHA256: keySHA},
+		},
+		{
+			name:   "encrypted_no_key",
+			client: &Client{},
+			attrs:  &storage.ObjectAttrs{CustomerKeySHA256: keySHA},
+			err:    "none was configured",
+		},
+		{
+			name:   "encrypted_other_key",
+			client: otherKey,
+			attrs:  &storage.ObjectAttrs{CustomerKeySHA256: keySHA},
+			err:    "different customer-supplied encryption key",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// The handle is never used, so it does not need a real client.
+			h := (&storage.Client{}).Bucket("b").Object("o")
+			_, err := tc.client.storageObjectKey(h, tc.attrs)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestClient_StorageEncryptionKey_storage(t *testing.T) {
+	testAcc(t)
+
+	ctx := logging.WithLogger(context.Background(), logging.TestLogger(t))
+
+	newClient := func(opts ...option.ClientOption) *Client {
+		client, err := New(ctx, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := client.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+		return client
+	}
+
+	bucket, object, key := testBucket(t), testName(t), testKey(t)
+	defer testStorageCleanup(t, bucket, object)
+
+	csek := newClient(WithStorageEncryptionKey(bytes.Repeat([]byte{0x42}, StorageEncryptionKeySize)))
+	if _, err := csek.Create(ctx, &StorageCreateRequest{
+		Bucket:    bucket,
+		Object:    object,
+		Key:       key,
+		Plaintext: []byte("my secret value"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := csek.Access(ctx, &StorageAccessRequest{
+		Bucket: bucket,
+		Object: object,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := string(plaintext), "my secret value"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	// A client without the key must not read the object.
+	if _, err := newClient().Access(ctx, &StorageAccessRequest{
+		Bucket: bucket,
+		Object: object,
+	}); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+)
+
+// resolveFunc resolves a single reference.
+type resolveFunc func(ctx context.Context, s string) ([]byte, error)
+
+// ResolveDeep is a top-level package function for resolving references nested
+// in a JSON or YAML document. See Client.ResolveDeep for more details.
+func ResolveDeep(ctx context.Context, s string) ([]byte, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.ResolveDeep(ctx, s)
+}
+
+// ResolveDeep resolves berglas references nested in a JSON or YAML document,
+// such as a serialized config blob in an environment variable. Every string
+// value that is entirely a reference is replaced by its resolved plaintext,
+// encoded as a JSON string or a double-quoted YAML scalar. Everything else,
+// including whitespace, comments, and key order, is preserved.
+//
+// A value that is itself a reference is resolved as with Resolve, and a value
+// that contains no references is returned unchanged. YAML is handled line by
+// line, so references in flow collections ("{...}" and "[...]") and multi-line
+// scalars are not resolved.
+func (c *Client) ResolveDeep(ctx context.Context, s string) ([]byte, error) {
+	logger := logging.FromContext(ctx)
+
+	logger.DebugContext(ctx, "resolvedeep.start")
+	defer logger.DebugContext(ctx, "resolvedeep.finish")
+
+	return resolveDeep(ctx, s, c.Resolve)
+}
+
+// hasNestedReference returns true if s may contain a reference anywhere in it.
+func hasNestedReference(s string) bool {
+	return strings.Contains(s, ReferencePrefixStorage) ||
+		strings.Contains(s, ReferencePrefixSecretManager)
+}
+
+func resolveDeep(ctx context.Context, s string, resolve resolveFunc) ([]byte, error) {
+	if IsReference(s) {
+		return resolve(ctx, s)
+	}
+	if !hasNestedReference(s) {
+		return []byte(s), nil
+	}
+
+	trimmed := strings.TrimSpace(s)
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) &&
+		json.Valid([]byte(trimmed)) {
+		return resolveDeepJSON(ctx, s, resolve)
+	}
+	return resolveDeepYAML(ctx, s, resolve)
+}
+
+// resolveDeepJSON resolves each string value in the valid JSON document s that
+// is a reference. Object keys are never resolved.
+func resolveDeepJSON(ctx context.Context, s string, resolve resolveFunc) ([]byte, error) {
+	var b bytes.Buffer
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '"' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		// Find the end of the string literal. The document is valid, so every
+		// quote outside of a literal starts one.
+		end := i + 1
+		for ; s[end] != '"'; end++ {
+			if s[end] == '\\' {
+				end++
+			}
+		}
+		literal := s[i : end+1]
+		i = end
+
+		// Object keys are followed by a colon.
+		if rest := strings.TrimLeft(s[end+1:], " \t\r\n"); strings.HasPrefix(rest, ":") {
+			b.WriteString(literal)
+			continue
+		}
+
+		var v string
+		if err := json.Unmarshal([]byte(literal), &v); err != nil || !IsReference(v) {
+			b.WriteString(literal)
+			continue
+		}
+
+		plaintext, err := resolve(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(quoteDeep(plaintext))
+		clear(plaintext)
+	}
+	return b.Bytes(), nil
+}
+
+// yamlPrefixRe matches the indentation, list markers, and mapping key that
+// precede a scalar value on a YAML line.
+var yamlPrefixRe = regexp.MustCompile(`^(\s*)((?:- +)*)((?:[^\s#'"\-{[][^#]*?|"[^"]*"|'[^']*'):(?: +|$))?`)
+
+// yamlBlockRe matches a block scalar indicator.
+var yamlBlockRe = regexp.MustCompile(`^[|>][-+0-9]*$`)
+
+// resolveDeepYAML resolves each scalar value in the YAML document s that is a
+// reference. Only values of mapping entries and list items are considered.
+func resolveDeepYAML(ctx context.Context, s string, resolve resolveFunc) ([]byte, error) {
+	var b bytes.Buffer
+	b.Grow(len(s))
+
+	// The indentation of the line that started a block scalar, or -1 if not in
+	// a block scalar.
+	blockIndent := -1
+
+	for _, line := range strings.SplitAfter(s, "\n") {
+		content := strings.TrimRight(line, "\r\n")
+		eol := line[len(content):]
+
+		indent := len(content) - len(strings.TrimLeft(content, " "))
+		if blockIndent >= 0 {
+			if strings.TrimSpace(content) == "" || indent > blockIndent {
+				b.WriteString(line)
+				continue
+			}
+			blockIndent = -1
+		}
+
+		m := yamlPrefixRe.FindStringSubmatch(content)
+		prefix := m[0]
+		if m[2] == "" && m[3] == "" {
+			// Not a list item or mapping entry.
+			b.WriteString(line)
+			continue
+		}
+
+		value, suffix, ok := splitYAMLScalar(content[len(prefix):])
+		if !ok {
+			b.WriteString(line)
+			continue
+		}
+		if yamlBlockRe.MatchString(value) {
+			blockIndent = indent
+			b.WriteString(line)
+			continue
+		}
+		if !IsReference(value) {
+			b.WriteString(line)
+			continue
+		}
+
+		plaintext, err := resolve(ctx, value)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(prefix)
+		b.WriteString(quoteDeep(plaintext))
+		b.WriteString(suffix)
+		b.WriteString(eol)
+		clear(plaintext)
+	}
+	return b.Bytes(), nil
+}
+
+// splitYAMLScalar splits s into a single-line scalar value and the trailing
+// whitespace and comment. It returns false if s is not a simple scalar, such
+// as a quoted scalar with escapes.
+func splitYAMLScalar(s string) (string, string, bool) {
+	var value, rest string
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := strings.IndexByte(s[1:], '"')
+		if end < 0 || strings.Contains(s[1:end+1], `\`) {
+			return "", "", false
+		}
+		value, rest = s[1:end+1], s[end+2:]
+	case strings.HasPrefix(s, `'`):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 || strings.HasPrefix(s[end+2:], `'`) {
+			return "", "", false
+		}
+		value, rest = s[1:end+1], s[end+2:]
+	default:
+		value, rest = s, ""
+		if i := strings.Index(s, " #"); i >= 0 {
+			value, rest = s[:i], s[i:]
+		}
+		trimmed := strings.TrimRight(value, " \t")
+		value, rest = trimmed, value[len(trimmed):]+rest
+	}
+
+	if t := strings.TrimLeft(rest, " \t"); t != "" && !strings.HasPrefix(t, "#") {
+		return "", "", false
+	}
+	return value, rest, true
+}
+
+// quoteDeep encodes the plaintext as a JSON string, which is also a valid
+// double-quoted YAML scalar. Invalid UTF-8 is replaced with U+FFFD.
+func quoteDeep(plaintext []byte) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(string(plaintext)); err != nil {
+		// Encoding a string cannot fail.
+		panic(fmt.Sprintf("failed to encode string: %s", err))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
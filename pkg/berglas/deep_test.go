@@ -0,0 +1,118 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestResolveDeep(t *testing.T) {
+	t.Parallel()
+
+	resolve := func(_ context.Context, s string) ([]byte, error) {
+		if strings.Contains(s, "missing") {
+			return nil, fmt.Errorf("%s does not exist", s)
+		}
+		return []byte("v(" + s + ")\n\"quoted\""), nil
+	}
+
+	cases := []struct {
+		name string
+		in   string
+		exp  string
+		err  bool
+	}{
+		{
+			name: "plain_reference",
+			in:   "sm://p/s",
+			exp:  "v(sm://p/s)\n\"quoted\"",
+		},
+		{
+			name: "no_references",
+			in:   `{"a": "b"}`,
+			exp:  `{"a": "b"}`,
+		},
+		{
+			name: "json",
+			in: `{
+  "db": {"password": "sm://p/db",  "host": "localhost"},
+  "sm://p/key": "keys are not resolved",
+  "list": ["berglas://b/o", 1, "not sm://p/s"]
+}`,
+			exp: `{
+  "db": {"password": "v(sm://p/db)\n\"quoted\"",  "host": "localhost"},
+  "sm://p/key": "keys are not resolved",
+  "list": ["v(berglas://b/o)\n\"quoted\"", 1, "not sm://p/s"]
+}`,
+		},
+		{
+			name: "json_error",
+			in:   `{"a": "sm://p/missing"}`,
+			err:  true,
+		},
+		{
+			name: "yaml",
+			in: "# config\r\n" +
+				"db:\n" +
+				"  password: sm://p/db  # from secret manager\n" +
+				"  token: 'berglas://b/o'\n" +
+				"  host: localhost\n" +
+				"keys:\n" +
+				"  - \"sm://p/a\"\n" +
+				"  - - sm://p/b\n" +
+				"cert: |\n" +
+				"  sm://p/not-resolved\n" +
+				"other: not sm://p/s\n",
+			exp: "# config\r\n" +
+				"db:\n" +
+				"  password: \"v(sm://p/db)\\n\\\"quoted\\\"\"  # from secret manager\n" +
+				"  token: \"v(berglas://b/o)\\n\\\"quoted\\\"\"\n" +
+				"  host: localhost\n" +
+				"keys:\n" +
+				"  - \"v(sm://p/a)\\n\\\"quoted\\\"\"\n" +
+				"  - - \"v(sm://p/b)\\n\\\"quoted\\\"\"\n" +
+				"cert: |\n" +
+				"  sm://p/not-resolved\n" +
+				"other: not sm://p/s\n",
+		},
+		{
+			name: "yaml_error",
+			in:   "a: sm://p/missing\n",
+			err:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			act, err := resolveDeep(context.Background(), tc.in, resolve)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if tc.err {
+				return
+			}
+			if exp := tc.exp; string(act) != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	defaultClientLock sync.Mutex
+	defaultClient     *Client
+)
+
+// DefaultClient returns the client used by the package-level helpers such as
+// Access and Resolve. It is created with New on first use and reused by later
+// calls, so one-off helper calls share connections. If creation fails, the
+// error is returned and creation is attempted again on the next call.
+func DefaultClient(ctx context.Context) (*Client, error) {
+	defaultClientLock.Lock()
+	defer defaultClientLock.Unlock()
+
+	if defaultClient != nil {
+		return defaultClient, nil
+	}
+
+	// The client outlives the caller's context, so it must not be canceled
+	// with it.
+	client, err := New(context.WithoutCancel(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defaultClient = client
+	return client, nil
+}
+
+// SetDefaultClient sets the client used by the package-level helpers, such as
+// one created with custom options. Setting nil causes the next helper call to
+// create a new client. The previous default client is not closed; call Close
+// on it if it is no longer used.
+func SetDefaultClient(c *Client) {
+	defaultClientLock.Lock()
+	defer defaultClientLock.Unlock()
+
+	defaultClient = c
+}
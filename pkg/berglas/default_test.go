@@ -0,0 +1,41 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDefaultClient(t *testing.T) {
+	// Not parallel: the default client is global.
+
+	t.Cleanup(func() {
+		SetDefaultClient(nil)
+	})
+
+	want := new(Client)
+	SetDefaultClient(want)
+
+	for i := 0; i < 2; i++ {
+		got, err := DefaultClient(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("expected %p to be %p", got, want)
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"runtime"
+	"time"
 
 	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"cloud.google.com/go/storage"
@@ -26,10 +27,20 @@ import (
 	"google.golang.org/api/iterator"
 	grpccodes "google.golang.org/grpc/codes"
 	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+const (
+	// SecretManagerMinDestroyTTL and SecretManagerMaxDestroyTTL are the bounds
+	// Secret Manager places on a secret's version destroy TTL.
+	SecretManagerMinDestroyTTL = 24 * time.Hour
+	SecretManagerMaxDestroyTTL = 1000 * 24 * time.Hour
 )
 
 type deleteRequest interface {
 	isDeleteRequest()
+	Validate() error
 }
 
 // StorageDeleteRequest is used as input to delete a secret from Cloud Storage.
@@ -43,6 +54,15 @@ type StorageDeleteRequest struct {
 
 func (r *StorageDeleteRequest) isDeleteRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageDeleteRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	return v.err()
+}
+
 // DeleteRequest is an alias for StorageDeleteRequest for
 // backwards-compatibility. New clients should use StorageDeleteRequest.
 type DeleteRequest = StorageDeleteRequest
@@ -55,14 +75,47 @@ type SecretManagerDeleteRequest struct {
 
 	// Name is the name of the secret to delete.
 	Name string
+
+	// Version, if set, is the version to destroy. Only this version is
+	// destroyed, and the secret and its other versions are kept. Aliases such
+	// as "latest" are not allowed.
+	Version string
+
+	// DestroyTTL, if positive, sets the secret's version destroy TTL before
+	// destroying Version. The version is then disabled instead of destroyed,
+	// and is only destroyed once the TTL passes. Until then, it can be
+	// recovered with Restore. The TTL stays set on the secret and applies to
+	// later destroys of its versions. It requires Version.
+	DestroyTTL time.Duration
 }
 
 func (r *SecretManagerDeleteRequest) isDeleteRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerDeleteRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	v.require("Name", r.Name, "missing secret name")
+	if r.Version == "latest" {
+		v.addf("Version", "cannot destroy the latest alias, give a version number")
+	}
+	if r.DestroyTTL != 0 {
+		if r.Version == "" {
+			v.addf("DestroyTTL", "destroy TTL requires a version")
+		}
+		if r.DestroyTTL < SecretManagerMinDestroyTTL || r.DestroyTTL > SecretManagerMaxDestroyTTL {
+			v.addf("DestroyTTL", "invalid destroy TTL %s: must be between %s and %s",
+				r.DestroyTTL, SecretManagerMinDestroyTTL, SecretManagerMaxDestroyTTL)
+		}
+	}
+	return v.err()
+}
+
 // Delete is a top-level package function for deleting a secret. For large
 // volumes of secrets, please create a client instead.
 func Delete(ctx context.Context, i deleteRequest) error {
-	client, err := New(ctx)
+	client, err := DefaultClient(ctx)
 	if err != nil {
 		return err
 	}
@@ -70,13 +123,22 @@ func Delete(ctx context.Context, i deleteRequest) error {
 }
 
 // Delete deletes a secret. When given a SecretManagerDeleteRequest, this
-// deletes a secret from Secret Manager. When given a StorageDeleteRequest, this
-// deletes a secret stored in Cloud Storage.
+// deletes a secret from Secret Manager, or destroys one version if a version is
+// given. When given a StorageDeleteRequest, this deletes a secret stored in
+// Cloud Storage.
 func (c *Client) Delete(ctx context.Context, i deleteRequest) error {
 	if i == nil {
 		return fmt.Errorf("missing request")
 	}
 
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := i.Validate(); err != nil {
+		return err
+	}
+
 	switch t := i.(type) {
 	case *SecretManagerDeleteRequest:
 		return c.secretManagerDelete(ctx, t)
@@ -89,14 +151,7 @@ func (c *Client) Delete(ctx context.Context, i deleteRequest) error {
 
 func (c *Client) secretManagerDelete(ctx context.Context, i *SecretManagerDeleteRequest) error {
 	project := i.Project
-	if project == "" {
-		return fmt.Errorf("missing project")
-	}
-
 	name := i.Name
-	if name == "" {
-		return fmt.Errorf("missing secret name")
-	}
 
 	logger := logging.FromContext(ctx).With(
 		"project", project,
@@ -106,6 +161,10 @@ func (c *Client) secretManagerDelete(ctx context.Context, i *SecretManagerDelete
 	logger.DebugContext(ctx, "delete.start")
 	defer logger.DebugContext(ctx, "delete.finish")
 
+	if i.Version != "" {
+		return c.secretManagerDestroyVersion(ctx, i)
+	}
+
 	if err := c.secretManagerClient.DeleteSecret(ctx, &secretspb.DeleteSecretRequest{
 		Name: fmt.Sprintf("projects/%s/secrets/%s", project, name),
 	}); err != nil {
@@ -117,16 +176,66 @@ func (c *Client) secretManagerDelete(ctx context.Context, i *SecretManagerDelete
 	return nil
 }
 
-func (c *Client) storageDelete(ctx context.Context, i *StorageDeleteRequest) error {
-	bucket := i.Bucket
-	if bucket == "" {
-		return fmt.Errorf("missing bucket name")
+// secretManagerDestroyVersion destroys a single version, first setting the
+// secret's destroy TTL if one was given.
+func (c *Client) secretManagerDestroyVersion(ctx context.Context, i *SecretManagerDeleteRequest) error {
+	project, name, version := i.Project, i.Name, i.Version
+	secretName := fmt.Sprintf("projects/%s/secrets/%s", project, name)
+
+	logger := logging.FromContext(ctx).With(
+		"project", project,
+		"name", name,
+		"version", version,
+	)
+
+	if i.DestroyTTL > 0 {
+		secret, err := c.secretManagerClient.GetSecret(ctx, &secretspb.GetSecretRequest{
+			Name: secretName,
+		})
+		if err != nil {
+			if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.NotFound {
+				return secretManagerError(ErrSecretDoesNotExist, project, name)
+			}
+			return fmt.Errorf("failed to read secret: %w", err)
+		}
+
+		if secret.GetVersionDestroyTtl().AsDuration() != i.DestroyTTL {
+			logger.DebugContext(ctx, "setting version destroy ttl", "ttl", i.DestroyTTL)
+
+			if _, err := c.secretManagerClient.UpdateSecret(ctx, &secretspb.UpdateSecretRequest{
+				Secret: &secretspb.Secret{
+					Name:              secretName,
+					Etag:              secret.GetEtag(),
+					VersionDestroyTtl: durationpb.New(i.DestroyTTL),
+				},
+				UpdateMask: &fieldmaskpb.FieldMask{
+					Paths: []string{"version_destroy_ttl"},
+				},
+			}); err != nil {
+				if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.Aborted {
+					return secretManagerError(ErrSecretModified, project, name)
+				}
+				return fmt.Errorf("failed to set version destroy ttl: %w", err)
+			}
+		}
 	}
 
-	object := i.Object
-	if object == "" {
-		return fmt.Errorf("missing object name")
+	logger.DebugContext(ctx, "destroying version")
+
+	if _, err := c.secretManagerClient.DestroySecretVersion(ctx, &secretspb.DestroySecretVersionRequest{
+		Name: fmt.Sprintf("%s/versions/%s", secretName, version),
+	}); err != nil {
+		if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.NotFound {
+			return secretManagerError(ErrSecretDoesNotExist, project, name)
+		}
+		return fmt.Errorf("failed to destroy secret version: %w", err)
 	}
+	return nil
+}
+
+func (c *Client) storageDelete(ctx context.Context, i *StorageDeleteRequest) error {
+	bucket := i.Bucket
+	object := i.Object
 
 	logger := logging.FromContext(ctx).With(
 		"bucket", bucket,
@@ -153,6 +262,11 @@ func (c *Client) storageDelete(ctx context.Context, i *StorageDeleteRequest) err
 
 	logger.DebugContext(ctx, "deleting secrets", "parallelism", parallelism)
 
+	// The number of generations is not known until listing completes.
+	progress := progressFromContext(ctx)
+	progress.Start(-1)
+	defer progress.Finish()
+
 L:
 	for {
 		obj, err := it.Next()
@@ -206,7 +320,9 @@ L:
 						logger.ErrorContext(ctx, "worker received error but channel blocked", "error", err)
 						cancel()
 					}
+					return
 				}
+				progress.Increment(1)
 			}()
 		}
 	}
@@ -46,7 +46,7 @@ func TestClient_Delete_secretManager(t *testing.T) {
 			Project: project,
 			Name:    name,
 		}); !IsSecretDoesNotExistErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretDoesNotExist)
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
 		}
 	})
 
@@ -67,7 +67,7 @@ func TestClient_Delete_secretManager(t *testing.T) {
 			Project: project,
 			Name:    name,
 		}); !IsSecretDoesNotExistErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretDoesNotExist)
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
 		}
 	})
 }
@@ -103,7 +103,7 @@ func TestClient_Delete_storage(t *testing.T) {
 			Bucket: bucket,
 			Object: object,
 		}); !IsSecretDoesNotExistErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretDoesNotExist)
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
 		}
 	})
 
@@ -124,7 +124,7 @@ func TestClient_Delete_storage(t *testing.T) {
 			Bucket: bucket,
 			Object: object,
 		}); !IsSecretDoesNotExistErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretDoesNotExist)
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
 		}
 	})
 }
@@ -0,0 +1,161 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"github.com/sethvargo/go-retry"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const (
+	// StorageReadMaxRetries is the default number of times a Cloud Storage
+	// download that failed with a transient error is retried.
+	StorageReadMaxRetries = 5
+
+	// StorageReadRetryBase is the default delay before the first retry of a
+	// Cloud Storage download. Later retries back off exponentially.
+	StorageReadRetryBase = 250 * time.Millisecond
+)
+
+// WithStorageReadRetry returns a client option that sets how Cloud Storage
+// downloads are retried when they fail with a transient error, such as a
+// connection reset partway through the object. A download that fails partway
+// resumes from the last byte received rather than starting over. Retries back
+// off exponentially from base, or StorageReadRetryBase if base is zero or less.
+// A maxRetries of zero or less disables retries. The default is
+// StorageReadMaxRetries retries.
+func WithStorageReadRetry(maxRetries int, base time.Duration) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.storageReadRetries = maxRetries
+		c.storageReadRetryBase = base
+	}}
+}
+
+// storageReadBackoff returns the backoff for retrying Cloud Storage downloads.
+func (c *Client) storageReadBackoff() retry.Backoff {
+	base := c.storageReadRetryBase
+	if base <= 0 {
+		base = StorageReadRetryBase
+	}
+	retries := c.storageReadRetries
+	if retries < 0 {
+		retries = 0
+	}
+
+	b := retry.NewExponential(base)
+	b = retry.WithCappedDuration(4*time.Second, b)
+	b = retry.WithJitterPercent(25, b)
+	return retry.WithMaxRetries(uint64(retries), b)
+}
+
+// storageDownload reads size bytes using open, which returns a reader starting
+// at the given offset. Transient failures are retried with the backoff b, and a
+// read that fails partway resumes from the last byte received. Since the
+// storage library only validates checksums of whole-object reads, a resumed
+// download is checked against crc, if it is not zero.
+func storageDownload(ctx context.Context, b retry.Backoff, size int64, crc uint32,
+	open func(ctx context.Context, offset int64) (io.ReadCloser, error),
+) ([]byte, error) {
+	logger := logging.FromContext(ctx)
+
+	var buf bytes.Buffer
+	if size > 0 {
+		buf.Grow(int(size))
+	}
+
+	var attempt int
+	err := retry.Do(ctx, b, func(ctx context.Context) error {
+		attempt++
+
+		// A previous attempt may have read everything before failing.
+		offset := int64(buf.Len())
+		if attempt > 1 {
+			if size > 0 && offset >= size {
+				return nil
+			}
+			logger.DebugContext(ctx, "resuming download",
+				"attempt", attempt,
+				"offset", offset)
+		}
+
+		r, err := open(ctx, offset)
+		if err != nil {
+			if isStorageReadRetryable(err) {
+				return retry.RetryableError(err)
+			}
+			return err
+		}
+		defer r.Close()
+
+		if _, err := buf.ReadFrom(r); err != nil {
+			err = fmt.Errorf("failed to read secret into string: %w", err)
+			if isStorageReadRetryable(err) {
+				return retry.RetryableError(err)
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	if attempt > 1 && crc != 0 {
+		if got := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)); got != crc {
+			return nil, fmt.Errorf("resumed download has checksum %d, expected %d", got, crc)
+		}
+	}
+	return data, nil
+}
+
+// isStorageReadRetryable returns true if err is a transient network or server
+// error that a download can be retried after.
+func isStorageReadRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) {
+		return true
+	}
+
+	var terr *googleapi.Error
+	if errors.As(err, &terr) {
+		return terr.Code == http.StatusTooManyRequests || terr.Code >= http.StatusInternalServerError
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-retry"
+	"google.golang.org/api/googleapi"
+)
+
+// flakyReader returns n bytes of r, then err.
+type flakyReader struct {
+	r   io.Reader
+	n   int
+	err error
+}
+
+func (f *flakyReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, f.err
+	}
+	if len(p) > f.n {
+		p = p[:f.n]
+	}
+	n, err := f.r.Read(p)
+	f.n -= n
+	return n, err
+}
+
+func TestStorageDownload(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("abcdefghijklmnopqrstuvwxyz")
+	crc := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+
+	cases := []struct {
+		name    string
+		crc     uint32
+		fails   []error
+		offsets []int64
+		err     bool
+	}{
+		{
+			name:    "success",
+			crc:     crc,
+			offsets: []int64{0},
+		},
+		{
+			name:    "resumes",
+			crc:     crc,
+			fails:   []error{io.ErrUnexpectedEOF, syscall.ECONNRESET},
+			offsets: []int64{0, 5, 10},
+		},
+		{
+			name:    "server_error",
+			crc:     crc,
+			fails:   []error{&googleapi.Error{Code: 503}},
+			offsets: []int64{0, 5},
+		},
+		{
+			name:    "not_retryable",
+			fails:   []error{&googleapi.Error{Code: 403}},
+			offsets: []int64{0},
+			err:     true,
+		},
+		{
+			name:    "exhausted",
+			fails:   []error{io.ErrUnexpectedEOF, io.ErrUnexpectedEOF, io.ErrUnexpectedEOF, io.ErrUnexpectedEOF},
+			offsets: []int64{0, 5, 10, 15},
+			err:     true,
+		},
+		{
+			name:    "checksum_mismatch",
+			crc:     crc + 1,
+			fails:   []error{io.ErrUnexpectedEOF},
+			offsets: []int64{0, 5},
+			err:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var offsets []int64
+			open := func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+				attempt := len(offsets)
+				offsets = append(offsets, offset)
+
+				r := io.Reader(bytes.NewReader(data[offset:]))
+				if attempt < len(tc.fails) {
+					r = &flakyReader{r: r, n: 5, err: tc.fails[attempt]}
+				}
+				return io.NopCloser(r), nil
+			}
+
+			b := retry.WithMaxRetries(3, retry.NewConstant(time.Millisecond))
+			act, err := storageDownload(context.Background(), b, int64(len(data)), tc.crc, open)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t: %v", tc.err, err)
+			}
+			if err == nil && !bytes.Equal(act, data) {
+				t.Errorf("expected %q to be %q", act, data)
+			}
+			if !reflect.DeepEqual(offsets, tc.offsets) {
+				t.Errorf("expected offsets %v to be %v", offsets, tc.offsets)
+			}
+		})
+	}
+}
+
+func TestStorageDownload_OpenRetry(t *testing.T) {
+	t.Parallel()
+
+	var attempts int
+	open := func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, fmt.Errorf("failed to read secret: %w", &googleapi.Error{Code: 429})
+		}
+		return io.NopCloser(bytes.NewReader([]byte("abc"))), nil
+	}
+
+	b := retry.WithMaxRetries(3, retry.NewConstant(time.Millisecond))
+	act, err := storageDownload(context.Background(), b, 3, 0, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(act), "abc"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if attempts != 2 {
+		t.Errorf("expected %d to be %d", attempts, 2)
+	}
+}
+
+func TestIsStorageReadRetryable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		err  error
+		exp  bool
+	}{
+		{"unexpected_eof", io.ErrUnexpectedEOF, true},
+		{"reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"rate_limited", &googleapi.Error{Code: 429}, true},
+		{"server_error", &googleapi.Error{Code: 500}, true},
+		{"forbidden", &googleapi.Error{Code: 403}, false},
+		{"canceled", context.Canceled, false},
+		{"other", errors.New("boom"), false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if act := isStorageReadRetryable(tc.err); act != tc.exp {
+				t.Errorf("expected %t to be %t", act, tc.exp)
+			}
+		})
+	}
+}
+
+func TestWithStorageReadRetry(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{storageReadRetries: StorageReadMaxRetries}
+	WithStorageReadRetry(0, 0).(*clientOption).apply(c)
+
+	if _, stop := c.storageReadBackoff().Next(); !stop {
+		t.Errorf("expected retries to be disabled")
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+)
+
+// DriftResult compares the version or generation a reference is pinned to with
+// the latest one.
+type DriftResult struct {
+	// Pinned is the Secret Manager version or Cloud Storage generation the
+	// reference is pinned to. It is empty if the reference is not pinned to a
+	// number, such as "latest" or a version alias, in which case Latest is not
+	// looked up.
+	Pinned string
+
+	// Latest is the newest enabled Secret Manager version, or the live Cloud
+	// Storage generation.
+	Latest string
+
+	// Behind is true if a newer version or generation than Pinned exists.
+	Behind bool
+}
+
+// Drift is a top-level package function for checking a pinned reference for
+// drift. For large volumes of secrets, please create a client instead.
+func Drift(ctx context.Context, ref *Reference) (*DriftResult, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Drift(ctx, ref)
+}
+
+// Drift compares the version or generation the reference is pinned to with the
+// latest one, without reading the secret's value. References that are not
+// pinned to a number return a result with an empty Pinned. Only Secret Manager
+// and Cloud Storage references are supported.
+func (c *Client) Drift(ctx context.Context, ref *Reference) (*DriftResult, error) {
+	if ref == nil {
+		return nil, fmt.Errorf("missing reference")
+	}
+
+	logger := logging.FromContext(ctx).With(
+		"reference", ref.String(),
+	)
+
+	logger.DebugContext(ctx, "drift.start")
+	defer logger.DebugContext(ctx, "drift.finish")
+
+	var pinned, latest int64
+	switch t := ref.Type(); t {
+	case ReferenceTypeSecretManager:
+		n, err := strconv.ParseInt(ref.Version(), 10, 64)
+		if err != nil {
+			return &DriftResult{}, nil
+		}
+		pinned = n
+
+		version, err := c.secretManagerResolveVersion(ctx, ref.Project(), ref.Name(),
+			versionConstraintLatestPrefix+"0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to find latest version: %w", err)
+		}
+		if latest, err = strconv.ParseInt(version, 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to parse latest version: %w", err)
+		}
+	case ReferenceTypeStorage:
+		if ref.Generation() == 0 {
+			return &DriftResult{}, nil
+		}
+		pinned = ref.Generation()
+
+		attrs, err := c.storageBucket(ctx, ref.Bucket()).Object(ref.Object()).Attrs(ctx)
+		if err == storage.ErrObjectNotExist {
+			return nil, storageError(ErrSecretDoesNotExist, ref.Bucket(), ref.Object())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret metadata: %w", err)
+		}
+		latest = attrs.Generation
+	default:
+		return nil, fmt.Errorf("drift is not supported for reference %s", ref)
+	}
+
+	return &DriftResult{
+		Pinned: strconv.FormatInt(pinned, 10),
+		Latest: strconv.FormatInt(latest, 10),
+		Behind: pinned < latest,
+	}, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestClient_Drift_unpinned(t *testing.T) {
+	t.Parallel()
+
+	// Unpinned references are reported without calling any API, so a zero
+	// client is enough.
+	client := new(Client)
+
+	for _, s := range []string{
+		"sm://p/s",
+		"sm://p/s#latest",
+		"sm://p/s#prod",
+		"sm://p/s#latest-1",
+		"berglas://b/o",
+	} {
+		ref, err := ParseReference(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := client.Drift(context.Background(), ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := (&DriftResult{}); !reflect.DeepEqual(result, exp) {
+			t.Errorf("expected %#v to be %#v", result, exp)
+		}
+	}
+
+	ref, err := ParseReference("s3://b/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Drift(context.Background(), ref); err == nil {
+		t.Errorf("expected error for s3 reference")
+	}
+}
+
+func TestClient_Drift_secretManager(t *testing.T) {
+	testAcc(t)
+
+	ctx, client := testClient(t)
+	project, name := testProject(t), testName(t)
+	defer testSecretManagerCleanup(t, project, name)
+
+	for _, plaintext := range []string{"v1", "v2"} {
+		if _, err := client.Update(ctx, &SecretManagerUpdateRequest{
+			Project:         project,
+			Name:            name,
+			Plaintext:       []byte(plaintext),
+			CreateIfMissing: true,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		version string
+		exp     *DriftResult
+	}{
+		{"1", &DriftResult{Pinned: "1", Latest: "2", Behind: true}},
+		{"2", &DriftResult{Pinned: "2", Latest: "2"}},
+	}
+
+	for _, tc := range cases {
+		ref, err := ParseReference("sm://" + project + "/" + name + "#" + tc.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := client.Drift(ctx, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result, tc.exp) {
+			t.Errorf("expected %#v to be %#v", result, tc.exp)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"google.golang.org/api/option"
+)
+
+// service identifies one of the Google Cloud APIs berglas talks to.
+type service string
+
+const (
+	serviceKMS             service = "kms"
+	servicePubSub          service = "pubsub"
+	serviceResourceManager service = "cloudresourcemanager"
+	serviceSecretManager   service = "secretmanager"
+	serviceStorage         service = "storage"
+)
+
+// serviceOption is a client option that only applies to a single service.
+type serviceOption struct {
+	option.ClientOption
+	service service
+}
+
+// WithStorageEndpoint returns a client option that overrides the Cloud Storage
+// API endpoint, for example to use a Private Service Connect endpoint. Unlike
+// option.WithEndpoint, it does not affect the other services.
+func WithStorageEndpoint(endpoint string) option.ClientOption {
+	return &serviceOption{option.WithEndpoint(endpoint), serviceStorage}
+}
+
+// WithSecretManagerEndpoint returns a client option that overrides the Secret
+// Manager API endpoint. Unlike option.WithEndpoint, it does not affect the
+// other services.
+func WithSecretManagerEndpoint(endpoint string) option.ClientOption {
+	return &serviceOption{option.WithEndpoint(endpoint), serviceSecretManager}
+}
+
+// WithKMSEndpoint returns a client option that overrides the Cloud KMS API
+// endpoint. Unlike option.WithEndpoint, it does not affect the other services.
+func WithKMSEndpoint(endpoint string) option.ClientOption {
+	return &serviceOption{option.WithEndpoint(endpoint), serviceKMS}
+}
+
+// serviceOptions filters the given options to those that apply to the given
+// service, dropping options that only configure the berglas client.
+// Service-specific options are appended last so they take precedence over any
+// general option.WithEndpoint.
+func serviceOptions(opts []option.ClientOption, s service) []option.ClientOption {
+	result := make([]option.ClientOption, 0, len(opts))
+	var specific []option.ClientOption
+	for _, opt := range opts {
+		switch t := opt.(type) {
+		case *clientOption:
+			// Only applies to the berglas client.
+		case *serviceOption:
+			if t.service == s {
+				specific = append(specific, t.ClientOption)
+			}
+		default:
+			result = append(result, opt)
+		}
+	}
+	return append(result, specific...)
+}
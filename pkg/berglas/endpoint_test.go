@@ -0,0 +1,90 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/option"
+)
+
+func TestServiceOptions(t *testing.T) {
+	t.Parallel()
+
+	general := option.WithEndpoint("general.example.com:443")
+	userAgent := option.WithUserAgent("test")
+
+	cases := []struct {
+		name    string
+		opts    []option.ClientOption
+		service service
+		exp     []option.ClientOption
+	}{
+		{
+			name:    "no_options",
+			opts:    nil,
+			service: serviceStorage,
+			exp:     []option.ClientOption{},
+		},
+		{
+			name:    "general_only",
+			opts:    []option.ClientOption{general, userAgent},
+			service: serviceKMS,
+			exp:     []option.ClientOption{general, userAgent},
+		},
+		{
+			name: "other_service_dropped",
+			opts: []option.ClientOption{
+				userAgent,
+				WithStorageEndpoint("storage.example.com:443"),
+			},
+			service: serviceKMS,
+			exp:     []option.ClientOption{userAgent},
+		},
+		{
+			name:    "client_option_dropped",
+			opts:    []option.ClientOption{WithReadOnly(), userAgent},
+			service: serviceStorage,
+			exp:     []option.ClientOption{userAgent},
+		},
+		{
+			name: "specific_after_general",
+			opts: []option.ClientOption{
+				WithSecretManagerEndpoint("sm.example.com:443"),
+				general,
+				userAgent,
+			},
+			service: serviceSecretManager,
+			exp: []option.ClientOption{
+				general,
+				userAgent,
+				option.WithEndpoint("sm.example.com:443"),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if act, exp := serviceOptions(tc.opts, tc.service), tc.exp; !reflect.DeepEqual(act, exp) {
+				t.Errorf("expected %#v to be %#v", act, exp)
+			}
+		})
+	}
+}
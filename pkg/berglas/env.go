@@ -0,0 +1,238 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	// DefaultResolveEnvParallelism is the default number of references
+	// ResolveEnv resolves concurrently.
+	DefaultResolveEnvParallelism = 8
+
+	// DefaultResolveEnvMaxBytes is the default maximum total size in bytes of
+	// the values resolved by ResolveEnv.
+	DefaultResolveEnvMaxBytes = 16 * 1024 * 1024
+)
+
+// ResolveEnvRequest is used as input to resolve all references in an
+// environment.
+type ResolveEnvRequest struct {
+	// Env is the environment in "KEY=VALUE" form, as returned by os.Environ.
+	Env []string
+
+	// Parallelism is the maximum number of references to resolve concurrently.
+	// If zero, DefaultResolveEnvParallelism is used.
+	Parallelism int
+
+	// MaxBytes is the maximum total size in bytes of the resolved values. If
+	// zero, DefaultResolveEnvMaxBytes is used. If negative, there is no limit.
+	MaxBytes int64
+
+	// Deep also resolves references nested in values that are JSON or YAML
+	// documents, as with ResolveDeep. With Deep, MaxBytes counts the size of
+	// each resolved document.
+	Deep bool
+}
+
+// EnvVarError is an error resolving a single environment variable.
+type EnvVarError struct {
+	Key string
+	Err error
+}
+
+// Error implements error.
+func (e *EnvVarError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Err)
+}
+
+// Unwrap implements errors.Unwrap.
+func (e *EnvVarError) Unwrap() error {
+	return e.Err
+}
+
+// ResolveEnvError is the error returned when one or more environment variables
+// could not be resolved.
+type ResolveEnvError struct {
+	// Errors are the per-variable errors, sorted by key.
+	Errors []*EnvVarError
+}
+
+// Error implements error.
+func (e *ResolveEnvError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("failed to resolve %d environment variable(s): %s",
+		len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap allows errors.Is and errors.As to match any of the variable errors.
+func (e *ResolveEnvError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ResolveEnv resolves all berglas references in the given environment with
+// bounded concurrency, returning a copy of the environment with each reference
+// replaced by its resolved value. Entries that are not references are returned
+// unchanged.
+//
+// Resolution stops at the first failure, including exceeding MaxBytes, and a
+// *ResolveEnvError listing every variable that failed is returned.
+//
+// Resolved values are returned as strings, which cannot be wiped, even with
+// WithZeroize. Use ResolveInto for values that must be wiped after use.
+func (c *Client) ResolveEnv(ctx context.Context, i *ResolveEnvRequest) ([]string, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+
+	parallelism := i.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultResolveEnvParallelism
+	}
+
+	maxBytes := i.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultResolveEnvMaxBytes
+	}
+
+	logger := logging.FromContext(ctx).With(
+		"parallelism", parallelism,
+		"max_bytes", maxBytes,
+	)
+
+	logger.DebugContext(ctx, "resolveenv.start")
+	defer logger.DebugContext(ctx, "resolveenv.finish")
+
+	env := slices.Clone(i.Env)
+
+	// Cancel outstanding work on the first failure.
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sem := semaphore.NewWeighted(int64(parallelism))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var total int64
+	var errs []*EnvVarError
+
+	fail := func(key string, err error) {
+		mu.Lock()
+		errs = append(errs, &EnvVarError{Key: key, Err: err})
+		mu.Unlock()
+		cancel()
+	}
+
+	for idx, e := range env {
+		k, v, ok := strings.Cut(e, "=")
+		if !ok {
+			continue
+		}
+		if !(IsReference(v) || i.Deep && hasNestedReference(v)) {
+			warnSuggestedReference(ctx, k, v)
+			continue
+		}
+
+		if err := sem.Acquire(workCtx, 1); err != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sem.Release(1)
+
+			resolve := c.Resolve
+			if i.Deep {
+				resolve = c.ResolveDeep
+			}
+
+			plaintext, err := resolve(workCtx, v)
+			if err != nil {
+				// Ignore errors caused by another variable failing.
+				if workCtx.Err() != nil && ctx.Err() == nil && errors.Is(err, context.Canceled) {
+					return
+				}
+				fail(k, err)
+				return
+			}
+			// The plaintext is copied into the environment and never returned, so
+			// it is always wiped.
+			defer clear(plaintext)
+
+			mu.Lock()
+			total += int64(len(plaintext))
+			exceeded := maxBytes > 0 && total > maxBytes
+			mu.Unlock()
+
+			if exceeded {
+				fail(k, fmt.Errorf("resolved values exceed the limit of %d bytes", maxBytes))
+				return
+			}
+
+			// Each goroutine writes a distinct index.
+			env[idx] = k + "=" + string(plaintext)
+		}()
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		slices.SortFunc(errs, func(a, b *EnvVarError) int {
+			return strings.Compare(a.Key, b.Key)
+		})
+		return nil, &ResolveEnvError{Errors: errs}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// UnresolvedReferences returns the sorted names of the variables in env, in
+// "KEY=VALUE" form, whose values still contain a berglas or Secret Manager
+// reference. After ResolveEnv, these are usually references nested in a value
+// that was not resolved with Deep, or secrets whose value is itself a
+// reference. Only names are returned, since the values may be secrets.
+func UnresolvedReferences(env []string) []string {
+	var names []string
+	for _, e := range env {
+		k, v, ok := strings.Cut(e, "=")
+		if !ok {
+			continue
+		}
+		if hasNestedReference(v) {
+			names = append(names, k)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+)
+
+func TestClient_ResolveEnv(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		env    []string
+		exp    []string
+		errKey string
+	}{
+		{
+			name: "no_references",
+			env:  []string{"FOO=bar", "EMPTY=", "MALFORMED"},
+			exp:  []string{"FOO=bar", "EMPTY=", "MALFORMED"},
+		},
+		{
+			name:   "invalid_reference",
+			env:    []string{"FOO=bar", "SECRET=sm://foo"},
+			errKey: "SECRET",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := logging.WithLogger(context.Background(), logging.TestLogger(t))
+
+			var client Client
+			env, err := client.ResolveEnv(ctx, &ResolveEnvRequest{
+				Env: tc.env,
+			})
+
+			if tc.errKey != "" {
+				var rerr *ResolveEnvError
+				if !errors.As(err, &rerr) {
+					t.Fatalf("expected %q to be a ResolveEnvError", err)
+				}
+				if act, exp := len(rerr.Errors), 1; act != exp {
+					t.Fatalf("expected %d errors to be %d", act, exp)
+				}
+				if act, exp := rerr.Errors[0].Key, tc.errKey; act != exp {
+					t.Errorf("expected %q to be %q", act, exp)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if act, exp := env, tc.exp; !reflect.DeepEqual(act, exp) {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
+
+func TestUnresolvedReferences(t *testing.T) {
+	t.Parallel()
+
+	env := []string{
+		"PLAIN=value",
+		"REF=sm://my-project/api-key",
+		"NESTED=postgres://user:berglas://my-bucket/db-password@db:5432",
+		"CONFIG={\"password\": \"sm://my-project/db-password\"}",
+		"URL=https://example.com/sm",
+		"EMPTY=",
+		"MALFORMED",
+	}
+
+	act := UnresolvedReferences(env)
+	exp := []string{"CONFIG", "NESTED", "REF"}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"google.golang.org/api/option"
+)
+
+// EnvelopeAlgorithm is the algorithm used to encrypt Cloud Storage secrets
+// locally with their data encryption key.
+type EnvelopeAlgorithm string
+
+const (
+	// EnvelopeAlgorithmAES256GCM is AES-256 in GCM mode with a random 96-bit
+	// nonce.
+	EnvelopeAlgorithmAES256GCM EnvelopeAlgorithm = "aes-256-gcm"
+
+	// EnvelopeAlgorithmXChaCha20Poly1305 is XChaCha20-Poly1305 with a random
+	// 192-bit nonce, which is large enough that random nonces never collide in
+	// practice.
+	EnvelopeAlgorithmXChaCha20Poly1305 EnvelopeAlgorithm = "xchacha20-poly1305"
+)
+
+// envelopeFormatV2 is the prefix of the versioned envelope format:
+//
+//	v2:<algorithm>:b64(kms_encrypted_dek):b64(nonce+ciphertext)
+//
+// The unversioned (v1) format is b64(kms_encrypted_dek):b64(nonce+ciphertext),
+// always encrypted with AES-256-GCM. A base64 encoded KMS ciphertext is never
+// the literal "v2", so the formats cannot be confused.
+const envelopeFormatV2 = "v2"
+
+// WithEnvelopeAlgorithm returns a client option that sets the algorithm used to
+// encrypt new Cloud Storage secrets, which are then written in the versioned
+// envelope format. Without this option, secrets are written in the original
+// unversioned AES-256-GCM format so older versions of berglas can read them.
+// Secrets in either format can always be read.
+func WithEnvelopeAlgorithm(alg EnvelopeAlgorithm) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.envelopeAlgorithm = alg
+	}}
+}
+
+// IsEnvelopeAlgorithm returns true if s is a supported EnvelopeAlgorithm.
+func IsEnvelopeAlgorithm(s string) bool {
+	switch EnvelopeAlgorithm(s) {
+	case EnvelopeAlgorithmAES256GCM, EnvelopeAlgorithmXChaCha20Poly1305:
+		return true
+	default:
+		return false
+	}
+}
+
+// envelopeAEAD returns the AEAD for the given algorithm and key. The empty
+// algorithm is the unversioned format, which uses AES-256-GCM.
+func envelopeAEAD(alg EnvelopeAlgorithm, key []byte) (cipher.AEAD, error) {
+	switch alg {
+	case "", EnvelopeAlgorithmAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher from key: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gcm cipher: %w", err)
+		}
+		return aead, nil
+	case EnvelopeAlgorithmXChaCha20Poly1305:
+		aead, err := chacha20poly1305.NewX(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create xchacha20-poly1305 cipher: %w", err)
+		}
+		return aead, nil
+	default:
+		return nil, fmt.Errorf("unsupported envelope algorithm %q", alg)
+	}
+}
+
+// envelopeAdditionalData returns the additional authenticated data for the
+// given algorithm. In the versioned format, the header is authenticated so the
+// algorithm cannot be swapped.
+func envelopeAdditionalData(alg EnvelopeAlgorithm) []byte {
+	if alg == "" {
+		return nil
+	}
+	return []byte(envelopeFormatV2 + ":" + string(alg))
+}
+
+// envelopeEncrypt generates a unique DEK and encrypts the plaintext with it
+// using the given algorithm. The DEK and resulting ciphertext are returned.
+func envelopeEncrypt(alg EnvelopeAlgorithm, plaintext []byte) ([]byte, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate random key bytes: %w", err)
+	}
+
+	aead, err := envelopeAEAD(alg, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Generate nonce
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate random nonce bytes: %w", err)
+	}
+
+	// Encrypt the ciphertext with the DEK
+	ciphertext := aead.Seal(nonce, nonce, plaintext, envelopeAdditionalData(alg))
+
+	return key, ciphertext, nil
+}
+
+// envelopeDecrypt decrypts the data with the dek using the given algorithm,
+// returning the plaintext and any errors that occur.
+func envelopeDecrypt(alg EnvelopeAlgorithm, dek, data []byte) ([]byte, error) {
+	aead, err := envelopeAEAD(alg, dek)
+	if err != nil {
+		return nil, err
+	}
+
+	size := aead.NonceSize()
+	if len(data) < size {
+		return nil, fmt.Errorf("malformed ciphertext")
+	}
+	nonce, ciphertext := data[:size], data[size:]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, envelopeAdditionalData(alg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt ciphertext with dek: %w", err)
+	}
+	return plaintext, nil
+}
+
+// envelopeEncode builds the storage object contents for the given algorithm,
+// using the unversioned format if the algorithm is empty.
+func envelopeEncode(alg EnvelopeAlgorithm, encDEK, ciphertext []byte) string {
+	blob := base64.StdEncoding.EncodeToString(encDEK) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext)
+	if alg == "" {
+		return blob
+	}
+	return envelopeFormatV2 + ":" + string(alg) + ":" + blob
+}
+
+// envelopeDecode parses storage object contents in either format, returning
+// the algorithm (empty for the unversioned format), the KMS encrypted DEK, and
+// the ciphertext.
+func envelopeDecode(data string) (EnvelopeAlgorithm, []byte, []byte, error) {
+	var alg EnvelopeAlgorithm
+	if rest, ok := strings.CutPrefix(data, envelopeFormatV2+":"); ok {
+		name, blob, ok := strings.Cut(rest, ":")
+		if !ok {
+			return "", nil, nil, fmt.Errorf("invalid ciphertext: missing algorithm")
+		}
+		if !IsEnvelopeAlgorithm(name) {
+			return "", nil, nil, fmt.Errorf("invalid ciphertext: unsupported algorithm %q", name)
+		}
+		alg, data = EnvelopeAlgorithm(name), blob
+	}
+
+	parts := strings.SplitN(data, ":", 2)
+	if len(parts) < 2 {
+		return "", nil, nil, fmt.Errorf("invalid ciphertext: not enough parts")
+	}
+
+	encDEK, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid ciphertext: failed to parse dek")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid ciphertext: failed to parse ciphertext")
+	}
+
+	return alg, encDEK, ciphertext, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		alg  EnvelopeAlgorithm
+	}{
+		{
+			name: "unversioned",
+		},
+		{
+			name: "aes_256_gcm",
+			alg:  EnvelopeAlgorithmAES256GCM,
+		},
+		{
+			name: "xchacha20_poly1305",
+			alg:  EnvelopeAlgorithmXChaCha20Poly1305,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			plaintext := []byte("my secret value")
+
+			dek, ciphertext, err := envelopeEncrypt(tc.alg, plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The DEK is returned as-is in place of the KMS encrypted DEK.
+			blob := envelopeEncode(tc.alg, dek, ciphertext)
+			if hasVersion := strings.HasPrefix(blob, "v2:"); hasVersion != (tc.alg != "") {
+				t.Errorf("expected %q to be versioned: %t", blob, tc.alg != "")
+			}
+
+			alg, encDEK, decoded, err := envelopeDecode(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if alg != tc.alg {
+				t.Errorf("expected %q to be %q", alg, tc.alg)
+			}
+
+			result, err := envelopeDecrypt(alg, encDEK, decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(result, plaintext) {
+				t.Errorf("expected %q to be %q", result, plaintext)
+			}
+		})
+	}
+
+	t.Run("algorithm_swap", func(t *testing.T) {
+		t.Parallel()
+
+		dek, ciphertext, err := envelopeEncrypt(EnvelopeAlgorithmAES256GCM, []byte("foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Neither the other algorithm nor the unversioned format may open it.
+		if _, err := envelopeDecrypt("", dek, ciphertext); err == nil {
+			t.Errorf("expected error")
+		}
+		if _, err := envelopeDecrypt(EnvelopeAlgorithmXChaCha20Poly1305, dek, ciphertext); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		t.Parallel()
+
+		if _, _, err := envelopeEncrypt("aes-128-cbc", []byte("foo")); err == nil {
+			t.Errorf("expected error")
+		}
+
+		b64 := base64.StdEncoding.EncodeToString([]byte("foo"))
+		if _, _, _, err := envelopeDecode("v2:aes-128-cbc:" + b64 + ":" + b64); err == nil {
+			t.Errorf("expected error")
+		}
+		if _, _, _, err := envelopeDecode("v2:" + b64); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+}
@@ -14,17 +14,75 @@
 
 package berglas
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
+// These errors may be matched with errors.Is. Errors about a specific secret
+// are returned as a *SecretError that wraps one of them, which may be matched
+// with errors.As to find the secret's resource name.
 const (
-	// errSecretAlreadyExists is the error returned if a secret already exists.
-	errSecretAlreadyExists = Error("secret already exists")
+	// ErrSecretAlreadyExists is the error returned if a secret already exists.
+	ErrSecretAlreadyExists = Error("secret already exists")
+
+	// ErrSecretDoesNotExist is the error returned if a secret does not exist.
+	ErrSecretDoesNotExist = Error("secret does not exist")
+
+	// ErrSecretModified is the error returned when preconditions fail.
+	ErrSecretModified = Error("secret modified between read and write")
+
+	// ErrReadOnly is the error returned when a read-only client is asked to
+	// mutate a secret or its permissions.
+	ErrReadOnly = Error("client is read-only")
+
+	// ErrSecretTooLarge is the error returned when the plaintext exceeds the
+	// maximum secret size.
+	ErrSecretTooLarge = Error("secret too large")
+
+	// ErrObjectIAMUnavailable is the error returned when a bucket does not
+	// support object IAM policies.
+	ErrObjectIAMUnavailable = Error("object iam policies are not supported on this bucket")
+
+	// ErrCopyRequiresRebind is the error returned when copying a Cloud Storage
+	// secret without rebinding would change the additional authenticated data
+	// of its data encryption key, leaving the copy undecryptable.
+	ErrCopyRequiresRebind = Error("copy requires rebinding the secret to its new location")
+
+	// ErrRateLimited is the error returned when Cloud Storage keeps rejecting
+	// writes to an object with 429 or 503 responses after all retries, such as
+	// when the same secret is updated more than once per second.
+	ErrRateLimited = Error("rate limited")
+
+	// ErrPolicyViolation is the error returned when Resolve is asked to place a
+	// secret in a way its Policy does not allow, such as a file-only secret in
+	// an environment variable.
+	ErrPolicyViolation = Error("secret policy violation")
 
-	// errSecretDoesNotExist is the error returned if a secret does not exist.
-	errSecretDoesNotExist = Error("secret does not exist")
+	// ErrReferenceNotAllowed is the error returned when a client configured
+	// with WithAllowedPrefixes is asked for a secret outside the allowlist.
+	ErrReferenceNotAllowed = Error("reference not allowed")
 
-	// errSecretModified is the error returned when preconditions fail.
-	errSecretModified = Error("secret modified between read and write")
+	// ErrRollbackDetected is the error wrapped by *RollbackError when an older
+	// generation of a secret was restored as the live generation.
+	ErrRollbackDetected = Error("secret rollback detected")
+
+	// ErrNamingPolicyViolation is the error returned when creating, updating,
+	// or copying to a secret whose name does not match the client's
+	// NamingPolicy.
+	ErrNamingPolicyViolation = Error("secret name violates naming policy")
+
+	// ErrNotRecoverable is the error returned when restoring a Secret Manager
+	// version that is not scheduled for destruction, or was already destroyed.
+	ErrNotRecoverable = Error("secret version is not recoverable")
+
+	// ErrKeyDoesNotExist is the error returned when getting or deleting a key
+	// that is not in a StructuredSecret.
+	ErrKeyDoesNotExist = Error("key does not exist in structured secret")
+
+	// ErrCircuitOpen is the error returned when an access is not sent because
+	// the circuit breaker for its backend is open. See WithCircuitBreaker.
+	ErrCircuitOpen = Error("circuit breaker open, backend unavailable")
 )
 
 // Error is an error from Berglas.
@@ -35,20 +93,98 @@ func (e Error) Error() string {
 	return string(e)
 }
 
+// SecretError is an error about a specific secret.
+type SecretError struct {
+	// Err is the underlying error, such as ErrSecretDoesNotExist.
+	Err error
+
+	// Resource is the name of the secret: "projects/P/secrets/S" for Secret
+	// Manager, "gs://B/O" for Cloud Storage, and "s3://B/O" for S3.
+	Resource string
+}
+
+// Error implements the error interface.
+func (e *SecretError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Err, e.Resource)
+}
+
+// Unwrap returns the underlying error.
+func (e *SecretError) Unwrap() error {
+	return e.Err
+}
+
+// secretManagerError wraps err with the resource name of a Secret Manager
+// secret.
+func secretManagerError(err error, project, name string) error {
+	return &SecretError{
+		Err:      err,
+		Resource: fmt.Sprintf("projects/%s/secrets/%s", project, name),
+	}
+}
+
+// storageError wraps err with the resource name of a Cloud Storage secret.
+func storageError(err error, bucket, object string) error {
+	return &SecretError{
+		Err:      err,
+		Resource: fmt.Sprintf("gs://%s/%s", bucket, object),
+	}
+}
+
+// s3Error wraps err with the resource name of an S3 secret.
+func s3Error(err error, bucket, object string) error {
+	return &SecretError{
+		Err:      err,
+		Resource: fmt.Sprintf("s3://%s/%s", bucket, object),
+	}
+}
+
 // IsSecretAlreadyExistsErr returns true if the given error means that the
-// secret already exists.
+// secret already exists. It is equivalent to errors.Is(err,
+// ErrSecretAlreadyExists).
 func IsSecretAlreadyExistsErr(err error) bool {
-	return errors.Is(err, errSecretAlreadyExists)
+	return errors.Is(err, ErrSecretAlreadyExists)
 }
 
 // IsSecretDoesNotExistErr returns true if the given error means that the secret
-// does not exist.
+// does not exist. It is equivalent to errors.Is(err, ErrSecretDoesNotExist).
 func IsSecretDoesNotExistErr(err error) bool {
-	return errors.Is(err, errSecretDoesNotExist)
+	return errors.Is(err, ErrSecretDoesNotExist)
 }
 
 // IsSecretModifiedErr returns true if the given error means that the secret
-// was modified (CAS failure).
+// was modified (CAS failure). It is equivalent to errors.Is(err,
+// ErrSecretModified).
 func IsSecretModifiedErr(err error) bool {
-	return errors.Is(err, errSecretModified)
+	return errors.Is(err, ErrSecretModified)
+}
+
+// IsReadOnlyErr returns true if the given error means that a mutating operation
+// was refused because the client is read-only. It is equivalent to
+// errors.Is(err, ErrReadOnly).
+func IsReadOnlyErr(err error) bool {
+	return errors.Is(err, ErrReadOnly)
+}
+
+// IsSecretTooLargeErr returns true if the given error means that the plaintext
+// exceeds the maximum secret size. It is equivalent to errors.Is(err,
+// ErrSecretTooLarge).
+func IsSecretTooLargeErr(err error) bool {
+	return errors.Is(err, ErrSecretTooLarge)
+}
+
+// IsObjectIAMUnavailableErr returns true if the given error means that the
+// bucket does not support object IAM policies, such as some older fine-grained
+// buckets. Storage grants and revokes can fall back to object ACLs with
+// AllowACLFallback. It is equivalent to errors.Is(err,
+// ErrObjectIAMUnavailable).
+func IsObjectIAMUnavailableErr(err error) bool {
+	return errors.Is(err, ErrObjectIAMUnavailable)
+}
+
+// IsRollbackErr returns true if the given error means that an older generation
+// of a secret was restored as the live generation. Use errors.As with a
+// *RollbackError for the generations involved. It is equivalent to
+// errors.Is(err, ErrRollbackDetected).
+func IsRollbackErr(err error) bool {
+	return errors.Is(err, ErrRollbackDetected)
 }
@@ -0,0 +1,99 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSecretError(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		err      error
+		is       error
+		resource string
+		msg      string
+	}{
+		{
+			name:     "secret_manager",
+			err:      secretManagerError(ErrSecretDoesNotExist, "p", "s"),
+			is:       ErrSecretDoesNotExist,
+			resource: "projects/p/secrets/s",
+			msg:      "secret does not exist: projects/p/secrets/s",
+		},
+		{
+			name:     "storage",
+			err:      storageError(ErrSecretModified, "b", "o"),
+			is:       ErrSecretModified,
+			resource: "gs://b/o",
+			msg:      "secret modified between read and write: gs://b/o",
+		},
+		{
+			name:     "s3_wrapped",
+			err:      fmt.Errorf("failed: %w", s3Error(ErrSecretAlreadyExists, "b", "o")),
+			is:       ErrSecretAlreadyExists,
+			resource: "s3://b/o",
+			msg:      "failed: secret already exists: s3://b/o",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if !errors.Is(tc.err, tc.is) {
+				t.Errorf("expected %q to be %q", tc.err, tc.is)
+			}
+
+			var serr *SecretError
+			if !errors.As(tc.err, &serr) {
+				t.Fatalf("expected %q to be a *SecretError", tc.err)
+			}
+			if act, exp := serr.Resource, tc.resource; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+			if act, exp := tc.err.Error(), tc.msg; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
+
+func TestSecretError_Client(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{}
+	WithS3Store(&memS3Store{}).(*clientOption).apply(c)
+
+	_, err := c.Access(context.Background(), &StorageS3AccessRequest{Bucket: "b", Object: "o"})
+	if !errors.Is(err, ErrSecretDoesNotExist) {
+		t.Fatalf("expected %q to be %q", err, ErrSecretDoesNotExist)
+	}
+
+	var serr *SecretError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected %q to be a *SecretError", err)
+	}
+	if act, exp := serr.Resource, "s3://b/o"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
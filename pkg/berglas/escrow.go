@@ -0,0 +1,445 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+)
+
+// EscrowBundleVersion is the version of the EscrowBundle format written by
+// this package.
+const EscrowBundleVersion = 1
+
+// escrowFingerprintPrefix prefixes the fingerprint that identifies a local
+// RSA wrapping key in an EscrowBundle.
+const escrowFingerprintPrefix = "sha256:"
+
+// escrowKMSAlgorithms are the Cloud KMS asymmetric decryption algorithms that
+// can be used as an escrow wrapping key. All use RSA-OAEP with SHA-256, which
+// is also used for local RSA wrapping keys.
+var escrowKMSAlgorithms = map[kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm]struct{}{
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256: {},
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256: {},
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA256: {},
+}
+
+// EscrowBundle is a sealed copy of a Cloud Storage secret that can be
+// recovered without the secret's primary Cloud KMS key. It holds the stored
+// ciphertext and the secret's data encryption key re-wrapped under an escrow
+// key with RSA-OAEP (SHA-256).
+type EscrowBundle struct {
+	// Version is the bundle format version.
+	Version int `json:"version"`
+
+	// Bucket, Object, and Generation identify the escrowed secret.
+	Bucket     string `json:"bucket"`
+	Object     string `json:"object"`
+	Generation int64  `json:"generation"`
+
+	// KMSKey is the secret's primary Cloud KMS key.
+	KMSKey string `json:"kms_key"`
+
+	// WrappingKey identifies the escrow key: either a Cloud KMS crypto key
+	// version, or the SHA-256 fingerprint ("sha256:<hex>") of the DER-encoded
+	// public key of a local RSA key.
+	WrappingKey string `json:"wrapping_key"`
+
+	// WrappedDEK is the data encryption key wrapped under the escrow key.
+	WrappedDEK []byte `json:"wrapped_dek"`
+
+	// Ciphertext is the stored envelope, as returned by ReadCiphertext.
+	Ciphertext []byte `json:"ciphertext"`
+
+	// ExportedAt is when the bundle was created.
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// EscrowExportRequest is used as input to export a Cloud Storage secret to an
+// EscrowBundle.
+type EscrowExportRequest struct {
+	// Bucket is the name of the bucket where the secret lives.
+	Bucket string
+
+	// Object is the name of the object in Cloud Storage.
+	Object string
+
+	// Generation of the object to export. The latest generation is used if
+	// unset.
+	Generation int64
+
+	// WrappingKey is the Cloud KMS crypto key version, with an RSA-OAEP SHA-256
+	// decryption algorithm, to wrap the data encryption key under. Only its
+	// public key is used. Exactly one of WrappingKey or PublicKey is required.
+	WrappingKey string
+
+	// PublicKey is a PEM-encoded RSA public key to wrap the data encryption key
+	// under.
+	PublicKey []byte
+}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *EscrowExportRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	switch {
+	case r.WrappingKey == "" && r.PublicKey == nil:
+		v.addf("WrappingKey", "missing wrapping key or public key")
+	case r.WrappingKey != "" && r.PublicKey != nil:
+		v.addf("WrappingKey", "only one of wrapping key or public key may be given")
+	case r.WrappingKey != "":
+		v.escrowWrappingKey("WrappingKey", r.WrappingKey)
+	}
+	return v.err()
+}
+
+// EscrowRestoreRequest is used as input to restore a Cloud Storage secret from
+// an EscrowBundle.
+type EscrowRestoreRequest struct {
+	// Bundle is the escrow bundle to restore.
+	Bundle *EscrowBundle
+
+	// PrivateKey is the PEM-encoded RSA private key matching a local wrapping
+	// key. It is not needed if the bundle was wrapped under a Cloud KMS key,
+	// which is then used to unwrap it.
+	PrivateKey []byte
+
+	// Bucket and Object are where to restore the secret. They default to the
+	// bundle's original location.
+	Bucket string
+	Object string
+
+	// Key is the fully qualified KMS key id to encrypt the restored secret with.
+	// It defaults to the bundle's original key, which may not be available in a
+	// break-glass scenario.
+	Key string
+}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *EscrowRestoreRequest) Validate() error {
+	var v validator
+	if r.Bundle == nil {
+		v.addf("Bundle", "missing bundle")
+		return v.err()
+	}
+	if r.Bundle.Version != EscrowBundleVersion {
+		v.addf("Bundle", "unsupported bundle version %d", r.Bundle.Version)
+	}
+	v.requireBytes("Bundle", r.Bundle.WrappedDEK, "missing wrapped dek")
+	v.requireBytes("Bundle", r.Bundle.Ciphertext, "missing ciphertext")
+	if r.PrivateKey == nil && strings.HasPrefix(r.Bundle.WrappingKey, escrowFingerprintPrefix) {
+		v.addf("PrivateKey", "missing private key for wrapping key %s", r.Bundle.WrappingKey)
+	}
+	if r.Bucket == "" {
+		v.require("Bucket", r.Bundle.Bucket, "missing bucket name")
+	}
+	if r.Object == "" {
+		v.require("Object", r.Bundle.Object, "missing object name")
+	}
+	v.kmsKey("Key", r.Key)
+	return v.err()
+}
+
+// EscrowExport is a top-level package function for exporting a secret to an
+// escrow bundle. For large volumes of secrets, please create a client instead.
+func EscrowExport(ctx context.Context, i *EscrowExportRequest) (*EscrowBundle, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.EscrowExport(ctx, i)
+}
+
+// EscrowExport decrypts a Cloud Storage secret's data encryption key with
+// Cloud KMS and re-wraps it under an escrow key, returning a bundle that can be
+// stored offline and later passed to EscrowRestore. The plaintext is never
+// decrypted. Because the bundle is a second path to the secret, every export is
+// logged at info level and callers should attach a justification with
+// WithJustification so it is recorded in the Cloud KMS audit log.
+func (c *Client) EscrowExport(ctx context.Context, i *EscrowExportRequest) (*EscrowBundle, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	generation := i.Generation
+	if generation == 0 {
+		generation = -1
+	}
+
+	logger := logging.FromContext(ctx).With(
+		"bucket", i.Bucket,
+		"object", i.Object,
+		"generation", generation,
+	)
+
+	logger.DebugContext(ctx, "escrow.export.start")
+	defer logger.DebugContext(ctx, "escrow.export.finish")
+
+	// Resolve the wrapping key before touching the secret so a bad key fails
+	// without decrypting anything.
+	pub, wrappingKey, err := c.escrowPublicKey(ctx, i)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, data, err := c.storageReadBlob(ctx, i.Bucket, i.Object, generation)
+	if err != nil {
+		return nil, err
+	}
+	key := attrs.Metadata[MetadataKMSKey]
+
+	_, encDEK, _, err := envelopeDecode(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	logger.DebugContext(ctx, "decrypting dek using kms")
+
+	dek, err := c.unwrapDEK(ctx, key, encDEK,
+		storageAAD(storageAADBound(attrs.Metadata), i.Bucket, i.Object, c.aadContext,
+			storageWriteCounter(attrs.Metadata)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt dek: %w", err)
+	}
+	defer c.wipe(dek)
+
+	wrappedDEK, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, dek, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap dek: %w", err)
+	}
+
+	logger.InfoContext(ctx, "exported secret to escrow",
+		"key", key,
+		"wrapping_key", wrappingKey)
+
+	return &EscrowBundle{
+		Version:     EscrowBundleVersion,
+		Bucket:      i.Bucket,
+		Object:      i.Object,
+		Generation:  attrs.Generation,
+		KMSKey:      key,
+		WrappingKey: wrappingKey,
+		WrappedDEK:  wrappedDEK,
+		Ciphertext:  data,
+		ExportedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// escrowPublicKey returns the RSA public key and identifier of the wrapping
+// key in the request.
+func (c *Client) escrowPublicKey(ctx context.Context, i *EscrowExportRequest) (*rsa.PublicKey, string, error) {
+	if i.PublicKey != nil {
+		pub, err := parseRSAPublicKey(i.PublicKey)
+		if err != nil {
+			return nil, "", err
+		}
+		return pub, escrowFingerprint(pub), nil
+	}
+
+	resp, err := c.kmsClient.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{
+		Name: i.WrappingKey,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get wrapping key: %w", err)
+	}
+	if _, ok := escrowKMSAlgorithms[resp.GetAlgorithm()]; !ok {
+		return nil, "", fmt.Errorf("wrapping key algorithm %s is not supported, "+
+			"must be an RSA-OAEP SHA-256 decryption key", resp.GetAlgorithm())
+	}
+
+	pub, err := parseRSAPublicKey([]byte(resp.GetPem()))
+	if err != nil {
+		return nil, "", err
+	}
+	return pub, i.WrappingKey, nil
+}
+
+// EscrowRestore is a top-level package function for restoring a secret from
+// an escrow bundle. For large volumes of secrets, please create a client
+// instead.
+func EscrowRestore(ctx context.Context, i *EscrowRestoreRequest) (*Secret, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.EscrowRestore(ctx, i)
+}
+
+// EscrowRestore unwraps the data encryption key in an escrow bundle, decrypts
+// the secret, and writes it back to Cloud Storage encrypted under a new data
+// encryption key. The secret's primary Cloud KMS key is not used unless it is
+// also the key to restore under.
+func (c *Client) EscrowRestore(ctx context.Context, i *EscrowRestoreRequest) (*Secret, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	b := i.Bundle
+	bucket, object, key := i.Bucket, i.Object, i.Key
+	if bucket == "" {
+		bucket = b.Bucket
+	}
+	if object == "" {
+		object = b.Object
+	}
+	if key == "" {
+		key = b.KMSKey
+	}
+
+	logger := logging.FromContext(ctx).With(
+		"bucket", bucket,
+		"object", object,
+		"key", key,
+		"wrapping_key", b.WrappingKey,
+	)
+
+	logger.DebugContext(ctx, "escrow.restore.start")
+	defer logger.DebugContext(ctx, "escrow.restore.finish")
+
+	var dek []byte
+	if i.PrivateKey != nil {
+		priv, err := parseRSAPrivateKey(i.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		if fp := escrowFingerprint(&priv.PublicKey); fp != b.WrappingKey {
+			return nil, fmt.Errorf("private key %s does not match wrapping key %s", fp, b.WrappingKey)
+		}
+
+		dek, err = rsa.DecryptOAEP(sha256.New(), nil, priv, b.WrappedDEK, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap dek: %w", err)
+		}
+	} else {
+		resp, err := c.kmsClient.AsymmetricDecrypt(ctx, &kmspb.AsymmetricDecryptRequest{
+			Name:       b.WrappingKey,
+			Ciphertext: b.WrappedDEK,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap dek: %w", err)
+		}
+		dek = resp.Plaintext
+	}
+	defer c.wipe(dek)
+
+	plaintext, err := DecryptOffline(b.Ciphertext, dek)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.InfoContext(ctx, "restoring secret from escrow")
+
+	return c.Update(ctx, &StorageUpdateRequest{
+		Bucket:          bucket,
+		Object:          object,
+		Key:             key,
+		Plaintext:       plaintext,
+		CreateIfMissing: true,
+	})
+}
+
+// escrowFingerprint returns the identifier of a local RSA wrapping key.
+func escrowFingerprint(pub *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		// Marshaling a valid RSA public key cannot fail.
+		panic(err)
+	}
+	sum := sha256.Sum256(der)
+	return escrowFingerprintPrefix + hex.EncodeToString(sum[:])
+}
+
+// parseRSAPublicKey parses a PEM-encoded PKIX or PKCS #1 RSA public key.
+func parseRSAPublicKey(b []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode public key: no pem block found")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key must be an RSA key, got %T", pub)
+		}
+		return rsaPub, nil
+	case "RSA PUBLIC KEY":
+		pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %q", block.Type)
+	}
+}
+
+// parseRSAPrivateKey parses a PEM-encoded PKCS #8 or PKCS #1 RSA private key.
+func parseRSAPrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode private key: no pem block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		rsaPriv, ok := priv.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key must be an RSA key, got %T", priv)
+		}
+		return rsaPriv, nil
+	case "RSA PRIVATE KEY":
+		priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		return priv, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %q", block.Type)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func testRSAKey(tb testing.TB) (*rsa.PrivateKey, []byte, []byte) {
+	tb.Helper()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return priv,
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+}
+
+func TestEscrowKeys(t *testing.T) {
+	t.Parallel()
+
+	priv, pubPEM, privPEM := testRSAKey(t)
+
+	pub, err := parseRSAPublicKey(pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseRSAPrivateKey(privPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp := escrowFingerprint(pub)
+	if !strings.HasPrefix(fp, escrowFingerprintPrefix) {
+		t.Errorf("expected %q to have prefix %q", fp, escrowFingerprintPrefix)
+	}
+	if act := escrowFingerprint(&parsed.PublicKey); act != fp {
+		t.Errorf("expected %q to be %q", act, fp)
+	}
+	if act := escrowFingerprint(&priv.PublicKey); act != fp {
+		t.Errorf("expected %q to be %q", act, fp)
+	}
+
+	if _, err := parseRSAPublicKey(privPEM); err == nil {
+		t.Errorf("expected error parsing private key as public key")
+	}
+	if _, err := parseRSAPrivateKey([]byte("nope")); err == nil {
+		t.Errorf("expected error parsing invalid private key")
+	}
+}
+
+func TestClient_Escrow(t *testing.T) {
+	testAcc(t)
+
+	ctx, client := testClient(t)
+
+	bucket, object, key := testBucket(t), testName(t), testKey(t)
+	restored := testName(t)
+	defer testStorageCleanup(t, bucket, object)
+	defer testStorageCleanup(t, bucket, restored)
+
+	plaintext := []byte("my secret value")
+	if _, err := client.Create(ctx, &StorageCreateRequest{
+		Bucket:    bucket,
+		Object:    object,
+		Key:       key,
+		Plaintext: plaintext,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, pubPEM, privPEM := testRSAKey(t)
+
+	bundle, err := client.EscrowExport(ctx, &EscrowExportRequest{
+		Bucket:    bucket,
+		Object:    object,
+		PublicKey: pubPEM,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.EscrowRestore(ctx, &EscrowRestoreRequest{
+		Bundle: bundle,
+		Object: restored,
+	}); err == nil {
+		t.Errorf("expected error without private key")
+	}
+
+	if _, err := client.EscrowRestore(ctx, &EscrowRestoreRequest{
+		Bundle:     bundle,
+		PrivateKey: privPEM,
+		Object:     restored,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	act, err := client.Access(ctx, &StorageAccessRequest{
+		Bucket: bucket,
+		Object: restored,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(act, plaintext) {
+		t.Errorf("expected %q to be %q", act, plaintext)
+	}
+}
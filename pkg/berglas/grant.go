@@ -18,8 +18,8 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"time"
 
-	"cloud.google.com/go/iam"
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
 	grpccodes "google.golang.org/grpc/codes"
@@ -28,6 +28,7 @@ import (
 
 type grantRequest interface {
 	isGrantRequest()
+	Validate() error
 }
 
 // StorageGrantRequest is used as input to grant access to secrets backed Cloud
@@ -42,10 +43,28 @@ type StorageGrantRequest struct {
 	// Members is the list of membership bindings. This should be in the format
 	// described at https://godoc.org/google.golang.org/api/iam/v1#Binding.
 	Members []string
+
+	// DryRun computes the changes without updating any IAM policies.
+	DryRun bool
+
+	// AllowACLFallback changes access with a READER object ACL entry if the
+	// bucket does not support object IAM policies. A warning is recorded on
+	// the result when this happens.
+	AllowACLFallback bool
 }
 
 func (r *StorageGrantRequest) isGrantRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageGrantRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	v.members("Members", r.Members)
+	return v.err()
+}
+
 // GrantRequest is an alias for StorageGrantRequest for
 // backwards-compatibility. New clients should use StorageGrantRequest.
 type GrantRequest = StorageGrantRequest
@@ -62,14 +81,43 @@ type SecretManagerGrantRequest struct {
 	// Members is the list of membership bindings. This should be in the format
 	// described at https://godoc.org/google.golang.org/api/iam/v1#Binding.
 	Members []string
+
+	// DryRun computes the changes without updating any IAM policies.
+	DryRun bool
+
+	// Scope is the resource to change. With SecretManagerScopeProject, the
+	// role is changed on the project's IAM policy, which applies to every
+	// secret in the project, and Name must be empty. Defaults to
+	// SecretManagerScopeSecret.
+	Scope SecretManagerScope
+
+	// Reason and Expires, if set, grant the role under an IAM condition that
+	// ends access at Expires and records Reason, such as a ticket number, in
+	// the condition's title and description. They are returned by Bindings for
+	// access reviews. A reason requires an expiry, since the condition needs an
+	// expression to evaluate.
+	Reason  string
+	Expires time.Time
 }
 
 func (r *SecretManagerGrantRequest) isGrantRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerGrantRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	v.secretManagerScope("Scope", "Name", r.Scope, r.Name)
+	v.members("Members", r.Members)
+	v.iamReason("Reason", r.Reason, r.Expires)
+	v.expiresAt("Expires", r.Expires)
+	return v.err()
+}
+
 // Grant is a top-level package function for granting access to a secret. For
 // large volumes of secrets, please create a client instead.
 func Grant(ctx context.Context, i grantRequest) error {
-	client, err := New(ctx)
+	client, err := DefaultClient(ctx)
 	if err != nil {
 		return err
 	}
@@ -79,8 +127,24 @@ func Grant(ctx context.Context, i grantRequest) error {
 // Grant adds IAM permission to the given entity to the storage object and the
 // underlying KMS key.
 func (c *Client) Grant(ctx context.Context, i grantRequest) error {
+	_, err := c.GrantWithResult(ctx, i)
+	return err
+}
+
+// GrantWithResult is like Grant, but returns the effect on each member of each
+// resource. If the request sets DryRun, the IAM policies are read but not
+// written, and the result describes the changes that would have been made.
+func (c *Client) GrantWithResult(ctx context.Context, i grantRequest) (*IAMResult, error) {
 	if i == nil {
-		return fmt.Errorf("missing request")
+		return nil, fmt.Errorf("missing request")
+	}
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
 	}
 
 	switch t := i.(type) {
@@ -89,70 +153,74 @@ func (c *Client) Grant(ctx context.Context, i grantRequest) error {
 	case *StorageGrantRequest:
 		return c.storageGrant(ctx, t)
 	default:
-		return fmt.Errorf("unknown grant type %T", t)
+		return nil, fmt.Errorf("unknown grant type %T", t)
 	}
 }
 
-func (c *Client) secretManagerGrant(ctx context.Context, i *SecretManagerGrantRequest) error {
+func (c *Client) secretManagerGrant(ctx context.Context, i *SecretManagerGrantRequest) (*IAMResult, error) {
 	project := i.Project
-	if project == "" {
-		return fmt.Errorf("missing project")
-	}
-
 	name := i.Name
-	if name == "" {
-		return fmt.Errorf("missing secret name")
-	}
-
 	members := i.Members
+	dryRun := i.DryRun
+	result := &IAMResult{DryRun: dryRun}
 	if len(members) == 0 {
-		return nil
+		return result, nil
 	}
 	sort.Strings(members)
 
 	logger := logging.FromContext(ctx).With(
 		"project", project,
 		"name", name,
+		"scope", i.Scope,
 		"members", members,
+		"dry_run", dryRun,
+		"reason", i.Reason,
 	)
 
 	logger.DebugContext(ctx, "grant.start")
 	defer logger.DebugContext(ctx, "grant.finish")
 
-	logger.DebugContext(ctx, "granting access to secret")
+	cond := iamAuditCondition(i.Reason, i.Expires)
 
-	storageHandle := c.secretManagerIAM(project, name)
-	if err := updateIAMPolicy(ctx, storageHandle, func(p *iam.Policy) *iam.Policy {
-		for _, m := range members {
-			p.Add(m, iamSecretManagerAccessor)
+	if i.Scope == SecretManagerScopeProject {
+		logger.DebugContext(ctx, "granting access to project")
+
+		changes, err := c.changeProjectIAMMembers(ctx, project, iamSecretManagerAccessor,
+			members, true, dryRun, cond)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update project IAM policy for %s: %w", project, err)
 		}
-		return p
-	}); err != nil {
+		result.Changes = append(result.Changes, changes...)
+		return result, nil
+	}
+
+	logger.DebugContext(ctx, "granting access to secret")
+
+	handle := c.secretManagerIAM(project, name).V3()
+	resource := fmt.Sprintf("projects/%s/secrets/%s", project, name)
+	changes, err := changeIAMMembers3(ctx, handle, resource, iamSecretManagerAccessor,
+		members, true, dryRun, cond)
+	if err != nil {
 		terr, ok := grpcstatus.FromError(err)
 		if ok && terr.Code() == grpccodes.NotFound {
-			return errSecretDoesNotExist
+			return nil, secretManagerError(ErrSecretDoesNotExist, project, name)
 		}
 
-		return fmt.Errorf("failed to update Secret Manager IAM policy for %s: %w", name, err)
+		return nil, fmt.Errorf("failed to update Secret Manager IAM policy for %s: %w", name, err)
 	}
+	result.Changes = append(result.Changes, changes...)
 
-	return nil
+	return result, nil
 }
 
-func (c *Client) storageGrant(ctx context.Context, i *StorageGrantRequest) error {
+func (c *Client) storageGrant(ctx context.Context, i *StorageGrantRequest) (*IAMResult, error) {
 	bucket := i.Bucket
-	if bucket == "" {
-		return fmt.Errorf("missing bucket name")
-	}
-
 	object := i.Object
-	if object == "" {
-		return fmt.Errorf("missing object name")
-	}
-
 	members := i.Members
+	dryRun := i.DryRun
+	result := &IAMResult{DryRun: dryRun}
 	if len(members) == 0 {
-		return nil
+		return result, nil
 	}
 	sort.Strings(members)
 
@@ -160,6 +228,7 @@ func (c *Client) storageGrant(ctx context.Context, i *StorageGrantRequest) error
 		"bucket", bucket,
 		"object", object,
 		"members", members,
+		"dry_run", dryRun,
 	)
 
 	logger.DebugContext(ctx, "grant.start")
@@ -171,13 +240,13 @@ func (c *Client) storageGrant(ctx context.Context, i *StorageGrantRequest) error
 	objHandle := c.storageClient.Bucket(bucket).Object(object)
 	attrs, err := objHandle.Attrs(ctx)
 	if err == storage.ErrObjectNotExist {
-		return errSecretDoesNotExist
+		return nil, storageError(ErrSecretDoesNotExist, bucket, object)
 	}
 	if err != nil {
-		return fmt.Errorf("failed to read secret metadata: %w", err)
+		return nil, fmt.Errorf("failed to read secret metadata: %w", err)
 	}
 	if attrs.Metadata == nil || attrs.Metadata[MetadataKMSKey] == "" {
-		return fmt.Errorf("missing kms key in secret metadata")
+		return nil, fmt.Errorf("missing kms key in secret metadata")
 	}
 	key := attrs.Metadata[MetadataKMSKey]
 
@@ -187,28 +256,42 @@ func (c *Client) storageGrant(ctx context.Context, i *StorageGrantRequest) error
 	// Grant access to storage
 	logger.DebugContext(ctx, "granting access to storage")
 
-	storageHandle := c.storageIAM(bucket, object)
-	if err := updateIAMPolicy(ctx, storageHandle, func(p *iam.Policy) *iam.Policy {
-		for _, m := range members {
-			p.Add(m, iamObjectReader)
-		}
-		return p
-	}); err != nil {
-		return fmt.Errorf("failed to update Storage IAM policy for %s: %w", object, err)
+	changes, err := c.changeStorageObjectMembers(ctx, bucket, object, members, true, dryRun)
+	if IsObjectIAMUnavailableErr(err) && i.AllowACLFallback {
+		logger.WarnContext(ctx, "object iam unavailable, falling back to object acl", "error", err)
+
+		result.Warnings = append(result.Warnings, &IAMWarning{
+			Kind:     IAMWarningACLFallback,
+			Resource: bucket + "/" + object,
+			Message:  "bucket does not support object IAM policies, used object ACLs instead",
+		})
+		changes, err = c.changeStorageACLMembers(ctx, bucket, object, members, true, dryRun)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update Storage IAM policy for %s: %w", object, err)
 	}
+	result.Changes = append(result.Changes, changes...)
 
 	// Grant access to KMS
+	if !isCloudKMSKey(key) {
+		logger.WarnContext(ctx, "skipping key iam, key is not a cloud kms key")
+
+		result.Warnings = append(result.Warnings, &IAMWarning{
+			Kind:     IAMWarningExternalKey,
+			Resource: key,
+			Message:  "key is not a Cloud KMS key, access to it must be managed separately",
+		})
+		return result, nil
+	}
 	logger.DebugContext(ctx, "granting access to kms")
 
 	kmsHandle := c.kmsClient.ResourceIAM(key)
-	if err := updateIAMPolicy(ctx, kmsHandle, func(p *iam.Policy) *iam.Policy {
-		for _, m := range members {
-			p.Add(m, iamKMSDecrypt)
-		}
-		return p
-	}); err != nil {
-		return fmt.Errorf("failed to update KMS IAM policy for %s: %w", key, err)
+	changes, err = changeIAMMembers(ctx, kmsHandle, key, iamKMSDecrypt,
+		members, true, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update KMS IAM policy for %s: %w", key, err)
 	}
+	result.Changes = append(result.Changes, changes...)
 
-	return nil
+	return result, nil
 }
@@ -14,5 +14,60 @@
 
 package berglas
 
-// Grant tests are included in revoke_test.go because IAM is eventually
+import (
+	"testing"
+)
+
+// Most grant tests are included in revoke_test.go because IAM is eventually
 // consistent and we are quota limited.
+
+func TestClient_Grant_secretManager(t *testing.T) {
+	testAcc(t)
+
+	t.Run("dry_run", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name, serviceAccount := testProject(t), testName(t), testServiceAccount(t)
+
+		if _, err := client.Create(ctx, &SecretManagerCreateRequest{
+			Project:   project,
+			Name:      name,
+			Plaintext: []byte("my secret value"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer testSecretManagerCleanup(t, project, name)
+
+		result, err := client.GrantWithResult(ctx, &SecretManagerGrantRequest{
+			Project: project,
+			Name:    name,
+			Members: []string{serviceAccount},
+			DryRun:  true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Changes) != 1 || result.Changes[0].Action != IAMActionAdded {
+			t.Errorf("expected a single added change, got %#v", result.Changes)
+		}
+
+		if testPolicyIncludes(t, client.secretManagerIAM(project, name), iamSecretManagerAccessor, serviceAccount) {
+			t.Errorf("expected policy to not include %q", serviceAccount)
+		}
+
+		result, err = client.RevokeWithResult(ctx, &SecretManagerRevokeRequest{
+			Project: project,
+			Name:    name,
+			Members: []string{serviceAccount},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Changes) != 1 || result.Changes[0].Action != IAMActionUnchanged {
+			t.Errorf("expected a single unchanged change, got %#v", result.Changes)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"time"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/option"
+)
+
+// WithHedging returns a client option that hedges Access calls, including those
+// made by Resolve and ResolveEnv: if a request has not completed after delay, a
+// second identical request is sent and the first to succeed is returned. The
+// other request is canceled. This trims tail latency, such as during regional
+// slowness, at the cost of extra requests, so delay should be around the
+// 95th or 99th percentile of normal access latency. A delay of zero or less
+// disables hedging.
+//
+// If the first request fails before delay, its error is returned without
+// hedging; hedging does not replace retries.
+func WithHedging(delay time.Duration) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.hedgeDelay = delay
+	}}
+}
+
+// accessUpstream accesses the secret from its backend, hedging the request if
+// the client was created with WithHedging.
+func (c *Client) accessUpstream(ctx context.Context, i accessRequest) (*accessResult, error) {
+	if c.hedgeDelay > 0 {
+		return c.accessHedged(ctx, i)
+	}
+	return c.access(ctx, i)
+}
+
+// accessHedged accesses the secret, sending a second request if the first has
+// not completed after the client's hedge delay.
+func (c *Client) accessHedged(ctx context.Context, i accessRequest) (*accessResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		secret *accessResult
+		err    error
+	}
+
+	// Buffered so the losing request never blocks after this returns.
+	results := make(chan result, 2)
+	attempt := func() {
+		secret, err := c.access(ctx, i)
+		results <- result{secret, err}
+	}
+	go attempt()
+
+	timer := time.NewTimer(c.hedgeDelay)
+	defer timer.Stop()
+
+	var firstErr error
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			logging.FromContext(ctx).DebugContext(ctx, "access is slow, sending hedged request",
+				"delay", c.hedgeDelay)
+			pending++
+			go attempt()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if pending > 0 {
+					// Wipe the losing result, if any, once it arrives.
+					go func() {
+						if r := <-results; r.err == nil {
+							c.wipe(r.secret.plaintext)
+						}
+					}()
+				}
+				return r.secret, nil
+			}
+
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stallS3Store is an S3Store whose first GetObject blocks until its context is
+// done, and which fails every call if fail is set.
+type stallS3Store struct {
+	memS3Store
+	calls atomic.Int32
+	fail  bool
+}
+
+func (s *stallS3Store) GetObject(ctx context.Context, bucket, object string) (*S3Object, error) {
+	if s.calls.Add(1) == 1 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if s.fail {
+		return nil, fmt.Errorf("backend unavailable")
+	}
+	return s.memS3Store.GetObject(ctx, bucket, object)
+}
+
+func TestClient_Access_hedging(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	keyPath := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := KeySchemeLocal + "://" + keyPath
+
+	newClient := func(t *testing.T, store *stallS3Store) *Client {
+		t.Helper()
+
+		c := &Client{}
+		WithS3Store(store).(*clientOption).apply(c)
+		if _, err := c.Create(ctx, &StorageS3CreateRequest{
+			Bucket:    "b",
+			Object:    "o",
+			Key:       key,
+			Plaintext: []byte("my secret"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		WithHedging(10 * time.Millisecond).(*clientOption).apply(c)
+		return c
+	}
+
+	t.Run("hedged", func(t *testing.T) {
+		t.Parallel()
+
+		store := &stallS3Store{}
+		c := newClient(t, store)
+
+		plaintext, err := c.Access(ctx, &StorageS3AccessRequest{Bucket: "b", Object: "o"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act, exp := string(plaintext), "my secret"; act != exp {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+		if act, exp := store.calls.Load(), int32(2); act != exp {
+			t.Errorf("expected %d calls to be %d", act, exp)
+		}
+	})
+
+	t.Run("all_fail", func(t *testing.T) {
+		t.Parallel()
+
+		store := &stallS3Store{fail: true}
+		c := newClient(t, store)
+
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		if _, err := c.Access(ctx, &StorageS3AccessRequest{Bucket: "b", Object: "o"}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+}
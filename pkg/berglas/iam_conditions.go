@@ -0,0 +1,342 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/iam"
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/genproto/googleapis/type/expr"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+const (
+	// IAMReasonMaxLength is the maximum length of the reason recorded on a
+	// grant, which must fit in the description of an IAM condition.
+	IAMReasonMaxLength = 200
+
+	// iamAuditDescription starts the description of every IAM condition
+	// created by a grant with a reason or expiry, which marks the binding as
+	// managed by berglas.
+	iamAuditDescription = "Granted by berglas."
+
+	// iamAuditReasonPrefix precedes the reason in the condition description.
+	iamAuditReasonPrefix = " Reason: "
+)
+
+// iamExpiryRegexp matches the expiry in a condition expression such as
+// `request.time < timestamp("2025-01-01T00:00:00Z")`, which is also the form
+// used by the Cloud Console for expiring access.
+var iamExpiryRegexp = regexp.MustCompile(`request\.time\s*<\s*timestamp\(\s*"([^"]+)"\s*\)`)
+
+// IAMBinding is a role granted to a set of members, possibly under a
+// condition.
+type IAMBinding struct {
+	// Role is the IAM role.
+	Role string
+
+	// Members are the members granted the role, sorted.
+	Members []string
+
+	// Condition is the condition the role is granted under, or nil if the
+	// binding is unconditional.
+	Condition *IAMCondition
+}
+
+// IAMCondition is the condition of an IAM binding. Grants made with a reason or
+// expiry record them in the condition, so access can be reviewed from the
+// policy alone.
+type IAMCondition struct {
+	// Title, Description, and Expression are the condition as stored in the
+	// policy.
+	Title       string
+	Description string
+	Expression  string
+
+	// Reason is the reason given when the binding was granted by berglas, or
+	// empty if there is none.
+	Reason string
+
+	// Expires is when the binding stops granting access, parsed from the
+	// expression, or the zero time if the expression has no expiry.
+	Expires time.Time
+}
+
+// Expired reports whether the condition has an expiry that is not after now.
+func (c *IAMCondition) Expired(now time.Time) bool {
+	return c != nil && !c.Expires.IsZero() && !c.Expires.After(now)
+}
+
+// iamAuditCondition returns the IAM condition that records the reason and
+// expiry of a grant, or nil if both are empty. A condition needs an
+// expression, so an expiry is required for a reason to be recorded; requests
+// enforce this in Validate.
+func iamAuditCondition(reason string, expires time.Time) *expr.Expr {
+	if reason == "" && expires.IsZero() {
+		return nil
+	}
+
+	title := "berglas grant"
+	description := iamAuditDescription
+	if reason != "" {
+		title = "berglas " + reason
+		description += iamAuditReasonPrefix + reason
+	}
+	if len(title) > iamConditionTitleMaxLength {
+		title = title[:iamConditionTitleMaxLength]
+		for !utf8.ValidString(title) {
+			title = title[:len(title)-1]
+		}
+	}
+
+	ts := expires.UTC().Format(time.RFC3339)
+	return &expr.Expr{
+		Title:       title,
+		Description: description,
+		Expression:  fmt.Sprintf("request.time < timestamp(%s)", strconv.Quote(ts)),
+	}
+}
+
+// isIAMAuditCondition reports whether the condition was created by a grant
+// with a reason or expiry.
+func isIAMAuditCondition(e *expr.Expr) bool {
+	return e != nil && strings.HasPrefix(e.Description, iamAuditDescription)
+}
+
+// iamConditionFromExpr parses the condition of a binding.
+func iamConditionFromExpr(e *expr.Expr) *IAMCondition {
+	if e == nil {
+		return nil
+	}
+
+	cond := &IAMCondition{
+		Title:       e.Title,
+		Description: e.Description,
+		Expression:  e.Expression,
+	}
+	if isIAMAuditCondition(e) {
+		_, cond.Reason, _ = strings.Cut(e.Description, iamAuditReasonPrefix)
+	}
+	if m := iamExpiryRegexp.FindStringSubmatch(e.Expression); m != nil {
+		if t, err := time.Parse(time.RFC3339, m[1]); err == nil {
+			cond.Expires = t
+		}
+	}
+	return cond
+}
+
+// sameIAMCondition reports whether two binding conditions are the same.
+func sameIAMCondition(a, b *expr.Expr) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Expression == b.Expression && a.Title == b.Title && a.Description == b.Description
+}
+
+// changeIAMBindings adds (or removes) the role for each member on the given
+// bindings, returning the new bindings and the effect on each member. Members
+// are added to the binding with the given condition, which is nil for the
+// unconditional binding. Members are removed from the unconditional binding and
+// from bindings whose condition was created by a grant with a reason or
+// expiry; other conditional bindings are preserved.
+func changeIAMBindings(bindings []*iampb.Binding, resource, role string,
+	members []string, add bool, cond *expr.Expr,
+) ([]*iampb.Binding, []*IAMChange) {
+	changes := make([]*IAMChange, 0, len(members))
+
+	var target *iampb.Binding
+	for _, b := range bindings {
+		if b.Role == role && sameIAMCondition(b.Condition, cond) {
+			target = b
+			break
+		}
+	}
+
+	for _, m := range members {
+		action := IAMActionUnchanged
+		if add {
+			if target == nil || !slices.Contains(target.Members, m) {
+				action = IAMActionAdded
+				if target == nil {
+					target = &iampb.Binding{Role: role, Condition: cond}
+					bindings = append(bindings, target)
+				}
+				target.Members = append(target.Members, m)
+			}
+		} else {
+			for _, b := range bindings {
+				if b.Role != role || (b.Condition != nil && !isIAMAuditCondition(b.Condition)) {
+					continue
+				}
+				if slices.Contains(b.Members, m) {
+					action = IAMActionRemoved
+					b.Members = slices.DeleteFunc(b.Members, func(s string) bool {
+						return s == m
+					})
+				}
+			}
+		}
+
+		changes = append(changes, &IAMChange{
+			Resource: resource,
+			Role:     role,
+			Member:   m,
+			Action:   action,
+		})
+	}
+
+	// Empty bindings are rejected.
+	bindings = slices.DeleteFunc(bindings, func(b *iampb.Binding) bool {
+		return b.Role == role && len(b.Members) == 0
+	})
+	return bindings, changes
+}
+
+// changeIAMMembers3 is like changeIAMMembers, but reads and writes version 3
+// policies, which preserves conditional bindings, and adds members under the
+// given condition.
+func changeIAMMembers3(ctx context.Context, h *iam.Handle3, resource, role string,
+	members []string, add, dryRun bool, cond *expr.Expr,
+) ([]*IAMChange, error) {
+	var changes []*IAMChange
+
+	if err := iamRetry(ctx, func(ctx context.Context) error {
+		p, err := h.Policy(ctx)
+		if err != nil {
+			return err
+		}
+		p.Bindings, changes = changeIAMBindings(p.Bindings, resource, role, members, add, cond)
+
+		if dryRun {
+			return nil
+		}
+		return h.SetPolicy(ctx, p)
+	}); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// iamBindings returns the bindings for the role, sorted with the unconditional
+// binding first and the others by condition title and expression.
+func iamBindings(bindings []*iampb.Binding, role string) []*IAMBinding {
+	result := make([]*IAMBinding, 0, len(bindings))
+	for _, b := range bindings {
+		if b.Role != role || len(b.Members) == 0 {
+			continue
+		}
+		members := slices.Clone(b.Members)
+		sort.Strings(members)
+		result = append(result, &IAMBinding{
+			Role:      b.Role,
+			Members:   members,
+			Condition: iamConditionFromExpr(b.Condition),
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i].Condition, result[j].Condition
+		if a == nil || b == nil {
+			return a == nil && b != nil
+		}
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return a.Expression < b.Expression
+	})
+	return result
+}
+
+// SecretManagerBindingsRequest is used as input to list the IAM bindings that
+// give access to a secret in Secret Manager.
+type SecretManagerBindingsRequest struct {
+	// Project is the ID or number of the project where secrets live.
+	Project string
+
+	// Name is the name of the secret.
+	Name string
+
+	// Scope is the resource whose policy is read. With
+	// SecretManagerScopeProject, the project's policy is read and Name must be
+	// empty. Defaults to SecretManagerScopeSecret.
+	Scope SecretManagerScope
+}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerBindingsRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	v.secretManagerScope("Scope", "Name", r.Scope, r.Name)
+	return v.err()
+}
+
+// Bindings returns the bindings of roles/secretmanager.secretAccessor on the
+// secret's (or project's) IAM policy, including conditional bindings with the
+// reason and expiry recorded by Grant.
+func (c *Client) Bindings(ctx context.Context, i *SecretManagerBindingsRequest) ([]*IAMBinding, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	logger := logging.FromContext(ctx).With(
+		"project", i.Project,
+		"name", i.Name,
+		"scope", i.Scope,
+	)
+
+	logger.DebugContext(ctx, "bindings.start")
+	defer logger.DebugContext(ctx, "bindings.finish")
+
+	if i.Scope == SecretManagerScopeProject {
+		bindings, err := c.projectIAMBindings(ctx, i.Project)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get project IAM policy for %s: %w", i.Project, err)
+		}
+		return iamBindings(bindings, iamSecretManagerAccessor), nil
+	}
+
+	var policy *iam.Policy3
+	h := c.secretManagerIAM(i.Project, i.Name).V3()
+	if err := iamRetry(ctx, func(ctx context.Context) error {
+		p, err := h.Policy(ctx)
+		if err != nil {
+			return err
+		}
+		policy = p
+		return nil
+	}); err != nil {
+		if grpcstatus.Code(err) == grpccodes.NotFound {
+			return nil, secretManagerError(ErrSecretDoesNotExist, i.Project, i.Name)
+		}
+		return nil, fmt.Errorf("failed to get Secret Manager IAM policy for %s: %w", i.Name, err)
+	}
+	return iamBindings(policy.Bindings, iamSecretManagerAccessor), nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"google.golang.org/genproto/googleapis/type/expr"
+)
+
+func TestIAMAuditCondition(t *testing.T) {
+	t.Parallel()
+
+	expires := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if act := iamAuditCondition("", time.Time{}); act != nil {
+		t.Errorf("expected %v to be nil", act)
+	}
+
+	cases := []struct {
+		name       string
+		reason     string
+		title      string
+		expression string
+	}{
+		{
+			name:       "reason",
+			reason:     "JIRA-123",
+			title:      "berglas JIRA-123",
+			expression: `request.time < timestamp("2025-01-01T00:00:00Z")`,
+		},
+		{
+			name:       "expiry_only",
+			title:      "berglas grant",
+			expression: `request.time < timestamp("2025-01-01T00:00:00Z")`,
+		},
+		{
+			name:       "long_reason",
+			reason:     strings.Repeat("a", 150),
+			title:      "berglas " + strings.Repeat("a", 92),
+			expression: `request.time < timestamp("2025-01-01T00:00:00Z")`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			e := iamAuditCondition(tc.reason, expires)
+			if act, exp := e.Title, tc.title; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+			if act, exp := e.Expression, tc.expression; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+
+			// The reason and expiry round-trip through the policy
+			cond := iamConditionFromExpr(e)
+			if act, exp := cond.Reason, tc.reason; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+			if act, exp := cond.Expires, expires; !act.Equal(exp) {
+				t.Errorf("expected %s to be %s", act, exp)
+			}
+			if !cond.Expired(expires) || cond.Expired(expires.Add(-time.Second)) {
+				t.Errorf("expected condition to expire at %s", expires)
+			}
+		})
+	}
+}
+
+func TestIAMConditionFromExpr_foreign(t *testing.T) {
+	t.Parallel()
+
+	cond := iamConditionFromExpr(&expr.Expr{
+		Title:       "temporary",
+		Description: "Reason: not from berglas",
+		Expression:  `request.time < timestamp("2030-06-01T12:00:00Z") && resource.name.startsWith("x")`,
+	})
+	if act := cond.Reason; act != "" {
+		t.Errorf("expected %q to be empty", act)
+	}
+	if act, exp := cond.Expires, time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC); !act.Equal(exp) {
+		t.Errorf("expected %s to be %s", act, exp)
+	}
+}
+
+func TestChangeIAMBindings(t *testing.T) {
+	t.Parallel()
+
+	const role = iamSecretManagerAccessor
+	audit := iamAuditCondition("JIRA-123", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	foreign := &expr.Expr{Title: "other", Expression: `resource.name.endsWith("x")`}
+
+	bindings := []*iampb.Binding{
+		{Role: role, Members: []string{"user:a@example.com"}},
+		{Role: role, Members: []string{"user:a@example.com"}, Condition: foreign},
+		{Role: "roles/owner", Members: []string{"user:a@example.com"}},
+	}
+
+	// Granting with a condition adds a conditional binding, even for members
+	// with unconditional access
+	bindings, changes := changeIAMBindings(bindings, "r", role,
+		[]string{"user:a@example.com", "user:b@example.com"}, true, audit)
+	for _, c := range changes {
+		if act, exp := c.Action, IAMActionAdded; act != exp {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+	}
+	if act, exp := len(bindings), 4; act != exp {
+		t.Fatalf("expected %d to be %d", act, exp)
+	}
+
+	// Granting again is unchanged
+	bindings, changes = changeIAMBindings(bindings, "r", role,
+		[]string{"user:b@example.com"}, true, iamAuditCondition("JIRA-123", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+	if act, exp := changes[0].Action, IAMActionUnchanged; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	// Revoking removes the unconditional and audit bindings, but preserves
+	// other conditions and roles
+	bindings, changes = changeIAMBindings(bindings, "r", role,
+		[]string{"user:a@example.com", "user:b@example.com", "user:c@example.com"}, false, nil)
+	var actions []IAMAction
+	for _, c := range changes {
+		actions = append(actions, c.Action)
+	}
+	if exp := []IAMAction{IAMActionRemoved, IAMActionRemoved, IAMActionUnchanged}; !reflect.DeepEqual(actions, exp) {
+		t.Errorf("expected %q to be %q", actions, exp)
+	}
+
+	exp := []*iampb.Binding{
+		{Role: role, Members: []string{"user:a@example.com"}, Condition: foreign},
+		{Role: "roles/owner", Members: []string{"user:a@example.com"}},
+	}
+	if !reflect.DeepEqual(bindings, exp) {
+		t.Errorf("expected %v to be %v", bindings, exp)
+	}
+}
+
+func TestIAMBindings(t *testing.T) {
+	t.Parallel()
+
+	const role = iamSecretManagerAccessor
+	audit := iamAuditCondition("JIRA-123", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	result := iamBindings([]*iampb.Binding{
+		{Role: role, Members: []string{"user:c@example.com"}, Condition: audit},
+		{Role: "roles/owner", Members: []string{"user:x@example.com"}},
+		{Role: role, Members: []string{"user:b@example.com", "user:a@example.com"}},
+	}, role)
+
+	if act, exp := len(result), 2; act != exp {
+		t.Fatalf("expected %d to be %d", act, exp)
+	}
+	if result[0].Condition != nil {
+		t.Errorf("expected unconditional binding first")
+	}
+	if act, exp := result[0].Members, []string{"user:a@example.com", "user:b@example.com"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+	if act, exp := result[1].Condition.Reason, "JIRA-123"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
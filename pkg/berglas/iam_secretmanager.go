@@ -21,12 +21,39 @@ import (
 	"cloud.google.com/go/iam"
 	"cloud.google.com/go/iam/apiv1/iampb"
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/genproto/googleapis/type/expr"
 )
 
 const (
 	iamSecretManagerAccessor = "roles/secretmanager.secretAccessor"
 )
 
+// SecretManagerScope is the resource a Secret Manager grant or revoke applies
+// to.
+type SecretManagerScope string
+
+const (
+	// SecretManagerScopeSecret applies the change to a single secret. This is
+	// the default.
+	SecretManagerScopeSecret SecretManagerScope = "secret"
+
+	// SecretManagerScopeProject applies the change to the project, which gives
+	// access to every secret in it.
+	SecretManagerScopeProject SecretManagerScope = "project"
+)
+
+// IsSecretManagerScope returns true if the given string is a supported
+// SecretManagerScope.
+func IsSecretManagerScope(s string) bool {
+	switch SecretManagerScope(s) {
+	case SecretManagerScopeSecret, SecretManagerScopeProject:
+		return true
+	default:
+		return false
+	}
+}
+
 // secretManagerIAM returns an IAM storage handle to the given secret since one
 // does not exist in the secrets library.
 func (c *Client) secretManagerIAM(project, name string) *iam.Handle {
@@ -71,3 +98,128 @@ func (c *secretManagerIAMClient) Test(ctx context.Context, resource string, perm
 	}
 	return list.Permissions, nil
 }
+
+// resourceManagerService returns a Resource Manager client, which is created
+// on demand since only project-level operations need it.
+func (c *Client) resourceManagerService(ctx context.Context) (*cloudresourcemanager.Service, error) {
+	svc, err := cloudresourcemanager.NewService(ctx, serviceOptions(c.opts, serviceResourceManager)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource manager client: %w", err)
+	}
+	return svc, nil
+}
+
+// changeProjectIAMMembers adds (or removes) the role for each member on the
+// project's IAM policy as described by changeIAMBindings, returning the effect
+// on each member. If dryRun is true, the policy is read but not written.
+func (c *Client) changeProjectIAMMembers(ctx context.Context, project, role string,
+	members []string, add, dryRun bool, cond *expr.Expr,
+) ([]*IAMChange, error) {
+	svc, err := c.resourceManagerService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resource := "projects/" + project
+
+	var changes []*IAMChange
+	if err := iamRetry(ctx, func(ctx context.Context) error {
+		p, err := getProjectIAMPolicy(ctx, svc, resource)
+		if err != nil {
+			return err
+		}
+
+		var bindings []*iampb.Binding
+		bindings, changes = changeIAMBindings(iamFromProjectBindings(p.Bindings), resource, role,
+			members, add, cond)
+		p.Bindings = iamToProjectBindings(bindings)
+
+		if dryRun {
+			return nil
+		}
+
+		_, err = svc.Projects.
+			SetIamPolicy(resource, &cloudresourcemanager.SetIamPolicyRequest{
+				Policy: p,
+			}).
+			Context(ctx).
+			Do()
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// projectIAMBindings returns the bindings of the project's IAM policy.
+func (c *Client) projectIAMBindings(ctx context.Context, project string) ([]*iampb.Binding, error) {
+	svc, err := c.resourceManagerService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var bindings []*iampb.Binding
+	if err := iamRetry(ctx, func(ctx context.Context) error {
+		p, err := getProjectIAMPolicy(ctx, svc, "projects/"+project)
+		if err != nil {
+			return err
+		}
+		bindings = iamFromProjectBindings(p.Bindings)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+// getProjectIAMPolicy reads the version 3 IAM policy of the project, which
+// includes conditional bindings.
+func getProjectIAMPolicy(ctx context.Context, svc *cloudresourcemanager.Service, resource string) (*cloudresourcemanager.Policy, error) {
+	return svc.Projects.
+		GetIamPolicy(resource, &cloudresourcemanager.GetIamPolicyRequest{
+			Options: &cloudresourcemanager.GetPolicyOptions{
+				RequestedPolicyVersion: 3,
+			},
+		}).
+		Context(ctx).
+		Do()
+}
+
+func iamFromProjectBindings(rbs []*cloudresourcemanager.Binding) []*iampb.Binding {
+	ibs := make([]*iampb.Binding, 0, len(rbs))
+	for _, rb := range rbs {
+		ib := &iampb.Binding{
+			Role:    rb.Role,
+			Members: rb.Members,
+		}
+		if rb.Condition != nil {
+			ib.Condition = &expr.Expr{
+				Title:       rb.Condition.Title,
+				Description: rb.Condition.Description,
+				Expression:  rb.Condition.Expression,
+				Location:    rb.Condition.Location,
+			}
+		}
+		ibs = append(ibs, ib)
+	}
+	return ibs
+}
+
+func iamToProjectBindings(ibs []*iampb.Binding) []*cloudresourcemanager.Binding {
+	rbs := make([]*cloudresourcemanager.Binding, 0, len(ibs))
+	for _, ib := range ibs {
+		rb := &cloudresourcemanager.Binding{
+			Role:    ib.Role,
+			Members: ib.Members,
+		}
+		if ib.Condition != nil {
+			rb.Condition = &cloudresourcemanager.Expr{
+				Title:       ib.Condition.Title,
+				Description: ib.Condition.Description,
+				Expression:  ib.Condition.Expression,
+				Location:    ib.Condition.Location,
+			}
+		}
+		rbs = append(rbs, rb)
+	}
+	return rbs
+}
@@ -16,37 +16,49 @@ package berglas
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/iam"
 	"cloud.google.com/go/iam/apiv1/iampb"
-	"github.com/GoogleCloudPlatform/berglas/v2/internal/version"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
 	"github.com/sethvargo/go-retry"
 	"google.golang.org/api/googleapi"
 	storagev1 "google.golang.org/api/storage/v1"
+	"google.golang.org/genproto/googleapis/type/expr"
 	grpccodes "google.golang.org/grpc/codes"
 	grpcstatus "google.golang.org/grpc/status"
 )
 
 const (
 	iamObjectReader = "roles/storage.legacyObjectReader"
+	iamObjectViewer = "roles/storage.objectViewer"
 	iamKMSDecrypt   = "roles/cloudkms.cryptoKeyDecrypter"
+
+	// iamConditionTitleMaxLength is the maximum length of an IAM condition
+	// title.
+	iamConditionTitleMaxLength = 100
 )
 
 // storageIAM returns an IAM storage handle to the given object since one does
 // not exist in the storage library.
 func (c *Client) storageIAM(bucket, object string) *iam.Handle {
 	return iam.InternalNewHandleClient(&storageIAMClient{
-		raw: c.storageIAMClient,
+		raw:       c.storageIAMClient,
+		userAgent: userAgent(c.userAgentSuffix),
 	}, bucket+"/"+object)
 }
 
 // storageIAMClient implements the iam.client interface.
 type storageIAMClient struct {
-	raw *storagev1.Service
+	raw       *storagev1.Service
+	userAgent string
 }
 
 func (c *storageIAMClient) Get(ctx context.Context, resource string) (*iampb.Policy, error) {
@@ -61,7 +73,7 @@ func (c *storageIAMClient) GetWithVersion(ctx context.Context, resource string,
 
 	// Note: Object-level IAM does not support versioned IAM policies at present.
 	call := c.raw.Objects.GetIamPolicy(bucket, object)
-	setClientHeader(call.Header())
+	c.setClientHeader(call.Header())
 
 	rp, err := call.Context(ctx).Do()
 	if err != nil {
@@ -78,7 +90,7 @@ func (c *storageIAMClient) Set(ctx context.Context, resource string, p *iampb.Po
 
 	rp := iamToStoragePolicy(p)
 	call := c.raw.Objects.SetIamPolicy(bucket, object, rp)
-	setClientHeader(call.Header())
+	c.setClientHeader(call.Header())
 
 	if _, err := call.Context(ctx).Do(); err != nil {
 		return err
@@ -93,7 +105,7 @@ func (c *storageIAMClient) Test(ctx context.Context, resource string, perms []st
 	}
 
 	call := c.raw.Objects.TestIamPermissions(bucket, object, perms)
-	setClientHeader(call.Header())
+	c.setClientHeader(call.Header())
 
 	res, err := call.Context(ctx).Do()
 	if err != nil {
@@ -150,8 +162,8 @@ func iamFromStorageBindings(rbs []*storagev1.PolicyBindings) []*iampb.Binding {
 	return ibs
 }
 
-func setClientHeader(h http.Header) {
-	h.Set("User-Agent", version.UserAgent)
+func (c *storageIAMClient) setClientHeader(h http.Header) {
+	h.Set("User-Agent", c.userAgent)
 }
 
 // getIAMPolicy fetches the IAM policy for the given resource handle, handling
@@ -211,11 +223,407 @@ func iamRetry(ctx context.Context, f retry.RetryFunc) error {
 			return retry.RetryableError(err)
 		}
 
-		// IAM returns 412 while propagating, also retry on server errors
-		if terr, ok := err.(*googleapi.Error); ok && (terr.Code == 412 || terr.Code >= 500) {
+		// IAM returns 412 while propagating and 409 on concurrent policy
+		// changes, also retry on server errors
+		if terr, ok := err.(*googleapi.Error); ok && (terr.Code == 409 || terr.Code == 412 || terr.Code >= 500) {
 			return retry.RetryableError(err)
 		}
 
 		return err
 	})
 }
+
+// IAMAction is the effect of a grant or revoke on a single member.
+type IAMAction string
+
+const (
+	// IAMActionAdded indicates the member was granted the role.
+	IAMActionAdded IAMAction = "added"
+
+	// IAMActionRemoved indicates the role was revoked from the member.
+	IAMActionRemoved IAMAction = "removed"
+
+	// IAMActionUnchanged indicates the member already had the role (for grants)
+	// or did not have the role (for revokes), so no change was needed.
+	IAMActionUnchanged IAMAction = "unchanged"
+)
+
+// IAMChange describes the effect of a grant or revoke on a single member of a
+// single resource.
+type IAMChange struct {
+	// Resource is the name of the resource whose policy was changed.
+	Resource string
+
+	// Role is the IAM role that was granted or revoked.
+	Role string
+
+	// Member is the IAM member.
+	Member string
+
+	// Action is the effect on the member. In dry-run mode, this is the effect
+	// that would have happened.
+	Action IAMAction
+}
+
+// IAMWarningKind identifies the kind of an IAMWarning.
+type IAMWarningKind string
+
+const (
+	// IAMWarningACLFallback indicates that object IAM policies are not supported
+	// on the bucket, so access was changed with object ACLs instead.
+	IAMWarningACLFallback IAMWarningKind = "acl_fallback"
+
+	// IAMWarningExternalKey indicates that the secret is encrypted with a key
+	// outside of Cloud KMS, so access to the key must be managed separately.
+	IAMWarningExternalKey IAMWarningKind = "external_key"
+)
+
+// IAMWarning is a non-fatal problem encountered during a grant or revoke.
+type IAMWarning struct {
+	// Kind is the kind of warning.
+	Kind IAMWarningKind
+
+	// Resource is the name of the affected resource.
+	Resource string
+
+	// Message describes the warning.
+	Message string
+}
+
+// IAMResult is the result of a grant or revoke.
+type IAMResult struct {
+	// DryRun indicates no changes were written.
+	DryRun bool
+
+	// Changes is the list of per-resource, per-member changes.
+	Changes []*IAMChange
+
+	// Warnings are non-fatal problems encountered while making the changes.
+	Warnings []*IAMWarning
+}
+
+// changeIAMMembers adds (or removes) the role for each member on the policy of
+// the given handle, returning the effect on each member. If dryRun is true,
+// the policy is read but not written.
+func changeIAMMembers(ctx context.Context, h *iam.Handle, resource, role string,
+	members []string, add, dryRun bool,
+) ([]*IAMChange, error) {
+	var changes []*IAMChange
+
+	// compute records the changes against the given policy, resetting any
+	// previous results since updates may be retried.
+	compute := func(p *iam.Policy) *iam.Policy {
+		changes = make([]*IAMChange, 0, len(members))
+		for _, m := range members {
+			action := IAMActionUnchanged
+			if has := p.HasRole(m, iam.RoleName(role)); add && !has {
+				action = IAMActionAdded
+				p.Add(m, iam.RoleName(role))
+			} else if !add && has {
+				action = IAMActionRemoved
+				p.Remove(m, iam.RoleName(role))
+			}
+
+			changes = append(changes, &IAMChange{
+				Resource: resource,
+				Role:     role,
+				Member:   m,
+				Action:   action,
+			})
+		}
+		return p
+	}
+
+	if dryRun {
+		p, err := getIAMPolicy(ctx, h)
+		if err != nil {
+			return nil, err
+		}
+		compute(p)
+		return changes, nil
+	}
+
+	if err := updateIAMPolicy(ctx, h, compute); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// changeStorageObjectMembers grants (or revokes) read access to the given
+// object. On buckets with uniform bucket-level access, object policies are not
+// available, so access is managed with a bucket-level objectViewer binding
+// conditioned on the object name instead. Legacy roles do not support
+// conditions.
+func (c *Client) changeStorageObjectMembers(ctx context.Context, bucket, object string,
+	members []string, add, dryRun bool,
+) ([]*IAMChange, error) {
+	resource := bucket + "/" + object
+
+	changes, err := changeIAMMembers(ctx, c.storageIAM(bucket, object), resource, iamObjectReader,
+		members, add, dryRun)
+	if err == nil {
+		return changes, nil
+	}
+	if !c.isStorageUniformAccessErr(ctx, bucket, err) {
+		if isStorageObjectIAMUnavailableErr(err) {
+			return nil, fmt.Errorf("%w: %w", ErrObjectIAMUnavailable, err)
+		}
+		return nil, err
+	}
+
+	logging.FromContext(ctx).DebugContext(ctx, "bucket uses uniform bucket-level access, using conditional binding")
+
+	changes, err = c.changeStorageConditionalMembers(ctx, bucket, object, members, add, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("bucket %s uses uniform bucket-level access, and updating its "+
+			"IAM policy with a conditional binding failed (this requires "+
+			"storage.buckets.setIamPolicy on the bucket): %w", bucket, err)
+	}
+	return changes, nil
+}
+
+// storageObjectMembers returns the members with read access to the given
+// object, reading the conditional bucket-level binding if the bucket uses
+// uniform bucket-level access.
+func (c *Client) storageObjectMembers(ctx context.Context, bucket, object string) ([]string, error) {
+	policy, err := getIAMPolicy(ctx, c.storageIAM(bucket, object))
+	if err == nil {
+		return policy.Members(iamObjectReader), nil
+	}
+	if !c.isStorageUniformAccessErr(ctx, bucket, err) {
+		return nil, err
+	}
+
+	var policy3 *iam.Policy3
+	h := c.storageClient.Bucket(bucket).IAM().V3()
+	if err := iamRetry(ctx, func(ctx context.Context) error {
+		p, err := h.Policy(ctx)
+		if err != nil {
+			return err
+		}
+		policy3 = p
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if b := storageConditionalBinding(policy3, bucket, object); b != nil {
+		return slices.Clone(b.Members), nil
+	}
+	return nil, nil
+}
+
+// isStorageUniformAccessErr reports whether the given error from an object IAM
+// call was caused by uniform bucket-level access being enabled on the bucket.
+func (c *Client) isStorageUniformAccessErr(ctx context.Context, bucket string, err error) bool {
+	var terr *googleapi.Error
+	if !errors.As(err, &terr) || terr.Code != http.StatusBadRequest {
+		return false
+	}
+
+	attrs, aerr := c.storageClient.Bucket(bucket).Attrs(ctx)
+	if aerr != nil {
+		// The caller may not be able to read bucket metadata, so fall back to the
+		// error message.
+		return strings.Contains(strings.ToLower(terr.Message), "uniform bucket-level access")
+	}
+	return attrs.UniformBucketLevelAccess.Enabled
+}
+
+// isStorageObjectIAMUnavailableErr reports whether the given error from an
+// object IAM call means the bucket does not support object IAM policies, which
+// happens on some older fine-grained buckets. Uniform bucket-level access must
+// be ruled out first since it fails the same way.
+func isStorageObjectIAMUnavailableErr(err error) bool {
+	var terr *googleapi.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+	return terr.Code == http.StatusBadRequest || terr.Code == http.StatusNotImplemented
+}
+
+// changeStorageACLMembers adds (or removes) a READER object ACL entry for each
+// member, returning the effect on each member. It is the fallback for buckets
+// that do not support object IAM policies. If dryRun is true, the ACL is read
+// but not written.
+func (c *Client) changeStorageACLMembers(ctx context.Context, bucket, object string,
+	members []string, add, dryRun bool,
+) ([]*IAMChange, error) {
+	resource := bucket + "/" + object
+
+	entities := make([]storage.ACLEntity, 0, len(members))
+	for _, m := range members {
+		entity, err := storageACLEntity(m)
+		if err != nil {
+			return nil, err
+		}
+		entities = append(entities, entity)
+	}
+
+	acl := c.storageClient.Bucket(bucket).Object(object).ACL()
+	rules, err := acl.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list object acl: %w", err)
+	}
+
+	existing := make(map[storage.ACLEntity]storage.ACLRole, len(rules))
+	for _, r := range rules {
+		existing[r.Entity] = r.Role
+	}
+
+	changes := make([]*IAMChange, 0, len(members))
+	for i, m := range members {
+		entity := entities[i]
+		role, has := existing[entity]
+
+		action := IAMActionUnchanged
+		switch {
+		case add && !has:
+			action = IAMActionAdded
+			if !dryRun {
+				if err := acl.Set(ctx, entity, storage.RoleReader); err != nil {
+					return nil, fmt.Errorf("failed to add %s to object acl: %w", entity, err)
+				}
+			}
+		case !add && has && role == storage.RoleReader:
+			action = IAMActionRemoved
+			if !dryRun {
+				if err := acl.Delete(ctx, entity); err != nil {
+					return nil, fmt.Errorf("failed to remove %s from object acl: %w", entity, err)
+				}
+			}
+		}
+
+		changes = append(changes, &IAMChange{
+			Resource: resource,
+			Role:     string(storage.RoleReader),
+			Member:   m,
+			Action:   action,
+		})
+	}
+	return changes, nil
+}
+
+// storageACLEntity converts an IAM member into the equivalent Cloud Storage
+// ACL entity. Members with no ACL equivalent, such as workload identity
+// principals, return an error.
+func storageACLEntity(member string) (storage.ACLEntity, error) {
+	switch member {
+	case "allUsers":
+		return storage.AllUsers, nil
+	case "allAuthenticatedUsers":
+		return storage.AllAuthenticatedUsers, nil
+	}
+
+	typ, id, _ := strings.Cut(member, ":")
+	switch typ {
+	case "user", "serviceAccount":
+		return storage.ACLEntity("user-" + id), nil
+	case "group":
+		return storage.ACLEntity("group-" + id), nil
+	case "domain":
+		return storage.ACLEntity("domain-" + id), nil
+	default:
+		return "", fmt.Errorf("member %q cannot be granted with an object acl", member)
+	}
+}
+
+// changeStorageConditionalMembers adds (or removes) each member to the
+// bucket-level objectViewer binding conditioned on the given object, returning
+// the effect on each member. If dryRun is true, the policy is read but not
+// written.
+func (c *Client) changeStorageConditionalMembers(ctx context.Context, bucket, object string,
+	members []string, add, dryRun bool,
+) ([]*IAMChange, error) {
+	h := c.storageClient.Bucket(bucket).IAM().V3()
+	resource := bucket + "/" + object
+
+	var changes []*IAMChange
+
+	// compute records the changes against the given policy, resetting any
+	// previous results since updates may be retried.
+	compute := func(p *iam.Policy3) {
+		changes = make([]*IAMChange, 0, len(members))
+
+		binding := storageConditionalBinding(p, bucket, object)
+		for _, m := range members {
+			has := binding != nil && slices.Contains(binding.Members, m)
+
+			action := IAMActionUnchanged
+			if add && !has {
+				action = IAMActionAdded
+				if binding == nil {
+					binding = &iampb.Binding{
+						Role:      iamObjectViewer,
+						Condition: storageObjectCondition(bucket, object),
+					}
+					p.Bindings = append(p.Bindings, binding)
+				}
+				binding.Members = append(binding.Members, m)
+			} else if !add && has {
+				action = IAMActionRemoved
+				binding.Members = slices.DeleteFunc(binding.Members, func(s string) bool {
+					return s == m
+				})
+			}
+
+			changes = append(changes, &IAMChange{
+				Resource: resource,
+				Role:     iamObjectViewer,
+				Member:   m,
+				Action:   action,
+			})
+		}
+
+		// Empty bindings are rejected.
+		if binding != nil && len(binding.Members) == 0 {
+			p.Bindings = slices.DeleteFunc(p.Bindings, func(b *iampb.Binding) bool {
+				return b == binding
+			})
+		}
+	}
+
+	if err := iamRetry(ctx, func(ctx context.Context) error {
+		p, err := h.Policy(ctx)
+		if err != nil {
+			return err
+		}
+		compute(p)
+
+		if dryRun {
+			return nil
+		}
+		return h.SetPolicy(ctx, p)
+	}); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// storageConditionalBinding returns the bucket-level objectViewer binding
+// conditioned on the given object, or nil if none exists.
+func storageConditionalBinding(p *iam.Policy3, bucket, object string) *iampb.Binding {
+	expression := storageObjectCondition(bucket, object).Expression
+	for _, b := range p.Bindings {
+		if b.Role == iamObjectViewer && b.Condition != nil && b.Condition.Expression == expression {
+			return b
+		}
+	}
+	return nil
+}
+
+// storageObjectCondition returns the IAM condition that limits a bucket-level
+// binding to the given object.
+func storageObjectCondition(bucket, object string) *expr.Expr {
+	title := "berglas " + object
+	if len(title) > iamConditionTitleMaxLength {
+		title = title[:iamConditionTitleMaxLength]
+	}
+
+	name := fmt.Sprintf("projects/_/buckets/%s/objects/%s", bucket, object)
+	return &expr.Expr{
+		Title:       title,
+		Description: fmt.Sprintf("Read access to the berglas secret %s/%s", bucket, object),
+		Expression:  fmt.Sprintf("resource.name == %s", strconv.Quote(name)),
+	}
+}
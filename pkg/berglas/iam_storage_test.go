@@ -0,0 +1,250 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/iam"
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/berglas/v2/internal/version"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+func TestClient_storageIAM_userAgent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	userAgents := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		userAgents[r.Method+" "+r.URL.Path] = r.Header.Get("User-Agent")
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/testPermissions") {
+			fmt.Fprint(w, `{"permissions": ["storage.objects.get"]}`)
+			return
+		}
+		fmt.Fprint(w, `{"bindings": []}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := New(ctx,
+		option.WithoutAuthentication(),
+		WithStorageEndpoint(srv.URL+"/storage/v1/"),
+		WithSecretManagerEndpoint("127.0.0.1:1"),
+		WithKMSEndpoint("127.0.0.1:1"),
+		WithUserAgentSuffix("my-service/1.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := client.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	h := client.storageIAM("my-bucket", "my-object")
+	policy, err := h.Policy(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetPolicy(ctx, policy); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.TestPermissions(ctx, []string{"storage.objects.get"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if act, exp := len(userAgents), 3; act != exp {
+		t.Fatalf("expected %d requests, got %d: %v", exp, act, userAgents)
+	}
+	for req, ua := range userAgents {
+		if exp := version.UserAgent + " my-service/1.2"; !strings.HasPrefix(ua, exp) {
+			t.Errorf("expected %s user agent %q to start with %q", req, ua, exp)
+		}
+	}
+}
+
+func TestStorageObjectCondition(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		bucket     string
+		object     string
+		title      string
+		expression string
+	}{
+		{
+			name:       "simple",
+			bucket:     "my-bucket",
+			object:     "api-key",
+			title:      "berglas api-key",
+			expression: `resource.name == "projects/_/buckets/my-bucket/objects/api-key"`,
+		},
+		{
+			name:       "quoted",
+			bucket:     "my-bucket",
+			object:     `foo/"bar"`,
+			title:      `berglas foo/"bar"`,
+			expression: `resource.name == "projects/_/buckets/my-bucket/objects/foo/\"bar\""`,
+		},
+		{
+			name:       "long_title",
+			bucket:     "my-bucket",
+			object:     strings.Repeat("a", 200),
+			title:      "berglas " + strings.Repeat("a", 92),
+			expression: `resource.name == "projects/_/buckets/my-bucket/objects/` + strings.Repeat("a", 200) + `"`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cond := storageObjectCondition(tc.bucket, tc.object)
+			if act, exp := cond.Title, tc.title; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+			if act, exp := cond.Expression, tc.expression; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
+
+func TestStorageConditionalBinding(t *testing.T) {
+	t.Parallel()
+
+	match := &iampb.Binding{
+		Role:      iamObjectViewer,
+		Members:   []string{"user:a@example.com"},
+		Condition: storageObjectCondition("my-bucket", "api-key"),
+	}
+
+	policy := &iam.Policy3{
+		Bindings: []*iampb.Binding{
+			{
+				Role:    iamObjectViewer,
+				Members: []string{"user:b@example.com"},
+			},
+			{
+				Role:      iamObjectViewer,
+				Members:   []string{"user:c@example.com"},
+				Condition: storageObjectCondition("my-bucket", "other"),
+			},
+			match,
+		},
+	}
+
+	if act, exp := storageConditionalBinding(policy, "my-bucket", "api-key"), match; act != exp {
+		t.Errorf("expected %v to be %v", act, exp)
+	}
+	if act := storageConditionalBinding(policy, "my-bucket", "missing"); act != nil {
+		t.Errorf("expected %v to be nil", act)
+	}
+}
+
+func TestStorageACLEntity(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		member string
+		exp    storage.ACLEntity
+		err    bool
+	}{
+		{
+			name:   "user",
+			member: "user:jane@example.com",
+			exp:    "user-jane@example.com",
+		},
+		{
+			name:   "service_account",
+			member: "serviceAccount:app@my-project.iam.gserviceaccount.com",
+			exp:    "user-app@my-project.iam.gserviceaccount.com",
+		},
+		{
+			name:   "group",
+			member: "group:admins@example.com",
+			exp:    "group-admins@example.com",
+		},
+		{
+			name:   "domain",
+			member: "domain:example.com",
+			exp:    "domain-example.com",
+		},
+		{
+			name:   "all_users",
+			member: "allUsers",
+			exp:    storage.AllUsers,
+		},
+		{
+			name:   "principal",
+			member: "principal://iam.googleapis.com/locations/global/workforcePools/p/subject/s",
+			err:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			act, err := storageACLEntity(tc.member)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if act != tc.exp {
+				t.Errorf("expected %q to be %q", act, tc.exp)
+			}
+		})
+	}
+}
+
+func TestIsStorageObjectIAMUnavailableErr(t *testing.T) {
+	t.Parallel()
+
+	if !isStorageObjectIAMUnavailableErr(&googleapi.Error{Code: http.StatusBadRequest}) {
+		t.Errorf("expected bad request to be unavailable")
+	}
+	if isStorageObjectIAMUnavailableErr(&googleapi.Error{Code: http.StatusForbidden}) {
+		t.Errorf("expected forbidden not to be unavailable")
+	}
+
+	err := fmt.Errorf("failed: %w", fmt.Errorf("%w: %w", ErrObjectIAMUnavailable, errors.New("boom")))
+	if !IsObjectIAMUnavailableErr(err) {
+		t.Errorf("expected %v to be object iam unavailable", err)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+)
+
+// Interface is the set of Client methods most applications use to manage and
+// resolve secrets. It exists so code can accept either a *Client or a test
+// double, such as the fake in the berglastest package.
+type Interface interface {
+	Access(ctx context.Context, i AccessRequester) ([]byte, error)
+	Read(ctx context.Context, i ReadRequester) (*Secret, error)
+	Create(ctx context.Context, i CreateRequester) (*Secret, error)
+	Update(ctx context.Context, i UpdateRequester) (*Secret, error)
+	Delete(ctx context.Context, i DeleteRequester) error
+	List(ctx context.Context, i ListRequester) (*ListResponse, error)
+	Resolve(ctx context.Context, s string) ([]byte, error)
+	Replace(ctx context.Context, key string) error
+}
+
+// SecretClient is Interface plus the methods that change who may access a
+// secret. Decorators that add metrics or caching around a *Client can
+// implement SecretClient to stand in for it everywhere.
+type SecretClient interface {
+	Interface
+
+	Grant(ctx context.Context, i GrantRequester) error
+	Revoke(ctx context.Context, i RevokeRequester) error
+}
+
+var _ SecretClient = (*Client)(nil)
+
+// AccessRequester is any request accepted by Access: a
+// SecretManagerAccessRequest, StorageAccessRequest, or StorageS3AccessRequest.
+type AccessRequester = accessRequest
+
+// ReadRequester is any request accepted by Read: a SecretManagerReadRequest,
+// StorageReadRequest, or StorageS3ReadRequest.
+type ReadRequester = readRequest
+
+// CreateRequester is any request accepted by Create: a
+// SecretManagerCreateRequest, StorageCreateRequest, or StorageS3CreateRequest.
+type CreateRequester = createRequest
+
+// UpdateRequester is any request accepted by Update: a
+// SecretManagerUpdateRequest, StorageUpdateRequest, or StorageS3UpdateRequest.
+type UpdateRequester = updateRequest
+
+// DeleteRequester is any request accepted by Delete: a
+// SecretManagerDeleteRequest or StorageDeleteRequest.
+type DeleteRequester = deleteRequest
+
+// ListRequester is any request accepted by List: a SecretManagerListRequest or
+// StorageListRequest.
+type ListRequester = listRequest
+
+// GrantRequester is any request accepted by Grant: a SecretManagerGrantRequest
+// or StorageGrantRequest.
+type GrantRequester = grantRequest
+
+// RevokeRequester is any request accepted by Revoke: a
+// SecretManagerRevokeRequest or StorageRevokeRequest.
+type RevokeRequester = revokeRequest
+
+// MembersRequester is any request accepted by Members: a
+// SecretManagerMembersRequest or StorageMembersRequest.
+type MembersRequester = membersRequest
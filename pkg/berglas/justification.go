@@ -0,0 +1,37 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// requestReasonHeader is the Google API system parameter that carries the
+// caller-supplied reason for a request.
+const requestReasonHeader = "x-goog-request-reason"
+
+// WithJustification returns a context whose Secret Manager and Cloud KMS
+// requests carry the given justification, such as a change ticket ID, as the
+// request reason (the X-Goog-Request-Reason system parameter). This lets
+// secret access be tied to a ticket in audit tooling. Cloud Storage requests do
+// not carry the justification. An empty justification returns ctx unchanged.
+func WithJustification(ctx context.Context, justification string) context.Context {
+	if justification == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, requestReasonHeader, justification)
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWithJustification(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name          string
+		justification string
+		exp           []string
+	}{
+		{
+			name:          "empty",
+			justification: "",
+			exp:           nil,
+		},
+		{
+			name:          "ticket",
+			justification: "ticket-123",
+			exp:           []string{"ticket-123"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := WithJustification(context.Background(), tc.justification)
+
+			md, _ := metadata.FromOutgoingContext(ctx)
+			if act, exp := md.Get(requestReasonHeader), tc.exp; !reflect.DeepEqual(act, exp) {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/api/option"
+)
+
+// KeySchemeLocal is the key URI scheme of the built-in local key wrapper, such
+// as "local-key:///path/to/key". The file holds a 32-byte AES-256-GCM key as
+// raw bytes or base64. Local keys provide no access control or audit logging
+// and are only intended for development.
+const KeySchemeLocal = "local-key"
+
+// KeyWrapper wraps and unwraps the data encryption keys of Cloud Storage
+// secrets with a key encryption key. Cloud KMS keys, given as resource names
+// such as "projects/p/locations/l/keyRings/r/cryptoKeys/k", are always
+// supported. Other key management systems are selected by the scheme of the
+// key URI and registered with WithKeyWrapper.
+type KeyWrapper interface {
+	// Wrap encrypts the data encryption key with the given key, authenticating
+	// the additional data.
+	Wrap(ctx context.Context, key string, dek, aad []byte) ([]byte, error)
+
+	// Unwrap decrypts a data encryption key previously returned by Wrap with
+	// the same key and additional data.
+	Unwrap(ctx context.Context, key string, wrapped, aad []byte) ([]byte, error)
+}
+
+// WithKeyWrapper returns a client option that wraps the data encryption keys of
+// Cloud Storage secrets whose key is a URI with the given scheme, such as
+// "aws-kms", using w. It replaces any wrapper already registered for the
+// scheme.
+func WithKeyWrapper(scheme string, w KeyWrapper) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		if c.keyWrappers == nil {
+			c.keyWrappers = make(map[string]KeyWrapper)
+		}
+		c.keyWrappers[scheme] = w
+	}}
+}
+
+// keyScheme returns the scheme of a key URI, or the empty string for a Cloud
+// KMS key name.
+func keyScheme(key string) string {
+	scheme, _, ok := strings.Cut(key, "://")
+	if !ok {
+		return ""
+	}
+	return scheme
+}
+
+// isCloudKMSKey returns true if the key is a Cloud KMS key name rather than a
+// URI for another key wrapper. Only Cloud KMS keys support IAM and protection
+// level checks.
+func isCloudKMSKey(key string) bool {
+	return keyScheme(key) == ""
+}
+
+// keyWrapper returns the wrapper for the given key.
+func (c *Client) keyWrapper(key string) (KeyWrapper, error) {
+	scheme := keyScheme(key)
+	if scheme == "" {
+		return &cloudKMSKeyWrapper{client: c.kmsClient}, nil
+	}
+	if w, ok := c.keyWrappers[scheme]; ok {
+		return w, nil
+	}
+	if scheme == KeySchemeLocal {
+		return localKeyWrapper{}, nil
+	}
+	return nil, fmt.Errorf("no key wrapper registered for %q keys", scheme)
+}
+
+// wrapDEK wraps the data encryption key with the given key.
+func (c *Client) wrapDEK(ctx context.Context, key string, dek, aad []byte) ([]byte, error) {
+	w, err := c.keyWrapper(key)
+	if err != nil {
+		return nil, err
+	}
+	return w.Wrap(ctx, key, dek, aad)
+}
+
+// unwrapDEK unwraps the data encryption key with the given key.
+func (c *Client) unwrapDEK(ctx context.Context, key string, wrapped, aad []byte) ([]byte, error) {
+	w, err := c.keyWrapper(key)
+	if err != nil {
+		return nil, err
+	}
+	return w.Unwrap(ctx, key, wrapped, aad)
+}
+
+// cloudKMSKeyWrapper is the default KeyWrapper, backed by Cloud KMS.
+type cloudKMSKeyWrapper struct {
+	client *kms.KeyManagementClient
+}
+
+func (w *cloudKMSKeyWrapper) Wrap(ctx context.Context, key string, dek, aad []byte) ([]byte, error) {
+	resp, err := w.client.Encrypt(ctx, &kmspb.EncryptRequest{
+		Name:                        key,
+		Plaintext:                   dek,
+		AdditionalAuthenticatedData: aad,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+func (w *cloudKMSKeyWrapper) Unwrap(ctx context.Context, key string, wrapped, aad []byte) ([]byte, error) {
+	resp, err := w.client.Decrypt(ctx, &kmspb.DecryptRequest{
+		Name:                        key,
+		Ciphertext:                  wrapped,
+		AdditionalAuthenticatedData: aad,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+// localKeyWrapper wraps data encryption keys with AES-256-GCM using a key read
+// from a local file. It is only intended for development.
+type localKeyWrapper struct{}
+
+func (localKeyWrapper) Wrap(_ context.Context, key string, dek, aad []byte) ([]byte, error) {
+	aead, err := localKeyAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate random nonce bytes: %w", err)
+	}
+	return aead.Seal(nonce, nonce, dek, aad), nil
+}
+
+func (localKeyWrapper) Unwrap(_ context.Context, key string, wrapped, aad []byte) ([]byte, error) {
+	aead, err := localKeyAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	size := aead.NonceSize()
+	if len(wrapped) < size {
+		return nil, fmt.Errorf("malformed wrapped key")
+	}
+	dek, err := aead.Open(nil, wrapped[:size], wrapped[size:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %w", err)
+	}
+	return dek, nil
+}
+
+// localKeyAEAD reads the key file named by a local key URI.
+func localKeyAEAD(key string) (cipher.AEAD, error) {
+	pth := strings.TrimPrefix(key, KeySchemeLocal+"://")
+	if pth == "" {
+		return nil, fmt.Errorf("invalid local key %q: missing path", key)
+	}
+
+	b, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local key: %w", err)
+	}
+	if len(b) != 32 {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(decoded) != 32 {
+			return nil, fmt.Errorf("invalid local key %s: must be 32 bytes, raw or base64", pth)
+		}
+		b = decoded
+	}
+
+	block, err := aes.NewCipher(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher from local key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcm from local key: %w", err)
+	}
+	return aead, nil
+}
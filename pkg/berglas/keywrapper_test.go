@@ -0,0 +1,129 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyScheme(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		key  string
+		exp  string
+	}{
+		{
+			name: "cloud_kms",
+			key:  "projects/p/locations/l/keyRings/kr/cryptoKeys/k",
+			exp:  "",
+		},
+		{
+			name: "aws_kms",
+			key:  "aws-kms://arn:aws:kms:us-east-1:111122223333:key/k",
+			exp:  "aws-kms",
+		},
+		{
+			name: "local",
+			key:  "local-key:///tmp/key",
+			exp:  "local-key",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if act, exp := keyScheme(tc.key), tc.exp; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
+
+func TestClient_keyWrapper(t *testing.T) {
+	t.Parallel()
+
+	custom := localKeyWrapper{}
+	c := &Client{}
+	WithKeyWrapper("custom", custom).(*clientOption).apply(c)
+
+	if _, err := c.keyWrapper("custom://k"); err != nil {
+		t.Errorf("expected registered wrapper: %s", err)
+	}
+	if _, err := c.keyWrapper(KeySchemeLocal + ":///k"); err != nil {
+		t.Errorf("expected built-in local wrapper: %s", err)
+	}
+	if _, err := c.keyWrapper("projects/p/locations/l/keyRings/kr/cryptoKeys/k"); err != nil {
+		t.Errorf("expected cloud kms wrapper: %s", err)
+	}
+	if _, err := c.keyWrapper("unknown://k"); err == nil {
+		t.Errorf("expected error for unregistered scheme")
+	}
+}
+
+func TestLocalKeyWrapper(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	raw := bytes.Repeat([]byte{0x42}, 32)
+	rawPath := filepath.Join(dir, "raw")
+	if err := os.WriteFile(rawPath, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b64Path := filepath.Join(dir, "b64")
+	if err := os.WriteFile(b64Path, []byte(base64.StdEncoding.EncodeToString(raw)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	shortPath := filepath.Join(dir, "short")
+	if err := os.WriteFile(shortPath, []byte("short"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dek := []byte("0123456789abcdef0123456789abcdef")
+	aad := []byte("bucket/object")
+	w := localKeyWrapper{}
+
+	wrapped, err := w.Wrap(ctx, KeySchemeLocal+"://"+rawPath, dek, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same key in base64 unwraps the same data.
+	unwrapped, err := w.Unwrap(ctx, KeySchemeLocal+"://"+b64Path, wrapped, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped, dek) {
+		t.Errorf("expected %q to be %q", unwrapped, dek)
+	}
+
+	if _, err := w.Unwrap(ctx, KeySchemeLocal+"://"+rawPath, wrapped, []byte("other")); err == nil {
+		t.Errorf("expected error for mismatched aad")
+	}
+	if _, err := w.Wrap(ctx, KeySchemeLocal+"://"+shortPath, dek, aad); err == nil {
+		t.Errorf("expected error for short key")
+	}
+}
@@ -15,22 +15,40 @@
 package berglas
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"path"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"golang.org/x/sync/semaphore"
 	"google.golang.org/api/iterator"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 type listRequest interface {
 	isListRequest()
+	Validate() error
 }
 
+const (
+	// StorageStateLive is the State of the live generation of a Cloud Storage
+	// secret when listing generations.
+	StorageStateLive = "LIVE"
+
+	// StorageStateArchived is the State of a noncurrent generation of a Cloud
+	// Storage secret when listing generations.
+	StorageStateArchived = "ARCHIVED"
+)
+
 // StorageListRequest is used as input to list secrets from Cloud Storage.
 type StorageListRequest struct {
 	// Bucket is the name of the bucket where the secrets live.
@@ -45,6 +63,14 @@ type StorageListRequest struct {
 
 func (r *StorageListRequest) isListRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageListRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	return v.err()
+}
+
 // ListRequest is an alias for StorageListRequest for backwards-compatibility.
 // New clients should use StorageListRequest.
 type ListRequest = StorageListRequest
@@ -60,13 +86,73 @@ type SecretManagerListRequest struct {
 
 	// Versions indicates that all versions of secrets should be listed.
 	Versions bool
+
+	// States, if set, limits listed versions to those in one of the given states
+	// (e.g. "ENABLED", "DISABLED", or "DESTROYED"). It only applies when
+	// Versions is true.
+	States []string
+
+	// WithMetadata populates the Size and CRC32C of each listed secret by
+	// accessing its payload, which requires permission to access the secret
+	// values. When Versions is false, the latest version is used. Versions that
+	// are disabled or destroyed are left unpopulated.
+	WithMetadata bool
+
+	// Parallelism is the maximum number of payloads accessed concurrently when
+	// WithMetadata is set. It defaults to DefaultListParallelism.
+	Parallelism int
 }
 
 func (r *SecretManagerListRequest) isListRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerListRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	if r.Parallelism < 0 {
+		v.addf("Parallelism", "parallelism must not be negative")
+	}
+	for _, state := range r.States {
+		if !isSecretVersionState(state) {
+			v.addf("States", "invalid state %q: must be one of %s",
+				state, strings.Join(secretVersionStateNames(), ", "))
+		}
+	}
+	return v.err()
+}
+
+// secretVersionStateNames returns the sorted list of valid Secret Manager
+// version states, excluding the unspecified value.
+func secretVersionStateNames() []string {
+	names := make([]string, 0, len(secretspb.SecretVersion_State_value))
+	for name, v := range secretspb.SecretVersion_State_value {
+		if v == int32(secretspb.SecretVersion_STATE_UNSPECIFIED) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isSecretVersionState returns true if s is a valid, specified Secret Manager
+// version state such as "ENABLED".
+func isSecretVersionState(s string) bool {
+	v, ok := secretspb.SecretVersion_State_value[s]
+	return ok && v != int32(secretspb.SecretVersion_STATE_UNSPECIFIED)
+}
+
+// DefaultListParallelism is the default number of concurrent requests made
+// when listing secrets with metadata.
+const DefaultListParallelism = 8
+
 // ListResponse is the response from a list call.
 type ListResponse struct {
-	// Secrets are the list of secrets in the response.
+	// Secrets are the list of secrets in the response. They are always in the
+	// same order for the same set of secrets: by name in reverse lexical order,
+	// then newest generation or version first. Secret Manager versions are
+	// compared numerically.
 	Secrets []*Secret
 }
 
@@ -83,13 +169,31 @@ func (s secretList) Len() int {
 func (s secretList) Less(i, j int) bool {
 	if s[i].Name == s[j].Name {
 		if s[i].Generation == s[j].Generation {
-			return s[i].Version > s[j].Version
+			return compareVersions(s[i].Version, s[j].Version) > 0
 		}
 		return s[i].Generation > s[j].Generation
 	}
 	return s[i].Name > s[j].Name
 }
 
+// compareVersions compares Secret Manager version IDs numerically, so "10"
+// is greater than "9". Versions that are not numbers, such as aliases, are less
+// than numeric versions and are compared lexically.
+func compareVersions(a, b string) int {
+	an, aErr := strconv.ParseInt(a, 10, 64)
+	bn, bErr := strconv.ParseInt(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return cmp.Compare(an, bn)
+	case aErr == nil:
+		return 1
+	case bErr == nil:
+		return -1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
 // Swap swaps the elements with indexes i and j.
 func (s secretList) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
@@ -98,7 +202,7 @@ func (s secretList) Swap(i, j int) {
 // List is a top-level package function for listing secrets. This doesn't
 // fetch the plaintext value of secrets.
 func List(ctx context.Context, i listRequest) (*ListResponse, error) {
-	client, err := New(ctx)
+	client, err := DefaultClient(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -112,6 +216,10 @@ func (c *Client) List(ctx context.Context, i listRequest) (*ListResponse, error)
 		return nil, fmt.Errorf("missing request")
 	}
 
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
 	switch t := i.(type) {
 	case *SecretManagerListRequest:
 		return c.secretManagerList(ctx, t)
@@ -124,10 +232,6 @@ func (c *Client) List(ctx context.Context, i listRequest) (*ListResponse, error)
 
 func (c *Client) secretManagerList(ctx context.Context, i *SecretManagerListRequest) (*ListResponse, error) {
 	project := i.Project
-	if project == "" {
-		return nil, fmt.Errorf("missing project")
-	}
-
 	prefix := i.Prefix
 	versions := i.Versions
 
@@ -160,12 +264,20 @@ func (c *Client) secretManagerList(ctx context.Context, i *SecretManagerListRequ
 				Parent:    project,
 				Name:      path.Base(resp.Name),
 				UpdatedAt: timestampToTime(resp.CreateTime),
+				Locations: secretManagerLocations(resp.GetReplication()),
 			})
 		}
 	}
 
 	if !versions {
 		sort.Sort(secretList(allSecrets))
+
+		if i.WithMetadata {
+			if err := c.secretManagerListMetadata(ctx, allSecrets, i.Parallelism); err != nil {
+				return nil, err
+			}
+		}
+
 		return &ListResponse{
 			Secrets: allSecrets,
 		}, nil
@@ -192,28 +304,116 @@ func (c *Client) secretManagerList(ctx context.Context, i *SecretManagerListRequ
 				return nil, fmt.Errorf("failed to list versions for %s: %w", s.Name, err)
 			}
 
+			state := resp.GetState().String()
+			if len(i.States) > 0 && !slices.Contains(i.States, state) {
+				continue
+			}
+
 			allSecretVersions = append(allSecretVersions, &Secret{
 				Parent:    project,
 				Name:      s.Name,
 				Version:   path.Base(resp.Name),
+				State:     state,
 				UpdatedAt: timestampToTime(resp.CreateTime),
+				Locations: s.Locations,
 			})
 		}
 	}
 
 	sort.Sort(secretList(allSecretVersions))
 
+	if i.WithMetadata {
+		if err := c.secretManagerListMetadata(ctx, allSecretVersions, i.Parallelism); err != nil {
+			return nil, err
+		}
+	}
+
 	return &ListResponse{
 		Secrets: allSecretVersions,
 	}, nil
 }
 
-func (c *Client) storageList(ctx context.Context, i *StorageListRequest) (*ListResponse, error) {
-	bucket := i.Bucket
-	if bucket == "" {
-		return nil, fmt.Errorf("missing bucket name")
+// secretManagerListMetadata concurrently accesses the payload of each secret
+// to populate its Size and CRC32C. The plaintext is discarded. It returns the
+// first error encountered, other than for versions that cannot be accessed
+// because they are disabled or destroyed.
+func (c *Client) secretManagerListMetadata(ctx context.Context, secrets []*Secret, parallelism int) error {
+	if parallelism <= 0 {
+		parallelism = DefaultListParallelism
+	}
+
+	logger := logging.FromContext(ctx)
+	logger.DebugContext(ctx, "fetching secret metadata",
+		"count", len(secrets),
+		"parallelism", parallelism)
+
+	// Cancel outstanding work on the first failure.
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sem := semaphore.NewWeighted(int64(parallelism))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	for _, s := range secrets {
+		if err := sem.Acquire(workCtx, 1); err != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sem.Release(1)
+
+			version := s.Version
+			if version == "" {
+				version = "latest"
+			}
+
+			resp, err := c.secretManagerClient.AccessSecretVersion(workCtx, &secretspb.AccessSecretVersionRequest{
+				Name: fmt.Sprintf("projects/%s/secrets/%s/versions/%s", s.Parent, s.Name, version),
+			})
+			if err != nil {
+				if terr, ok := grpcstatus.FromError(err); ok &&
+					(terr.Code() == grpccodes.NotFound || terr.Code() == grpccodes.FailedPrecondition) {
+					logger.DebugContext(ctx, "skipping inaccessible version",
+						"name", s.Name,
+						"version", version,
+						"error", err)
+					return
+				}
+
+				mu.Lock()
+				if firstErr == nil && workCtx.Err() == nil {
+					firstErr = fmt.Errorf("failed to access %s version %s: %w", s.Name, version, err)
+				}
+				mu.Unlock()
+				cancel()
+				return
+			}
+
+			payload := resp.GetPayload()
+			s.Size = int64(len(payload.GetData()))
+			s.CRC32C = uint32(payload.GetDataCrc32C())
+			clear(payload.GetData())
+		}()
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
 	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to fetch secret metadata: %w", err)
+	}
+	return nil
+}
 
+func (c *Client) storageList(ctx context.Context, i *StorageListRequest) (*ListResponse, error) {
+	bucket := i.Bucket
 	prefix := i.Prefix
 	generations := i.Generations
 
@@ -234,8 +434,7 @@ func (c *Client) storageList(ctx context.Context, i *StorageListRequest) (*ListR
 	}
 
 	// List all objects
-	it := c.storageClient.
-		Bucket(bucket).
+	it := c.storageBucket(ctx, bucket).
 		Objects(ctx, query)
 	for {
 		obj, err := it.Next()
@@ -277,7 +476,12 @@ func (c *Client) storageList(ctx context.Context, i *StorageListRequest) (*ListR
 
 		if foundLiveObject {
 			for _, obj := range objects {
-				result = append(result, secretFromAttrs(bucket, obj, nil))
+				secret := secretFromAttrs(bucket, obj, nil)
+				secret.State = StorageStateLive
+				if !obj.Deleted.IsZero() {
+					secret.State = StorageStateArchived
+				}
+				result = append(result, secret)
 			}
 		}
 	}
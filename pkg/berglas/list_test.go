@@ -14,7 +14,44 @@
 
 package berglas
 
-import "testing"
+import (
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSecretList_Sort(t *testing.T) {
+	t.Parallel()
+
+	list := secretList{
+		{Name: "a", Version: "9"},
+		{Name: "b", Generation: 1},
+		{Name: "a", Version: "10"},
+		{Name: "b", Generation: 2},
+		{Name: "a", Version: "latest"},
+		{Name: "a", Version: "1"},
+	}
+
+	// Sorting any permutation must give the same order.
+	for _, perm := range [][]int{{0, 1, 2, 3, 4, 5}, {5, 4, 3, 2, 1, 0}, {2, 0, 5, 3, 1, 4}} {
+		shuffled := make(secretList, len(list))
+		for i, j := range perm {
+			shuffled[i] = list[j]
+		}
+		sort.Sort(shuffled)
+
+		var act []string
+		for _, s := range shuffled {
+			act = append(act, fmt.Sprintf("%s/%s%d", s.Name, s.Version, s.Generation))
+		}
+		exp := []string{"b/2", "b/1", "a/100", "a/90", "a/10", "a/latest0"}
+		if !reflect.DeepEqual(act, exp) {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+	}
+}
 
 func TestClient_List_secretManager(t *testing.T) {
 	testAcc(t)
@@ -106,6 +143,67 @@ func TestClient_List_secretManager(t *testing.T) {
 		if d := len(list.Secrets); d != 4 { // 4 because create creates the first version
 			t.Errorf("expected 3 secrets, got %d: %#v", d, list.Secrets)
 		}
+
+		for _, s := range list.Secrets {
+			if act, exp := s.State, "ENABLED"; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		}
+
+		list, err = client.List(ctx, &SecretManagerListRequest{
+			Project:  project,
+			Prefix:   name,
+			Versions: true,
+			States:   []string{"DISABLED"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if d := len(list.Secrets); d != 0 {
+			t.Errorf("expected no disabled versions, got %d: %#v", d, list.Secrets)
+		}
+	})
+
+	t.Run("with_metadata", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name := testProject(t), testName(t)
+
+		if _, err := client.Create(ctx, &SecretManagerCreateRequest{
+			Project:   project,
+			Name:      name,
+			Plaintext: []byte("test"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer testSecretManagerCleanup(t, project, name)
+
+		list, err := client.List(ctx, &SecretManagerListRequest{
+			Project:      project,
+			Prefix:       name,
+			Versions:     true,
+			WithMetadata: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if d := len(list.Secrets); d != 1 {
+			t.Fatalf("expected 1 secret, got %d: %#v", d, list.Secrets)
+		}
+
+		s := list.Secrets[0]
+		if s.Size != 4 {
+			t.Errorf("expected %d to be %d", s.Size, 4)
+		}
+		if exp := crc32.Checksum([]byte("test"), crc32.MakeTable(crc32.Castagnoli)); s.CRC32C != exp {
+			t.Errorf("expected %08x to be %08x", s.CRC32C, exp)
+		}
+		if s.Plaintext != nil {
+			t.Errorf("expected plaintext to be nil")
+		}
 	})
 }
 
@@ -202,5 +300,19 @@ func TestClient_List_storage(t *testing.T) {
 		if d := len(list.Secrets); d != 4 { // 4 because create creates the first version
 			t.Errorf("expected 3 secrets, got %d: %#v", d, list.Secrets)
 		}
+
+		live := 0
+		for _, s := range list.Secrets {
+			switch s.State {
+			case StorageStateLive:
+				live++
+			case StorageStateArchived:
+			default:
+				t.Errorf("unexpected state %q for generation %d", s.State, s.Generation)
+			}
+		}
+		if live != 1 {
+			t.Errorf("expected 1 live generation, got %d", live)
+		}
 	})
 }
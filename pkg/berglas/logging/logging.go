@@ -29,6 +29,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logredact"
 )
 
 // contextKey is a private string type to prevent collisions in the context map.
@@ -57,10 +59,25 @@ var defaultLoggerOnce = sync.OnceValue(func() *slog.Logger {
 // It returns the configured logger and a leveler which can be used to change
 // the logger's level dynamically. The leveler does not require locking to
 // change the level.
-func New(w io.Writer, logLevel, logFormat string, debug bool) (*slog.Logger, error) {
+//
+// Options may hash the attributes that name secrets with [WithHashedAttrs] or
+// sample debug records with [WithDebugSampleRate], and mask resolved secret
+// values with [WithRedactor].
+func New(w io.Writer, logLevel, logFormat string, debug bool, options ...Option) (*slog.Logger, error) {
+	cfg := &config{debugSampleRate: 1}
+	for _, o := range options {
+		o(cfg)
+	}
+
 	opts := &slog.HandlerOptions{
 		ReplaceAttr: cloudLoggingAttrsEncoder(),
 	}
+	if cfg.hashKey != nil {
+		hash, encode := hashAttrsEncoder(cfg.hashKey), opts.ReplaceAttr
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			return encode(groups, hash(groups, a))
+		}
+	}
 
 	level, err := LookupLevel(logLevel)
 	if err != nil {
@@ -79,14 +96,28 @@ func New(w io.Writer, logLevel, logFormat string, debug bool) (*slog.Logger, err
 		level = math.MinInt
 	}
 
+	var h slog.Handler
 	switch format {
 	case FormatJSON:
-		return slog.New(NewLevelHandler(level, slog.NewJSONHandler(w, opts))), nil
+		h = slog.NewJSONHandler(w, opts)
 	case FormatText:
-		return slog.New(NewLevelHandler(level, slog.NewTextHandler(w, opts))), nil
+		h = slog.NewTextHandler(w, opts)
 	default:
 		return nil, fmt.Errorf("unknown log format %q", format)
 	}
+
+	if cfg.debugSampleRate != 1 {
+		sh, err := NewSampleHandler(cfg.debugSampleRate, h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid debug sample rate: %w", err)
+		}
+		h = sh
+	}
+
+	if cfg.redactor != nil {
+		h = logredact.NewHandler(h, cfg.redactor)
+	}
+	return slog.New(NewLevelHandler(level, h)), nil
 }
 
 // SetLevel adjusts the level on the provided logger. The handler on the given
@@ -0,0 +1,192 @@
+// Copyright 2023 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logredact"
+)
+
+// sensitiveKeys are the attribute keys whose values name secrets, buckets,
+// projects, keys, or IAM members. Keys are matched against the last
+// dot-separated segment of an attribute key, so "existing.bucket" is hashed
+// like "bucket".
+var sensitiveKeys = []string{
+	"bucket",
+	"destination_path",
+	"directory",
+	"fallback",
+	"folder",
+	"key",
+	"kms_crypto_key",
+	"kms_key_ring",
+	"members",
+	"metadata",
+	"name",
+	"object",
+	"prefix",
+	"project",
+	"project_id",
+	"reference",
+	"secret",
+	"source_bucket",
+	"source_object",
+	"staging_name",
+	"suggestion",
+	"topic",
+	"wrapping_key",
+}
+
+// SensitiveKeys returns the attribute keys whose values are hashed by
+// [WithHashedAttrs].
+func SensitiveKeys() []string {
+	return slices.Clone(sensitiveKeys)
+}
+
+// Option is an option for [New].
+type Option func(*config)
+
+type config struct {
+	hashKey         []byte
+	debugSampleRate float64
+	redactor        *logredact.Redactor
+}
+
+// WithHashedAttrs replaces the values of the attributes named by
+// [SensitiveKeys] with an HMAC-SHA256 of the value under the given key, as
+// computed by [HashValue]. This keeps references and naming conventions out of
+// the logs while still letting the same secret be correlated across log
+// entries. Error messages are not hashed and may still contain names.
+func WithHashedAttrs(key []byte) Option {
+	return func(c *config) {
+		c.hashKey = slices.Clone(key)
+	}
+}
+
+// WithDebugSampleRate logs only the given fraction of debug records, between 0
+// and 1, chosen at random. Records at info level and above are always logged.
+func WithDebugSampleRate(rate float64) Option {
+	return func(c *config) {
+		c.debugSampleRate = rate
+	}
+}
+
+// WithRedactor masks the values registered with r in the message and
+// attributes of each record, as done by [logredact.NewHandler].
+func WithRedactor(r *logredact.Redactor) Option {
+	return func(c *config) {
+		c.redactor = r
+	}
+}
+
+// HashValue returns the HMAC-SHA256 of s under the given key, as it appears in
+// logs written with [WithHashedAttrs]. Use it to find the log entries for a
+// known reference.
+func HashValue(key []byte, s string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// hashAttrsEncoder hashes the values of sensitive attributes. Object metadata
+// maps keep their keys, but each value is hashed.
+func hashAttrsEncoder(key []byte) func([]string, slog.Attr) slog.Attr {
+	return func(_ []string, a slog.Attr) slog.Attr {
+		if a.Value.Kind() == slog.KindGroup || !isSensitiveKey(a.Key) {
+			return a
+		}
+
+		if m, ok := a.Value.Any().(map[string]string); ok {
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+
+			attrs := make([]slog.Attr, 0, len(m))
+			for _, k := range keys {
+				attrs = append(attrs, slog.String(k, HashValue(key, m[k])))
+			}
+			a.Value = slog.GroupValue(attrs...)
+			return a
+		}
+
+		a.Value = slog.StringValue(HashValue(key, a.Value.String()))
+		return a
+	}
+}
+
+// isSensitiveKey reports whether the last dot-separated segment of the
+// attribute key is one of [sensitiveKeys].
+func isSensitiveKey(k string) bool {
+	if i := strings.LastIndexByte(k, '.'); i >= 0 {
+		k = k[i+1:]
+	}
+	return slices.Contains(sensitiveKeys, k)
+}
+
+// Ensure we are a slog handler.
+var _ slog.Handler = (*SampleHandler)(nil)
+
+// SampleHandler is a handler that drops a fraction of debug records at random.
+type SampleHandler struct {
+	handler slog.Handler
+	rate    float64
+}
+
+// NewSampleHandler creates a handler that passes the given fraction of debug
+// records, between 0 and 1, to h. Records at info level and above are always
+// passed.
+func NewSampleHandler(rate float64, h slog.Handler) (*SampleHandler, error) {
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("sample rate must be between 0 and 1, got %v", rate)
+	}
+	return &SampleHandler{
+		handler: h,
+		rate:    rate,
+	}, nil
+}
+
+// Enabled implements Handler.Enabled.
+func (h *SampleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+// Handle implements Handler.Handle.
+func (h *SampleHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < LevelInfo && rand.Float64() >= h.rate {
+		return nil
+	}
+	return h.handler.Handle(ctx, r) //nolint:wrapcheck // Want passthrough
+}
+
+// WithAttrs implements Handler.WithAttrs.
+func (h *SampleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &SampleHandler{handler: h.handler.WithAttrs(attrs), rate: h.rate}
+}
+
+// WithGroup implements Handler.WithGroup.
+func (h *SampleHandler) WithGroup(name string) slog.Handler {
+	return &SampleHandler{handler: h.handler.WithGroup(name), rate: h.rate}
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNew_hashedAttrs(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("my-hash-key")
+
+	var b bytes.Buffer
+	logger, err := New(&b, "debug", "json", false, WithHashedAttrs(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.With("reference", "sm://my-project/api-key").
+		InfoContext(context.Background(), "resolved", "generation", 12)
+
+	out := b.String()
+	if strings.Contains(out, "api-key") {
+		t.Errorf("expected %q to not contain the reference", out)
+	}
+	if exp := HashValue(key, "sm://my-project/api-key"); !strings.Contains(out, exp) {
+		t.Errorf("expected %q to contain %q", out, exp)
+	}
+	if exp := `"generation":12`; !strings.Contains(out, exp) {
+		t.Errorf("expected %q to contain %q", out, exp)
+	}
+
+	b.Reset()
+	logger.InfoContext(context.Background(), "found",
+		"existing.bucket", "my-bucket",
+		"metadata", map[string]string{"berglas-kms-key": "my-key"})
+
+	out = b.String()
+	for _, s := range []string{"my-bucket", "my-key"} {
+		if strings.Contains(out, s) {
+			t.Errorf("expected %q to not contain %q", out, s)
+		}
+	}
+	if exp := `"berglas-kms-key":"` + HashValue(key, "my-key"); !strings.Contains(out, exp) {
+		t.Errorf("expected %q to contain %q", out, exp)
+	}
+}
+
+func TestNew_debugSampleRate(t *testing.T) {
+	t.Parallel()
+
+	if _, err := New(&bytes.Buffer{}, "debug", "json", false, WithDebugSampleRate(2)); err == nil {
+		t.Errorf("expected error for invalid rate")
+	}
+
+	var b bytes.Buffer
+	logger, err := New(&b, "debug", "json", false, WithDebugSampleRate(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	logger.DebugContext(ctx, "dropped")
+	logger.InfoContext(ctx, "kept")
+
+	out := b.String()
+	if strings.Contains(out, "dropped") {
+		t.Errorf("expected %q to not contain debug record", out)
+	}
+	if !strings.Contains(out, "kept") {
+		t.Errorf("expected %q to contain info record", out)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/option"
+)
+
+func TestClient_hashedAttrs(t *testing.T) {
+	t.Parallel()
+
+	const kmsKey = "projects/my-kms-project/locations/global/keyRings/my-key-ring/cryptoKeys/my-crypto-key"
+
+	// Serve object attributes so storage updates log the existing object, and
+	// fail everything else.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/o/my-object") {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{
+			"bucket": "my-bucket",
+			"name": "my-object",
+			"generation": "2",
+			"metageneration": "1",
+			"metadata": {%q: "1", %q: %q}
+		}`, MetadataIDKey, MetadataKMSKey, kmsKey)
+	}))
+	t.Cleanup(srv.Close)
+
+	keyPath := filepath.Join(t.TempDir(), "my-local-key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	hashKey := []byte("my-hash-key")
+	logger, err := logging.New(&b, "debug", "json", false, logging.WithHashedAttrs(hashKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := logging.WithLogger(context.Background(), logger)
+
+	client, err := New(ctx,
+		option.WithoutAuthentication(),
+		WithStorageEndpoint(srv.URL+"/storage/v1/"),
+		WithSecretManagerEndpoint("127.0.0.1:1"),
+		WithKMSEndpoint("127.0.0.1:1"),
+		WithS3Store(&memS3Store{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := client.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// Calls to Google Cloud fail immediately, after the request is logged.
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := client.Update(canceledCtx, &SecretManagerUpdateRequest{
+		Project:   "my-project",
+		Name:      "my-secret",
+		Plaintext: []byte("p"),
+	}); err == nil {
+		t.Errorf("expected secret manager update to fail")
+	}
+
+	if _, err := client.Update(ctx, &StorageUpdateRequest{
+		Bucket:    "my-bucket",
+		Object:    "my-object",
+		Plaintext: []byte("p"),
+	}); err == nil {
+		t.Errorf("expected storage update to fail")
+	}
+
+	if err := client.Bootstrap(canceledCtx, &StorageBootstrapRequest{
+		ProjectID:    "my-project",
+		Bucket:       "my-bucket",
+		KMSKeyRing:   "my-key-ring",
+		KMSCryptoKey: "my-crypto-key",
+	}); err == nil {
+		t.Errorf("expected bootstrap to fail")
+	}
+
+	if _, err := client.Create(ctx, &StorageS3CreateRequest{
+		Bucket:    "my-s3-bucket",
+		Object:    "my-s3-object",
+		Key:       KeySchemeLocal + "://" + keyPath,
+		Plaintext: []byte("p"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := b.String()
+	for _, s := range []string{
+		"my-project",
+		"my-secret",
+		"my-bucket",
+		"my-object",
+		"my-kms-project",
+		"my-key-ring",
+		"my-crypto-key",
+		"my-s3-bucket",
+		"my-s3-object",
+		"my-local-key",
+	} {
+		if strings.Contains(out, s) {
+			t.Errorf("expected logs to not contain %q:\n%s", s, out)
+		}
+	}
+
+	for _, s := range []string{
+		"update.start",
+		"found existing storage object",
+		"bootstrap.start",
+		"writing object to s3",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected logs to contain %q:\n%s", s, out)
+		}
+	}
+}
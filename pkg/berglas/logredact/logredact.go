@@ -0,0 +1,231 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logredact masks resolved secret values in application logs.
+//
+// Values are registered with a Redactor, usually the process-wide Default, and
+// a berglas client created with berglas.WithLogRedaction registers every
+// secret it resolves. Wrap a log destination with NewWriter, or a slog handler
+// with NewHandler, to replace registered values with Mask before they are
+// written.
+package logredact
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// Mask replaces registered values in redacted output.
+	Mask = "[REDACTED]"
+
+	// MinLength is the length in bytes below which values are not registered.
+	// Masking very short values such as "1" or "true" would mangle unrelated
+	// log output without hiding anything meaningful.
+	MinLength = 4
+)
+
+var defaultRedactor = New()
+
+// Default returns the process-wide redactor.
+func Default() *Redactor {
+	return defaultRedactor
+}
+
+// Register adds values to the process-wide redactor.
+func Register(values ...string) {
+	defaultRedactor.Register(values...)
+}
+
+// Redactor is a set of values to mask. It is safe for concurrent use.
+type Redactor struct {
+	lock     sync.RWMutex
+	values   map[string]struct{}
+	replacer *strings.Replacer
+}
+
+// New creates an empty redactor.
+func New() *Redactor {
+	return &Redactor{
+		values: make(map[string]struct{}),
+	}
+}
+
+// Register adds values to the redactor. Values shorter than MinLength are
+// ignored. A value that spans multiple lines is also registered line by line,
+// since loggers often split or escape multi-line values.
+func (r *Redactor) Register(values ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, v := range values {
+		r.add(v)
+		if strings.Contains(v, "\n") {
+			for _, line := range strings.Split(v, "\n") {
+				r.add(strings.TrimSuffix(line, "\r"))
+			}
+		}
+	}
+}
+
+// add adds a single value. The caller must hold the lock.
+func (r *Redactor) add(v string) {
+	if len(v) < MinLength {
+		return
+	}
+	if _, ok := r.values[v]; ok {
+		return
+	}
+	r.values[v] = struct{}{}
+	r.replacer = nil
+}
+
+// Redact returns s with every registered value replaced with Mask. Longer
+// values are replaced first, so a value that contains another is masked as a
+// whole.
+func (r *Redactor) Redact(s string) string {
+	replacer := r.getReplacer()
+	if replacer == nil {
+		return s
+	}
+	return replacer.Replace(s)
+}
+
+// getReplacer returns the replacer for the registered values, building it if
+// values were registered since it was last built. It returns nil if there are
+// no values.
+func (r *Redactor) getReplacer() *strings.Replacer {
+	r.lock.RLock()
+	replacer, n := r.replacer, len(r.values)
+	r.lock.RUnlock()
+	if replacer != nil || n == 0 {
+		return replacer
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.replacer == nil {
+		values := make([]string, 0, len(r.values))
+		for v := range r.values {
+			values = append(values, v)
+		}
+		slices.SortFunc(values, func(a, b string) int {
+			return len(b) - len(a)
+		})
+
+		oldnew := make([]string, 0, 2*len(values))
+		for _, v := range values {
+			oldnew = append(oldnew, v, Mask)
+		}
+		r.replacer = strings.NewReplacer(oldnew...)
+	}
+	return r.replacer
+}
+
+// writer redacts each write before passing it to the underlying writer.
+type writer struct {
+	w io.Writer
+	r *Redactor
+}
+
+// NewWriter returns a writer that masks the values registered with r in each
+// call to Write before writing to w. Values split across calls to Write are
+// not masked, so w should receive whole log lines, as the standard log and
+// slog packages write them.
+func NewWriter(w io.Writer, r *Redactor) io.Writer {
+	return &writer{w: w, r: r}
+}
+
+// Write implements io.Writer. It reports len(p) on success, even if the
+// redacted output has a different length.
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.r.Redact(string(p))); err != nil {
+		return 0, err //nolint:wrapcheck // Want passthrough
+	}
+	return len(p), nil
+}
+
+// Ensure we are a slog handler.
+var _ slog.Handler = (*Handler)(nil)
+
+// Handler is a slog handler that masks registered values in the message and
+// attributes of each record.
+type Handler struct {
+	handler slog.Handler
+	r       *Redactor
+}
+
+// NewHandler creates a handler that masks the values registered with r before
+// passing records to h.
+func NewHandler(h slog.Handler, r *Redactor) *Handler {
+	return &Handler{handler: h, r: r}
+}
+
+// Enabled implements Handler.Enabled.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+// Handle implements Handler.Handle.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	nr := slog.NewRecord(r.Time, r.Level, h.r.Redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		nr.AddAttrs(h.redactAttr(a))
+		return true
+	})
+	return h.handler.Handle(ctx, nr) //nolint:wrapcheck // Want passthrough
+}
+
+// WithAttrs implements Handler.WithAttrs.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		redacted = append(redacted, h.redactAttr(a))
+	}
+	return &Handler{handler: h.handler.WithAttrs(redacted), r: h.r}
+}
+
+// WithGroup implements Handler.WithGroup.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{handler: h.handler.WithGroup(name), r: h.r}
+}
+
+// redactAttr masks registered values in the attribute's value. Values that
+// are not strings or groups are formatted as strings only if they contain a
+// registered value.
+func (h *Handler) redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		attrs := v.Group()
+		redacted := make([]slog.Attr, 0, len(attrs))
+		for _, ga := range attrs {
+			redacted = append(redacted, h.redactAttr(ga))
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	case slog.KindString:
+		return slog.String(a.Key, h.r.Redact(v.String()))
+	case slog.KindAny:
+		s := v.String()
+		if r := h.r.Redact(s); r != s {
+			return slog.String(a.Key, r)
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
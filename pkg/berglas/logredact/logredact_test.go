@@ -0,0 +1,123 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logredact
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	t.Parallel()
+
+	r := New()
+	r.Register("abc", "s3cr3t", "s3cr3t-longer", "line1\nline2")
+
+	cases := []struct {
+		name string
+		in   string
+		exp  string
+	}{
+		{
+			name: "no_match",
+			in:   "nothing to see",
+			exp:  "nothing to see",
+		},
+		{
+			name: "short_values_ignored",
+			in:   "abc",
+			exp:  "abc",
+		},
+		{
+			name: "longest_first",
+			in:   "got s3cr3t-longer and s3cr3t",
+			exp:  "got [REDACTED] and [REDACTED]",
+		},
+		{
+			name: "multiline_parts",
+			in:   `value="line1\nline2"`,
+			exp:  `value="[REDACTED]\n[REDACTED]"`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if act, exp := r.Redact(tc.in), tc.exp; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	t.Parallel()
+
+	r := New()
+	r.Register("hunter2")
+
+	var b bytes.Buffer
+	w := NewWriter(&b, r)
+
+	in := "password is hunter2\n"
+	n, err := w.Write([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := n, len(in); act != exp {
+		t.Errorf("expected %d to be %d", act, exp)
+	}
+	if act, exp := b.String(), "password is [REDACTED]\n"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	t.Parallel()
+
+	r := New()
+	r.Register("hunter2")
+
+	var b bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewTextHandler(&b, nil), r)).
+		With("preset", "hunter2")
+	logger.Info("leaked hunter2",
+		"value", "hunter2",
+		"error", errors.New("bad password hunter2"),
+		slog.Group("group", "nested", "hunter2"),
+		"count", 5)
+
+	out := b.String()
+	if strings.Contains(out, "hunter2") {
+		t.Errorf("expected %q to not contain the secret", out)
+	}
+	for _, exp := range []string{
+		`msg="leaked [REDACTED]"`,
+		`preset=[REDACTED]`,
+		`error="bad password [REDACTED]"`,
+		`group.nested=[REDACTED]`,
+		`count=5`,
+	} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("expected %q to contain %q", out, exp)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/googleapi"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+type membersRequest interface {
+	isMembersRequest()
+	Validate() error
+}
+
+// StorageMembersRequest is used as input to list the members with access to a
+// secret in Cloud Storage.
+type StorageMembersRequest struct {
+	// Bucket is the name of the bucket where the secret lives.
+	Bucket string
+
+	// Object is the name of the object in Cloud Storage.
+	Object string
+}
+
+func (r *StorageMembersRequest) isMembersRequest() {}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageMembersRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	return v.err()
+}
+
+// SecretManagerMembersRequest is used as input to list the members with access
+// to a secret in Secret Manager.
+type SecretManagerMembersRequest struct {
+	// Project is the ID or number of the project where secrets live.
+	Project string
+
+	// Name is the name of the secret.
+	Name string
+}
+
+func (r *SecretManagerMembersRequest) isMembersRequest() {}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerMembersRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	v.require("Name", r.Name, "missing secret name")
+	return v.err()
+}
+
+// Members is a top-level package function for listing the members with access
+// to a secret. For large volumes of secrets, please create a client instead.
+func Members(ctx context.Context, i membersRequest) ([]string, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Members(ctx, i)
+}
+
+// Members returns the sorted list of IAM members that were granted access to
+// the secret by Grant. For Cloud Storage secrets, these are the members with
+// roles/storage.legacyObjectReader on the object. For Secret Manager secrets,
+// these are the members with roles/secretmanager.secretAccessor on the secret.
+func (c *Client) Members(ctx context.Context, i membersRequest) ([]string, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch t := i.(type) {
+	case *SecretManagerMembersRequest:
+		return c.secretManagerMembers(ctx, t)
+	case *StorageMembersRequest:
+		return c.storageMembers(ctx, t)
+	default:
+		return nil, fmt.Errorf("unknown members type %T", t)
+	}
+}
+
+func (c *Client) secretManagerMembers(ctx context.Context, i *SecretManagerMembersRequest) ([]string, error) {
+	project := i.Project
+	name := i.Name
+
+	logger := logging.FromContext(ctx).With(
+		"project", project,
+		"name", name,
+	)
+
+	logger.DebugContext(ctx, "members.start")
+	defer logger.DebugContext(ctx, "members.finish")
+
+	policy, err := getIAMPolicy(ctx, c.secretManagerIAM(project, name))
+	if err != nil {
+		terr, ok := grpcstatus.FromError(err)
+		if ok && terr.Code() == grpccodes.NotFound {
+			return nil, secretManagerError(ErrSecretDoesNotExist, project, name)
+		}
+		return nil, fmt.Errorf("failed to get Secret Manager IAM policy for %s: %w", name, err)
+	}
+
+	members := policy.Members(iamSecretManagerAccessor)
+	sort.Strings(members)
+	return members, nil
+}
+
+func (c *Client) storageMembers(ctx context.Context, i *StorageMembersRequest) ([]string, error) {
+	bucket := i.Bucket
+	object := i.Object
+
+	logger := logging.FromContext(ctx).With(
+		"bucket", bucket,
+		"object", object,
+	)
+
+	logger.DebugContext(ctx, "members.start")
+	defer logger.DebugContext(ctx, "members.finish")
+
+	members, err := c.storageObjectMembers(ctx, bucket, object)
+	if err != nil {
+		if terr, ok := err.(*googleapi.Error); ok && terr.Code == http.StatusNotFound {
+			return nil, storageError(ErrSecretDoesNotExist, bucket, object)
+		}
+		return nil, fmt.Errorf("failed to get Storage IAM policy for %s: %w", object, err)
+	}
+	sort.Strings(members)
+	return members, nil
+}
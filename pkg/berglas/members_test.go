@@ -0,0 +1,96 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import "testing"
+
+func TestClient_Members_secretManager(t *testing.T) {
+	testAcc(t)
+
+	t.Run("missing", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name := testProject(t), testName(t)
+
+		if _, err := client.Members(ctx, &SecretManagerMembersRequest{
+			Project: project,
+			Name:    name,
+		}); !IsSecretDoesNotExistErr(err) {
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
+		}
+	})
+
+	t.Run("exists", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name := testProject(t), testName(t)
+
+		if _, err := client.Create(ctx, &SecretManagerCreateRequest{
+			Project:   project,
+			Name:      name,
+			Plaintext: []byte("my secret plaintext"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer testSecretManagerCleanup(t, project, name)
+
+		members, err := client.Members(ctx, &SecretManagerMembersRequest{
+			Project: project,
+			Name:    name,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(members) != 0 {
+			t.Errorf("expected no members, got %q", members)
+		}
+	})
+}
+
+func TestClient_Members_storage(t *testing.T) {
+	testAcc(t)
+
+	t.Run("exists", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		bucket, object, key := testBucket(t), testName(t), testKey(t)
+
+		if _, err := client.Create(ctx, &StorageCreateRequest{
+			Bucket:    bucket,
+			Object:    object,
+			Key:       key,
+			Plaintext: []byte("my secret plaintext"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer testStorageCleanup(t, bucket, object)
+
+		members, err := client.Members(ctx, &StorageMembersRequest{
+			Bucket: bucket,
+			Object: object,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(members) != 0 {
+			t.Errorf("expected no members, got %q", members)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/option"
+)
+
+// NamingPolicy restricts the names of new and updated secrets, so naming
+// conventions such as "<team>-<app>-<purpose>" can be enforced centrally.
+type NamingPolicy struct {
+	// Pattern must match the name of the secret: the Secret Manager secret name
+	// or the Cloud Storage or S3 object name, without the project or bucket. It
+	// should be anchored with ^ and $ to match the whole name.
+	Pattern *regexp.Regexp
+
+	// Description explains the policy to people whose secret names are
+	// rejected, such as "<team>-<app>-<purpose>, in lowercase". The pattern is
+	// shown instead if it is empty.
+	Description string
+}
+
+// ParseNamingPolicy parses a naming policy file. Each line is of the form
+// "KEY=VALUE", where KEY is "pattern" or "description". Whitespace around the
+// key and value is ignored, as are blank lines and lines starting with "#".
+// The pattern is required and is anchored to match the whole name:
+//
+//	# Secrets are named <team>-<app>-<purpose>.
+//	pattern = [a-z]+-[a-z0-9]+-[a-z0-9-]+
+//	description = <team>-<app>-<purpose>, in lowercase
+func ParseNamingPolicy(r io.Reader) (*NamingPolicy, error) {
+	var pattern string
+	var policy NamingPolicy
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch key {
+		case "pattern":
+			pattern = value
+		case "description":
+			policy.Description = value
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q: must be pattern or description", n, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read naming policy: %w", err)
+	}
+
+	if pattern == "" {
+		return nil, fmt.Errorf("missing pattern")
+	}
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	policy.Pattern = re
+	return &policy, nil
+}
+
+// WithNamingPolicy returns a client option that refuses to create, update, or
+// copy to secrets whose names do not match the policy. The error wraps
+// ErrNamingPolicyViolation and explains the policy. Reading and deleting
+// secrets is not restricted.
+func WithNamingPolicy(p *NamingPolicy) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.namingPolicy = p
+	}}
+}
+
+// checkNaming returns an error wrapping ErrNamingPolicyViolation if the secret
+// name does not match the client's naming policy, if any.
+func (c *Client) checkNaming(name string) error {
+	p := c.namingPolicy
+	if p == nil || p.Pattern == nil || p.Pattern.MatchString(name) {
+		return nil
+	}
+
+	want := p.Description
+	if want == "" {
+		want = "pattern " + p.Pattern.String()
+	}
+	return fmt.Errorf("%w: name %q must be %s", ErrNamingPolicyViolation, name, want)
+}
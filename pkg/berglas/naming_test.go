@@ -0,0 +1,199 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseNamingPolicy(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name        string
+		in          string
+		description string
+		match       []string
+		noMatch     []string
+		err         string
+	}{
+		{
+			name: "valid",
+			in: strings.Join([]string{
+				"# Secrets are named <team>-<app>-<purpose>.",
+				"",
+				"pattern = [a-z]+-[a-z0-9]+-[a-z0-9-]+",
+				"description = <team>-<app>-<purpose>, in lowercase",
+			}, "\n"),
+			description: "<team>-<app>-<purpose>, in lowercase",
+			match:       []string{"payments-api-db-password", "infra-ci-token"},
+			noMatch:     []string{"db-password", "Payments-api-key", "x/payments-api-key"},
+		},
+		{
+			name:    "anchored",
+			in:      "pattern=a|ab",
+			match:   []string{"a", "ab"},
+			noMatch: []string{"abc", "ba"},
+		},
+		{
+			name: "missing_separator",
+			in:   "pattern",
+			err:  "line 1: expected KEY=VALUE",
+		},
+		{
+			name: "unknown_key",
+			in:   "pattern=a\nregex=b",
+			err:  `line 2: unknown key "regex"`,
+		},
+		{
+			name: "missing_pattern",
+			in:   "description=anything",
+			err:  "missing pattern",
+		},
+		{
+			name: "invalid_pattern",
+			in:   "pattern=[a-z",
+			err:  "invalid pattern",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			policy, err := ParseNamingPolicy(strings.NewReader(tc.in))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if act, exp := policy.Description, tc.description; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+			for _, name := range tc.match {
+				if !policy.Pattern.MatchString(name) {
+					t.Errorf("expected %q to match", name)
+				}
+			}
+			for _, name := range tc.noMatch {
+				if policy.Pattern.MatchString(name) {
+					t.Errorf("expected %q to not match", name)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_namingPolicy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	c := &Client{}
+	WithNamingPolicy(&NamingPolicy{
+		Pattern:     regexp.MustCompile(`^[a-z]+-[a-z0-9]+-[a-z0-9-]+$`),
+		Description: "<team>-<app>-<purpose>",
+	}).(*clientOption).apply(c)
+
+	cases := []struct {
+		name     string
+		call     func() error
+		resource string
+	}{
+		{
+			name: "secret_manager_create",
+			call: func() error {
+				_, err := c.Create(ctx, &SecretManagerCreateRequest{
+					Project:   "p",
+					Name:      "password",
+					Plaintext: []byte("x"),
+				})
+				return err
+			},
+			resource: "projects/p/secrets/password",
+		},
+		{
+			name: "storage_update",
+			call: func() error {
+				_, err := c.Update(ctx, &StorageUpdateRequest{
+					Bucket:    "b",
+					Object:    "password",
+					Plaintext: []byte("x"),
+				})
+				return err
+			},
+			resource: "gs://b/password",
+		},
+		{
+			name: "update_many",
+			call: func() error {
+				_, err := c.UpdateMany(ctx, []*SecretManagerUpdateRequest{
+					{Project: "p", Name: "team-app-key", Plaintext: []byte("x")},
+					{Project: "p", Name: "password", Plaintext: []byte("x")},
+				})
+				return err
+			},
+			resource: "projects/p/secrets/password",
+		},
+		{
+			name: "copy",
+			call: func() error {
+				_, err := c.Copy(ctx, &CopyRequest{
+					SourceBucket: "b",
+					SourceObject: "team-app-key",
+					Bucket:       "b",
+					Object:       "password",
+				})
+				return err
+			},
+			resource: "gs://b/password",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.call()
+			if !errors.Is(err, ErrNamingPolicyViolation) {
+				t.Fatalf("expected naming policy violation, got %v", err)
+			}
+			if act, exp := err.Error(), "must be <team>-<app>-<purpose>"; !strings.Contains(act, exp) {
+				t.Errorf("expected %q to contain %q", act, exp)
+			}
+
+			var serr *SecretError
+			if !errors.As(err, &serr) {
+				t.Fatalf("expected %T, got %T", serr, err)
+			}
+			if act, exp := serr.Resource, tc.resource; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/googleapi"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+const (
+	// PingBackendStorage is the Cloud Storage backend checked by Ping.
+	PingBackendStorage = "storage"
+
+	// PingBackendSecretManager is the Secret Manager backend checked by Ping.
+	PingBackendSecretManager = "secretmanager"
+
+	// PingBackendKMS is the Cloud KMS backend checked by Ping.
+	PingBackendKMS = "kms"
+)
+
+// pingResource is the name of the resource looked up by Ping. It is not
+// expected to exist.
+const pingResource = "berglas-ping"
+
+// PingResult is the status of a single backend checked by Ping.
+type PingResult struct {
+	// Backend is the name of the backend, such as PingBackendStorage.
+	Backend string
+
+	// Latency is how long the check took.
+	Latency time.Duration
+
+	// Err is the reason the backend is unhealthy, or nil if it is healthy.
+	Err error
+}
+
+// Ping is a top-level package function for checking the configured backends.
+// See Client.Ping for more details.
+func Ping(ctx context.Context) ([]*PingResult, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Ping(ctx)
+}
+
+// Ping verifies that the credentials are accepted by and the API is reachable
+// for Cloud Storage, Secret Manager, and Cloud KMS. Each backend is checked
+// concurrently by looking up a resource that is not expected to exist, so no
+// project, bucket, or permissions are required: any response other than an
+// authentication failure means the backend is healthy.
+//
+// It returns a result for each backend, in a fixed order, and an error naming
+// every unhealthy backend. This is suitable for readiness checks.
+func (c *Client) Ping(ctx context.Context) ([]*PingResult, error) {
+	logger := logging.FromContext(ctx)
+
+	logger.DebugContext(ctx, "ping.start")
+	defer logger.DebugContext(ctx, "ping.finish")
+
+	checks := []struct {
+		backend string
+		check   func(ctx context.Context) error
+	}{
+		{PingBackendStorage, func(ctx context.Context) error {
+			_, err := c.storageClient.Bucket(pingResource).Attrs(ctx)
+			return err
+		}},
+		{PingBackendSecretManager, func(ctx context.Context) error {
+			_, err := c.secretManagerClient.GetSecret(ctx, &secretspb.GetSecretRequest{
+				Name: fmt.Sprintf("projects/%s/secrets/%s", pingResource, pingResource),
+			})
+			return err
+		}},
+		{PingBackendKMS, func(ctx context.Context) error {
+			_, err := c.kmsClient.GetKeyRing(ctx, &kmspb.GetKeyRingRequest{
+				Name: fmt.Sprintf("projects/%s/locations/global/keyRings/%s",
+					pingResource, pingResource),
+			})
+			return err
+		}},
+	}
+
+	results := make([]*PingResult, len(checks))
+	var wg sync.WaitGroup
+	for i, chk := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			err := pingError(chk.check(ctx))
+			results[i] = &PingResult{
+				Backend: chk.backend,
+				Latency: time.Since(start),
+				Err:     err,
+			}
+
+			logger.DebugContext(ctx, "pinged backend",
+				"backend", chk.backend,
+				"error", err)
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Backend, r.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// pingError returns nil if err is a response from a reachable backend that
+// accepted the credentials, such as a not found or permission denied error.
+func pingError(err error) error {
+	if err == nil || errors.Is(err, storage.ErrBucketNotExist) {
+		return nil
+	}
+
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		if gerr.Code == http.StatusUnauthorized {
+			return fmt.Errorf("credentials rejected: %w", err)
+		}
+		return nil
+	}
+
+	if terr, ok := grpcstatus.FromError(err); ok {
+		switch terr.Code() {
+		case grpccodes.Unauthenticated:
+			return fmt.Errorf("credentials rejected: %w", err)
+		case grpccodes.NotFound, grpccodes.PermissionDenied, grpccodes.InvalidArgument,
+			grpccodes.FailedPrecondition:
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestPingError(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		err     error
+		healthy bool
+	}{
+		{"nil", nil, true},
+		{"bucket_not_exist", fmt.Errorf("wrapped: %w", storage.ErrBucketNotExist), true},
+		{"http_forbidden", &googleapi.Error{Code: http.StatusForbidden}, true},
+		{"http_unauthorized", &googleapi.Error{Code: http.StatusUnauthorized}, false},
+		{"grpc_not_found", grpcstatus.Error(grpccodes.NotFound, "nope"), true},
+		{"grpc_permission_denied", grpcstatus.Error(grpccodes.PermissionDenied, "nope"), true},
+		{"grpc_unauthenticated", grpcstatus.Error(grpccodes.Unauthenticated, "nope"), false},
+		{"grpc_unavailable", grpcstatus.Error(grpccodes.Unavailable, "nope"), false},
+		{"other", context.DeadlineExceeded, false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := pingError(tc.err); (err == nil) != tc.healthy {
+				t.Errorf("expected healthy to be %t, got %v", tc.healthy, err)
+			}
+		})
+	}
+}
+
+func TestClient_Ping(t *testing.T) {
+	testAcc(t)
+
+	ctx, client := testClient(t)
+
+	results, err := client.Ping(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var backends []string
+	for _, r := range results {
+		backends = append(backends, r.Backend)
+	}
+	if act, exp := fmt.Sprint(backends), "[storage secretmanager kms]"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+
+	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/option"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// Policy restricts how Resolve may place a secret. It is stored in the
+// MetadataPolicyKey object metadata of Cloud Storage and S3 secrets, and in the
+// MetadataPolicyKey label of Secret Manager secrets. The policy of Secret
+// Manager secrets is only enforced by clients created with
+// WithSecretManagerPolicy.
+type Policy string
+
+const (
+	// PolicyNone places no restriction on how a secret is resolved.
+	PolicyNone Policy = ""
+
+	// PolicyFileOnly only allows a secret to be resolved to a file, using a
+	// reference with a filepath or destination.
+	PolicyFileOnly Policy = "file-only"
+
+	// PolicyEnvOnly only allows a secret to be resolved to a value, such as an
+	// environment variable, and never to a file.
+	PolicyEnvOnly Policy = "env-only"
+)
+
+// IsPolicy returns true if s is a supported Policy.
+func IsPolicy(s string) bool {
+	switch Policy(s) {
+	case PolicyNone, PolicyFileOnly, PolicyEnvOnly:
+		return true
+	default:
+		return false
+	}
+}
+
+// WithPolicyOverride returns a client option that resolves secrets regardless
+// of their Policy. Use it sparingly, such as for break-glass access.
+func WithPolicyOverride() option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.policyOverride = true
+	}}
+}
+
+// WithSecretManagerPolicy returns a client option that enforces the Policy
+// label of Secret Manager secrets. Secret versions do not carry the labels of
+// their secret, so each access also reads the secret, which requires
+// secretmanager.secrets.get in addition to
+// secretmanager.versions.access. An access fails if the labels cannot be read.
+//
+// Without this option, Secret Manager secrets are resolved without checking
+// their Policy.
+func WithSecretManagerPolicy() option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.secretManagerPolicies = true
+	}}
+}
+
+// checkPolicy returns an error wrapping ErrPolicyViolation if the secret the
+// reference points to, which has the given policy, may not be resolved the way
+// the reference asks. A reference with a filepath resolves to a file; any other
+// reference resolves to a value, which Replace and ResolveEnv place in an
+// environment variable.
+func (c *Client) checkPolicy(ref *Reference, policy Policy) error {
+	if c.policyOverride {
+		return nil
+	}
+
+	toFile := ref.Filepath() != ""
+	switch {
+	case policy == PolicyFileOnly && !toFile:
+		return policyError(ref, fmt.Errorf("%w: secret is %s and the reference has no filepath",
+			ErrPolicyViolation, policy))
+	case policy == PolicyEnvOnly && toFile:
+		return policyError(ref, fmt.Errorf("%w: secret is %s and the reference has a filepath",
+			ErrPolicyViolation, policy))
+	}
+	return nil
+}
+
+// policyError wraps err as a SecretError naming the secret the reference
+// points to.
+func policyError(ref *Reference, err error) error {
+	switch ref.Type() {
+	case ReferenceTypeSecretManager:
+		return secretManagerError(err, ref.Project(), ref.Name())
+	case ReferenceTypeS3:
+		return s3Error(err, ref.Bucket(), ref.Object())
+	default:
+		return storageError(err, ref.Bucket(), ref.Object())
+	}
+}
+
+// secretManagerPolicy returns the Policy label of the secret.
+func (c *Client) secretManagerPolicy(ctx context.Context, project, name string) (Policy, error) {
+	secret, err := c.secretManagerClient.GetSecret(ctx, &secretspb.GetSecretRequest{
+		Name: fmt.Sprintf("projects/%s/secrets/%s", project, name),
+	})
+	if err != nil {
+		if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.NotFound {
+			return PolicyNone, secretManagerError(ErrSecretDoesNotExist, project, name)
+		}
+		return PolicyNone, err
+	}
+	return Policy(secret.GetLabels()[MetadataPolicyKey]), nil
+}
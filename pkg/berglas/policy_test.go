@@ -0,0 +1,191 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_Resolve_policy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	keyPath := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := KeySchemeLocal + "://" + keyPath
+
+	store := &memS3Store{}
+	c := &Client{}
+	WithS3Store(store).(*clientOption).apply(c)
+
+	for _, name := range []string{"none", "file", "env"} {
+		if _, err := c.Create(ctx, &StorageS3CreateRequest{
+			Bucket:    "b",
+			Object:    name,
+			Key:       key,
+			Plaintext: []byte("secret"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.objects["b/file"].Metadata[MetadataPolicyKey] = string(PolicyFileOnly)
+	store.objects["b/env"].Metadata[MetadataPolicyKey] = string(PolicyEnvOnly)
+
+	cases := []struct {
+		name    string
+		ref     string
+		allowed bool
+	}{
+		{
+			name:    "none_value",
+			ref:     "s3://b/none",
+			allowed: true,
+		},
+		{
+			name:    "none_file",
+			ref:     "s3://b/none?destination=" + filepath.Join(dir, "none"),
+			allowed: true,
+		},
+		{
+			name:    "file_only_value",
+			ref:     "s3://b/file",
+			allowed: false,
+		},
+		{
+			name:    "file_only_file",
+			ref:     "s3://b/file?destination=" + filepath.Join(dir, "file"),
+			allowed: true,
+		},
+		{
+			name:    "env_only_value",
+			ref:     "s3://b/env",
+			allowed: true,
+		},
+		{
+			name:    "env_only_file",
+			ref:     "s3://b/env?destination=" + filepath.Join(dir, "env"),
+			allowed: false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := c.Resolve(ctx, tc.ref)
+			if tc.allowed {
+				if err != nil {
+					t.Errorf("expected %q to resolve, got %v", tc.ref, err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrPolicyViolation) {
+				t.Errorf("expected policy violation, got %v", err)
+			}
+			var serr *SecretError
+			if !errors.As(err, &serr) {
+				t.Fatalf("expected %T, got %T", serr, err)
+			}
+			if !strings.HasPrefix(tc.ref, serr.Resource) {
+				t.Errorf("expected %q to name %q", serr.Resource, tc.ref)
+			}
+		})
+	}
+
+	t.Run("override", func(t *testing.T) {
+		t.Parallel()
+
+		override := &Client{}
+		WithS3Store(store).(*clientOption).apply(override)
+		WithPolicyOverride().(*clientOption).apply(override)
+
+		if _, err := override.Resolve(ctx, "s3://b/file"); err != nil {
+			t.Errorf("expected override to resolve, got %v", err)
+		}
+	})
+}
+
+func TestClient_Resolve_policyCached(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	keyPath := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := KeySchemeLocal + "://" + keyPath
+
+	store := &flakyS3Store{}
+	c := &Client{}
+	WithS3Store(store).(*clientOption).apply(c)
+	WithDiskCache(t.TempDir(), key, time.Hour).(*clientOption).apply(c)
+
+	if _, err := c.Create(ctx, &StorageS3CreateRequest{
+		Bucket:    "b",
+		Object:    "file",
+		Key:       key,
+		Plaintext: []byte("secret"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	store.objects["b/file"].Metadata[MetadataPolicyKey] = string(PolicyFileOnly)
+	store.reads.Store(0)
+
+	if _, err := c.Resolve(ctx, "s3://b/file?destination="+filepath.Join(dir, "file")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The policy is cached with the value
+	if _, err := c.Resolve(ctx, "s3://b/file"); !errors.Is(err, ErrPolicyViolation) {
+		t.Errorf("expected policy violation, got %v", err)
+	}
+	if act, exp := store.reads.Load(), int32(1); act != exp {
+		t.Errorf("expected %d reads, got %d", exp, act)
+	}
+}
+
+func TestStorageCreateRequest_Validate_policy(t *testing.T) {
+	t.Parallel()
+
+	err := (&StorageCreateRequest{
+		Bucket:    "b",
+		Object:    "o",
+		Key:       "projects/p/locations/l/keyRings/r/cryptoKeys/k",
+		Plaintext: []byte("p"),
+		Policy:    "read-only",
+	}).Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if act, exp := err.Error(), `invalid policy "read-only"`; !strings.Contains(act, exp) {
+		t.Errorf("expected %q to contain %q", act, exp)
+	}
+}
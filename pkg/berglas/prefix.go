@@ -0,0 +1,101 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/option"
+)
+
+// WithAllowedPrefixes returns a client option that restricts Access and Resolve
+// to secrets whose reference starts with one of the given prefixes, such as
+// "sm://team-a-" or "berglas://team-a-bucket/". References are compared in
+// their canonical form without a version or generation: "sm://P/N",
+// "berglas://B/O", and "s3://B/O". Requests for any other secret fail with an
+// error wrapping ErrReferenceNotAllowed before any API call is made.
+//
+// This lets a shared component resolve references supplied by its users
+// without reading secrets those users should not reach through it. Calling the
+// option more than once adds to the allowlist.
+func WithAllowedPrefixes(prefixes ...string) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.allowedPrefixes = append(c.allowedPrefixes, prefixes...)
+	}}
+}
+
+// prefixAllowed reports whether ref starts with one of the client's allowed
+// prefixes. Every reference is allowed when no prefixes are configured.
+func (c *Client) prefixAllowed(ref string) bool {
+	if len(c.allowedPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.allowedPrefixes {
+		if strings.HasPrefix(ref, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAllowedRequest returns an error wrapping ErrReferenceNotAllowed if the
+// secret the access request points to is outside the client's allowlist.
+func (c *Client) checkAllowedRequest(i accessRequest) error {
+	if len(c.allowedPrefixes) == 0 {
+		return nil
+	}
+
+	switch t := i.(type) {
+	case *SecretManagerAccessRequest:
+		if !c.prefixAllowed(fmt.Sprintf("sm://%s/%s", t.Project, t.Name)) {
+			return secretManagerError(ErrReferenceNotAllowed, t.Project, t.Name)
+		}
+	case *StorageAccessRequest:
+		if !c.prefixAllowed(fmt.Sprintf("berglas://%s/%s", t.Bucket, t.Object)) {
+			return storageError(ErrReferenceNotAllowed, t.Bucket, t.Object)
+		}
+	case *StorageS3AccessRequest:
+		if !c.prefixAllowed(fmt.Sprintf("s3://%s/%s", t.Bucket, t.Object)) {
+			return s3Error(ErrReferenceNotAllowed, t.Bucket, t.Object)
+		}
+	default:
+		return fmt.Errorf("%w: unknown access type %T", ErrReferenceNotAllowed, t)
+	}
+	return nil
+}
+
+// checkAllowedReference returns an error wrapping ErrReferenceNotAllowed if
+// the secret, or for a directory reference the prefix, that ref points to is
+// outside the client's allowlist.
+func (c *Client) checkAllowedReference(ref *Reference) error {
+	if len(c.allowedPrefixes) == 0 {
+		return nil
+	}
+
+	var canonical string
+	switch ref.Type() {
+	case ReferenceTypeSecretManager:
+		canonical = fmt.Sprintf("sm://%s/%s", ref.Project(), ref.Name())
+	case ReferenceTypeStorage:
+		canonical = fmt.Sprintf("berglas://%s/%s", ref.Bucket(), ref.Object())
+	case ReferenceTypeS3:
+		canonical = fmt.Sprintf("s3://%s/%s", ref.Bucket(), ref.Object())
+	}
+	if !c.prefixAllowed(canonical) {
+		return policyError(ref, ErrReferenceNotAllowed)
+	}
+	return nil
+}
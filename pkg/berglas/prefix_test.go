@@ -0,0 +1,149 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClient_allowedPrefixes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	keyPath := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := KeySchemeLocal + "://" + keyPath
+
+	store := &memS3Store{}
+	c := &Client{}
+	WithS3Store(store).(*clientOption).apply(c)
+	WithAllowedPrefixes("s3://team-a/", "s3://shared/team-a-").(*clientOption).apply(c)
+
+	for _, obj := range [][2]string{
+		{"team-a", "db"},
+		{"team-b", "db"},
+		{"shared", "team-a-token"},
+		{"shared", "team-b-token"},
+	} {
+		if _, err := c.Create(ctx, &StorageS3CreateRequest{
+			Bucket:    obj[0],
+			Object:    obj[1],
+			Key:       key,
+			Plaintext: []byte("secret"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name    string
+		ref     string
+		allowed bool
+	}{
+		{
+			name:    "bucket",
+			ref:     "s3://team-a/db",
+			allowed: true,
+		},
+		{
+			name:    "object_prefix",
+			ref:     "s3://shared/team-a-token",
+			allowed: true,
+		},
+		{
+			name:    "other_bucket",
+			ref:     "s3://team-b/db",
+			allowed: false,
+		},
+		{
+			name:    "other_object",
+			ref:     "s3://shared/team-b-token",
+			allowed: false,
+		},
+		{
+			name:    "fallback_outside",
+			ref:     "s3://team-b/db|s3://team-a/db",
+			allowed: true,
+		},
+		{
+			name:    "other_type",
+			ref:     "sm://team-a/db",
+			allowed: false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := c.Resolve(ctx, tc.ref)
+			if tc.allowed {
+				if err != nil {
+					t.Errorf("expected %q to resolve, got %v", tc.ref, err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrReferenceNotAllowed) {
+				t.Errorf("expected reference not allowed, got %v", err)
+			}
+			var serr *SecretError
+			if !errors.As(err, &serr) {
+				t.Fatalf("expected %T, got %T", serr, err)
+			}
+		})
+	}
+
+	t.Run("access", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := c.Access(ctx, &StorageS3AccessRequest{
+			Bucket: "team-a",
+			Object: "db",
+		}); err != nil {
+			t.Errorf("expected access to succeed, got %v", err)
+		}
+
+		_, err := c.Access(ctx, &StorageS3AccessRequest{
+			Bucket: "team-b",
+			Object: "db",
+		})
+		if !errors.Is(err, ErrReferenceNotAllowed) {
+			t.Errorf("expected reference not allowed, got %v", err)
+		}
+	})
+
+	t.Run("unrestricted", func(t *testing.T) {
+		t.Parallel()
+
+		open := &Client{}
+		WithS3Store(store).(*clientOption).apply(open)
+
+		if _, err := open.Resolve(ctx, "s3://team-b/db"); err != nil {
+			t.Errorf("expected unrestricted client to resolve, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import "context"
+
+// progressKey points to the value in the context where the progress reporter
+// is stored.
+const progressKey = contextKey("progress")
+
+// contextKey is a private string type to prevent collisions in the context map.
+type contextKey string
+
+// Progress receives progress updates from long-running operations, such as
+// deleting all generations of a Cloud Storage secret. Implementations must be
+// safe for concurrent use, since operations may complete items in parallel.
+type Progress interface {
+	// Start is called once before any items are processed. Total is the number
+	// of items to process, or -1 if the total is not known in advance.
+	Start(total int)
+
+	// Increment is called as items complete.
+	Increment(n int)
+
+	// Finish is called once after all items are processed, even on failure.
+	Finish()
+}
+
+// DiscardProgress is a Progress that discards all updates. It is used when no
+// progress reporter is attached to the context.
+var DiscardProgress Progress = noopProgress{}
+
+// WithProgress creates a new context with the provided progress reporter
+// attached. Long-running client operations report their progress to it.
+func WithProgress(ctx context.Context, p Progress) context.Context {
+	return context.WithValue(ctx, progressKey, p)
+}
+
+// progressFromContext returns the progress reporter stored in the context. If
+// no such reporter exists, a reporter that discards all updates is returned.
+func progressFromContext(ctx context.Context) Progress {
+	if p, ok := ctx.Value(progressKey).(Progress); ok {
+		return p
+	}
+	return DiscardProgress
+}
+
+// noopProgress is a Progress that discards all updates.
+type noopProgress struct{}
+
+func (noopProgress) Start(int)     {}
+func (noopProgress) Increment(int) {}
+func (noopProgress) Finish()       {}
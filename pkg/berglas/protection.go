@@ -0,0 +1,126 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// protectionLevelNames returns the sorted list of valid Cloud KMS protection
+// levels, excluding the unspecified value.
+func protectionLevelNames() []string {
+	names := make([]string, 0, len(kmspb.ProtectionLevel_value))
+	for name, v := range kmspb.ProtectionLevel_value {
+		if v == int32(kmspb.ProtectionLevel_PROTECTION_LEVEL_UNSPECIFIED) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isProtectionLevel returns true if s is a valid, specified Cloud KMS
+// protection level such as "HSM" or "EXTERNAL".
+func isProtectionLevel(s string) bool {
+	v, ok := kmspb.ProtectionLevel_value[s]
+	return ok && v != int32(kmspb.ProtectionLevel_PROTECTION_LEVEL_UNSPECIFIED)
+}
+
+// kmsKeyProtectionLevel looks up the given Cloud KMS key and returns the
+// protection level of the version that will be used for encryption. It returns
+// an error if the key cannot be used for envelope encryption.
+//
+// Encrypting only requires cloudkms.cryptoKeyVersions.useToEncrypt, so callers
+// may not be able to read key metadata. In that case, the lookup is skipped and
+// an empty protection level is returned without an error.
+//
+// Berglas generates a new data encryption key for every write and never caches
+// data encryption keys, so no further checks are needed for external (EKM)
+// keys or keys subject to Assured Workloads restrictions. Keys for other key
+// wrappers have no protection level.
+func (c *Client) kmsKeyProtectionLevel(ctx context.Context, key string) (string, error) {
+	logger := logging.FromContext(ctx).With("key", key)
+
+	if !isCloudKMSKey(key) {
+		logger.DebugContext(ctx, "skipping kms key check, not a cloud kms key")
+		return "", nil
+	}
+
+	cryptoKey, err := c.kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+		Name: kmsKeyTrimVersion(key),
+	})
+	if err != nil {
+		if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.PermissionDenied {
+			logger.DebugContext(ctx, "skipping kms key check, permission denied", "error", err)
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get kms key: %w", err)
+	}
+
+	if cryptoKey.GetPurpose() != kmspb.CryptoKey_ENCRYPT_DECRYPT {
+		return "", fmt.Errorf("kms key %s has purpose %s, must be %s",
+			cryptoKey.GetName(), cryptoKey.GetPurpose(), kmspb.CryptoKey_ENCRYPT_DECRYPT)
+	}
+
+	version := cryptoKey.GetPrimary()
+	if kmsKeyIncludesVersion(key) {
+		version, err = c.kmsClient.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+			Name: key,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to get kms key version: %w", err)
+		}
+	}
+	if version == nil {
+		return "", fmt.Errorf("kms key %s has no primary version", cryptoKey.GetName())
+	}
+
+	if version.GetState() != kmspb.CryptoKeyVersion_ENABLED {
+		return "", fmt.Errorf("kms key version %s is %s, must be %s",
+			version.GetName(), version.GetState(), kmspb.CryptoKeyVersion_ENABLED)
+	}
+
+	level := version.GetProtectionLevel().String()
+	logger.DebugContext(ctx, "found kms key protection level", "protection_level", level)
+	return level, nil
+}
+
+// checkProtectionLevel returns an error if the actual protection level does
+// not match the required one. An empty required level always matches. An
+// empty actual level means the key could not be inspected, which is an error
+// when a level is required.
+func checkProtectionLevel(key, actual, required string) error {
+	if required == "" {
+		return nil
+	}
+	if actual == "" {
+		return fmt.Errorf("cannot verify protection level of kms key %s: "+
+			"missing permission cloudkms.cryptoKeys.get", key)
+	}
+	if !strings.EqualFold(actual, required) {
+		return fmt.Errorf("kms key %s has protection level %s, must be %s",
+			key, actual, required)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"testing"
+)
+
+func TestCheckProtectionLevel(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		actual   string
+		required string
+		err      bool
+	}{
+		{
+			name:   "not_required",
+			actual: "SOFTWARE",
+		},
+		{
+			name: "not_required_unknown",
+		},
+		{
+			name:     "match",
+			actual:   "EXTERNAL",
+			required: "EXTERNAL",
+		},
+		{
+			name:     "mismatch",
+			actual:   "SOFTWARE",
+			required: "HSM",
+			err:      true,
+		},
+		{
+			name:     "unknown",
+			required: "EXTERNAL_VPC",
+			err:      true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := checkProtectionLevel("k", tc.actual, tc.required)
+			if (err != nil) != tc.err {
+				t.Errorf("expected error to be %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestIsProtectionLevel(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range protectionLevelNames() {
+		if !isProtectionLevel(name) {
+			t.Errorf("expected %q to be a protection level", name)
+		}
+	}
+
+	for _, name := range []string{"", "hsm", "PROTECTION_LEVEL_UNSPECIFIED"} {
+		if isProtectionLevel(name) {
+			t.Errorf("expected %q to not be a protection level", name)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+)
+
+// StoragePruneRequest is used as input to delete expired secrets from a Cloud
+// Storage bucket.
+type StoragePruneRequest struct {
+	// Bucket is the name of the bucket where the secrets live.
+	Bucket string
+
+	// Prefix matches secret names to filter.
+	Prefix string
+
+	// DryRun reports which secrets are expired without deleting them.
+	DryRun bool
+}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StoragePruneRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	return v.err()
+}
+
+// StoragePruneGenerationsRequest is used as input to delete old generations
+// of a secret in Cloud Storage.
+type StoragePruneGenerationsRequest struct {
+	// Bucket is the name of the bucket where the secret lives.
+	Bucket string
+
+	// Object is the name of the secret in Cloud Storage.
+	Object string
+
+	// Keep is the number of most recent generations to keep, including the
+	// live generation. It must be at least 1.
+	Keep int
+
+	// DryRun reports which generations would be deleted without deleting them.
+	DryRun bool
+}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StoragePruneGenerationsRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	if r.Keep < 1 {
+		v.addf("Keep", "invalid keep %d: must be at least 1", r.Keep)
+	}
+	return v.err()
+}
+
+// PruneResponse is the response from a prune call.
+type PruneResponse struct {
+	// Secrets are the expired secrets or old generations that were deleted, or
+	// would have been deleted for a dry run.
+	Secrets []*Secret
+}
+
+// Prune is a top-level package function for deleting expired secrets. For
+// large volumes of secrets, please create a client instead.
+func Prune(ctx context.Context, i *StoragePruneRequest) (*PruneResponse, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Prune(ctx, i)
+}
+
+// Prune deletes all secrets in a Cloud Storage bucket whose expiration time
+// has passed, including all of their generations. Secrets without an
+// expiration are never deleted.
+func (c *Client) Prune(ctx context.Context, i *StoragePruneRequest) (*PruneResponse, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+
+	if c.readOnly && !i.DryRun {
+		return nil, ErrReadOnly
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	bucket := i.Bucket
+
+	logger := logging.FromContext(ctx).With(
+		"bucket", bucket,
+		"prefix", i.Prefix,
+		"dry_run", i.DryRun,
+	)
+
+	logger.DebugContext(ctx, "prune.start")
+	defer logger.DebugContext(ctx, "prune.finish")
+
+	list, err := c.storageList(ctx, &StorageListRequest{
+		Bucket: bucket,
+		Prefix: i.Prefix,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	expired := expiredSecrets(list.Secrets, time.Now())
+	logger.DebugContext(ctx, "found expired secrets", "count", len(expired))
+
+	if i.DryRun {
+		return &PruneResponse{Secrets: expired}, nil
+	}
+
+	pruned := make([]*Secret, 0, len(expired))
+	for _, s := range expired {
+		logger.DebugContext(ctx, "deleting expired secret",
+			"object", s.Name,
+			"expires_at", s.ExpiresAt)
+
+		if err := c.storageDelete(ctx, &StorageDeleteRequest{
+			Bucket: bucket,
+			Object: s.Name,
+		}); err != nil {
+			return &PruneResponse{Secrets: pruned},
+				fmt.Errorf("failed to delete expired secret %s: %w", s.Name, err)
+		}
+		pruned = append(pruned, s)
+	}
+
+	return &PruneResponse{Secrets: pruned}, nil
+}
+
+// PruneGenerations is a top-level package function for deleting old
+// generations of a secret. For large volumes of secrets, please create a client
+// instead.
+func PruneGenerations(ctx context.Context, i *StoragePruneGenerationsRequest) (*PruneResponse, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.PruneGenerations(ctx, i)
+}
+
+// PruneGenerations deletes the noncurrent generations of a Cloud Storage secret
+// beyond the Keep most recent, oldest first. The live generation is never
+// deleted. This complements a bucket-wide lifecycle rule for secrets that need
+// stricter history trimming.
+func (c *Client) PruneGenerations(ctx context.Context, i *StoragePruneGenerationsRequest) (*PruneResponse, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+
+	if c.readOnly && !i.DryRun {
+		return nil, ErrReadOnly
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	bucket := i.Bucket
+	object := i.Object
+
+	logger := logging.FromContext(ctx).With(
+		"bucket", bucket,
+		"object", object,
+		"keep", i.Keep,
+		"dry_run", i.DryRun,
+	)
+
+	logger.DebugContext(ctx, "prune_generations.start")
+	defer logger.DebugContext(ctx, "prune_generations.finish")
+
+	list, err := c.storageList(ctx, &StorageListRequest{
+		Bucket:      bucket,
+		Prefix:      object,
+		Generations: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list generations: %w", err)
+	}
+
+	generations := make([]*Secret, 0, len(list.Secrets))
+	for _, s := range list.Secrets {
+		// The prefix also matches other secrets that start with this name.
+		if s.Name == object {
+			generations = append(generations, s)
+		}
+	}
+	if len(generations) == 0 {
+		return nil, storageError(ErrSecretDoesNotExist, bucket, object)
+	}
+
+	old := oldGenerations(generations, i.Keep)
+	logger.DebugContext(ctx, "found old generations", "count", len(old))
+
+	if i.DryRun {
+		return &PruneResponse{Secrets: old}, nil
+	}
+
+	pruned := make([]*Secret, 0, len(old))
+	for _, s := range old {
+		logger.DebugContext(ctx, "deleting old generation", "generation", s.Generation)
+
+		if err := c.storageClient.
+			Bucket(bucket).
+			Object(object).
+			Generation(s.Generation).
+			Delete(ctx); err != nil {
+			return &PruneResponse{Secrets: pruned},
+				fmt.Errorf("failed to delete generation %d of %s: %w", s.Generation, object, err)
+		}
+		pruned = append(pruned, s)
+	}
+
+	return &PruneResponse{Secrets: pruned}, nil
+}
+
+// oldGenerations returns the generations beyond the keep most recent, oldest
+// first.
+func oldGenerations(generations []*Secret, keep int) []*Secret {
+	sorted := make([]*Secret, len(generations))
+	copy(sorted, generations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Generation > sorted[j].Generation
+	})
+
+	if len(sorted) <= keep {
+		return nil
+	}
+	old := sorted[keep:]
+
+	// Delete the oldest first so an interrupted prune never leaves a gap.
+	sort.Slice(old, func(i, j int) bool {
+		return old[i].Generation < old[j].Generation
+	})
+	return old
+}
+
+// expiredSecrets returns the secrets with an expiration at or before now.
+func expiredSecrets(secrets []*Secret, now time.Time) []*Secret {
+	var result []*Secret
+	for _, s := range secrets {
+		if !s.ExpiresAt.IsZero() && !s.ExpiresAt.After(now) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestExpiredSecrets(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	secrets := []*Secret{
+		{Name: "never"},
+		{Name: "past", ExpiresAt: now.Add(-time.Minute)},
+		{Name: "now", ExpiresAt: now},
+		{Name: "future", ExpiresAt: now.Add(time.Minute)},
+	}
+
+	var names []string
+	for _, s := range expiredSecrets(secrets, now) {
+		names = append(names, s.Name)
+	}
+
+	if exp := []string{"past", "now"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("expected %q to be %q", names, exp)
+	}
+}
+
+func TestOldGenerations(t *testing.T) {
+	t.Parallel()
+
+	generations := []*Secret{
+		{Generation: 3},
+		{Generation: 5},
+		{Generation: 1},
+		{Generation: 4},
+		{Generation: 2},
+	}
+
+	cases := []struct {
+		name string
+		keep int
+		exp  []int64
+	}{
+		{
+			name: "keep_two",
+			keep: 2,
+			exp:  []int64{1, 2, 3},
+		},
+		{
+			name: "keep_all",
+			keep: 5,
+			exp:  nil,
+		},
+		{
+			name: "keep_more",
+			keep: 10,
+			exp:  nil,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var act []int64
+			for _, s := range oldGenerations(generations, tc.keep) {
+				act = append(act, s.Generation)
+			}
+			if !reflect.DeepEqual(act, tc.exp) {
+				t.Errorf("expected %v to be %v", act, tc.exp)
+			}
+		})
+	}
+}
+
+func TestSecretFromAttrs_expiresAt(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		value string
+		exp   time.Time
+	}{
+		{
+			name: "missing",
+		},
+		{
+			name:  "valid",
+			value: "2024-01-02T03:04:05Z",
+			exp:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		{
+			name:  "malformed",
+			value: "tomorrow",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			attrs := &storage.ObjectAttrs{
+				Name:     "o",
+				Metadata: map[string]string{},
+			}
+			if tc.value != "" {
+				attrs.Metadata[MetadataExpiresAtKey] = tc.value
+			}
+
+			if act := secretFromAttrs("b", attrs, nil).ExpiresAt; !act.Equal(tc.exp) {
+				t.Errorf("expected %q to be %q", act, tc.exp)
+			}
+		})
+	}
+}
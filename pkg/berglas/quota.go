@@ -0,0 +1,64 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/grpc/metadata"
+)
+
+// userProjectHeader is the Google API system parameter that sets the project
+// charged for quota and billing.
+const userProjectHeader = "x-goog-user-project"
+
+// quotaProjectKey is the context key for the quota project.
+type quotaProjectKey struct{}
+
+// WithQuotaProject returns a context whose requests charge quota and billing
+// to the given project instead of the project of the credentials. Secret
+// Manager and Cloud KMS requests carry it as the X-Goog-User-Project system
+// parameter, and Cloud Storage reads and listings as the user project. A later
+// call replaces the project. An empty project returns ctx unchanged.
+func WithQuotaProject(ctx context.Context, project string) context.Context {
+	if project == "" {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, quotaProjectKey{}, project)
+
+	// Replace rather than append, so a nested call overrides the project
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(userProjectHeader, project)
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// quotaProjectFromContext returns the quota project set with WithQuotaProject,
+// if any.
+func quotaProjectFromContext(ctx context.Context) string {
+	project, _ := ctx.Value(quotaProjectKey{}).(string)
+	return project
+}
+
+// storageBucket returns a handle for the bucket that charges the quota project
+// in the context, if any.
+func (c *Client) storageBucket(ctx context.Context, bucket string) *storage.BucketHandle {
+	h := c.storageClient.Bucket(bucket)
+	if project := quotaProjectFromContext(ctx); project != "" {
+		h = h.UserProject(project)
+	}
+	return h
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWithQuotaProject(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		projects []string
+		exp      []string
+	}{
+		{
+			name:     "empty",
+			projects: []string{""},
+			exp:      nil,
+		},
+		{
+			name:     "project",
+			projects: []string{"foreign"},
+			exp:      []string{"foreign"},
+		},
+		{
+			name:     "replaced",
+			projects: []string{"first", "second"},
+			exp:      []string{"second"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := WithJustification(context.Background(), "ticket-123")
+			for _, project := range tc.projects {
+				ctx = WithQuotaProject(ctx, project)
+			}
+
+			md, _ := metadata.FromOutgoingContext(ctx)
+			if act, exp := md.Get(userProjectHeader), tc.exp; !reflect.DeepEqual(act, exp) {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+			if act, exp := md.Get(requestReasonHeader), []string{"ticket-123"}; !reflect.DeepEqual(act, exp) {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+
+			var exp string
+			if len(tc.exp) > 0 {
+				exp = tc.exp[0]
+			}
+			if act := quotaProjectFromContext(ctx); act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
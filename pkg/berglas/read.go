@@ -16,14 +16,11 @@ package berglas
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"io"
 	"path"
 	"sort"
-	"strings"
 
-	"cloud.google.com/go/kms/apiv1/kmspb"
 	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
@@ -33,6 +30,7 @@ import (
 
 type readRequest interface {
 	isReadRequest()
+	Validate() error
 }
 
 // StorageReadRequest is used as input to read a secret from Cloud Storage
@@ -50,6 +48,15 @@ type StorageReadRequest struct {
 
 func (r *StorageReadRequest) isReadRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageReadRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	return v.err()
+}
+
 // ReadRequest is an alias for StorageReadRequest for backwards-compatibility.
 // New clients should use StorageReadRequest.
 type ReadRequest = StorageReadRequest
@@ -63,16 +70,29 @@ type SecretManagerReadRequest struct {
 	// Name is the name of the secret to read.
 	Name string
 
-	// Version is the version of the secret to read.
+	// Version is the version of the secret to read. Besides a version number
+	// or alias, this may be a constraint resolved against the enabled versions:
+	// "latest-N" for the Nth version before latest, or ">=N", ">N", "<=N", or
+	// "<N" for the newest version matching the comparison.
 	Version string
 }
 
 func (r *SecretManagerReadRequest) isReadRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerReadRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	v.require("Name", r.Name, "missing secret name")
+	v.versionConstraint("Version", r.Version)
+	return v.err()
+}
+
 // Read is a top-level package function for reading an entire secret object. It
 // returns attributes about the secret object, including the plaintext.
 func Read(ctx context.Context, i readRequest) (*Secret, error) {
-	client, err := New(ctx)
+	client, err := DefaultClient(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -81,17 +101,24 @@ func Read(ctx context.Context, i readRequest) (*Secret, error) {
 
 // Read read a secret. When given a SecretManagerReadRequest, this reads a
 // secret from Secret Manager. When given a StorageReadRequest, this reads a
-// secret stored in Cloud Storage.
+// secret stored in Cloud Storage. When given a StorageS3ReadRequest, this reads
+// a secret stored in an S3-compatible store.
 func (c *Client) Read(ctx context.Context, i readRequest) (*Secret, error) {
 	if i == nil {
 		return nil, fmt.Errorf("missing request")
 	}
 
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
 	switch t := i.(type) {
 	case *SecretManagerReadRequest:
 		return c.secretManagerRead(ctx, t)
 	case *StorageReadRequest:
 		return c.storageRead(ctx, t)
+	case *StorageS3ReadRequest:
+		return c.s3Read(ctx, t.Bucket, t.Object)
 	default:
 		return nil, fmt.Errorf("unknown read type %T", t)
 	}
@@ -99,15 +126,7 @@ func (c *Client) Read(ctx context.Context, i readRequest) (*Secret, error) {
 
 func (c *Client) secretManagerRead(ctx context.Context, i *SecretManagerReadRequest) (*Secret, error) {
 	project := i.Project
-	if project == "" {
-		return nil, fmt.Errorf("missing project")
-	}
-
 	name := i.Name
-	if name == "" {
-		return nil, fmt.Errorf("missing secret name")
-	}
-
 	version := i.Version
 	if version == "" {
 		version = "latest"
@@ -122,6 +141,11 @@ func (c *Client) secretManagerRead(ctx context.Context, i *SecretManagerReadRequ
 	logger.DebugContext(ctx, "read.start")
 	defer logger.DebugContext(ctx, "read.finish")
 
+	version, err := c.secretManagerResolveVersion(ctx, project, name, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+
 	logger.DebugContext(ctx, "reading secret version")
 
 	versionResp, err := c.secretManagerClient.GetSecretVersion(ctx, &secretspb.GetSecretVersionRequest{
@@ -130,7 +154,7 @@ func (c *Client) secretManagerRead(ctx context.Context, i *SecretManagerReadRequ
 	if err != nil {
 		terr, ok := grpcstatus.FromError(err)
 		if ok && terr.Code() == grpccodes.NotFound {
-			return nil, errSecretDoesNotExist
+			return nil, secretManagerError(ErrSecretDoesNotExist, project, name)
 		}
 		return nil, fmt.Errorf("failed to read secret: %w", err)
 	}
@@ -160,6 +184,7 @@ func (c *Client) secretManagerRead(ctx context.Context, i *SecretManagerReadRequ
 		Parent:    project,
 		Name:      name,
 		Version:   path.Base(versionResp.Name),
+		State:     versionResp.GetState().String(),
 		Plaintext: accessResp.Payload.Data,
 		UpdatedAt: timestampToTime(versionResp.CreateTime),
 		Locations: locations,
@@ -168,15 +193,7 @@ func (c *Client) secretManagerRead(ctx context.Context, i *SecretManagerReadRequ
 
 func (c *Client) storageRead(ctx context.Context, i *StorageReadRequest) (*Secret, error) {
 	bucket := i.Bucket
-	if bucket == "" {
-		return nil, fmt.Errorf("missing bucket name")
-	}
-
 	object := i.Object
-	if object == "" {
-		return nil, fmt.Errorf("missing object name")
-	}
-
 	generation := i.Generation
 	if generation == 0 {
 		generation = -1
@@ -191,91 +208,121 @@ func (c *Client) storageRead(ctx context.Context, i *StorageReadRequest) (*Secre
 	logger.DebugContext(ctx, "read.start")
 	defer logger.DebugContext(ctx, "read.finish")
 
-	// Get attributes to find the KMS key
-	logger.DebugContext(ctx, "reading attributes from storage")
-
-	attrs, err := c.storageClient.
-		Bucket(bucket).
-		Object(object).
-		Generation(generation).
-		Attrs(ctx)
-	if err == storage.ErrObjectNotExist {
-		return nil, errSecretDoesNotExist
-	}
+	attrs, data, err := c.storageReadBlob(ctx, bucket, object, generation)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read secret metadata: %w", err)
-	}
-	if attrs.Metadata == nil || attrs.Metadata[MetadataKMSKey] == "" {
-		return nil, fmt.Errorf("missing kms key in secret metadata")
+		return nil, err
 	}
 	key := attrs.Metadata[MetadataKMSKey]
 
 	logger = logger.With("key", key)
 	logger.DebugContext(ctx, "found kms key")
 
-	// Download the file from GCS
-	logger.DebugContext(ctx, "downloading file from storage")
+	// Split into parts
+	logger.DebugContext(ctx, "deconstructing and decoding ciphertext into parts")
 
-	ior, err := c.storageClient.
-		Bucket(bucket).
-		Object(object).
-		Generation(generation).
-		NewReader(ctx)
-	if err == storage.ErrObjectNotExist {
-		return nil, fmt.Errorf("secret object not found")
+	alg, encDEK, ciphertext, err := envelopeDecode(string(data))
+	if err != nil {
+		return nil, err
 	}
+
+	// Decrypt the DEK using a KMS key
+	logger.DebugContext(ctx, "decrypting dek using kms")
+
+	dek, err := c.unwrapDEK(ctx, key, encDEK,
+		storageAAD(storageAADBound(attrs.Metadata), bucket, object, c.aadContext,
+			storageWriteCounter(attrs.Metadata)))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read secret: %w", err)
+		if storageAADBound(attrs.Metadata) {
+			return nil, fmt.Errorf("failed to decrypt dek (check that the secret "+
+				"was not copied and the aad context matches): %w", err)
+		}
+		return nil, fmt.Errorf("failed to decrypt dek (check that the secret "+
+			"was not copied from another object name): %w", err)
 	}
+	defer c.wipe(dek)
 
-	// Read the entire response into memory
-	logger.DebugContext(ctx, "reading object into memory")
+	// Decrypt with the local key
+	logger.DebugContext(ctx, "decrypting data with deck locally")
 
-	data, err := io.ReadAll(ior)
+	plaintext, err := envelopeDecrypt(alg, dek, ciphertext)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read secret into string: %w", err)
+		return nil, fmt.Errorf("failed to decrypt envelope: %w", err)
 	}
-	if err := ior.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close reader: %w", err)
+
+	// The write counter is only trusted once the DEK has been decrypted with
+	// it, and only the live generation can have been rolled back.
+	if generation < 0 {
+		if err := c.storageVerifyWriteCounter(ctx, bucket, object, attrs); err != nil {
+			c.wipe(plaintext)
+			return nil, err
+		}
 	}
+	return secretFromAttrs(bucket, attrs, plaintext), nil
+}
 
-	// Split into parts
-	logger.DebugContext(ctx, "deconstructing and decoding ciphertext into parts")
+// storageReadBlob reads the attributes and raw stored envelope for the given
+// object generation. It does not call KMS.
+func (c *Client) storageReadBlob(ctx context.Context, bucket, object string, generation int64) (*storage.ObjectAttrs, []byte, error) {
+	logger := logging.FromContext(ctx).With(
+		"bucket", bucket,
+		"object", object,
+		"generation", generation,
+	)
 
-	parts := strings.SplitN(string(data), ":", 2)
-	if len(parts) < 2 {
-		return nil, fmt.Errorf("invalid ciphertext: not enough parts")
-	}
+	// Get attributes to find the KMS key
+	logger.DebugContext(ctx, "reading attributes from storage")
 
-	encDEK, err := base64.StdEncoding.DecodeString(parts[0])
+	attrs, err := c.storageBucket(ctx, bucket).
+		Object(object).
+		Generation(generation).
+		Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil, storageError(ErrSecretDoesNotExist, bucket, object)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("invalid ciphertext: failed to parse dek")
+		return nil, nil, fmt.Errorf("failed to read secret metadata: %w", err)
+	}
+	if attrs.Metadata == nil || attrs.Metadata[MetadataKMSKey] == "" {
+		return nil, nil, fmt.Errorf("missing kms key in secret metadata")
 	}
 
-	ciphertext, err := base64.StdEncoding.DecodeString(parts[1])
+	// Download the file from GCS
+	logger.DebugContext(ctx, "downloading file from storage")
+
+	handle, err := c.storageObjectKey(c.storageBucket(ctx, bucket).
+		Object(object).
+		Generation(generation), attrs)
 	if err != nil {
-		return nil, fmt.Errorf("invalid ciphertext: failed to parse ciphertext")
+		return nil, nil, storageError(err, bucket, object)
 	}
 
-	// Decrypt the DEK using a KMS key
-	logger.DebugContext(ctx, "decrypting dek using kms")
+	// Pin the generation, so a resumed download reads the same object even if
+	// the secret is updated meanwhile.
+	handle = handle.Generation(attrs.Generation)
 
-	kmsResp, err := c.kmsClient.Decrypt(ctx, &kmspb.DecryptRequest{
-		Name:                        key,
-		Ciphertext:                  encDEK,
-		AdditionalAuthenticatedData: []byte(object),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt dek: %w", err)
+	// The checksum of an object with a customer-supplied key is not known to
+	// match the downloaded bytes, so it is not checked.
+	crc := attrs.CRC32C
+	if attrs.CustomerKeySHA256 != "" {
+		crc = 0
 	}
-	dek := kmsResp.Plaintext
 
-	// Decrypt with the local key
-	logger.DebugContext(ctx, "decrypting data with deck locally")
+	// Read the entire response into memory
+	logger.DebugContext(ctx, "reading object into memory")
 
-	plaintext, err := envelopeDecrypt(dek, ciphertext)
+	data, err := storageDownload(ctx, c.storageReadBackoff(), attrs.Size, crc,
+		func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+			r, err := handle.NewRangeReader(ctx, offset, -1)
+			if err == storage.ErrObjectNotExist {
+				return nil, fmt.Errorf("secret object not found")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read secret: %w", err)
+			}
+			return r, nil
+		})
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt envelope: %w", err)
+		return nil, nil, err
 	}
-	return secretFromAttrs(bucket, attrs, plaintext), nil
+	return attrs, data, nil
 }
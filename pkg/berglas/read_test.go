@@ -33,7 +33,7 @@ func TestClient_Read_secretManager(t *testing.T) {
 			Name:    name,
 		})
 		if !IsSecretDoesNotExistErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretDoesNotExist)
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
 		}
 	})
 
@@ -120,7 +120,7 @@ func TestClient_Read_storage(t *testing.T) {
 			Object: object,
 		})
 		if !IsSecretDoesNotExistErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretDoesNotExist)
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
 		}
 	})
 
@@ -0,0 +1,43 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logredact"
+	"google.golang.org/api/option"
+)
+
+// WithLogRedaction returns a client option that registers every secret value
+// returned by Resolve, and so by Replace and ResolveEnv, with r. Logs written
+// through logredact.NewWriter or logredact.NewHandler with the same redactor
+// then mask those values. Use logredact.Default() to share one redactor across
+// the process.
+//
+// Registered values stay in memory for the life of the redactor, even with
+// WithZeroize.
+func WithLogRedaction(r *logredact.Redactor) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.redactor = r
+	}}
+}
+
+// registerRedaction registers the plaintext with the client's redactor, if
+// any.
+func (c *Client) registerRedaction(plaintext []byte) {
+	if c.redactor == nil {
+		return
+	}
+	c.redactor.Register(string(plaintext))
+}
@@ -29,6 +29,24 @@ const (
 
 	// ReferencePrefixSecretManager is the prefix for secret manager references
 	ReferencePrefixSecretManager = "sm://"
+
+	// ReferencePrefixGCS and ReferencePrefixStorageURL are alternate prefixes
+	// for Cloud Storage references, accepted by ParseReference so existing
+	// gs:// and https:// object URLs can be used as-is. Unlike the berglas://
+	// prefix, IsReference does not match them, since such URLs commonly point
+	// to objects that are not secrets.
+	ReferencePrefixGCS        = "gs://"
+	ReferencePrefixStorageURL = "https://storage.googleapis.com/"
+
+	// ReferencePrefixS3 is the prefix for secrets in an S3-compatible store,
+	// which must be configured on the client with WithS3Store. Like gs://
+	// references, IsReference does not match it.
+	ReferencePrefixS3 = "s3://"
+
+	// ReferenceFallbackSeparator separates the references in a fallback chain
+	// such as "sm://my-project/foo|berglas://my-bucket/foo". Resolve tries each
+	// reference in order and returns the first that succeeds.
+	ReferenceFallbackSeparator = "|"
 )
 
 // ReferenceType is the type of Berglas reference. It is used to distinguish
@@ -39,6 +57,7 @@ const (
 	_ ReferenceType = iota
 	ReferenceTypeSecretManager
 	ReferenceTypeStorage
+	ReferenceTypeS3
 )
 
 // Reference is a parsed berglas reference.
@@ -47,6 +66,7 @@ type Reference struct {
 	bucket     string
 	object     string
 	generation int64
+	directory  bool
 
 	// Secret Manager properties
 	project string
@@ -54,18 +74,25 @@ type Reference struct {
 	version string
 
 	// Common properties
-	typ      ReferenceType
-	filepath string
+	typ          ReferenceType
+	filepath     string
+	destination  string
+	quotaProject string
+
+	// Transforms applied by Resolve
+	encoding string
+	jsonKey  string
+	trim     bool
 }
 
 // Bucket is the storage bucket where the secret lives. This is only set on
-// Cloud Storage secrets.
+// Cloud Storage and S3 secrets.
 func (r *Reference) Bucket() string {
 	return r.bucket
 }
 
 // Object is the name of the secret in the storage bucket. This is only set on
-// Cloud Storage secrets.
+// Cloud Storage and S3 secrets.
 func (r *Reference) Object() string {
 	return r.object
 }
@@ -76,6 +103,14 @@ func (r *Reference) Generation() int64 {
 	return r.generation
 }
 
+// IsDirectory indicates that the reference ends in "/*" and refers to every
+// secret under the prefix returned by Object, rather than a single secret. When
+// resolved, each secret is written to a file in the directory returned by
+// Filepath. This is only set on Cloud Storage secrets.
+func (r *Reference) IsDirectory() bool {
+	return r.directory
+}
+
 // Project is the GCP project where the secret lives. This is only set on Secret
 // Manager secrets.
 func (r *Reference) Project() string {
@@ -97,6 +132,38 @@ func (r *Reference) Filepath() string {
 	return r.filepath
 }
 
+// Destination is the "destination" query parameter as given, such as a path or
+// "tempfile", if any. Unlike Filepath, it is not replaced by the name of a
+// created temporary file.
+func (r *Reference) Destination() string {
+	return r.destination
+}
+
+// QuotaProject is the project charged for quota and billing when the reference
+// is resolved, given as the "quotaProject" or "billingProject" query parameter,
+// if any.
+func (r *Reference) QuotaProject() string {
+	return r.quotaProject
+}
+
+// Encoding is the encoding the secret is decoded from when resolved, such as
+// "base64", if any.
+func (r *Reference) Encoding() string {
+	return r.encoding
+}
+
+// JSONKey is the top-level key extracted from the secret when resolved, if the
+// secret is a JSON object.
+func (r *Reference) JSONKey() string {
+	return r.jsonKey
+}
+
+// Trim indicates that leading and trailing whitespace is removed from the
+// secret when resolved.
+func (r *Reference) Trim() bool {
+	return r.trim
+}
+
 // Type is the type of reference, used for switching.
 func (r *Reference) Type() ReferenceType {
 	return r.typ
@@ -112,11 +179,16 @@ func (r *Reference) String() string {
 			return fmt.Sprintf("sm://%s/%s#%s", r.project, r.name, r.version)
 		}
 	case ReferenceTypeStorage:
+		if r.directory {
+			return fmt.Sprintf("berglas://%s/%s*", r.bucket, r.object)
+		}
 		if r.generation == 0 {
 			return fmt.Sprintf("berglas://%s/%s", r.bucket, r.object)
 		} else {
 			return fmt.Sprintf("berglas://%s/%s#%d", r.bucket, r.object, r.generation)
 		}
+	case ReferenceTypeS3:
+		return fmt.Sprintf("s3://%s/%s", r.bucket, r.object)
 	default:
 		return fmt.Sprintf("unknown type %T", r.typ)
 	}
@@ -140,23 +212,123 @@ func IsSecretManagerReference(s string) bool {
 	return strings.HasPrefix(s, ReferencePrefixSecretManager)
 }
 
+// referenceSchemeTypos maps lowercase schemes, including common misspellings,
+// to the reference scheme they were likely meant to be.
+var referenceSchemeTypos = map[string]string{
+	"sm":       "sm",
+	"berglas":  "berglas",
+	"berglass": "berglas",
+	"berlgas":  "berglas",
+}
+
+// SuggestReference reports whether the given string is not a reference, but
+// looks like a mistyped one, such as "sm:/my-project/foo" or " berglas://a/b".
+// If so, it returns the reference that was likely intended. Values that
+// IsReference matches are never suggested.
+func SuggestReference(s string) (string, bool) {
+	if IsReference(s) {
+		return "", false
+	}
+
+	// Surrounding whitespace is easy to pick up from YAML or shell quoting.
+	trimmed := strings.TrimSpace(s)
+	if IsReference(trimmed) {
+		return trimmed, true
+	}
+
+	i := strings.IndexFunc(trimmed, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+	})
+	if i <= 0 {
+		return "", false
+	}
+	scheme, ok := referenceSchemeTypos[strings.ToLower(trimmed[:i])]
+	if !ok {
+		return "", false
+	}
+
+	// The separator is whatever mix of colons and slashes follows the scheme,
+	// such as ":/", ":", or "//".
+	rest := strings.TrimLeft(trimmed[i:], ":/")
+	if len(rest) == len(trimmed[i:]) || !strings.Contains(rest, "/") {
+		return "", false
+	}
+	return scheme + "://" + rest, true
+}
+
 // ParseReference parses a secret ref of the format `berglas://bucket/secret` or
 // `sm://project/secret` and returns a structure representing that information.
+// Cloud Storage references may also be given as `gs://bucket/secret` or
+// `https://storage.googleapis.com/bucket/secret`, where the generation may be
+// given as a "generation" query parameter as well as a fragment. Secrets in an
+// S3-compatible store are given as `s3://bucket/secret`.
+// Fallback chains must be parsed with ParseReferences instead.
 func ParseReference(s string) (*Reference, error) {
+	return parseReference(s, "", "")
+}
+
+// parseReference parses a single reference. The default project and bucket, if
+// not empty, are used for Secret Manager and Cloud Storage references that omit
+// them.
+func parseReference(s, defaultProject, defaultBucket string) (*Reference, error) {
+	if strings.Contains(s, ReferenceFallbackSeparator) {
+		return nil, fmt.Errorf("reference is a fallback chain, use ParseReferences")
+	}
+
 	// Make sure it's a reference and strip out the prefix
 	switch {
 	case IsSecretManagerReference(s):
 		s = strings.TrimPrefix(s, ReferencePrefixSecretManager)
-		return secretManagerParseReference(s)
+		return secretManagerParseReference(s, defaultProject)
 	case IsStorageReference(s):
 		s = strings.TrimPrefix(s, ReferencePrefixStorage)
-		return storageParseReference(s)
+		return storageParseReference(s, defaultBucket)
+	case strings.HasPrefix(s, ReferencePrefixGCS):
+		s = strings.TrimPrefix(s, ReferencePrefixGCS)
+		return storageParseReference(s, defaultBucket)
+	case strings.HasPrefix(s, ReferencePrefixStorageURL):
+		s = strings.TrimPrefix(s, ReferencePrefixStorageURL)
+		return storageParseReference(s, defaultBucket)
+	case IsS3Reference(s):
+		s = strings.TrimPrefix(s, ReferencePrefixS3)
+		return s3ParseReference(s)
 	default:
 		return nil, fmt.Errorf("not a storage or secret manager reference")
 	}
 }
 
-func secretManagerParseReference(s string) (*Reference, error) {
+// IsS3Reference returns true if the given string looks like a reference to a
+// secret in an S3-compatible store.
+func IsS3Reference(s string) bool {
+	return strings.HasPrefix(s, ReferencePrefixS3)
+}
+
+// ParseReferences parses a secret ref that may be a fallback chain of the
+// format `sm://project/secret|berglas://bucket/secret`, returning each
+// reference in order. A single reference returns a list of one.
+func ParseReferences(s string) ([]*Reference, error) {
+	return parseReferences(s, "", "")
+}
+
+// parseReferences parses a fallback chain, using the default project and
+// bucket as with parseReference.
+func parseReferences(s, defaultProject, defaultBucket string) ([]*Reference, error) {
+	parts := strings.Split(s, ReferenceFallbackSeparator)
+	refs := make([]*Reference, 0, len(parts))
+	for _, part := range parts {
+		ref, err := parseReference(strings.TrimSpace(part), defaultProject, defaultBucket)
+		if err != nil {
+			if len(parts) == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("failed to parse reference %q: %w", part, err)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+func secretManagerParseReference(s, defaultProject string) (*Reference, error) {
 	// Parse the remainder as a URL to extract any query params
 	u, err := url.Parse(s)
 	if err != nil {
@@ -165,6 +337,9 @@ func secretManagerParseReference(s string) (*Reference, error) {
 
 	// Separate project from secret
 	ss := strings.SplitN(u.Path, "/", 2)
+	if len(ss) == 1 && ss[0] != "" && defaultProject != "" {
+		ss = []string{defaultProject, ss[0]}
+	}
 	if len(ss) < 2 {
 		return nil, fmt.Errorf("invalid secret format %q", s)
 	}
@@ -184,8 +359,19 @@ func secretManagerParseReference(s string) (*Reference, error) {
 		return nil, fmt.Errorf("invalid secret name %q", r.name)
 	}
 
+	// Parse transforms
+	if err := refParseTransforms(&r, u.Query()); err != nil {
+		return nil, err
+	}
+
+	// Parse quota project
+	if err := refParseQuotaProject(&r, u.Query()); err != nil {
+		return nil, err
+	}
+
 	// Parse destination
-	path, err := refExtractFilepath(r.name, u.Query().Get("destination"))
+	r.destination = u.Query().Get("destination")
+	path, err := refExtractFilepath(r.name, r.destination)
 	if err != nil {
 		return nil, err
 	}
@@ -194,7 +380,7 @@ func secretManagerParseReference(s string) (*Reference, error) {
 	return &r, nil
 }
 
-func storageParseReference(s string) (*Reference, error) {
+func storageParseReference(s, defaultBucket string) (*Reference, error) {
 	// Remove any leading slashes (it messes up bucket names)
 	s = strings.TrimPrefix(s, "/")
 
@@ -206,6 +392,9 @@ func storageParseReference(s string) (*Reference, error) {
 
 	// Separate bucket from path
 	ss := strings.SplitN(u.Path, "/", 2)
+	if len(ss) == 1 && ss[0] != "" && defaultBucket != "" {
+		ss = []string{defaultBucket, ss[0]}
+	}
 	if len(ss) < 2 {
 		return nil, fmt.Errorf("invalid secret format %q", s)
 	}
@@ -220,10 +409,44 @@ func storageParseReference(s string) (*Reference, error) {
 		if generation, err := strconv.ParseInt(u.Fragment, 0, 64); err == nil {
 			r.generation = generation
 		}
+	} else if v := u.Query().Get("generation"); v != "" {
+		// Cloud Storage URLs give the generation as a query parameter.
+		if generation, err := strconv.ParseInt(v, 10, 64); err == nil {
+			r.generation = generation
+		}
+	}
+
+	// A trailing "*" refers to every secret under the prefix
+	if r.object == "*" || strings.HasSuffix(r.object, "/*") {
+		if r.generation != 0 {
+			return nil, fmt.Errorf("directory reference %q cannot have a generation", s)
+		}
+		r.directory = true
+		r.object = strings.TrimSuffix(r.object, "*")
+	}
+
+	// Parse transforms
+	if err := refParseTransforms(&r, u.Query()); err != nil {
+		return nil, err
+	}
+
+	// Parse quota project
+	if err := refParseQuotaProject(&r, u.Query()); err != nil {
+		return nil, err
 	}
 
 	// Parse destination
-	path, err := refExtractFilepath(r.object, u.Query().Get("destination"))
+	r.destination = u.Query().Get("destination")
+	if r.directory {
+		path, err := refExtractDirpath(r.destination)
+		if err != nil {
+			return nil, err
+		}
+		r.filepath = path
+		return &r, nil
+	}
+
+	path, err := refExtractFilepath(r.object, r.destination)
 	if err != nil {
 		return nil, err
 	}
@@ -232,6 +455,63 @@ func storageParseReference(s string) (*Reference, error) {
 	return &r, nil
 }
 
+func s3ParseReference(s string) (*Reference, error) {
+	r, err := storageParseReference(s, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// S3 has no generations and listing is not supported
+	if r.generation != 0 {
+		return nil, fmt.Errorf("s3 reference %q cannot have a generation", s)
+	}
+	if r.directory {
+		return nil, fmt.Errorf("s3 reference %q cannot be a directory", s)
+	}
+	if r.quotaProject != "" {
+		return nil, fmt.Errorf("s3 reference %q cannot have a quota project", s)
+	}
+
+	r.typ = ReferenceTypeS3
+	return r, nil
+}
+
+// refParseTransforms sets the transforms given as query params on the
+// reference.
+func refParseTransforms(r *Reference, q url.Values) error {
+	switch encoding := q.Get("encoding"); encoding {
+	case "", "base64", "base64url", "hex":
+		r.encoding = encoding
+	default:
+		return fmt.Errorf("invalid encoding %q: must be one of base64, base64url, hex", encoding)
+	}
+
+	r.jsonKey = q.Get("jsonkey")
+
+	if v := q.Get("trim"); v != "" {
+		trim, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid trim %q: %w", v, err)
+		}
+		r.trim = trim
+	}
+	return nil
+}
+
+// refParseQuotaProject sets the quota project given as a query param on the
+// reference.
+func refParseQuotaProject(r *Reference, q url.Values) error {
+	quota, billing := q.Get("quotaProject"), q.Get("billingProject")
+	if quota != "" && billing != "" && quota != billing {
+		return fmt.Errorf("conflicting quotaProject %q and billingProject %q", quota, billing)
+	}
+	r.quotaProject = quota
+	if r.quotaProject == "" {
+		r.quotaProject = billing
+	}
+	return nil
+}
+
 func refExtractFilepath(object, s string) (string, error) {
 	switch s {
 	case "tmpfile", "tempfile":
@@ -251,3 +531,19 @@ func refExtractFilepath(object, s string) (string, error) {
 		return s, nil
 	}
 }
+
+func refExtractDirpath(s string) (string, error) {
+	switch s {
+	case "":
+		return "", fmt.Errorf("directory references require a destination")
+	case "tmpfile", "tempfile":
+		// create a tempdir for the secrets
+		dir, err := os.MkdirTemp("", "berglas-*")
+		if err != nil {
+			return "", fmt.Errorf("failed to create tempdir for secrets: %w", err)
+		}
+		return dir, nil
+	default:
+		return s, nil
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+)
+
+// defaultProjectKey and defaultBucketKey are the context keys for the default
+// project and bucket.
+type (
+	defaultProjectKey struct{}
+	defaultBucketKey  struct{}
+)
+
+// WithDefaultProject returns a context in which Secret Manager references that
+// omit the project, such as "sm://my-secret", refer to a secret in the given
+// project. This lets services that resolve secrets for many tenants set the
+// tenant's project once per request. It is consulted by ParseReferenceContext,
+// ParseReferencesContext, and the Resolve and Watch functions.
+func WithDefaultProject(ctx context.Context, project string) context.Context {
+	return context.WithValue(ctx, defaultProjectKey{}, project)
+}
+
+// WithDefaultBucket returns a context in which Cloud Storage references that
+// omit the bucket, such as "berglas://my-secret", refer to a secret in the
+// given bucket. Since object names may contain slashes, only references without
+// any slash use the default. It is consulted by the same functions as
+// WithDefaultProject.
+func WithDefaultBucket(ctx context.Context, bucket string) context.Context {
+	return context.WithValue(ctx, defaultBucketKey{}, bucket)
+}
+
+// ParseReferenceContext is like ParseReference, but references that omit the
+// project or bucket use the defaults set on the context with
+// WithDefaultProject and WithDefaultBucket.
+func ParseReferenceContext(ctx context.Context, s string) (*Reference, error) {
+	project, bucket := contextDefaults(ctx)
+	return parseReference(s, project, bucket)
+}
+
+// ParseReferencesContext is like ParseReferences, but references that omit the
+// project or bucket use the defaults set on the context with
+// WithDefaultProject and WithDefaultBucket.
+func ParseReferencesContext(ctx context.Context, s string) ([]*Reference, error) {
+	project, bucket := contextDefaults(ctx)
+	return parseReferences(s, project, bucket)
+}
+
+// contextDefaults returns the default project and bucket set on the context.
+func contextDefaults(ctx context.Context) (string, string) {
+	project, _ := ctx.Value(defaultProjectKey{}).(string)
+	bucket, _ := ctx.Value(defaultBucketKey{}).(string)
+	return project, bucket
+}
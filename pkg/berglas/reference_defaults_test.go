@@ -0,0 +1,88 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseReferenceContext(t *testing.T) {
+	t.Parallel()
+
+	ctx := WithDefaultBucket(WithDefaultProject(context.Background(), "tenant-p"), "tenant-b")
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		s    string
+		exp  string
+		err  bool
+	}{
+		{"sm_default", ctx, "sm://secret#3", "sm://tenant-p/secret#3", false},
+		{"sm_explicit", ctx, "sm://p/secret", "sm://p/secret", false},
+		{"sm_no_default", context.Background(), "sm://secret", "", true},
+		{"sm_empty", ctx, "sm://", "", true},
+		{"storage_default", ctx, "berglas://secret", "berglas://tenant-b/secret", false},
+		{"gs_default", ctx, "gs://secret", "berglas://tenant-b/secret", false},
+		{"storage_explicit", ctx, "berglas://b/dir/secret", "berglas://b/dir/secret", false},
+		{"storage_no_default", context.Background(), "berglas://secret", "", true},
+		{"s3_ignores_default", ctx, "s3://secret", "", true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ref, err := ParseReferenceContext(tc.ctx, tc.s)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t, got %v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if act := ref.String(); act != tc.exp {
+				t.Errorf("expected %q to be %q", act, tc.exp)
+			}
+		})
+	}
+}
+
+func TestParseReferencesContext(t *testing.T) {
+	t.Parallel()
+
+	ctx := WithDefaultProject(context.Background(), "tenant-p")
+
+	refs, err := ParseReferencesContext(ctx, "sm://primary|sm://p/fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var act []string
+	for _, ref := range refs {
+		act = append(act, ref.String())
+	}
+	if exp := []string{"sm://tenant-p/primary", "sm://p/fallback"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	// The defaults do not change ParseReference.
+	if _, err := ParseReference("sm://primary"); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -80,10 +80,11 @@ func TestParseReference(t *testing.T) {
 			"destination_path",
 			"sm://foo/bar?destination=/var/foo",
 			&Reference{
-				project:  "foo",
-				name:     "bar",
-				filepath: "/var/foo",
-				typ:      ReferenceTypeSecretManager,
+				project:     "foo",
+				name:        "bar",
+				filepath:    "/var/foo",
+				destination: "/var/foo",
+				typ:         ReferenceTypeSecretManager,
 			},
 			false,
 		},
@@ -91,11 +92,12 @@ func TestParseReference(t *testing.T) {
 			"destination_path",
 			"sm://foo/bar?destination=/var/foo#12",
 			&Reference{
-				project:  "foo",
-				name:     "bar",
-				version:  "12",
-				filepath: "/var/foo",
-				typ:      ReferenceTypeSecretManager,
+				project:     "foo",
+				name:        "bar",
+				version:     "12",
+				filepath:    "/var/foo",
+				destination: "/var/foo",
+				typ:         ReferenceTypeSecretManager,
 			},
 			false,
 		},
@@ -131,25 +133,180 @@ func TestParseReference(t *testing.T) {
 			"destination_path",
 			"berglas://foo/bar?destination=/var/foo",
 			&Reference{
-				bucket:   "foo",
-				object:   "bar",
-				filepath: "/var/foo",
-				typ:      ReferenceTypeStorage,
+				bucket:      "foo",
+				object:      "bar",
+				filepath:    "/var/foo",
+				destination: "/var/foo",
+				typ:         ReferenceTypeStorage,
 			},
 			false,
 		},
 		{
 			"destination_path",
 			"berglas://foo/bar?destination=/var/foo#1563925173373377",
+			&Reference{
+				bucket:      "foo",
+				object:      "bar",
+				generation:  1563925173373377,
+				filepath:    "/var/foo",
+				destination: "/var/foo",
+				typ:         ReferenceTypeStorage,
+			},
+			false,
+		},
+		{
+			"gs-prefix",
+			"gs://foo/bar/baz#12",
+			&Reference{
+				bucket:     "foo",
+				object:     "bar/baz",
+				generation: 12,
+				typ:        ReferenceTypeStorage,
+			},
+			false,
+		},
+		{
+			"gs-no-secret",
+			"gs://foo",
+			nil,
+			true,
+		},
+		{
+			"quota-project",
+			"sm://foo/bar?quotaProject=baz",
+			&Reference{
+				project:      "foo",
+				name:         "bar",
+				typ:          ReferenceTypeSecretManager,
+				quotaProject: "baz",
+			},
+			false,
+		},
+		{
+			"billing-project",
+			"berglas://foo/bar?billingProject=baz",
+			&Reference{
+				bucket:       "foo",
+				object:       "bar",
+				typ:          ReferenceTypeStorage,
+				quotaProject: "baz",
+			},
+			false,
+		},
+		{
+			"conflicting-quota-project",
+			"sm://foo/bar?quotaProject=baz&billingProject=qux",
+			nil,
+			true,
+		},
+		{
+			"s3-prefix",
+			"s3://foo/bar/baz?destination=/var/foo",
+			&Reference{
+				bucket:      "foo",
+				object:      "bar/baz",
+				typ:         ReferenceTypeS3,
+				filepath:    "/var/foo",
+				destination: "/var/foo",
+			},
+			false,
+		},
+		{
+			"s3-generation",
+			"s3://foo/bar#12",
+			nil,
+			true,
+		},
+		{
+			"s3-directory",
+			"s3://foo/bar/*?destination=/var/foo",
+			nil,
+			true,
+		},
+		{
+			"https-prefix",
+			"https://storage.googleapis.com/foo/bar%20baz?generation=12",
 			&Reference{
 				bucket:     "foo",
-				object:     "bar",
-				generation: 1563925173373377,
-				filepath:   "/var/foo",
+				object:     "bar baz",
+				generation: 12,
 				typ:        ReferenceTypeStorage,
 			},
 			false,
 		},
+		{
+			"https-other-host",
+			"https://example.com/foo/bar",
+			nil,
+			true,
+		},
+
+		// Directories
+		{
+			"directory",
+			"berglas://foo/bar/*?destination=/etc/app/",
+			&Reference{
+				bucket:      "foo",
+				object:      "bar/",
+				directory:   true,
+				filepath:    "/etc/app/",
+				destination: "/etc/app/",
+				typ:         ReferenceTypeStorage,
+			},
+			false,
+		},
+		{
+			"directory_bucket_root",
+			"berglas://foo/*?destination=/etc/app",
+			&Reference{
+				bucket:      "foo",
+				object:      "",
+				directory:   true,
+				filepath:    "/etc/app",
+				destination: "/etc/app",
+				typ:         ReferenceTypeStorage,
+			},
+			false,
+		},
+		{
+			"directory_missing_destination",
+			"berglas://foo/bar/*",
+			nil,
+			true,
+		},
+		{
+			"directory_generation",
+			"berglas://foo/bar/*?destination=/etc/app#12",
+			nil,
+			true,
+		},
+
+		// Transforms
+		{
+			"transforms",
+			"sm://foo/bar?encoding=base64&jsonkey=password&trim=true",
+			&Reference{
+				project:  "foo",
+				name:     "bar",
+				typ:      ReferenceTypeSecretManager,
+				encoding: "base64",
+				jsonKey:  "password",
+				trim:     true,
+			},
+			false,
+		},
+		{
+			"invalid_encoding",
+			"berglas://foo/bar?encoding=rot13",
+			nil,
+			true,
+		},
+		{
+			"invalid_trim",
+			"berglas://foo/bar?trim=maybe",
+			nil,
+			true,
+		},
 	}
 
 	for _, tc := range cases {
@@ -170,6 +327,118 @@ func TestParseReference(t *testing.T) {
 	}
 }
 
+func TestParseReferences(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		s    string
+		exp  []string
+		err  bool
+	}{
+		{
+			"single",
+			"sm://foo/bar",
+			[]string{"sm://foo/bar"},
+			false,
+		},
+		{
+			"chain",
+			"sm://foo/bar|berglas://baz/qux#12",
+			[]string{"sm://foo/bar", "berglas://baz/qux#12"},
+			false,
+		},
+		{
+			"chain_spaces",
+			"sm://foo/bar | berglas://baz/qux",
+			[]string{"sm://foo/bar", "berglas://baz/qux"},
+			false,
+		},
+		{
+			"chain_invalid",
+			"sm://foo/bar|baz/qux",
+			nil,
+			true,
+		},
+		{
+			"chain_empty",
+			"sm://foo/bar|",
+			nil,
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			refs, err := ParseReferences(tc.s)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+
+			var act []string
+			for _, ref := range refs {
+				act = append(act, ref.String())
+			}
+
+			if !reflect.DeepEqual(act, tc.exp) {
+				t.Errorf("expected %q to be %q", act, tc.exp)
+			}
+		})
+	}
+
+	t.Run("parse_reference_rejects_chain", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := ParseReference("sm://foo/bar|sm://foo/baz"); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestSuggestReference(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		s    string
+		exp  string
+		ok   bool
+	}{
+		{"valid", "sm://foo/bar", "", false},
+		{"literal", "my-password", "", false},
+		{"url", "https://example.com/foo", "", false},
+		{"no_path", "sm:foo", "", false},
+		{"sm_single_slash", "sm:/foo/bar", "sm://foo/bar", true},
+		{"berglas_single_slash", "berglas:/foo/bar#12", "berglas://foo/bar#12", true},
+		{"missing_slashes", "sm:foo/bar", "sm://foo/bar", true},
+		{"missing_colon", "berglas//foo/bar", "berglas://foo/bar", true},
+		{"extra_colon", "sm:://foo/bar", "sm://foo/bar", true},
+		{"uppercase", "SM://foo/bar", "sm://foo/bar", true},
+		{"misspelled", "berglass://foo/bar", "berglas://foo/bar", true},
+		{"whitespace", " sm://foo/bar\n", "sm://foo/bar", true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			act, ok := SuggestReference(tc.s)
+			if ok != tc.ok {
+				t.Fatalf("expected %t to be %t", ok, tc.ok)
+			}
+			if act != tc.exp {
+				t.Errorf("expected %q to be %q", act, tc.exp)
+			}
+		})
+	}
+}
+
 func TestReference_String(t *testing.T) {
 	t.Parallel()
 
@@ -198,6 +467,11 @@ func TestReference_String(t *testing.T) {
 			&Reference{bucket: "bucket", object: "secret", generation: 1234567890, typ: ReferenceTypeStorage},
 			"berglas://bucket/secret#1234567890",
 		},
+		{
+			"berglas_directory",
+			&Reference{bucket: "bucket", object: "certs/", directory: true, typ: ReferenceTypeStorage},
+			"berglas://bucket/certs/*",
+		},
 	}
 
 	for _, tc := range cases {
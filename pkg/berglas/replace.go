@@ -11,7 +11,7 @@ import (
 // Replace parses a berglas reference and replaces it. See Client.Replace for
 // more details and examples.
 func Replace(ctx context.Context, key string) error {
-	client, err := New(ctx)
+	client, err := DefaultClient(ctx)
 	if err != nil {
 		return err
 	}
@@ -23,6 +23,38 @@ func Replace(ctx context.Context, key string) error {
 // this function replaces the value of the environment variable to the resolved
 // secret reference.
 func (c *Client) Replace(ctx context.Context, key string) error {
+	return c.replace(ctx, key, c.Resolve)
+}
+
+// ReplaceDeep resolves references nested in the JSON or YAML value of the
+// environment variable. See Client.ReplaceDeep for more details.
+func ReplaceDeep(ctx context.Context, key string) error {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return err
+	}
+	return client.ReplaceDeep(ctx, key)
+}
+
+// ReplaceDeep is like Replace, but the value of the environment variable may be
+// a JSON or YAML document with nested references, each of which is replaced
+// with its resolved secret. See ResolveDeep for how documents are handled.
+func (c *Client) ReplaceDeep(ctx context.Context, key string) error {
+	return c.replace(ctx, key, c.ResolveDeep)
+}
+
+// warnSuggestedReference logs a warning if the value of the environment
+// variable with the given key looks like a mistyped reference, since it would
+// otherwise be passed through as a literal value.
+func warnSuggestedReference(ctx context.Context, key, value string) {
+	if suggestion, ok := SuggestReference(value); ok {
+		logging.FromContext(ctx).WarnContext(ctx, "value looks like a mistyped reference",
+			"key", key,
+			"suggestion", suggestion)
+	}
+}
+
+func (c *Client) replace(ctx context.Context, key string, resolve resolveFunc) error {
 	value := os.Getenv(key)
 
 	logger := logging.FromContext(ctx).With(
@@ -33,7 +65,9 @@ func (c *Client) Replace(ctx context.Context, key string) error {
 	logger.DebugContext(ctx, "replacevalue.start")
 	defer logger.DebugContext(ctx, "replacevalue.finish")
 
-	plaintext, err := c.Resolve(ctx, value)
+	warnSuggestedReference(ctx, key, value)
+
+	plaintext, err := resolve(ctx, value)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,150 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/option"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// SlowAccessThreshold is how long accessing a Secret Manager secret may take
+// before the secret's replica locations are checked and a warning is logged.
+const SlowAccessThreshold = 2 * time.Second
+
+// replicationLookupTimeout bounds the extra call made to find the replica
+// locations of a secret after a slow or failed access.
+const replicationLookupTimeout = 5 * time.Second
+
+// ReplicationError is the error returned when accessing a Secret Manager secret
+// with user-managed replication fails in a way that may be caused by none of
+// its replicas being reachable from the caller.
+type ReplicationError struct {
+	// Err is the underlying error.
+	Err error
+
+	// Resource is the name of the secret, "projects/P/secrets/S".
+	Resource string
+
+	// Locations are the sorted replica locations of the secret.
+	Locations []string
+
+	// PreferredRegion is the region given with WithPreferredRegion, if any.
+	PreferredRegion string
+}
+
+// Error implements the error interface.
+func (e *ReplicationError) Error() string {
+	hint := "consider adding a replica near the caller"
+	if e.PreferredRegion != "" && !slices.Contains(e.Locations, e.PreferredRegion) {
+		hint = fmt.Sprintf("consider adding a replica in %s", e.PreferredRegion)
+	}
+	return fmt.Sprintf("%s (%s is only replicated to %s, %s)",
+		e.Err, e.Resource, strings.Join(e.Locations, ", "), hint)
+}
+
+// Unwrap implements errors.Unwrap.
+func (e *ReplicationError) Unwrap() error {
+	return e.Err
+}
+
+// WithPreferredRegion returns a client option that names the region the caller
+// runs in, such as "us-east1". Secret Manager serves secrets from the nearest
+// replica on its own and does not allow choosing one, so the region is used to
+// point out secrets with no replica in it when an access is slow or fails.
+func WithPreferredRegion(region string) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.preferredRegion = region
+	}}
+}
+
+// isReplicationErr reports whether the Secret Manager error may be caused by no
+// replica of the secret being reachable.
+func isReplicationErr(err error) bool {
+	switch grpcstatus.Code(err) {
+	case grpccodes.Unavailable, grpccodes.DeadlineExceeded, grpccodes.FailedPrecondition:
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// secretManagerReplicas returns the sorted user-managed replica locations of
+// the secret, or nil if it uses automatic replication. It is called after the
+// access itself, so it does not inherit the cancellation of ctx.
+func (c *Client) secretManagerReplicas(ctx context.Context, project, name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), replicationLookupTimeout)
+	defer cancel()
+
+	resp, err := c.secretManagerClient.GetSecret(ctx, &secretspb.GetSecretRequest{
+		Name: fmt.Sprintf("projects/%s/secrets/%s", project, name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret: %w", err)
+	}
+	return secretManagerLocations(resp.GetReplication()), nil
+}
+
+// secretManagerReplicationError returns a ReplicationError for the failed
+// access if the secret uses user-managed replication, and nil otherwise.
+func (c *Client) secretManagerReplicationError(ctx context.Context, project, name string, err error) error {
+	locations, lerr := c.secretManagerReplicas(ctx, project, name)
+	if lerr != nil || len(locations) == 0 {
+		logging.FromContext(ctx).DebugContext(ctx, "not a replication error",
+			"locations", locations,
+			"error", lerr)
+		return nil
+	}
+
+	return &ReplicationError{
+		Err:             err,
+		Resource:        fmt.Sprintf("projects/%s/secrets/%s", project, name),
+		Locations:       locations,
+		PreferredRegion: c.preferredRegion,
+	}
+}
+
+// secretManagerWarnSlowAccess logs a warning with the replica locations of a
+// secret that was slow to access, unless it uses automatic replication or has a
+// replica in the preferred region.
+func (c *Client) secretManagerWarnSlowAccess(ctx context.Context, project, name string, latency time.Duration) {
+	logger := logging.FromContext(ctx).With(
+		"project", project,
+		"name", name,
+		"latency", latency,
+	)
+
+	locations, err := c.secretManagerReplicas(ctx, project, name)
+	if err != nil {
+		logger.DebugContext(ctx, "failed to check replicas of slow secret", "error", err)
+		return
+	}
+	if len(locations) == 0 || slices.Contains(locations, c.preferredRegion) {
+		return
+	}
+
+	logger.WarnContext(ctx, "secret manager access was slow, the secret may "+
+		"have no replica near the caller",
+		"locations", locations,
+		"preferred_region", c.preferredRegion)
+}
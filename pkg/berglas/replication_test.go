@@ -0,0 +1,114 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestReplicationError(t *testing.T) {
+	t.Parallel()
+
+	cause := grpcstatus.Error(grpccodes.Unavailable, "unavailable")
+
+	cases := []struct {
+		name   string
+		region string
+		exp    string
+	}{
+		{
+			name: "no_region",
+			exp: "rpc error: code = Unavailable desc = unavailable (projects/p/secrets/s " +
+				"is only replicated to europe-west1, europe-west4, consider adding a " +
+				"replica near the caller)",
+		},
+		{
+			name:   "missing_region",
+			region: "us-east1",
+			exp: "rpc error: code = Unavailable desc = unavailable (projects/p/secrets/s " +
+				"is only replicated to europe-west1, europe-west4, consider adding a " +
+				"replica in us-east1)",
+		},
+		{
+			name:   "present_region",
+			region: "europe-west4",
+			exp: "rpc error: code = Unavailable desc = unavailable (projects/p/secrets/s " +
+				"is only replicated to europe-west1, europe-west4, consider adding a " +
+				"replica near the caller)",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := fmt.Errorf("failed: %w", &ReplicationError{
+				Err:             cause,
+				Resource:        "projects/p/secrets/s",
+				Locations:       []string{"europe-west1", "europe-west4"},
+				PreferredRegion: tc.region,
+			})
+
+			var rerr *ReplicationError
+			if !errors.As(err, &rerr) {
+				t.Fatalf("expected %q to be a *ReplicationError", err)
+			}
+			if !errors.Is(err, cause) {
+				t.Errorf("expected %q to wrap %q", err, cause)
+			}
+			if act, exp := rerr.Error(), tc.exp; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
+
+func TestIsReplicationErr(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		err  error
+		exp  bool
+	}{
+		{"unavailable", grpcstatus.Error(grpccodes.Unavailable, ""), true},
+		{"deadline", grpcstatus.Error(grpccodes.DeadlineExceeded, ""), true},
+		{"failed_precondition", grpcstatus.Error(grpccodes.FailedPrecondition, ""), true},
+		{"context_deadline", fmt.Errorf("failed: %w", context.DeadlineExceeded), true},
+		{"permission_denied", grpcstatus.Error(grpccodes.PermissionDenied, ""), false},
+		{"not_found", grpcstatus.Error(grpccodes.NotFound, ""), false},
+		{"other", errors.New("oops"), false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if act, exp := isReplicationErr(tc.err), tc.exp; act != exp {
+				t.Errorf("expected %t to be %t", act, exp)
+			}
+		})
+	}
+}
@@ -16,9 +16,14 @@ package berglas
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 
 	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
 )
@@ -29,7 +34,7 @@ const chmodSupported = runtime.GOOS != "windows" && runtime.GOOS != "plan9"
 // Resolve parses and extracts a berglas reference. See Client.Resolve for more
 // details and examples.
 func Resolve(ctx context.Context, s string) ([]byte, error) {
-	client, err := New(ctx)
+	client, err := DefaultClient(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -38,7 +43,23 @@ func Resolve(ctx context.Context, s string) ([]byte, error) {
 
 // Resolve parses and extracts a berglas reference. The result is the plaintext
 // secrets contents, or a path to the decrypted contents on disk.
+//
+// The reference may be a fallback chain of references separated by
+// ReferenceFallbackSeparator, such as
+// "sm://my-project/foo|berglas://my-bucket/foo". Each reference is tried in
+// order and the result of the first that succeeds is returned. This is useful
+// while migrating secrets between storage backends.
+//
+// A Cloud Storage reference ending in "/*" is a directory reference, such as
+// "berglas://my-bucket/certs/*?destination=/etc/app/certs". Every secret under
+// the prefix is written to the destination and the result is the destination
+// directory. Use ResolveDirectory to get a manifest of the files written.
+//
+// If the client was created with WithAliases, s may also be the name of an
+// alias.
 func (c *Client) Resolve(ctx context.Context, s string) ([]byte, error) {
+	s = c.expandAlias(s)
+
 	logger := logging.FromContext(ctx).With(
 		"reference", s,
 	)
@@ -46,11 +67,55 @@ func (c *Client) Resolve(ctx context.Context, s string) ([]byte, error) {
 	logger.DebugContext(ctx, "resolve.start")
 	defer logger.DebugContext(ctx, "resolve.finish")
 
-	ref, err := ParseReference(s)
+	refs, err := ParseReferencesContext(ctx, s)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse reference %s: %w", s, err)
 	}
 
+	if len(refs) == 1 {
+		return c.resolveReference(ctx, refs[0])
+	}
+
+	errs := make([]error, 0, len(refs))
+	for _, ref := range refs {
+		plaintext, err := c.resolveReference(ctx, ref)
+		if err == nil {
+			return plaintext, nil
+		}
+		logger.DebugContext(ctx, "fallback reference failed",
+			"fallback", ref.String(),
+			"error", err)
+		errs = append(errs, err)
+
+		// Do not try further references once the caller has given up.
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("failed to resolve any reference in %s: %w", s, errors.Join(errs...))
+}
+
+// resolveReference accesses a single parsed reference, writing it to disk if
+// the reference has a filepath.
+func (c *Client) resolveReference(ctx context.Context, ref *Reference) ([]byte, error) {
+	logger := logging.FromContext(ctx).With(
+		"reference", ref.String(),
+	)
+
+	ctx = WithQuotaProject(ctx, ref.QuotaProject())
+
+	if err := c.checkAllowedReference(ref); err != nil {
+		return nil, err
+	}
+
+	if ref.IsDirectory() {
+		manifest, err := c.resolveDirectory(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(manifest.Directory), nil
+	}
+
 	var req accessRequest
 	switch ref.Type() {
 	case ReferenceTypeSecretManager:
@@ -65,42 +130,195 @@ func (c *Client) Resolve(ctx context.Context, s string) ([]byte, error) {
 			Object:     ref.Object(),
 			Generation: ref.Generation(),
 		}
+	case ReferenceTypeS3:
+		req = &StorageS3AccessRequest{
+			Bucket: ref.Bucket(),
+			Object: ref.Object(),
+		}
 	}
 
-	plaintext, err := c.Access(ctx, req)
+	result, err := c.accessPipeline(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to access secret %s: %w", ref.String(), err)
 	}
+	plaintext := result.plaintext
+
+	if err := c.checkPolicy(ref, result.policy); err != nil {
+		c.wipe(plaintext)
+		return nil, err
+	}
+
+	c.registerRedaction(plaintext)
+
+	plaintext, err = c.applyTransforms(ref, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to transform secret %s: %w", ref.String(), err)
+	}
+	c.registerRedaction(plaintext)
 
 	if pth := ref.Filepath(); pth != "" {
 		logger.DebugContext(ctx, "writing to filepath", "filepath", pth)
 
-		f, err := os.OpenFile(ref.Filepath(), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		name, err := writeSecretFile(pth, plaintext)
 		if err != nil {
-			return nil, fmt.Errorf("failed to open filepath %s: %w", pth, err)
+			return nil, err
+		}
+
+		// Set the plaintext to the resulting file path
+		c.wipe(plaintext)
+		plaintext = []byte(name)
+	}
+
+	return plaintext, nil
+}
+
+// writeSecretFile writes the plaintext to the given path with owner-only
+// permissions, returning the name of the file.
+func writeSecretFile(pth string, plaintext []byte) (string, error) {
+	f, err := os.OpenFile(pth, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to open filepath %s: %w", pth, err)
+	}
+
+	if chmodSupported {
+		if err := f.Chmod(0600); err != nil {
+			return "", fmt.Errorf("failed to chmod filepath %s: %w", pth, err)
 		}
+	}
+
+	if _, err := f.Write(plaintext); err != nil {
+		return "", fmt.Errorf("failed to write secret to filepath %s: %w", pth, err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return "", fmt.Errorf("failed to sync filepath %s: %w", pth, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to close filepath %s: %w", pth, err)
+	}
+	return f.Name(), nil
+}
+
+// DirectoryManifest describes the files written when resolving a directory
+// reference.
+type DirectoryManifest struct {
+	// Directory is the directory the secrets were written to.
+	Directory string
+
+	// Files are the secrets that were written, sorted by path.
+	Files []*DirectoryManifestFile
+}
+
+// DirectoryManifestFile is a single secret written when resolving a directory
+// reference.
+type DirectoryManifestFile struct {
+	// Reference is the resolved secret, including its generation.
+	Reference string
+
+	// Path is the file the secret was written to.
+	Path string
+}
+
+// ResolveDirectory parses and extracts a directory reference. See
+// Client.ResolveDirectory for more details and examples.
+func ResolveDirectory(ctx context.Context, s string) (*DirectoryManifest, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.ResolveDirectory(ctx, s)
+}
+
+// ResolveDirectory resolves a directory reference such as
+// "berglas://my-bucket/certs/*?destination=/etc/app/certs", writing every
+// secret under the prefix to a file in the destination named after the final
+// segment of the secret's name. Transforms given on the reference are applied
+// to each secret. It returns a manifest of the files written.
+//
+// Resolve also accepts directory references, returning the destination
+// directory instead of a manifest.
+func (c *Client) ResolveDirectory(ctx context.Context, s string) (*DirectoryManifest, error) {
+	ref, err := ParseReferenceContext(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reference %s: %w", s, err)
+	}
+	if !ref.IsDirectory() {
+		return nil, fmt.Errorf("reference %s is not a directory reference", s)
+	}
+	return c.resolveDirectory(ctx, ref)
+}
+
+// resolveDirectory writes every secret under a directory reference's prefix
+// into its destination.
+func (c *Client) resolveDirectory(ctx context.Context, ref *Reference) (*DirectoryManifest, error) {
+	dir := ref.Filepath()
+	ctx = WithQuotaProject(ctx, ref.QuotaProject())
 
-		if chmodSupported {
-			if err := f.Chmod(0600); err != nil {
-				return nil, fmt.Errorf("failed to chmod filepath %s: %w", pth, err)
-			}
+	logger := logging.FromContext(ctx).With(
+		"reference", ref.String(),
+		"directory", dir,
+	)
+
+	logger.DebugContext(ctx, "listing secrets under prefix")
+
+	list, err := c.List(ctx, &StorageListRequest{
+		Bucket: ref.Bucket(),
+		Prefix: ref.Object(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets %s: %w", ref.String(), err)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	manifest := &DirectoryManifest{
+		Directory: dir,
+		Files:     make([]*DirectoryManifestFile, 0, len(list.Secrets)),
+	}
+	seen := make(map[string]string, len(list.Secrets))
+
+	for _, secret := range list.Secrets {
+		// Skip folder placeholder objects
+		if strings.HasSuffix(secret.Name, "/") {
+			continue
 		}
 
-		if _, err := f.Write(plaintext); err != nil {
-			return nil, fmt.Errorf("failed to write secret to filepath %s: %w", pth, err)
+		base := path.Base(secret.Name)
+		if other, ok := seen[base]; ok {
+			return nil, fmt.Errorf("secrets %s and %s would both be written to %s",
+				other, secret.Name, base)
 		}
+		seen[base] = secret.Name
 
-		if err := f.Sync(); err != nil {
-			return nil, fmt.Errorf("failed to sync filepath %s: %w", pth, err)
+		fileRef := &Reference{
+			typ:        ReferenceTypeStorage,
+			bucket:     ref.Bucket(),
+			object:     secret.Name,
+			generation: secret.Generation,
+			encoding:   ref.Encoding(),
+			jsonKey:    ref.JSONKey(),
+			trim:       ref.Trim(),
+			filepath:   filepath.Join(dir, base),
 		}
 
-		if err := f.Close(); err != nil {
-			return nil, fmt.Errorf("failed to close filepath %s: %w", pth, err)
+		logger.DebugContext(ctx, "resolving secret", "secret", fileRef.String())
+
+		pth, err := c.resolveReference(ctx, fileRef)
+		if err != nil {
+			return nil, err
 		}
 
-		// Set the plaintext to the resulting file path
-		plaintext = []byte(f.Name())
+		manifest.Files = append(manifest.Files, &DirectoryManifestFile{
+			Reference: fileRef.String(),
+			Path:      string(pth),
+		})
 	}
 
-	return plaintext, nil
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	return manifest, nil
 }
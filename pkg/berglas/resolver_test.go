@@ -17,6 +17,8 @@ package berglas
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -63,6 +65,48 @@ func TestClient_Resolve_secretManager(t *testing.T) {
 			t.Errorf("expected %q to be %q", act, exp)
 		}
 	})
+
+	t.Run("fallback", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name := testProject(t), testName(t)
+		plaintext := []byte("my secret plaintext")
+
+		if _, err := client.Create(ctx, &SecretManagerCreateRequest{
+			Project:   project,
+			Name:      name,
+			Plaintext: plaintext,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer testSecretManagerCleanup(t, project, name)
+
+		ref := fmt.Sprintf("sm://%s/%s|sm://%s/%s",
+			project, testName(t), project, name)
+
+		b, err := client.Resolve(ctx, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if act, exp := b, plaintext; !bytes.Equal(act, exp) {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+	})
+
+	t.Run("fallback_all_missing", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project := testProject(t)
+		ref := fmt.Sprintf("sm://%s/%s|sm://%s/%s",
+			project, testName(t), project, testName(t))
+
+		if _, err := client.Resolve(ctx, ref); err == nil {
+			t.Error("expected error")
+		}
+	})
 }
 
 func TestClient_Resolve_storage(t *testing.T) {
@@ -109,4 +153,45 @@ func TestClient_Resolve_storage(t *testing.T) {
 			t.Errorf("expected %q to be %q", act, exp)
 		}
 	})
+
+	t.Run("directory", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		bucket, prefix, key := testBucket(t), testName(t), testKey(t)
+
+		for _, name := range []string{"a", "b"} {
+			object := prefix + "/" + name
+			if _, err := client.Create(ctx, &StorageCreateRequest{
+				Bucket:    bucket,
+				Object:    object,
+				Key:       key,
+				Plaintext: []byte(name),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			defer testStorageCleanup(t, bucket, object)
+		}
+
+		dir := t.TempDir()
+		ref := fmt.Sprintf("berglas://%s/%s/*?destination=%s", bucket, prefix, dir)
+
+		manifest, err := client.ResolveDirectory(ctx, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act, exp := len(manifest.Files), 2; act != exp {
+			t.Fatalf("expected %d to be %d", act, exp)
+		}
+
+		for _, name := range []string{"a", "b"} {
+			b, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if act, exp := string(b), name; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		}
+	})
 }
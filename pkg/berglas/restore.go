@@ -0,0 +1,133 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+
+	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// SecretManagerRestoreRequest is used as input to recover a Secret Manager
+// version that was destroyed with a destroy TTL and is still awaiting
+// destruction.
+type SecretManagerRestoreRequest struct {
+	// Project is the ID or number of the project of the secret.
+	Project string
+
+	// Name is the name of the secret.
+	Name string
+
+	// Version is the version to recover.
+	Version string
+}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerRestoreRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	v.require("Name", r.Name, "missing secret name")
+	v.require("Version", r.Version, "missing version")
+	return v.err()
+}
+
+// Restore is a top-level package function for recovering a secret version. For
+// large volumes of secrets, please create a client instead.
+func Restore(ctx context.Context, i *SecretManagerRestoreRequest) (*Secret, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Restore(ctx, i)
+}
+
+// Restore recovers a Secret Manager version that was destroyed while the
+// secret had a version destroy TTL, such as with the DestroyTTL field of
+// SecretManagerDeleteRequest. Until the TTL passes, the version is only
+// disabled, and Restore enables it again, which cancels its destruction. It
+// returns ErrNotRecoverable if the version is not scheduled for destruction or
+// was already destroyed.
+func (c *Client) Restore(ctx context.Context, i *SecretManagerRestoreRequest) (*Secret, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	project, name, version := i.Project, i.Name, i.Version
+
+	logger := logging.FromContext(ctx).With(
+		"project", project,
+		"name", name,
+		"version", version,
+	)
+
+	logger.DebugContext(ctx, "restore.start")
+	defer logger.DebugContext(ctx, "restore.finish")
+
+	versionName := fmt.Sprintf("projects/%s/secrets/%s/versions/%s", project, name, version)
+	current, err := c.secretManagerClient.GetSecretVersion(ctx, &secretspb.GetSecretVersionRequest{
+		Name: versionName,
+	})
+	if err != nil {
+		if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.NotFound {
+			return nil, secretManagerError(ErrSecretDoesNotExist, project, name)
+		}
+		return nil, fmt.Errorf("failed to read secret version: %w", err)
+	}
+
+	if current.GetState() == secretspb.SecretVersion_DESTROYED {
+		return nil, secretManagerError(fmt.Errorf("%w: version %s was destroyed",
+			ErrNotRecoverable, version), project, name)
+	}
+	if current.GetScheduledDestroyTime() == nil {
+		return nil, secretManagerError(fmt.Errorf("%w: version %s is not scheduled for destruction",
+			ErrNotRecoverable, version), project, name)
+	}
+
+	logger.DebugContext(ctx, "enabling version",
+		"scheduled_destroy_time", current.GetScheduledDestroyTime().AsTime())
+
+	restored, err := c.secretManagerClient.EnableSecretVersion(ctx, &secretspb.EnableSecretVersionRequest{
+		Name: versionName,
+		Etag: current.GetEtag(),
+	})
+	if err != nil {
+		terr, ok := grpcstatus.FromError(err)
+		if ok && terr.Code() == grpccodes.Aborted {
+			return nil, secretManagerError(ErrSecretModified, project, name)
+		}
+		return nil, fmt.Errorf("failed to enable secret version: %w", err)
+	}
+
+	return &Secret{
+		Parent:    project,
+		Name:      name,
+		Version:   version,
+		State:     restored.GetState().String(),
+		UpdatedAt: timestampToTime(restored.GetCreateTime()),
+	}, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClient_Restore(t *testing.T) {
+	testAcc(t)
+
+	t.Run("destroy_ttl", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name := testProject(t), testName(t)
+
+		secret, err := client.Create(ctx, &SecretManagerCreateRequest{
+			Project:   project,
+			Name:      name,
+			Plaintext: []byte("my secret value"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer testSecretManagerCleanup(t, project, name)
+
+		if err := client.Delete(ctx, &SecretManagerDeleteRequest{
+			Project:    project,
+			Name:       name,
+			Version:    secret.Version,
+			DestroyTTL: 24 * time.Hour,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := client.Access(ctx, &SecretManagerAccessRequest{
+			Project: project,
+			Name:    name,
+			Version: secret.Version,
+		}); err == nil {
+			t.Fatal("expected access to a version awaiting destruction to fail")
+		}
+
+		if _, err := client.Restore(ctx, &SecretManagerRestoreRequest{
+			Project: project,
+			Name:    name,
+			Version: secret.Version,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		plaintext, err := client.Access(ctx, &SecretManagerAccessRequest{
+			Project: project,
+			Name:    name,
+			Version: secret.Version,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(plaintext), "my secret value"; got != want {
+			t.Errorf("expected %q to be %q", got, want)
+		}
+	})
+
+	t.Run("not_scheduled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name := testProject(t), testName(t)
+
+		secret, err := client.Create(ctx, &SecretManagerCreateRequest{
+			Project:   project,
+			Name:      name,
+			Plaintext: []byte("my secret value"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer testSecretManagerCleanup(t, project, name)
+
+		if _, err := client.Restore(ctx, &SecretManagerRestoreRequest{
+			Project: project,
+			Name:    name,
+			Version: secret.Version,
+		}); !errors.Is(err, ErrNotRecoverable) {
+			t.Errorf("expected %q to be %q", err, ErrNotRecoverable)
+		}
+	})
+}
+
+func TestClient_Restore_readOnly(t *testing.T) {
+	t.Parallel()
+
+	client := &Client{readOnly: true}
+	if _, err := client.Restore(context.Background(), &SecretManagerRestoreRequest{
+		Project: "p",
+		Name:    "n",
+		Version: "1",
+	}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected %q to be %q", err, ErrReadOnly)
+	}
+}
@@ -19,7 +19,6 @@ import (
 	"fmt"
 	"sort"
 
-	"cloud.google.com/go/iam"
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
 	grpccodes "google.golang.org/grpc/codes"
@@ -28,6 +27,7 @@ import (
 
 type revokeRequest interface {
 	isRevokeRequest()
+	Validate() error
 }
 
 // StorageRevokeRequest is used as input to revoke access to a from Cloud
@@ -42,10 +42,28 @@ type StorageRevokeRequest struct {
 	// Members is the list of membership bindings. This should be in the format
 	// described at https://godoc.org/google.golang.org/api/iam/v1#Binding.
 	Members []string
+
+	// DryRun computes the changes without updating any IAM policies.
+	DryRun bool
+
+	// AllowACLFallback changes access with a READER object ACL entry if the
+	// bucket does not support object IAM policies. A warning is recorded on
+	// the result when this happens.
+	AllowACLFallback bool
 }
 
 func (r *StorageRevokeRequest) isRevokeRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageRevokeRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	v.members("Members", r.Members)
+	return v.err()
+}
+
 // RevokeRequest is an alias for StorageRevokeRequest for
 // backwards-compatibility. New clients should use StorageRevokeRequest.
 type RevokeRequest = StorageRevokeRequest
@@ -62,14 +80,33 @@ type SecretManagerRevokeRequest struct {
 	// Members is the list of membership bindings. This should be in the format
 	// described at https://godoc.org/google.golang.org/api/iam/v1#Binding.
 	Members []string
+
+	// DryRun computes the changes without updating any IAM policies.
+	DryRun bool
+
+	// Scope is the resource to change. With SecretManagerScopeProject, the
+	// role is changed on the project's IAM policy, which applies to every
+	// secret in the project, and Name must be empty. Defaults to
+	// SecretManagerScopeSecret.
+	Scope SecretManagerScope
 }
 
 func (r *SecretManagerRevokeRequest) isRevokeRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerRevokeRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	v.secretManagerScope("Scope", "Name", r.Scope, r.Name)
+	v.members("Members", r.Members)
+	return v.err()
+}
+
 // Revoke is a top-level package function for revokeing access to a secret. For
 // large volumes of secrets, please create a client instead.
 func Revoke(ctx context.Context, i revokeRequest) error {
-	client, err := New(ctx)
+	client, err := DefaultClient(ctx)
 	if err != nil {
 		return err
 	}
@@ -79,8 +116,24 @@ func Revoke(ctx context.Context, i revokeRequest) error {
 // Revoke removes IAM permission to the given entity on the storage object and
 // the underlying KMS key.
 func (c *Client) Revoke(ctx context.Context, i revokeRequest) error {
+	_, err := c.RevokeWithResult(ctx, i)
+	return err
+}
+
+// RevokeWithResult is like Revoke, but returns the effect on each member of each
+// resource. If the request sets DryRun, the IAM policies are read but not
+// written, and the result describes the changes that would have been made.
+func (c *Client) RevokeWithResult(ctx context.Context, i revokeRequest) (*IAMResult, error) {
 	if i == nil {
-		return fmt.Errorf("missing request")
+		return nil, fmt.Errorf("missing request")
+	}
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
 	}
 
 	switch t := i.(type) {
@@ -89,70 +142,71 @@ func (c *Client) Revoke(ctx context.Context, i revokeRequest) error {
 	case *StorageRevokeRequest:
 		return c.storageRevoke(ctx, t)
 	default:
-		return fmt.Errorf("unknown revoke type %T", t)
+		return nil, fmt.Errorf("unknown revoke type %T", t)
 	}
 }
 
-func (c *Client) secretManagerRevoke(ctx context.Context, i *SecretManagerRevokeRequest) error {
+func (c *Client) secretManagerRevoke(ctx context.Context, i *SecretManagerRevokeRequest) (*IAMResult, error) {
 	project := i.Project
-	if project == "" {
-		return fmt.Errorf("missing project")
-	}
-
 	name := i.Name
-	if name == "" {
-		return fmt.Errorf("missing secret name")
-	}
-
 	members := i.Members
+	dryRun := i.DryRun
+	result := &IAMResult{DryRun: dryRun}
 	if len(members) == 0 {
-		return nil
+		return result, nil
 	}
 	sort.Strings(members)
 
 	logger := logging.FromContext(ctx).With(
 		"project", project,
 		"name", name,
+		"scope", i.Scope,
 		"members", members,
+		"dry_run", dryRun,
 	)
 
 	logger.DebugContext(ctx, "revoke.start")
 	defer logger.DebugContext(ctx, "revoke.finish")
 
-	logger.DebugContext(ctx, "revoking access to seetcr")
+	if i.Scope == SecretManagerScopeProject {
+		logger.DebugContext(ctx, "revoking access to project")
 
-	storageHandle := c.secretManagerIAM(project, name)
-	if err := updateIAMPolicy(ctx, storageHandle, func(p *iam.Policy) *iam.Policy {
-		for _, m := range members {
-			p.Remove(m, iamSecretManagerAccessor)
+		changes, err := c.changeProjectIAMMembers(ctx, project, iamSecretManagerAccessor,
+			members, false, dryRun, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update project IAM policy for %s: %w", project, err)
 		}
-		return p
-	}); err != nil {
+		result.Changes = append(result.Changes, changes...)
+		return result, nil
+	}
+
+	logger.DebugContext(ctx, "revoking access to seetcr")
+
+	handle := c.secretManagerIAM(project, name).V3()
+	resource := fmt.Sprintf("projects/%s/secrets/%s", project, name)
+	changes, err := changeIAMMembers3(ctx, handle, resource, iamSecretManagerAccessor,
+		members, false, dryRun, nil)
+	if err != nil {
 		terr, ok := grpcstatus.FromError(err)
 		if ok && terr.Code() == grpccodes.NotFound {
-			return errSecretDoesNotExist
+			return nil, secretManagerError(ErrSecretDoesNotExist, project, name)
 		}
 
-		return fmt.Errorf("failed to update Storage IAM policy for %s: %w", name, err)
+		return nil, fmt.Errorf("failed to update Storage IAM policy for %s: %w", name, err)
 	}
+	result.Changes = append(result.Changes, changes...)
 
-	return nil
+	return result, nil
 }
 
-func (c *Client) storageRevoke(ctx context.Context, i *StorageRevokeRequest) error {
+func (c *Client) storageRevoke(ctx context.Context, i *StorageRevokeRequest) (*IAMResult, error) {
 	bucket := i.Bucket
-	if bucket == "" {
-		return fmt.Errorf("missing bucket name")
-	}
-
 	object := i.Object
-	if object == "" {
-		return fmt.Errorf("missing object name")
-	}
-
 	members := i.Members
+	dryRun := i.DryRun
+	result := &IAMResult{DryRun: dryRun}
 	if len(members) == 0 {
-		return nil
+		return result, nil
 	}
 	sort.Strings(members)
 
@@ -160,6 +214,7 @@ func (c *Client) storageRevoke(ctx context.Context, i *StorageRevokeRequest) err
 		"bucket", bucket,
 		"object", object,
 		"members", members,
+		"dry_run", dryRun,
 	)
 
 	logger.DebugContext(ctx, "revoke.start")
@@ -171,13 +226,13 @@ func (c *Client) storageRevoke(ctx context.Context, i *StorageRevokeRequest) err
 	objHandle := c.storageClient.Bucket(bucket).Object(object)
 	attrs, err := objHandle.Attrs(ctx)
 	if err == storage.ErrObjectNotExist {
-		return errSecretDoesNotExist
+		return nil, storageError(ErrSecretDoesNotExist, bucket, object)
 	}
 	if err != nil {
-		return fmt.Errorf("failed to read secret metadata: %w", err)
+		return nil, fmt.Errorf("failed to read secret metadata: %w", err)
 	}
 	if attrs.Metadata == nil || attrs.Metadata[MetadataKMSKey] == "" {
-		return fmt.Errorf("missing kms key in secret metadata")
+		return nil, fmt.Errorf("missing kms key in secret metadata")
 	}
 	key := attrs.Metadata[MetadataKMSKey]
 
@@ -187,28 +242,42 @@ func (c *Client) storageRevoke(ctx context.Context, i *StorageRevokeRequest) err
 	// Remove access to storage
 	logger.DebugContext(ctx, "revoking access to storage")
 
-	storageHandle := c.storageIAM(bucket, object)
-	if err := updateIAMPolicy(ctx, storageHandle, func(p *iam.Policy) *iam.Policy {
-		for _, m := range members {
-			p.Remove(m, iamObjectReader)
-		}
-		return p
-	}); err != nil {
-		return fmt.Errorf("failed to update Storage IAM policy for %s: %w", object, err)
+	changes, err := c.changeStorageObjectMembers(ctx, bucket, object, members, false, dryRun)
+	if IsObjectIAMUnavailableErr(err) && i.AllowACLFallback {
+		logger.WarnContext(ctx, "object iam unavailable, falling back to object acl", "error", err)
+
+		result.Warnings = append(result.Warnings, &IAMWarning{
+			Kind:     IAMWarningACLFallback,
+			Resource: bucket + "/" + object,
+			Message:  "bucket does not support object IAM policies, used object ACLs instead",
+		})
+		changes, err = c.changeStorageACLMembers(ctx, bucket, object, members, false, dryRun)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update Storage IAM policy for %s: %w", object, err)
 	}
+	result.Changes = append(result.Changes, changes...)
 
 	// Remove access to KMS
+	if !isCloudKMSKey(key) {
+		logger.WarnContext(ctx, "skipping key iam, key is not a cloud kms key")
+
+		result.Warnings = append(result.Warnings, &IAMWarning{
+			Kind:     IAMWarningExternalKey,
+			Resource: key,
+			Message:  "key is not a Cloud KMS key, access to it must be managed separately",
+		})
+		return result, nil
+	}
 	logger.DebugContext(ctx, "revoking access to kms")
 
 	kmsHandle := c.kmsClient.ResourceIAM(key)
-	if err := updateIAMPolicy(ctx, kmsHandle, func(p *iam.Policy) *iam.Policy {
-		for _, m := range members {
-			p.Remove(m, iamKMSDecrypt)
-		}
-		return p
-	}); err != nil {
-		return fmt.Errorf("failed to update KMS IAM policy for %s: %w", key, err)
+	changes, err = changeIAMMembers(ctx, kmsHandle, key, iamKMSDecrypt,
+		members, false, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update KMS IAM policy for %s: %w", key, err)
 	}
+	result.Changes = append(result.Changes, changes...)
 
-	return nil
+	return result, nil
 }
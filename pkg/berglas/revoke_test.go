@@ -16,6 +16,7 @@ package berglas
 
 import (
 	"context"
+	"reflect"
 	"testing"
 
 	"cloud.google.com/go/iam"
@@ -24,22 +25,6 @@ import (
 func TestClient_Revoke_secretManager(t *testing.T) {
 	testAcc(t)
 
-	policyIncludesServiceAccount := func(tb testing.TB, h *iam.Handle, serviceAccount string) bool {
-		policy, err := getIAMPolicy(context.Background(), h)
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		found := false
-		members := policy.Members(iamSecretManagerAccessor)
-		for _, member := range members {
-			if member == serviceAccount {
-				found = true
-			}
-		}
-		return found
-	}
-
 	t.Run("missing", func(t *testing.T) {
 		t.Parallel()
 
@@ -51,7 +36,63 @@ func TestClient_Revoke_secretManager(t *testing.T) {
 			Name:    name,
 			Members: []string{serviceAccount},
 		}); !IsSecretDoesNotExistErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretDoesNotExist)
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
+		}
+	})
+
+	t.Run("dry_run", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name, serviceAccount := testProject(t), testName(t), testServiceAccount(t)
+
+		if _, err := client.Create(ctx, &SecretManagerCreateRequest{
+			Project:   project,
+			Name:      name,
+			Plaintext: []byte("my secret value"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer testSecretManagerCleanup(t, project, name)
+
+		if err := client.Grant(ctx, &SecretManagerGrantRequest{
+			Project: project,
+			Name:    name,
+			Members: []string{serviceAccount},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		handle := client.secretManagerIAM(project, name)
+		before, err := getIAMPolicy(ctx, handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := client.RevokeWithResult(ctx, &SecretManagerRevokeRequest{
+			Project: project,
+			Name:    name,
+			Members: []string{serviceAccount},
+			DryRun:  true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Changes) != 1 || result.Changes[0].Action != IAMActionRemoved {
+			t.Errorf("expected a single removed change, got %#v", result.Changes)
+		}
+
+		after, err := getIAMPolicy(ctx, handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(after.InternalProto, before.InternalProto) {
+			t.Errorf("expected policy to be unchanged, got %v (was %v)",
+				after.InternalProto, before.InternalProto)
+		}
+		if !testPolicyIncludes(t, handle, iamSecretManagerAccessor, serviceAccount) {
+			t.Errorf("expected policy to include %q", serviceAccount)
 		}
 	})
 
@@ -79,7 +120,7 @@ func TestClient_Revoke_secretManager(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if !policyIncludesServiceAccount(t, client.secretManagerIAM(project, name), serviceAccount) {
+		if !testPolicyIncludes(t, client.secretManagerIAM(project, name), iamSecretManagerAccessor, serviceAccount) {
 			t.Errorf("expected policy to include %q", serviceAccount)
 		}
 
@@ -91,7 +132,7 @@ func TestClient_Revoke_secretManager(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if policyIncludesServiceAccount(t, client.secretManagerIAM(project, name), serviceAccount) {
+		if testPolicyIncludes(t, client.secretManagerIAM(project, name), iamSecretManagerAccessor, serviceAccount) {
 			t.Errorf("expected policy to not include %q", serviceAccount)
 		}
 	})
@@ -100,22 +141,6 @@ func TestClient_Revoke_secretManager(t *testing.T) {
 func TestClient_Revoke_storage(t *testing.T) {
 	testAcc(t)
 
-	policyIncludesServiceAccount := func(tb testing.TB, h *iam.Handle, serviceAccount string) bool {
-		policy, err := getIAMPolicy(context.Background(), h)
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		found := false
-		members := policy.Members(iamObjectReader)
-		for _, member := range members {
-			if member == serviceAccount {
-				found = true
-			}
-		}
-		return found
-	}
-
 	t.Run("missing", func(t *testing.T) {
 		t.Parallel()
 
@@ -127,7 +152,7 @@ func TestClient_Revoke_storage(t *testing.T) {
 			Object:  object,
 			Members: []string{serviceAccount},
 		}); !IsSecretDoesNotExistErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretDoesNotExist)
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
 		}
 	})
 
@@ -156,7 +181,7 @@ func TestClient_Revoke_storage(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if !policyIncludesServiceAccount(t, client.storageIAM(bucket, object), serviceAccount) {
+		if !testPolicyIncludes(t, client.storageIAM(bucket, object), iamObjectReader, serviceAccount) {
 			t.Errorf("expected policy to include %q", serviceAccount)
 		}
 
@@ -168,8 +193,25 @@ func TestClient_Revoke_storage(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if policyIncludesServiceAccount(t, client.storageIAM(bucket, object), serviceAccount) {
+		if testPolicyIncludes(t, client.storageIAM(bucket, object), iamObjectReader, serviceAccount) {
 			t.Errorf("expected policy to not include %q", serviceAccount)
 		}
 	})
 }
+
+// testPolicyIncludes reports whether the IAM policy of the handle grants the
+// role to the member.
+func testPolicyIncludes(tb testing.TB, h *iam.Handle, role iam.RoleName, member string) bool {
+	tb.Helper()
+
+	policy, err := getIAMPolicy(context.Background(), h)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, m := range policy.Members(role) {
+		if m == member {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// WithRollbackProtection returns a client option that detects rollback of
+// Cloud Storage secrets, where an older generation of a secret is restored as
+// the live generation, such as by copying a noncurrent generation over it.
+//
+// Every write stamps the secret with a write counter, one higher than any
+// other generation of the object, which is bound into the KMS additional
+// authenticated data so it cannot be altered. Reading the live generation
+// returns a *RollbackError if another generation of the object has a higher
+// counter, or if the client has already read a higher counter for the object.
+// Comparing against other generations requires object versioning and one
+// extra list call per read.
+//
+// Once a secret has a write counter, every client increments it on update,
+// even without this option.
+func WithRollbackProtection() option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.rollbackProtection = true
+	}}
+}
+
+// RollbackError is the error returned when the live generation of a secret has
+// a lower write counter than another generation of it, meaning an older
+// generation was restored. It wraps ErrRollbackDetected.
+type RollbackError struct {
+	// Generation and Counter are the live generation and its write counter.
+	// Counter is zero if the live generation was written without one.
+	Generation int64
+	Counter    int64
+
+	// NewerGeneration and NewerCounter are the generation with the highest
+	// write counter. NewerGeneration is zero if the counter was previously
+	// read by the client rather than found in a noncurrent generation.
+	NewerGeneration int64
+	NewerCounter    int64
+}
+
+// Error implements the error interface.
+func (e *RollbackError) Error() string {
+	if e.NewerGeneration == 0 {
+		return fmt.Sprintf("%s: generation %d has write counter %d, but counter %d was already read",
+			ErrRollbackDetected, e.Generation, e.Counter, e.NewerCounter)
+	}
+	return fmt.Sprintf("%s: generation %d has write counter %d, but generation %d has write counter %d",
+		ErrRollbackDetected, e.Generation, e.Counter, e.NewerGeneration, e.NewerCounter)
+}
+
+// Unwrap returns ErrRollbackDetected.
+func (e *RollbackError) Unwrap() error {
+	return ErrRollbackDetected
+}
+
+// storageWriteCounter returns the write counter recorded in the object
+// metadata, or zero if there is none. Malformed counters are also treated as
+// zero, which fails decryption since the counter is bound into the AAD.
+func storageWriteCounter(metadata map[string]string) int64 {
+	n, err := strconv.ParseInt(metadata[MetadataWriteCounterKey], 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// storageNextWriteCounter returns the write counter for a new generation of
+// the object, given the attributes of the live generation (nil if there is
+// none). It is zero if the secret should not be stamped.
+func (c *Client) storageNextWriteCounter(ctx context.Context, bucket, object string, live *storage.ObjectAttrs) (int64, error) {
+	var counter int64
+	if live != nil {
+		counter = storageWriteCounter(live.Metadata)
+	}
+
+	if !c.rollbackProtection {
+		if counter == 0 {
+			return 0, nil
+		}
+		return counter + 1, nil
+	}
+
+	// Include noncurrent generations, so a secret that is deleted and created
+	// again does not look rolled back.
+	newest, _, err := c.storageNewestWriteCounter(ctx, bucket, object, 0)
+	if err != nil {
+		return 0, err
+	}
+	return max(counter, newest) + 1, nil
+}
+
+// storageNewestWriteCounter returns the highest write counter of any
+// generation of the object other than exclude, and the generation it was found
+// on.
+func (c *Client) storageNewestWriteCounter(ctx context.Context, bucket, object string, exclude int64) (int64, int64, error) {
+	query := &storage.Query{
+		Prefix:   object,
+		Versions: true,
+	}
+	if err := query.SetAttrSelection([]string{"Name", "Generation", "Metadata"}); err != nil {
+		return 0, 0, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var counter, generation int64
+	it := c.storageBucket(ctx, bucket).Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to list generations: %w", err)
+		}
+		if attrs.Name != object || attrs.Generation == exclude {
+			continue
+		}
+		if n := storageWriteCounter(attrs.Metadata); n > counter {
+			counter, generation = n, attrs.Generation
+		}
+	}
+	return counter, generation, nil
+}
+
+// storageVerifyWriteCounter returns a *RollbackError if the live generation of
+// the object, whose DEK has already been decrypted, is older than another
+// generation or than a generation the client read before.
+func (c *Client) storageVerifyWriteCounter(ctx context.Context, bucket, object string, live *storage.ObjectAttrs) error {
+	if !c.rollbackProtection {
+		return nil
+	}
+
+	counter := storageWriteCounter(live.Metadata)
+	rerr := &RollbackError{
+		Generation: live.Generation,
+		Counter:    counter,
+	}
+
+	logging.FromContext(ctx).DebugContext(ctx, "verifying write counter",
+		"generation", live.Generation,
+		"counter", counter)
+
+	newest, generation, err := c.storageNewestWriteCounter(ctx, bucket, object, live.Generation)
+	if err != nil {
+		return fmt.Errorf("failed to verify write counter: %w", err)
+	}
+	if newest > counter {
+		rerr.NewerGeneration, rerr.NewerCounter = generation, newest
+		return storageError(rerr, bucket, object)
+	}
+
+	if seen := c.writeCounters.observe(bucket+"/"+object, counter); seen > counter {
+		rerr.NewerCounter = seen
+		return storageError(rerr, bucket, object)
+	}
+	return nil
+}
+
+// writeCounters records the highest write counter read for each object.
+type writeCounters struct {
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+// observe records the counter for the key, returning the highest counter
+// recorded for it, including this one.
+func (w *writeCounters) observe(key string, counter int64) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.counters == nil {
+		w.counters = make(map[string]int64)
+	}
+	if seen := w.counters[key]; seen > counter {
+		return seen
+	}
+	w.counters[key] = counter
+	return counter
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+)
+
+func TestStorageWriteCounter(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		metadata map[string]string
+		exp      int64
+	}{
+		{
+			name:     "nil",
+			metadata: nil,
+			exp:      0,
+		},
+		{
+			name:     "valid",
+			metadata: map[string]string{MetadataWriteCounterKey: "12"},
+			exp:      12,
+		},
+		{
+			name:     "negative",
+			metadata: map[string]string{MetadataWriteCounterKey: "-1"},
+			exp:      0,
+		},
+		{
+			name:     "malformed",
+			metadata: map[string]string{MetadataWriteCounterKey: "twelve"},
+			exp:      0,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if act, exp := storageWriteCounter(tc.metadata), tc.exp; act != exp {
+				t.Errorf("expected %d to be %d", act, exp)
+			}
+		})
+	}
+}
+
+func TestWriteCounters_observe(t *testing.T) {
+	t.Parallel()
+
+	var w writeCounters
+	for _, step := range []struct {
+		counter, exp int64
+	}{
+		{counter: 1, exp: 1},
+		{counter: 3, exp: 3},
+		{counter: 2, exp: 3},
+		{counter: 4, exp: 4},
+	} {
+		if act, exp := w.observe("b/o", step.counter), step.exp; act != exp {
+			t.Errorf("observe(%d): expected %d to be %d", step.counter, act, exp)
+		}
+	}
+
+	if act, exp := w.observe("b/other", 1), int64(1); act != exp {
+		t.Errorf("expected %d to be %d", act, exp)
+	}
+}
+
+func TestRollbackError(t *testing.T) {
+	t.Parallel()
+
+	err := storageError(&RollbackError{
+		Generation:      3,
+		Counter:         1,
+		NewerGeneration: 2,
+		NewerCounter:    2,
+	}, "b", "o")
+
+	if !IsRollbackErr(err) {
+		t.Errorf("expected %v to be a rollback error", err)
+	}
+
+	var rerr *RollbackError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected %T, got %T", rerr, err)
+	}
+	if act, exp := rerr.NewerGeneration, int64(2); act != exp {
+		t.Errorf("expected %d to be %d", act, exp)
+	}
+
+	if act, exp := err.Error(), "secret rollback detected: generation 3 has write "+
+		"counter 1, but generation 2 has write counter 2: gs://b/o"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
+
+func TestClient_RollbackProtection_storage(t *testing.T) {
+	testAcc(t)
+
+	ctx := logging.WithLogger(context.Background(), logging.TestLogger(t))
+
+	client, err := New(ctx, WithRollbackProtection())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := client.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	bucket, object, key := testBucket(t), testName(t), testKey(t)
+	defer testStorageCleanup(t, bucket, object)
+
+	original, err := client.Create(ctx, &StorageCreateRequest{
+		Bucket:    bucket,
+		Object:    object,
+		Key:       key,
+		Plaintext: []byte("v1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Update(ctx, &StorageUpdateRequest{
+		Bucket:    bucket,
+		Object:    object,
+		Plaintext: []byte("v2"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Access(ctx, &StorageAccessRequest{
+		Bucket: bucket,
+		Object: object,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restore the first generation as the live generation.
+	handle := client.storageClient.Bucket(bucket).Object(object)
+	if _, err := handle.CopierFrom(handle.Generation(original.Generation)).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Access(ctx, &StorageAccessRequest{
+		Bucket: bucket,
+		Object: object,
+	}); !IsRollbackErr(err) {
+		t.Errorf("expected rollback error, got %v", err)
+	}
+
+	// Older generations may still be read explicitly.
+	if _, err := client.Access(ctx, &StorageAccessRequest{
+		Bucket:     bucket,
+		Object:     object,
+		Generation: original.Generation,
+	}); err != nil {
+		t.Errorf("expected reading generation %d to succeed, got %v", original.Generation, err)
+	}
+}
@@ -0,0 +1,413 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/option"
+)
+
+// S3Object is an object in an S3-compatible store.
+type S3Object struct {
+	// Data is the object contents.
+	Data []byte
+
+	// Metadata is the user-defined object metadata.
+	Metadata map[string]string
+
+	// UpdatedAt is when the object was last modified. It is ignored when
+	// writing.
+	UpdatedAt time.Time
+
+	// ETag is the entity tag of the object, if the store reports one. It is
+	// ignored when writing.
+	ETag string
+}
+
+// S3Store reads and writes objects in an S3-compatible store such as AWS S3 or
+// MinIO. Secrets in an S3Store use the same envelope format and key wrappers
+// as Cloud Storage secrets.
+type S3Store interface {
+	// GetObject returns the object. If the object does not exist, the returned
+	// error wraps fs.ErrNotExist.
+	GetObject(ctx context.Context, bucket, object string) (*S3Object, error)
+
+	// PutObject writes the object. If ifNotExists is true and the object
+	// already exists, the returned error wraps fs.ErrExist.
+	PutObject(ctx context.Context, bucket, object string, o *S3Object, ifNotExists bool) error
+}
+
+// S3ConditionalStore is an S3Store that can write an object only if it has not
+// changed since it was read. Stores that implement it let
+// StorageS3UpdateRequest set ETag to detect concurrent writes.
+type S3ConditionalStore interface {
+	S3Store
+
+	// PutObjectIfMatch writes the object only if its current ETag is etag. If
+	// the object changed or no longer exists, the returned error wraps
+	// ErrSecretModified.
+	PutObjectIfMatch(ctx context.Context, bucket, object string, o *S3Object, etag string) error
+}
+
+// WithS3Store returns a client option that stores S3 secrets using s.
+func WithS3Store(s S3Store) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.s3Store = s
+	}}
+}
+
+// StorageS3AccessRequest is used as input to access a secret from an
+// S3-compatible store.
+type StorageS3AccessRequest struct {
+	// Bucket is the name of the bucket where the secret lives.
+	Bucket string
+
+	// Object is the name of the object in the bucket.
+	Object string
+}
+
+func (r *StorageS3AccessRequest) isAccessRequest() {}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageS3AccessRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	return v.err()
+}
+
+// StorageS3ReadRequest is used as input to read a secret, including its
+// metadata, from an S3-compatible store.
+type StorageS3ReadRequest struct {
+	// Bucket is the name of the bucket where the secret lives.
+	Bucket string
+
+	// Object is the name of the object in the bucket.
+	Object string
+}
+
+func (r *StorageS3ReadRequest) isReadRequest() {}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageS3ReadRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	return v.err()
+}
+
+// StorageS3CreateRequest is used as input to create a secret in an
+// S3-compatible store.
+type StorageS3CreateRequest struct {
+	// Bucket is the name of the bucket where the secret lives.
+	Bucket string
+
+	// Object is the name of the object in the bucket.
+	Object string
+
+	// Key is the key used to wrap the data encryption key, such as a Cloud KMS
+	// key name or an aws-kms:// key URI.
+	Key string
+
+	// Plaintext is the plaintext secret to encrypt and store.
+	Plaintext []byte
+}
+
+func (r *StorageS3CreateRequest) isCreateRequest() {}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageS3CreateRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	v.require("Key", r.Key, "missing key name")
+	v.kmsKey("Key", r.Key)
+	v.requireBytes("Plaintext", r.Plaintext, "missing plaintext")
+	return v.err()
+}
+
+// StorageS3UpdateRequest is used as input to update a secret in an
+// S3-compatible store. S3 has no generations, so concurrent updates are last
+// writer wins unless ETag is set.
+type StorageS3UpdateRequest struct {
+	// Bucket is the name of the bucket where the secret lives.
+	Bucket string
+
+	// Object is the name of the object in the bucket.
+	Object string
+
+	// Key is the key used to wrap the data encryption key. If empty, the key of
+	// the existing secret is used.
+	Key string
+
+	// Plaintext value of the secret.
+	Plaintext []byte
+
+	// CreateIfMissing indicates that the updater should create a secret with the
+	// given parameters if one does not already exist.
+	CreateIfMissing bool
+
+	// ETag, if set, is the entity tag of the secret as last read. The update
+	// fails with ErrSecretModified if the secret changed since. It requires a
+	// store that implements S3ConditionalStore.
+	ETag string
+}
+
+func (r *StorageS3UpdateRequest) isUpdateRequest() {}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageS3UpdateRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	v.kmsKey("Key", r.Key)
+	v.requireBytes("Plaintext", r.Plaintext, "missing plaintext")
+	return v.err()
+}
+
+// s3 returns the configured S3 store.
+func (c *Client) s3() (S3Store, error) {
+	if c.s3Store == nil {
+		return nil, fmt.Errorf("no s3 store configured")
+	}
+	return c.s3Store, nil
+}
+
+func (c *Client) s3Access(ctx context.Context, i *StorageS3AccessRequest) (*accessResult, error) {
+	bucket := i.Bucket
+	object := i.Object
+
+	logger := logging.FromContext(ctx).With(
+		"bucket", bucket,
+		"object", object,
+	)
+
+	logger.DebugContext(ctx, "access.start")
+	defer logger.DebugContext(ctx, "access.finish")
+
+	secret, err := c.s3Read(ctx, bucket, object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access secret: %w", err)
+	}
+	return &accessResult{plaintext: secret.Plaintext, policy: secret.Policy}, nil
+}
+
+// s3Read reads and decrypts the secret.
+func (c *Client) s3Read(ctx context.Context, bucket, object string) (*Secret, error) {
+	logger := logging.FromContext(ctx).With(
+		"bucket", bucket,
+		"object", object,
+	)
+
+	store, err := c.s3()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.DebugContext(ctx, "reading object from s3")
+
+	o, err := store.GetObject(ctx, bucket, object)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, s3Error(ErrSecretDoesNotExist, bucket, object)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+	if o.Metadata == nil || o.Metadata[MetadataKMSKey] == "" {
+		return nil, fmt.Errorf("missing kms key in secret metadata")
+	}
+	key := o.Metadata[MetadataKMSKey]
+
+	logger = logger.With("key", key)
+	logger.DebugContext(ctx, "deconstructing and decoding ciphertext into parts")
+
+	alg, encDEK, ciphertext, err := envelopeDecode(string(o.Data))
+	if err != nil {
+		return nil, err
+	}
+
+	logger.DebugContext(ctx, "decrypting dek")
+
+	dek, err := c.unwrapDEK(ctx, key, encDEK,
+		storageAAD(storageAADBound(o.Metadata), bucket, object, c.aadContext,
+			storageWriteCounter(o.Metadata)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt dek: %w", err)
+	}
+	defer c.wipe(dek)
+
+	plaintext, err := envelopeDecrypt(alg, dek, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt envelope: %w", err)
+	}
+	return secretFromS3Object(bucket, object, o, plaintext), nil
+}
+
+func (c *Client) s3Create(ctx context.Context, i *StorageS3CreateRequest) (*Secret, error) {
+	secret, err := c.s3EncryptAndWrite(ctx, i.Bucket, i.Object, i.Key, i.Plaintext, true, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret: %w", err)
+	}
+	return secret, nil
+}
+
+func (c *Client) s3Update(ctx context.Context, i *StorageS3UpdateRequest) (*Secret, error) {
+	bucket := i.Bucket
+	object := i.Object
+	key := i.Key
+
+	logger := logging.FromContext(ctx).With(
+		"bucket", bucket,
+		"object", object,
+		"key", key,
+	)
+
+	logger.DebugContext(ctx, "update.start")
+	defer logger.DebugContext(ctx, "update.finish")
+
+	store, err := c.s3()
+	if err != nil {
+		return nil, err
+	}
+
+	if i.ETag != "" {
+		if _, ok := store.(S3ConditionalStore); !ok {
+			return nil, fmt.Errorf("s3 store does not support conditional writes")
+		}
+	}
+
+	// Find the existing key, if any
+	create := false
+	o, err := store.GetObject(ctx, bucket, object)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if i.ETag != "" {
+			return nil, s3Error(ErrSecretModified, bucket, object)
+		}
+		if !i.CreateIfMissing {
+			return nil, s3Error(ErrSecretDoesNotExist, bucket, object)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("missing key name")
+		}
+		create = true
+	case err != nil:
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	case i.ETag != "" && o.ETag != i.ETag:
+		return nil, s3Error(ErrSecretModified, bucket, object)
+	case key == "":
+		key = o.Metadata[MetadataKMSKey]
+		if key == "" {
+			return nil, fmt.Errorf("missing kms key in secret metadata")
+		}
+	}
+
+	secret, err := c.s3EncryptAndWrite(ctx, bucket, object, key, i.Plaintext, create, i.ETag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update secret: %w", err)
+	}
+	return secret, nil
+}
+
+// s3EncryptAndWrite encrypts the plaintext and writes it to the S3 store in
+// the same envelope format as Cloud Storage secrets. If etag is not empty, the
+// write is conditional on the object still having that entity tag.
+func (c *Client) s3EncryptAndWrite(ctx context.Context, bucket, object, key string, plaintext []byte, create bool, etag string) (*Secret, error) {
+	logger := logging.FromContext(ctx).With(
+		"bucket", bucket,
+		"object", object,
+		"key", key,
+	)
+
+	logger.DebugContext(ctx, "s3EncryptAndWrite.start")
+	defer logger.DebugContext(ctx, "s3EncryptAndWrite.finish")
+
+	store, err := c.s3()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.DebugContext(ctx, "generating envelope")
+	dek, ciphertext, err := envelopeEncrypt(c.envelopeAlgorithm, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform envelope encryption: %w", err)
+	}
+	defer c.wipe(dek)
+
+	logger.DebugContext(ctx, "encrypting envelope")
+	encDEK, err := c.wrapDEK(ctx, key, dek, storageAAD(c.boundAAD, bucket, object, c.aadContext, 0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+
+	o := &S3Object{
+		Data: []byte(envelopeEncode(c.envelopeAlgorithm, encDEK, ciphertext)),
+		Metadata: map[string]string{
+			MetadataIDKey:  "1",
+			MetadataKMSKey: kmsKeyTrimVersion(key),
+		},
+	}
+	if c.boundAAD {
+		o.Metadata[MetadataAADKey] = aadVersionBound
+	}
+
+	logger.DebugContext(ctx, "writing object to s3", "metadata", o.Metadata)
+	if etag != "" {
+		cs, ok := store.(S3ConditionalStore)
+		if !ok {
+			return nil, fmt.Errorf("s3 store does not support conditional writes")
+		}
+		if err := cs.PutObjectIfMatch(ctx, bucket, object, o, etag); err != nil {
+			if errors.Is(err, ErrSecretModified) {
+				return nil, s3Error(ErrSecretModified, bucket, object)
+			}
+			return nil, fmt.Errorf("failed to write to bucket: %w", err)
+		}
+	} else if err := store.PutObject(ctx, bucket, object, o, create); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return nil, s3Error(ErrSecretAlreadyExists, bucket, object)
+		}
+		return nil, fmt.Errorf("failed to write to bucket: %w", err)
+	}
+
+	o.UpdatedAt = time.Now().UTC()
+	return secretFromS3Object(bucket, object, o, plaintext), nil
+}
+
+// secretFromS3Object constructs a secret from the given S3 object and
+// plaintext.
+func secretFromS3Object(bucket, object string, o *S3Object, plaintext []byte) *Secret {
+	return &Secret{
+		Parent:      bucket,
+		Name:        object,
+		UpdatedAt:   o.UpdatedAt,
+		KMSKey:      o.Metadata[MetadataKMSKey],
+		Policy:      Policy(o.Metadata[MetadataPolicyKey]),
+		ContentType: o.Metadata[MetadataContentTypeKey],
+		Size:        int64(len(o.Data)),
+		ETag:        o.ETag,
+		Plaintext:   plaintext,
+	}
+}
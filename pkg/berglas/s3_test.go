@@ -0,0 +1,174 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memS3Store is an in-memory S3ConditionalStore.
+type memS3Store struct {
+	mu      sync.Mutex
+	objects map[string]*S3Object
+	writes  int
+}
+
+func (s *memS3Store) GetObject(_ context.Context, bucket, object string) (*S3Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.objects[bucket+"/"+object]
+	if !ok {
+		return nil, fmt.Errorf("object %s/%s: %w", bucket, object, fs.ErrNotExist)
+	}
+	return o, nil
+}
+
+func (s *memS3Store) PutObject(_ context.Context, bucket, object string, o *S3Object, ifNotExists bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[bucket+"/"+object]; ok && ifNotExists {
+		return fmt.Errorf("object %s/%s: %w", bucket, object, fs.ErrExist)
+	}
+	s.put(bucket, object, o)
+	return nil
+}
+
+func (s *memS3Store) PutObjectIfMatch(_ context.Context, bucket, object string, o *S3Object, etag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.objects[bucket+"/"+object]; !ok || existing.ETag != etag {
+		return fmt.Errorf("object %s/%s: %w", bucket, object, ErrSecretModified)
+	}
+	s.put(bucket, object, o)
+	return nil
+}
+
+// put stores a copy of o with a new modification time and ETag. The caller
+// must hold the lock.
+func (s *memS3Store) put(bucket, object string, o *S3Object) {
+	if s.objects == nil {
+		s.objects = make(map[string]*S3Object)
+	}
+	s.writes++
+
+	stored := *o
+	stored.UpdatedAt = time.Now().UTC()
+	stored.ETag = fmt.Sprintf(`"%d"`, s.writes)
+	s.objects[bucket+"/"+object] = &stored
+}
+
+func TestClient_S3(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	keyPath := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := KeySchemeLocal + "://" + keyPath
+
+	c := &Client{}
+	WithS3Store(&memS3Store{}).(*clientOption).apply(c)
+
+	if _, err := c.Access(ctx, &StorageS3AccessRequest{Bucket: "b", Object: "o"}); !IsSecretDoesNotExistErr(err) {
+		t.Errorf("expected secret does not exist, got %v", err)
+	}
+
+	if _, err := c.Update(ctx, &StorageS3UpdateRequest{
+		Bucket:    "b",
+		Object:    "o",
+		Plaintext: []byte("v0"),
+	}); !IsSecretDoesNotExistErr(err) {
+		t.Errorf("expected secret does not exist, got %v", err)
+	}
+
+	secret, err := c.Create(ctx, &StorageS3CreateRequest{
+		Bucket:    "b",
+		Object:    "o",
+		Key:       key,
+		Plaintext: []byte("v1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := secret.KMSKey, key; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	if _, err := c.Create(ctx, &StorageS3CreateRequest{
+		Bucket:    "b",
+		Object:    "o",
+		Key:       key,
+		Plaintext: []byte("v1"),
+	}); !IsSecretAlreadyExistsErr(err) {
+		t.Errorf("expected secret already exists, got %v", err)
+	}
+
+	// The key of the existing secret is reused
+	if _, err := c.Update(ctx, &StorageS3UpdateRequest{
+		Bucket:    "b",
+		Object:    "o",
+		Plaintext: []byte("v2"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := c.Access(ctx, &StorageS3AccessRequest{Bucket: "b", Object: "o"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := string(plaintext), "v2"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	read, err := c.Read(ctx, &StorageS3ReadRequest{Bucket: "b", Object: "o"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := string(read.Plaintext), "v2"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+	if read.UpdatedAt.IsZero() {
+		t.Errorf("expected updated time to be set")
+	}
+
+	// Secrets are bound to their object name
+	store := c.s3Store.(*memS3Store)
+	store.objects["b/copy"] = store.objects["b/o"]
+	if _, err := c.Access(ctx, &StorageS3AccessRequest{Bucket: "b", Object: "copy"}); err == nil {
+		t.Errorf("expected error accessing copied secret")
+	}
+}
+
+func TestClient_S3_NotConfigured(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{}
+	if _, err := c.Access(context.Background(), &StorageS3AccessRequest{Bucket: "b", Object: "o"}); err == nil {
+		t.Errorf("expected error without s3 store")
+	}
+}
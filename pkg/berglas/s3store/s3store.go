@@ -0,0 +1,174 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package s3store provides a berglas.S3Store backed by AWS S3 or another
+// S3-compatible store such as MinIO. Register it on a client with:
+//
+//	berglas.WithS3Store(s3store.New(""))
+//
+// Credentials and the region are loaded from the default AWS configuration
+// chain. The endpoint may also be set with the AWS_ENDPOINT_URL_S3 environment
+// variable.
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Store reads and writes secrets in an S3-compatible store.
+type Store struct {
+	endpoint string
+
+	once      sync.Once
+	client    *s3.Client
+	clientErr error
+}
+
+var _ berglas.S3ConditionalStore = (*Store)(nil)
+
+// New creates a new store. If endpoint is not empty, requests are sent to it
+// using path-style addressing, as most S3-compatible stores such as MinIO
+// require. The AWS configuration is loaded on first use.
+func New(endpoint string) *Store {
+	return &Store{endpoint: endpoint}
+}
+
+// GetObject reads the object.
+func (s *Store) GetObject(ctx context.Context, bucket, object string) (*berglas.S3Object, error) {
+	client, err := s.s3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	})
+	if err != nil {
+		if httpStatus(err) == http.StatusNotFound {
+			return nil, fmt.Errorf("object %s/%s: %w", bucket, object, fs.ErrNotExist)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object body: %w", err)
+	}
+
+	return &berglas.S3Object{
+		Data:      data,
+		Metadata:  resp.Metadata,
+		UpdatedAt: aws.ToTime(resp.LastModified),
+		ETag:      aws.ToString(resp.ETag),
+	}, nil
+}
+
+// PutObject writes the object. If ifNotExists is true, the write is
+// conditional on the object not existing, which requires a store that supports
+// conditional writes.
+func (s *Store) PutObject(ctx context.Context, bucket, object string, o *berglas.S3Object, ifNotExists bool) error {
+	client, err := s.s3Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	input := putObjectInput(bucket, object, o)
+	if ifNotExists {
+		input.IfNoneMatch = aws.String("*")
+	}
+
+	if _, err := client.PutObject(ctx, input); err != nil {
+		if ifNotExists && httpStatus(err) == http.StatusPreconditionFailed {
+			return fmt.Errorf("object %s/%s: %w", bucket, object, fs.ErrExist)
+		}
+		return err
+	}
+	return nil
+}
+
+// PutObjectIfMatch writes the object only if its current ETag is etag, which
+// requires a store that supports conditional writes.
+func (s *Store) PutObjectIfMatch(ctx context.Context, bucket, object string, o *berglas.S3Object, etag string) error {
+	client, err := s.s3Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	input := putObjectInput(bucket, object, o)
+	input.IfMatch = aws.String(etag)
+
+	if _, err := client.PutObject(ctx, input); err != nil {
+		switch httpStatus(err) {
+		case http.StatusPreconditionFailed, http.StatusNotFound, http.StatusConflict:
+			return fmt.Errorf("object %s/%s: %w", bucket, object, berglas.ErrSecretModified)
+		}
+		return err
+	}
+	return nil
+}
+
+// putObjectInput builds the unconditional request to write the object.
+func putObjectInput(bucket, object string, o *berglas.S3Object) *s3.PutObjectInput {
+	return &s3.PutObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(object),
+		Body:         bytes.NewReader(o.Data),
+		CacheControl: aws.String(berglas.CacheControl),
+		Metadata:     o.Metadata,
+	}
+}
+
+// s3Client returns the S3 client, loading the AWS configuration on first use.
+func (s *Store) s3Client(ctx context.Context) (*s3.Client, error) {
+	s.once.Do(func() {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			s.clientErr = fmt.Errorf("failed to load aws config: %w", err)
+			return
+		}
+
+		s.client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+			if s.endpoint != "" {
+				o.BaseEndpoint = aws.String(s.endpoint)
+				o.UsePathStyle = true
+			}
+		})
+	})
+	return s.client, s.clientErr
+}
+
+// httpStatus returns the HTTP status code of the error, or 0 if the error is
+// not an HTTP response error.
+func httpStatus(err error) int {
+	var rerr *awshttp.ResponseError
+	if errors.As(err, &rerr) {
+		return rerr.HTTPStatusCode()
+	}
+	return 0
+}
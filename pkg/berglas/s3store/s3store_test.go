@@ -0,0 +1,149 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// testServer is a minimal S3-compatible server for GetObject and PutObject,
+// including If-None-Match and If-Match conditions.
+func testServer(t *testing.T) *Store {
+	t.Helper()
+
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	metadata := make(map[string]string)
+	etags := make(map[string]string)
+	var writes int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+				return
+			}
+			w.Header().Set("X-Amz-Meta-Berglas-Kms-Key", metadata[r.URL.Path])
+			w.Header().Set("ETag", etags[r.URL.Path])
+			w.Write(data)
+		case http.MethodPut:
+			_, ok := objects[r.URL.Path]
+			if ok && r.Header.Get("If-None-Match") == "*" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				io.WriteString(w, `<Error><Code>PreconditionFailed</Code></Error>`)
+				return
+			}
+			if m := r.Header.Get("If-Match"); m != "" {
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+					return
+				}
+				if m != etags[r.URL.Path] {
+					w.WriteHeader(http.StatusPreconditionFailed)
+					io.WriteString(w, `<Error><Code>PreconditionFailed</Code></Error>`)
+					return
+				}
+			}
+			data, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = data
+			metadata[r.URL.Path] = r.Header.Get("X-Amz-Meta-Berglas-Kms-Key")
+			writes++
+			etags[r.URL.Path] = fmt.Sprintf(`"%d"`, writes)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	s := New(srv.URL)
+	s.once.Do(func() {
+		s.client = s3.New(s3.Options{
+			Region:       "us-east-1",
+			BaseEndpoint: aws.String(srv.URL),
+			UsePathStyle: true,
+			Credentials:  credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		})
+	})
+	return s
+}
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := testServer(t)
+
+	if _, err := s.GetObject(ctx, "b", "o"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+
+	o := &berglas.S3Object{
+		Data:     []byte("blob"),
+		Metadata: map[string]string{berglas.MetadataKMSKey: "local-key:///k"},
+	}
+	if err := s.PutObject(ctx, "b", "o", o, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutObject(ctx, "b", "o", o, true); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected exist error, got %v", err)
+	}
+	if err := s.PutObject(ctx, "b", "o", o, false); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetObject(ctx, "b", "o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := string(got.Data), "blob"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+	if act, exp := got.Metadata[berglas.MetadataKMSKey], "local-key:///k"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+	if got.ETag == "" {
+		t.Fatalf("expected etag to be set")
+	}
+
+	if err := s.PutObjectIfMatch(ctx, "b", "o", o, got.ETag); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutObjectIfMatch(ctx, "b", "o", o, got.ETag); !errors.Is(err, berglas.ErrSecretModified) {
+		t.Errorf("expected secret modified error, got %v", err)
+	}
+	if err := s.PutObjectIfMatch(ctx, "b", "missing", o, got.ETag); !errors.Is(err, berglas.ErrSecretModified) {
+		t.Errorf("expected secret modified error, got %v", err)
+	}
+}
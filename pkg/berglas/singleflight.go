@@ -0,0 +1,75 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/api/option"
+)
+
+// WithSingleflight coalesces concurrent Access calls for the same secret,
+// including those made by Resolve and ResolveEnv, into a single upstream
+// request. This is useful when many goroutines resolve the same shared secret
+// at once, such as at startup. Each caller receives its own copy of the
+// plaintext.
+//
+// Calls only share a request while it is in flight; results are not cached. If
+// the request fails because the context of the caller that started it was
+// canceled, the other callers retry with their own context.
+func WithSingleflight() option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.accessGroup = new(singleflight.Group)
+	}}
+}
+
+// accessShared accesses the secret, sharing the upstream request with any
+// identical in-flight call.
+func (c *Client) accessShared(ctx context.Context, i accessRequest) (*accessResult, error) {
+	key := accessRequestKey(ctx, i)
+
+	for {
+		v, err, shared := c.accessGroup.Do(key, func() (any, error) {
+			return c.accessUpstream(ctx, i)
+		})
+		if shared {
+			logging.FromContext(ctx).DebugContext(ctx, "shared in-flight access",
+				"key", key)
+		}
+
+		if err != nil {
+			canceled := errors.Is(err, context.Canceled) ||
+				errors.Is(err, context.DeadlineExceeded)
+			if shared && canceled && ctx.Err() == nil {
+				continue
+			}
+			return nil, err
+		}
+
+		// Every caller may wipe its result, so shared results are copied.
+		result := v.(*accessResult)
+		if shared {
+			return &accessResult{
+				plaintext: bytes.Clone(result.plaintext),
+				policy:    result.policy,
+			}, nil
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowS3Store is an S3Store that counts and delays reads.
+type slowS3Store struct {
+	memS3Store
+	reads atomic.Int32
+}
+
+func (s *slowS3Store) GetObject(ctx context.Context, bucket, object string) (*S3Object, error) {
+	s.reads.Add(1)
+	time.Sleep(100 * time.Millisecond)
+	return s.memS3Store.GetObject(ctx, bucket, object)
+}
+
+func TestClient_Access_singleflight(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	keyPath := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &slowS3Store{}
+	c := &Client{}
+	WithS3Store(store).(*clientOption).apply(c)
+	WithSingleflight().(*clientOption).apply(c)
+
+	if _, err := c.Create(ctx, &StorageS3CreateRequest{
+		Bucket:    "b",
+		Object:    "o",
+		Key:       KeySchemeLocal + "://" + keyPath,
+		Plaintext: []byte("shared"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	store.reads.Store(0)
+
+	const n = 10
+	results := make([][]byte, n)
+	errs := make([]error, n)
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			results[i], errs[i] = c.Access(ctx, &StorageS3AccessRequest{Bucket: "b", Object: "o"})
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if act, exp := string(results[i]), "shared"; act != exp {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+	}
+
+	if act := store.reads.Load(); act >= n {
+		t.Errorf("expected fewer than %d reads, got %d", n, act)
+	}
+
+	// Each caller owns its result.
+	clear(results[0])
+	if act, exp := string(results[1]), "shared"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
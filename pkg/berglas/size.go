@@ -0,0 +1,69 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/option"
+)
+
+const (
+	// SecretManagerMaxPlaintextSize is the maximum size in bytes of a Secret
+	// Manager secret version payload.
+	SecretManagerMaxPlaintextSize = 64 * 1024
+
+	// DefaultStorageMaxPlaintextSize is the recommended maximum size in bytes
+	// of a Cloud Storage secret. Secrets are encrypted and decrypted in memory,
+	// so the berglas CLI refuses larger values without --force-large. Library
+	// clients have no limit unless one is set with WithMaxStoragePlaintextSize,
+	// but log a warning for larger values.
+	DefaultStorageMaxPlaintextSize = 1024 * 1024
+)
+
+// WithMaxStoragePlaintextSize returns a client option that sets the maximum
+// size in bytes of Cloud Storage secrets that can be created or updated, such
+// as DefaultStorageMaxPlaintextSize. By default, or with a value of zero or
+// less, there is no limit. The Secret Manager limit is imposed by the API and
+// cannot be changed.
+func WithMaxStoragePlaintextSize(n int64) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.storageMaxPlaintextSize = n
+	}}
+}
+
+// checkPlaintextSize returns an error if the given plaintext is larger than
+// max bytes. A max of zero or less means there is no limit, in which case a
+// warning is logged for plaintext over the default limit.
+func checkPlaintextSize(ctx context.Context, plaintext []byte, max int64) error {
+	size := int64(len(plaintext))
+
+	if max <= 0 {
+		if size > DefaultStorageMaxPlaintextSize {
+			logging.FromContext(ctx).WarnContext(ctx, "plaintext exceeds the default size limit",
+				"size", size,
+				"limit", DefaultStorageMaxPlaintextSize)
+		}
+		return nil
+	}
+
+	if size > max {
+		return fmt.Errorf("%w: plaintext is %d bytes, maximum is %d bytes",
+			ErrSecretTooLarge, size, max)
+	}
+	return nil
+}
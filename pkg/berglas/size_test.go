@@ -0,0 +1,119 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/option"
+)
+
+func TestCheckPlaintextSize(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		size     int
+		max      int64
+		tooLarge bool
+	}{
+		{
+			name: "under",
+			size: 10,
+			max:  SecretManagerMaxPlaintextSize,
+		},
+		{
+			name: "exact",
+			size: SecretManagerMaxPlaintextSize,
+			max:  SecretManagerMaxPlaintextSize,
+		},
+		{
+			name:     "over",
+			size:     SecretManagerMaxPlaintextSize + 1,
+			max:      SecretManagerMaxPlaintextSize,
+			tooLarge: true,
+		},
+		{
+			name: "no_limit",
+			size: DefaultStorageMaxPlaintextSize + 1,
+			max:  0,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := logging.WithLogger(context.Background(), logging.TestLogger(t))
+			plaintext := bytes.Repeat([]byte("a"), tc.size)
+
+			err := checkPlaintextSize(ctx, plaintext, tc.max)
+			if act, exp := IsSecretTooLargeErr(err), tc.tooLarge; act != exp {
+				t.Errorf("expected %t to be %t: %v", act, exp, err)
+			}
+		})
+	}
+}
+
+func TestClient_Create_tooLarge(t *testing.T) {
+	t.Parallel()
+
+	ctx := logging.WithLogger(context.Background(), logging.TestLogger(t))
+
+	var client Client
+	WithMaxStoragePlaintextSize(8).(*clientOption).apply(&client)
+
+	if _, err := client.Create(ctx, &StorageCreateRequest{
+		Bucket:    "my-bucket",
+		Object:    "my-object",
+		Key:       "projects/p/locations/l/keyRings/kr/cryptoKeys/ck",
+		Plaintext: []byte("more than eight bytes"),
+	}); !IsSecretTooLargeErr(err) {
+		t.Errorf("expected %q to be %q", err, ErrSecretTooLarge)
+	}
+
+	if _, err := client.Update(ctx, &SecretManagerUpdateRequest{
+		Project:   "my-project",
+		Name:      "my-secret",
+		Plaintext: make([]byte, SecretManagerMaxPlaintextSize+1),
+	}); !IsSecretTooLargeErr(err) {
+		t.Errorf("expected %q to be %q", err, ErrSecretTooLarge)
+	}
+}
+
+func TestNew_noStorageSizeLimit(t *testing.T) {
+	t.Parallel()
+
+	ctx := logging.WithLogger(context.Background(), logging.TestLogger(t))
+
+	client, err := New(ctx, option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := client.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if act := client.storageMaxPlaintextSize; act > 0 {
+		t.Errorf("expected no storage size limit, got %d", act)
+	}
+}
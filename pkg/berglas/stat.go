@@ -0,0 +1,313 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/iterator"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+var (
+	// secretManagerStatPermissions are the permissions checked on a Secret
+	// Manager secret.
+	secretManagerStatPermissions = []string{
+		"secretmanager.secrets.delete",
+		"secretmanager.secrets.get",
+		"secretmanager.secrets.getIamPolicy",
+		"secretmanager.secrets.setIamPolicy",
+		"secretmanager.secrets.update",
+		"secretmanager.versions.access",
+		"secretmanager.versions.add",
+	}
+
+	// storageStatPermissions are the permissions checked on a Cloud Storage
+	// secret object.
+	storageStatPermissions = []string{
+		"storage.objects.create",
+		"storage.objects.delete",
+		"storage.objects.get",
+		"storage.objects.getIamPolicy",
+		"storage.objects.setIamPolicy",
+	}
+
+	// kmsStatPermissions are the permissions checked on the Cloud KMS key of a
+	// Cloud Storage secret.
+	kmsStatPermissions = []string{
+		"cloudkms.cryptoKeyVersions.useToDecrypt",
+		"cloudkms.cryptoKeyVersions.useToEncrypt",
+	}
+)
+
+type statRequest interface {
+	isStatRequest()
+	Validate() error
+}
+
+// StorageStatRequest is used as input to describe a secret in Cloud Storage.
+type StorageStatRequest struct {
+	// Bucket is the name of the bucket where the secret lives.
+	Bucket string
+
+	// Object is the name of the object in Cloud Storage.
+	Object string
+}
+
+func (r *StorageStatRequest) isStatRequest() {}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageStatRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	return v.err()
+}
+
+// SecretManagerStatRequest is used as input to describe a secret in Secret
+// Manager.
+type SecretManagerStatRequest struct {
+	// Project is the ID or number of the project where the secret lives.
+	Project string
+
+	// Name is the name of the secret.
+	Name string
+}
+
+func (r *SecretManagerStatRequest) isStatRequest() {}
+
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerStatRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	v.require("Name", r.Name, "missing secret name")
+	return v.err()
+}
+
+// StatResponse is the response from a stat call.
+type StatResponse struct {
+	// Exists indicates whether the secret exists. If false, the other fields are
+	// unset.
+	Exists bool
+
+	// Secret is the latest version of the secret, without its plaintext. For
+	// Secret Manager, Locations is the replication policy of the secret.
+	Secret *Secret
+
+	// Versions is the number of versions (Secret Manager) or generations (Cloud
+	// Storage) of the secret, including disabled and noncurrent ones.
+	Versions int
+
+	// Permissions are the permissions the caller has on the secret, sorted. For
+	// Cloud Storage, this includes permissions on the KMS key.
+	Permissions []string
+}
+
+// Stat is a top-level package function for describing a secret. For large
+// volumes of secrets, please create a client instead.
+func Stat(ctx context.Context, i statRequest) (*StatResponse, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Stat(ctx, i)
+}
+
+// Stat describes a secret without reading its plaintext: whether it exists,
+// its latest version, how many versions it has, and which permissions the
+// caller has on it. A secret that does not exist is not an error.
+func (c *Client) Stat(ctx context.Context, i statRequest) (*StatResponse, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch t := i.(type) {
+	case *SecretManagerStatRequest:
+		return c.secretManagerStat(ctx, t)
+	case *StorageStatRequest:
+		return c.storageStat(ctx, t)
+	default:
+		return nil, fmt.Errorf("unknown stat type %T", t)
+	}
+}
+
+func (c *Client) secretManagerStat(ctx context.Context, i *SecretManagerStatRequest) (*StatResponse, error) {
+	project := i.Project
+	name := i.Name
+
+	logger := logging.FromContext(ctx).With(
+		"project", project,
+		"name", name,
+	)
+
+	logger.DebugContext(ctx, "stat.start")
+	defer logger.DebugContext(ctx, "stat.finish")
+
+	parent := fmt.Sprintf("projects/%s/secrets/%s", project, name)
+
+	secretResp, err := c.secretManagerClient.GetSecret(ctx, &secretspb.GetSecretRequest{
+		Name: parent,
+	})
+	if err != nil {
+		terr, ok := grpcstatus.FromError(err)
+		if ok && terr.Code() == grpccodes.NotFound {
+			return &StatResponse{Exists: false}, nil
+		}
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+
+	secret := &Secret{
+		Parent:      project,
+		Name:        name,
+		UpdatedAt:   timestampToTime(secretResp.GetCreateTime()),
+		Locations:   secretManagerLocations(secretResp.GetReplication()),
+		ContentType: secretResp.GetAnnotations()[MetadataContentTypeKey],
+	}
+
+	logger.DebugContext(ctx, "listing secret versions")
+
+	var count int
+	it := c.secretManagerClient.ListSecretVersions(ctx, &secretspb.ListSecretVersionsRequest{
+		Parent: parent,
+	})
+	for {
+		resp, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions: %w", err)
+		}
+
+		count++
+		if updatedAt := timestampToTime(resp.GetCreateTime()); secret.Version == "" || updatedAt.After(secret.UpdatedAt) {
+			secret.Version = path.Base(resp.GetName())
+			secret.UpdatedAt = updatedAt
+		}
+	}
+
+	logger.DebugContext(ctx, "testing iam permissions")
+
+	perms, err := c.secretManagerIAM(project, name).TestPermissions(ctx, secretManagerStatPermissions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to test permissions: %w", err)
+	}
+	sort.Strings(perms)
+
+	return &StatResponse{
+		Exists:      true,
+		Secret:      secret,
+		Versions:    count,
+		Permissions: perms,
+	}, nil
+}
+
+func (c *Client) storageStat(ctx context.Context, i *StorageStatRequest) (*StatResponse, error) {
+	bucket := i.Bucket
+	object := i.Object
+
+	logger := logging.FromContext(ctx).With(
+		"bucket", bucket,
+		"object", object,
+	)
+
+	logger.DebugContext(ctx, "stat.start")
+	defer logger.DebugContext(ctx, "stat.finish")
+
+	attrs, err := c.storageClient.
+		Bucket(bucket).
+		Object(object).
+		Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return &StatResponse{Exists: false}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret metadata: %w", err)
+	}
+
+	// Objects that are not berglas secrets are treated as missing.
+	if attrs.Metadata[MetadataIDKey] != "1" {
+		return &StatResponse{Exists: false}, nil
+	}
+
+	secret := secretFromAttrs(bucket, attrs, nil)
+
+	logger.DebugContext(ctx, "listing generations")
+
+	var count int
+	it := c.storageClient.
+		Bucket(bucket).
+		Objects(ctx, &storage.Query{
+			Prefix:   object,
+			Versions: true,
+		})
+	for {
+		obj, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list generations: %w", err)
+		}
+		if obj.Name == object {
+			count++
+		}
+	}
+
+	logger.DebugContext(ctx, "testing iam permissions")
+
+	perms, err := c.storageIAM(bucket, object).TestPermissions(ctx, storageStatPermissions)
+	if err != nil {
+		if !c.isStorageUniformAccessErr(ctx, bucket, err) {
+			return nil, fmt.Errorf("failed to test permissions: %w", err)
+		}
+
+		// Object-level IAM is not available on buckets with uniform bucket-level
+		// access, so use the bucket permissions instead.
+		perms, err = c.storageClient.Bucket(bucket).IAM().TestPermissions(ctx, storageStatPermissions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to test permissions: %w", err)
+		}
+	}
+
+	if secret.KMSKey != "" && isCloudKMSKey(secret.KMSKey) {
+		kmsPerms, err := c.kmsClient.ResourceIAM(secret.KMSKey).TestPermissions(ctx, kmsStatPermissions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to test kms key permissions: %w", err)
+		}
+		perms = append(perms, kmsPerms...)
+	}
+	sort.Strings(perms)
+
+	return &StatResponse{
+		Exists:      true,
+		Secret:      secret,
+		Versions:    count,
+		Permissions: perms,
+	}, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClient_Stat_secretManager(t *testing.T) {
+	testAcc(t)
+
+	t.Run("missing", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name := testProject(t), testName(t)
+
+		resp, err := client.Stat(ctx, &SecretManagerStatRequest{
+			Project: project,
+			Name:    name,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Exists {
+			t.Errorf("expected secret to not exist")
+		}
+	})
+
+	t.Run("exists", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name := testProject(t), testName(t)
+
+		if _, err := client.Create(ctx, &SecretManagerCreateRequest{
+			Project:     project,
+			Name:        name,
+			Plaintext:   []byte("test"),
+			ContentType: "application/json",
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer testSecretManagerCleanup(t, project, name)
+
+		if _, err := client.Update(ctx, &SecretManagerUpdateRequest{
+			Project:   project,
+			Name:      name,
+			Plaintext: []byte("test2"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := client.Stat(ctx, &SecretManagerStatRequest{
+			Project: project,
+			Name:    name,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !resp.Exists {
+			t.Fatalf("expected secret to exist")
+		}
+		if resp.Versions != 2 {
+			t.Errorf("expected %d to be %d", resp.Versions, 2)
+		}
+		if act, exp := resp.Secret.Version, "2"; act != exp {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+		if act, exp := resp.Secret.ContentType, "application/json"; act != exp {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+		if !slices.Contains(resp.Permissions, "secretmanager.versions.access") {
+			t.Errorf("expected %q to include access", resp.Permissions)
+		}
+	})
+}
+
+func TestClient_Stat_storage(t *testing.T) {
+	testAcc(t)
+
+	t.Run("missing", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		bucket, object := testBucket(t), testName(t)
+
+		resp, err := client.Stat(ctx, &StorageStatRequest{
+			Bucket: bucket,
+			Object: object,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Exists {
+			t.Errorf("expected secret to not exist")
+		}
+	})
+
+	t.Run("exists", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		bucket, object, key := testBucket(t), testName(t), testKey(t)
+
+		if _, err := client.Create(ctx, &StorageCreateRequest{
+			Bucket:      bucket,
+			Object:      object,
+			Key:         key,
+			Plaintext:   []byte("test"),
+			ContentType: "application/x-pem-file",
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer testStorageCleanup(t, bucket, object)
+
+		resp, err := client.Stat(ctx, &StorageStatRequest{
+			Bucket: bucket,
+			Object: object,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !resp.Exists {
+			t.Fatalf("expected secret to exist")
+		}
+		if resp.Versions != 1 {
+			t.Errorf("expected %d to be %d", resp.Versions, 1)
+		}
+		if act, exp := resp.Secret.KMSKey, kmsKeyTrimVersion(key); act != exp {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+		if act, exp := resp.Secret.ContentType, "application/x-pem-file"; act != exp {
+			t.Errorf("expected %q to be %q", act, exp)
+		}
+		if !slices.Contains(resp.Permissions, "cloudkms.cryptoKeyVersions.useToDecrypt") {
+			t.Errorf("expected %q to include decrypt", resp.Permissions)
+		}
+	})
+}
@@ -0,0 +1,234 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Stats counts the Google Cloud API calls made by clients created with
+// WithStats. It is safe for concurrent use, and the zero value is ready to
+// use.
+type Stats struct {
+	lock     sync.Mutex
+	services map[string]*ServiceStats
+}
+
+// ServiceStats summarizes the calls made to a single Google Cloud API.
+type ServiceStats struct {
+	// Service is the API, such as "secretmanager", "kms", or "storage".
+	Service string
+
+	// Calls is the number of requests sent, including retries.
+	Calls int64
+
+	// Errors is the number of requests that failed.
+	Errors int64
+
+	// Retries is the number of requests that repeated an earlier failed
+	// request. Cloud Storage reports retries directly. For the other APIs,
+	// requests that failed with a status the client libraries retry, such as
+	// UNAVAILABLE, are counted instead.
+	Retries int64
+
+	// Latency is the total time spent waiting for responses.
+	Latency time.Duration
+
+	// BytesSent and BytesReceived are the sizes of the request and response
+	// bodies, or of the protocol buffer messages for gRPC APIs.
+	BytesSent, BytesReceived int64
+}
+
+// WithStats returns a client option that records the Google Cloud API calls
+// made by the client in s. Calls to S3-compatible stores and AWS KMS are not
+// recorded.
+func WithStats(s *Stats) option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.stats = s
+	}}
+}
+
+// Services returns a copy of the statistics for each API that was called,
+// sorted by service.
+func (s *Stats) Services() []*ServiceStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := make([]*ServiceStats, 0, len(s.services))
+	for _, ss := range s.services {
+		cp := *ss
+		result = append(result, &cp)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Service < result[j].Service
+	})
+	return result
+}
+
+// record adds a single call to the statistics for the service.
+func (s *Stats) record(service string, latency time.Duration, sent, received int64, failed, retry bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.services == nil {
+		s.services = make(map[string]*ServiceStats)
+	}
+	ss, ok := s.services[service]
+	if !ok {
+		ss = &ServiceStats{Service: service}
+		s.services[service] = ss
+	}
+
+	ss.Calls++
+	ss.Latency += latency
+	ss.BytesSent += sent
+	ss.BytesReceived += received
+	if failed {
+		ss.Errors++
+	}
+	if retry {
+		ss.Retries++
+	}
+}
+
+// grpcOption returns a client option that records the unary calls made over
+// the gRPC connection.
+func (s *Stats) grpcOption(service string) option.ClientOption {
+	return option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(
+		func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+			invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			start := time.Now()
+			err := invoker(ctx, method, req, reply, cc, opts...)
+
+			var sent, received int64
+			if m, ok := req.(proto.Message); ok {
+				sent = int64(proto.Size(m))
+			}
+			if m, ok := reply.(proto.Message); ok && err == nil {
+				received = int64(proto.Size(m))
+			}
+
+			var retryable bool
+			switch grpcstatus.Code(err) {
+			case grpccodes.Unavailable, grpccodes.ResourceExhausted, grpccodes.Aborted:
+				retryable = true
+			}
+
+			s.record(service, time.Since(start), sent, received, err != nil, retryable)
+			return err
+		}))
+}
+
+// storageOption returns a client option with an authenticated HTTP client for
+// Cloud Storage that records each request. The Cloud Storage clients do not
+// accept a transport wrapper, so the HTTP client is built here with the same
+// options and scopes they would use.
+func (s *Stats) storageOption(ctx context.Context, opts []option.ClientOption) (option.ClientOption, error) {
+	opts = append(opts, option.WithScopes(storage.ScopeFullControl, "https://www.googleapis.com/auth/cloud-platform"))
+	if os.Getenv("STORAGE_EMULATOR_HOST") != "" {
+		opts = append(opts, option.WithoutAuthentication())
+	}
+
+	hc, _, err := htransport.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Want passthrough
+	}
+	hc.Transport = &statsTransport{stats: s, service: string(serviceStorage), next: hc.Transport}
+	return option.WithHTTPClient(hc), nil
+}
+
+// statsTransport is an http.RoundTripper that records each request.
+type statsTransport struct {
+	stats   *Stats
+	service string
+	next    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper. The response is recorded once its
+// body is closed, so the latency and size include reading the body.
+func (t *statsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+
+	retry := attemptCount(req.Header.Get("X-Goog-Api-Client")) > 1
+
+	var sent int64
+	if req.ContentLength > 0 {
+		sent = req.ContentLength
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.stats.record(t.service, time.Since(start), sent, 0, true, retry)
+		return nil, err //nolint:wrapcheck // Want passthrough
+	}
+
+	resp.Body = &statsBody{
+		ReadCloser: resp.Body,
+		done: func(received int64) {
+			t.stats.record(t.service, time.Since(start), sent, received, resp.StatusCode >= 400, retry)
+		},
+	}
+	return resp, nil
+}
+
+// statsBody counts the bytes read from a response body and reports them once
+// when the body is closed.
+type statsBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(int64)
+}
+
+// Read implements io.Reader.
+func (b *statsBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err //nolint:wrapcheck // Want passthrough
+}
+
+// Close implements io.Closer.
+func (b *statsBody) Close() error {
+	b.once.Do(func() { b.done(b.n) })
+	return b.ReadCloser.Close() //nolint:wrapcheck // Want passthrough
+}
+
+// attemptCount returns the attempt number the Cloud Storage client records in
+// the x-goog-api-client header of each request, or 0 if there is none.
+func attemptCount(header string) int {
+	for _, field := range strings.Fields(header) {
+		if v, ok := strings.CutPrefix(field, "gccl-attempt-count/"); ok {
+			n, _ := strconv.Atoi(v)
+			return n
+		}
+	}
+	return 0
+}
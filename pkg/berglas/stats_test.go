@@ -0,0 +1,111 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAttemptCount(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		header string
+		exp    int
+	}{
+		{"empty", "", 0},
+		{"first", "gl-go/1.22 gccl/1.50.0 gccl-invocation-id/abc gccl-attempt-count/1", 1},
+		{"retry", "gccl-invocation-id/abc gccl-attempt-count/12", 12},
+		{"invalid", "gccl-attempt-count/x", 0},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if act, exp := attemptCount(tc.header), tc.exp; act != exp {
+				t.Errorf("expected %d to be %d", act, exp)
+			}
+		})
+	}
+}
+
+func TestStatsTransport(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		_, _ = io.WriteString(w, "hello")
+	}))
+	t.Cleanup(srv.Close)
+
+	var stats Stats
+	client := &http.Client{
+		Transport: &statsTransport{stats: &stats, service: "storage", next: http.DefaultTransport},
+	}
+
+	for _, pth := range []string{"/ok", "/missing"} {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+pth, strings.NewReader("abc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pth == "/missing" {
+			req.Header.Set("X-Goog-Api-Client", "gccl-attempt-count/2")
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(resp.Body); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	services := stats.Services()
+	if act, exp := len(services), 1; act != exp {
+		t.Fatalf("expected %d to be %d", act, exp)
+	}
+
+	s := services[0]
+	if act, exp := s.Service, "storage"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+	if act, exp := s.Calls, int64(2); act != exp {
+		t.Errorf("expected %d calls to be %d", act, exp)
+	}
+	if act, exp := s.Errors, int64(1); act != exp {
+		t.Errorf("expected %d errors to be %d", act, exp)
+	}
+	if act, exp := s.Retries, int64(1); act != exp {
+		t.Errorf("expected %d retries to be %d", act, exp)
+	}
+	if act, exp := s.BytesSent, int64(6); act != exp {
+		t.Errorf("expected %d bytes sent to be %d", act, exp)
+	}
+	if act, exp := s.BytesReceived, int64(10); act != exp {
+		t.Errorf("expected %d bytes received to be %d", act, exp)
+	}
+}
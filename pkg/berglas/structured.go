@@ -0,0 +1,326 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/internal/envexport"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+)
+
+// StructuredFormat is the serialization of a StructuredSecret.
+type StructuredFormat string
+
+const (
+	// StructuredFormatJSON stores the keys as a JSON object of strings. It is
+	// the format of new and empty secrets.
+	StructuredFormatJSON StructuredFormat = "json"
+
+	// StructuredFormatDotenv stores the keys as NAME=VALUE lines.
+	StructuredFormatDotenv StructuredFormat = "dotenv"
+)
+
+// structuredDotenvKeyRegexp matches keys allowed in a dotenv structured secret.
+var structuredDotenvKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// StructuredSecret is a secret that holds several named values, stored
+// together in one Secret Manager version or Cloud Storage object so that they
+// are always read and written atomically. Individual values of JSON secrets can
+// be extracted when resolving with the "jsonkey" reference parameter; dotenv
+// secrets must be read with GetKey.
+type StructuredSecret struct {
+	// Format is the serialization used by Marshal.
+	Format StructuredFormat
+
+	// Fields are the values by key.
+	Fields map[string][]byte
+}
+
+// ParseStructuredSecret parses a secret value as a StructuredSecret. Values
+// that start with "{" are parsed as a JSON object whose values are all strings,
+// and other values as dotenv. An empty value is an empty JSON secret.
+func ParseStructuredSecret(b []byte) (*StructuredSecret, error) {
+	s := &StructuredSecret{
+		Format: StructuredFormatJSON,
+		Fields: make(map[string][]byte),
+	}
+
+	trimmed := bytes.TrimSpace(b)
+	switch {
+	case len(trimmed) == 0:
+		return s, nil
+	case trimmed[0] == '{':
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &m); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON structured secret: %w", err)
+		}
+		for k, raw := range m {
+			var v string
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return nil, fmt.Errorf("failed to parse JSON structured secret: key %q is not a string", k)
+			}
+			s.Fields[k] = []byte(v)
+		}
+		return s, nil
+	default:
+		vars, err := envexport.ReadDotenv(bytes.NewReader(trimmed))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dotenv structured secret: %w", err)
+		}
+		s.Format = StructuredFormatDotenv
+		for _, v := range vars {
+			s.Fields[v.Name] = []byte(v.Value)
+		}
+		return s, nil
+	}
+}
+
+// Keys returns the keys in sorted order.
+func (s *StructuredSecret) Keys() []string {
+	keys := make([]string, 0, len(s.Fields))
+	for k := range s.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the value of the key, or ErrKeyDoesNotExist.
+func (s *StructuredSecret) Get(key string) ([]byte, error) {
+	v, ok := s.Fields[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrKeyDoesNotExist, key)
+	}
+	return v, nil
+}
+
+// Set sets the value of the key. Keys of dotenv secrets must be valid
+// environment variable names.
+func (s *StructuredSecret) Set(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("missing key")
+	}
+	if s.Format == StructuredFormatDotenv && !structuredDotenvKeyRegexp.MatchString(key) {
+		return fmt.Errorf("invalid key %q: dotenv keys must be valid environment variable names", key)
+	}
+	if s.Fields == nil {
+		s.Fields = make(map[string][]byte)
+	}
+	s.Fields[key] = value
+	return nil
+}
+
+// Delete removes the key, or returns ErrKeyDoesNotExist.
+func (s *StructuredSecret) Delete(key string) error {
+	if _, ok := s.Fields[key]; !ok {
+		return fmt.Errorf("%w: %q", ErrKeyDoesNotExist, key)
+	}
+	delete(s.Fields, key)
+	return nil
+}
+
+// Marshal serializes the secret in its Format, with keys in sorted order.
+// Values must be valid UTF-8.
+func (s *StructuredSecret) Marshal() ([]byte, error) {
+	keys := s.Keys()
+	for _, k := range keys {
+		if !utf8.Valid(s.Fields[k]) {
+			return nil, fmt.Errorf("value of key %q is not valid UTF-8", k)
+		}
+	}
+
+	switch s.Format {
+	case StructuredFormatJSON, "":
+		m := make(map[string]string, len(keys))
+		for _, k := range keys {
+			m[k] = string(s.Fields[k])
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal structured secret: %w", err)
+		}
+		return b, nil
+	case StructuredFormatDotenv:
+		vars := make([]*envexport.Var, 0, len(keys))
+		for _, k := range keys {
+			vars = append(vars, &envexport.Var{Name: k, Value: string(s.Fields[k])})
+		}
+		var buf bytes.Buffer
+		if err := envexport.WriteDotenv(&buf, vars); err != nil {
+			return nil, fmt.Errorf("failed to marshal structured secret: %w", err)
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown structured secret format %q", s.Format)
+	}
+}
+
+// ReadStructured reads the secret at the reference and parses it as a
+// StructuredSecret. Secret Manager, Cloud Storage, and S3 references are
+// supported.
+func (c *Client) ReadStructured(ctx context.Context, ref *Reference) (*StructuredSecret, error) {
+	s, _, err := c.readStructured(ctx, ref)
+	return s, err
+}
+
+// UpdateStructured reads the secret at the reference, calls fn to change it,
+// and writes the result as a new version of the secret. Cloud Storage writes,
+// and S3 writes to a store that implements S3ConditionalStore and reports
+// ETags, fail with ErrSecretModified if the secret changed since it was read.
+// Secret Manager and other S3 stores cannot write conditionally, so concurrent
+// updates of those secrets are last writer wins and may drop each other's
+// changes. The reference must not be pinned to a version or generation.
+func (c *Client) UpdateStructured(ctx context.Context, ref *Reference, fn func(*StructuredSecret) error) (*Secret, error) {
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+	if ref == nil {
+		return nil, fmt.Errorf("missing reference")
+	}
+	if ref.Version() != "" || ref.Generation() != 0 {
+		return nil, fmt.Errorf("cannot update pinned reference %s", ref)
+	}
+
+	logger := logging.FromContext(ctx).With(
+		"reference", ref.String(),
+	)
+
+	logger.DebugContext(ctx, "updatestructured.start")
+	defer logger.DebugContext(ctx, "updatestructured.finish")
+
+	s, current, err := c.readStructured(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fn(s); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := s.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	var req updateRequest
+	switch ref.Type() {
+	case ReferenceTypeSecretManager:
+		req = &SecretManagerUpdateRequest{
+			Project:   ref.Project(),
+			Name:      ref.Name(),
+			Plaintext: plaintext,
+		}
+	case ReferenceTypeStorage:
+		req = &StorageUpdateRequest{
+			Bucket:         ref.Bucket(),
+			Object:         ref.Object(),
+			Generation:     current.Generation,
+			Metageneration: current.Metageneration,
+			Plaintext:      plaintext,
+		}
+	case ReferenceTypeS3:
+		s3Req := &StorageS3UpdateRequest{
+			Bucket:    ref.Bucket(),
+			Object:    ref.Object(),
+			Plaintext: plaintext,
+		}
+		// Stores that report ETags but cannot write conditionally reject
+		// updates with an ETag, so they are last writer wins.
+		if _, ok := c.s3Store.(S3ConditionalStore); ok {
+			s3Req.ETag = current.ETag
+		}
+		req = s3Req
+	}
+	return c.Update(ctx, req)
+}
+
+// GetKey returns the value of a key in the structured secret at the reference,
+// or ErrKeyDoesNotExist.
+func (c *Client) GetKey(ctx context.Context, ref *Reference, key string) ([]byte, error) {
+	s, err := c.ReadStructured(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(key)
+}
+
+// SetKey sets the value of a key in the structured secret at the reference,
+// writing a new version of the secret.
+func (c *Client) SetKey(ctx context.Context, ref *Reference, key string, value []byte) (*Secret, error) {
+	return c.UpdateStructured(ctx, ref, func(s *StructuredSecret) error {
+		return s.Set(key, value)
+	})
+}
+
+// DeleteKey removes a key from the structured secret at the reference, writing
+// a new version of the secret. It returns ErrKeyDoesNotExist if the key is not
+// set.
+func (c *Client) DeleteKey(ctx context.Context, ref *Reference, key string) (*Secret, error) {
+	return c.UpdateStructured(ctx, ref, func(s *StructuredSecret) error {
+		return s.Delete(key)
+	})
+}
+
+// readStructured reads and parses the secret at the reference, also returning
+// the secret read.
+func (c *Client) readStructured(ctx context.Context, ref *Reference) (*StructuredSecret, *Secret, error) {
+	if ref == nil {
+		return nil, nil, fmt.Errorf("missing reference")
+	}
+
+	var req readRequest
+	switch t := ref.Type(); t {
+	case ReferenceTypeSecretManager:
+		req = &SecretManagerReadRequest{
+			Project: ref.Project(),
+			Name:    ref.Name(),
+			Version: ref.Version(),
+		}
+	case ReferenceTypeStorage:
+		if ref.IsDirectory() {
+			return nil, nil, fmt.Errorf("directory reference %s cannot be a structured secret", ref)
+		}
+		req = &StorageReadRequest{
+			Bucket:     ref.Bucket(),
+			Object:     ref.Object(),
+			Generation: ref.Generation(),
+		}
+	case ReferenceTypeS3:
+		req = &StorageS3ReadRequest{
+			Bucket: ref.Bucket(),
+			Object: ref.Object(),
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown reference type %d", t)
+	}
+
+	secret, err := c.Read(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s, err := ParseStructuredSecret(secret.Plaintext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	return s, secret, nil
+}
@@ -0,0 +1,290 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseStructuredSecret(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		i      string
+		format StructuredFormat
+		fields map[string][]byte
+		err    bool
+	}{
+		{
+			name:   "empty",
+			i:      "",
+			format: StructuredFormatJSON,
+			fields: map[string][]byte{},
+		},
+		{
+			name:   "json",
+			i:      ` {"user":"admin","pass":"s3cr3t"}`,
+			format: StructuredFormatJSON,
+			fields: map[string][]byte{"user": []byte("admin"), "pass": []byte("s3cr3t")},
+		},
+		{
+			name: "json_non_string",
+			i:    `{"port":5432}`,
+			err:  true,
+		},
+		{
+			name: "json_invalid",
+			i:    `{"user":`,
+			err:  true,
+		},
+		{
+			name:   "dotenv",
+			i:      "USER=admin\nPASS=\"a b\"\n",
+			format: StructuredFormatDotenv,
+			fields: map[string][]byte{"USER": []byte("admin"), "PASS": []byte("a b")},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := ParseStructuredSecret([]byte(tc.i))
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %t, got %v", tc.err, err)
+			}
+			if tc.err {
+				return
+			}
+			if act, exp := s.Format, tc.format; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+			if act, exp := s.Fields, tc.fields; !reflect.DeepEqual(act, exp) {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
+
+func TestStructuredSecret_Marshal(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		s    *StructuredSecret
+		exp  string
+		err  bool
+	}{
+		{
+			name: "json",
+			s: &StructuredSecret{
+				Format: StructuredFormatJSON,
+				Fields: map[string][]byte{"b": []byte("2"), "a": []byte("1")},
+			},
+			exp: `{"a":"1","b":"2"}`,
+		},
+		{
+			name: "dotenv",
+			s: &StructuredSecret{
+				Format: StructuredFormatDotenv,
+				Fields: map[string][]byte{"B": []byte("2"), "A": []byte("1")},
+			},
+			exp: "A=1\nB=2\n",
+		},
+		{
+			name: "invalid_utf8",
+			s: &StructuredSecret{
+				Format: StructuredFormatJSON,
+				Fields: map[string][]byte{"a": {0xff}},
+			},
+			err: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := tc.s.Marshal()
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %t, got %v", tc.err, err)
+			}
+			if act, exp := string(b), tc.exp; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
+
+func TestStructuredSecret_Set(t *testing.T) {
+	t.Parallel()
+
+	s := &StructuredSecret{Format: StructuredFormatDotenv}
+	if err := s.Set("not-an-env-name", []byte("x")); err == nil {
+		t.Errorf("expected error for invalid dotenv key")
+	}
+	if err := s.Set("VALID", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("MISSING"); !errors.Is(err, ErrKeyDoesNotExist) {
+		t.Errorf("expected %v to be %v", err, ErrKeyDoesNotExist)
+	}
+}
+
+func TestClient_Structured(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	keyPath := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := KeySchemeLocal + "://" + keyPath
+
+	c := &Client{}
+	WithS3Store(&memS3Store{}).(*clientOption).apply(c)
+
+	if _, err := c.Create(ctx, &StorageS3CreateRequest{
+		Bucket:    "b",
+		Object:    "o",
+		Key:       key,
+		Plaintext: []byte(`{"user":"admin"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := ParseReference("s3://b/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.SetKey(ctx, ref, "pass", []byte("s3cr3t")); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := c.GetKey(ctx, ref, "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := string(v), "s3cr3t"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	if _, err := c.DeleteKey(ctx, ref, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetKey(ctx, ref, "user"); !errors.Is(err, ErrKeyDoesNotExist) {
+		t.Errorf("expected %v to be %v", err, ErrKeyDoesNotExist)
+	}
+
+	s, err := c.ReadStructured(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := s.Keys(), []string{"pass"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	// A concurrent change between the read and the write is not overwritten.
+	if _, err := c.UpdateStructured(ctx, ref, func(s *StructuredSecret) error {
+		if _, err := c.SetKey(ctx, ref, "other", []byte("concurrent")); err != nil {
+			t.Fatal(err)
+		}
+		return s.Set("mine", []byte("lost"))
+	}); !IsSecretModifiedErr(err) {
+		t.Errorf("expected %v to be %v", err, ErrSecretModified)
+	}
+	if v, err := c.GetKey(ctx, ref, "other"); err != nil || string(v) != "concurrent" {
+		t.Errorf("expected concurrent key to be kept, got %q (%v)", v, err)
+	}
+
+	pinned, err := ParseReference("sm://p/n#1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SetKey(ctx, pinned, "x", []byte("y")); err == nil {
+		t.Errorf("expected error updating pinned reference")
+	}
+}
+
+// plainS3Store is an S3Store that reports ETags but cannot write
+// conditionally.
+type plainS3Store struct {
+	store *memS3Store
+}
+
+func (s *plainS3Store) GetObject(ctx context.Context, bucket, object string) (*S3Object, error) {
+	return s.store.GetObject(ctx, bucket, object)
+}
+
+func (s *plainS3Store) PutObject(ctx context.Context, bucket, object string, o *S3Object, ifNotExists bool) error {
+	return s.store.PutObject(ctx, bucket, object, o, ifNotExists)
+}
+
+func TestClient_Structured_unconditionalS3(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	keyPath := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{0x42}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := KeySchemeLocal + "://" + keyPath
+
+	store := &plainS3Store{store: &memS3Store{}}
+	c := &Client{}
+	WithS3Store(store).(*clientOption).apply(c)
+
+	if _, err := c.Create(ctx, &StorageS3CreateRequest{
+		Bucket:    "b",
+		Object:    "o",
+		Key:       key,
+		Plaintext: []byte(`{"user":"admin"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if o, err := store.GetObject(ctx, "b", "o"); err != nil || o.ETag == "" {
+		t.Fatalf("expected store to report an ETag, got %v (%v)", o, err)
+	}
+
+	ref, err := ParseReference("s3://b/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SetKey(ctx, ref, "pass", []byte("s3cr3t")); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := c.GetKey(ctx, ref, "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := string(v), "s3cr3t"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// applyTransforms applies the transforms of the reference to the plaintext,
+// in the order: decode the encoding, extract the JSON key, then trim
+// whitespace. The given plaintext is wiped if it is replaced.
+func (c *Client) applyTransforms(ref *Reference, plaintext []byte) ([]byte, error) {
+	if encoding := ref.Encoding(); encoding != "" {
+		decoded, err := decodeTransform(encoding, plaintext)
+		if err != nil {
+			c.wipe(plaintext)
+			return nil, fmt.Errorf("failed to decode secret as %s: %w", encoding, err)
+		}
+		c.wipe(plaintext)
+		plaintext = decoded
+	}
+
+	if key := ref.JSONKey(); key != "" {
+		value, err := jsonKeyTransform(key, plaintext)
+		if err != nil {
+			c.wipe(plaintext)
+			return nil, err
+		}
+		c.wipe(plaintext)
+		plaintext = value
+	}
+
+	if ref.Trim() {
+		// Trimming returns a subslice, so nothing needs to be wiped.
+		plaintext = bytes.TrimSpace(plaintext)
+	}
+
+	return plaintext, nil
+}
+
+// decodeTransform decodes b using the given encoding. Surrounding whitespace,
+// such as a trailing newline, is ignored.
+func decodeTransform(encoding string, b []byte) ([]byte, error) {
+	b = bytes.TrimSpace(b)
+
+	switch encoding {
+	case "base64":
+		out := make([]byte, base64.StdEncoding.DecodedLen(len(b)))
+		n, err := base64.StdEncoding.Decode(out, b)
+		return out[:n], err
+	case "base64url":
+		out := make([]byte, base64.URLEncoding.DecodedLen(len(b)))
+		n, err := base64.URLEncoding.Decode(out, b)
+		return out[:n], err
+	case "hex":
+		out := make([]byte, hex.DecodedLen(len(b)))
+		n, err := hex.Decode(out, b)
+		return out[:n], err
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
+
+// jsonKeyTransform extracts the top-level key from the JSON object in b.
+// String values are returned without quotes. Other values are returned as
+// JSON.
+func jsonKeyTransform(key string, b []byte) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, fmt.Errorf("failed to parse secret as a JSON object: %w", err)
+	}
+
+	raw, ok := obj[key]
+	if !ok {
+		return nil, fmt.Errorf("secret does not contain JSON key %q", key)
+	}
+
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return []byte(str), nil
+	}
+	return []byte(raw), nil
+}
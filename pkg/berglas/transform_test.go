@@ -0,0 +1,121 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"testing"
+)
+
+func TestClient_applyTransforms(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		ref       string
+		plaintext string
+		exp       string
+		err       bool
+	}{
+		{
+			name:      "none",
+			ref:       "sm://p/s",
+			plaintext: " abc\n",
+			exp:       " abc\n",
+		},
+		{
+			name:      "trim",
+			ref:       "sm://p/s?trim=true",
+			plaintext: " abc\n",
+			exp:       "abc",
+		},
+		{
+			name:      "base64",
+			ref:       "sm://p/s?encoding=base64",
+			plaintext: "aGVsbG8=\n",
+			exp:       "hello",
+		},
+		{
+			name:      "base64url",
+			ref:       "sm://p/s?encoding=base64url",
+			plaintext: "_-8=",
+			exp:       "\xff\xef",
+		},
+		{
+			name:      "hex",
+			ref:       "sm://p/s?encoding=hex",
+			plaintext: "68656c6c6f",
+			exp:       "hello",
+		},
+		{
+			name:      "base64_invalid",
+			ref:       "sm://p/s?encoding=base64",
+			plaintext: "not base64!",
+			err:       true,
+		},
+		{
+			name:      "jsonkey_string",
+			ref:       "sm://p/s?jsonkey=password",
+			plaintext: `{"username": "admin", "password": "hunter2"}`,
+			exp:       "hunter2",
+		},
+		{
+			name:      "jsonkey_object",
+			ref:       "sm://p/s?jsonkey=nested",
+			plaintext: `{"nested": {"a": 1}}`,
+			exp:       `{"a": 1}`,
+		},
+		{
+			name:      "jsonkey_missing",
+			ref:       "sm://p/s?jsonkey=password",
+			plaintext: `{"username": "admin"}`,
+			err:       true,
+		},
+		{
+			name:      "jsonkey_not_json",
+			ref:       "sm://p/s?jsonkey=password",
+			plaintext: "hunter2",
+			err:       true,
+		},
+		{
+			name:      "all",
+			ref:       "sm://p/s?encoding=base64&jsonkey=password&trim=true",
+			plaintext: "eyJwYXNzd29yZCI6ICIgaHVudGVyMlxuIn0=", // {"password": " hunter2\n"}
+			exp:       "hunter2",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ref, err := ParseReference(tc.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var client Client
+			act, err := client.applyTransforms(ref, []byte(tc.plaintext))
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+
+			if string(act) != tc.exp {
+				t.Errorf("expected %q to be %q", act, tc.exp)
+			}
+		})
+	}
+}
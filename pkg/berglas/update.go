@@ -18,17 +18,24 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/iam"
 	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/iterator"
 	grpccodes "google.golang.org/grpc/codes"
 	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type updateRequest interface {
 	isUpdateRequest()
+	Validate() error
 }
 
 // StorageUpdateRequest is used as input to update a secret from Cloud Storage
@@ -55,10 +62,26 @@ type StorageUpdateRequest struct {
 	// CreateIfMissing indicates that the updater should create a secret with the
 	// given parameters if one does not already exist.
 	CreateIfMissing bool
+
+	// ExpiresAt, if set, is the time after which the secret is considered
+	// expired and may be deleted by Prune. If unset, the expiration of an
+	// existing secret is preserved.
+	ExpiresAt time.Time
 }
 
 func (r *StorageUpdateRequest) isUpdateRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *StorageUpdateRequest) Validate() error {
+	var v validator
+	v.require("Bucket", r.Bucket, "missing bucket name")
+	v.require("Object", r.Object, "missing object name")
+	v.kmsKey("Key", r.Key)
+	v.expiresAt("ExpiresAt", r.ExpiresAt)
+	return v.err()
+}
+
 // UpdateRequest is an alias for StorageUpdateRequest for
 // backwards-compatibility. New clients should use StorageUpdateRequest.
 type UpdateRequest = StorageUpdateRequest
@@ -71,9 +94,23 @@ type SecretManagerUpdateRequest struct {
 	// Name is the name of the secret to update.
 	Name string
 
-	// Plaintext is the plaintext to store.
+	// Plaintext is the plaintext to store. This may be nil when only changing
+	// Locations on an existing secret.
 	Plaintext []byte
 
+	// Locations is an array indicating the canonical IDs (e.g. "us-east1") of
+	// the locations to the replicate data at. If nil, the replication policy of
+	// an existing secret is left unchanged and new secrets use automatic
+	// replication.
+	//
+	// Secret Manager does not allow changing the replication policy of an
+	// existing secret. If the given locations differ from those of the existing
+	// secret, the secret is re-created with the new replication policy and all
+	// enabled versions and IAM bindings are copied over. Version numbers are not
+	// preserved. Secrets that use customer-managed encryption keys cannot be
+	// re-created this way.
+	Locations []string
+
 	// CreateIfMissing indicates that the updater should create a secret with the
 	// given parameters if one does not already exist.
 	CreateIfMissing bool
@@ -81,10 +118,22 @@ type SecretManagerUpdateRequest struct {
 
 func (r *SecretManagerUpdateRequest) isUpdateRequest() {}
 
+// Validate checks the request for missing or malformed fields without making
+// any network calls.
+func (r *SecretManagerUpdateRequest) Validate() error {
+	var v validator
+	v.require("Project", r.Project, "missing project")
+	v.require("Name", r.Name, "missing secret name")
+	if r.Plaintext == nil && r.Locations == nil {
+		v.addf("Plaintext", "missing plaintext")
+	}
+	return v.err()
+}
+
 // Update is a top-level package function for updating a secret. For large
 // volumes of secrets, please update a client instead.
 func Update(ctx context.Context, i updateRequest) (*Secret, error) {
-	client, err := New(ctx)
+	client, err := DefaultClient(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -93,17 +142,47 @@ func Update(ctx context.Context, i updateRequest) (*Secret, error) {
 
 // Update updates a secret. When given a SecretManagerUpdateRequest, this
 // updates a secret in Secret Manager. When given a StorageUpdateRequest, this
-// updates a secret stored in Cloud Storage encrypted with Cloud KMS.
+// updates a secret stored in Cloud Storage encrypted with Cloud KMS. When given
+// a StorageS3UpdateRequest, this updates a secret stored in the client's
+// S3-compatible store.
 func (c *Client) Update(ctx context.Context, i updateRequest) (*Secret, error) {
 	if i == nil {
 		return nil, fmt.Errorf("missing request")
 	}
 
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
 	switch t := i.(type) {
 	case *SecretManagerUpdateRequest:
+		if err := c.checkNaming(t.Name); err != nil {
+			return nil, secretManagerError(err, t.Project, t.Name)
+		}
+		if err := checkPlaintextSize(ctx, t.Plaintext, SecretManagerMaxPlaintextSize); err != nil {
+			return nil, err
+		}
 		return c.secretManagerUpdate(ctx, t)
 	case *StorageUpdateRequest:
+		if err := c.checkNaming(t.Object); err != nil {
+			return nil, storageError(err, t.Bucket, t.Object)
+		}
+		if err := checkPlaintextSize(ctx, t.Plaintext, c.storageMaxPlaintextSize); err != nil {
+			return nil, err
+		}
 		return c.storageUpdate(ctx, t)
+	case *StorageS3UpdateRequest:
+		if err := c.checkNaming(t.Object); err != nil {
+			return nil, s3Error(err, t.Bucket, t.Object)
+		}
+		if err := checkPlaintextSize(ctx, t.Plaintext, c.storageMaxPlaintextSize); err != nil {
+			return nil, err
+		}
+		return c.s3Update(ctx, t)
 	default:
 		return nil, fmt.Errorf("unknown update type %T", t)
 	}
@@ -111,25 +190,22 @@ func (c *Client) Update(ctx context.Context, i updateRequest) (*Secret, error) {
 
 func (c *Client) secretManagerUpdate(ctx context.Context, i *SecretManagerUpdateRequest) (*Secret, error) {
 	project := i.Project
-	if project == "" {
-		return nil, fmt.Errorf("missing project")
-	}
-
 	name := i.Name
-	if name == "" {
-		return nil, fmt.Errorf("missing secret name")
+	// Sort a copy so the caller's request, which may be shared by concurrent
+	// updates, is not modified.
+	locations := slices.Clone(i.Locations)
+	if locations != nil {
+		sort.Strings(locations)
 	}
 
 	plaintext := i.Plaintext
-	if plaintext == nil {
-		return nil, fmt.Errorf("missing plaintext")
-	}
 
 	createIfMissing := i.CreateIfMissing
 
 	logger := logging.FromContext(ctx).With(
 		"project", project,
 		"name", name,
+		"locations", locations,
 		"create_if_missing", createIfMissing,
 	)
 
@@ -150,7 +226,11 @@ func (c *Client) secretManagerUpdate(ctx context.Context, i *SecretManagerUpdate
 		logger.DebugContext(ctx, "secret does not exist")
 
 		if !createIfMissing {
-			return nil, errSecretDoesNotExist
+			return nil, secretManagerError(ErrSecretDoesNotExist, project, name)
+		}
+
+		if plaintext == nil {
+			return nil, fmt.Errorf("missing plaintext")
 		}
 
 		logger.DebugContext(ctx, "creating secret")
@@ -159,11 +239,7 @@ func (c *Client) secretManagerUpdate(ctx context.Context, i *SecretManagerUpdate
 			Parent:   fmt.Sprintf("projects/%s", project),
 			SecretId: name,
 			Secret: &secretspb.Secret{
-				Replication: &secretspb.Replication{
-					Replication: &secretspb.Replication_Automatic_{
-						Automatic: &secretspb.Replication_Automatic{},
-					},
-				},
+				Replication: secretManagerReplication(locations),
 			},
 		})
 		if err != nil {
@@ -172,6 +248,22 @@ func (c *Client) secretManagerUpdate(ctx context.Context, i *SecretManagerUpdate
 				return nil, fmt.Errorf("failed to create secret: %w", err)
 			}
 		}
+	} else if locations != nil && !slices.Equal(locations, secretManagerLocations(secretResp.Replication)) {
+		logger.DebugContext(ctx, "replication locations changed, re-creating secret")
+
+		secretResp, err = c.secretManagerRehome(ctx, secretResp, locations)
+		if err != nil {
+			return nil, fmt.Errorf("failed to change secret locations: %w", err)
+		}
+	}
+
+	if plaintext == nil {
+		logger.DebugContext(ctx, "no plaintext given, reading latest version")
+
+		return c.secretManagerRead(ctx, &SecretManagerReadRequest{
+			Project: project,
+			Name:    name,
+		})
 	}
 
 	logger.DebugContext(ctx, "creating secret version")
@@ -192,27 +284,242 @@ func (c *Client) secretManagerUpdate(ctx context.Context, i *SecretManagerUpdate
 		Version:   path.Base(versionResp.Name),
 		Plaintext: plaintext,
 		UpdatedAt: timestampToTime(versionResp.CreateTime),
+		Locations: secretManagerLocations(secretResp.Replication),
 	}, nil
 }
 
-func (c *Client) storageUpdate(ctx context.Context, i *StorageUpdateRequest) (*Secret, error) {
-	bucket := i.Bucket
-	if bucket == "" {
-		return nil, fmt.Errorf("missing bucket name")
+// secretManagerRehome re-creates the given secret with a replication policy for
+// the given locations. Secret Manager does not permit changing replication in
+// place, so all enabled versions are first copied into a staging secret as a
+// backup, the original secret is deleted and re-created, and the versions and
+// IAM policy are copied back. The staging secret is removed on success. If the
+// process fails midway, the staging secret is left behind for recovery.
+//
+// Customer-managed encryption keys are tied to a location, so secrets that use
+// them are not re-homed.
+func (c *Client) secretManagerRehome(ctx context.Context, existing *secretspb.Secret, locations []string) (*secretspb.Secret, error) {
+	project, name := secretManagerParseName(existing.Name)
+
+	if secretManagerHasCMEK(existing.Replication) {
+		return nil, fmt.Errorf("secret uses customer-managed encryption, which " +
+			"is tied to its current locations; re-create the secret with keys " +
+			"for the new locations instead")
 	}
 
-	object := i.Object
-	if object == "" {
-		return nil, fmt.Errorf("missing object name")
+	stagingName, err := secretManagerStagingName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := logging.FromContext(ctx).With(
+		"project", project,
+		"name", name,
+		"staging_name", stagingName,
+		"locations", locations,
+	)
+
+	logger.DebugContext(ctx, "rehome.start")
+	defer logger.DebugContext(ctx, "rehome.finish")
+
+	// Read all enabled versions, oldest first.
+	logger.DebugContext(ctx, "reading existing versions")
+
+	payloads, err := c.secretManagerEnabledPayloads(ctx, existing.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the existing IAM policy, including conditional bindings.
+	logger.DebugContext(ctx, "reading existing iam policy")
+
+	var policy *iam.Policy3
+	if err := iamRetry(ctx, func(ctx context.Context) error {
+		p, err := c.secretManagerIAM(project, name).V3().Policy(ctx)
+		if err != nil {
+			return err
+		}
+		policy = p
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to get IAM policy: %w", err)
+	}
+
+	// Build the template for the new secret, preserving labels, annotations,
+	// and other settings.
+	template := proto.Clone(existing).(*secretspb.Secret)
+	template.Name = ""
+	template.CreateTime = nil
+	template.Etag = ""
+	template.VersionAliases = nil
+	template.Replication = secretManagerReplication(locations)
+
+	// Copy into a staging secret as a backup.
+	logger.DebugContext(ctx, "creating staging secret")
+
+	staging, err := c.secretManagerCreateWithPayloads(ctx, project, stagingName, template, payloads)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging secret %s: %w", stagingName, err)
+	}
+
+	// Swap the original secret.
+	logger.DebugContext(ctx, "deleting original secret")
+
+	if err := c.secretManagerClient.DeleteSecret(ctx, &secretspb.DeleteSecretRequest{
+		Name: existing.Name,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to delete original secret: %w", err)
+	}
+
+	logger.DebugContext(ctx, "re-creating original secret")
+
+	secret, err := c.secretManagerCreateWithPayloads(ctx, project, name, template, payloads)
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-create secret (a backup remains at %s): %w",
+			staging.Name, err)
 	}
 
+	logger.DebugContext(ctx, "restoring iam policy")
+
+	if err := iamRetry(ctx, func(ctx context.Context) error {
+		h := c.secretManagerIAM(project, name).V3()
+		p, err := h.Policy(ctx)
+		if err != nil {
+			return err
+		}
+		p.Bindings = append(p.Bindings, policy.Bindings...)
+		return h.SetPolicy(ctx, p)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to restore IAM policy (a backup remains at %s): %w",
+			staging.Name, err)
+	}
+
+	// Cleanup the staging secret.
+	logger.DebugContext(ctx, "deleting staging secret")
+
+	if err := c.secretManagerClient.DeleteSecret(ctx, &secretspb.DeleteSecretRequest{
+		Name: staging.Name,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to delete staging secret %s: %w", staging.Name, err)
+	}
+
+	return secret, nil
+}
+
+// secretManagerMaxSecretIDLength is the maximum length of a Secret Manager
+// secret ID.
+const secretManagerMaxSecretIDLength = 255
+
+// secretManagerStagingName returns the name of the staging secret used to
+// re-home the named secret. It returns an error if the name would be longer
+// than Secret Manager allows.
+func secretManagerStagingName(name string) (string, error) {
+	stagingName := name + "-berglas-rehome"
+	if len(stagingName) > secretManagerMaxSecretIDLength {
+		return "", fmt.Errorf("secret name is too long to re-home: staging "+
+			"secret %s would exceed %d characters", stagingName,
+			secretManagerMaxSecretIDLength)
+	}
+	return stagingName, nil
+}
+
+// secretManagerHasCMEK reports whether the replication policy uses a
+// customer-managed encryption key for any replica.
+func secretManagerHasCMEK(replication *secretspb.Replication) bool {
+	if replication.GetAutomatic().GetCustomerManagedEncryption() != nil {
+		return true
+	}
+	for _, r := range replication.GetUserManaged().GetReplicas() {
+		if r.GetCustomerManagedEncryption() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// secretManagerEnabledPayloads returns the payloads of all enabled versions of
+// the given secret, ordered from oldest to newest. Disabled and destroyed
+// versions cannot be accessed and are skipped.
+func (c *Client) secretManagerEnabledPayloads(ctx context.Context, secretName string) ([][]byte, error) {
+	var versions []*secretspb.SecretVersion
+
+	it := c.secretManagerClient.ListSecretVersions(ctx, &secretspb.ListSecretVersionsRequest{
+		Parent: secretName,
+		Filter: "state:ENABLED",
+	})
+	for {
+		resp, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions: %w", err)
+		}
+		versions = append(versions, resp)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return timestampToTime(versions[i].CreateTime).Before(timestampToTime(versions[j].CreateTime))
+	})
+
+	payloads := make([][]byte, 0, len(versions))
+	for _, v := range versions {
+		resp, err := c.secretManagerClient.AccessSecretVersion(ctx, &secretspb.AccessSecretVersionRequest{
+			Name: v.Name,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to access version %s: %w", path.Base(v.Name), err)
+		}
+		payloads = append(payloads, resp.Payload.Data)
+	}
+	return payloads, nil
+}
+
+// secretManagerCreateWithPayloads creates a secret from the given template and
+// adds a version for each payload in order.
+func (c *Client) secretManagerCreateWithPayloads(ctx context.Context, project, name string, template *secretspb.Secret, payloads [][]byte) (*secretspb.Secret, error) {
+	secret, err := c.secretManagerClient.CreateSecret(ctx, &secretspb.CreateSecretRequest{
+		Parent:   fmt.Sprintf("projects/%s", project),
+		SecretId: name,
+		Secret:   template,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret: %w", err)
+	}
+
+	for _, payload := range payloads {
+		if _, err := c.secretManagerClient.AddSecretVersion(ctx, &secretspb.AddSecretVersionRequest{
+			Parent: secret.Name,
+			Payload: &secretspb.SecretPayload{
+				Data: payload,
+			},
+		}); err != nil {
+			return nil, fmt.Errorf("failed to copy secret version: %w", err)
+		}
+	}
+	return secret, nil
+}
+
+// secretManagerParseName splits a fully-qualified secret name of the format
+// "projects/<project>/secrets/<name>" into its project and name.
+func secretManagerParseName(s string) (string, string) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 4 {
+		return "", s
+	}
+	return parts[1], parts[3]
+}
+
+func (c *Client) storageUpdate(ctx context.Context, i *StorageUpdateRequest) (*Secret, error) {
+	bucket := i.Bucket
+	object := i.Object
+
 	// Key and Plaintext may be required depending on whether the object exists.
 	key := i.Key
 	plaintext := i.Plaintext
-
 	generation := i.Generation
 	metageneration := i.Metageneration
 	createIfMissing := i.CreateIfMissing
+	expiresAt := i.ExpiresAt
 
 	logger := logging.FromContext(ctx).With(
 		"bucket", bucket,
@@ -265,6 +572,10 @@ func (c *Client) storageUpdate(ctx context.Context, i *StorageUpdateRequest) (*S
 			logger.DebugContext(ctx, "setting key")
 		}
 
+		if expiresAt.IsZero() {
+			expiresAt = secretFromAttrs(bucket, attrs, nil).ExpiresAt
+		}
+
 		if plaintext == nil {
 			logger.DebugContext(ctx, "attempting to access plaintext")
 
@@ -290,8 +601,26 @@ func (c *Client) storageUpdate(ctx context.Context, i *StorageUpdateRequest) (*S
 		// Update the secret
 		logger.DebugContext(ctx, "updating secret")
 
-		secret, err := c.encryptAndWrite(ctx, bucket, object, key, plaintext,
-			generation, metageneration)
+		level, err := c.kmsKeyProtectionLevel(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check kms key: %w", err)
+		}
+
+		counter, err := c.storageNextWriteCounter(ctx, bucket, object, attrs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update secret: %w", err)
+		}
+
+		// An existing secret with bound additional authenticated data is never
+		// downgraded, even if this client does not bind it.
+		secret, err := c.encryptAndWrite(ctx, bucket, object, key, writeOptions{
+			protectionLevel: level,
+			expiresAt:       expiresAt,
+			policy:          Policy(attrs.Metadata[MetadataPolicyKey]),
+			contentType:     attrs.Metadata[MetadataContentTypeKey],
+			writeCounter:    counter,
+			boundAAD:        c.boundAAD || storageAADBound(attrs.Metadata),
+		}, plaintext, generation, metageneration)
 		if err != nil {
 			return nil, fmt.Errorf("failed to update secret: %w", err)
 		}
@@ -313,7 +642,7 @@ func (c *Client) storageUpdate(ctx context.Context, i *StorageUpdateRequest) (*S
 		logger.DebugContext(ctx, "secret does not exist")
 
 		if !createIfMissing {
-			return nil, errSecretDoesNotExist
+			return nil, storageError(ErrSecretDoesNotExist, bucket, object)
 		}
 
 		if key == "" {
@@ -327,8 +656,22 @@ func (c *Client) storageUpdate(ctx context.Context, i *StorageUpdateRequest) (*S
 		logger.DebugContext(ctx, "creating secret")
 
 		// Update the secret.
-		secret, err := c.encryptAndWrite(ctx, bucket, object, key, plaintext,
-			generation, metageneration)
+		level, err := c.kmsKeyProtectionLevel(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check kms key: %w", err)
+		}
+
+		counter, err := c.storageNextWriteCounter(ctx, bucket, object, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update secret: %w", err)
+		}
+
+		secret, err := c.encryptAndWrite(ctx, bucket, object, key, writeOptions{
+			protectionLevel: level,
+			expiresAt:       expiresAt,
+			writeCounter:    counter,
+			boundAAD:        c.boundAAD,
+		}, plaintext, generation, metageneration)
 		if err != nil {
 			return nil, fmt.Errorf("failed to update secret: %w", err)
 		}
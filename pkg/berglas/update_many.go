@@ -0,0 +1,133 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"golang.org/x/sync/semaphore"
+)
+
+// UpdateManyParallelism is the maximum number of secrets UpdateMany updates
+// concurrently.
+const UpdateManyParallelism = 8
+
+// UpdateManyResult is the outcome of a single update in UpdateMany.
+type UpdateManyResult struct {
+	// Request is the update request.
+	Request *SecretManagerUpdateRequest
+
+	// Secret is the updated secret, if the update succeeded.
+	Secret *Secret
+
+	// Err is the error from the update, if it failed.
+	Err error
+}
+
+// UpdateMany is a top-level package function for updating many Secret Manager
+// secrets. For large volumes of secrets, please create a client instead.
+func UpdateMany(ctx context.Context, reqs []*SecretManagerUpdateRequest) ([]*UpdateManyResult, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.UpdateMany(ctx, reqs)
+}
+
+// UpdateMany updates many Secret Manager secrets concurrently, such as when
+// adding the same payload to every secret that holds a shared CA bundle. At
+// most UpdateManyParallelism updates run at once.
+//
+// Every request is validated before any secret is updated. A failed update
+// does not stop the others. The results are returned in the order of the
+// requests, along with an error summarizing the failures if any update failed.
+func (c *Client) UpdateMany(ctx context.Context, reqs []*SecretManagerUpdateRequest) ([]*UpdateManyResult, error) {
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	for idx, req := range reqs {
+		if req == nil {
+			return nil, fmt.Errorf("request %d: missing request", idx)
+		}
+		if err := req.Validate(); err != nil {
+			return nil, fmt.Errorf("request %d: %w", idx, err)
+		}
+		if err := c.checkNaming(req.Name); err != nil {
+			return nil, fmt.Errorf("request %d: %w", idx, secretManagerError(err, req.Project, req.Name))
+		}
+	}
+
+	logger := logging.FromContext(ctx).With(
+		"count", len(reqs),
+		"parallelism", UpdateManyParallelism,
+	)
+
+	logger.DebugContext(ctx, "updatemany.start")
+	defer logger.DebugContext(ctx, "updatemany.finish")
+
+	progress := progressFromContext(ctx)
+	progress.Start(len(reqs))
+	defer progress.Finish()
+
+	sem := semaphore.NewWeighted(UpdateManyParallelism)
+
+	results := make([]*UpdateManyResult, len(reqs))
+	var wg sync.WaitGroup
+	for idx, req := range reqs {
+		results[idx] = &UpdateManyResult{Request: req}
+
+		if err := sem.Acquire(ctx, 1); err != nil {
+			results[idx].Err = err
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sem.Release(1)
+			defer progress.Increment(1)
+
+			// Each goroutine writes a distinct result.
+			secret, err := c.Update(ctx, req)
+			results[idx].Secret, results[idx].Err = secret, err
+		}()
+	}
+
+	wg.Wait()
+
+	var errs []error
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+
+		// Name the secret unless the error already does.
+		var serr *SecretError
+		if errors.As(r.Err, &serr) {
+			errs = append(errs, r.Err)
+		} else {
+			errs = append(errs, secretManagerError(r.Err, r.Request.Project, r.Request.Name))
+		}
+	}
+	if len(errs) > 0 {
+		return results, fmt.Errorf("%d of %d updates failed: %w", len(errs), len(reqs), errors.Join(errs...))
+	}
+	return results, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestClient_UpdateMany_validate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		readOnly bool
+		reqs     []*SecretManagerUpdateRequest
+		err      string
+	}{
+		{
+			name:     "read_only",
+			readOnly: true,
+			reqs:     []*SecretManagerUpdateRequest{{Project: "p", Name: "n", Plaintext: []byte("x")}},
+			err:      ErrReadOnly.Error(),
+		},
+		{
+			name: "nil_request",
+			reqs: []*SecretManagerUpdateRequest{{Project: "p", Name: "n", Plaintext: []byte("x")}, nil},
+			err:  "request 1: missing request",
+		},
+		{
+			name: "invalid_request",
+			reqs: []*SecretManagerUpdateRequest{{Project: "p", Plaintext: []byte("x")}},
+			err:  "request 0:",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := &Client{readOnly: tc.readOnly}
+			_, err := client.UpdateMany(context.Background(), tc.reqs)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected %v to contain %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestClient_UpdateMany(t *testing.T) {
+	testAcc(t)
+
+	ctx, client := testClient(t)
+	project := testProject(t)
+	existing, missing := testName(t), testName(t)
+
+	if _, err := client.Create(ctx, &SecretManagerCreateRequest{
+		Project:   project,
+		Name:      existing,
+		Plaintext: []byte("old"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer testSecretManagerCleanup(t, project, existing)
+	defer testSecretManagerCleanup(t, project, missing)
+
+	results, err := client.UpdateMany(ctx, []*SecretManagerUpdateRequest{
+		{Project: project, Name: existing, Plaintext: []byte("new")},
+		{Project: project, Name: missing, Plaintext: []byte("new")},
+	})
+	if !errors.Is(err, ErrSecretDoesNotExist) {
+		t.Errorf("expected %v to be %v", err, ErrSecretDoesNotExist)
+	}
+	if act, exp := len(results), 2; act != exp {
+		t.Fatalf("expected %d to be %d", act, exp)
+	}
+	if err := results[0].Err; err != nil {
+		t.Errorf("expected %v to be nil", err)
+	}
+	if act, exp := results[0].Secret.Version, "2"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+	if err := results[1].Err; !errors.Is(err, ErrSecretDoesNotExist) {
+		t.Errorf("expected %v to be %v", err, ErrSecretDoesNotExist)
+	}
+}
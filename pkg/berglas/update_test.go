@@ -16,7 +16,14 @@ package berglas
 
 import (
 	"bytes"
+	"context"
+	"reflect"
+	"strings"
 	"testing"
+
+	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/option"
 )
 
 func TestClient_Update_secretManager(t *testing.T) {
@@ -34,7 +41,7 @@ func TestClient_Update_secretManager(t *testing.T) {
 			Name:      name,
 			Plaintext: plaintext,
 		}); !IsSecretDoesNotExistErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretDoesNotExist)
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
 		}
 		defer testSecretManagerCleanup(t, project, name)
 	})
@@ -91,6 +98,41 @@ func TestClient_Update_secretManager(t *testing.T) {
 			t.Errorf("expected plaintext %q to be %q", act, exp)
 		}
 	})
+
+	t.Run("change_locations", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name := testProject(t), testName(t)
+		plaintext := []byte("my secret plaintext")
+
+		if _, err := client.Create(ctx, &SecretManagerCreateRequest{
+			Project:   project,
+			Name:      name,
+			Plaintext: plaintext,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer testSecretManagerCleanup(t, project, name)
+
+		locations := []string{"europe-west1", "europe-west4"}
+		updateResp, err := client.Update(ctx, &SecretManagerUpdateRequest{
+			Project:   project,
+			Name:      name,
+			Locations: locations,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if act, exp := updateResp.Locations, locations; !reflect.DeepEqual(act, exp) {
+			t.Errorf("expected locations %q to be %q", act, exp)
+		}
+
+		if act, exp := updateResp.Plaintext, plaintext; !bytes.Equal(act, exp) {
+			t.Errorf("expected plaintext %q to be %q", act, exp)
+		}
+	})
 }
 
 func TestClient_Update_storage(t *testing.T) {
@@ -109,7 +151,7 @@ func TestClient_Update_storage(t *testing.T) {
 			Key:       key,
 			Plaintext: plaintext,
 		}); !IsSecretDoesNotExistErr(err) {
-			t.Errorf("expected %q to be %q", err, errSecretDoesNotExist)
+			t.Errorf("expected %q to be %q", err, ErrSecretDoesNotExist)
 		}
 		defer testStorageCleanup(t, bucket, object)
 	})
@@ -171,3 +213,110 @@ func TestClient_Update_storage(t *testing.T) {
 		}
 	})
 }
+
+func TestClient_Update_secretManagerLocationsUnchanged(t *testing.T) {
+	t.Parallel()
+
+	ctx := logging.WithLogger(context.Background(), logging.TestLogger(t))
+	client, err := New(ctx,
+		option.WithoutAuthentication(),
+		WithSecretManagerEndpoint("127.0.0.1:1"),
+		WithKMSEndpoint("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := client.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	locations := []string{"us-west1", "us-east1"}
+	if _, err := client.Update(ctx, &SecretManagerUpdateRequest{
+		Project:   "p",
+		Name:      "n",
+		Locations: locations,
+		Plaintext: []byte("p"),
+	}); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if act, exp := locations, []string{"us-west1", "us-east1"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
+
+func TestSecretManagerStagingName(t *testing.T) {
+	t.Parallel()
+
+	if act, err := secretManagerStagingName("n"); err != nil || act != "n-berglas-rehome" {
+		t.Errorf("expected n-berglas-rehome, got %q (%v)", act, err)
+	}
+
+	if _, err := secretManagerStagingName(strings.Repeat("n", 250)); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestSecretManagerHasCMEK(t *testing.T) {
+	t.Parallel()
+
+	cmek := &secretspb.CustomerManagedEncryption{KmsKeyName: "k"}
+
+	cases := []struct {
+		name        string
+		replication *secretspb.Replication
+		exp         bool
+	}{
+		{
+			name:        "automatic",
+			replication: secretManagerReplication(nil),
+			exp:         false,
+		},
+		{
+			name:        "user_managed",
+			replication: secretManagerReplication([]string{"us-east1"}),
+			exp:         false,
+		},
+		{
+			name: "automatic_cmek",
+			replication: &secretspb.Replication{
+				Replication: &secretspb.Replication_Automatic_{
+					Automatic: &secretspb.Replication_Automatic{
+						CustomerManagedEncryption: cmek,
+					},
+				},
+			},
+			exp: true,
+		},
+		{
+			name: "user_managed_cmek",
+			replication: &secretspb.Replication{
+				Replication: &secretspb.Replication_UserManaged_{
+					UserManaged: &secretspb.Replication_UserManaged{
+						Replicas: []*secretspb.Replication_UserManaged_Replica{
+							{Location: "us-east1"},
+							{Location: "us-west1", CustomerManagedEncryption: cmek},
+						},
+					},
+				},
+			},
+			exp: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if act, exp := secretManagerHasCMEK(tc.replication), tc.exp; act != exp {
+				t.Errorf("expected %t to be %t", act, exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// kmsKeyRegexp matches a fully-qualified Cloud KMS crypto key, optionally
+// including a crypto key version.
+var kmsKeyRegexp = regexp.MustCompile(
+	`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+(/cryptoKeyVersions/[^/]+)?$`)
+
+// FieldError describes a single invalid field on a request.
+type FieldError struct {
+	// Field is the name of the request struct field that is invalid.
+	Field string
+
+	// Message is a human-readable description of the problem.
+	Message string
+}
+
+// Error implements the error interface.
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+// ValidationError is returned when a request fails validation. Validation
+// happens before any network calls are made, so these errors can be returned
+// without credentials.
+type ValidationError struct {
+	// Errors is the list of invalid fields, in the order they were checked.
+	Errors []*FieldError
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Error())
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// IsValidationErr returns true if the given error is a request validation
+// error.
+func IsValidationErr(err error) bool {
+	var verr *ValidationError
+	return errors.As(err, &verr)
+}
+
+// validator accumulates field errors.
+type validator struct {
+	errs []*FieldError
+}
+
+// addf records an error for the given field.
+func (v *validator) addf(field, format string, args ...any) {
+	v.errs = append(v.errs, &FieldError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// require records an error for the given field if the value is empty.
+func (v *validator) require(field, value, msg string) {
+	if value == "" {
+		v.addf(field, "%s", msg)
+	}
+}
+
+// requireBytes records an error for the given field if the value is nil.
+func (v *validator) requireBytes(field string, value []byte, msg string) {
+	if value == nil {
+		v.addf(field, "%s", msg)
+	}
+}
+
+// kmsKey records an error for the given field if the value is not a valid
+// Cloud KMS crypto key. Empty values are not checked.
+func (v *validator) kmsKey(field, value string) {
+	if scheme := keyScheme(value); scheme != "" {
+		if strings.TrimPrefix(value, scheme+"://") == "" {
+			v.addf(field, "invalid key %q: missing key after scheme", value)
+		}
+		return
+	}
+	if value != "" && !kmsKeyRegexp.MatchString(value) {
+		v.addf(field, "invalid kms key %q: must be of the format "+
+			"projects/<p>/locations/<l>/keyRings/<kr>/cryptoKeys/<k>", value)
+	}
+}
+
+// versionConstraint records an error for the given field if the value is a
+// malformed Secret Manager version constraint.
+func (v *validator) versionConstraint(field, value string) {
+	if _, err := parseVersionConstraint(value); err != nil {
+		v.addf(field, "%s", err)
+	}
+}
+
+// escrowWrappingKey records an error for the given field if the value is not
+// a fully-qualified Cloud KMS crypto key version.
+func (v *validator) escrowWrappingKey(field, value string) {
+	if !kmsKeyRegexp.MatchString(value) || !kmsKeyIncludesVersion(value) {
+		v.addf(field, "invalid wrapping key %q: must be of the format "+
+			"projects/<p>/locations/<l>/keyRings/<kr>/cryptoKeys/<k>/cryptoKeyVersions/<v>", value)
+	}
+}
+
+// secretManagerScope records an error for the scope field if the value is not
+// a supported SecretManagerScope, and for the name field if the name is
+// missing for a secret scope or given for a project scope.
+func (v *validator) secretManagerScope(scopeField, nameField string, scope SecretManagerScope, name string) {
+	switch scope {
+	case "", SecretManagerScopeSecret:
+		v.require(nameField, name, "missing secret name")
+	case SecretManagerScopeProject:
+		if name != "" {
+			v.addf(nameField, "secret name must be empty for project scope")
+		}
+	default:
+		v.addf(scopeField, "invalid scope %q: must be one of secret, project", scope)
+	}
+}
+
+// iamReason records an error for the given field if the reason of a grant is
+// too long or multi-line, or is given without an expiry.
+func (v *validator) iamReason(field, value string, expires time.Time) {
+	switch {
+	case value == "":
+	case expires.IsZero():
+		v.addf(field, "a reason requires an expiry")
+	case len(value) > IAMReasonMaxLength:
+		v.addf(field, "reason must be at most %d bytes", IAMReasonMaxLength)
+	case strings.ContainsAny(value, "\r\n"):
+		v.addf(field, "reason must be a single line")
+	}
+}
+
+// expiresAt records an error for the given field if the value is set and not
+// in the future.
+func (v *validator) expiresAt(field string, value time.Time) {
+	if !value.IsZero() && !value.After(time.Now()) {
+		v.addf(field, "invalid expiration %s: must be in the future",
+			value.Format(time.RFC3339))
+	}
+}
+
+// policy records an error for the given field if the value is not a supported
+// Policy.
+func (v *validator) policy(field string, value Policy) {
+	if !IsPolicy(string(value)) {
+		v.addf(field, "invalid policy %q: must be one of %s, %s", value, PolicyFileOnly, PolicyEnvOnly)
+	}
+}
+
+// contentType records an error for the given field if the value is set and is
+// not a valid media type.
+func (v *validator) contentType(field, value string) {
+	if value == "" {
+		return
+	}
+	if _, _, err := mime.ParseMediaType(value); err != nil {
+		v.addf(field, "invalid content type %q: %s", value, err)
+	}
+}
+
+// canIAction records an error for the given field if the value is not a
+// supported CanIAction.
+func (v *validator) canIAction(field string, value CanIAction) {
+	if !IsCanIAction(string(value)) {
+		v.addf(field, "invalid action %q: must be one of access, create, grant", value)
+	}
+}
+
+// members records an error for each member that is not in a valid IAM member
+// format.
+func (v *validator) members(field string, members []string) {
+	for _, m := range members {
+		if err := validateMember(m); err != nil {
+			v.addf(field, "%s", err)
+		}
+	}
+}
+
+// err returns the accumulated errors, or nil if there are none.
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errs}
+}
+
+// memberTypes are the accepted IAM member type prefixes.
+var memberTypes = map[string]struct{}{
+	"deleted":        {},
+	"domain":         {},
+	"group":          {},
+	"principal":      {},
+	"principalSet":   {},
+	"projectEditor":  {},
+	"projectOwner":   {},
+	"projectViewer":  {},
+	"serviceAccount": {},
+	"user":           {},
+}
+
+// validateMember returns an error if the given member is not in the
+// "type:value" IAM member format with a known type.
+func validateMember(m string) error {
+	switch m {
+	case "allUsers", "allAuthenticatedUsers":
+		return nil
+	}
+
+	typ, value, ok := strings.Cut(m, ":")
+	if !ok || typ == "" || value == "" {
+		return fmt.Errorf("invalid member %q: must be of the format type:value "+
+			"(e.g. user:jane@example.com)", m)
+	}
+
+	if _, ok := memberTypes[typ]; !ok {
+		return fmt.Errorf("invalid member %q: unknown member type %q", m, typ)
+	}
+
+	switch typ {
+	case "user", "group", "serviceAccount":
+		if !strings.Contains(value, "@") {
+			return fmt.Errorf("invalid member %q: %s must be an email address", m, typ)
+		}
+	}
+	return nil
+}
+
+// ExpandMember expands shorthand member strings into their fully-qualified IAM
+// member format:
+//
+//   - "sa:NAME@PROJECT" becomes "serviceAccount:NAME@PROJECT.iam.gserviceaccount.com"
+//   - "sa:NAME@PROJECT.iam.gserviceaccount.com" becomes "serviceAccount:..."
+//   - bare service account emails (ending in gserviceaccount.com) become
+//     "serviceAccount:EMAIL"
+//   - other bare emails become "user:EMAIL"
+//
+// All other values are returned unchanged. The result is validated, and an
+// error is returned if it is not a valid IAM member.
+func ExpandMember(m string) (string, error) {
+	m = strings.TrimSpace(m)
+
+	switch {
+	case strings.HasPrefix(m, "sa:"):
+		value := strings.TrimPrefix(m, "sa:")
+		if name, project, ok := strings.Cut(value, "@"); ok && name != "" && project != "" &&
+			!strings.Contains(project, ".") {
+			value = name + "@" + project + ".iam.gserviceaccount.com"
+		}
+		m = "serviceAccount:" + value
+	case !strings.Contains(m, ":") && strings.Contains(m, "@"):
+		_, domain, _ := strings.Cut(m, "@")
+		if strings.HasSuffix(domain, "gserviceaccount.com") {
+			m = "serviceAccount:" + m
+		} else {
+			m = "user:" + m
+		}
+	}
+
+	if err := validateMember(m); err != nil {
+		return "", err
+	}
+	return m, nil
+}
+
+// ExpandMembers calls ExpandMember on each member, returning the first error.
+func ExpandMembers(members []string) ([]string, error) {
+	result := make([]string, 0, len(members))
+	for _, m := range members {
+		expanded, err := ExpandMember(m)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, expanded)
+	}
+	return result, nil
+}
@@ -0,0 +1,436 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		req    interface{ Validate() error }
+		fields []string
+	}{
+		{
+			"storage_access_valid",
+			&StorageAccessRequest{Bucket: "b", Object: "o"},
+			nil,
+		},
+		{
+			"storage_access_missing",
+			&StorageAccessRequest{},
+			[]string{"Bucket", "Object"},
+		},
+		{
+			"secret_manager_access_version_constraint",
+			&SecretManagerAccessRequest{Project: "p", Name: "n", Version: "latest-1"},
+			nil,
+		},
+		{
+			"secret_manager_access_malformed_version_constraint",
+			&SecretManagerAccessRequest{Project: "p", Name: "n", Version: ">=x"},
+			[]string{"Version"},
+		},
+		{
+			"secret_manager_access_missing_name",
+			&SecretManagerAccessRequest{Project: "p"},
+			[]string{"Name"},
+		},
+		{
+			"secret_manager_create_content_type",
+			&SecretManagerCreateRequest{Project: "p", Name: "n", Plaintext: []byte("x"), ContentType: "application/json; charset=utf-8"},
+			nil,
+		},
+		{
+			"secret_manager_create_malformed_content_type",
+			&SecretManagerCreateRequest{Project: "p", Name: "n", Plaintext: []byte("x"), ContentType: "json/"},
+			[]string{"ContentType"},
+		},
+		{
+			"secret_manager_delete_destroy_ttl",
+			&SecretManagerDeleteRequest{Project: "p", Name: "n", Version: "3", DestroyTTL: 7 * 24 * time.Hour},
+			nil,
+		},
+		{
+			"secret_manager_delete_destroy_ttl_without_version",
+			&SecretManagerDeleteRequest{Project: "p", Name: "n", DestroyTTL: 7 * 24 * time.Hour},
+			[]string{"DestroyTTL"},
+		},
+		{
+			"secret_manager_delete_destroy_ttl_too_short",
+			&SecretManagerDeleteRequest{Project: "p", Name: "n", Version: "3", DestroyTTL: time.Hour},
+			[]string{"DestroyTTL"},
+		},
+		{
+			"secret_manager_delete_latest",
+			&SecretManagerDeleteRequest{Project: "p", Name: "n", Version: "latest"},
+			[]string{"Version"},
+		},
+		{
+			"secret_manager_restore_missing_version",
+			&SecretManagerRestoreRequest{Project: "p", Name: "n"},
+			[]string{"Version"},
+		},
+		{
+			"storage_create_malformed_key",
+			&StorageCreateRequest{
+				Bucket:    "b",
+				Object:    "o",
+				Key:       "projects/p/keyRings/kr",
+				Plaintext: []byte("p"),
+			},
+			[]string{"Key"},
+		},
+		{
+			"storage_create_key_version",
+			&StorageCreateRequest{
+				Bucket:    "b",
+				Object:    "o",
+				Key:       "projects/p/locations/l/keyRings/kr/cryptoKeys/k/cryptoKeyVersions/1",
+				Plaintext: []byte("p"),
+			},
+			nil,
+		},
+		{
+			"storage_create_key_uri",
+			&StorageCreateRequest{
+				Bucket:    "b",
+				Object:    "o",
+				Key:       "aws-kms://arn:aws:kms:us-east-1:111122223333:key/k",
+				Plaintext: []byte("p"),
+			},
+			nil,
+		},
+		{
+			"storage_create_key_uri_empty",
+			&StorageCreateRequest{
+				Bucket:    "b",
+				Object:    "o",
+				Key:       "local-key://",
+				Plaintext: []byte("p"),
+			},
+			[]string{"Key"},
+		},
+		{
+			"s3_create_missing_key",
+			&StorageS3CreateRequest{
+				Bucket:    "b",
+				Object:    "o",
+				Plaintext: []byte("p"),
+			},
+			[]string{"Key"},
+		},
+		{
+			"s3_update_missing_object",
+			&StorageS3UpdateRequest{
+				Bucket:    "b",
+				Plaintext: []byte("p"),
+			},
+			[]string{"Object"},
+		},
+		{
+			"storage_create_missing_plaintext",
+			&StorageCreateRequest{
+				Bucket: "b",
+				Object: "o",
+				Key:    "projects/p/locations/l/keyRings/kr/cryptoKeys/k",
+			},
+			[]string{"Plaintext"},
+		},
+		{
+			"storage_create_protection_level",
+			&StorageCreateRequest{
+				Bucket:                 "b",
+				Object:                 "o",
+				Key:                    "projects/p/locations/l/keyRings/kr/cryptoKeys/k",
+				Plaintext:              []byte("p"),
+				RequireProtectionLevel: "EXTERNAL",
+			},
+			nil,
+		},
+		{
+			"storage_create_invalid_protection_level",
+			&StorageCreateRequest{
+				Bucket:                 "b",
+				Object:                 "o",
+				Key:                    "projects/p/locations/l/keyRings/kr/cryptoKeys/k",
+				Plaintext:              []byte("p"),
+				RequireProtectionLevel: "PROTECTION_LEVEL_UNSPECIFIED",
+			},
+			[]string{"RequireProtectionLevel"},
+		},
+		{
+			"storage_create_expired",
+			&StorageCreateRequest{
+				Bucket:    "b",
+				Object:    "o",
+				Key:       "projects/p/locations/l/keyRings/kr/cryptoKeys/k",
+				Plaintext: []byte("p"),
+				ExpiresAt: time.Now().Add(-time.Hour),
+			},
+			[]string{"ExpiresAt"},
+		},
+		{
+			"secret_manager_list_negative_parallelism",
+			&SecretManagerListRequest{Project: "p", Parallelism: -1},
+			[]string{"Parallelism"},
+		},
+		{
+			"secret_manager_list_states",
+			&SecretManagerListRequest{Project: "p", Versions: true, States: []string{"ENABLED", "DISABLED"}},
+			nil,
+		},
+		{
+			"secret_manager_list_invalid_state",
+			&SecretManagerListRequest{Project: "p", Versions: true, States: []string{"enabled"}},
+			[]string{"States"},
+		},
+		{
+			"secret_manager_stat_missing_name",
+			&SecretManagerStatRequest{Project: "p"},
+			[]string{"Name"},
+		},
+		{
+			"storage_can_i_valid",
+			&StorageCanIRequest{Bucket: "b", Object: "o", Action: CanIAccess},
+			nil,
+		},
+		{
+			"storage_can_i_create_missing_key",
+			&StorageCanIRequest{Bucket: "b", Object: "o", Action: CanICreate},
+			[]string{"Key"},
+		},
+		{
+			"secret_manager_can_i_invalid_action",
+			&SecretManagerCanIRequest{Project: "p", Name: "n", Action: "revoke"},
+			[]string{"Action"},
+		},
+		{
+			"escrow_export_missing_wrapping_key",
+			&EscrowExportRequest{Bucket: "b", Object: "o"},
+			[]string{"WrappingKey"},
+		},
+		{
+			"escrow_export_wrapping_key_without_version",
+			&EscrowExportRequest{
+				Bucket:      "b",
+				Object:      "o",
+				WrappingKey: "projects/p/locations/l/keyRings/kr/cryptoKeys/k",
+			},
+			[]string{"WrappingKey"},
+		},
+		{
+			"escrow_restore_missing_private_key",
+			&EscrowRestoreRequest{
+				Bundle: &EscrowBundle{
+					Version:     EscrowBundleVersion,
+					Bucket:      "b",
+					Object:      "o",
+					WrappingKey: "sha256:abcd",
+					WrappedDEK:  []byte("w"),
+					Ciphertext:  []byte("c"),
+				},
+			},
+			[]string{"PrivateKey"},
+		},
+		{
+			"copy_valid",
+			&CopyRequest{SourceBucket: "b", SourceObject: "o", Bucket: "b", Object: "o2"},
+			nil,
+		},
+		{
+			"copy_same_location",
+			&CopyRequest{SourceBucket: "b", SourceObject: "o", Bucket: "b", Object: "o"},
+			[]string{"Object"},
+		},
+		{
+			"copy_missing_destination",
+			&CopyRequest{SourceBucket: "b", SourceObject: "o"},
+			[]string{"Bucket", "Object"},
+		},
+		{
+			"secret_manager_grant_project_scope",
+			&SecretManagerGrantRequest{Project: "p", Scope: SecretManagerScopeProject},
+			nil,
+		},
+		{
+			"secret_manager_grant_project_scope_with_name",
+			&SecretManagerGrantRequest{Project: "p", Name: "n", Scope: SecretManagerScopeProject},
+			[]string{"Name"},
+		},
+		{
+			"secret_manager_grant_reason",
+			&SecretManagerGrantRequest{Project: "p", Name: "n", Reason: "JIRA-123", Expires: time.Now().Add(time.Hour)},
+			nil,
+		},
+		{
+			"secret_manager_grant_reason_without_expiry",
+			&SecretManagerGrantRequest{Project: "p", Name: "n", Reason: "JIRA-123"},
+			[]string{"Reason"},
+		},
+		{
+			"secret_manager_grant_reason_multiline",
+			&SecretManagerGrantRequest{Project: "p", Name: "n", Reason: "a\nb", Expires: time.Now().Add(time.Hour)},
+			[]string{"Reason"},
+		},
+		{
+			"secret_manager_grant_expired",
+			&SecretManagerGrantRequest{Project: "p", Name: "n", Expires: time.Now().Add(-time.Hour)},
+			[]string{"Expires"},
+		},
+		{
+			"secret_manager_bindings_missing_name",
+			&SecretManagerBindingsRequest{Project: "p"},
+			[]string{"Name"},
+		},
+		{
+			"secret_manager_revoke_invalid_scope",
+			&SecretManagerRevokeRequest{Project: "p", Name: "n", Scope: "version"},
+			[]string{"Scope"},
+		},
+		{
+			"storage_prune_generations_invalid_keep",
+			&StoragePruneGenerationsRequest{Bucket: "b", Object: "o"},
+			[]string{"Keep"},
+		},
+		{
+			"storage_prune_missing_bucket",
+			&StoragePruneRequest{},
+			[]string{"Bucket"},
+		},
+		{
+			"secret_manager_update_locations_only",
+			&SecretManagerUpdateRequest{Project: "p", Name: "n", Locations: []string{"us-east1"}},
+			nil,
+		},
+		{
+			"secret_manager_update_missing_plaintext",
+			&SecretManagerUpdateRequest{Project: "p", Name: "n"},
+			[]string{"Plaintext"},
+		},
+		{
+			"storage_grant_invalid_members",
+			&StorageGrantRequest{
+				Bucket:  "b",
+				Object:  "o",
+				Members: []string{"user:jane@example.com", "jane@example.com", "allUsers"},
+			},
+			[]string{"Members"},
+		},
+		{
+			"secret_manager_revoke_empty_member_value",
+			&SecretManagerRevokeRequest{Project: "p", Name: "n", Members: []string{"user:"}},
+			[]string{"Members"},
+		},
+		{
+			"storage_bootstrap_missing",
+			&StorageBootstrapRequest{},
+			[]string{"ProjectID", "Bucket"},
+		},
+		{
+			"storage_bootstrap_kms_options",
+			&StorageBootstrapRequest{
+				ProjectID:          "p",
+				Bucket:             "b",
+				KMSProtectionLevel: "HSM",
+				KMSRotationPeriod:  90 * 24 * time.Hour,
+			},
+			nil,
+		},
+		{
+			"storage_bootstrap_invalid_kms_options",
+			&StorageBootstrapRequest{
+				ProjectID:          "p",
+				Bucket:             "b",
+				KMSProtectionLevel: "EXTERNAL",
+				KMSRotationPeriod:  time.Hour,
+			},
+			[]string{"KMSProtectionLevel", "KMSRotationPeriod"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.req.Validate()
+			if tc.fields == nil {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected %q to be a validation error", err)
+			}
+
+			fields := make([]string, 0, len(verr.Errors))
+			for _, fe := range verr.Errors {
+				fields = append(fields, fe.Field)
+			}
+			if !reflect.DeepEqual(fields, tc.fields) {
+				t.Errorf("expected fields %q to be %q", fields, tc.fields)
+			}
+		})
+	}
+}
+
+func TestExpandMember(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		i    string
+		o    string
+		err  bool
+	}{
+		{"user", "user:jane@example.com", "user:jane@example.com", false},
+		{"all_users", "allUsers", "allUsers", false},
+		{"bare_email", "jane@example.com", "user:jane@example.com", false},
+		{"bare_service_account", "sa@p.iam.gserviceaccount.com", "serviceAccount:sa@p.iam.gserviceaccount.com", false},
+		{"sa_short", "sa:builder@my-project", "serviceAccount:builder@my-project.iam.gserviceaccount.com", false},
+		{"sa_full", "sa:builder@my-project.iam.gserviceaccount.com", "serviceAccount:builder@my-project.iam.gserviceaccount.com", false},
+		{"domain", "domain:example.com", "domain:example.com", false},
+		{"unknown_type", "usr:jane@example.com", "", true},
+		{"user_not_email", "user:jane", "", true},
+		{"bare_name", "jane", "", true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			act, err := ExpandMember(tc.i)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if act != tc.o {
+				t.Errorf("expected %q to be %q", act, tc.o)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"google.golang.org/api/iterator"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// versionConstraintLatestPrefix prefixes a constraint relative to the latest
+// enabled version, such as "latest-1" for the previous version.
+const versionConstraintLatestPrefix = "latest-"
+
+// versionConstraintOperators are the comparison operators of a constraint such
+// as ">=5", longest first so ">=" is not parsed as ">".
+var versionConstraintOperators = []string{">=", "<=", ">", "<"}
+
+// versionConstraint selects a Secret Manager version by comparison rather than
+// by number or alias.
+type versionConstraint struct {
+	// op is a comparison operator or versionConstraintLatestPrefix.
+	op string
+
+	// n is the version number compared against, or the number of enabled
+	// versions to go back from latest.
+	n int64
+}
+
+// parseVersionConstraint parses a Secret Manager version constraint. Versions
+// that are not constraints, such as "5", "latest", or an alias, return nil and
+// no error.
+func parseVersionConstraint(s string) (*versionConstraint, error) {
+	op, rest := "", ""
+	if strings.HasPrefix(s, versionConstraintLatestPrefix) {
+		op, rest = versionConstraintLatestPrefix, strings.TrimPrefix(s, versionConstraintLatestPrefix)
+	} else {
+		for _, o := range versionConstraintOperators {
+			if strings.HasPrefix(s, o) {
+				op, rest = o, strings.TrimPrefix(s, o)
+				break
+			}
+		}
+	}
+	if op == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid version constraint %q: must be latest-N, "+
+			"or >=N, >N, <=N, <N for a version number N", s)
+	}
+	return &versionConstraint{op: op, n: n}, nil
+}
+
+// pick returns the version that satisfies the constraint from the given
+// enabled version numbers, sorted from newest to oldest. For comparisons, the
+// newest matching version is picked.
+func (vc *versionConstraint) pick(versions []int64) (int64, bool) {
+	if vc.op == versionConstraintLatestPrefix {
+		if vc.n >= int64(len(versions)) {
+			return 0, false
+		}
+		return versions[vc.n], true
+	}
+
+	for _, v := range versions {
+		var ok bool
+		switch vc.op {
+		case ">=":
+			ok = v >= vc.n
+		case ">":
+			ok = v > vc.n
+		case "<=":
+			ok = v <= vc.n
+		case "<":
+			ok = v < vc.n
+		}
+		if ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// secretManagerResolveVersion resolves a version constraint to a version
+// number by listing the enabled versions of the secret. Versions that are not
+// constraints are returned unchanged.
+func (c *Client) secretManagerResolveVersion(ctx context.Context, project, name, version string) (string, error) {
+	vc, err := parseVersionConstraint(version)
+	if err != nil {
+		return "", err
+	}
+	if vc == nil {
+		return version, nil
+	}
+
+	logger := logging.FromContext(ctx).With(
+		"project", project,
+		"name", name,
+		"constraint", version,
+	)
+
+	logger.DebugContext(ctx, "listing enabled versions to resolve constraint")
+
+	var versions []int64
+	it := c.secretManagerClient.ListSecretVersions(ctx, &secretspb.ListSecretVersionsRequest{
+		Parent: fmt.Sprintf("projects/%s/secrets/%s", project, name),
+		Filter: "state:ENABLED",
+	})
+	for {
+		resp, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.NotFound {
+				return "", secretManagerError(ErrSecretDoesNotExist, project, name)
+			}
+			return "", fmt.Errorf("failed to list versions: %w", err)
+		}
+
+		n, err := strconv.ParseInt(path.Base(resp.GetName()), 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, n)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+	n, ok := vc.pick(versions)
+	if !ok {
+		return "", fmt.Errorf("no enabled version matches %q: %w", version,
+			secretManagerError(ErrSecretDoesNotExist, project, name))
+	}
+
+	logger.DebugContext(ctx, "resolved version constraint", "version", n)
+	return strconv.FormatInt(n, 10), nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVersionConstraint(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		s    string
+		exp  *versionConstraint
+		err  bool
+	}{
+		{name: "number", s: "5", exp: nil},
+		{name: "latest", s: "latest", exp: nil},
+		{name: "alias", s: "prod", exp: nil},
+		{name: "latest_minus", s: "latest-1", exp: &versionConstraint{op: "latest-", n: 1}},
+		{name: "gte", s: ">=5", exp: &versionConstraint{op: ">=", n: 5}},
+		{name: "gt", s: ">5", exp: &versionConstraint{op: ">", n: 5}},
+		{name: "lte", s: "<=5", exp: &versionConstraint{op: "<=", n: 5}},
+		{name: "lt", s: "<5", exp: &versionConstraint{op: "<", n: 5}},
+		{name: "malformed", s: ">=five", err: true},
+		{name: "negative", s: "latest--1", err: true},
+		{name: "empty", s: "<", err: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vc, err := parseVersionConstraint(tc.s)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t, got %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(vc, tc.exp) {
+				t.Errorf("expected %#v to be %#v", vc, tc.exp)
+			}
+		})
+	}
+}
+
+func TestVersionConstraint_pick(t *testing.T) {
+	t.Parallel()
+
+	// Version 4 is disabled, so it is not listed
+	versions := []int64{6, 5, 3, 2, 1}
+
+	cases := []struct {
+		name string
+		vc   *versionConstraint
+		exp  int64
+		ok   bool
+	}{
+		{name: "latest_minus_zero", vc: &versionConstraint{op: "latest-", n: 0}, exp: 6, ok: true},
+		{name: "previous", vc: &versionConstraint{op: "latest-", n: 2}, exp: 3, ok: true},
+		{name: "previous_too_far", vc: &versionConstraint{op: "latest-", n: 5}, ok: false},
+		{name: "gte", vc: &versionConstraint{op: ">=", n: 2}, exp: 6, ok: true},
+		{name: "gt_none", vc: &versionConstraint{op: ">", n: 6}, ok: false},
+		{name: "lte_skips_disabled", vc: &versionConstraint{op: "<=", n: 4}, exp: 3, ok: true},
+		{name: "lt", vc: &versionConstraint{op: "<", n: 2}, exp: 1, ok: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			n, ok := tc.vc.pick(versions)
+			if ok != tc.ok {
+				t.Fatalf("expected ok to be %t", tc.ok)
+			}
+			if n != tc.exp {
+				t.Errorf("expected %d to be %d", n, tc.exp)
+			}
+		})
+	}
+}
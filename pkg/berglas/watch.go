@@ -0,0 +1,402 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	secretspb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultWatchPollInterval is the interval at which watched secrets are
+	// polled when no Pub/Sub notifications are available.
+	DefaultWatchPollInterval = 30 * time.Second
+
+	// watchSubscriptionExpiration is how long an idle watch subscription lives
+	// before Pub/Sub deletes it. Subscriptions are deleted when the watch ends,
+	// so this only matters if the process dies.
+	watchSubscriptionExpiration = 24 * time.Hour
+
+	// watchPollIntervalKey points to the value in the context where the poll
+	// interval is stored.
+	watchPollIntervalKey = contextKey("watch_poll_interval")
+)
+
+// errWatchPubSubUnavailable is returned when a Pub/Sub subscription for a
+// watch could not be created, in which case the watch falls back to polling.
+var errWatchPubSubUnavailable = errors.New("pubsub notifications unavailable")
+
+// WithWatchPollInterval creates a new context with the provided poll interval
+// attached. Watches use it instead of DefaultWatchPollInterval when polling.
+func WithWatchPollInterval(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, watchPollIntervalKey, d)
+}
+
+// watchPollIntervalFromContext returns the poll interval stored in the
+// context, or DefaultWatchPollInterval if none is set.
+func watchPollIntervalFromContext(ctx context.Context) time.Duration {
+	if d, ok := ctx.Value(watchPollIntervalKey).(time.Duration); ok && d > 0 {
+		return d
+	}
+	return DefaultWatchPollInterval
+}
+
+// Watch watches a berglas reference for new versions. See Client.Watch for more
+// details.
+func Watch(ctx context.Context, ref string, fn func(*Secret)) error {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return err
+	}
+	return client.Watch(ctx, ref, fn)
+}
+
+// Watch watches the secret identified by the given berglas reference and calls
+// fn with the secret each time a new version is added. It blocks until the
+// context is cancelled, returning the context's error, or until an
+// unrecoverable error occurs. Calls to fn are never concurrent.
+//
+// For Secret Manager secrets with a configured Pub/Sub topic, and for Cloud
+// Storage secrets in a bucket with a Pub/Sub notification covering the object,
+// a temporary subscription is created on the topic and new versions are
+// delivered as they are added. This requires permission to create
+// subscriptions in the topic's project. If there is no topic or the
+// subscription cannot be created, the latest version is polled instead; see
+// WithWatchPollInterval.
+//
+// The reference must not pin a version or generation, since a pinned version
+// never changes.
+func (c *Client) Watch(ctx context.Context, s string, fn func(*Secret)) error {
+	logger := logging.FromContext(ctx).With(
+		"reference", s,
+	)
+
+	logger.DebugContext(ctx, "watch.start")
+	defer logger.DebugContext(ctx, "watch.finish")
+
+	if fn == nil {
+		return fmt.Errorf("missing callback")
+	}
+
+	ref, err := ParseReferenceContext(ctx, s)
+	if err != nil {
+		return fmt.Errorf("failed to parse reference %s: %w", s, err)
+	}
+
+	switch ref.Type() {
+	case ReferenceTypeSecretManager:
+		if v := ref.Version(); v != "" && v != "latest" {
+			return fmt.Errorf("cannot watch pinned version %s of %s", v, s)
+		}
+		return c.secretManagerWatch(ctx, ref.Project(), ref.Name(), fn)
+	case ReferenceTypeStorage:
+		if g := ref.Generation(); g != 0 {
+			return fmt.Errorf("cannot watch pinned generation %d of %s", g, s)
+		}
+		return c.storageWatch(ctx, ref.Bucket(), ref.Object(), fn)
+	default:
+		return fmt.Errorf("unknown reference type %d", ref.Type())
+	}
+}
+
+func (c *Client) secretManagerWatch(ctx context.Context, project, name string, fn func(*Secret)) error {
+	logger := logging.FromContext(ctx).With(
+		"project", project,
+		"name", name,
+	)
+
+	secretName := fmt.Sprintf("projects/%s/secrets/%s", project, name)
+
+	logger.DebugContext(ctx, "reading secret")
+
+	secret, err := c.secretManagerClient.GetSecret(ctx, &secretspb.GetSecretRequest{
+		Name: secretName,
+	})
+	if err != nil {
+		terr, ok := grpcstatus.FromError(err)
+		if ok && terr.Code() == grpccodes.NotFound {
+			return secretManagerError(ErrSecretDoesNotExist, project, name)
+		}
+		return fmt.Errorf("failed to read secret: %w", err)
+	}
+
+	latest := func(ctx context.Context) (string, error) {
+		resp, err := c.secretManagerClient.GetSecretVersion(ctx, &secretspb.GetSecretVersionRequest{
+			Name: secretName + "/versions/latest",
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to read latest version: %w", err)
+		}
+		return path.Base(resp.Name), nil
+	}
+
+	current, err := latest(ctx)
+	if err != nil {
+		return err
+	}
+	logger.DebugContext(ctx, "found current version", "version", current)
+
+	check := watchChecker(current, latest, func(ctx context.Context, version string) (*Secret, error) {
+		return c.secretManagerRead(ctx, &SecretManagerReadRequest{
+			Project: project,
+			Name:    name,
+			Version: version,
+		})
+	}, fn)
+
+	var topic string
+	if len(secret.Topics) > 0 {
+		topic = secret.Topics[0].Name
+	}
+
+	filter := `attributes.eventType = "SECRET_VERSION_ADD"`
+	return c.watchTopic(ctx, topic, filter, func(attrs map[string]string) bool {
+		// The topic may be shared by multiple secrets, and the secretId may use
+		// the project number instead of the project ID.
+		return strings.HasSuffix(attrs["secretId"], "/secrets/"+name)
+	}, check)
+}
+
+func (c *Client) storageWatch(ctx context.Context, bucket, object string, fn func(*Secret)) error {
+	logger := logging.FromContext(ctx).With(
+		"bucket", bucket,
+		"object", object,
+	)
+
+	objHandle := c.storageClient.Bucket(bucket).Object(object)
+
+	latest := func(ctx context.Context) (int64, error) {
+		attrs, err := objHandle.Attrs(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read secret metadata: %w", err)
+		}
+		return attrs.Generation, nil
+	}
+
+	logger.DebugContext(ctx, "reading secret metadata")
+
+	attrs, err := objHandle.Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return storageError(ErrSecretDoesNotExist, bucket, object)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read secret metadata: %w", err)
+	}
+	current := attrs.Generation
+	logger.DebugContext(ctx, "found current generation", "generation", current)
+
+	check := watchChecker(current, latest, func(ctx context.Context, generation int64) (*Secret, error) {
+		return c.storageRead(ctx, &StorageReadRequest{
+			Bucket:     bucket,
+			Object:     object,
+			Generation: generation,
+		})
+	}, fn)
+
+	// Listing notifications requires bucket-level permissions the caller may
+	// not have, in which case the secret is polled.
+	logger.DebugContext(ctx, "listing bucket notifications")
+
+	var topic string
+	notifications, err := c.storageClient.Bucket(bucket).Notifications(ctx)
+	if err != nil {
+		logger.WarnContext(ctx, "failed to list bucket notifications", "error", err)
+	}
+	if n := storageNotificationFor(notifications, object); n != nil {
+		topic = fmt.Sprintf("projects/%s/topics/%s", n.TopicProjectID, n.TopicID)
+	}
+
+	filter := fmt.Sprintf(`attributes.eventType = "%s"`, storage.ObjectFinalizeEvent)
+	return c.watchTopic(ctx, topic, filter, func(attrs map[string]string) bool {
+		// Notifications cover every object under the prefix.
+		return attrs["bucketId"] == bucket && attrs["objectId"] == object
+	}, check)
+}
+
+// storageNotificationFor returns the notification that publishes finalize
+// events for the given object, or nil if there is none. If multiple
+// notifications match, the one with the lowest ID is returned.
+func storageNotificationFor(notifications map[string]*storage.Notification, object string) *storage.Notification {
+	ids := make([]string, 0, len(notifications))
+	for id := range notifications {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		n := notifications[id]
+		if !strings.HasPrefix(object, n.ObjectNamePrefix) {
+			continue
+		}
+		if len(n.EventTypes) > 0 && !slices.Contains(n.EventTypes, storage.ObjectFinalizeEvent) {
+			continue
+		}
+		return n
+	}
+	return nil
+}
+
+// watchTopic receives notifications from a temporary subscription on the given
+// topic and calls check for each message that matches. If the topic is empty
+// or a subscription cannot be created, it polls instead.
+func (c *Client) watchTopic(ctx context.Context, topic, filter string, match func(map[string]string) bool, check func(context.Context)) error {
+	if topic != "" {
+		err := c.watchPubSub(ctx, topic, filter, func(ctx context.Context, attrs map[string]string) {
+			if match(attrs) {
+				check(ctx)
+			}
+		})
+		if !errors.Is(err, errWatchPubSubUnavailable) {
+			return err
+		}
+		logging.FromContext(ctx).WarnContext(ctx, "falling back to polling", "topic", topic, "error", err)
+	}
+	return watchPoll(ctx, check)
+}
+
+// watchChecker returns a function that compares the latest version to the last
+// seen version and, if it changed, reads the secret at that version and calls
+// fn with it. The returned function is safe for concurrent use and serializes
+// calls to fn.
+func watchChecker[V comparable](
+	current V,
+	latest func(context.Context) (V, error),
+	read func(context.Context, V) (*Secret, error),
+	fn func(*Secret),
+) func(context.Context) {
+	var mu sync.Mutex
+
+	return func(ctx context.Context) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		logger := logging.FromContext(ctx)
+
+		version, err := latest(ctx)
+		if err != nil {
+			logger.WarnContext(ctx, "failed to check for new version", "error", err)
+			return
+		}
+		if version == current {
+			return
+		}
+
+		secret, err := read(ctx, version)
+		if err != nil {
+			logger.WarnContext(ctx, "failed to read new version", "version", version, "error", err)
+			return
+		}
+
+		logger.DebugContext(ctx, "found new version", "version", version)
+		current = version
+		fn(secret)
+	}
+}
+
+// watchPoll calls check at the poll interval until the context is done.
+func watchPoll(ctx context.Context, check func(context.Context)) error {
+	interval := watchPollIntervalFromContext(ctx)
+
+	logger := logging.FromContext(ctx)
+	logger.DebugContext(ctx, "polling for new versions", "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			check(ctx)
+		}
+	}
+}
+
+// watchPubSub creates a temporary subscription on the given topic with the
+// given filter and calls handle with the attributes of each message until the
+// context is done. The subscription is deleted before returning. If the
+// subscription cannot be created, the error wraps errWatchPubSubUnavailable.
+func (c *Client) watchPubSub(ctx context.Context, topic, filter string, handle func(context.Context, map[string]string)) error {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != "topics" {
+		return fmt.Errorf("%w: invalid topic %q", errWatchPubSubUnavailable, topic)
+	}
+	project, topicID := parts[1], parts[3]
+
+	logger := logging.FromContext(ctx).With(
+		"topic", topic,
+	)
+
+	pubsubClient, err := pubsub.NewClient(ctx, project, serviceOptions(c.opts, servicePubSub)...)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create pubsub client: %w", errWatchPubSubUnavailable, err)
+	}
+	defer pubsubClient.Close()
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("failed to generate subscription name: %w", err)
+	}
+	id := "berglas-watch-" + hex.EncodeToString(b)
+
+	logger.DebugContext(ctx, "creating subscription", "subscription", id)
+
+	sub, err := pubsubClient.CreateSubscription(ctx, id, pubsub.SubscriptionConfig{
+		Topic:            pubsubClient.Topic(topicID),
+		Filter:           filter,
+		ExpirationPolicy: watchSubscriptionExpiration,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: failed to create subscription: %w", errWatchPubSubUnavailable, err)
+	}
+	defer func() {
+		// The watch context is likely cancelled at this point.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+
+		logger.DebugContext(ctx, "deleting subscription", "subscription", id)
+		if err := sub.Delete(ctx); err != nil {
+			logger.WarnContext(ctx, "failed to delete subscription", "subscription", id, "error", err)
+		}
+	}()
+
+	sub.ReceiveSettings.NumGoroutines = 1
+	sub.ReceiveSettings.MaxOutstandingMessages = 1
+
+	if err := sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		m.Ack()
+		handle(ctx, m.Attributes)
+	}); err != nil {
+		return fmt.Errorf("failed to receive notifications: %w", err)
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,305 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+)
+
+func TestWatchChecker(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		versions []string
+		failRead map[string]bool
+		exp      []string
+	}{
+		{
+			name:     "unchanged",
+			versions: []string{"1", "1", "1"},
+			exp:      nil,
+		},
+		{
+			name:     "changed",
+			versions: []string{"1", "2", "2", "3"},
+			exp:      []string{"2", "3"},
+		},
+		{
+			name:     "read_failure_retries",
+			versions: []string{"2", "2", "3"},
+			failRead: map[string]bool{"2": true},
+			exp:      []string{"2", "3"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := logging.WithLogger(context.Background(), logging.TestLogger(t))
+
+			var i int
+			latest := func(context.Context) (string, error) {
+				v := tc.versions[i]
+				i++
+				return v, nil
+			}
+			read := func(_ context.Context, v string) (*Secret, error) {
+				if tc.failRead[v] {
+					delete(tc.failRead, v)
+					return nil, fmt.Errorf("read failed")
+				}
+				return &Secret{Version: v}, nil
+			}
+
+			var act []string
+			check := watchChecker("1", latest, read, func(s *Secret) {
+				act = append(act, s.Version)
+			})
+			for range tc.versions {
+				check(ctx)
+			}
+
+			if exp := tc.exp; !reflect.DeepEqual(act, exp) {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
+
+func TestStorageNotificationFor(t *testing.T) {
+	t.Parallel()
+
+	notifications := map[string]*storage.Notification{
+		"2": {
+			ID:               "2",
+			TopicID:          "all",
+			ObjectNamePrefix: "",
+		},
+		"1": {
+			ID:               "1",
+			TopicID:          "deletes",
+			ObjectNamePrefix: "app/",
+			EventTypes:       []string{storage.ObjectDeleteEvent},
+		},
+		"0": {
+			ID:               "0",
+			TopicID:          "app",
+			ObjectNamePrefix: "app/",
+			EventTypes:       []string{storage.ObjectFinalizeEvent, storage.ObjectDeleteEvent},
+		},
+	}
+
+	cases := []struct {
+		name          string
+		notifications map[string]*storage.Notification
+		object        string
+		exp           string
+	}{
+		{
+			name:          "none",
+			notifications: nil,
+			object:        "app/key",
+			exp:           "",
+		},
+		{
+			name:          "prefix_and_event",
+			notifications: notifications,
+			object:        "app/key",
+			exp:           "app",
+		},
+		{
+			name:          "other_prefix",
+			notifications: notifications,
+			object:        "other/key",
+			exp:           "all",
+		},
+		{
+			name: "event_mismatch",
+			notifications: map[string]*storage.Notification{
+				"1": notifications["1"],
+			},
+			object: "app/key",
+			exp:    "",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var act string
+			if n := storageNotificationFor(tc.notifications, tc.object); n != nil {
+				act = n.TopicID
+			}
+			if exp := tc.exp; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
+
+func TestClient_Watch_secretManager(t *testing.T) {
+	testAcc(t)
+
+	t.Run("poll", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name := testProject(t), testName(t)
+
+		if _, err := client.Create(ctx, &SecretManagerCreateRequest{
+			Project:   project,
+			Name:      name,
+			Plaintext: []byte("my secret plaintext"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer testSecretManagerCleanup(t, project, name)
+
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		ctx = WithWatchPollInterval(ctx, time.Second)
+
+		secrets := make(chan *Secret, 1)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- client.Watch(ctx, fmt.Sprintf("sm://%s/%s", project, name), func(s *Secret) {
+				secrets <- s
+			})
+		}()
+
+		// Give the watch time to record the current version.
+		time.Sleep(2 * time.Second)
+
+		plaintext := []byte("my new secret plaintext")
+		if _, err := client.Update(ctx, &SecretManagerUpdateRequest{
+			Project:   project,
+			Name:      name,
+			Plaintext: plaintext,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case s := <-secrets:
+			if act, exp := s.Version, "2"; act != exp {
+				t.Errorf("expected version %q to be %q", act, exp)
+			}
+			if act, exp := s.Plaintext, plaintext; !bytes.Equal(act, exp) {
+				t.Errorf("expected plaintext %q to be %q", act, exp)
+			}
+		case err := <-errCh:
+			t.Fatalf("watch ended early: %s", err)
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for new version")
+		}
+	})
+
+	t.Run("pinned_version", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		project, name := testProject(t), testName(t)
+
+		if err := client.Watch(ctx, fmt.Sprintf("sm://%s/%s#1", project, name), func(*Secret) {}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+}
+
+func TestClient_Watch_storage(t *testing.T) {
+	testAcc(t)
+
+	t.Run("poll", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		bucket, object, key := testBucket(t), testName(t), testKey(t)
+
+		if _, err := client.Create(ctx, &StorageCreateRequest{
+			Bucket:    bucket,
+			Object:    object,
+			Key:       key,
+			Plaintext: []byte("my secret plaintext"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		defer testStorageCleanup(t, bucket, object)
+
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		ctx = WithWatchPollInterval(ctx, time.Second)
+
+		secrets := make(chan *Secret, 1)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- client.Watch(ctx, fmt.Sprintf("berglas://%s/%s", bucket, object), func(s *Secret) {
+				secrets <- s
+			})
+		}()
+
+		// Give the watch time to record the current generation.
+		time.Sleep(2 * time.Second)
+
+		plaintext := []byte("my new secret plaintext")
+		updated, err := client.Update(ctx, &StorageUpdateRequest{
+			Bucket:    bucket,
+			Object:    object,
+			Plaintext: plaintext,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case s := <-secrets:
+			if act, exp := s.Generation, updated.Generation; act != exp {
+				t.Errorf("expected generation %d to be %d", act, exp)
+			}
+			if act, exp := s.Plaintext, plaintext; !bytes.Equal(act, exp) {
+				t.Errorf("expected plaintext %q to be %q", act, exp)
+			}
+		case err := <-errCh:
+			t.Fatalf("watch ended early: %s", err)
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for new generation")
+		}
+	})
+
+	t.Run("pinned_generation", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, client := testClient(t)
+		bucket, object := testBucket(t), testName(t)
+
+		if err := client.Watch(ctx, fmt.Sprintf("berglas://%s/%s#1", bucket, object), func(*Secret) {}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+}
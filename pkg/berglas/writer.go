@@ -16,19 +16,56 @@ package berglas
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
-	"cloud.google.com/go/kms/apiv1/kmspb"
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas/logging"
+	"github.com/sethvargo/go-retry"
 	"google.golang.org/api/googleapi"
 )
 
+const (
+	// StorageWriteMaxRetries is the number of times a Cloud Storage write that
+	// was rate limited is retried before failing with ErrRateLimited.
+	StorageWriteMaxRetries = 5
+
+	// StorageWriteRetryBase is the delay before the first retry of a rate
+	// limited Cloud Storage write. Later retries back off exponentially.
+	StorageWriteRetryBase = time.Second
+)
+
+// writeOptions are optional attributes recorded on a secret object when it is
+// written.
+type writeOptions struct {
+	// protectionLevel is the protection level of the KMS key, if known.
+	protectionLevel string
+
+	// expiresAt is the time after which the secret may be pruned. The zero
+	// value means the secret never expires.
+	expiresAt time.Time
+
+	// policy restricts how Resolve may place the secret.
+	policy Policy
+
+	// contentType is the media type of the plaintext, if any.
+	contentType string
+
+	// writeCounter is bound into the AAD to detect rollback. Zero means the
+	// secret is not stamped.
+	writeCounter int64
+
+	// boundAAD binds the KMS additional authenticated data to the bucket and
+	// client context in addition to the object.
+	boundAAD bool
+}
+
 // encryptAndWrite is a low-level function for encrypting and writing data.
 func (c *Client) encryptAndWrite(
-	ctx context.Context, bucket, object, key string, plaintext []byte,
+	ctx context.Context, bucket, object, key string, opts writeOptions, plaintext []byte,
 	generation, metageneration int64) (*Secret, error) {
 
 	logger := logging.FromContext(ctx).With(
@@ -45,29 +82,27 @@ func (c *Client) encryptAndWrite(
 	// Generate a unique DEK and encrypt the plaintext locally (useful for large
 	// pieces of data).
 	logger.DebugContext(ctx, "generating envelope")
-	dek, ciphertext, err := envelopeEncrypt(plaintext)
+	dek, ciphertext, err := envelopeEncrypt(c.envelopeAlgorithm, plaintext)
 	if err != nil {
 		return nil, fmt.Errorf("failed to perform envelope encryption: %w", err)
 	}
+	defer c.wipe(dek)
 
 	// Encrypt the plaintext using a KMS key
 	logger.DebugContext(ctx, "encrypting envelope")
-	kmsResp, err := c.kmsClient.Encrypt(ctx, &kmspb.EncryptRequest{
-		Name:                        key,
-		Plaintext:                   dek,
-		AdditionalAuthenticatedData: []byte(object),
-	})
+	encDEK, err := c.wrapDEK(ctx, key, dek, storageAAD(opts.boundAAD, bucket, object, c.aadContext, opts.writeCounter))
 	if err != nil {
 		return nil, fmt.Errorf("failed to encrypt secret: %w", err)
 	}
-	encDEK := kmsResp.Ciphertext
 
 	// Build the storage object contents. Contents will be of the format:
 	//
 	//    b64(kms_encrypted_dek):b64(dek_encrypted_plaintext)
-	blob := fmt.Sprintf("%s:%s",
-		base64.StdEncoding.EncodeToString(encDEK),
-		base64.StdEncoding.EncodeToString(ciphertext))
+	//
+	// or, if an envelope algorithm was configured:
+	//
+	//    v2:<algorithm>:b64(kms_encrypted_dek):b64(dek_encrypted_plaintext)
+	blob := envelopeEncode(c.envelopeAlgorithm, encDEK, ciphertext)
 
 	// If generation and metageneration are 0, then we should only create the
 	// object if it does not exist. Otherwise, we should only perform an update if
@@ -84,12 +119,66 @@ func (c *Client) encryptAndWrite(
 		}
 	}
 
+	// Cloud Storage allows about one write per second to the same object, so
+	// retry writes that were rate limited.
+	var attrs *storage.ObjectAttrs
+	attempt := 0
+	err = retry.Do(ctx, storageWriteBackoff(), func(ctx context.Context) error {
+		attempt++
+		a, err := c.storageWrite(ctx, bucket, object, key, opts, conds, blob)
+		if err != nil {
+			if isStorageRateLimited(err) {
+				logger.DebugContext(ctx, "write was rate limited", "attempt", attempt, "error", err)
+				return retry.RetryableError(err)
+			}
+			return err
+		}
+		attrs = a
+		return nil
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to write object", "error", err)
+
+		if isStorageRateLimited(err) {
+			return nil, storageError(fmt.Errorf("%w after %d attempts: %w", ErrRateLimited, attempt, err), bucket, object)
+		}
+
+		var terr *googleapi.Error
+		if errors.As(err, &terr) {
+			switch terr.Code {
+			case http.StatusNotFound:
+				return nil, fmt.Errorf("bucket does not exist")
+			case http.StatusPreconditionFailed:
+				if conds.DoesNotExist {
+					return nil, storageError(ErrSecretAlreadyExists, bucket, object)
+				}
+				return nil, storageError(ErrSecretModified, bucket, object)
+			}
+			return nil, fmt.Errorf("failed to write to bucket: %w", err)
+		}
+		return nil, err
+	}
+
+	return secretFromAttrs(bucket, attrs, plaintext), nil
+}
+
+// storageWrite writes the encoded secret to a Cloud Storage object with the
+// given conditions and returns the attributes of the new object.
+func (c *Client) storageWrite(
+	ctx context.Context, bucket, object, key string, opts writeOptions, conds storage.Conditions,
+	blob string) (*storage.ObjectAttrs, error) {
+
+	logger := logging.FromContext(ctx)
+
 	// Create the writer
-	iow := c.storageClient.
+	handle := c.storageClient.
 		Bucket(bucket).
 		Object(object).
-		If(conds).
-		NewWriter(ctx)
+		If(conds)
+	if c.storageKey != nil {
+		handle = handle.Key(c.storageKey)
+	}
+	iow := handle.NewWriter(ctx)
 
 	iow.ObjectAttrs.CacheControl = CacheControl
 	iow.ChunkSize = ChunkSize
@@ -99,6 +188,26 @@ func (c *Client) encryptAndWrite(
 	}
 	iow.Metadata[MetadataIDKey] = "1"
 	iow.Metadata[MetadataKMSKey] = kmsKeyTrimVersion(key)
+	if opts.boundAAD {
+		iow.Metadata[MetadataAADKey] = aadVersionBound
+	}
+	if opts.protectionLevel != "" {
+		iow.Metadata[MetadataKMSProtectionLevelKey] = opts.protectionLevel
+	}
+	if !opts.expiresAt.IsZero() {
+		// Custom-Time lets bucket lifecycle rules delete expired secrets too.
+		iow.Metadata[MetadataExpiresAtKey] = opts.expiresAt.UTC().Format(time.RFC3339)
+		iow.ObjectAttrs.CustomTime = opts.expiresAt
+	}
+	if opts.policy != PolicyNone {
+		iow.Metadata[MetadataPolicyKey] = string(opts.policy)
+	}
+	if opts.contentType != "" {
+		iow.Metadata[MetadataContentTypeKey] = opts.contentType
+	}
+	if opts.writeCounter > 0 {
+		iow.Metadata[MetadataWriteCounterKey] = strconv.FormatInt(opts.writeCounter, 10)
+	}
 
 	// Write
 	logger.DebugContext(ctx, "writing object to storage", "metadata", iow.Metadata)
@@ -109,22 +218,32 @@ func (c *Client) encryptAndWrite(
 	// Close and flush
 	logger.DebugContext(ctx, "finalizing writer")
 	if err := iow.Close(); err != nil {
-		logger.ErrorContext(ctx, "failed to initialize writer", "error", err)
-
-		if terr, ok := err.(*googleapi.Error); ok {
-			switch terr.Code {
-			case http.StatusNotFound:
-				return nil, fmt.Errorf("bucket does not exist")
-			case http.StatusPreconditionFailed:
-				if conds.DoesNotExist {
-					return nil, errSecretAlreadyExists
-				}
-				return nil, errSecretModified
-			}
+		var terr *googleapi.Error
+		if errors.As(err, &terr) {
+			return nil, terr
 		}
-
 		return nil, fmt.Errorf("failed to write to bucket: %w", err)
 	}
+	return iow.Attrs(), nil
+}
 
-	return secretFromAttrs(bucket, iow.Attrs(), plaintext), nil
+// storageWriteBackoff returns the backoff for retrying rate-limited writes to
+// Cloud Storage. Jitter keeps concurrent writers from retrying in lockstep.
+func storageWriteBackoff() retry.Backoff {
+	b := retry.NewExponential(StorageWriteRetryBase)
+	b = retry.WithCappedDuration(8*time.Second, b)
+	b = retry.WithJitterPercent(25, b)
+	return retry.WithMaxRetries(StorageWriteMaxRetries, b)
+}
+
+// isStorageRateLimited returns true if err is a Cloud Storage 429 or 503 error.
+// Writes are conditional on the object's generation, so retrying one that was
+// applied despite the error fails with a precondition error rather than
+// writing twice.
+func isStorageRateLimited(err error) bool {
+	var terr *googleapi.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+	return terr.Code == http.StatusTooManyRequests || terr.Code == http.StatusServiceUnavailable
 }
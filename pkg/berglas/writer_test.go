@@ -0,0 +1,78 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsStorageRateLimited(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		err  error
+		exp  bool
+	}{
+		{"too_many_requests", &googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{"unavailable", &googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{"wrapped", fmt.Errorf("failed: %w", &googleapi.Error{Code: http.StatusTooManyRequests}), true},
+		{"precondition_failed", &googleapi.Error{Code: http.StatusPreconditionFailed}, false},
+		{"internal", &googleapi.Error{Code: http.StatusInternalServerError}, false},
+		{"other", errors.New("oops"), false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if act, exp := isStorageRateLimited(tc.err), tc.exp; act != exp {
+				t.Errorf("expected %t to be %t", act, exp)
+			}
+		})
+	}
+}
+
+func TestStorageWriteBackoff(t *testing.T) {
+	t.Parallel()
+
+	b := storageWriteBackoff()
+
+	var retries int
+	for {
+		d, stop := b.Next()
+		if stop {
+			break
+		}
+		retries++
+
+		if d < StorageWriteRetryBase*3/4 || d > 10*time.Second {
+			t.Errorf("retry %d: expected %s to be between %s and %s",
+				retries, d, StorageWriteRetryBase*3/4, 10*time.Second)
+		}
+	}
+
+	if act, exp := retries, StorageWriteMaxRetries; act != exp {
+		t.Errorf("expected %d to be %d", act, exp)
+	}
+}
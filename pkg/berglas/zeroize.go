@@ -0,0 +1,84 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"google.golang.org/api/option"
+)
+
+// WithZeroize returns a client option that makes the client overwrite
+// intermediate buffers holding key material or plaintext with zeros once they
+// are no longer needed. Use AccessInto and ResolveInto to receive plaintext in
+// caller-owned buffers, so the caller can wipe them after use too.
+//
+// Go may still copy memory (for example when growing slices or converting to
+// strings), so this reduces but does not eliminate copies of secrets in memory.
+// In particular, the environment returned by ResolveEnv holds each value in a
+// string that is never wiped.
+func WithZeroize() option.ClientOption {
+	return &clientOption{apply: func(c *Client) {
+		c.zeroize = true
+	}}
+}
+
+// wipe overwrites the given buffers with zeros if the client was created with
+// WithZeroize.
+func (c *Client) wipe(bufs ...[]byte) {
+	if !c.zeroize {
+		return
+	}
+	for _, b := range bufs {
+		clear(b)
+	}
+}
+
+// AccessInto is like Access, but copies the plaintext into dst and returns the
+// number of bytes copied. If dst is too small, an error wrapping
+// io.ErrShortBuffer is returned and nothing is copied. With WithZeroize, the
+// client's copy of the plaintext is wiped before returning.
+func (c *Client) AccessInto(ctx context.Context, i accessRequest, dst []byte) (int, error) {
+	plaintext, err := c.Access(ctx, i)
+	if err != nil {
+		return 0, err
+	}
+	return c.copyInto(dst, plaintext)
+}
+
+// ResolveInto is like Resolve, but copies the result into dst and returns the
+// number of bytes copied. If dst is too small, an error wrapping
+// io.ErrShortBuffer is returned and nothing is copied. With WithZeroize, the
+// client's copy of the result is wiped before returning.
+func (c *Client) ResolveInto(ctx context.Context, s string, dst []byte) (int, error) {
+	plaintext, err := c.Resolve(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	return c.copyInto(dst, plaintext)
+}
+
+// copyInto copies plaintext into dst and wipes plaintext.
+func (c *Client) copyInto(dst, plaintext []byte) (int, error) {
+	defer c.wipe(plaintext)
+
+	if len(plaintext) > len(dst) {
+		return 0, fmt.Errorf("%w: secret is %d bytes, buffer is %d bytes",
+			io.ErrShortBuffer, len(plaintext), len(dst))
+	}
+	return copy(dst, plaintext), nil
+}
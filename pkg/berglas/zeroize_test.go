@@ -0,0 +1,89 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglas
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestClient_copyInto(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		zeroize   bool
+		plaintext []byte
+		dstSize   int
+		err       error
+		exp       []byte
+		wiped     bool
+	}{
+		{
+			name:      "copies",
+			plaintext: []byte("secret"),
+			dstSize:   10,
+			exp:       []byte("secret"),
+		},
+		{
+			name:      "zeroize",
+			zeroize:   true,
+			plaintext: []byte("secret"),
+			dstSize:   10,
+			exp:       []byte("secret"),
+			wiped:     true,
+		},
+		{
+			name:      "short_buffer",
+			zeroize:   true,
+			plaintext: []byte("secret"),
+			dstSize:   3,
+			err:       io.ErrShortBuffer,
+			exp:       []byte{},
+			wiped:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var client Client
+			if tc.zeroize {
+				WithZeroize().(*clientOption).apply(&client)
+			}
+
+			plaintext := bytes.Clone(tc.plaintext)
+			dst := make([]byte, tc.dstSize)
+
+			n, err := client.copyInto(dst, plaintext)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %q to be %q", err, tc.err)
+			}
+			if act, exp := dst[:n], tc.exp; !bytes.Equal(act, exp) {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+
+			wiped := bytes.Equal(plaintext, make([]byte, len(plaintext)))
+			if act, exp := wiped, tc.wiped; act != exp {
+				t.Errorf("expected wiped %t to be %t", act, exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,639 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package berglastest provides an in-memory fake of the berglas client for
+// unit tests that should not call Google Cloud.
+package berglastest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas"
+)
+
+var _ berglas.SecretClient = (*Client)(nil)
+
+// Client is an in-memory implementation of berglas.SecretClient. Secret
+// Manager, Cloud Storage, and S3 secrets are kept in separate namespaces and
+// never leave the process. The zero value is not usable; create one with New.
+//
+// Secret Manager versions are numbered from 1 per secret, and Cloud Storage
+// and S3 generations are numbered from 1 per client. Version constraints such
+// as "latest-1", transforms, destination filepaths, and directory references
+// are not supported and return an error.
+type Client struct {
+	lock    sync.Mutex
+	secrets map[string]*secret
+
+	// members are the members granted access to each secret, or to every
+	// secret in a Secret Manager project when the name is empty.
+	members map[string]map[string]struct{}
+
+	// generation is the last Cloud Storage or S3 generation handed out.
+	generation int64
+}
+
+// secret is a single secret and all of its versions, oldest first.
+type secret struct {
+	typ      berglas.ReferenceType
+	parent   string
+	name     string
+	key      string
+	versions []*version
+}
+
+// version is a single version or generation of a secret.
+type version struct {
+	id        int64
+	plaintext []byte
+	updatedAt time.Time
+}
+
+// New creates an empty fake client.
+func New() *Client {
+	return &Client{
+		secrets: make(map[string]*secret),
+		members: make(map[string]map[string]struct{}),
+	}
+}
+
+// Set stores plaintext as a new version of the secret at the given reference,
+// creating the secret if it does not exist. The reference is any berglas
+// reference accepted by berglas.ParseReference, such as "sm://p/n" or
+// "berglas://b/o".
+func (c *Client) Set(ref string, plaintext []byte) (*berglas.Secret, error) {
+	r, err := berglas.ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	if r.IsDirectory() {
+		return nil, fmt.Errorf("cannot set directory reference %s", ref)
+	}
+
+	parent, name := r.Bucket(), r.Object()
+	if r.Type() == berglas.ReferenceTypeSecretManager {
+		parent, name = r.Project(), r.Name()
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s := c.secret(r.Type(), parent, name)
+	if s == nil {
+		s = c.add(r.Type(), parent, name, "")
+	}
+	return c.appendVersion(s, plaintext), nil
+}
+
+// MustSet is like Set, but it fails the test if the secret cannot be stored.
+func (c *Client) MustSet(tb testing.TB, ref, plaintext string) *berglas.Secret {
+	tb.Helper()
+
+	s, err := c.Set(ref, []byte(plaintext))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+// Access returns the plaintext of the requested secret version.
+func (c *Client) Access(ctx context.Context, i berglas.AccessRequester) ([]byte, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	var s *berglas.Secret
+	var err error
+	switch t := i.(type) {
+	case *berglas.SecretManagerAccessRequest:
+		s, err = c.read(berglas.ReferenceTypeSecretManager, t.Project, t.Name, t.Version)
+	case *berglas.StorageAccessRequest:
+		s, err = c.read(berglas.ReferenceTypeStorage, t.Bucket, t.Object, generationString(t.Generation))
+	case *berglas.StorageS3AccessRequest:
+		s, err = c.read(berglas.ReferenceTypeS3, t.Bucket, t.Object, "")
+	default:
+		return nil, fmt.Errorf("unknown access type %T", t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Plaintext, nil
+}
+
+// Read returns the requested secret version and its metadata.
+func (c *Client) Read(ctx context.Context, i berglas.ReadRequester) (*berglas.Secret, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch t := i.(type) {
+	case *berglas.SecretManagerReadRequest:
+		return c.read(berglas.ReferenceTypeSecretManager, t.Project, t.Name, t.Version)
+	case *berglas.StorageReadRequest:
+		return c.read(berglas.ReferenceTypeStorage, t.Bucket, t.Object, generationString(t.Generation))
+	case *berglas.StorageS3ReadRequest:
+		return c.read(berglas.ReferenceTypeS3, t.Bucket, t.Object, "")
+	default:
+		return nil, fmt.Errorf("unknown read type %T", t)
+	}
+}
+
+// Create stores a new secret. It returns berglas.ErrSecretAlreadyExists if
+// the secret exists.
+func (c *Client) Create(ctx context.Context, i berglas.CreateRequester) (*berglas.Secret, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	var typ berglas.ReferenceType
+	var parent, name, key string
+	var plaintext []byte
+	switch t := i.(type) {
+	case *berglas.SecretManagerCreateRequest:
+		typ, parent, name, plaintext = berglas.ReferenceTypeSecretManager, t.Project, t.Name, t.Plaintext
+	case *berglas.StorageCreateRequest:
+		typ, parent, name, key, plaintext = berglas.ReferenceTypeStorage, t.Bucket, t.Object, t.Key, t.Plaintext
+	case *berglas.StorageS3CreateRequest:
+		typ, parent, name, key, plaintext = berglas.ReferenceTypeS3, t.Bucket, t.Object, t.Key, t.Plaintext
+	default:
+		return nil, fmt.Errorf("unknown create type %T", t)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.secret(typ, parent, name) != nil {
+		return nil, secretError(berglas.ErrSecretAlreadyExists, typ, parent, name)
+	}
+	return c.appendVersion(c.add(typ, parent, name, key), plaintext), nil
+}
+
+// Update stores a new version of an existing secret. It returns
+// berglas.ErrSecretDoesNotExist if the secret does not exist and the request
+// does not set CreateIfMissing, and berglas.ErrSecretModified if a Cloud
+// Storage request sets a Generation or Metageneration that is not current.
+func (c *Client) Update(ctx context.Context, i berglas.UpdateRequester) (*berglas.Secret, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	var typ berglas.ReferenceType
+	var parent, name, key string
+	var plaintext []byte
+	var createIfMissing bool
+	var generation, metageneration int64
+	switch t := i.(type) {
+	case *berglas.SecretManagerUpdateRequest:
+		typ, parent, name = berglas.ReferenceTypeSecretManager, t.Project, t.Name
+		plaintext, createIfMissing = t.Plaintext, t.CreateIfMissing
+	case *berglas.StorageUpdateRequest:
+		typ, parent, name, key = berglas.ReferenceTypeStorage, t.Bucket, t.Object, t.Key
+		plaintext, createIfMissing = t.Plaintext, t.CreateIfMissing
+		generation, metageneration = t.Generation, t.Metageneration
+	case *berglas.StorageS3UpdateRequest:
+		typ, parent, name, key = berglas.ReferenceTypeS3, t.Bucket, t.Object, t.Key
+		plaintext, createIfMissing = t.Plaintext, t.CreateIfMissing
+	default:
+		return nil, fmt.Errorf("unknown update type %T", t)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s := c.secret(typ, parent, name)
+	if s == nil {
+		if !createIfMissing {
+			return nil, secretError(berglas.ErrSecretDoesNotExist, typ, parent, name)
+		}
+		s = c.add(typ, parent, name, key)
+	} else {
+		latest := s.versions[len(s.versions)-1]
+		if (generation != 0 && generation != latest.id) || (metageneration != 0 && metageneration != 1) {
+			return nil, secretError(berglas.ErrSecretModified, typ, parent, name)
+		}
+		if key != "" {
+			s.key = key
+		}
+	}
+
+	// Like the real client, an update without a plaintext only changes the
+	// metadata of a Secret Manager secret and rewrites the existing plaintext
+	// of a Cloud Storage secret.
+	if plaintext == nil && len(s.versions) > 0 {
+		latest := s.versions[len(s.versions)-1]
+		if typ == berglas.ReferenceTypeSecretManager {
+			return s.toSecret(latest), nil
+		}
+		plaintext = latest.plaintext
+	}
+	return c.appendVersion(s, plaintext), nil
+}
+
+// Delete removes a secret and all of its versions. Deleting a secret that
+// does not exist is not an error.
+func (c *Client) Delete(ctx context.Context, i berglas.DeleteRequester) error {
+	if i == nil {
+		return fmt.Errorf("missing request")
+	}
+	if err := i.Validate(); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var k string
+	switch t := i.(type) {
+	case *berglas.SecretManagerDeleteRequest:
+		k = secretKey(berglas.ReferenceTypeSecretManager, t.Project, t.Name)
+	case *berglas.StorageDeleteRequest:
+		k = secretKey(berglas.ReferenceTypeStorage, t.Bucket, t.Object)
+	default:
+		return fmt.Errorf("unknown delete type %T", t)
+	}
+	delete(c.secrets, k)
+	delete(c.members, k)
+	return nil
+}
+
+// Grant records that the members may access the secret, or every secret in
+// the project for a request with berglas.SecretManagerScopeProject. It returns
+// berglas.ErrSecretDoesNotExist if the secret does not exist.
+func (c *Client) Grant(ctx context.Context, i berglas.GrantRequester) error {
+	if i == nil {
+		return fmt.Errorf("missing request")
+	}
+	if err := i.Validate(); err != nil {
+		return err
+	}
+
+	var typ berglas.ReferenceType
+	var parent, name string
+	var members []string
+	var dryRun bool
+	switch t := i.(type) {
+	case *berglas.SecretManagerGrantRequest:
+		typ, parent, name, members, dryRun = berglas.ReferenceTypeSecretManager, t.Project, t.Name, t.Members, t.DryRun
+	case *berglas.StorageGrantRequest:
+		typ, parent, name, members, dryRun = berglas.ReferenceTypeStorage, t.Bucket, t.Object, t.Members, t.DryRun
+	default:
+		return fmt.Errorf("unknown grant type %T", t)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if name != "" && c.secret(typ, parent, name) == nil {
+		return secretError(berglas.ErrSecretDoesNotExist, typ, parent, name)
+	}
+	if dryRun {
+		return nil
+	}
+
+	k := secretKey(typ, parent, name)
+	if c.members[k] == nil {
+		c.members[k] = make(map[string]struct{}, len(members))
+	}
+	for _, m := range members {
+		c.members[k][m] = struct{}{}
+	}
+	return nil
+}
+
+// Revoke removes the members' access to the secret, or to every secret in the
+// project for a request with berglas.SecretManagerScopeProject. It returns
+// berglas.ErrSecretDoesNotExist if the secret does not exist.
+func (c *Client) Revoke(ctx context.Context, i berglas.RevokeRequester) error {
+	if i == nil {
+		return fmt.Errorf("missing request")
+	}
+	if err := i.Validate(); err != nil {
+		return err
+	}
+
+	var typ berglas.ReferenceType
+	var parent, name string
+	var members []string
+	var dryRun bool
+	switch t := i.(type) {
+	case *berglas.SecretManagerRevokeRequest:
+		typ, parent, name, members, dryRun = berglas.ReferenceTypeSecretManager, t.Project, t.Name, t.Members, t.DryRun
+	case *berglas.StorageRevokeRequest:
+		typ, parent, name, members, dryRun = berglas.ReferenceTypeStorage, t.Bucket, t.Object, t.Members, t.DryRun
+	default:
+		return fmt.Errorf("unknown revoke type %T", t)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if name != "" && c.secret(typ, parent, name) == nil {
+		return secretError(berglas.ErrSecretDoesNotExist, typ, parent, name)
+	}
+	if dryRun {
+		return nil
+	}
+
+	k := secretKey(typ, parent, name)
+	for _, m := range members {
+		delete(c.members[k], m)
+	}
+	return nil
+}
+
+// GrantedMembers returns the sorted members granted access to the secret at
+// the given reference by Grant. Members granted access to the whole Secret
+// Manager project are not included.
+func (c *Client) GrantedMembers(ref string) ([]string, error) {
+	r, err := berglas.ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, name := r.Bucket(), r.Object()
+	if r.Type() == berglas.ReferenceTypeSecretManager {
+		parent, name = r.Project(), r.Name()
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	members := make([]string, 0, len(c.members[secretKey(r.Type(), parent, name)]))
+	for m := range c.members[secretKey(r.Type(), parent, name)] {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// List returns the secrets in the requested project or bucket, sorted by name.
+// Only the latest version of each secret is returned unless the request asks
+// for all versions or generations. Plaintexts are not populated.
+func (c *Client) List(ctx context.Context, i berglas.ListRequester) (*berglas.ListResponse, error) {
+	if i == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+
+	var typ berglas.ReferenceType
+	var parent, prefix string
+	var all bool
+	switch t := i.(type) {
+	case *berglas.SecretManagerListRequest:
+		typ, parent, prefix, all = berglas.ReferenceTypeSecretManager, t.Project, t.Prefix, t.Versions
+	case *berglas.StorageListRequest:
+		typ, parent, prefix, all = berglas.ReferenceTypeStorage, t.Bucket, t.Prefix, t.Generations
+	default:
+		return nil, fmt.Errorf("unknown list type %T", t)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var secrets []*berglas.Secret
+	for _, s := range c.secrets {
+		if s.typ != typ || s.parent != parent || !strings.HasPrefix(s.name, prefix) {
+			continue
+		}
+
+		versions := s.versions[len(s.versions)-1:]
+		if all {
+			versions = s.versions
+		}
+		for _, v := range versions {
+			secret := s.toSecret(v)
+			secret.Plaintext = nil
+			secrets = append(secrets, secret)
+		}
+	}
+
+	sort.Slice(secrets, func(i, j int) bool {
+		if secrets[i].Name != secrets[j].Name {
+			return secrets[i].Name < secrets[j].Name
+		}
+		return secrets[i].UpdatedAt.Before(secrets[j].UpdatedAt)
+	})
+	return &berglas.ListResponse{Secrets: secrets}, nil
+}
+
+// Resolve parses s as a berglas reference and returns the plaintext of the
+// secret it refers to. Fallback references separated by "|" are tried in
+// order, and default projects and buckets from the context are honored.
+func (c *Client) Resolve(ctx context.Context, s string) ([]byte, error) {
+	refs, err := berglas.ParseReferencesContext(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reference %s: %w", s, err)
+	}
+
+	var errs []error
+	for _, ref := range refs {
+		plaintext, err := c.resolveReference(ctx, ref)
+		if err == nil {
+			return plaintext, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return nil, fmt.Errorf("failed to resolve any reference in %s: %w", s, errors.Join(errs...))
+}
+
+// resolveReference accesses a single parsed reference.
+func (c *Client) resolveReference(ctx context.Context, ref *berglas.Reference) ([]byte, error) {
+	if ref.IsDirectory() || ref.Filepath() != "" ||
+		ref.Encoding() != "" || ref.JSONKey() != "" || ref.Trim() {
+		return nil, fmt.Errorf("berglastest: reference %s uses features not supported by the fake", ref)
+	}
+
+	var req berglas.AccessRequester
+	switch ref.Type() {
+	case berglas.ReferenceTypeSecretManager:
+		req = &berglas.SecretManagerAccessRequest{
+			Project: ref.Project(),
+			Name:    ref.Name(),
+			Version: ref.Version(),
+		}
+	case berglas.ReferenceTypeStorage:
+		req = &berglas.StorageAccessRequest{
+			Bucket:     ref.Bucket(),
+			Object:     ref.Object(),
+			Generation: ref.Generation(),
+		}
+	case berglas.ReferenceTypeS3:
+		req = &berglas.StorageS3AccessRequest{
+			Bucket: ref.Bucket(),
+			Object: ref.Object(),
+		}
+	}
+
+	plaintext, err := c.Access(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access secret %s: %w", ref, err)
+	}
+	return plaintext, nil
+}
+
+// Replace resolves the reference in the environment variable with the given
+// name and replaces its value with the plaintext.
+func (c *Client) Replace(ctx context.Context, key string) error {
+	plaintext, err := c.Resolve(ctx, os.Getenv(key))
+	if err != nil {
+		return err
+	}
+
+	if err := os.Setenv(key, string(plaintext)); err != nil {
+		return fmt.Errorf("failed to set %s: %w", key, err)
+	}
+	return nil
+}
+
+// read returns the version of a secret. An empty version or "latest" is the
+// newest version. The caller must not hold the lock.
+func (c *Client) read(typ berglas.ReferenceType, parent, name, v string) (*berglas.Secret, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s := c.secret(typ, parent, name)
+	if s == nil {
+		return nil, secretError(berglas.ErrSecretDoesNotExist, typ, parent, name)
+	}
+
+	if v == "" || v == "latest" {
+		return s.toSecret(s.versions[len(s.versions)-1]), nil
+	}
+
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("berglastest: version %q is not supported by the fake", v)
+	}
+	for _, ver := range s.versions {
+		if ver.id == id {
+			return s.toSecret(ver), nil
+		}
+	}
+	return nil, secretError(berglas.ErrSecretDoesNotExist, typ, parent, name)
+}
+
+// secret returns the stored secret, or nil if it does not exist. The caller
+// must hold the lock.
+func (c *Client) secret(typ berglas.ReferenceType, parent, name string) *secret {
+	return c.secrets[secretKey(typ, parent, name)]
+}
+
+// add stores a new secret with no versions. The caller must hold the lock.
+func (c *Client) add(typ berglas.ReferenceType, parent, name, key string) *secret {
+	s := &secret{
+		typ:    typ,
+		parent: parent,
+		name:   name,
+		key:    key,
+	}
+	c.secrets[secretKey(typ, parent, name)] = s
+	return s
+}
+
+// appendVersion adds a new version to the secret and returns it. The caller
+// must hold the lock.
+func (c *Client) appendVersion(s *secret, plaintext []byte) *berglas.Secret {
+	var id int64
+	if s.typ == berglas.ReferenceTypeSecretManager {
+		id = int64(len(s.versions)) + 1
+	} else {
+		c.generation++
+		id = c.generation
+	}
+
+	v := &version{
+		id:        id,
+		plaintext: bytes.Clone(plaintext),
+		updatedAt: time.Now().UTC(),
+	}
+	s.versions = append(s.versions, v)
+	return s.toSecret(v)
+}
+
+// toSecret returns the given version as a berglas.Secret. The plaintext is
+// copied so callers cannot modify the stored value.
+func (s *secret) toSecret(v *version) *berglas.Secret {
+	secret := &berglas.Secret{
+		Parent:    s.parent,
+		Name:      s.name,
+		Plaintext: bytes.Clone(v.plaintext),
+		UpdatedAt: v.updatedAt,
+		KMSKey:    s.key,
+		Size:      int64(len(v.plaintext)),
+		State:     "ENABLED",
+	}
+	if s.typ == berglas.ReferenceTypeSecretManager {
+		secret.Version = strconv.FormatInt(v.id, 10)
+	} else {
+		secret.Generation = v.id
+		secret.Metageneration = 1
+	}
+	return secret
+}
+
+// secretKey returns the map key of a secret.
+func secretKey(typ berglas.ReferenceType, parent, name string) string {
+	return fmt.Sprintf("%d/%s/%s", typ, parent, name)
+}
+
+// secretError wraps err with the resource name of a secret, like the real
+// client.
+func secretError(err error, typ berglas.ReferenceType, parent, name string) error {
+	var resource string
+	switch typ {
+	case berglas.ReferenceTypeSecretManager:
+		resource = fmt.Sprintf("projects/%s/secrets/%s", parent, name)
+	case berglas.ReferenceTypeS3:
+		resource = fmt.Sprintf("s3://%s/%s", parent, name)
+	default:
+		resource = fmt.Sprintf("gs://%s/%s", parent, name)
+	}
+	return &berglas.SecretError{Err: err, Resource: resource}
+}
+
+// generationString returns the generation as a version string, where zero is
+// the latest generation.
+func generationString(g int64) string {
+	if g == 0 {
+		return ""
+	}
+	return strconv.FormatInt(g, 10)
+}
@@ -0,0 +1,249 @@
+// Copyright 2019 The Berglas Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package berglastest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/berglas/v2/pkg/berglas"
+)
+
+func TestClient_SecretManager(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+
+	if _, err := c.Create(ctx, &berglas.SecretManagerCreateRequest{
+		Project:   "p",
+		Name:      "n",
+		Plaintext: []byte("v1"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Create(ctx, &berglas.SecretManagerCreateRequest{
+		Project:   "p",
+		Name:      "n",
+		Plaintext: []byte("v1"),
+	}); !errors.Is(err, berglas.ErrSecretAlreadyExists) {
+		t.Errorf("expected %v to be %v", err, berglas.ErrSecretAlreadyExists)
+	}
+
+	secret, err := c.Update(ctx, &berglas.SecretManagerUpdateRequest{
+		Project:   "p",
+		Name:      "n",
+		Plaintext: []byte("v2"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := secret.Version, "2"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	cases := []struct {
+		version string
+		exp     string
+	}{
+		{"", "v2"},
+		{"latest", "v2"},
+		{"1", "v1"},
+	}
+	for _, tc := range cases {
+		plaintext, err := c.Access(ctx, &berglas.SecretManagerAccessRequest{
+			Project: "p",
+			Name:    "n",
+			Version: tc.version,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act, exp := string(plaintext), tc.exp; act != exp {
+			t.Errorf("version %q: expected %q to be %q", tc.version, act, exp)
+		}
+	}
+
+	if err := c.Delete(ctx, &berglas.SecretManagerDeleteRequest{
+		Project: "p",
+		Name:    "n",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var serr *berglas.SecretError
+	if _, err := c.Read(ctx, &berglas.SecretManagerReadRequest{
+		Project: "p",
+		Name:    "n",
+	}); !errors.As(err, &serr) || !errors.Is(err, berglas.ErrSecretDoesNotExist) {
+		t.Errorf("expected %v to be %v", err, berglas.ErrSecretDoesNotExist)
+	} else if act, exp := serr.Resource, "projects/p/secrets/n"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}
+
+func TestClient_Storage(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+
+	if _, err := c.Update(ctx, &berglas.StorageUpdateRequest{
+		Bucket:    "b",
+		Object:    "o",
+		Plaintext: []byte("v1"),
+	}); !errors.Is(err, berglas.ErrSecretDoesNotExist) {
+		t.Errorf("expected %v to be %v", err, berglas.ErrSecretDoesNotExist)
+	}
+
+	first := c.MustSet(t, "berglas://b/o", "v1")
+	second, err := c.Update(ctx, &berglas.StorageUpdateRequest{
+		Bucket:     "b",
+		Object:     "o",
+		Generation: first.Generation,
+		Plaintext:  []byte("v2"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Update(ctx, &berglas.StorageUpdateRequest{
+		Bucket:     "b",
+		Object:     "o",
+		Generation: first.Generation,
+		Plaintext:  []byte("v3"),
+	}); !errors.Is(err, berglas.ErrSecretModified) {
+		t.Errorf("expected %v to be %v", err, berglas.ErrSecretModified)
+	}
+
+	secret, err := c.Read(ctx, &berglas.StorageReadRequest{
+		Bucket:     "b",
+		Object:     "o",
+		Generation: first.Generation,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := string(secret.Plaintext), "v1"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+
+	resp, err := c.List(ctx, &berglas.StorageListRequest{
+		Bucket:      "b",
+		Generations: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := len(resp.Secrets), 2; act != exp {
+		t.Fatalf("expected %d to be %d", act, exp)
+	}
+	if act, exp := resp.Secrets[1].Generation, second.Generation; act != exp {
+		t.Errorf("expected %d to be %d", act, exp)
+	}
+	if resp.Secrets[0].Plaintext != nil {
+		t.Errorf("expected plaintext to be nil")
+	}
+}
+
+func TestClient_Resolve(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+	c.MustSet(t, "sm://p/fallback", "value")
+
+	cases := []struct {
+		name string
+		ref  string
+		exp  string
+		err  bool
+	}{
+		{
+			name: "fallback",
+			ref:  "sm://p/missing|sm://p/fallback",
+			exp:  "value",
+		},
+		{
+			name: "missing",
+			ref:  "sm://p/missing",
+			err:  true,
+		},
+		{
+			name: "unsupported_transform",
+			ref:  "sm://p/fallback?encoding=base64",
+			err:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			plaintext, err := c.Resolve(ctx, tc.ref)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t, got %v", tc.err, err)
+			}
+			if act, exp := string(plaintext), tc.exp; act != exp {
+				t.Errorf("expected %q to be %q", act, exp)
+			}
+		})
+	}
+}
+
+func TestClient_GrantRevoke(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+
+	if err := c.Grant(ctx, &berglas.SecretManagerGrantRequest{
+		Project: "p",
+		Name:    "n",
+		Members: []string{"user:a@example.com"},
+	}); !errors.Is(err, berglas.ErrSecretDoesNotExist) {
+		t.Errorf("expected %v to be %v", err, berglas.ErrSecretDoesNotExist)
+	}
+
+	c.MustSet(t, "sm://p/n", "value")
+
+	if err := c.Grant(ctx, &berglas.SecretManagerGrantRequest{
+		Project: "p",
+		Name:    "n",
+		Members: []string{"user:b@example.com", "user:a@example.com"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Revoke(ctx, &berglas.SecretManagerRevokeRequest{
+		Project: "p",
+		Name:    "n",
+		Members: []string{"user:b@example.com"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := c.GrantedMembers("sm://p/n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := strings.Join(members, ","), "user:a@example.com"; act != exp {
+		t.Errorf("expected %q to be %q", act, exp)
+	}
+}